@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	applyPlanFile string
+	applyApprove  []string
+)
+
+var applyCmd = &cobra.Command{
+	Use:   "apply",
+	Short: "Carry out a plan saved by otter plan",
+	Long: `Apply exactly the file changes and run exactly the hook commands recorded by otter plan, in
+the same order, without fetching or rendering layers again.
+
+Before changing anything, apply checks that every file the plan touches is still as it was when
+the plan was made, and refuses to run a stale plan.
+
+Deleting files, overwriting files edited since a layer wrote them, and running hooks from layers
+not applied before need approval: pass them with --approve, or confirm them when prompted.
+Approvals are recorded in the audit log.`,
+	RunE: runApply,
+}
+
+func init() {
+	applyCmd.Flags().StringVar(&applyPlanFile, "plan", "", "Plan file written by otter plan")
+	applyCmd.MarkFlagRequired("plan")
+	applyCmd.Flags().StringSliceVar(&applyApprove, "approve", nil, "Approve changes without prompting: all, a kind (delete, overwrite, hook), or kind:target, e.g. delete:docs/*")
+}
+
+func runApply(cmd *cobra.Command, args []string) (err error) {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	if _, err := os.Stat(filepath.Join(currentDir, ".otter")); os.IsNotExist(err) {
+		return withExitCode(ExitConfig, fmt.Errorf(".otter directory not found. Please run 'otter init' first"))
+	}
+
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := projectConfig.Notify.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	plan, err := util.LoadPlan(applyPlanFile)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	fsys := util.NewOSFileSystem()
+	if stale := plan.Stale(fsys, currentDir); len(stale) > 0 {
+		return withExitCode(ExitConflict, fmt.Errorf("the project changed since the plan was made (%s); run otter plan again", strings.Join(stale, ", ")))
+	}
+
+	approvals, err := planApprovals(currentDir, plan)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	// Record the outcome in the audit log, whether it succeeds or not
+	audit := util.NewAuditEntry("apply", Version)
+	audit.Otterfile = plan.Otterfile
+	audit.Layers = plan.Layers
+	defer func() {
+		audit.Success = err == nil
+		if err != nil {
+			audit.Error = err.Error()
+		}
+		if auditErr := util.RecordAudit(currentDir, projectConfig.Audit, audit); auditErr != nil {
+			fmt.Printf("Warning: %v\n", auditErr)
+		}
+		if notifyErr := projectConfig.Notify.SendBuild(filepath.Base(currentDir), audit); notifyErr != nil {
+			fmt.Printf("Warning: %v\n", notifyErr)
+		}
+	}()
+
+	// Destructive changes and hooks from new sources only happen once approved
+	audit.Approvals = approvals
+	if err := approvePlan(audit.Approvals, applyApprove); err != nil {
+		return err
+	}
+
+	fmt.Printf("Applying plan %s made %s\n", applyPlanFile, plan.Created.Local().Format("2006-01-02 15:04"))
+	cmdExec := util.NewCommandExecutor(currentDir)
+	cmdExec.Env = plan.Env
+	cmdExec.Condition = file.EvaluateCondition
+	onError := func() {
+		if len(plan.OnError) > 0 {
+			cmdExec.ExecuteHook(plan.OnError, plan.OnErrorOptions, "error cleanup")
+			audit.Hooks = append(audit.Hooks, cmdExec.TakeAttempts()...)
+		}
+	}
+
+	for _, step := range plan.Steps {
+		if len(step.Files) > 0 {
+			changes, err := step.ApplyFiles(fsys, currentDir)
+			audit.FilesChanged = append(audit.FilesChanged, changes...)
+			for _, change := range changes {
+				fmt.Printf("  %s: %s\n", change.Action, change.Path)
+			}
+			if err != nil {
+				onError()
+				return err
+			}
+		}
+
+		if len(step.Commands) > 0 {
+			err := cmdExec.ExecuteHook(step.Commands, step.Options, step.Hook)
+			audit.Hooks = append(audit.Hooks, cmdExec.TakeAttempts()...)
+			if err != nil {
+				onError()
+				return withExitCode(ExitHook, fmt.Errorf("%s hook failed: %w", step.Hook, err))
+			}
+		}
+	}
+
+	// Every command the plan runs was approved or ran before
+	var commands []string
+	for _, step := range plan.Steps {
+		commands = append(commands, step.Commands...)
+	}
+	if err := recordHooks(fsys, currentDir, append(commands, plan.OnError...)); err != nil {
+		return err
+	}
+
+	// The plan recorded the manifest, so the remote state is updated once it is applied
+	backend, err := util.NewStateBackend(projectConfig.State, currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	manifestPath := filepath.Join(currentDir, ".otter", util.FileManifestName)
+	if _, statErr := os.Stat(manifestPath); backend != nil && statErr == nil {
+		if err := util.PushState(backend, fsys, manifestPath); err != nil {
+			return withExitCode(ExitFetch, err)
+		}
+	}
+
+	fmt.Printf("\n🎉 Plan applied successfully! Applied %d layer(s).\n", len(plan.Layers))
+	return nil
+}
+
+// approvePlan marks the approvals given with --approve and asks for the rest, when otter can prompt
+func approvePlan(approvals []util.PlanApproval, approve []string) error {
+	var pending []int
+	for i := range approvals {
+		for _, value := range approve {
+			if approvals[i].Matches(value) {
+				approvals[i].ApprovedBy = "flag"
+				break
+			}
+		}
+		if approvals[i].ApprovedBy == "" {
+			pending = append(pending, i)
+		}
+	}
+	if len(pending) == 0 {
+		return nil
+	}
+
+	fmt.Printf("The plan needs approval for:\n")
+	for _, i := range pending {
+		fmt.Printf("  %s (%s)\n", approvals[i], approvals[i].Reason)
+	}
+	if !util.StdinIsTerminal() {
+		return withExitCode(ExitPolicy, fmt.Errorf("%d change(s) need approval; pass them with --approve, e.g. --approve %s", len(pending), approvals[pending[0]]))
+	}
+	if !util.PromptForConfirmation("Approve these changes? [y/N]: ") {
+		return withExitCode(ExitPolicy, fmt.Errorf("plan not approved"))
+	}
+	for _, i := range pending {
+		approvals[i].ApprovedBy = "prompt"
+	}
+	fmt.Println()
+	return nil
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	bakeFiles   []string
+	bakeOutput  string
+	bakeWorkdir string
+)
+
+var bakeCmd = &cobra.Command{
+	Use:   "bake",
+	Short: "Render layers into a container build context",
+	Long: `Render all applicable layers into a build context directory and emit a Dockerfile
+snippet that copies it into an image, so container-based development environments can be
+produced from the same Otterfile.
+
+The context is written to <output>/context and the snippet to <output>/Dockerfile.otter.
+Hooks are not run; add any required setup as RUN instructions in your Dockerfile.`,
+	RunE: runBake,
+}
+
+func init() {
+	bakeCmd.Flags().StringArrayVarP(&bakeFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	bakeCmd.Flags().StringVarP(&bakeOutput, "output", "o", filepath.Join(".otter", "bake"), "Directory to write the build context and Dockerfile snippet to")
+	bakeCmd.Flags().StringVar(&bakeWorkdir, "workdir", "/workspace", "Directory inside the image the layers are copied to")
+}
+
+func runBake(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	outputDir := bakeOutput
+	if !filepath.IsAbs(outputDir) {
+		outputDir = filepath.Join(currentDir, outputDir)
+	}
+	contextDir := filepath.Join(outputDir, "context")
+
+	// Start from an empty context so files from removed layers don't linger
+	if err := os.RemoveAll(contextDir); err != nil {
+		return fmt.Errorf("failed to clean build context: %w", err)
+	}
+	if err := os.MkdirAll(contextDir, 0755); err != nil {
+		return fmt.Errorf("failed to create build context: %w", err)
+	}
+
+	err = executeBuild(buildOptions{
+		ProjectDir:     currentDir,
+		OtterfilePaths: bakeFiles,
+		OutputDir:      contextDir,
+		Force:          true,
+		Yes:            true,
+		SkipHooks:      true,
+		Operation:      "bake",
+		SkipToolCheck:  true,
+	})
+	if err != nil {
+		return err
+	}
+
+	contextRel, err := filepath.Rel(outputDir, contextDir)
+	if err != nil {
+		return fmt.Errorf("failed to get relative path: %w", err)
+	}
+
+	snippet := fmt.Sprintf(`# Generated by otter bake; build with %s as the context
+COPY %s/ %s/
+`, outputDir, filepath.ToSlash(contextRel), bakeWorkdir)
+
+	snippetPath := filepath.Join(outputDir, "Dockerfile.otter")
+	if err := os.WriteFile(snippetPath, []byte(snippet), 0644); err != nil {
+		return fmt.Errorf("failed to write Dockerfile snippet: %w", err)
+	}
+
+	fmt.Printf("\nBuild context: %s\n", contextDir)
+	fmt.Printf("Dockerfile snippet: %s\n\n%s", snippetPath, snippet)
+
+	return nil
+}
@@ -1,10 +1,13 @@
 package cmd
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/geoffjay/otter/file"
 	"github.com/geoffjay/otter/util"
@@ -13,8 +16,18 @@ import (
 )
 
 var (
-	buildFile  string
-	forceApply bool
+	buildFiles     []string
+	forceApply     bool
+	buildLocked    bool
+	buildVerifyKey string
+	buildYes       bool
+	buildTargetSSH string
+	buildContainer string
+	buildNoPrune   bool
+	buildPreserve  bool
+	buildResume    bool
+	buildLogOutput string
+	buildEngine    string
 )
 
 var buildCmd = &cobra.Command{
@@ -26,41 +39,214 @@ all defined layers to the current project.`,
 }
 
 func init() {
-	buildCmd.Flags().StringVarP(&buildFile, "file", "f", "", "Specify the Otterfile/Envfile to use (default: auto-detect)")
+	buildCmd.Flags().StringArrayVarP(&buildFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
 	buildCmd.Flags().BoolVarP(&forceApply, "force", "F", false, "Force apply layers without prompting for file overwrites")
+	buildCmd.Flags().BoolVar(&buildLocked, "locked", false, "Apply layers at the commits pinned in Otterfile.lock")
+	buildCmd.Flags().BoolVarP(&buildYes, "yes", "y", false, "Apply builds that exceed the configured size limits without confirmation")
+	buildCmd.Flags().StringVar(&buildTargetSSH, "target-ssh", "", "Experimental: apply layers to a remote directory ([user@]host:path or ssh://host/path)")
+	buildCmd.Flags().StringVar(&buildContainer, "target-container", "", "Apply layers into a running container (<name>[:/path], default path /) using docker cp")
+	buildCmd.Flags().BoolVar(&buildNoPrune, "no-prune", false, "Report files that layers no longer provide instead of deleting them")
+	buildCmd.Flags().BoolVar(&buildPreserve, "preserve-attrs", false, "Preserve extended attributes, and ownership when run as root, of files from local layers")
+	buildCmd.Flags().BoolVar(&buildResume, "resume", false, "Continue a failed build from its checkpoint without applying finished layers again")
+	buildCmd.Flags().StringVar(&buildLogOutput, "log-output", util.LogOutputBuffered, "How to show the output of layers copied concurrently: buffered (whole layers in order) or prefixed (lines as they come, prefixed with the layer)")
+	buildCmd.Flags().StringVar(&buildEngine, "engine", os.Getenv("OTTER_ENGINE"), "URL of an otter serve to run the build on instead of this process (default: $OTTER_ENGINE)")
+	buildCmd.Flags().StringVar(&buildVerifyKey, "verify-key", "", "minisign public key file used to verify Otterfile.lock.minisig (with --locked)")
+}
+
+// buildOptions holds the inputs for a single build of a project
+type buildOptions struct {
+	ProjectDir string // Project root the layers are applied into
+	// OtterfilePaths are the Otterfiles to stack, later ones overriding earlier ones; auto-detected when empty
+	OtterfilePaths []string
+	Force          bool   // Skip overwrite confirmation prompts
+	Locked         bool   // Check out the commits pinned in the lockfile instead of updating
+	VerifyKey      string // minisign public key file; overrides the configured key
+	Yes            bool   // Skip confirmation for layers exceeding the size limits
+	// FS receives the layer files; the real disk is used when nil
+	FS util.FileSystem
+	// TargetSSH applies every layer to this remote directory instead of the project
+	TargetSSH string
+	// TargetContainer applies every layer into this running container instead of the project
+	TargetContainer string
+	// OutputDir is where layer targets are resolved; defaults to ProjectDir
+	OutputDir string
+	// SkipHooks disables all global and layer hooks
+	SkipHooks bool
+	// Operation names the build in the audit log; defaults to "build"
+	Operation string
+	// SkipToolCheck skips checking the TOOLS requirements against the local machine
+	SkipToolCheck bool
+	// NoPrune reports files that layers no longer provide instead of deleting them
+	NoPrune bool
+	// PreserveAttributes copies extended attributes, and ownership when run as root, from local layers
+	PreserveAttributes bool
+	// Prompt asks on the terminal for PROMPT variables the Otterfile doesn't provide; otherwise
+	// their defaults are used
+	Prompt bool
+	// Unattended declines the confirmations Force and Yes don't skip instead of asking on the
+	// terminal, for builds run by otter serve
+	Unattended bool
+	// Checkpoint records the build's progress in .otter so a failed build can be resumed
+	Checkpoint bool
+	// Resume continues from the checkpoint of a failed build, skipping the layers and stages it finished
+	Resume bool
+	// Plan records the file changes and hooks of the build instead of making and running them
+	Plan *util.Plan
+	// LogOutput is how the output of layers copied concurrently is shown; buffered when empty
+	LogOutput string
+	// Metrics records the build's duration and outcome and its layer fetches; nil records nothing
+	Metrics *util.Metrics
+	// Tracer records spans around the phases of the build; nil records nothing
+	Tracer *util.Tracer
+	// TraceParent is the W3C trace context the build's spans join; a new trace when empty
+	TraceParent string
 }
 
 func runBuild(cmd *cobra.Command, args []string) error {
+	if buildTargetSSH != "" && buildContainer != "" {
+		return withExitCode(ExitConfig, fmt.Errorf("--target-ssh and --target-container cannot be combined"))
+	}
+	if buildEngine != "" && (buildTargetSSH != "" || buildContainer != "" || buildVerifyKey != "") {
+		return withExitCode(ExitConfig, fmt.Errorf("--target-ssh, --target-container, and --verify-key cannot be used with --engine"))
+	}
+
 	currentDir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
+	metrics, err := envMetrics()
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	defer metrics.Close()
+	tracer, err := envTracer()
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	err = newEngine(buildEngine, telemetry{metrics: metrics, tracer: tracer}).Build(engineRequest{
+		Project:            currentDir,
+		Files:              buildFiles,
+		Force:              forceApply,
+		Locked:             buildLocked,
+		VerifyKey:          buildVerifyKey,
+		Yes:                buildYes,
+		TargetSSH:          buildTargetSSH,
+		TargetContainer:    buildContainer,
+		NoPrune:            buildNoPrune,
+		PreserveAttributes: buildPreserve,
+		Checkpoint:         true,
+		Resume:             buildResume,
+		LogOutput:          buildLogOutput,
+		TraceParent:        os.Getenv("TRACEPARENT"),
+		Prompt:             true,
+	})
+	if err == nil && buildEngine != "" {
+		fmt.Printf("Build completed by the engine at %s\n", buildEngine)
+	}
+	return err
+}
+
+// executeBuild applies all applicable layers of the project's Otterfile
+func executeBuild(opts buildOptions) (err error) {
+	currentDir := opts.ProjectDir
+	outputDir := opts.OutputDir
+	if outputDir == "" {
+		outputDir = currentDir
+	}
+	operation := opts.Operation
+	if operation == "" {
+		operation = "build"
+	}
+	start := time.Now()
+	defer func() { recordBuild(opts.Metrics, operation, time.Since(start), err) }()
+
+	// Trace the phases of the build: parsing, fetching, copying, and hooks
+	span := opts.Tracer.Start("otter."+operation, opts.TraceParent, "otter.project", currentDir)
+	defer func() {
+		span.End(err)
+		if flushErr := opts.Tracer.Flush(); flushErr != nil {
+			fmt.Printf("Warning: %v\n", flushErr)
+		}
+	}()
+
 	// Check if .otter directory exists
 	otterDir := filepath.Join(currentDir, ".otter")
 	if _, err := os.Stat(otterDir); os.IsNotExist(err) {
-		return fmt.Errorf(".otter directory not found. Please run 'otter init' first")
+		return withExitCode(ExitConfig, fmt.Errorf(".otter directory not found. Please run 'otter init' first"))
 	}
 
 	cacheDir := filepath.Join(otterDir, "cache")
 
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := projectConfig.Notify.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := projectConfig.Fetch.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	cacheBackend, err := util.NewCacheBackend(projectConfig.Cache, currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	// Record the outcome of the build in the audit log, whether it succeeds or not
+	audit := util.NewAuditEntry(operation, Version)
+	warnings := util.NewWarnings(projectConfig.Warnings.Disable)
+	defer func() {
+		audit.Warnings = warnings.Reported
+		audit.Success = err == nil
+		if err != nil {
+			audit.Error = err.Error()
+		}
+		if auditErr := util.RecordAudit(currentDir, projectConfig.Audit, audit); auditErr != nil {
+			fmt.Printf("Warning: %v\n", auditErr)
+		}
+		if operation == "build" {
+			if notifyErr := projectConfig.Notify.SendBuild(filepath.Base(currentDir), audit); notifyErr != nil {
+				fmt.Printf("Warning: %v\n", notifyErr)
+			}
+		}
+	}()
+
 	// Find Otterfile if not specified
-	var otterfilePath string
-	if buildFile != "" {
-		otterfilePath = buildFile
-	} else {
-		otterfilePath, err = file.FindOtterfile()
+	otterfilePaths := opts.OtterfilePaths
+	if len(otterfilePaths) == 0 {
+		otterfilePath, err := file.FindOtterfile()
 		if err != nil {
-			return err
+			return withExitCode(ExitConfig, err)
 		}
+		otterfilePaths = []string{otterfilePath}
 	}
 
-	fmt.Printf("Using configuration file: %s\n", otterfilePath)
+	fmt.Printf("Using configuration file: %s\n", strings.Join(otterfilePaths, ", "))
+	audit.Otterfile = strings.Join(otterfilePaths, ",")
 
-	// Parse the Otterfile
-	config, err := file.ParseOtterfile(otterfilePath)
+	// Parse the Otterfile, stacking any additional files on top of the first
+	var prompter file.Prompter
+	if opts.Prompt && util.StdinIsTerminal() {
+		prompter = terminalPrompter()
+	}
+	parseSpan := span.Start("otter.parse")
+	config, err := file.ParseOtterfileStackInteractive(otterfilePaths, prompter)
+	parseSpan.End(err)
 	if err != nil {
-		return fmt.Errorf("failed to parse %s: %w", otterfilePath, err)
+		if len(otterfilePaths) == 1 {
+			return withExitCode(ExitConfig, fmt.Errorf("failed to parse %s: %w", otterfilePaths[0], err))
+		}
+		return withExitCode(ExitConfig, err)
+	}
+	warnings.Add(config.Warnings...)
+
+	// Fail fast when required toolchains are missing
+	if !opts.SkipToolCheck {
+		if err := checkRequiredTools(config.Tools); err != nil {
+			return withExitCode(ExitPolicy, err)
+		}
 	}
 
 	if len(config.Layers) == 0 {
@@ -71,7 +257,7 @@ func runBuild(cmd *cobra.Command, args []string) error {
 	// Filter applicable layers based on conditions
 	applicableLayers, err := config.FilterApplicableLayers()
 	if err != nil {
-		return fmt.Errorf("failed to filter applicable layers: %w", err)
+		return withExitCode(ExitConfig, fmt.Errorf("failed to filter applicable layers: %w", err))
 	}
 
 	if len(applicableLayers) == 0 {
@@ -85,33 +271,551 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		fmt.Printf("Found %d layer(s) to process:\n", len(applicableLayers))
 	}
 
+	// Check every TARGET against the project's policy before anything is fetched or written.
+	// Remote targets write outside the project, so the policy doesn't apply to them.
+	remoteBuild := opts.TargetSSH != "" || opts.TargetContainer != ""
+	for _, layer := range applicableLayers {
+		if remoteBuild || util.IsSSHTarget(layer.Target) || util.IsContainerTarget(layer.Target) {
+			continue
+		}
+		if err := projectConfig.Targets.Check(util.LayerRef{Name: layer.Name, Repository: layer.Repository}, layer.Target); err != nil {
+			return withExitCode(ExitPolicy, err)
+		}
+	}
+
+	lock, err := loadBuildLockfile(currentDir, projectConfig, opts)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
 	// Initialize git, file, and command operations
 	gitOps := util.NewGitOperations(cacheDir)
+	gitOps.Metrics = opts.Metrics
+	gitOps.Cache = cacheBackend
 	fileOps := util.NewFileOperations()
+	if opts.FS != nil {
+		fileOps = util.NewFileOperationsWithFS(opts.FS)
+	}
 	cmdExec := util.NewCommandExecutor(currentDir)
+	cmdExec.Env = config.Env
+	cmdExec.Condition = file.EvaluateCondition
+
+	// A plan is worked out against an overlay of the project, which stays untouched
+	var overlay *util.OverlayFileSystem
+	if opts.Plan != nil {
+		overlay = util.NewOverlayFileSystem(fileOps.FS)
+		fileOps = util.NewFileOperationsWithFS(overlay)
+		opts.Plan.Otterfile = audit.Otterfile
+		opts.Plan.OnError, opts.Plan.OnErrorOptions = config.OnError, config.OnErrorOptions
+		opts.Plan.Env = config.Env
+	}
+	confirm := util.PromptForConfirmation
+	if opts.Unattended {
+		confirm = declineConfirmation
+	}
+	fileOps.Confirm = confirm
+
+	// runHooks executes hook commands, recording each attempt in the audit entry, and warns when
+	// they are slow, unless they wait for input. When planning, the commands are added to the plan
+	// after the file changes made so far.
+	runHooks := func(commands []string, options []util.HookOptions, context string, layers, disabled []string) error {
+		if opts.Plan != nil {
+			if err := opts.Plan.AddFiles(overlay, outputDir); err != nil {
+				return err
+			}
+			opts.Plan.AddHook(context, layers, commands, options)
+			return nil
+		}
+
+		interactive := false
+		for _, option := range options {
+			interactive = interactive || option.Interactive
+		}
+
+		start := time.Now()
+		hookSpan := span.Start("otter.hook", "otter.hook.context", context)
+		err := cmdExec.ExecuteHook(commands, options, context)
+		hookSpan.End(err)
+		audit.Hooks = append(audit.Hooks, cmdExec.TakeAttempts()...)
+		if elapsed := time.Since(start); elapsed > util.SlowHookThreshold && !interactive {
+			warnings.Warn(util.WarnSlowHook, disabled, "%s hook took %s", context, elapsed.Round(time.Second))
+		}
+		return err
+	}
+
+	// onError runs the global error hooks before a failed build returns
+	onError := func() {
+		if !opts.SkipHooks && len(config.OnError) > 0 && opts.Plan == nil {
+			runHooks(config.OnError, config.OnErrorOptions, "error cleanup", nil, config.HookDisabledWarnings)
+		}
+	}
 
 	// Load ignore patterns
 	if err := fileOps.LoadIgnorePatterns(currentDir); err != nil {
-		return fmt.Errorf("failed to load ignore patterns: %w", err)
+		return withExitCode(ExitConfig, fmt.Errorf("failed to load ignore patterns: %w", err))
+	}
+	if err := fileOps.AddIgnorePresets(config.IgnorePresets); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if fileOps.IgnoreCase, err = util.ResolveIgnoreCase(config.IgnoreCase); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if fileOps.RequireAllowHidden, err = util.ResolveHiddenFiles(projectConfig.HiddenFiles); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if fileOps.SecretScan, err = util.ResolveSecretScan(projectConfig.SecretScan); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if fileOps.LogOutput, err = util.ResolveLogOutput(opts.LogOutput); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	// Nested .otterignore scopes follow the project layout wherever the layers are written
+	fileOps.IgnoreRoot = outputDir
+	if fileOps.EditorConfig, err = util.LoadEditorConfig(fileOps.FS, currentDir); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	fileOps.Project = util.DetectProject(currentDir)
+	fileOps.User = util.DetectUser(currentDir)
+	fileOps.Build = util.NewBuildInfo(Version)
+	fileOps.Env = util.TemplateEnv(projectConfig.TemplateEnv)
+	fileOps.Condition = file.EvaluateCondition
+	fileOps.TemplateLimits = projectConfig.TemplateLimits
+
+	// The checkpoint records the layers and stages finished so far, so a failed build can resume
+	// without fetching and copying everything again
+	checkpointPath := filepath.Join(otterDir, util.CheckpointFileName)
+	var checkpoint *util.Checkpoint
+	if opts.Checkpoint {
+		key, err := util.CheckpointKey(otterfilePaths, operation, outputDir, opts.TargetSSH, opts.TargetContainer, fmt.Sprint(opts.Locked, opts.SkipHooks))
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		checkpoint = util.NewCheckpoint(key)
+
+		var previous *util.Checkpoint
+		if opts.Resume {
+			if previous, err = util.LoadCheckpoint(fileOps.FS, checkpointPath); err != nil {
+				return withExitCode(ExitConfig, err)
+			}
+		}
+		switch {
+		case !opts.Resume:
+			if err := util.RemoveCheckpoint(fileOps.FS, checkpointPath); err != nil {
+				return err
+			}
+		case previous == nil:
+			fmt.Printf("No checkpoint to resume from; building from the start\n")
+		case previous.Key != key:
+			return withExitCode(ExitConfig, fmt.Errorf("the Otterfile or build options changed since the checkpoint was saved; run otter build without --resume"))
+		default:
+			checkpoint = previous
+			fmt.Printf("Resuming from checkpoint: %d layer(s) already applied\n", len(checkpoint.Layers))
+		}
+	}
+	defer func() {
+		if err != nil && checkpoint.Started() {
+			fmt.Printf("\nProgress was saved; run 'otter build --resume' to continue where the build stopped\n")
+		}
+	}()
+
+	// Package layers are merged per generated file and written once all layers are processed
+	manifests := make(map[string]*util.PackageManifest)
+	var manifestPaths []string
+
+	// saveCheckpoint records the stage completed along with what layers contributed so far
+	saveCheckpoint := func(stage string) error {
+		if checkpoint == nil {
+			return nil
+		}
+		checkpoint.Stage = stage
+		checkpoint.Gitignore = fileOps.GitignoreSections()
+		checkpoint.Packages = nil
+		for _, manifestPath := range manifestPaths {
+			checkpoint.Packages = append(checkpoint.Packages, util.CheckpointPackage{Path: manifestPath, Data: manifests[manifestPath].Data})
+		}
+		return checkpoint.Save(fileOps.FS, checkpointPath)
 	}
 
-	// Execute global before build hooks
-	if len(config.OnBeforeBuild) > 0 {
+	// A resumed build picks up what the applied layers contributed to the files written at the end
+	if checkpoint != nil && !checkpoint.Reached(util.StageApply) {
+		fileOps.RestoreGitignoreSections(checkpoint.Gitignore)
+		for _, pkg := range checkpoint.Packages {
+			manifests[pkg.Path] = &util.PackageManifest{Data: pkg.Data}
+			manifestPaths = append(manifestPaths, pkg.Path)
+		}
+	}
+
+	// Execute global before build hooks, which a resumed build already ran
+	if !opts.SkipHooks && len(config.OnBeforeBuild) > 0 && !checkpoint.Started() {
 		fmt.Printf("\nExecuting global before build hooks:\n")
-		if err := cmdExec.ExecuteCommands(config.OnBeforeBuild, "before build"); err != nil {
-			if len(config.OnError) > 0 {
-				cmdExec.ExecuteCommands(config.OnError, "error cleanup")
+		if err := runHooks(config.OnBeforeBuild, config.OnBeforeBuildOptions, "before build", nil, config.HookDisabledWarnings); err != nil {
+			onError()
+			return withExitCode(ExitHook, fmt.Errorf("before build hook failed: %w", err))
+		}
+	}
+
+	// Layer AFTER commands marked ONCE, run after all layers are applied
+	var deferred util.DeferredHooks
+
+	// Files written and ignored by the layer being applied, reported when it finishes
+	var layerChanges []util.FileChange
+	var layerIgnored []util.IgnoredFile
+
+	// recordFiles moves the given files written and ignored into the audit entry, relative to the output
+	// directory, and returns the recorded changes
+	recordFiles := func(changes []util.FileChange, ignored []util.IgnoredFile) []util.FileChange {
+		for i := range changes {
+			if relativePath, relErr := filepath.Rel(outputDir, changes[i].Path); relErr == nil {
+				changes[i].Path = relativePath
+			}
+		}
+		audit.FilesChanged = append(audit.FilesChanged, changes...)
+		layerChanges = append(layerChanges, changes...)
+		for _, file := range ignored {
+			if relativePath, relErr := filepath.Rel(outputDir, file.Path); relErr == nil {
+				file.Path = relativePath
+			}
+			audit.FilesIgnored = append(audit.FilesIgnored, file)
+			layerIgnored = append(layerIgnored, file)
+		}
+		return changes
+	}
+
+	// recordChanges records the files written and ignored so far
+	recordChanges := func() []util.FileChange {
+		return recordFiles(fileOps.TakeChanges(), fileOps.TakeIgnored())
+	}
+
+	// The manifest of layer-provided files finds files a layer stops providing. It only describes
+	// the project itself, so builds into another output directory don't use it.
+	trackFiles := outputDir == currentDir
+	fileManifestPath := filepath.Join(otterDir, util.FileManifestName)
+	var stateBackend util.StateBackend
+	if trackFiles {
+		if stateBackend, err = pullManifestState(currentDir, projectConfig, fileOps.FS); err != nil {
+			return err
+		}
+	}
+	fileManifest, err := util.LoadFileManifest(fileOps.FS, fileManifestPath)
+	if err != nil {
+		return err
+	}
+	var appliedLayers []util.ManifestLayer
+
+	// writtenBy remembers which layer wrote each file during this build, to warn when another layer replaces it
+	writtenBy := make(map[string]string)
+
+	// warnSecrets reports the files a layer wrote that look like they contain credentials
+	warnSecrets := func(layer file.Layer, changes []util.FileChange) {
+		for _, change := range changes {
+			if change.Secret != "" {
+				warnings.Warn(util.WarnSecret, layer.DisabledWarnings, "layer %s wrote %s, which looks like it contains %s", layer.Repository, change.Path, change.Secret)
+			}
+		}
+	}
+
+	// trackLayerFiles records the files a copied layer created or overwrote in the manifest
+	trackLayerFiles := func(layer file.Layer, commit string, changes []util.FileChange) error {
+		warnSecrets(layer, changes)
+		for _, change := range changes {
+			if change.Action != "create" && change.Action != "overwrite" {
+				continue
+			}
+			if previous, ok := writtenBy[change.Path]; ok && previous != layer.Repository {
+				warnings.Warn(util.WarnLayerOverride, layer.DisabledWarnings, "layer %s overwrote %s from layer %s", layer.Repository, change.Path, previous)
+			}
+			writtenBy[change.Path] = layer.Repository
+		}
+
+		if !trackFiles {
+			return nil
+		}
+		var written []string
+		for _, change := range changes {
+			if change.Action == "create" || change.Action == "overwrite" {
+				written = append(written, change.Path)
+			}
+		}
+		files, err := fileOps.ManifestFiles(outputDir, written)
+		if err != nil {
+			return err
+		}
+		// Files the layer wrote before and its STRATEGY left alone this time are still its files
+		if previous, ok := fileManifest.Find(layer.Repository, layer.Target); ok {
+			kept := make(map[string]bool)
+			for _, ignored := range layerIgnored {
+				if util.IsStrategySkip(ignored) {
+					kept[filepath.ToSlash(ignored.Path)] = true
+				}
+			}
+			for _, file := range previous.Files {
+				if kept[file.Path] {
+					files = append(files, file)
+				}
+			}
+		}
+		appliedLayers = append(appliedLayers, manifestLayer(layer, commit, files))
+		return nil
+	}
+
+	// finishLayer records a layer whose files have been applied and runs its after hooks
+	finishLayer := func(layer file.Layer, commit string, commitErr error) error {
+		// Show commit information
+		pinned := ""
+		if commitErr == nil {
+			if strings.HasPrefix(layer.Repository, util.BuiltinLayerPrefix) {
+				fmt.Printf("  Layer type: Built-in\n")
+			} else if commit == "local-dir" {
+				fmt.Printf("  Layer type: Local directory\n")
+			} else {
+				fmt.Printf("  Layer commit: %s\n", commit[:8])
+				lock.Set(layer.Repository, commit)
+				pinned = commit
+			}
+		}
+		// Summarize what the layer did as a tree, which is easier to review than the stream of copies
+		report := util.NewLayerReport(layerChanges, layerIgnored)
+		layerChanges, layerIgnored = nil, nil
+		auditLayer := util.AuditLayer{
+			Repository: layer.Repository,
+			Commit:     commit,
+			Target:     layer.Target,
+			Comment:    layer.Comment,
+		}
+		if report.Summary != (util.LayerSummary{}) {
+			lines := strings.Split(strings.TrimSuffix(report.String(), "\n"), "\n")
+			fmt.Printf("  Files: %s\n", lines[0])
+			for _, line := range lines[1:] {
+				fmt.Printf("    %s\n", line)
+			}
+			auditLayer.Files = &report.Summary
+		}
+		audit.Layers = append(audit.Layers, auditLayer)
+
+		// Execute after hooks for this layer, deferring those marked ONCE to the end of the build
+		after, afterOptions := deferred.Defer(layer.Repository, layer.After, layer.AfterOptions)
+		if !opts.SkipHooks && len(after) > 0 {
+			if err := runHooks(after, afterOptions, "after layer", []string{layer.Repository}, layer.DisabledWarnings); err != nil {
+				onError()
+				return withExitCode(ExitHook, fmt.Errorf("after hook failed for layer %s: %w", layer.Repository, err))
+			}
+		}
+
+		fmt.Printf("  ✓ Layer applied successfully\n")
+
+		if checkpoint == nil {
+			return nil
+		}
+		finished := util.CheckpointLayer{Repository: layer.Repository, Target: layer.Target, Commit: pinned}
+		if n := len(appliedLayers); n > 0 && appliedLayers[n-1].Repository == layer.Repository && appliedLayers[n-1].Target == layer.Target {
+			files := appliedLayers[n-1]
+			finished.Files = &files
+		}
+		checkpoint.Layers = append(checkpoint.Layers, finished)
+		return saveCheckpoint("")
+	}
+
+	// Without prompts, file layers without hooks are queued and copied together, concurrently where
+	// they write to separate files. Any other layer copies the queue first to keep the declared order.
+	parallelCopy := opts.Force && opts.TargetSSH == "" && opts.TargetContainer == ""
+	var queued []queuedCopy
+	copyQueued := func() error {
+		if len(queued) == 0 {
+			return nil
+		}
+		jobs := make([]util.CopyJob, len(queued))
+		for i, q := range queued {
+			jobs[i] = q.job
+		}
+		fmt.Printf("\nCopying %d queued layer(s)\n", len(jobs))
+		copySpan := span.Start("otter.copy", "otter.layers", strconv.Itoa(len(jobs)))
+		results, copyErr := fileOps.CopyLayers(jobs, currentDir)
+		copySpan.End(copyErr)
+
+		pending := queued
+		queued = nil
+		for i, q := range pending {
+			changes := recordFiles(results[i].Changes, results[i].Ignored)
+			if copyErr != nil {
+				continue
+			}
+			if err := trackLayerFiles(q.layer, q.commit, changes); err != nil {
+				return err
+			}
+			fmt.Printf("\nFinished layer: %s\n", q.layer.Repository)
+			if err := finishLayer(q.layer, q.commit, q.commitErr); err != nil {
+				return err
+			}
+		}
+		if copyErr != nil {
+			onError()
+			return copyFailure(copyErr)
+		}
+		return nil
+	}
+
+	// Deprecation notices are repeated after the build so they aren't lost in its output
+	var deprecations []string
+
+	// Layers check their compatibility against every layer the build applies
+	layerRefs := make([]util.LayerRef, 0, len(applicableLayers))
+	for _, layer := range applicableLayers {
+		layerRefs = append(layerRefs, util.LayerRef{Name: layer.Name, Repository: layer.Repository})
+	}
+
+	// A checkpoint only applies while the layers it finished are still the first layers applied
+	if checkpoint != nil {
+		for i, finished := range checkpoint.Layers {
+			if i >= len(applicableLayers) || applicableLayers[i].Repository != finished.Repository || applicableLayers[i].Target != finished.Target {
+				return withExitCode(ExitConfig, fmt.Errorf("the applicable layers changed since the checkpoint was saved; run otter build without --resume"))
+			}
+		}
+	}
+
+	// Remote layers are fetched together up front, within the fetch limits of their hosts. Layers
+	// with before hooks are fetched after their hooks run, since the hooks may set up access.
+	gitOps.Limiter = util.NewHostLimiter(projectConfig.Fetch)
+	gitOps.FailOnForcePush = projectConfig.Fetch.ForcePushed == util.ForcePushedFail
+	gitOps.DefaultBranch = projectConfig.Fetch.DefaultBranch
+	gitOps.Branches = config.LayerBranches()
+	skipFetch := make(map[string]bool)
+	for _, layer := range applicableLayers {
+		if !opts.SkipHooks && len(layer.Before) > 0 {
+			skipFetch[layer.Repository] = true
+		}
+	}
+	// Frozen layers stay at their pinned commit, read from the cache without fetching when it has it
+	prefetched := make(map[string]util.FetchResult)
+	for _, layer := range applicableLayers {
+		pinned, ok := lock.Find(layer.Repository)
+		if !layer.Frozen || !ok || !gitOps.IsRemoteLayer(layer.Repository) {
+			continue
+		}
+		if layerPath, err := gitOps.CachedLayerPath(layer.Repository); err == nil && layerPath != "" && gitOps.CheckoutCommit(layerPath, pinned.Commit) == nil {
+			prefetched[layer.Repository] = util.FetchResult{Path: layerPath}
+			skipFetch[layer.Repository] = true
+		}
+	}
+	var prefetch []string
+	for i, layer := range applicableLayers {
+		if _, ok := checkpoint.Finished(i); ok || skipFetch[layer.Repository] || !gitOps.IsRemoteLayer(layer.Repository) {
+			continue
+		}
+		skipFetch[layer.Repository] = true
+		prefetch = append(prefetch, layer.Repository)
+	}
+	if len(prefetch) > 1 {
+		fmt.Printf("\nFetching %d remote layer(s)\n", len(prefetch))
+		fetchSpan := span.Start("otter.fetch", "otter.layers", strconv.Itoa(len(prefetch)))
+		var fetchErrs []error
+		for i, result := range gitOps.FetchLayers(prefetch, fileOps.LogOutput) {
+			prefetched[prefetch[i]] = result
+			fetchErrs = append(fetchErrs, result.Err)
+		}
+		fetchSpan.End(errors.Join(fetchErrs...))
+	}
+
+	// The apply limits hold for everything the build writes rather than for each layer, so the
+	// stats of its layers add up; once the developer agrees to exceed them, nothing more is asked
+	var buildStats util.LayerStats
+	limitsConfirmed := opts.Yes
+	checkApplyLimits := func(stats util.LayerStats) error {
+		buildStats.Files += stats.Files
+		buildStats.Bytes += stats.Bytes
+		buildStats.Overwrites += stats.Overwrites
+		if limitsConfirmed {
+			return nil
+		}
+		if exceeded, reason := projectConfig.ApplyLimits.Exceeded(buildStats); exceeded {
+			fmt.Printf("\n  This build would write %d file(s) (%d overwriting existing files): %s\n", buildStats.Files, buildStats.Overwrites, reason)
+			if !confirm("  Do you want to proceed? [y/N]: ") {
+				onError()
+				return withExitCode(ExitPolicy, fmt.Errorf("build aborted: the build exceeds size limits (use --yes to skip this check)"))
 			}
-			return fmt.Errorf("before build hook failed: %w", err)
+			limitsConfirmed = true
+		}
+		return nil
+	}
+
+	// Guard against accidentally applying a huge build over the project by measuring the file
+	// layers before anything is copied. Layers fetched after their before hooks run and generator
+	// layers are measured once their files exist.
+	measured := make(map[int]bool)
+	if !opts.Yes {
+		var stats util.LayerStats
+		for i, layer := range applicableLayers {
+			if _, ok := checkpoint.Finished(i); ok || layer.Type != "" || (!opts.SkipHooks && len(layer.Before) > 0) {
+				continue
+			}
+			if remoteTarget, err := resolveRemoteTarget(layer.Target, opts); err != nil || remoteTarget != nil {
+				continue
+			}
+			fetched, ok := prefetched[layer.Repository]
+			if !ok {
+				fetchSpan := span.Start("otter.fetch", "otter.layer", layer.Repository)
+				fetched.Path, fetched.Err = gitOps.CloneOrUpdateLayer(layer.Repository)
+				fetchSpan.End(fetched.Err)
+				prefetched[layer.Repository] = fetched
+			}
+			if fetched.Err != nil {
+				// Reported when the layer is applied
+				continue
+			}
+
+			fileOps.AllowProtected = layer.Allow
+			fileOps.AllowHidden = layer.AllowHidden
+			fileOps.Only = layer.Only
+			fileOps.Map = layer.Map
+			fileOps.Strategy = layer.Strategy
+			layerStats, err := fileOps.MeasureLayer(fetched.Path, filepath.Join(outputDir, layer.Target))
+			if err != nil {
+				onError()
+				return fmt.Errorf("failed to measure layer %s: %w", layer.Repository, err)
+			}
+			stats.Files += layerStats.Files
+			stats.Bytes += layerStats.Bytes
+			stats.Overwrites += layerStats.Overwrites
+			measured[i] = true
+		}
+		if err := checkApplyLimits(stats); err != nil {
+			return err
 		}
 	}
 
 	// Process each applicable layer
 	for i, layer := range applicableLayers {
+		// Layers a resumed build already applied are restored from the checkpoint without fetching them
+		if finished, ok := checkpoint.Finished(i); ok {
+			fmt.Printf("\n[%d/%d] Already applied: %s\n", i+1, len(applicableLayers), layer.Repository)
+			if finished.Commit != "" {
+				lock.Set(layer.Repository, finished.Commit)
+			}
+			if finished.Files != nil {
+				appliedLayers = append(appliedLayers, *finished.Files)
+			}
+			deferred.Defer(layer.Repository, layer.After, layer.AfterOptions)
+			continue
+		}
+
+		copiesFiles := layer.Type == "" || layer.Type == file.LayerTypeGenerator
+		hasHooks := !opts.SkipHooks && (len(layer.Before) > 0 || len(layer.After) > 0)
+		queueable := parallelCopy && copiesFiles && !hasHooks
+		if !queueable {
+			if err := copyQueued(); err != nil {
+				return err
+			}
+		}
+
 		fmt.Printf("\n[%d/%d] Processing layer: %s\n", i+1, len(applicableLayers), layer.Repository)
+		if layer.Comment != "" {
+			fmt.Printf("  # %s\n", layer.Comment)
+		}
 		if layer.Condition != "" {
 			fmt.Printf("  Condition: %s\n", layer.Condition)
 		}
+		if layer.Frozen {
+			fmt.Printf("  Frozen at its pinned commit\n")
+		}
 		if len(layer.Template) > 0 {
 			fmt.Printf("  Template variables: ")
 			var templateVars []string
@@ -122,77 +826,587 @@ func runBuild(cmd *cobra.Command, args []string) error {
 		}
 
 		// Execute before hooks for this layer
-		if len(layer.Before) > 0 {
-			if err := cmdExec.ExecuteCommands(layer.Before, "before layer"); err != nil {
-				if len(config.OnError) > 0 {
-					cmdExec.ExecuteCommands(config.OnError, "error cleanup")
-				}
-				return fmt.Errorf("before hook failed for layer %s: %w", layer.Repository, err)
+		if !opts.SkipHooks && len(layer.Before) > 0 {
+			if err := runHooks(layer.Before, layer.BeforeOptions, "before layer", []string{layer.Repository}, layer.DisabledWarnings); err != nil {
+				onError()
+				return withExitCode(ExitHook, fmt.Errorf("before hook failed for layer %s: %w", layer.Repository, err))
 			}
 		}
 
-		// Clone or update the layer
-		layerPath, err := gitOps.CloneOrUpdateLayer(layer.Repository)
+		// Clone or update the layer, unless it was fetched up front
+		fetched, ok := prefetched[layer.Repository]
+		if !ok {
+			fetchSpan := span.Start("otter.fetch", "otter.layer", layer.Repository)
+			fetched.Path, fetched.Err = gitOps.CloneOrUpdateLayer(layer.Repository)
+			fetchSpan.End(fetched.Err)
+		}
+		layerPath, err := fetched.Path, fetched.Err
 		if err != nil {
-			if len(config.OnError) > 0 {
-				cmdExec.ExecuteCommands(config.OnError, "error cleanup")
+			onError()
+			return withExitCode(ExitFetch, fmt.Errorf("failed to process layer %s: %w", layer.Repository, err))
+		}
+
+		// Local layers can be read from a snapshot pinned by content hash instead of in place
+		localLayer := !gitOps.IsRemoteLayer(layer.Repository) && !strings.HasPrefix(layer.Repository, util.BuiltinLayerPrefix)
+		if projectConfig.Lock.SnapshotLocal && localLayer {
+			if layerPath, err = snapshotLocalLayer(gitOps, lock, layer.Repository, layerPath, opts.Locked); err != nil {
+				onError()
+				return err
+			}
+		}
+
+		commit, commitErr := gitOps.GetRepositoryCommit(layerPath)
+		// Frozen layers that aren't pinned yet are pinned at the commit fetched now
+		if (opts.Locked || layer.Frozen) && commitErr == nil && commit != "local-dir" {
+			locked, ok := lock.Find(layer.Repository)
+			if !ok && opts.Locked {
+				onError()
+				return withExitCode(ExitConfig, fmt.Errorf("layer %s is not pinned in %s; run a build without --locked to update it", layer.Repository, util.LockfileName))
+			}
+			if ok && locked.Commit != commit {
+				if err := gitOps.CheckoutCommit(layerPath, locked.Commit); err != nil {
+					onError()
+					return withExitCode(ExitFetch, fmt.Errorf("failed to check out locked commit for layer %s: %w", layer.Repository, err))
+				}
+				commit = locked.Commit
+			}
+		}
+
+		// Scan the fetched content before anything is copied into the project
+		if err := util.ScanLayer(projectConfig.Scan, layer.Repository, layerPath); err != nil {
+			var scanErr *util.ScanError
+			if errors.As(err, &scanErr) {
+				if reportPath, qErr := util.QuarantineLayer(otterDir, layerPath, scanErr); qErr == nil {
+					fmt.Printf("  Layer quarantined, see %s\n", reportPath)
+				} else {
+					fmt.Printf("  Warning: %v\n", qErr)
+				}
+			}
+			onError()
+			return withExitCode(ExitPolicy, err)
+		}
+
+		// Surface deprecation notices so projects migrate before the layer disappears
+		if metadata, err := util.LoadLayerMetadata(layerPath); err != nil {
+			fmt.Printf("  Warning: %v\n", err)
+		} else {
+			if metadata.Deprecated != nil {
+				message := fmt.Sprintf("layer %s is %s", layer.Repository, metadata.Deprecated)
+				if warnings.Warn(util.WarnDeprecatedLayer, layer.DisabledWarnings, "%s", message) {
+					deprecations = append(deprecations, message)
+				}
+			}
+			if compatibility := metadata.Compatibility; compatibility != nil {
+				problems := compatibility.Check(util.LayerRef{Name: layer.Name, Repository: layer.Repository}, layerRefs, opts.ProjectDir)
+				if len(problems) > 0 && !compatibility.Warn {
+					onError()
+					return withExitCode(ExitPolicy, fmt.Errorf("layer %s is incompatible: %s", layer.Repository, strings.Join(problems, "; ")))
+				}
+				for _, problem := range problems {
+					warnings.Warn(util.WarnIncompatible, layer.DisabledWarnings, "layer %s %s", layer.Repository, problem)
+				}
 			}
-			return fmt.Errorf("failed to process layer %s: %w", layer.Repository, err)
+		}
+
+		remoteTarget, err := resolveRemoteTarget(layer.Target, opts)
+		if err != nil {
+			onError()
+			return withExitCode(ExitConfig, err)
 		}
 
 		// Determine target directory
 		var targetPath string
 		if layer.Target == "." {
-			targetPath = currentDir
+			targetPath = outputDir
 		} else {
-			targetPath = filepath.Join(currentDir, layer.Target)
+			targetPath = filepath.Join(outputDir, layer.Target)
+		}
+
+		// Generator layers copy the output of their commands instead of the repository contents
+		sourcePath := layerPath
+		if layer.Type == file.LayerTypeGenerator {
+			generatedPath, err := util.RunGenerator(layer.Generate, layerPath)
+			if err != nil {
+				onError()
+				return withExitCode(ExitHook, fmt.Errorf("generator failed for layer %s: %w", layer.Repository, err))
+			}
+			defer os.RemoveAll(generatedPath)
+			sourcePath = generatedPath
 		}
+		fileOps.AllowProtected = layer.Allow
+		fileOps.AllowHidden = layer.AllowHidden
+		fileOps.Only = layer.Only
+		fileOps.Map = layer.Map
+		fileOps.Strategy = layer.Strategy
+		fileOps.PreserveAttributes = opts.PreserveAttributes && localLayer
+		fileOps.Layer = &util.LayerInfo{Repository: layer.Repository, Commit: commit, Target: layer.Target}
+
+		var copyErr error
+		switch {
+		case !copiesFiles && remoteTarget != nil:
+			onError()
+			return withExitCode(ExitConfig, fmt.Errorf("%s layer %s cannot be applied to a remote target", layer.Type, layer.Repository))
+		case layer.Type == file.LayerTypePatch:
+			fmt.Printf("  Patching files in: %s\n", targetPath)
+			_, copyErr = fileOps.ApplyPatchLayer(layerPath, targetPath, util.DefaultPatchFuzz)
+			recordChanges()
+		case !copiesFiles:
+			manifestPath := filepath.Join(targetPath, packageManifestFile(layer.Type))
+			manifest, ok := manifests[manifestPath]
+			if !ok {
+				manifest = util.NewPackageManifest()
+				manifests[manifestPath] = manifest
+				manifestPaths = append(manifestPaths, manifestPath)
+			}
+
+			fmt.Printf("  Package layer: merging into %s\n", manifestPath)
+			copyErr = manifest.MergeLayer(layerPath)
+		case remoteTarget != nil:
+			fmt.Printf("  Remote target: %s\n", remoteTarget)
+			uploaded := len(audit.FilesChanged)
+			copyErr = applyLayerRemotely(fileOps, sourcePath, currentDir, layer, remoteTarget, audit)
+			warnSecrets(layer, audit.FilesChanged[uploaded:])
+		default:
+			fmt.Printf("  Target directory: %s\n", targetPath)
+
+			// When only TEMPLATE values changed since the last build, re-render just the files using them
+			previous, recorded := fileManifest.Find(layer.Repository, layer.Target)
+			if trackFiles && recorded && layer.Type == "" && commitErr == nil && commit != "local-dir" && previous.Commit == commit {
+				// Earlier layers may write the same files, so they are copied first
+				if err := copyQueued(); err != nil {
+					return err
+				}
+				files, rendered, err := fileOps.RenderTemplateChanges(sourcePath, outputDir, previous, layer.Template, layer.Delims)
+				warnSecrets(layer, recordChanges())
+				if err != nil {
+					onError()
+					return fmt.Errorf("failed to re-render layer %s: %w", layer.Repository, err)
+				}
+				if rendered {
+					fmt.Printf("  Layer unchanged; re-rendered files using changed template values\n")
+					appliedLayers = append(appliedLayers, manifestLayer(layer, commit, files))
+					break
+				}
+			}
+
+			// Layers that weren't measured up front count toward the limits now
+			if !opts.Yes && !measured[i] {
+				stats, err := fileOps.MeasureLayer(sourcePath, targetPath)
+				if err != nil {
+					onError()
+					return fmt.Errorf("failed to measure layer %s: %w", layer.Repository, err)
+				}
+				if err := checkApplyLimits(stats); err != nil {
+					return err
+				}
+			}
+
+			if queueable {
+				fmt.Printf("  Queued for copying\n")
+				queued = append(queued, queuedCopy{
+					layer:     layer,
+					commit:    commit,
+					commitErr: commitErr,
+					job: util.CopyJob{
+						Name:               layerDisplayName(layer),
+						Source:             sourcePath,
+						Target:             targetPath,
+						Template:           layer.Template,
+						Delims:             layer.Delims,
+						Allow:              layer.Allow,
+						Hidden:             layer.AllowHidden,
+						Only:               layer.Only,
+						Map:                layer.Map,
+						Strategy:           layer.Strategy,
+						Layer:              fileOps.Layer,
+						PreserveAttributes: fileOps.PreserveAttributes,
+					},
+				})
+				continue
+			}
 
-		fmt.Printf("  Target directory: %s\n", targetPath)
+			// Copy files from layer to target
+			copySpan := span.Start("otter.copy", "otter.layer", layer.Repository)
+			copyErr = fileOps.CopyLayer(sourcePath, targetPath, currentDir, layer.Template, layer.Delims, opts.Force)
+			copySpan.End(copyErr)
+			changes := recordChanges()
 
-		// Copy files from layer to target
-		if err := fileOps.CopyLayer(layerPath, targetPath, currentDir, layer.Template, layer.Delims, forceApply); err != nil {
-			if len(config.OnError) > 0 {
-				cmdExec.ExecuteCommands(config.OnError, "error cleanup")
+			if copyErr == nil {
+				if err := trackLayerFiles(layer, commit, changes); err != nil {
+					return err
+				}
 			}
-			return fmt.Errorf("failed to copy layer files: %w", err)
+		}
+		if copyErr != nil {
+			onError()
+			return copyFailure(copyErr)
 		}
 
-		// Show commit information
-		commit, err := gitOps.GetRepositoryCommit(layerPath)
-		if err == nil {
-			if commit == "local-dir" {
-				fmt.Printf("  Layer type: Local directory\n")
-			} else {
-				fmt.Printf("  Layer commit: %s\n", commit[:8])
+		if err := finishLayer(layer, commit, commitErr); err != nil {
+			return err
+		}
+	}
+	if err := copyQueued(); err != nil {
+		return err
+	}
+
+	// Write what the layers contributed to shared files, unless a resumed build already did
+	if !checkpoint.Reached(util.StageApply) {
+		for _, manifestPath := range manifestPaths {
+			if err := writePackageManifest(fileOps.FS, manifestPath, manifests[manifestPath]); err != nil {
+				onError()
+				return err
 			}
+			fmt.Printf("\nGenerated %s\n", manifestPath)
+
+			change := util.FileChange{Path: manifestPath, Action: "generate"}
+			if relativePath, relErr := filepath.Rel(outputDir, manifestPath); relErr == nil {
+				change.Path = relativePath
+			}
+			audit.FilesChanged = append(audit.FilesChanged, change)
 		}
 
-		// Execute after hooks for this layer
-		if len(layer.After) > 0 {
-			if err := cmdExec.ExecuteCommands(layer.After, "after layer"); err != nil {
-				if len(config.OnError) > 0 {
-					cmdExec.ExecuteCommands(config.OnError, "error cleanup")
+		// Remove files that re-applied layers no longer provide, and those of layers that are gone
+		// from the Otterfile. Layers it still declares whose IF condition doesn't hold keep theirs.
+		if trackFiles {
+			applied := make(map[[2]string]bool)
+			for _, layer := range applicableLayers {
+				applied[[2]string{layer.Repository, layer.Target}] = true
+			}
+			for _, layer := range config.Layers {
+				if previous, ok := fileManifest.Find(layer.Repository, layer.Target); ok && !applied[[2]string{layer.Repository, layer.Target}] {
+					appliedLayers = append(appliedLayers, previous)
+				}
+			}
+			if err := fileOps.PruneLayers(outputDir, fileManifest, appliedLayers, !opts.NoPrune); err != nil {
+				onError()
+				return err
+			}
+			recordChanges()
+			if err := fileManifest.Save(fileOps.FS, fileManifestPath); err != nil {
+				return err
+			}
+			// A plan doesn't change the project, so the remote state waits for otter apply
+			if stateBackend != nil && opts.Plan == nil {
+				if err := util.PushState(stateBackend, fileOps.FS, fileManifestPath); err != nil {
+					return withExitCode(ExitFetch, err)
 				}
-				return fmt.Errorf("after hook failed for layer %s: %w", layer.Repository, err)
 			}
 		}
 
-		fmt.Printf("  ✓ Layer applied successfully\n")
+		// Assemble the .gitignore rules contributed by layers
+		if err := fileOps.ApplyGitignoreFragments(); err != nil {
+			onError()
+			return err
+		}
+		recordChanges()
+		if err := saveCheckpoint(util.StageApply); err != nil {
+			return err
+		}
 	}
 
-	// Execute global after build hooks
-	if len(config.OnAfterBuild) > 0 {
-		fmt.Printf("\nExecuting global after build hooks:\n")
-		if err := cmdExec.ExecuteCommands(config.OnAfterBuild, "after build"); err != nil {
-			if len(config.OnError) > 0 {
-				cmdExec.ExecuteCommands(config.OnError, "error cleanup")
+	// Run the hooks that follow the layers, unless a resumed build already did
+	if !checkpoint.Reached(util.StageHooks) {
+		// Execute the deferred layer hooks once each, in the order they were first declared
+		if !opts.SkipHooks && len(deferred.Commands) > 0 {
+			fmt.Printf("\nExecuting deferred layer hooks:\n")
+			if err := runHooks(deferred.Commands, deferred.Options, "deferred after layer", deferred.Layers, config.HookDisabledWarnings); err != nil {
+				onError()
+				return withExitCode(ExitHook, fmt.Errorf("deferred after hook failed: %w", err))
+			}
+		}
+
+		// Execute global after build hooks
+		if !opts.SkipHooks && len(config.OnAfterBuild) > 0 {
+			fmt.Printf("\nExecuting global after build hooks:\n")
+			if err := runHooks(config.OnAfterBuild, config.OnAfterBuildOptions, "after build", nil, config.HookDisabledWarnings); err != nil {
+				onError()
+				return withExitCode(ExitHook, fmt.Errorf("after build hook failed: %w", err))
 			}
-			return fmt.Errorf("after build hook failed: %w", err)
+		}
+		if err := saveCheckpoint(util.StageHooks); err != nil {
+			return err
+		}
+	}
+
+	// Pin the applied commits so later builds can reproduce them with --locked
+	if !opts.Locked && len(lock.Layers) > 0 {
+		if err := lock.SaveFS(fileOps.FS, filepath.Join(currentDir, util.LockfileName)); err != nil {
+			return err
+		}
+	}
+
+	if opts.Plan != nil {
+		opts.Plan.Layers = audit.Layers
+		return opts.Plan.AddFiles(overlay, outputDir)
+	}
+
+	if checkpoint != nil {
+		if err := util.RemoveCheckpoint(fileOps.FS, checkpointPath); err != nil {
+			return err
+		}
+	}
+	if !opts.SkipHooks {
+		var commands []string
+		for _, attempt := range audit.Hooks {
+			commands = append(commands, attempt.Command)
+		}
+		if err := recordHooks(fileOps.FS, currentDir, append(commands, config.OnError...)); err != nil {
+			return err
 		}
 	}
 
 	fmt.Printf("\n🎉 Build completed successfully! Applied %d layer(s).\n", len(config.Layers))
+	if len(deprecations) > 0 {
+		fmt.Printf("\n⚠ Deprecated layers; migrate before they are removed:\n")
+		for _, message := range deprecations {
+			fmt.Printf("  - %s\n", message)
+		}
+		fmt.Println()
+	}
+	if len(warnings.Reported) > 0 {
+		fmt.Printf("%d warning(s) reported; suppress them with %s comments or warnings.disable in .otter/%s\n", len(warnings.Reported), util.DisableDirective, util.ConfigFileName)
+	}
 
 	return nil
 }
+
+// snapshotLocalLayer returns the cached snapshot of a local layer and pins its content hash in the
+// lockfile. Locked builds use the pinned snapshot, taking it from the directory only when the
+// directory still has the pinned content.
+func snapshotLocalLayer(gitOps *util.GitOperations, lock *util.Lockfile, repository, layerPath string, locked bool) (string, error) {
+	if locked {
+		pinned, ok := lock.Find(repository)
+		if !ok || pinned.SHA256 == "" {
+			return "", withExitCode(ExitConfig, fmt.Errorf("local layer %s is not pinned in %s; run a build without --locked to snapshot it", repository, util.LockfileName))
+		}
+		if snapshotPath, ok := gitOps.LayerSnapshot(pinned.SHA256); ok {
+			fmt.Printf("  Using snapshot: %s\n", pinned.SHA256[:12])
+			return snapshotPath, nil
+		}
+	}
+
+	snapshotPath, hash, err := gitOps.SnapshotLayer(layerPath)
+	if err != nil {
+		return "", err
+	}
+	if locked {
+		if pinned, _ := lock.Find(repository); pinned.SHA256 != hash {
+			return "", withExitCode(ExitFetch, fmt.Errorf("local layer %s changed since it was pinned and its snapshot is no longer cached", repository))
+		}
+	} else {
+		lock.SetSnapshot(repository, hash)
+	}
+	fmt.Printf("  Snapshot: %s\n", hash[:12])
+	return snapshotPath, nil
+}
+
+// declineConfirmation answers no to a confirmation, for builds with no terminal to ask on
+func declineConfirmation(prompt string) bool {
+	fmt.Printf("%sno (unattended build)\n", prompt)
+	return false
+}
+
+// copyFailure classifies an error from copying layer files, separating declined overwrites
+func copyFailure(err error) error {
+	err = fmt.Errorf("failed to copy layer files: %w", err)
+	if errors.Is(err, util.ErrAborted) {
+		return withExitCode(ExitConflict, err)
+	}
+	if errors.Is(err, util.ErrUnsafeLayer) || errors.Is(err, util.ErrTemplateLimit) || errors.Is(err, util.ErrSecretFound) {
+		return withExitCode(ExitPolicy, err)
+	}
+	return err
+}
+
+// loadBuildLockfile loads the project lockfile. Locked builds require the lockfile to exist and,
+// when a public key is configured, to carry a valid minisign signature.
+func loadBuildLockfile(projectDir string, projectConfig *util.Config, opts buildOptions) (*util.Lockfile, error) {
+	lockPath := filepath.Join(projectDir, util.LockfileName)
+
+	if !opts.Locked {
+		if _, err := os.Stat(lockPath); os.IsNotExist(err) {
+			return util.NewLockfile(), nil
+		}
+		return util.LoadLockfile(lockPath)
+	}
+
+	publicKey := projectConfig.Lock.PublicKey
+	if opts.VerifyKey != "" {
+		keyData, err := os.ReadFile(opts.VerifyKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read verification key: %w", err)
+		}
+		publicKey = string(keyData)
+	}
+
+	if publicKey != "" {
+		if err := util.VerifyFileSignature(lockPath, publicKey); err != nil {
+			return nil, withExitCode(ExitPolicy, err)
+		}
+		fmt.Printf("Verified signature of %s\n", util.LockfileName)
+	}
+
+	return util.LoadLockfile(lockPath)
+}
+
+// pullManifestState replaces the project's manifest with the one in the configured state backend,
+// and returns the backend, or nil when the project keeps its state only in .otter
+func pullManifestState(projectDir string, projectConfig *util.Config, fsys util.FileSystem) (util.StateBackend, error) {
+	backend, err := util.NewStateBackend(projectConfig.State, projectDir)
+	if err != nil {
+		return nil, withExitCode(ExitConfig, err)
+	}
+	if backend == nil {
+		return nil, nil
+	}
+	if err := util.PullState(backend, fsys, filepath.Join(projectDir, ".otter", util.FileManifestName)); err != nil {
+		return nil, withExitCode(ExitFetch, err)
+	}
+	return backend, nil
+}
+
+// layerDisplayName returns the NAME of a layer, or its repository when it has none
+func layerDisplayName(layer file.Layer) string {
+	if layer.Name != "" {
+		return layer.Name
+	}
+	return layer.Repository
+}
+
+// packageManifestFile returns the file generated for a package layer type
+func packageManifestFile(layerType string) string {
+	if layerType == file.LayerTypeNix {
+		return "flake.nix"
+	}
+	return util.PackageFragmentName
+}
+
+// queuedCopy is a file layer waiting to be copied together with other layers
+type queuedCopy struct {
+	layer     file.Layer
+	commit    string
+	commitErr error
+	job       util.CopyJob
+}
+
+// manifestLayer records the files a layer wrote, with the commit and template values they came from
+func manifestLayer(layer file.Layer, commit string, files []util.ManifestFile) util.ManifestLayer {
+	recorded := util.ManifestLayer{Repository: layer.Repository, Target: layer.Target, Files: files}
+	if layer.Type == "" && commit != "local-dir" {
+		recorded.Commit = commit
+	}
+	if len(layer.Template) > 0 {
+		recorded.Template = layer.Template
+		recorded.Delims = layer.Delims[:]
+	}
+	return recorded
+}
+
+// writePackageManifest renders a merged package manifest in the format its file name calls for
+func writePackageManifest(fsys util.FileSystem, manifestPath string, manifest *util.PackageManifest) error {
+	var content []byte
+	if filepath.Base(manifestPath) == "flake.nix" {
+		content = manifest.FlakeNix()
+	} else {
+		var err error
+		if content, err = manifest.DevboxJSON(); err != nil {
+			return err
+		}
+	}
+
+	if err := fsys.MkdirAll(filepath.Dir(manifestPath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", manifestPath, err)
+	}
+	if err := fsys.WriteFile(manifestPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", manifestPath, err)
+	}
+
+	return nil
+}
+
+// resolveRemoteTarget returns the remote target for a layer, either from an ssh:// or docker://
+// TARGET or by joining the layer's relative TARGET onto the --target-ssh/--target-container directory
+func resolveRemoteTarget(layerTarget string, opts buildOptions) (util.RemoteTarget, error) {
+	if util.IsSSHTarget(layerTarget) {
+		return util.ParseSSHTarget(layerTarget)
+	}
+	if util.IsContainerTarget(layerTarget) {
+		return util.ParseContainerTarget(layerTarget)
+	}
+
+	var base util.RemoteTarget
+	switch {
+	case opts.TargetSSH != "":
+		target, err := util.ParseSSHTarget(opts.TargetSSH)
+		if err != nil {
+			return nil, err
+		}
+		base = target
+	case opts.TargetContainer != "":
+		target, err := util.ParseContainerTarget(opts.TargetContainer)
+		if err != nil {
+			return nil, err
+		}
+		base = target
+	default:
+		return nil, nil
+	}
+
+	return base.Join(layerTarget), nil
+}
+
+// applyLayerRemotely renders a layer into an in-memory staging area and uploads the result
+// to the remote target in a single transfer
+func applyLayerRemotely(fileOps *util.FileOperations, layerPath, projectDir string, layer file.Layer, target util.RemoteTarget, audit *util.AuditEntry) error {
+	const stagingRoot = "/staging"
+
+	staging := util.NewMemFileSystem()
+	if err := staging.MkdirAll(stagingRoot, 0755); err != nil {
+		return err
+	}
+
+	stagingOps := util.NewFileOperationsWithFS(staging)
+	stagingOps.IgnorePatterns = fileOps.IgnorePatterns
+	stagingOps.IgnoreSources = fileOps.IgnoreSources
+	stagingOps.IgnoreCase = fileOps.IgnoreCase
+	stagingOps.AllowProtected = layer.Allow
+	stagingOps.AllowHidden = layer.AllowHidden
+	stagingOps.Only = layer.Only
+	stagingOps.Map = layer.Map
+	stagingOps.RequireAllowHidden = fileOps.RequireAllowHidden
+	stagingOps.Project = fileOps.Project
+	stagingOps.User = fileOps.User
+	stagingOps.Build = fileOps.Build
+	stagingOps.Layer = fileOps.Layer
+	stagingOps.Env = fileOps.Env
+	stagingOps.TemplateLimits = fileOps.TemplateLimits
+	stagingOps.Condition = fileOps.Condition
+	stagingOps.SecretScan = fileOps.SecretScan
+
+	// The remote side can't be inspected for conflicts, so files are always overwritten
+	if err := stagingOps.CopyLayer(layerPath, stagingRoot, projectDir, layer.Template, layer.Delims, true); err != nil {
+		return err
+	}
+
+	for _, change := range stagingOps.TakeChanges() {
+		relativePath, err := filepath.Rel(stagingRoot, change.Path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		audit.FilesChanged = append(audit.FilesChanged, util.FileChange{
+			Path:   target.Join(relativePath).String(),
+			Action: "upload",
+			Secret: change.Secret,
+		})
+	}
+
+	for _, ignored := range stagingOps.TakeIgnored() {
+		if relativePath, err := filepath.Rel(stagingRoot, ignored.Path); err == nil {
+			ignored.Path = target.Join(relativePath).String()
+		}
+		audit.FilesIgnored = append(audit.FilesIgnored, ignored)
+	}
+
+	return target.Upload(staging, stagingRoot)
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var cacheKeyFiles []string
+
+var cacheKeyCmd = &cobra.Command{
+	Use:   "cache-key",
+	Short: "Print a key that changes whenever the build inputs change",
+	Long: `Print a deterministic key derived from the Otterfile, Otterfile.lock, .otterignore,
+.otter/config.json, the resolved variables and template values, and the layers that apply
+in the current environment, so CI systems can cache .otter/ and skip builds when nothing changed.
+
+Remote layers that aren't pinned in Otterfile.lock are keyed by their repository only; build
+with --locked to make the key cover their content.`,
+	RunE: runCacheKey,
+}
+
+func init() {
+	cacheKeyCmd.Flags().StringArrayVarP(&cacheKeyFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+}
+
+func runCacheKey(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	otterfilePaths := cacheKeyFiles
+	if len(otterfilePaths) == 0 {
+		otterfilePath, err := file.FindOtterfile()
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		otterfilePaths = []string{otterfilePath}
+	}
+
+	config, err := file.ParseOtterfileStack(otterfilePaths)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	applicableLayers, err := config.FilterApplicableLayers()
+	if err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("failed to filter applicable layers: %w", err))
+	}
+
+	key := util.NewCacheKey()
+	key.Add("version", []byte(Version))
+	key.Add("platform", []byte(runtime.GOOS+"/"+runtime.GOARCH))
+
+	fsys := util.NewOSFileSystem()
+	for _, otterfilePath := range otterfilePaths {
+		if err := key.AddFile(fsys, "Otterfile", otterfilePath); err != nil {
+			return err
+		}
+	}
+	for _, name := range []string{util.LockfileName, ".otterignore", filepath.Join(".otter", util.ConfigFileName)} {
+		if err := key.AddFile(fsys, name, filepath.Join(currentDir, name)); err != nil {
+			return err
+		}
+	}
+
+	// Variables and layers are resolved against the environment, so they capture its effect on the build
+	variables, err := json.Marshal(config.Variables)
+	if err != nil {
+		return fmt.Errorf("failed to encode variables: %w", err)
+	}
+	key.Add("variables", variables)
+
+	layers, err := json.Marshal(applicableLayers)
+	if err != nil {
+		return fmt.Errorf("failed to encode layers: %w", err)
+	}
+	key.Add("layers", layers)
+
+	// Templates can render the developer's identity with .User
+	user, err := json.Marshal(util.DetectUser(currentDir))
+	if err != nil {
+		return fmt.Errorf("failed to encode user: %w", err)
+	}
+	key.Add("user", user)
+
+	// and the allowlisted environment with .Env
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	env, err := json.Marshal(util.TemplateEnv(projectConfig.TemplateEnv))
+	if err != nil {
+		return fmt.Errorf("failed to encode environment: %w", err)
+	}
+	key.Add("env", env)
+
+	fmt.Println(key.Sum())
+	return nil
+}
@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	checkLayerFormat string
+	checkLayerDelims string
+)
+
+var checkLayerCmd = &cobra.Command{
+	Use:   "check-layer <path|url>",
+	Short: "Validate a layer on its own, e.g. in the CI of a layer repository",
+	Long: `Check a layer without an Otterfile or project, so problems are caught before the layer is
+merged rather than when a project builds it:
+
+  metadata    .otter-layer.json parses and has only the fields otter knows
+  ignore      each line of the layer's .otterignore is valid and matches as intended
+  template    each file the layer copies parses as a template
+  forbidden   no leftovers of git among the files it copies, such as conflict markers
+  secret      no file in the layer looks like a credential or contains one
+  safety      no paths that differ only in case, links out of the layer, or special files
+
+The layer may be a directory, a repository URL, or a built-in layer. Files that use {{ for
+something else, such as GitHub Actions expressions, belong in layers applied with DELIMS; pass
+the same delimiters with --delims. Any finding exits with the policy status code.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runCheckLayer,
+}
+
+func init() {
+	checkLayerCmd.Flags().StringVar(&checkLayerFormat, "format", "text", "Output format: text or json")
+	checkLayerCmd.Flags().StringVar(&checkLayerDelims, "delims", "{{ }}", "Template delimiters the layer is applied with, separated by a space")
+}
+
+func runCheckLayer(cmd *cobra.Command, args []string) error {
+	if checkLayerFormat != "text" && checkLayerFormat != "json" {
+		return withExitCode(ExitConfig, fmt.Errorf("unknown format %q; use text or json", checkLayerFormat))
+	}
+	delims := strings.Fields(checkLayerDelims)
+	if len(delims) != 2 {
+		return withExitCode(ExitConfig, fmt.Errorf("--delims expects left and right delimiters separated by a space, got: %s", checkLayerDelims))
+	}
+
+	// A directory is checked in place; anything else is fetched like a layer of an Otterfile
+	layerPath := args[0]
+	if info, err := os.Stat(layerPath); err != nil || !info.IsDir() {
+		cacheDir, err := os.MkdirTemp("", "otter-check-layer-")
+		if err != nil {
+			return fmt.Errorf("failed to create a directory to fetch into: %w", err)
+		}
+		defer os.RemoveAll(cacheDir)
+
+		gitOps := util.NewGitOperations(cacheDir)
+		gitOps.Output = os.Stderr // Keep stdout for the findings
+		if layerPath, err = gitOps.CloneOrUpdateLayer(args[0]); err != nil {
+			return withExitCode(ExitFetch, fmt.Errorf("failed to fetch layer %s: %w", args[0], err))
+		}
+	}
+
+	result, err := util.CheckLayer(layerPath, [2]string{delims[0], delims[1]})
+	if err != nil {
+		return err
+	}
+	result.Layer = args[0]
+
+	if checkLayerFormat == "json" {
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode findings: %w", err)
+		}
+		fmt.Println(string(data))
+	} else {
+		fmt.Printf("Checked %d file(s) of layer %s\n", result.Files, result.Layer)
+		if len(result.Findings) > 0 {
+			fmt.Printf("\nFindings (%d):\n", len(result.Findings))
+			for _, finding := range result.Findings {
+				fmt.Printf("  %s\n", finding)
+			}
+		}
+	}
+
+	if len(result.Findings) > 0 {
+		return withExitCode(ExitPolicy, fmt.Errorf("layer %s has %d problem(s)", result.Layer, len(result.Findings)))
+	}
+	return nil
+}
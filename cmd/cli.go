@@ -4,12 +4,18 @@ import (
 	"fmt"
 	"os"
 
+	"github.com/geoffjay/otter/file"
 	"github.com/spf13/cobra"
 )
 
+// Version is the otter release version, set at build time with
+// -ldflags "-X github.com/geoffjay/otter/cmd.Version=v1.2.3"
+var Version = "dev"
+
 var cliCmd = &cobra.Command{
-	Use:   "otter",
-	Short: "Otter simplifies development environment setup through layered templates",
+	Use:     "otter",
+	Version: Version,
+	Short:   "Otter simplifies development environment setup through layered templates",
 	Long: `Otter is a tool that simplifies development environment setup through a layer concept 
 that pulls other templates containing files into the project it's run inside of.`,
 }
@@ -18,11 +24,35 @@ that pulls other templates containing files into the project it's run inside of.
 func Execute() {
 	if err := cliCmd.Execute(); err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
 func init() {
+	file.OtterVersion = Version
+	cliCmd.SetFlagErrorFunc(func(cmd *cobra.Command, err error) error {
+		return withExitCode(ExitConfig, err)
+	})
+
 	cliCmd.AddCommand(initCmd)
 	cliCmd.AddCommand(buildCmd)
+	cliCmd.AddCommand(serveCmd)
+	cliCmd.AddCommand(lockCmd)
+	cliCmd.AddCommand(bakeCmd)
+	cliCmd.AddCommand(doctorCmd)
+	cliCmd.AddCommand(newCmd)
+	cliCmd.AddCommand(cacheKeyCmd)
+	cliCmd.AddCommand(demoCmd)
+	cliCmd.AddCommand(varsCmd)
+	cliCmd.AddCommand(verifyCmd)
+	cliCmd.AddCommand(planCmd)
+	cliCmd.AddCommand(applyCmd)
+	cliCmd.AddCommand(suggestCmd)
+	cliCmd.AddCommand(listCmd)
+	cliCmd.AddCommand(infoCmd)
+	cliCmd.AddCommand(notifyCmd)
+	cliCmd.AddCommand(diffCmd)
+	cliCmd.AddCommand(testCmd)
+	cliCmd.AddCommand(checkLayerCmd)
+	cliCmd.AddCommand(reportCmd)
 }
@@ -0,0 +1,251 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	demoDir  string
+	demoKeep bool
+	demoYes  bool
+)
+
+var demoCmd = &cobra.Command{
+	Use:   "demo",
+	Short: "Walk through otter's features in a sandbox project",
+	Long: `Create a sandbox project and walk through otter step by step: initializing the project,
+applying a local layer, conditional layers, templates, and hooks. Every step runs a real build,
+so the walkthrough also works as an end-to-end smoke test of the installed otter.
+
+The sandbox is created in a temporary directory and removed afterwards unless --keep or --dir
+is given. Use --yes to run every step without pausing.`,
+	RunE: runDemo,
+}
+
+func init() {
+	demoCmd.Flags().StringVar(&demoDir, "dir", "", "Directory to create the sandbox project in (kept afterwards); must be empty")
+	demoCmd.Flags().BoolVar(&demoKeep, "keep", false, "Keep the temporary sandbox project afterwards")
+	demoCmd.Flags().BoolVarP(&demoYes, "yes", "y", false, "Run every step without waiting for enter")
+}
+
+// demoStep is one stage of the walkthrough: files to write, an explanation, and files the build must produce
+type demoStep struct {
+	title       string
+	explanation string
+	files       map[string]string // Files written into the sandbox before building, relative to it
+	otterfile   string
+	expect      map[string]string // Files the build must produce and text they must contain
+	absent      []string          // Files the build must not produce
+}
+
+var demoSteps = []demoStep{
+	{
+		title: "Apply a local layer",
+		explanation: `A layer is a directory of files that otter copies into the project. Layers are usually git
+repositories, but a local directory works the same way, which is handy while developing one.
+This Otterfile applies the layer in ./layers/basics to the project root.`,
+		files: map[string]string{
+			"layers/basics/README.md":       "# Sandbox\n\nThis file came from the basics layer.\n",
+			"layers/basics/docs/guide.md":   "Nested directories are copied too.\n",
+			"layers/basics/.otterignore":    "*.draft\n",
+			"layers/basics/notes.draft":     "Ignored by the layer's own .otterignore.\n",
+			"layers/dev-tools/.tool-config": "debug = true\n",
+			"layers/production/deploy.yml":  "replicas: 3\n",
+			"layers/service/service.yml":    "name: {{.service}}\nport: {{.port}}\n",
+		},
+		otterfile: "LAYER ./layers/basics\n",
+		expect: map[string]string{
+			"README.md":     "basics layer",
+			"docs/guide.md": "Nested",
+		},
+		absent: []string{"notes.draft"},
+	},
+	{
+		title: "Apply layers conditionally",
+		explanation: `IF makes a layer depend on the environment. env= compares against OTTER_ENV (the demo sets it
+to development), and conditions on os, arch, editor, and project facts work the same way.
+Only the development layer applies here.`,
+		otterfile: `LAYER ./layers/basics
+LAYER ./layers/dev-tools IF env=development
+LAYER ./layers/production IF env=production
+`,
+		expect: map[string]string{
+			".tool-config": "debug",
+		},
+		absent: []string{"deploy.yml"},
+	},
+	{
+		title: "Render templates",
+		explanation: `TEMPLATE passes values to a layer. Files containing template syntax are rendered with Go's
+text/template, so {{.service}} becomes the value given in the Otterfile. VAR defines values
+that can be reused with ${...} anywhere in the Otterfile.`,
+		otterfile: `VAR SERVICE=billing
+
+LAYER ./layers/basics
+LAYER ./layers/dev-tools IF env=development
+LAYER ./layers/production IF env=production
+LAYER ./layers/service TARGET services/${SERVICE} TEMPLATE service=${SERVICE} port=8080
+`,
+		expect: map[string]string{
+			"services/billing/service.yml": "name: billing",
+		},
+	},
+	{
+		title: "Run hooks",
+		explanation: `BEFORE and AFTER run shell commands around a layer, and ON_BEFORE_BUILD: and ON_AFTER_BUILD:
+around the whole build. They're useful for installing dependencies or generating code once a
+layer's files are in place.`,
+		otterfile: `VAR SERVICE=billing
+
+ON_AFTER_BUILD: ["echo Build finished > build.log"]
+
+LAYER ./layers/basics
+LAYER ./layers/dev-tools IF env=development
+LAYER ./layers/production IF env=production
+LAYER ./layers/service TARGET services/${SERVICE} TEMPLATE service=${SERVICE} port=8080 \
+  AFTER ["echo Service layer applied"]
+`,
+		expect: map[string]string{
+			"build.log": "Build finished",
+		},
+	},
+}
+
+func runDemo(cmd *cobra.Command, args []string) error {
+	sandboxDir := demoDir
+	if sandboxDir == "" {
+		tempDir, err := os.MkdirTemp("", "otter-demo-")
+		if err != nil {
+			return fmt.Errorf("failed to create sandbox directory: %w", err)
+		}
+		sandboxDir = tempDir
+		if !demoKeep {
+			defer os.RemoveAll(sandboxDir)
+		}
+	} else {
+		absDir, err := filepath.Abs(sandboxDir)
+		if err != nil {
+			return fmt.Errorf("failed to resolve sandbox directory: %w", err)
+		}
+		sandboxDir = absDir
+		if entries, err := os.ReadDir(sandboxDir); err == nil && len(entries) > 0 {
+			return withExitCode(ExitConfig, fmt.Errorf("%s already exists and is not empty", sandboxDir))
+		}
+		if err := os.MkdirAll(sandboxDir, 0755); err != nil {
+			return fmt.Errorf("failed to create sandbox directory: %w", err)
+		}
+	}
+
+	// Builds resolve local layers and conditions against the working directory
+	previousDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	if err := os.Chdir(sandboxDir); err != nil {
+		return fmt.Errorf("failed to enter sandbox directory: %w", err)
+	}
+	defer os.Chdir(previousDir)
+
+	if err := os.Setenv("OTTER_ENV", "development"); err != nil {
+		return fmt.Errorf("failed to set OTTER_ENV: %w", err)
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+	total := len(demoSteps) + 1
+
+	printDemoHeading(1, total, "Initialize the project")
+	fmt.Printf(`otter init creates the .otter directory, where otter keeps its cache, audit log, and
+record of applied files, along with a default .otterignore and a sample Otterfile.
+
+`)
+	waitForDemo(reader)
+	if err := initProject(sandboxDir); err != nil {
+		return err
+	}
+
+	for i, step := range demoSteps {
+		printDemoHeading(i+2, total, step.title)
+		fmt.Printf("%s\n", step.explanation)
+
+		for name, content := range step.files {
+			path := filepath.Join(sandboxDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+			}
+			if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", path, err)
+			}
+		}
+		otterfilePath := filepath.Join(sandboxDir, "Otterfile")
+		if err := os.WriteFile(otterfilePath, []byte(step.otterfile), 0644); err != nil {
+			return fmt.Errorf("failed to write Otterfile: %w", err)
+		}
+
+		fmt.Printf("\nOtterfile:\n\n")
+		for _, line := range strings.Split(strings.TrimRight(step.otterfile, "\n"), "\n") {
+			fmt.Printf("  %s\n", line)
+		}
+		fmt.Println()
+		waitForDemo(reader)
+
+		err := executeBuild(buildOptions{
+			ProjectDir:     sandboxDir,
+			OtterfilePaths: []string{otterfilePath},
+			Force:          true,
+			Yes:            true,
+			Operation:      "demo",
+		})
+		if err != nil {
+			return fmt.Errorf("demo step %d (%s) failed: %w", i+2, step.title, err)
+		}
+		if err := checkDemoStep(sandboxDir, step); err != nil {
+			return fmt.Errorf("demo step %d (%s) failed: %w", i+2, step.title, err)
+		}
+	}
+
+	fmt.Printf("\nDemo complete: every step built and produced the expected files.\n")
+	if demoDir != "" || demoKeep {
+		fmt.Printf("The sandbox project is in %s\n", sandboxDir)
+	}
+	return nil
+}
+
+// printDemoHeading prints the title of a walkthrough step
+func printDemoHeading(number, total int, title string) {
+	heading := fmt.Sprintf("Step %d/%d: %s", number, total, title)
+	fmt.Printf("\n%s\n%s\n\n", heading, strings.Repeat("=", len(heading)))
+}
+
+// waitForDemo pauses until the user presses enter, unless --yes was given
+func waitForDemo(reader *bufio.Reader) {
+	if demoYes {
+		return
+	}
+	fmt.Printf("Press enter to continue...")
+	reader.ReadString('\n')
+}
+
+// checkDemoStep verifies that a build produced the files a step expects
+func checkDemoStep(sandboxDir string, step demoStep) error {
+	for name, text := range step.expect {
+		content, err := os.ReadFile(filepath.Join(sandboxDir, filepath.FromSlash(name)))
+		if err != nil {
+			return fmt.Errorf("expected %s to be written: %w", name, err)
+		}
+		if !strings.Contains(string(content), text) {
+			return fmt.Errorf("expected %s to contain %q, got %q", name, text, content)
+		}
+	}
+	for _, name := range step.absent {
+		if _, err := os.Stat(filepath.Join(sandboxDir, filepath.FromSlash(name))); err == nil {
+			return fmt.Errorf("expected %s not to be written", name)
+		}
+	}
+	return nil
+}
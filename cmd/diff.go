@@ -0,0 +1,282 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	diffFiles      []string
+	diffUpstream   bool
+	diffTo         string
+	diffPatch      bool
+	diffSideBySide bool
+	diffNoPager    bool
+)
+
+var diffCmd = &cobra.Command{
+	Use:   "diff --upstream [layer...]",
+	Short: "Show the files a layer update would change in the project",
+	Long: `Fetch the remote layers of the Otterfile into the cache and list the files that changed
+between the commit pinned in Otterfile.lock and the latest commit, or --to a branch, tag, or
+commit. Each file is shown where the project receives it, after TARGET, and files the ignore
+rules keep out of the project are marked, so a layer bump can be reviewed like a dependency
+changelog. Nothing is applied to the project.
+
+With --patch the content of the changes follows each layer as a unified diff, shown with the
+tool OTTER_DIFF names if set, e.g. delta, and side by side with --side-by-side. Output to a
+terminal is shown in OTTER_PAGER, PAGER, or less.
+
+Layers can be limited by NAME or repository, with * wildcards.`,
+	RunE: runDiff,
+}
+
+func init() {
+	diffCmd.Flags().StringArrayVarP(&diffFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	diffCmd.Flags().BoolVar(&diffUpstream, "upstream", false, "Compare the pinned commit of each layer with upstream")
+	diffCmd.Flags().StringVar(&diffTo, "to", "", "Branch, tag, or commit to compare with (default: the latest commit the layer tracks)")
+	diffCmd.Flags().BoolVarP(&diffPatch, "patch", "p", false, "Show the content of the changes as a unified diff")
+	diffCmd.Flags().BoolVar(&diffSideBySide, "side-by-side", false, "Show the content of the changes side by side with the OTTER_DIFF tool; implies --patch")
+	diffCmd.Flags().BoolVar(&diffNoPager, "no-pager", false, "Write to stdout instead of the pager")
+}
+
+// upstreamActions abbreviate the kinds of change like git status does
+var upstreamActions = map[string]string{
+	util.UpstreamAdded:    "A",
+	util.UpstreamModified: "M",
+	util.UpstreamDeleted:  "D",
+}
+
+func runDiff(cmd *cobra.Command, args []string) error {
+	if !diffUpstream {
+		return withExitCode(ExitConfig, fmt.Errorf("otter diff only compares layers with upstream so far; pass --upstream, or run otter verify to check the project against its layers"))
+	}
+	diffTool := util.DiffToolCommand(diffSideBySide)
+	if diffSideBySide && diffTool == "" {
+		return withExitCode(ExitConfig, fmt.Errorf("--side-by-side needs a diff tool that supports it; set OTTER_DIFF, e.g. to delta"))
+	}
+
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := projectConfig.Fetch.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	cacheBackend, err := util.NewCacheBackend(projectConfig.Cache, currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	config, err := parseOtterfiles(diffFiles)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	lock, err := util.LoadLockfile(filepath.Join(currentDir, util.LockfileName))
+	if err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("%w; run 'otter build' to pin the layers first", err))
+	}
+
+	fileOps := util.NewFileOperations()
+	if err := fileOps.LoadIgnorePatterns(currentDir); err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("failed to load ignore patterns: %w", err))
+	}
+	if err := fileOps.AddIgnorePresets(config.IgnorePresets); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if fileOps.IgnoreCase, err = util.ResolveIgnoreCase(config.IgnoreCase); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if fileOps.RequireAllowHidden, err = util.ResolveHiddenFiles(projectConfig.HiddenFiles); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	fileOps.IgnoreRoot = currentDir
+
+	gitOps := util.NewGitOperations(filepath.Join(currentDir, ".otter", "cache"))
+	gitOps.Limiter = util.NewHostLimiter(projectConfig.Fetch)
+	gitOps.FailOnForcePush = projectConfig.Fetch.ForcePushed == util.ForcePushedFail
+	gitOps.DefaultBranch = projectConfig.Fetch.DefaultBranch
+	gitOps.Branches = config.LayerBranches()
+	gitOps.Cache = cacheBackend
+	var layers []file.Layer
+	var repositories []string
+	for _, layer := range config.Layers {
+		if !gitOps.IsRemoteLayer(layer.Repository) || !matchesAny(layer, args) {
+			continue
+		}
+		layers = append(layers, layer)
+		repositories = append(repositories, layer.Repository)
+	}
+	if len(layers) == 0 {
+		return withExitCode(ExitConfig, fmt.Errorf("no remote layers to compare"))
+	}
+
+	// A repository applied to several targets is fetched once
+	repositories = uniqueStrings(repositories)
+	fetched := make(map[string]util.FetchResult)
+	for i, result := range gitOps.FetchLayers(repositories, util.LogOutputBuffered) {
+		fetched[repositories[i]] = result
+	}
+
+	// Output to a terminal goes through the pager, once everything is fetched
+	var out io.Writer = os.Stdout
+	color := util.StdoutIsTerminal() && os.Getenv("NO_COLOR") == ""
+	if !diffNoPager && util.StdoutIsTerminal() {
+		pager, err := util.StartPager(util.PagerCommand(), os.Stdout)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		if pager != nil {
+			defer pager.Close()
+			out = pager
+		}
+	}
+
+	var failed int
+	for _, layer := range layers {
+		fmt.Fprintf(out, "\n%s", layer.Repository)
+		if layer.Target != "." {
+			fmt.Fprintf(out, " (TARGET %s)", layer.Target)
+		}
+		fmt.Fprintln(out)
+		if layer.Frozen {
+			fmt.Fprintf(out, "  Frozen; builds keep it at its pinned commit\n")
+		}
+
+		result := fetched[layer.Repository]
+		if result.Err != nil {
+			fmt.Fprintf(out, "  ✗ could not be fetched: %v\n", result.Err)
+			failed++
+			continue
+		}
+		pinned, ok := lock.Find(layer.Repository)
+		if !ok {
+			fmt.Fprintf(out, "  Not pinned in %s; run 'otter build' to pin it\n", util.LockfileName)
+			continue
+		}
+		latest, err := gitOps.GetRepositoryCommit(result.Path)
+		if err == nil && diffTo != "" {
+			latest, err = gitOps.ResolveCommit(result.Path, diffTo)
+		}
+		if err != nil {
+			fmt.Fprintf(out, "  ✗ %v\n", err)
+			failed++
+			continue
+		}
+		if latest == pinned.Commit {
+			fmt.Fprintf(out, "  Up to date at %s\n", util.ShortCommit(latest))
+			continue
+		}
+
+		changes, err := gitOps.UpstreamChanges(result.Path, pinned.Commit, latest)
+		if err != nil {
+			fmt.Fprintf(out, "  ✗ %v\n", err)
+			failed++
+			continue
+		}
+		remoteTarget := util.IsSSHTarget(layer.Target) || util.IsContainerTarget(layer.Target)
+		if !remoteTarget {
+			fileOps.AllowProtected = layer.Allow
+			fileOps.AllowHidden = layer.AllowHidden
+			fileOps.Only = layer.Only
+			fileOps.Map = layer.Map
+			if err := fileOps.MapUpstreamChanges(result.Path, filepath.Join(currentDir, layer.Target), changes); err != nil {
+				fmt.Fprintf(out, "  ✗ %v\n", err)
+				failed++
+				continue
+			}
+		}
+
+		fmt.Fprintf(out, "  %s → %s, %d file(s) changed\n", util.ShortCommit(pinned.Commit), util.ShortCommit(latest), len(changes))
+		for _, change := range changes {
+			path := change.Path
+			if change.Destination != "" {
+				if relativePath, err := filepath.Rel(currentDir, change.Destination); err == nil {
+					path = relativePath
+				}
+			}
+			fmt.Fprintf(out, "  %s %s", upstreamActions[change.Action], util.DisplayPath(path))
+			if change.Additions > 0 || change.Deletions > 0 {
+				fmt.Fprintf(out, " (+%d -%d)", change.Additions, change.Deletions)
+			}
+			if change.Ignored != "" {
+				fmt.Fprintf(out, " [%s]", change.Ignored)
+			}
+			fmt.Fprintln(out)
+		}
+
+		if diffPatch || diffSideBySide {
+			if err := showUpstreamPatch(out, gitOps, result.Path, pinned.Commit, latest, changes, currentDir, diffTool, color); err != nil {
+				fmt.Fprintf(out, "  ✗ %v\n", err)
+				failed++
+			}
+		}
+	}
+
+	if failed > 0 {
+		return withExitCode(ExitFetch, fmt.Errorf("%d layer(s) could not be compared", failed))
+	}
+	return nil
+}
+
+// showUpstreamPatch writes the content of a layer's changes to out as a unified diff, through the
+// diff tool when one is set
+func showUpstreamPatch(out io.Writer, gitOps *util.GitOperations, layerPath, from, to string, changes []util.UpstreamChange, root, diffTool string, color bool) error {
+	patch, err := gitOps.UpstreamPatch(layerPath, from, to, changes, root)
+	if err != nil {
+		return err
+	}
+	if len(patch.FilePatches()) == 0 {
+		return nil
+	}
+
+	fmt.Fprintln(out)
+	if diffTool == "" {
+		return util.WriteUnifiedDiff(out, patch, color)
+	}
+	var unified bytes.Buffer
+	if err := util.WriteUnifiedDiff(&unified, patch, false); err != nil {
+		return err
+	}
+	return util.RunDiffTool(diffTool, unified.Bytes(), out)
+}
+
+// matchesAny reports whether a layer matches one of the patterns by NAME or repository, or there
+// are no patterns
+func matchesAny(layer file.Layer, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	ref := util.LayerRef{Name: layer.Name, Repository: layer.Repository}
+	for _, pattern := range patterns {
+		if ref.Matches(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// uniqueStrings returns values without repeats, in order
+func uniqueStrings(values []string) []string {
+	var unique []string
+	seen := make(map[string]bool)
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	doctorFile       string
+	doctorCheckDrift bool
+)
+
+var doctorCmd = &cobra.Command{
+	Use:   "doctor",
+	Short: "Check that the project and its required tools are set up",
+	Long: `Check that the project has been initialized, that its Otterfile/Envfile parses, and
+that the toolchains listed in TOOLS directives are installed at the required versions.
+
+With --check-drift, also check that files written by layers haven't been modified or removed
+since the last build. Drift exits with its own status code, so CI can tell it apart.`,
+	RunE: runDoctor,
+}
+
+func init() {
+	doctorCmd.Flags().StringVarP(&doctorFile, "file", "f", "", "Specify the Otterfile/Envfile to use (default: auto-detect)")
+	doctorCmd.Flags().BoolVar(&doctorCheckDrift, "check-drift", false, "Check that files written by layers are unchanged since the last build")
+}
+
+func runDoctor(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	// The exit code is that of the first problem found
+	var problems int
+	code := ExitError
+	problem := func(exitCode int) {
+		if problems == 0 {
+			code = exitCode
+		}
+		problems++
+	}
+
+	if _, err := os.Stat(filepath.Join(currentDir, ".otter")); err != nil {
+		fmt.Printf("✗ .otter directory not found, run 'otter init'\n")
+		problem(ExitConfig)
+	} else {
+		fmt.Printf("✓ .otter directory found\n")
+	}
+
+	otterfilePath := doctorFile
+	if otterfilePath == "" {
+		otterfilePath, err = file.FindOtterfile()
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return withExitCode(ExitConfig, fmt.Errorf("doctor found problems"))
+		}
+	}
+
+	config, err := file.ParseOtterfile(otterfilePath)
+	if err != nil {
+		fmt.Printf("✗ %s: %v\n", otterfilePath, err)
+		return withExitCode(ExitConfig, fmt.Errorf("doctor found problems"))
+	}
+	fmt.Printf("✓ %s parses (%d layer(s))\n", otterfilePath, len(config.Layers))
+
+	if err := checkRequiredTools(config.Tools); err != nil {
+		problem(ExitPolicy)
+	}
+
+	if doctorCheckDrift {
+		projectConfig, err := util.LoadConfig(currentDir)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		fsys := util.NewOSFileSystem()
+		if _, err := pullManifestState(currentDir, projectConfig, fsys); err != nil {
+			return err
+		}
+		manifest, err := util.LoadFileManifest(fsys, filepath.Join(currentDir, ".otter", util.FileManifestName))
+		if err != nil {
+			return err
+		}
+		drifted, err := manifest.Drift(fsys, currentDir)
+		if err != nil {
+			return err
+		}
+		if len(drifted) > 0 {
+			fmt.Printf("\n✗ %d file(s) changed since otter wrote them:\n", len(drifted))
+			for _, path := range drifted {
+				fmt.Printf("  - %s\n", path)
+			}
+			problem(ExitDrift)
+		} else {
+			fmt.Printf("\n✓ Files written by layers are unchanged\n")
+		}
+	}
+
+	if problems > 0 {
+		return withExitCode(code, fmt.Errorf("doctor found %d problem(s)", problems))
+	}
+
+	fmt.Printf("\nEverything looks good.\n")
+	return nil
+}
+
+// checkRequiredTools checks the TOOLS requirements, printing the result of each along with
+// install commands for missing tools, and returns an error listing the unmet requirements
+func checkRequiredTools(tools []file.ToolRequirement) error {
+	if len(tools) == 0 {
+		return nil
+	}
+
+	fmt.Printf("\nChecking required tools:\n")
+
+	var unmet []string
+	for _, tool := range tools {
+		check := util.CheckTool(tool.Name, tool.Operator, tool.Version)
+		if check.Satisfied {
+			if check.Version != "" {
+				fmt.Printf("  ✓ %s %s (%s)\n", tool.Name, check.Version, tool)
+			} else {
+				fmt.Printf("  ✓ %s\n", tool)
+			}
+			continue
+		}
+
+		fmt.Printf("  ✗ %s\n", check.Problem())
+		for _, hint := range check.InstallHints() {
+			fmt.Printf("    install with: %s\n", hint)
+		}
+		unmet = append(unmet, tool.String())
+	}
+
+	if len(unmet) > 0 {
+		return fmt.Errorf("required tools are missing or outdated: %s", strings.Join(unmet, ", "))
+	}
+
+	return nil
+}
@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+)
+
+// Engine carries out otter's operations on a project. The CLI and otter serve share the
+// in-process engine; with --engine, the CLI talks to a running otter serve instead, so builds can
+// run elsewhere, such as in a sandbox for hooks, without another copy of the orchestration.
+type Engine interface {
+	// Validate parses the Otterfile of a request, or the Otterfile given inline as Content
+	Validate(req engineRequest) error
+	// Plan lists the layers of the project's Otterfile, where they apply, and whether they will
+	Plan(req engineRequest) ([]plannedLayer, error)
+	// Fetch clones or updates a layer into the project's cache
+	Fetch(req fetchRequest) (fetchResult, error)
+	// Build applies the project's Otterfile
+	Build(req engineRequest) error
+}
+
+// engineRequest names a project and its Otterfile, along with the options of a build. Paths are
+// resolved where the engine runs.
+type engineRequest struct {
+	Project string `json:"project"`
+	File    string `json:"file,omitempty"`
+	Content string `json:"content,omitempty"` // Otterfile to validate instead of the project's
+
+	Files              []string `json:"files,omitempty"` // Otterfiles to stack; File is used when empty
+	Force              bool     `json:"force,omitempty"`
+	Locked             bool     `json:"locked,omitempty"`
+	Yes                bool     `json:"yes,omitempty"`
+	NoPrune            bool     `json:"no_prune,omitempty"`
+	PreserveAttributes bool     `json:"preserve_attributes,omitempty"`
+	TargetSSH          string   `json:"target_ssh,omitempty"`
+	TargetContainer    string   `json:"target_container,omitempty"`
+	Checkpoint         bool     `json:"checkpoint,omitempty"`
+	Resume             bool     `json:"resume,omitempty"`
+	LogOutput          string   `json:"log_output,omitempty"`
+	VerifyKey          string   `json:"verify_key,omitempty"`
+	// TraceParent is the W3C trace context the build's spans join, e.g. of a provisioning pipeline
+	TraceParent string `json:"traceparent,omitempty"`
+	// Prompt asks for PROMPT variables on the terminal; never sent, as otter serve has none
+	Prompt bool `json:"-"`
+	// Unattended declines confirmations rather than asking on the terminal; set by otter serve
+	Unattended bool `json:"-"`
+}
+
+// fetchRequest names a layer to fetch into a project's cache
+type fetchRequest struct {
+	Project    string `json:"project"`
+	Repository string `json:"repository"`
+}
+
+// fetchResult is where a fetched layer is and its commit
+type fetchResult struct {
+	Path   string
+	Commit string
+}
+
+// newEngine returns the engine at url, a running otter serve, or the in-process engine when url
+// is empty. A remote engine records metrics and traces of its own.
+func newEngine(url string, t telemetry) Engine {
+	if url == "" {
+		return newLocalEngine(t)
+	}
+	return &remoteEngine{url: strings.TrimSuffix(url, "/"), token: os.Getenv("OTTER_SERVE_TOKEN")}
+}
+
+// localEngine performs operations in this process
+type localEngine struct {
+	// mu serializes operations; builds change the working directory of the process
+	mu sync.Mutex
+	// gitOps keeps one GitOperations per cache directory so repeated requests reuse it
+	gitOps map[string]*util.GitOperations
+	// telemetry receives the metrics and traces of builds and fetches
+	telemetry telemetry
+}
+
+func newLocalEngine(t telemetry) *localEngine {
+	return &localEngine{gitOps: make(map[string]*util.GitOperations), telemetry: t}
+}
+
+func (e *localEngine) Validate(req engineRequest) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// Inline content without a project is parsed against the directory otter runs in
+	if req.Content != "" && req.Project == "" {
+		_, err := file.ParseOtterfileReader(strings.NewReader(req.Content), "request")
+		return withExitCode(ExitConfig, err)
+	}
+	return e.inProject(req.Project, func(projectDir string) error {
+		var err error
+		if req.Content != "" {
+			_, err = file.ParseOtterfileReader(strings.NewReader(req.Content), "request")
+		} else {
+			_, err = e.parseProjectOtterfile(req)
+		}
+		return withExitCode(ExitConfig, err)
+	})
+}
+
+func (e *localEngine) Plan(req engineRequest) ([]plannedLayer, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var layers []plannedLayer
+	err := e.inProject(req.Project, func(projectDir string) error {
+		config, err := e.parseProjectOtterfile(req)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+
+		for _, layer := range config.Layers {
+			apply, err := layer.ShouldApplyLayer()
+			if err != nil {
+				return withExitCode(ExitConfig, fmt.Errorf("error evaluating condition for layer %s: %w", layer.Repository, err))
+			}
+			layers = append(layers, plannedLayer{
+				Repository: layer.Repository,
+				Target:     filepath.Join(projectDir, layer.Target),
+				Condition:  layer.Condition,
+				Apply:      apply,
+			})
+		}
+		return nil
+	})
+	return layers, err
+}
+
+func (e *localEngine) Fetch(req fetchRequest) (fetchResult, error) {
+	if req.Repository == "" {
+		return fetchResult{}, withExitCode(ExitConfig, fmt.Errorf("repository is required"))
+	}
+
+	// A relative project is resolved against the working directory, which builds change
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	projectDir, err := resolveProjectDir(req.Project)
+	if err != nil {
+		return fetchResult{}, withExitCode(ExitConfig, err)
+	}
+
+	gitOps := e.gitOperations(filepath.Join(projectDir, ".otter", "cache"))
+	layerPath, err := gitOps.CloneOrUpdateLayer(req.Repository)
+	if err != nil {
+		return fetchResult{}, withExitCode(ExitFetch, err)
+	}
+
+	commit, _ := gitOps.GetRepositoryCommit(layerPath)
+	return fetchResult{Path: layerPath, Commit: commit}, nil
+}
+
+func (e *localEngine) Build(req engineRequest) error {
+	otterfilePaths := req.Files
+	if len(otterfilePaths) == 0 && req.File != "" {
+		otterfilePaths = []string{req.File}
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return e.inProject(req.Project, func(projectDir string) error {
+		return executeBuild(buildOptions{
+			ProjectDir:         projectDir,
+			OtterfilePaths:     otterfilePaths,
+			Force:              req.Force,
+			Locked:             req.Locked,
+			VerifyKey:          req.VerifyKey,
+			Yes:                req.Yes,
+			TargetSSH:          req.TargetSSH,
+			TargetContainer:    req.TargetContainer,
+			NoPrune:            req.NoPrune,
+			PreserveAttributes: req.PreserveAttributes,
+			Checkpoint:         req.Checkpoint,
+			Resume:             req.Resume,
+			LogOutput:          req.LogOutput,
+			Metrics:            e.telemetry.metrics,
+			Tracer:             e.telemetry.tracer,
+			TraceParent:        req.TraceParent,
+			Prompt:             req.Prompt,
+			Unattended:         req.Unattended,
+		})
+	})
+}
+
+// parseProjectOtterfile parses the Otterfile referenced by a request
+func (e *localEngine) parseProjectOtterfile(req engineRequest) (*file.OtterfileConfig, error) {
+	projectDir, err := resolveProjectDir(req.Project)
+	if err != nil {
+		return nil, err
+	}
+
+	otterfilePath := req.File
+	if otterfilePath == "" {
+		otterfilePath = "Otterfile"
+		if _, err := os.Stat(filepath.Join(projectDir, otterfilePath)); os.IsNotExist(err) {
+			otterfilePath = "Envfile"
+		}
+	}
+	if !filepath.IsAbs(otterfilePath) {
+		otterfilePath = filepath.Join(projectDir, otterfilePath)
+	}
+
+	return file.ParseOtterfile(otterfilePath)
+}
+
+// inProject runs fn from within the project directory, restoring the working directory afterwards.
+// Relative paths in the Otterfile (local layers, conditions) are resolved against the
+// working directory, so the engine must switch into the project while it evaluates them.
+// Callers must hold e.mu.
+func (e *localEngine) inProject(project string, fn func(projectDir string) error) error {
+	projectDir, err := resolveProjectDir(project)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	previousDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+	if err := os.Chdir(projectDir); err != nil {
+		return fmt.Errorf("failed to enter project directory: %w", err)
+	}
+	defer os.Chdir(previousDir)
+
+	return fn(projectDir)
+}
+
+// gitOperations returns the cached GitOperations for a cache directory
+func (e *localEngine) gitOperations(cacheDir string) *util.GitOperations {
+	if gitOps, ok := e.gitOps[cacheDir]; ok {
+		return gitOps
+	}
+	gitOps := util.NewGitOperations(cacheDir)
+	gitOps.Metrics = e.telemetry.metrics
+	e.gitOps[cacheDir] = gitOps
+	return gitOps
+}
+
+// remoteEngine performs operations through the HTTP API of otter serve
+type remoteEngine struct {
+	url   string
+	token string
+}
+
+func (e *remoteEngine) Validate(req engineRequest) error {
+	_, err := e.call("/v1/validate", req)
+	return err
+}
+
+func (e *remoteEngine) Plan(req engineRequest) ([]plannedLayer, error) {
+	resp, err := e.call("/v1/plan", req)
+	return resp.Layers, err
+}
+
+func (e *remoteEngine) Fetch(req fetchRequest) (fetchResult, error) {
+	resp, err := e.call("/v1/fetch", req)
+	return fetchResult{Path: resp.Path, Commit: resp.Commit}, err
+}
+
+func (e *remoteEngine) Build(req engineRequest) error {
+	_, err := e.call("/v1/apply", req)
+	return err
+}
+
+// call posts a request to an endpoint, returning the error the engine reported with its exit code
+func (e *remoteEngine) call(endpoint string, body interface{}) (serveResponse, error) {
+	var resp serveResponse
+	data, err := json.Marshal(body)
+	if err != nil {
+		return resp, fmt.Errorf("failed to encode request: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, e.url+endpoint, bytes.NewReader(data))
+	if err != nil {
+		return resp, withExitCode(ExitConfig, fmt.Errorf("invalid engine URL %s: %w", e.url, err))
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if e.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+e.token)
+	}
+
+	httpResp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		return resp, fmt.Errorf("failed to reach engine at %s: %w", e.url, err)
+	}
+	defer httpResp.Body.Close()
+
+	if err := json.NewDecoder(httpResp.Body).Decode(&resp); err != nil {
+		return resp, fmt.Errorf("invalid response from engine at %s (%s): %w", e.url, httpResp.Status, err)
+	}
+	if httpResp.StatusCode >= 300 {
+		code := resp.ExitCode
+		if code == 0 {
+			code = ExitError
+		}
+		return resp, withExitCode(code, fmt.Errorf("engine at %s: %s", e.url, resp.Error))
+	}
+	return resp, nil
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"errors"
+)
+
+// Exit codes returned by otter commands, so scripts and CI can branch on the kind of failure
+const (
+	ExitError    = 1 // Unclassified failure
+	ExitConfig   = 2 // Invalid flags, Otterfile, or project configuration
+	ExitFetch    = 3 // A layer couldn't be fetched or checked out
+	ExitConflict = 4 // Overwriting existing files was declined
+	ExitHook     = 5 // A hook or generator command failed
+	ExitDrift    = 6 // Project files differ from what their layers provided
+	ExitPolicy   = 7 // A scan, signature check, size limit, or TOOLS requirement rejected the operation
+)
+
+// exitClasses name the kinds of failure, e.g. for metrics
+var exitClasses = map[int]string{
+	ExitError:    "error",
+	ExitConfig:   "config",
+	ExitFetch:    "fetch",
+	ExitConflict: "conflict",
+	ExitHook:     "hook",
+	ExitDrift:    "drift",
+	ExitPolicy:   "policy",
+}
+
+// exitError carries the exit code for an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string { return e.err.Error() }
+func (e *exitError) Unwrap() error { return e.err }
+
+// withExitCode classifies err with an exit code, keeping the code of an already classified error
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *exitError
+	if errors.As(err, &classified) {
+		return err
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode returns the exit code for an error returned by a command
+func exitCode(err error) int {
+	var classified *exitError
+	if errors.As(err, &classified) {
+		return classified.code
+	}
+	return ExitError
+}
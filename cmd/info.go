@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var infoFiles []string
+
+var infoCmd = &cobra.Command{
+	Use:   "info <layer>",
+	Short: "Show what a layer provides and how the Otterfile applies it",
+	Long: `Show a layer's description, where its files are, and what its .otter-layer.json declares,
+such as a deprecation or the layers it conflicts with. A layer of the Otterfile is selected by
+its NAME or repository, and is shown with the clauses that apply it; any other argument is
+taken as a repository, e.g. builtin:makefile.
+
+Layers aren't fetched; remote layers are described once a build has fetched them into
+.otter/cache.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runInfo,
+}
+
+func init() {
+	infoCmd.Flags().StringArrayVarP(&infoFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+}
+
+func runInfo(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	// Layers outside the Otterfile can be described too, so a missing Otterfile isn't an error
+	layer := &file.Layer{Repository: args[0], Target: "."}
+	inOtterfile := false
+	if _, findErr := file.FindOtterfile(); len(infoFiles) > 0 || findErr == nil {
+		config, err := parseOtterfiles(infoFiles)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		if found := findLayer(config, args[0]); found != nil {
+			layer, inOtterfile = found, true
+		}
+	}
+
+	gitOps := util.NewGitOperations(filepath.Join(currentDir, ".otter", "cache"))
+	layerPath, description := describeLayer(gitOps, layer.Repository)
+
+	fmt.Printf("Layer %s\n", layer.Repository)
+	fmt.Printf("  Description: %s\n", description)
+	if inOtterfile {
+		if layer.Comment != "" {
+			fmt.Printf("  Comment: %s\n", layer.Comment)
+		}
+		if options := layerOptions(*layer); len(options) > 0 {
+			fmt.Printf("  Options: %s\n", strings.Join(options, ", "))
+		}
+		if layer.Condition != "" {
+			apply, err := layer.ShouldApplyLayer()
+			if err != nil {
+				return withExitCode(ExitConfig, err)
+			}
+			fmt.Printf("  Applies: %t\n", apply)
+		}
+	} else {
+		fmt.Printf("  Not in the Otterfile\n")
+	}
+	if layerPath == "" {
+		return nil
+	}
+
+	fmt.Printf("  Path: %s\n", layerPath)
+	if gitOps.IsRemoteLayer(layer.Repository) {
+		if commit, err := gitOps.GetRepositoryCommit(layerPath); err == nil {
+			fmt.Printf("  Commit: %s\n", commit)
+		}
+	}
+
+	metadata, err := util.LoadLayerMetadata(layerPath)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if metadata.Deprecated != nil {
+		fmt.Printf("  Status: %s\n", metadata.Deprecated)
+	}
+	if compatibility := metadata.Compatibility; compatibility != nil {
+		if len(compatibility.Conflicts) > 0 {
+			fmt.Printf("  Conflicts with: %s\n", strings.Join(compatibility.Conflicts, ", "))
+		}
+		if len(compatibility.Layers) > 0 {
+			fmt.Printf("  Requires layers: %s\n", strings.Join(compatibility.Layers, ", "))
+		}
+		if len(compatibility.Files) > 0 {
+			fmt.Printf("  Requires files: %s\n", strings.Join(compatibility.Files, ", "))
+		}
+	}
+	return nil
+}
@@ -5,14 +5,31 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/geoffjay/otter/util"
+
 	"github.com/spf13/cobra"
 )
 
+var (
+	initFromExisting bool
+	initRegistries   []string
+)
+
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize the current directory for otter",
-	Long:  `Initialize the current directory by creating the .otter directory structure.`,
-	RunE:  runInit,
+	Long: `Initialize the current directory by creating the .otter directory structure.
+
+With --from-existing, the files already in the project are compared with known layers, by the
+SHA-256 of each layer's canonical files, and the Otterfile is proposed from the layers found
+instead of written from a sample. Known layers come from known_layers in .otter/config.json,
+registries given with --registry, and the built-in layers that don't use templates.`,
+	RunE: runInit,
+}
+
+func init() {
+	initCmd.Flags().BoolVar(&initFromExisting, "from-existing", false, "Propose an Otterfile from the known layers the project's files match")
+	initCmd.Flags().StringArrayVar(&initRegistries, "registry", nil, "URL or path of a JSON registry of known layers (repeatable)")
 }
 
 func runInit(cmd *cobra.Command, args []string) error {
@@ -21,6 +38,77 @@ func runInit(cmd *cobra.Command, args []string) error {
 		return fmt.Errorf("failed to get current directory: %w", err)
 	}
 
+	if initFromExisting {
+		if err := proposeOtterfile(currentDir); err != nil {
+			return err
+		}
+	} else if len(initRegistries) > 0 {
+		return withExitCode(ExitConfig, fmt.Errorf("--registry is only used with --from-existing"))
+	}
+
+	return initProject(currentDir)
+}
+
+// proposeOtterfile recognizes known layers in the files of an existing project and writes an
+// Otterfile applying them, or shows it when the project already has one
+func proposeOtterfile(currentDir string) error {
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := util.ValidateKnownLayers(projectConfig.KnownLayers); err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("invalid known_layers in .otter/%s: %w", util.ConfigFileName, err))
+	}
+
+	known := append([]util.KnownLayer(nil), projectConfig.KnownLayers...)
+	for _, registry := range initRegistries {
+		content, err := fetchSource(registry, "registry")
+		if err != nil {
+			return withExitCode(ExitFetch, err)
+		}
+		layers, err := util.ParseKnownLayerRegistry([]byte(content), registry)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		known = append(known, layers...)
+	}
+	known = append(known, util.BuiltinKnownLayers()...)
+
+	matches, err := util.MatchKnownLayers(currentDir, known)
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		fmt.Printf("No known layers found among the project's files (%d known)\n", len(known))
+		return nil
+	}
+
+	fmt.Printf("Recognized layers:\n")
+	for _, match := range matches {
+		fmt.Printf("  %s (%d of %d file(s) match)\n", match.Line(), len(match.Matched), len(match.Files))
+		for _, name := range match.Modified {
+			fmt.Printf("    modified: %s\n", name)
+		}
+		for _, name := range match.Missing {
+			fmt.Printf("    missing: %s\n", name)
+		}
+	}
+
+	proposal := util.ProposeOtterfile(matches)
+	otterfilePath := filepath.Join(currentDir, "Otterfile")
+	if _, err := os.Stat(otterfilePath); err == nil {
+		fmt.Printf("\nOtterfile already exists, so it was left as is. Proposed Otterfile:\n\n%s\n", proposal)
+		return nil
+	}
+	if err := os.WriteFile(otterfilePath, []byte(proposal), 0644); err != nil {
+		return fmt.Errorf("failed to write proposed Otterfile: %w", err)
+	}
+	fmt.Printf("\nProposed an Otterfile from %d recognized layer(s); partial matches are commented out for review\n", len(matches))
+	return nil
+}
+
+// initProject creates the .otter directory structure and default files in a project directory
+func initProject(currentDir string) error {
 	otterDir := filepath.Join(currentDir, ".otter")
 	cacheDir := filepath.Join(otterDir, "cache")
 
@@ -59,6 +147,10 @@ node_modules/
 # Example:
 # LAYER git@github.com:otter-layers/go-cobra-cli.git
 # LAYER git@github.com:otter-layers/cursor-go-rules.git TARGET .cursor/rules
+#
+# Built-in layers ship with otter: editorconfig, gitattributes, makefile, license-mit
+# LAYER builtin:license-mit TEMPLATE year=2026 author=${USER}
+LAYER builtin:editorconfig
 `
 		if err := os.WriteFile(otterfilePath, []byte(sampleOtterfile), 0644); err != nil {
 			return fmt.Errorf("failed to create sample Otterfile: %w", err)
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var listFiles []string
+
+var listCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the layers the Otterfile applies, with what each provides",
+	Long: `List the layers of the Otterfile in the current directory along with a short description
+of each: the description in the layer's .otter-layer.json, or else the first heading of its
+README, and whether the layer is deprecated. A comment at the end of a LAYER command, such as why the project uses the layer, is
+shown below it.
+
+Layers aren't fetched; remote layers are described once a build has fetched them into
+.otter/cache.`,
+	RunE: runList,
+}
+
+func init() {
+	listCmd.Flags().StringArrayVarP(&listFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+}
+
+func runList(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	config, err := parseOtterfiles(listFiles)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	gitOps := util.NewGitOperations(filepath.Join(currentDir, ".otter", "cache"))
+	fmt.Printf("Layers (%d):\n", len(config.Layers))
+	for _, layer := range config.Layers {
+		if options := layerOptions(layer); len(options) > 0 {
+			fmt.Printf("  %s (%s)\n", layer.Repository, strings.Join(options, ", "))
+		} else {
+			fmt.Printf("  %s\n", layer.Repository)
+		}
+		layerPath, description := describeLayer(gitOps, layer.Repository)
+		fmt.Printf("    %s\n", description)
+		if layerPath != "" {
+			if metadata, err := util.LoadLayerMetadata(layerPath); err == nil && metadata.Deprecated != nil {
+				fmt.Printf("    Status: %s\n", metadata.Deprecated)
+			}
+		}
+		if layer.Comment != "" {
+			fmt.Printf("    # %s\n", layer.Comment)
+		}
+	}
+	return nil
+}
+
+// parseOtterfiles parses the given Otterfiles as a stack, or the Otterfile in the current directory
+func parseOtterfiles(otterfilePaths []string) (*file.OtterfileConfig, error) {
+	if len(otterfilePaths) == 0 {
+		otterfilePath, err := file.FindOtterfile()
+		if err != nil {
+			return nil, err
+		}
+		otterfilePaths = []string{otterfilePath}
+	}
+	return file.ParseOtterfileStack(otterfilePaths)
+}
+
+// layerOptions describes the clauses of a LAYER command that set where and when it applies
+func layerOptions(layer file.Layer) []string {
+	var options []string
+	if layer.Name != "" {
+		options = append(options, "NAME "+layer.Name)
+	}
+	if layer.Target != "." {
+		options = append(options, "TARGET "+layer.Target)
+	}
+	if layer.Type != "" {
+		options = append(options, "TYPE "+layer.Type)
+	}
+	if layer.Condition != "" {
+		options = append(options, "IF "+layer.Condition)
+	}
+	if len(layer.Only) > 0 {
+		options = append(options, "ONLY "+strings.Join(layer.Only, " "))
+	}
+	if len(layer.Map) > 0 {
+		var mappings []string
+		for _, mapping := range layer.Map {
+			mappings = append(mappings, mapping.String())
+		}
+		options = append(options, "MAP "+strings.Join(mappings, " "))
+	}
+	if layer.Branch != "" {
+		options = append(options, "BRANCH "+layer.Branch)
+	}
+	if len(layer.Depends) > 0 {
+		options = append(options, "DEPENDS "+strings.Join(layer.Depends, " "))
+	}
+	if layer.Strategy != "" {
+		options = append(options, "STRATEGY "+layer.Strategy)
+	}
+	if layer.Frozen {
+		options = append(options, "FROZEN")
+	}
+	return options
+}
+
+// describeLayer returns where a layer's files are, without fetching it, and its description or
+// why there is none. The path is empty when the layer isn't available.
+func describeLayer(gitOps *util.GitOperations, repository string) (string, string) {
+	layerPath, err := gitOps.CachedLayerPath(repository)
+	switch {
+	case err != nil:
+		return "", fmt.Sprintf("(unavailable: %v)", err)
+	case layerPath == "":
+		return "", "(not fetched yet; run 'otter build' to fetch it)"
+	}
+	if description := util.LayerDescription(layerPath); description != "" {
+		return layerPath, description
+	}
+	return layerPath, "(no description)"
+}
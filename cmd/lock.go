@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	lockSignKey string
+	lockFiles   []string
+)
+
+var lockCmd = &cobra.Command{
+	Use:   "lock",
+	Short: "Manage the Otterfile.lock lockfile",
+	Long:  `Manage the Otterfile.lock lockfile that pins remote layers to specific commits.`,
+}
+
+var lockSignCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign Otterfile.lock with a minisign key",
+	Long: `Sign Otterfile.lock with a team minisign key, writing Otterfile.lock.minisig.
+
+Builds run with --locked verify the signature when a public key is configured in
+.otter/config.json (lock.public_key) or passed with --verify-key. Requires the
+minisign binary to be installed.`,
+	RunE: runLockSign,
+}
+
+var lockVerifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check Otterfile.lock against the Otterfile and the layer remotes",
+	Long: `Check that every remote layer that applies in the current environment is pinned in
+Otterfile.lock, that every pinned layer is still referenced by the Otterfile, and that each
+pinned commit still exists upstream on a branch or tag of the layer repository.`,
+	RunE: runLockVerify,
+}
+
+var lockTidyCmd = &cobra.Command{
+	Use:   "tidy",
+	Short: "Remove unused entries from Otterfile.lock and sort it",
+	Long: `Remove entries for layers the Otterfile no longer references, along with duplicate entries,
+and write the remaining entries sorted by repository for clean diffs. Layers are kept when any
+LAYER command references them, whether or not its condition applies in the current environment.`,
+	RunE: runLockTidy,
+}
+
+func init() {
+	lockSignCmd.Flags().StringVarP(&lockSignKey, "key", "s", "", "minisign secret key file (default: minisign's default key)")
+	lockVerifyCmd.Flags().StringArrayVarP(&lockFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	lockTidyCmd.Flags().StringArrayVarP(&lockFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	lockCmd.AddCommand(lockSignCmd)
+	lockCmd.AddCommand(lockVerifyCmd)
+	lockCmd.AddCommand(lockTidyCmd)
+}
+
+func runLockSign(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	lockPath := filepath.Join(currentDir, util.LockfileName)
+	if _, err := util.LoadLockfile(lockPath); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	minisignArgs := []string{"-S", "-m", lockPath, "-x", lockPath + util.SignatureSuffix}
+	if lockSignKey != "" {
+		minisignArgs = append(minisignArgs, "-s", lockSignKey)
+	}
+
+	minisign := exec.Command("minisign", minisignArgs...)
+	minisign.Stdin = os.Stdin
+	minisign.Stdout = os.Stdout
+	minisign.Stderr = os.Stderr
+	if err := minisign.Run(); err != nil {
+		return fmt.Errorf("failed to sign %s: %w", util.LockfileName, err)
+	}
+
+	fmt.Printf("Signed %s\n", util.LockfileName)
+	return nil
+}
+
+// parseLockOtterfiles parses the Otterfile stack the lockfile belongs to
+func parseLockOtterfiles() (*file.OtterfileConfig, error) {
+	otterfilePaths := lockFiles
+	if len(otterfilePaths) == 0 {
+		otterfilePath, err := file.FindOtterfile()
+		if err != nil {
+			return nil, withExitCode(ExitConfig, err)
+		}
+		otterfilePaths = []string{otterfilePath}
+	}
+
+	config, err := file.ParseOtterfileStack(otterfilePaths)
+	if err != nil {
+		return nil, withExitCode(ExitConfig, err)
+	}
+	return config, nil
+}
+
+func runLockVerify(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	config, err := parseLockOtterfiles()
+	if err != nil {
+		return err
+	}
+	applicableLayers, err := config.FilterApplicableLayers()
+	if err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("failed to filter applicable layers: %w", err))
+	}
+
+	lock, err := util.LoadLockfile(filepath.Join(currentDir, util.LockfileName))
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	gitOps := util.NewGitOperations(filepath.Join(currentDir, ".otter", "cache"))
+	gitOps.Branches = config.LayerBranches()
+
+	// The exit code is that of the first problem found
+	var problems int
+	code := ExitError
+	problem := func(exitCode int, format string, args ...interface{}) {
+		fmt.Printf("  ✗ "+format+"\n", args...)
+		if problems == 0 {
+			code = exitCode
+		}
+		problems++
+	}
+
+	referenced := make(map[string]bool)
+	for _, layer := range config.Layers {
+		referenced[layer.Repository] = true
+	}
+
+	fmt.Printf("Verifying %s:\n", util.LockfileName)
+	for _, layer := range applicableLayers {
+		if !gitOps.IsRemoteLayer(layer.Repository) {
+			continue
+		}
+		if _, ok := lock.Find(layer.Repository); !ok {
+			problem(ExitConfig, "%s is not pinned; run 'otter build' to pin it", layer.Repository)
+		}
+	}
+
+	for _, locked := range lock.Layers {
+		if !referenced[locked.Repository] {
+			problem(ExitConfig, "%s is not referenced by the Otterfile; run 'otter lock tidy' to remove it", locked.Repository)
+			continue
+		}
+		if !gitOps.IsRemoteLayer(locked.Repository) {
+			continue
+		}
+
+		layerPath, err := gitOps.CloneOrUpdateLayer(locked.Repository)
+		if err != nil {
+			problem(ExitFetch, "%s could not be fetched: %v", locked.Repository, err)
+			continue
+		}
+		found, err := gitOps.HasUpstreamCommit(layerPath, locked.Commit)
+		if err != nil {
+			problem(ExitFetch, "%s: %v", locked.Repository, err)
+			continue
+		}
+		if !found {
+			problem(ExitConfig, "%s: commit %s no longer exists upstream", locked.Repository, locked.Commit)
+			continue
+		}
+		fmt.Printf("  ✓ %s @ %s\n", locked.Repository, locked.Commit[:min(8, len(locked.Commit))])
+	}
+
+	if problems > 0 {
+		return withExitCode(code, fmt.Errorf("%s has %d problem(s)", util.LockfileName, problems))
+	}
+	fmt.Printf("\n%s is up to date.\n", util.LockfileName)
+	return nil
+}
+
+func runLockTidy(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	config, err := parseLockOtterfiles()
+	if err != nil {
+		return err
+	}
+
+	lockPath := filepath.Join(currentDir, util.LockfileName)
+	before, err := os.ReadFile(lockPath)
+	if err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("failed to read lockfile %s: %w", lockPath, err))
+	}
+	lock, err := util.LoadLockfile(lockPath)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	var referenced []string
+	for _, layer := range config.Layers {
+		referenced = append(referenced, layer.Repository)
+	}
+	for _, removed := range lock.Tidy(referenced) {
+		fmt.Printf("  Removing: %s\n", removed.Repository)
+	}
+
+	if err := lock.Save(lockPath); err != nil {
+		return err
+	}
+	after, err := os.ReadFile(lockPath)
+	if err != nil {
+		return fmt.Errorf("failed to read lockfile %s: %w", lockPath, err)
+	}
+	if string(after) == string(before) {
+		fmt.Printf("%s is already tidy\n", util.LockfileName)
+		return nil
+	}
+
+	fmt.Printf("Tidied %s\n", util.LockfileName)
+	if _, err := os.Stat(lockPath + util.SignatureSuffix); err == nil {
+		fmt.Printf("Run 'otter lock sign' again; the existing signature no longer matches\n")
+	}
+	return nil
+}
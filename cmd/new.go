@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/geoffjay/otter/file"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	newFrom string
+	newVars []string
+	newYes  bool
+)
+
+var newCmd = &cobra.Command{
+	Use:   "new <dir>",
+	Short: "Create a new project from an Otterfile",
+	Long: `Create a new project directory, initialize it, fetch an Otterfile from a URL or path,
+prompt for the values of its VAR definitions, and build it.
+
+Values can be given up front with --var; with --yes, the Otterfile's defaults are used
+for any variable that isn't set.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runNew,
+}
+
+func init() {
+	newCmd.Flags().StringVar(&newFrom, "from", "", "URL or path of the Otterfile to create the project from")
+	newCmd.Flags().StringArrayVar(&newVars, "var", nil, "Set a variable without prompting (KEY=VALUE, repeatable)")
+	newCmd.Flags().BoolVarP(&newYes, "yes", "y", false, "Use default values for variables that aren't set with --var")
+	newCmd.MarkFlagRequired("from")
+}
+
+func runNew(cmd *cobra.Command, args []string) error {
+	projectDir, err := filepath.Abs(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve project directory: %w", err)
+	}
+
+	// Refuse to generate into a directory that already has content
+	if entries, err := os.ReadDir(projectDir); err == nil && len(entries) > 0 {
+		return withExitCode(ExitConfig, fmt.Errorf("%s already exists and is not empty", projectDir))
+	}
+
+	values := make(map[string]string)
+	for _, assignment := range newVars {
+		key, value, ok := strings.Cut(assignment, "=")
+		if !ok || key == "" {
+			return withExitCode(ExitConfig, fmt.Errorf("--var must be in format 'KEY=VALUE', got: %s", assignment))
+		}
+		values[key] = value
+	}
+
+	content, err := fetchOtterfile(newFrom)
+	if err != nil {
+		return withExitCode(ExitFetch, err)
+	}
+
+	// Prompt for each variable the Otterfile declares, offering its value as the default
+	definitions := file.ListVariables(content)
+	if len(definitions) > 0 && !newYes {
+		fmt.Printf("Project variables (press enter to accept the default):\n")
+	}
+	reader := bufio.NewReader(os.Stdin)
+	for _, definition := range definitions {
+		if _, set := values[definition.Name]; set || newYes {
+			continue
+		}
+		// A required variable without a default shows what it needs instead, and PROMPT its question
+		label := definition.Name
+		if definition.Prompt != "" {
+			label = definition.Prompt
+		}
+		hint := " [" + definition.Value + "]"
+		switch {
+		case definition.Required && definition.Value == "":
+			hint = " (required)"
+		case definition.Prompt != "" && definition.Value == "":
+			hint = ""
+		}
+		if len(definition.Allowed) > 0 {
+			hint += " (one of " + strings.Join(definition.Allowed, ", ") + ")"
+		}
+		fmt.Printf("  %s%s: ", label, hint)
+		answer, err := reader.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("failed to read value for %s: %w", definition.Name, err)
+		}
+		if answer = strings.TrimSpace(answer); answer != "" {
+			values[definition.Name] = answer
+		}
+	}
+
+	if err := os.MkdirAll(projectDir, 0755); err != nil {
+		return fmt.Errorf("failed to create project directory: %w", err)
+	}
+
+	otterfilePath := filepath.Join(projectDir, "Otterfile")
+	if err := os.WriteFile(otterfilePath, []byte(file.SetVariables(content, values)), 0644); err != nil {
+		return fmt.Errorf("failed to write Otterfile: %w", err)
+	}
+	fmt.Printf("Created %s from %s\n", otterfilePath, newFrom)
+
+	if err := initProject(projectDir); err != nil {
+		return err
+	}
+
+	// Conditions and hooks are evaluated relative to the working directory
+	if err := os.Chdir(projectDir); err != nil {
+		return fmt.Errorf("failed to enter project directory: %w", err)
+	}
+
+	return executeBuild(buildOptions{
+		ProjectDir:     projectDir,
+		OtterfilePaths: []string{otterfilePath},
+		Force:          true,
+		Yes:            newYes,
+		Prompt:         !newYes,
+		Operation:      "new",
+	})
+}
+
+// terminalPrompter asks for the values of PROMPT variables on the terminal, asking again until
+// there is an answer when the variable has no default
+func terminalPrompter() file.Prompter {
+	reader := bufio.NewReader(os.Stdin)
+	return func(name, question, defaultValue string, hasDefault bool) (string, error) {
+		hint := ""
+		if hasDefault {
+			hint = " [" + defaultValue + "]"
+		}
+		for {
+			fmt.Printf("%s%s ", question, hint)
+			answer, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return "", fmt.Errorf("failed to read value for %s: %w", name, err)
+			}
+			if answer = strings.TrimSpace(answer); answer != "" {
+				return answer, nil
+			}
+			if hasDefault {
+				return defaultValue, nil
+			}
+			if err == io.EOF {
+				return "", fmt.Errorf("no value given for %s", name)
+			}
+		}
+	}
+}
+
+// fetchOtterfile reads an Otterfile from an http(s) URL or a local path
+func fetchOtterfile(source string) (string, error) {
+	return fetchSource(source, "Otterfile")
+}
+
+// fetchSource reads a file, described by what in errors, from an http(s) URL or a local path
+func fetchSource(source, what string) (string, error) {
+	if !strings.HasPrefix(source, "http://") && !strings.HasPrefix(source, "https://") {
+		content, err := os.ReadFile(strings.TrimPrefix(source, "file://"))
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", what, err)
+		}
+		return string(content), nil
+	}
+
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(source)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch %s: %w", what, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch %s from %s: %s", what, source, resp.Status)
+	}
+
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", what, err)
+	}
+	return string(content), nil
+}
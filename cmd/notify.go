@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	notifyFiles    []string
+	notifyWebhooks []string
+	notifyFormat   string
+)
+
+var notifyCmd = &cobra.Command{
+	Use:   "notify",
+	Short: "Check layers for new commits and send a notification when updates are available",
+	Long: `Fetch every remote layer of the Otterfile into the cache and compare its latest commit with
+the one pinned in Otterfile.lock. Nothing is applied to the project, so it's safe to run from
+cron or CI.
+
+A summary is always printed. When updates are available, the notification is also posted to each
+webhook passed with --webhook or listed in notify.webhooks in .otter/config.json, either as JSON
+or as a Slack-compatible message.`,
+	RunE: runNotify,
+}
+
+func init() {
+	notifyCmd.Flags().StringArrayVarP(&notifyFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	notifyCmd.Flags().StringArrayVar(&notifyWebhooks, "webhook", nil, "Post the notification to this URL when updates are available; repeat for several")
+	notifyCmd.Flags().StringVar(&notifyFormat, "format", util.WebhookFormatJSON, "Payload format for --webhook: json or slack")
+}
+
+func runNotify(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := projectConfig.Notify.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if err := projectConfig.Fetch.Validate(); err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	cacheBackend, err := util.NewCacheBackend(projectConfig.Cache, currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	webhooks := projectConfig.Notify.Webhooks
+	for _, url := range notifyWebhooks {
+		webhook := util.WebhookConfig{URL: url, Format: notifyFormat}
+		if err := webhook.Validate(); err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+
+	config, err := parseOtterfiles(notifyFiles)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	lock, err := util.LoadLockfile(filepath.Join(currentDir, util.LockfileName))
+	if err != nil {
+		return withExitCode(ExitConfig, fmt.Errorf("%w; run 'otter build' to pin the layers first", err))
+	}
+
+	gitOps := util.NewGitOperations(filepath.Join(currentDir, ".otter", "cache"))
+	gitOps.Limiter = util.NewHostLimiter(projectConfig.Fetch)
+	gitOps.FailOnForcePush = projectConfig.Fetch.ForcePushed == util.ForcePushedFail
+	gitOps.DefaultBranch = projectConfig.Fetch.DefaultBranch
+	gitOps.Branches = config.LayerBranches()
+	gitOps.Cache = cacheBackend
+	var repositories []string
+	checked := make(map[string]bool)
+	for _, layer := range config.Layers {
+		if checked[layer.Repository] || !gitOps.IsRemoteLayer(layer.Repository) {
+			continue
+		}
+		checked[layer.Repository] = true
+		// Frozen layers are meant to stay behind upstream
+		if layer.Frozen {
+			fmt.Printf("Skipping frozen layer: %s\n", layer.Repository)
+			continue
+		}
+		repositories = append(repositories, layer.Repository)
+	}
+
+	notification := util.NewUpdateNotification(filepath.Base(currentDir))
+	var failed int
+	for i, result := range gitOps.FetchLayers(repositories, util.LogOutputBuffered) {
+		repository := repositories[i]
+		if result.Err != nil {
+			fmt.Printf("  ✗ %s could not be fetched: %v\n", repository, result.Err)
+			failed++
+			continue
+		}
+		latest, err := gitOps.GetRepositoryCommit(result.Path)
+		if err != nil {
+			fmt.Printf("  ✗ %s: %v\n", repository, err)
+			failed++
+			continue
+		}
+
+		locked, _ := lock.Find(repository)
+		if locked.Commit != latest {
+			notification.Updates = append(notification.Updates, util.LayerUpdate{
+				Repository: repository,
+				Locked:     locked.Commit,
+				Latest:     latest,
+			})
+		}
+	}
+
+	summary := notification.Summary()
+	fmt.Printf("\n%s\n", summary)
+
+	if len(notification.Updates) > 0 {
+		for _, webhook := range webhooks {
+			if err := webhook.Send(notification, summary); err != nil {
+				return err
+			}
+			fmt.Printf("Sent notification to %s\n", webhook.URL)
+		}
+	}
+
+	if failed > 0 {
+		return withExitCode(ExitFetch, fmt.Errorf("%d layer(s) could not be checked", failed))
+	}
+	return nil
+}
@@ -0,0 +1,143 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	planFiles   []string
+	planOutput  string
+	planLocked  bool
+	planNoPrune bool
+)
+
+var planCmd = &cobra.Command{
+	Use:   "plan",
+	Short: "Work out a build without changing the project and save it for otter apply",
+	Long: `Fetch and render every applicable layer like otter build, but record the resulting file
+changes and hook commands in a plan file instead of making and running them. Review the plan,
+then run otter apply --plan <file> to carry out exactly those changes.
+
+The plan includes the content of every file it writes. GENERATE commands of generator layers run
+while planning, since their output is what the layer copies.`,
+	RunE: runPlan,
+}
+
+func init() {
+	planCmd.Flags().StringArrayVarP(&planFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	planCmd.Flags().StringVarP(&planOutput, "output", "o", util.DefaultPlanFile, "File to write the plan to")
+	planCmd.Flags().BoolVar(&planLocked, "locked", false, "Plan layers at the commits pinned in Otterfile.lock")
+	planCmd.Flags().BoolVar(&planNoPrune, "no-prune", false, "Report files that layers no longer provide instead of planning to delete them")
+}
+
+func runPlan(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	plan := util.NewPlan(Version)
+	err = executeBuild(buildOptions{
+		ProjectDir:     currentDir,
+		OtterfilePaths: planFiles,
+		Force:          true,
+		Locked:         planLocked,
+		Yes:            true,
+		Operation:      "plan",
+		NoPrune:        planNoPrune,
+		Plan:           plan,
+	})
+	if err != nil {
+		return err
+	}
+
+	outputPath := planOutput
+	if !filepath.IsAbs(outputPath) {
+		outputPath = filepath.Join(currentDir, outputPath)
+	}
+	if err := plan.Save(outputPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("\nPlan:\n")
+	printPlan(plan)
+
+	approvals, err := planApprovals(currentDir, plan)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if len(approvals) > 0 {
+		fmt.Printf("\nNeeds approval when applied:\n")
+		for _, approval := range approvals {
+			fmt.Printf("  %s (%s)\n", approval, approval.Reason)
+		}
+	}
+	fmt.Printf("\nSaved plan to %s; run 'otter apply --plan %s' to apply it\n", outputPath, planOutput)
+	return nil
+}
+
+// printPlan lists the steps of a plan: + created, ~ overwritten, and - deleted files, and $ hook commands
+func printPlan(plan *util.Plan) {
+	marks := map[string]string{"create": "+", "overwrite": "~", "delete": "-"}
+	counts := make(map[string]int)
+	hooks := 0
+
+	for _, step := range plan.Steps {
+		for _, file := range step.Files {
+			fmt.Printf("  %s %s\n", marks[file.Action], file.Path)
+			counts[file.Action]++
+		}
+		if len(step.Commands) == 0 {
+			continue
+		}
+		source := step.Hook
+		if len(step.Layers) > 0 {
+			source += " " + strings.Join(step.Layers, ", ")
+		}
+		for _, command := range step.Commands {
+			fmt.Printf("  $ %s (%s)\n", command, source)
+			hooks++
+		}
+	}
+
+	var summary []string
+	summary = append(summary, fmt.Sprintf("%d to create", counts["create"]))
+	summary = append(summary, fmt.Sprintf("%d to overwrite", counts["overwrite"]))
+	summary = append(summary, fmt.Sprintf("%d to delete", counts["delete"]))
+	summary = append(summary, fmt.Sprintf("%d hook command(s)", hooks))
+	fmt.Printf("\n%s\n", strings.Join(summary, ", "))
+}
+
+// planApprovals returns the changes in a plan that need approval, judged against the project's
+// manifest, lockfile, and the hook commands its last build or apply ran
+func planApprovals(projectDir string, plan *util.Plan) ([]util.PlanApproval, error) {
+	manifest, err := util.LoadFileManifest(util.NewOSFileSystem(), filepath.Join(projectDir, ".otter", util.FileManifestName))
+	if err != nil {
+		return nil, err
+	}
+	var lock *util.Lockfile
+	lockPath := filepath.Join(projectDir, util.LockfileName)
+	if _, statErr := os.Stat(lockPath); statErr == nil {
+		if lock, err = util.LoadLockfile(lockPath); err != nil {
+			return nil, err
+		}
+	}
+	ran, err := util.LoadHookRecord(util.NewOSFileSystem(), filepath.Join(projectDir, ".otter", util.HookRecordFileName))
+	if err != nil {
+		return nil, err
+	}
+	return plan.Approvals(manifest, lock, ran), nil
+}
+
+// recordHooks saves the hook and ON_ERROR commands of a build or apply as the ones later plans run
+// without approval
+func recordHooks(fsys util.FileSystem, projectDir string, commands []string) error {
+	return util.NewHookRecord(commands).Save(fsys, filepath.Join(projectDir, ".otter", util.HookRecordFileName))
+}
@@ -0,0 +1,108 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	reportAggregate []string
+	reportFormat    string
+)
+
+var reportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report which layers and versions projects use, from their audit logs",
+	Long: `Report the layers each project applied in its latest successful build, grouped by layer and
+the version it is pinned to, from audit logs and build notifications collected from many
+projects. Projects pinned to an older version of a layer than another project uses are marked
+outdated.
+
+--aggregate takes directories, which are searched for audit.log, *.json, and *.jsonl files,
+files, or globs; repeat it to read several. An audit log is attributed to the directory of its
+project, or to its file name, e.g. billing for billing.jsonl; build notifications name their
+project. Without --aggregate, the audit log of the current project is read.
+
+Only local files are read; nothing is sent anywhere.`,
+	Args: cobra.NoArgs,
+	RunE: runReport,
+}
+
+func init() {
+	reportCmd.Flags().StringArrayVar(&reportAggregate, "aggregate", nil, "Directory, file, or glob of audit logs and build notifications to read (repeatable)")
+	reportCmd.Flags().StringVar(&reportFormat, "format", "text", "Output format: text or json")
+}
+
+func runReport(cmd *cobra.Command, args []string) error {
+	if reportFormat != "text" && reportFormat != "json" {
+		return withExitCode(ExitConfig, fmt.Errorf("unknown format %q; use text or json", reportFormat))
+	}
+
+	sources := reportAggregate
+	if len(sources) == 0 {
+		currentDir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("failed to get current directory: %w", err)
+		}
+		sources = []string{filepath.Join(currentDir, ".otter", util.AuditLogFileName)}
+	}
+
+	report, err := util.AggregateUsage(sources)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	if reportFormat == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return fmt.Errorf("failed to encode report: %w", err)
+		}
+		fmt.Println(string(data))
+		return nil
+	}
+
+	fmt.Printf("Layers used by %d project(s) in their latest successful builds, from %d audit entries\n", report.Projects, report.Reports)
+	for _, layer := range report.Layers {
+		projects := 0
+		for _, version := range layer.Versions {
+			projects += len(version.Projects)
+		}
+		fmt.Printf("\n%s (%d project(s))\n", layer.Repository, projects)
+		for _, version := range layer.Versions {
+			ref := version.Ref
+			if ref == "" {
+				ref = "unpinned"
+			}
+			names := make([]string, len(version.Projects))
+			for i, project := range version.Projects {
+				names[i] = project.Project
+				if version.Ref == "" && len(project.Commit) >= 7 {
+					names[i] += " at " + project.Commit[:7]
+				}
+			}
+			fmt.Printf("  %s: %s", ref, strings.Join(names, ", "))
+			if version.Outdated {
+				fmt.Printf(" (outdated; %s is the newest in use)", layer.Latest)
+			}
+			fmt.Println()
+		}
+	}
+
+	if outdated := report.Outdated(); outdated > 0 {
+		fmt.Printf("\n%d project(s) pin an outdated version of a layer\n", outdated)
+	}
+	if len(report.Skipped) > 0 {
+		fmt.Printf("\nSkipped %d file(s) that aren't audit logs or build notifications:\n", len(report.Skipped))
+		for _, name := range report.Skipped {
+			fmt.Printf("  %s\n", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,471 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	serveAddr         string
+	serveToken        string
+	serveAuditLog     string
+	serveMetrics      bool
+	serveStatsd       string
+	serveStatsdPrefix string
+)
+
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Run a local server that performs fetch and apply operations",
+	Long: `Run a long-lived local HTTP server that performs layer fetch and apply operations
+on behalf of CLI or editor clients. Keeping a single process alive amortizes startup and
+clone costs across many quick invocations.
+
+The server runs the same engine as the CLI, which can use it with otter build --engine.
+
+Endpoints:
+  GET  /v1/health     Report that the server is running
+  POST /v1/validate   Parse an Otterfile and report errors
+  POST /v1/plan       List the layers a build would apply and where
+  POST /v1/fetch      Clone or update a layer into a project's cache
+  POST /v1/apply      Build a project by applying its Otterfile
+  GET  /metrics       Build and fetch metrics in the Prometheus text format (with --metrics or --statsd)
+
+When a token is configured (--token or OTTER_SERVE_TOKEN), every request other than
+the health check must send it as "Authorization: Bearer <token>". A token is required
+when listening on an address other than loopback. Requests must be JSON, and requests from
+web pages on other origins are rejected.
+
+Metrics cover build duration and failures by exit code class, and the time and cache hits
+of layer fetches by host. Besides the /metrics endpoint, they can be sent to statsd with
+--statsd (default: $OTTER_STATSD_ADDR).`,
+	RunE: runServe,
+}
+
+func init() {
+	serveCmd.Flags().StringVar(&serveAddr, "addr", "127.0.0.1:7717", "Address to listen on")
+	serveCmd.Flags().StringVar(&serveToken, "token", "", "Bearer token required by API requests (default: $OTTER_SERVE_TOKEN)")
+	serveCmd.Flags().StringVar(&serveAuditLog, "audit-log", "", "Append a JSON line per API request to this file")
+	serveCmd.Flags().BoolVar(&serveMetrics, "metrics", false, "Serve build and fetch metrics for Prometheus at /metrics")
+	serveCmd.Flags().StringVar(&serveStatsd, "statsd", "", "Send build and fetch metrics to this statsd host:port (default: $OTTER_STATSD_ADDR)")
+	serveCmd.Flags().StringVar(&serveStatsdPrefix, "statsd-prefix", defaultStatsdPrefix, "Prefix of the metric names sent to statsd")
+}
+
+// plannedLayer describes a layer in the response of the plan endpoint
+type plannedLayer struct {
+	Repository string `json:"repository"`
+	Target     string `json:"target"`
+	Condition  string `json:"condition,omitempty"`
+	Apply      bool   `json:"apply"`
+}
+
+// serveResponse is the body returned by every endpoint
+type serveResponse struct {
+	Status string         `json:"status"`
+	Path   string         `json:"path,omitempty"`
+	Commit string         `json:"commit,omitempty"`
+	Layers []plannedLayer `json:"layers,omitempty"`
+	Error  string         `json:"error,omitempty"`
+	// ExitCode is the exit code the CLI would return for the error
+	ExitCode int `json:"exit_code,omitempty"`
+}
+
+// auditEntry is a single line of the server audit log
+type auditEntry struct {
+	Time     time.Time `json:"time"`
+	Remote   string    `json:"remote"`
+	Endpoint string    `json:"endpoint"`
+	Project  string    `json:"project,omitempty"`
+	Status   int       `json:"status"`
+	Duration string    `json:"duration"`
+}
+
+// server exposes the in-process engine over HTTP
+type server struct {
+	engine *localEngine
+	// token, when set, must be presented as a bearer token
+	token string
+	// loopback is set when the server only listens on the loopback interface, so requests must
+	// name a loopback Host, which keeps DNS rebinding from reaching it
+	loopback bool
+	// auditMu guards writes to auditLog
+	auditMu  sync.Mutex
+	auditLog *os.File
+}
+
+func newServer(addr, token string, t telemetry) *server {
+	return &server{
+		engine:   newLocalEngine(t),
+		token:    token,
+		loopback: isLoopbackAddr(addr),
+	}
+}
+
+func runServe(cmd *cobra.Command, args []string) error {
+	token := serveToken
+	if token == "" {
+		token = os.Getenv("OTTER_SERVE_TOKEN")
+	}
+	statsdAddr := serveStatsd
+	if statsdAddr == "" {
+		statsdAddr = os.Getenv("OTTER_STATSD_ADDR")
+	}
+	var metrics *util.Metrics
+	if serveMetrics || statsdAddr != "" {
+		metrics = util.NewMetrics()
+	}
+	if statsdAddr != "" {
+		if err := metrics.EnableStatsd(statsdAddr, serveStatsdPrefix); err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		defer metrics.Close()
+	}
+	tracer, err := envTracer()
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	srv := newServer(serveAddr, token, telemetry{metrics: metrics, tracer: tracer})
+
+	if serveAuditLog != "" {
+		auditFile, err := os.OpenFile(serveAuditLog, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer auditFile.Close()
+		srv.auditLog = auditFile
+	}
+
+	// Builds run hooks, so anyone who can reach the server can run commands as this user
+	if token == "" && !isLoopbackAddr(serveAddr) {
+		return withExitCode(ExitConfig, fmt.Errorf("serving on %s requires an auth token (--token or OTTER_SERVE_TOKEN)", serveAddr))
+	}
+
+	fmt.Printf("Otter server listening on http://%s\n", serveAddr)
+	return http.ListenAndServe(serveAddr, srv.routes())
+}
+
+// routes registers the server endpoints
+func (s *server) routes() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/health", s.handleHealth)
+	mux.Handle("/v1/validate", s.authorized(s.handleValidate))
+	mux.Handle("/v1/plan", s.authorized(s.handlePlan))
+	mux.Handle("/v1/fetch", s.authorized(s.handleFetch))
+	mux.Handle("/v1/apply", s.authorized(s.handleApply))
+	if s.engine.telemetry.metrics != nil {
+		mux.Handle("/metrics", s.authorized(s.handleMetrics))
+	}
+	return s.audited(s.local(mux))
+}
+
+// local rejects requests from web pages: those a browser sent from another origin, and those
+// that reached a loopback server through a host name that isn't loopback, as DNS rebinding does
+func (s *server) local(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if u, err := url.Parse(origin); err != nil || !isLoopbackHost(u.Host) {
+				writeJSON(w, http.StatusForbidden, serveResponse{Status: "error", Error: "cross-origin requests are not allowed"})
+				return
+			}
+		}
+		if s.loopback && !isLoopbackHost(r.Host) {
+			writeJSON(w, http.StatusForbidden, serveResponse{Status: "error", Error: "requests must be addressed to a loopback host"})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authorized rejects requests that do not carry the configured bearer token
+func (s *server) authorized(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.token != "" {
+			presented := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(presented), []byte(s.token)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, serveResponse{Status: "error", Error: "unauthorized"})
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+// audited records every request in the audit log when one is configured
+func (s *server) audited(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.auditLog == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// Peek at the body to record which project the request targeted
+		var target struct {
+			Project string `json:"project"`
+		}
+		if body, err := io.ReadAll(r.Body); err == nil {
+			json.Unmarshal(body, &target)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r)
+
+		s.writeAudit(auditEntry{
+			Time:     start.UTC(),
+			Remote:   r.RemoteAddr,
+			Endpoint: r.URL.Path,
+			Project:  target.Project,
+			Status:   recorder.status,
+			Duration: time.Since(start).String(),
+		})
+	})
+}
+
+// writeAudit appends an entry to the audit log
+func (s *server) writeAudit(entry auditEntry) {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	s.auditMu.Lock()
+	defer s.auditMu.Unlock()
+	s.auditLog.Write(append(data, '\n'))
+}
+
+// statusRecorder captures the status code written by a handler
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// isLoopbackAddr reports whether addr only listens on the loopback interface
+func isLoopbackAddr(addr string) bool {
+	return strings.HasPrefix(addr, "127.") || strings.HasPrefix(addr, "localhost:") || strings.HasPrefix(addr, "[::1]:")
+}
+
+// isLoopbackHost reports whether a host[:port] from a Host or Origin header names this machine
+func isLoopbackHost(hostport string) bool {
+	host := hostport
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.Trim(host, "[]")
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func (s *server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, serveResponse{Status: "ok"})
+}
+
+func (s *server) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	s.engine.telemetry.metrics.WritePrometheus(w)
+}
+
+func (s *server) handleValidate(w http.ResponseWriter, r *http.Request) {
+	var req engineRequest
+	if !decodeRequest(w, r, &req) || !checkRequestFiles(w, req) {
+		return
+	}
+
+	if err := s.engine.Validate(req); err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "invalid", "", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, serveResponse{Status: "ok"})
+}
+
+func (s *server) handlePlan(w http.ResponseWriter, r *http.Request) {
+	var req engineRequest
+	if !decodeRequest(w, r, &req) || !checkRequestFiles(w, req) {
+		return
+	}
+
+	layers, err := s.engine.Plan(req)
+	if err != nil {
+		writeError(w, http.StatusUnprocessableEntity, "error", "", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, serveResponse{Status: "ok", Layers: layers})
+}
+
+func (s *server) handleFetch(w http.ResponseWriter, r *http.Request) {
+	var req fetchRequest
+	if !decodeRequest(w, r, &req) {
+		return
+	}
+	if req.Repository == "" {
+		writeJSON(w, http.StatusBadRequest, serveResponse{Status: "error", Error: "repository is required", ExitCode: ExitConfig})
+		return
+	}
+	projectDir, err := resolveProjectDir(req.Project)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "error", "", withExitCode(ExitConfig, err))
+		return
+	}
+	// Fetching into a directory otter doesn't manage would make it look initialized to a build
+	if stat, err := os.Stat(filepath.Join(projectDir, ".otter")); err != nil || !stat.IsDir() {
+		writeJSON(w, http.StatusBadRequest, serveResponse{Status: "error", Error: ".otter directory not found. Please run 'otter init' first", ExitCode: ExitConfig})
+		return
+	}
+
+	result, err := s.engine.Fetch(req)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "error", "", err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, serveResponse{Status: "ok", Path: result.Path, Commit: result.Commit})
+}
+
+func (s *server) handleApply(w http.ResponseWriter, r *http.Request) {
+	var req engineRequest
+	if !decodeRequest(w, r, &req) || !checkRequestFiles(w, req) {
+		return
+	}
+
+	projectDir, err := resolveProjectDir(req.Project)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "error", "", withExitCode(ExitConfig, err))
+		return
+	}
+
+	// Targets outside the project and key files are paths on the server, which clients don't choose
+	if req.TargetSSH != "" || req.TargetContainer != "" || req.VerifyKey != "" {
+		writeJSON(w, http.StatusBadRequest, serveResponse{Status: "error", Error: "target_ssh, target_container, and verify_key are not accepted by the server", ExitCode: ExitConfig})
+		return
+	}
+
+	// The server has no terminal to prompt on, so overwrites and size limits need force and yes
+	req.Project = projectDir
+	if req.TraceParent == "" {
+		req.TraceParent = r.Header.Get("traceparent")
+	}
+	req.Unattended = true
+	if err := s.engine.Build(req); err != nil {
+		writeError(w, http.StatusInternalServerError, "error", projectDir, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, serveResponse{Status: "ok", Path: projectDir})
+}
+
+// resolveProjectDir validates the project directory of a request
+func resolveProjectDir(project string) (string, error) {
+	if project == "" {
+		return "", fmt.Errorf("project is required")
+	}
+
+	absPath, err := filepath.Abs(project)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve project path %s: %w", project, err)
+	}
+
+	if stat, err := os.Stat(absPath); err != nil || !stat.IsDir() {
+		return "", fmt.Errorf("project directory does not exist: %s", absPath)
+	}
+
+	return absPath, nil
+}
+
+// checkRequestFiles rejects Otterfiles that aren't the project's own: absolute paths, paths that
+// lead outside the project, and files in .otter, where fetched layers are cached
+func checkRequestFiles(w http.ResponseWriter, req engineRequest) bool {
+	files := req.Files
+	if req.File != "" {
+		files = append([]string{req.File}, files...)
+	}
+	for _, name := range files {
+		if !projectFile(req.Project, name) {
+			writeJSON(w, http.StatusBadRequest, serveResponse{Status: "error", Error: fmt.Sprintf("Otterfile %s must be a path inside the project, outside .otter", name), ExitCode: ExitConfig})
+			return false
+		}
+	}
+	return true
+}
+
+// projectFile reports whether name is relative to the project and stays inside it, outside .otter,
+// once symlinks are followed
+func projectFile(project, name string) bool {
+	if !filepath.IsLocal(name) {
+		return false
+	}
+	projectDir, err := filepath.EvalSymlinks(project)
+	if err != nil {
+		// The project is checked when the request is handled; the name on its own is fine
+		return !inOtterDir(name)
+	}
+	resolved, err := filepath.EvalSymlinks(filepath.Join(projectDir, name))
+	if err != nil {
+		return !inOtterDir(name)
+	}
+	relative, err := filepath.Rel(projectDir, resolved)
+	return err == nil && filepath.IsLocal(relative) && !inOtterDir(relative)
+}
+
+// inOtterDir reports whether a path relative to the project is in its .otter directory
+func inOtterDir(name string) bool {
+	clean := filepath.ToSlash(filepath.Clean(name))
+	return clean == ".otter" || strings.HasPrefix(clean, ".otter/")
+}
+
+// decodeRequest decodes a JSON POST body, writing an error response on failure. Requiring a JSON
+// content type keeps browsers from sending requests without a CORS preflight, which the server fails.
+func decodeRequest(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Method != http.MethodPost {
+		writeJSON(w, http.StatusMethodNotAllowed, serveResponse{Status: "error", Error: "method not allowed"})
+		return false
+	}
+	if mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediaType != "application/json" {
+		writeJSON(w, http.StatusUnsupportedMediaType, serveResponse{Status: "error", Error: "Content-Type must be application/json"})
+		return false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		writeJSON(w, http.StatusBadRequest, serveResponse{Status: "error", Error: fmt.Sprintf("invalid request body: %v", err)})
+		return false
+	}
+
+	return true
+}
+
+// writeError writes an error response carrying the exit code of err
+func writeError(w http.ResponseWriter, status int, result, path string, err error) {
+	writeJSON(w, status, serveResponse{Status: result, Path: path, Error: err.Error(), ExitCode: exitCode(err)})
+}
+
+// writeJSON writes a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// serveRequest sends a POST to a loopback server the way otter build --engine does, with headers
+// replaced or added from header
+func serveRequest(s *server, endpoint, body string, header map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, endpoint, strings.NewReader(body))
+	r.Host = "127.0.0.1:7717"
+	r.Header.Set("Content-Type", "application/json")
+	for name, value := range header {
+		if name == "Host" {
+			r.Host = value
+			continue
+		}
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.routes().ServeHTTP(w, r)
+	return w
+}
+
+func TestServeRejectsBrowserRequests(t *testing.T) {
+	s := newServer("127.0.0.1:7717", "", telemetry{})
+	body := `{"content": "LAYER ./layer"}`
+
+	tests := []struct {
+		name   string
+		header map[string]string
+		status int
+	}{
+		{"json from the CLI", nil, http.StatusOK},
+		{"json with charset", map[string]string{"Content-Type": "application/json; charset=utf-8"}, http.StatusOK},
+		{"loopback origin", map[string]string{"Origin": "http://localhost:3000"}, http.StatusOK},
+		{"simple form post", map[string]string{"Content-Type": "text/plain"}, http.StatusUnsupportedMediaType},
+		{"no content type", map[string]string{"Content-Type": ""}, http.StatusUnsupportedMediaType},
+		{"cross-origin page", map[string]string{"Origin": "https://attacker.example"}, http.StatusForbidden},
+		{"opaque origin", map[string]string{"Origin": "null"}, http.StatusForbidden},
+		{"dns rebinding", map[string]string{"Host": "attacker.example:7717"}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := serveRequest(s, "/v1/validate", body, tt.header); w.Code != tt.status {
+				t.Errorf("Expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+			}
+		})
+	}
+
+	// A server listening on every interface requires a token instead, so any Host is accepted
+	remote := newServer("0.0.0.0:7717", "secret", telemetry{})
+	header := map[string]string{"Host": "devbox.example:7717", "Authorization": "Bearer secret"}
+	if w := serveRequest(remote, "/v1/validate", body, header); w.Code != http.StatusOK {
+		t.Errorf("Expected a request to a non-loopback server to be accepted, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestServeRejectsFilesOutsideProject(t *testing.T) {
+	projectDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "Otterfile")
+	for _, path := range []string{
+		filepath.Join(projectDir, "Otterfile"),
+		filepath.Join(projectDir, ".otter", "cache", "tools-0123abcd", "Otterfile"),
+		outside,
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte("LAYER ./layer\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(outside, filepath.Join(projectDir, "Linked")); err != nil {
+		t.Fatal(err)
+	}
+
+	s := newServer("127.0.0.1:7717", "", telemetry{})
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{"project Otterfile", `{"file": "Otterfile"}`, http.StatusOK},
+		{"absolute path", `{"file": "` + outside + `"}`, http.StatusBadRequest},
+		{"parent directory", `{"file": "../Otterfile"}`, http.StatusBadRequest},
+		{"fetched layer", `{"file": ".otter/cache/tools-0123abcd/Otterfile"}`, http.StatusBadRequest},
+		{"symlink out of the project", `{"file": "Linked"}`, http.StatusBadRequest},
+		{"stacked file", `{"files": ["Otterfile", "../Otterfile"]}`, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := `{"project": "` + projectDir + `", ` + strings.TrimPrefix(tt.body, "{")
+			for _, endpoint := range []string{"/v1/validate", "/v1/plan"} {
+				if w := serveRequest(s, endpoint, body, nil); w.Code != tt.status {
+					t.Errorf("%s: expected status %d, got %d: %s", endpoint, tt.status, w.Code, w.Body)
+				}
+			}
+			if tt.status != http.StatusOK {
+				if w := serveRequest(s, "/v1/apply", body, nil); w.Code != tt.status {
+					t.Errorf("/v1/apply: expected status %d, got %d: %s", tt.status, w.Code, w.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestServeFetchRequiresInitializedProject(t *testing.T) {
+	projectDir := t.TempDir()
+	s := newServer("127.0.0.1:7717", "", telemetry{})
+
+	body := `{"project": "` + projectDir + `", "repository": "https://example.com/tools.git"}`
+	if w := serveRequest(s, "/v1/fetch", body, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("Expected fetch into an uninitialized directory to be rejected, got %d: %s", w.Code, w.Body)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, ".otter")); !os.IsNotExist(err) {
+		t.Errorf("Expected no .otter directory to be created, got %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/geoffjay/otter/file"
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	suggestFile  string
+	suggestApply bool
+)
+
+var suggestCmd = &cobra.Command{
+	Use:   "suggest",
+	Short: "Suggest layers for the project in the current directory",
+	Long: `Look at the project in the current directory (go.mod, package.json, pyproject.toml, a
+Dockerfile, CI configuration, and so on) and suggest layers it doesn't apply yet.
+
+Besides the layers otter knows about, projects can list their own in the suggest section of
+.otter/config.json. With --apply, the suggested layers are added to the Otterfile, which is
+created when there isn't one.`,
+	RunE: runSuggest,
+}
+
+func init() {
+	suggestCmd.Flags().StringVarP(&suggestFile, "file", "f", "", "Specify the Otterfile/Envfile to use (default: auto-detect)")
+	suggestCmd.Flags().BoolVar(&suggestApply, "apply", false, "Add the suggested layers to the Otterfile")
+}
+
+func runSuggest(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	// Layers the Otterfile already applies, under any condition, aren't suggested again
+	otterfilePath := suggestFile
+	if otterfilePath == "" {
+		if otterfilePath, err = file.FindOtterfile(); err != nil {
+			otterfilePath = ""
+		}
+	}
+	var applied []string
+	if otterfilePath != "" {
+		config, err := file.ParseOtterfile(otterfilePath)
+		if err != nil {
+			return withExitCode(ExitConfig, fmt.Errorf("failed to parse %s: %w", otterfilePath, err))
+		}
+		for _, layer := range config.Layers {
+			applied = append(applied, layer.Repository)
+		}
+	}
+
+	if traits := util.ProjectTraits(currentDir); len(traits) > 0 {
+		fmt.Printf("Detected: %s\n", strings.Join(traits, ", "))
+	}
+
+	rules := append(append([]util.SuggestionRule(nil), projectConfig.Suggest...), util.DefaultSuggestionRules...)
+	suggestions := util.SuggestLayers(currentDir, rules, applied)
+	if len(suggestions) == 0 {
+		fmt.Println("No layers to suggest.")
+		return nil
+	}
+
+	fmt.Printf("\nSuggested layers:\n")
+	for _, suggestion := range suggestions {
+		fmt.Printf("  %s (%s)\n", suggestion.Line(), suggestion.Reason)
+	}
+
+	if !suggestApply {
+		fmt.Printf("\nRun 'otter suggest --apply' to add them to the Otterfile\n")
+		return nil
+	}
+
+	if otterfilePath == "" {
+		otterfilePath = "Otterfile"
+	}
+	if err := appendSuggestions(otterfilePath, suggestions); err != nil {
+		return err
+	}
+	fmt.Printf("\nAdded %d layer(s) to %s; run 'otter build' to apply them\n", len(suggestions), otterfilePath)
+	return nil
+}
+
+// appendSuggestions adds the suggested layers to the end of an Otterfile, creating it if needed
+func appendSuggestions(otterfilePath string, suggestions []util.LayerSuggestion) error {
+	content, err := os.ReadFile(otterfilePath)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", otterfilePath, err)
+	}
+
+	var builder strings.Builder
+	builder.Write(content)
+	if len(content) > 0 {
+		if content[len(content)-1] != '\n' {
+			builder.WriteString("\n")
+		}
+		builder.WriteString("\n")
+	}
+	builder.WriteString("# Added by otter suggest\n")
+	for _, suggestion := range suggestions {
+		fmt.Fprintf(&builder, "# %s\n%s\n", suggestion.Reason, suggestion.Line())
+	}
+
+	if err := os.WriteFile(otterfilePath, []byte(builder.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", otterfilePath, err)
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/geoffjay/otter/util"
+)
+
+// telemetry is where the in-process engine reports metrics and traces; nil fields report nothing
+type telemetry struct {
+	metrics *util.Metrics
+	tracer  *util.Tracer
+}
+
+// defaultStatsdPrefix starts the name of every metric sent to statsd
+const defaultStatsdPrefix = "otter"
+
+// envMetrics returns metrics sent to the statsd server in OTTER_STATSD_ADDR, so CI builds can
+// report to a fleet-wide collector, or nil when it's unset
+func envMetrics() (*util.Metrics, error) {
+	addr := os.Getenv("OTTER_STATSD_ADDR")
+	if addr == "" {
+		return nil, nil
+	}
+	metrics := util.NewMetrics()
+	if err := metrics.EnableStatsd(addr, defaultStatsdPrefix); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// envTracer returns a tracer configured with the standard OpenTelemetry environment variables,
+// OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS, and
+// OTEL_SERVICE_NAME, or nil when no endpoint is set
+func envTracer() (*util.Tracer, error) {
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" {
+		if base := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT"); base != "" {
+			endpoint = strings.TrimSuffix(base, "/") + "/v1/traces"
+		}
+	}
+	if endpoint == "" {
+		return nil, nil
+	}
+	if protocol := os.Getenv("OTEL_EXPORTER_OTLP_PROTOCOL"); protocol != "" && protocol != "http/json" {
+		return nil, fmt.Errorf("otter exports traces with OTLP over HTTP as JSON; OTEL_EXPORTER_OTLP_PROTOCOL is %s, set it to http/json", protocol)
+	}
+
+	config := util.TracerConfig{
+		Endpoint:       endpoint,
+		Headers:        make(map[string]string),
+		ServiceName:    os.Getenv("OTEL_SERVICE_NAME"),
+		ServiceVersion: Version,
+	}
+	if config.ServiceName == "" {
+		config.ServiceName = "otter"
+	}
+	for _, header := range strings.Split(os.Getenv("OTEL_EXPORTER_OTLP_HEADERS"), ",") {
+		if key, value, ok := strings.Cut(header, "="); ok {
+			config.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return util.NewTracer(config), nil
+}
+
+// recordBuild records the duration and outcome of a build, and the class of its failure
+func recordBuild(metrics *util.Metrics, operation string, duration time.Duration, err error) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+		metrics.Count("otter_build_failures_total", "operation", operation, "class", exitClasses[exitCode(err)])
+	}
+	metrics.Count("otter_builds_total", "operation", operation, "result", result)
+	metrics.Observe("otter_build_duration_seconds", duration, "operation", operation)
+}
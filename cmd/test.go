@@ -0,0 +1,148 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	testGolden bool
+	testUpdate bool
+)
+
+var testCmd = &cobra.Command{
+	Use:   "test --golden <case>...",
+	Short: "Build golden test cases and compare the results with their expected output",
+	Long: `Build each golden test case in a temporary directory and compare what the build wrote with
+the expected tree and report of the case, to check Otterfiles and layers before they reach a
+project.
+
+A case is a directory holding an Otterfile and the local layers it refers to, along with:
+
+  expected/     the files the build is expected to write, as a tree
+  report.json   the expected report: the outcome, layers, and files changed and ignored
+  input/        optional files the output starts with, e.g. for merges and patches
+
+Anything else in the case, such as .otter/config.json or .otterignore, is part of the project the
+case is built in. Cases may be given as patterns, e.g. testdata/case-*. Pass --update to write the
+results of each build as its expected output. Any difference exits with the drift status code.`,
+	RunE: runTest,
+}
+
+func init() {
+	testCmd.Flags().BoolVar(&testGolden, "golden", false, "Compare builds with the expected output of golden test cases")
+	testCmd.Flags().BoolVar(&testUpdate, "update", false, "Write the results of each build as the expected output of its case")
+}
+
+func runTest(cmd *cobra.Command, args []string) error {
+	if !testGolden {
+		return withExitCode(ExitConfig, fmt.Errorf("otter test only runs golden test cases so far; pass --golden"))
+	}
+
+	var cases []string
+	for _, pattern := range args {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return withExitCode(ExitConfig, fmt.Errorf("invalid case pattern %s: %w", pattern, err))
+		}
+		if len(matches) == 0 {
+			return withExitCode(ExitConfig, fmt.Errorf("no golden test cases match %s", pattern))
+		}
+		cases = append(cases, matches...)
+	}
+	cases = uniqueStrings(cases)
+	if len(cases) == 0 {
+		return withExitCode(ExitConfig, fmt.Errorf("no golden test cases given"))
+	}
+
+	var failed []string
+	for _, caseDir := range cases {
+		fmt.Printf("\n=== %s\n", caseDir)
+		differences, err := runGoldenCase(caseDir)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println()
+		switch {
+		case testUpdate:
+			fmt.Printf("--- UPDATED %s\n", caseDir)
+		case len(differences) > 0:
+			fmt.Printf("--- FAIL %s\n", caseDir)
+			for _, difference := range differences {
+				fmt.Printf("  %s\n", difference)
+			}
+			failed = append(failed, caseDir)
+		default:
+			fmt.Printf("--- PASS %s\n", caseDir)
+		}
+	}
+
+	if len(failed) > 0 {
+		return withExitCode(ExitDrift, fmt.Errorf("%d of %d golden test case(s) failed", len(failed), len(cases)))
+	}
+	return nil
+}
+
+// runGoldenCase builds a golden test case in a temporary directory and returns how the results
+// differ from its expected output, or updates the expected output with --update. A failing build
+// isn't an error, since its report may be what the case expects.
+func runGoldenCase(caseDir string) ([]string, error) {
+	caseDir, err := filepath.Abs(caseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", caseDir, err)
+	}
+	tempDir, err := os.MkdirTemp("", "otter-golden-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create a directory to build in: %w", err)
+	}
+	defer os.RemoveAll(tempDir)
+	// Errors name resolved paths, e.g. under /private on macOS, which the placeholders must match
+	if resolved, err := filepath.EvalSymlinks(tempDir); err == nil {
+		tempDir = resolved
+	}
+
+	projectDir := filepath.Join(tempDir, "project")
+	outputDir := filepath.Join(tempDir, "output")
+	if err := util.PrepareGoldenCase(caseDir, projectDir, outputDir); err != nil {
+		return nil, withExitCode(ExitConfig, err)
+	}
+
+	// Builds resolve local layers and conditions against the working directory
+	previousDir, err := os.Getwd()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current directory: %w", err)
+	}
+	if err := os.Chdir(projectDir); err != nil {
+		return nil, fmt.Errorf("failed to enter %s: %w", projectDir, err)
+	}
+	defer os.Chdir(previousDir)
+
+	buildErr := executeBuild(buildOptions{
+		ProjectDir:     projectDir,
+		OtterfilePaths: []string{filepath.Join(projectDir, "Otterfile")},
+		OutputDir:      outputDir,
+		Force:          true,
+		Yes:            true,
+		Operation:      "test",
+	})
+	if buildErr != nil {
+		fmt.Printf("Build failed: %v\n", buildErr)
+	}
+
+	entry, err := util.LastAuditEntry(projectDir)
+	if err != nil {
+		return nil, withExitCode(ExitConfig, fmt.Errorf("%w; golden test cases need the audit log, so audit.disabled can't be set", err))
+	}
+	report := util.NewGoldenReport(entry, map[string]string{projectDir: "$PROJECT", outputDir: "$OUTPUT"})
+
+	if testUpdate {
+		return nil, util.UpdateGolden(caseDir, outputDir, report)
+	}
+	return util.CompareGolden(caseDir, outputDir, report)
+}
@@ -0,0 +1,103 @@
+package cmd
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/geoffjay/otter/file"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	varsFiles []string
+	varsLayer string
+)
+
+var varsCmd = &cobra.Command{
+	Use:   "vars",
+	Short: "Show the variables the Otterfile defines",
+	Long: `Show the global variables defined with VAR. With --layer, show the variables ${...}
+placeholders in that LAYER command resolve against, marking the layer's own WITH variables,
+along with the TEMPLATE values passed to its files.
+
+A layer is selected by its NAME or repository.`,
+	RunE: runVars,
+}
+
+func init() {
+	varsCmd.Flags().StringArrayVarP(&varsFiles, "file", "f", nil, "Specify the Otterfile/Envfile to use (default: auto-detect); repeat to stack files")
+	varsCmd.Flags().StringVar(&varsLayer, "layer", "", "Show the variables in scope for the layer with this NAME or repository")
+}
+
+func runVars(cmd *cobra.Command, args []string) error {
+	otterfilePaths := varsFiles
+	if len(otterfilePaths) == 0 {
+		otterfilePath, err := file.FindOtterfile()
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		otterfilePaths = []string{otterfilePath}
+	}
+
+	config, err := file.ParseOtterfileStack(otterfilePaths)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+
+	if varsLayer == "" {
+		fmt.Printf("Variables:\n")
+		printVariables(config.Variables, nil)
+		return nil
+	}
+
+	layer := findLayer(config, varsLayer)
+	if layer == nil {
+		return withExitCode(ExitConfig, fmt.Errorf("no layer with NAME or repository %s", varsLayer))
+	}
+
+	fmt.Printf("Variables for layer %s:\n", layer.Repository)
+	printVariables(config.LayerVariables(*layer), layer.Variables)
+	if len(layer.Template) > 0 {
+		fmt.Printf("\nTemplate values:\n")
+		printVariables(layer.Template, nil)
+	}
+	return nil
+}
+
+// findLayer returns the layer with a NAME, or else the first with a repository, or nil when there is none
+func findLayer(config *file.OtterfileConfig, ref string) *file.Layer {
+	for i := range config.Layers {
+		if config.Layers[i].Name == ref {
+			return &config.Layers[i]
+		}
+	}
+	for i := range config.Layers {
+		if config.Layers[i].Repository == ref {
+			return &config.Layers[i]
+		}
+	}
+	return nil
+}
+
+// printVariables prints variables sorted by name, marking those that are scoped to a layer
+func printVariables(variables, scoped map[string]string) {
+	if len(variables) == 0 {
+		fmt.Printf("  (none)\n")
+		return
+	}
+
+	names := make([]string, 0, len(variables))
+	for name := range variables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if _, ok := scoped[name]; ok {
+			fmt.Printf("  %s=%s (layer)\n", name, variables[name])
+		} else {
+			fmt.Printf("  %s=%s\n", name, variables[name])
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/geoffjay/otter/util"
+
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Check layer-managed files against the manifest and lockfile without network access",
+	Long: `Recompute the hash of every file layers wrote, as recorded in .otter/manifest.json, and
+list files that were modified or are missing. Files in a layer's own TARGET directory that no
+layer wrote are listed as extra. When Otterfile.lock exists, the commit each layer was last
+applied at is compared with its pinned commit.
+
+Nothing is fetched, so verify can run as an integrity check before deploying; only a configured
+state backend is read, for the manifest. Any difference exits with the drift status code.`,
+	RunE: runVerify,
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	currentDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("failed to get current directory: %w", err)
+	}
+
+	projectConfig, err := util.LoadConfig(currentDir)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	fsys := util.NewOSFileSystem()
+	if _, err := pullManifestState(currentDir, projectConfig, fsys); err != nil {
+		return err
+	}
+	manifestPath := filepath.Join(currentDir, ".otter", util.FileManifestName)
+	manifest, err := util.LoadFileManifest(fsys, manifestPath)
+	if err != nil {
+		return withExitCode(ExitConfig, err)
+	}
+	if len(manifest.Layers) == 0 {
+		return withExitCode(ExitConfig, fmt.Errorf("no layer files are recorded in %s; run otter build first", manifestPath))
+	}
+
+	check, err := manifest.Check(fsys, currentDir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Checked %d file(s) from %d layer(s)\n", check.Checked, len(manifest.Layers))
+
+	printPaths := func(heading string, paths []string) {
+		if len(paths) == 0 {
+			return
+		}
+		fmt.Printf("\n%s (%d):\n", heading, len(paths))
+		for _, path := range paths {
+			fmt.Printf("  %s\n", path)
+		}
+	}
+	printPaths("Modified", check.Modified)
+	printPaths("Missing", check.Missing)
+	printPaths("Extra", check.Extra)
+
+	// Layers applied at another commit than the one pinned
+	var unpinned []string
+	lockPath := filepath.Join(currentDir, util.LockfileName)
+	if _, statErr := os.Stat(lockPath); statErr == nil {
+		lock, err := util.LoadLockfile(lockPath)
+		if err != nil {
+			return withExitCode(ExitConfig, err)
+		}
+		for _, layer := range manifest.Layers {
+			locked, ok := lock.Find(layer.Repository)
+			if !ok || locked.Commit == "" || layer.Commit == "" || layer.Commit == locked.Commit {
+				continue
+			}
+			unpinned = append(unpinned, fmt.Sprintf("%s: applied at %s, pinned at %s", layer.Repository, util.ShortCommit(layer.Commit), util.ShortCommit(locked.Commit)))
+		}
+		printPaths("Layers not at their pinned commit", unpinned)
+	}
+
+	problems := len(check.Modified) + len(check.Missing) + len(check.Extra) + len(unpinned)
+	if problems > 0 {
+		fmt.Println()
+		return withExitCode(ExitDrift, fmt.Errorf("verify found %d difference(s)", problems))
+	}
+
+	fmt.Printf("\n✓ Layer-managed files match the manifest\n")
+	return nil
+}
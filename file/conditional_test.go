@@ -4,6 +4,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -13,6 +14,8 @@ func TestParseCondition(t *testing.T) {
 		conditionStr  string
 		expectedKey   string
 		expectedValue string
+		expectNegate  bool
+		expectOp      string
 		expectError   bool
 	}{
 		{
@@ -53,6 +56,44 @@ func TestParseCondition(t *testing.T) {
 			expectedValue: "",
 			expectError:   false,
 		},
+		{
+			name:          "Not equal",
+			conditionStr:  "env!=production",
+			expectedKey:   "env",
+			expectedValue: "production",
+			expectNegate:  true,
+		},
+		{
+			name:          "Pattern",
+			conditionStr:  "env~=prod.*",
+			expectedKey:   "env",
+			expectedValue: "prod.*",
+			expectOp:      "~=",
+		},
+		{
+			name:          "Version comparison",
+			conditionStr:  "version>=1.21",
+			expectedKey:   "version",
+			expectedValue: "1.21",
+			expectOp:      ">=",
+		},
+		{
+			name:          "Less than",
+			conditionStr:  "version<v2",
+			expectedKey:   "version",
+			expectedValue: "v2",
+			expectOp:      "<",
+		},
+		{
+			name:         "Comparison with something other than a version",
+			conditionStr: "version>=latest",
+			expectError:  true,
+		},
+		{
+			name:         "Invalid pattern",
+			conditionStr: "env~=[prod",
+			expectError:  true,
+		},
 		{
 			name:          "Multiple equals signs",
 			conditionStr:  "custom=value=with=equals",
@@ -85,6 +126,14 @@ func TestParseCondition(t *testing.T) {
 			if condition.Value != tt.expectedValue {
 				t.Errorf("Expected value %s, got %s", tt.expectedValue, condition.Value)
 			}
+
+			if condition.Negate != tt.expectNegate {
+				t.Errorf("Expected negate %v, got %v", tt.expectNegate, condition.Negate)
+			}
+
+			if tt.expectOp != "" && condition.Operator != tt.expectOp {
+				t.Errorf("Expected operator %s, got %s", tt.expectOp, condition.Operator)
+			}
 		})
 	}
 }
@@ -321,6 +370,49 @@ func TestEvaluateCondition_CustomVariable(t *testing.T) {
 	}
 }
 
+func TestEvaluateCondition_Operators(t *testing.T) {
+	t.Setenv("OTTER_ENV", "production")
+	t.Setenv("OTTER_VERSION", "1.21.4")
+	t.Setenv("OTTER_BRANCH", "release/2.0")
+
+	tests := []struct {
+		condition string
+		expected  bool
+	}{
+		{"env~=prod.*", true},
+		{"env~=prod*", true},
+		{"env~=staging|production", true},
+		{"env~=prod", false},
+		{"branch~=release/*", true},
+		{"version>=1.21", true},
+		{"version>=1.22", false},
+		{"version>1.21.3", true},
+		{"version<=1.21.4", true},
+		{"version<v1.21", false},
+		{"version<2", true},
+		{"missing>=1", false},
+		{"missing<1", false},
+	}
+	for _, tt := range tests {
+		condition, err := parseCondition(tt.condition)
+		if err != nil {
+			t.Fatalf("Failed to parse %s: %v", tt.condition, err)
+		}
+		matched, err := evaluateCondition(condition)
+		if err != nil {
+			t.Fatalf("Failed to evaluate %s: %v", tt.condition, err)
+		}
+		if matched != tt.expected {
+			t.Errorf("Expected %s to be %v", tt.condition, tt.expected)
+		}
+	}
+
+	layer := Layer{Condition: "NOT version<1.21 AND env~=prod.*"}
+	if apply, err := layer.ShouldApplyLayer(); err != nil || !apply {
+		t.Errorf("Expected the layer to apply, got %v, %v", apply, err)
+	}
+}
+
 func TestLayerShouldApplyLayer(t *testing.T) {
 	tests := []struct {
 		name      string
@@ -367,6 +459,93 @@ func TestLayerShouldApplyLayer(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "AND - all match",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "env=production AND os=" + runtime.GOOS,
+			},
+			envVars:  map[string]string{"OTTER_ENV": "production"},
+			expected: true,
+		},
+		{
+			name: "AND - one does not match",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "env=production AND os=" + runtime.GOOS,
+			},
+			envVars:  map[string]string{},
+			expected: false,
+		},
+		{
+			name: "OR - one matches",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "editor=vscode OR editor=cursor",
+			},
+			envVars:  map[string]string{"OTTER_EDITOR": "cursor"},
+			expected: true,
+		},
+		{
+			name: "AND binds tighter than OR",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "env=production OR editor=vim AND os=plan9",
+			},
+			envVars:  map[string]string{"OTTER_EDITOR": "vim"},
+			expected: false,
+		},
+		{
+			name: "Not equal - matches",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "env!=production",
+			},
+			envVars:  map[string]string{},
+			expected: true,
+		},
+		{
+			name: "NOT - does not match",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "NOT os=" + runtime.GOOS,
+			},
+			expected: false,
+		},
+		{
+			name: "NOT with AND",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "env=production AND NOT editor=vim",
+			},
+			envVars:  map[string]string{"OTTER_ENV": "production", "OTTER_EDITOR": "cursor"},
+			expected: true,
+		},
+		{
+			name: "NOT of not equal",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "NOT os!=" + runtime.GOOS,
+			},
+			expected: true,
+		},
+		{
+			name: "Invalid term with AND",
+			layer: Layer{
+				Repository: "test-repo",
+				Target:     ".",
+				Condition:  "env=development AND invalid-condition",
+			},
+			expectErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -540,3 +719,98 @@ LAYER git@github.com:example/macos.git IF os=darwin
 		}
 	}
 }
+
+func TestParseOtterfileConditionOperators(t *testing.T) {
+	content := `LAYER git@github.com:example/prod.git IF env=production and os=linux TARGET prod
+LAYER git@github.com:example/editor.git IF editor=vscode OR editor=cursor
+LAYER git@github.com:example/unix.git IF NOT os=windows AND not env=production
+LAYER git@github.com:example/dev.git IF env!=production TARGET dev
+LAYER git@github.com:example/go.git IF version>=1.21 AND env~=prod.*
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	if config.Layers[0].Condition != "env=production AND os=linux" || config.Layers[0].Target != "prod" {
+		t.Errorf("Unexpected first layer: %+v", config.Layers[0])
+	}
+	if config.Layers[1].Condition != "editor=vscode OR editor=cursor" {
+		t.Errorf("Unexpected condition: %s", config.Layers[1].Condition)
+	}
+	if config.Layers[2].Condition != "NOT os=windows AND NOT env=production" {
+		t.Errorf("Unexpected condition: %s", config.Layers[2].Condition)
+	}
+	if config.Layers[3].Condition != "env!=production" || config.Layers[3].Target != "dev" {
+		t.Errorf("Unexpected fourth layer: %+v", config.Layers[3])
+	}
+	if config.Layers[4].Condition != "version>=1.21 AND env~=prod.*" {
+		t.Errorf("Unexpected condition: %s", config.Layers[4].Condition)
+	}
+
+	for _, content := range []string{"LAYER ./layer IF", "LAYER ./layer IF NOT", "LAYER ./layer IF os=linux OR NOT"} {
+		if _, err := ParseOtterfileReader(strings.NewReader(content), "inline"); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+
+	_, err = ParseOtterfileReader(strings.NewReader("LAYER ./layer IF env=production AND"), "inline")
+	if err == nil || !strings.Contains(err.Error(), "IF condition ends with AND") {
+		t.Errorf("Expected an error for a dangling AND, got %v", err)
+	}
+}
+
+func TestEvaluateCondition_Project(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tempDir, "go.mod"), []byte("module github.com/example/api\n"), 0644); err != nil {
+		t.Fatalf("Failed to write go.mod: %v", err)
+	}
+
+	originalDir, _ := os.Getwd()
+	os.Chdir(tempDir)
+	defer os.Chdir(originalDir)
+
+	matched, err := evaluateCondition(&Condition{Key: "project.module", Value: "github.com/example/api"})
+	if err != nil || !matched {
+		t.Errorf("Expected project.module to match, got %v, %v", matched, err)
+	}
+
+	matched, err = evaluateCondition(&Condition{Key: "project.package", Value: "web"})
+	if err != nil || matched {
+		t.Errorf("Expected project.package not to match, got %v, %v", matched, err)
+	}
+
+	if _, err := evaluateCondition(&Condition{Key: "project.owner", Value: "me"}); err == nil {
+		t.Error("Expected error for unknown project fact")
+	}
+}
+
+func TestEvaluateConditionExpression(t *testing.T) {
+	t.Setenv("OTTER_ENV", "production")
+	t.Setenv("OTTER_REGION", "eu")
+
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{"", true},
+		{"env=production", true},
+		{"env=production AND region=us", false},
+		{"env=staging OR region~=e*", true},
+		{"NOT env=production", false},
+	}
+	for _, tt := range tests {
+		matched, err := EvaluateCondition(tt.expression)
+		if err != nil {
+			t.Errorf("EvaluateCondition(%q) failed: %v", tt.expression, err)
+			continue
+		}
+		if matched != tt.expected {
+			t.Errorf("EvaluateCondition(%q) = %v, expected %v", tt.expression, matched, tt.expected)
+		}
+	}
+
+	if _, err := EvaluateCondition("version>=latest"); err == nil {
+		t.Error("Expected an error for an invalid condition")
+	}
+}
@@ -0,0 +1,96 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+)
+
+// orderLayers returns layers in the order they are applied: each layer after the layers its DEPENDS
+// clause names, and otherwise in the order they are declared. Every name must be the NAME of one of
+// all, the layers of the Otterfile, and the layers it names must be among layers too, so a layer
+// doesn't apply without what it depends on. Dependency cycles are an error.
+func orderLayers(layers, all []Layer) ([]Layer, error) {
+	declared := make(map[string]bool)
+	for _, layer := range all {
+		if layer.Name != "" {
+			declared[layer.Name] = true
+		}
+	}
+	index := make(map[string]int)
+	for i, layer := range layers {
+		if layer.Name != "" {
+			index[layer.Name] = i
+		}
+	}
+
+	// dependents lists the layers waiting on each layer, and waiting counts what each layer waits on
+	dependents := make([][]int, len(layers))
+	waiting := make([]int, len(layers))
+	for i, layer := range layers {
+		for _, name := range layer.Depends {
+			j, ok := index[name]
+			switch {
+			case !declared[name]:
+				return nil, fmt.Errorf("layer %s DEPENDS on %s, which isn't the NAME of any layer", layerLabel(layer), name)
+			case !ok:
+				return nil, fmt.Errorf("layer %s DEPENDS on %s, which doesn't apply (check its IF condition)", layerLabel(layer), name)
+			}
+			dependents[j] = append(dependents[j], i)
+			waiting[i]++
+		}
+	}
+
+	// Apply the first declared layer that isn't waiting on another, until none is left
+	ordered := make([]Layer, 0, len(layers))
+	applied := make([]bool, len(layers))
+	for len(ordered) < len(layers) {
+		next := -1
+		for i := range layers {
+			if !applied[i] && waiting[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			return nil, fmt.Errorf("layer dependencies form a cycle: %s", dependencyCycle(layers, index, applied))
+		}
+		applied[next] = true
+		ordered = append(ordered, layers[next])
+		for _, i := range dependents[next] {
+			waiting[i]--
+		}
+	}
+	return ordered, nil
+}
+
+// dependencyCycle describes a cycle among the layers not yet applied, e.g. api -> db -> api
+func dependencyCycle(layers []Layer, index map[string]int, applied []bool) string {
+	// Every layer left waits on another layer left, so following dependencies must come back around
+	current := 0
+	for applied[current] {
+		current++
+	}
+	position := make(map[int]int)
+	var path []string
+	for {
+		if start, seen := position[current]; seen {
+			return strings.Join(append(path[start:], layerLabel(layers[current])), " -> ")
+		}
+		position[current] = len(path)
+		path = append(path, layerLabel(layers[current]))
+		for _, name := range layers[current].Depends {
+			if j := index[name]; !applied[j] {
+				current = j
+				break
+			}
+		}
+	}
+}
+
+// layerLabel names a layer in messages by its NAME, or its repository when it has none
+func layerLabel(layer Layer) string {
+	if layer.Name != "" {
+		return layer.Name
+	}
+	return layer.Repository
+}
@@ -0,0 +1,159 @@
+package file
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/geoffjay/otter/util"
+)
+
+// layerGroup holds the options a GROUP block gives the layers inside it, merged with those of the
+// blocks around it
+type layerGroup struct {
+	target    string            // Prefix of the TARGET of each layer; empty for none
+	condition string            // Condition each layer must meet besides its own; empty for none
+	template  map[string]string // Template variables of each layer that doesn't set them itself
+	line      int               // Line of the GROUP command
+}
+
+// parseGroupCommand parses a GROUP command, which opens a block of layers sharing its TARGET, IF,
+// and TEMPLATE options until the matching END
+func parseGroupCommand(args []string, config *OtterfileConfig) error {
+	group := layerGroup{template: make(map[string]string), line: config.line}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToUpper(args[i]) {
+		case "TARGET":
+			if i+1 >= len(args) {
+				return fmt.Errorf("TARGET requires a path argument")
+			}
+			group.target = config.substituteVariables(args[i+1], config.Variables)
+			if err := config.checkSubstituted(group.target); err != nil {
+				return err
+			}
+			i++ // Skip the next argument as it's the target path
+		case "IF":
+			condition, next, err := parseConditionArgs(args, i)
+			if err != nil {
+				return err
+			}
+			group.condition = condition
+			i = next // Skip processed arguments
+		case "TEMPLATE":
+			start := i
+			for i+1 < len(args) && strings.Contains(args[i+1], "=") {
+				key, value, _ := strings.Cut(args[i+1], "=")
+				value = config.substituteVariables(strings.TrimSpace(value), config.Variables)
+				if err := config.checkSubstituted(value); err != nil {
+					return err
+				}
+				group.template[strings.TrimSpace(key)] = value
+				i++
+			}
+			if i == start {
+				return fmt.Errorf("TEMPLATE requires template variable assignments")
+			}
+		default:
+			return fmt.Errorf("unknown GROUP argument: %s (expected TARGET, IF, or TEMPLATE)", args[i])
+		}
+	}
+
+	// A nested group adds to the options of the groups around it
+	if len(config.groups) > 0 {
+		outer := config.groups[len(config.groups)-1]
+		if outer.target != "" && (filepath.IsAbs(group.target) || util.IsSSHTarget(group.target) || util.IsContainerTarget(group.target)) {
+			return fmt.Errorf("TARGET %s must be relative to the TARGET %s of the enclosing GROUP", group.target, outer.target)
+		}
+		group.target = joinGroupTarget(outer.target, group.target)
+		group.condition = combineConditions(outer.condition, group.condition)
+		for key, value := range outer.template {
+			if _, ok := group.template[key]; !ok {
+				group.template[key] = value
+			}
+		}
+	}
+	config.groups = append(config.groups, group)
+	return nil
+}
+
+// parseEndCommand parses an END command, which closes the innermost GROUP block
+func parseEndCommand(args []string, config *OtterfileConfig) error {
+	if len(args) > 0 {
+		return fmt.Errorf("END takes no arguments")
+	}
+	if len(config.groups) == 0 {
+		return fmt.Errorf("END without a GROUP")
+	}
+	config.groups = config.groups[:len(config.groups)-1]
+	return nil
+}
+
+// applyGroup gives a layer the options of the GROUP block it is in, if any: its TARGET is placed
+// under the group's, it must meet the group's condition too, and it gets the group's template
+// variables it doesn't set itself
+func (config *OtterfileConfig) applyGroup(layer *Layer) error {
+	if len(config.groups) == 0 {
+		return nil
+	}
+	group := config.groups[len(config.groups)-1]
+
+	if group.target != "" {
+		if filepath.IsAbs(layer.Target) || util.IsSSHTarget(layer.Target) || util.IsContainerTarget(layer.Target) {
+			return fmt.Errorf("TARGET %s must be relative to the TARGET %s of its GROUP", layer.Target, group.target)
+		}
+		layer.Target = joinGroupTarget(group.target, layer.Target)
+	}
+	layer.Condition = combineConditions(group.condition, layer.Condition)
+	for key, value := range group.template {
+		if _, ok := layer.Template[key]; !ok {
+			layer.Template[key] = value
+		}
+	}
+	return nil
+}
+
+// joinGroupTarget places target under the target of a group. The prefix may be a remote target,
+// such as ssh://host/srv, so it is joined as text rather than as a path.
+func joinGroupTarget(prefix, target string) string {
+	target = strings.TrimPrefix(target, "./")
+	switch {
+	case prefix == "":
+		return target
+	case target == "" || target == ".":
+		return prefix
+	}
+	return strings.TrimSuffix(prefix, "/") + "/" + target
+}
+
+// combineConditions returns a condition that holds when both a and b hold. AND binds tighter than
+// OR and there are no parentheses, so every alternative of a is combined with every one of b.
+func combineConditions(a, b string) string {
+	switch {
+	case a == "":
+		return b
+	case b == "":
+		return a
+	}
+	var alternatives []string
+	for _, left := range conditionAlternatives(a) {
+		for _, right := range conditionAlternatives(b) {
+			alternatives = append(alternatives, left+" AND "+right)
+		}
+	}
+	return strings.Join(alternatives, " OR ")
+}
+
+// conditionAlternatives splits a condition at its ORs
+func conditionAlternatives(condition string) []string {
+	var alternatives []string
+	var terms []string
+	for _, field := range strings.Fields(condition) {
+		if strings.ToUpper(field) == "OR" {
+			alternatives = append(alternatives, strings.Join(terms, " "))
+			terms = nil
+			continue
+		}
+		terms = append(terms, field)
+	}
+	return append(alternatives, strings.Join(terms, " "))
+}
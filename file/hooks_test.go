@@ -2,7 +2,12 @@ package file
 
 import (
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/geoffjay/otter/util"
 )
 
 func TestParseGlobalHooks(t *testing.T) {
@@ -191,3 +196,144 @@ func stringSlicesEqual(a, b []string) bool {
 	}
 	return true
 }
+
+func TestParseInteractiveHooks(t *testing.T) {
+	content := `ON_BEFORE_BUILD: ["gh auth status || gh auth login"] INTERACTIVE
+ON_AFTER_BUILD: ["make test"]
+LAYER ./layer BEFORE ["[ -f .env ] || ./scripts/setup-env.sh"] interactive AFTER ["echo done"] TARGET app
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	if len(config.OnBeforeBuildOptions) != 1 || !config.OnBeforeBuildOptions[0].Interactive {
+		t.Errorf("Expected the before build hook to be interactive, got %v", config.OnBeforeBuildOptions)
+	}
+	if config.OnAfterBuildOptions != nil {
+		t.Errorf("Expected no options for the after build hook, got %v", config.OnAfterBuildOptions)
+	}
+
+	layer := config.Layers[0]
+	if !stringSlicesEqual(layer.Before, []string{"[ -f .env ] || ./scripts/setup-env.sh"}) {
+		t.Errorf("Unexpected BEFORE commands: %v", layer.Before)
+	}
+	if len(layer.BeforeOptions) != 1 || !layer.BeforeOptions[0].Interactive {
+		t.Errorf("Expected the BEFORE hook to be interactive, got %v", layer.BeforeOptions)
+	}
+	if layer.AfterOptions != nil || layer.Target != "app" {
+		t.Errorf("Expected AFTER without options and TARGET app, got %v and %s", layer.AfterOptions, layer.Target)
+	}
+
+	if _, err := ParseOtterfileReader(strings.NewReader(`ON_ERROR: ["make clean"] NOW`), "inline"); err == nil {
+		t.Error("Expected an unknown hook option to fail")
+	}
+}
+
+func TestParseEnvCommand(t *testing.T) {
+	content := `VAR PROJECT_NAME=billing
+ENV DATABASE_URL=postgres://localhost/${PROJECT_NAME}
+ENV GREETING = hello  world
+ENV EMPTY=
+ON_AFTER_BUILD: ["./scripts/migrate.sh"]
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	expected := []string{"DATABASE_URL=postgres://localhost/billing", "GREETING=hello world", "EMPTY="}
+	if !stringSlicesEqual(config.Env, expected) {
+		t.Errorf("Expected %v, got %v", expected, config.Env)
+	}
+
+	for _, invalid := range []string{"ENV", "ENV DATABASE_URL", "ENV 1PASSWORD=x", "ENV MY-VAR=x", "ENV =x"} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected %q to fail", invalid)
+		}
+	}
+}
+
+func TestParseHookRetries(t *testing.T) {
+	content := `ON_BEFORE_BUILD: ["npm ci"] RETRIES 3 CONTINUE_ON_ERROR
+LAYER ./layer AFTER ["pip install -r requirements.txt"] retries 2 TARGET app
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	expected := util.HookOptions{ContinueOnError: true, Retries: 3}
+	if len(config.OnBeforeBuildOptions) != 1 || !reflect.DeepEqual(config.OnBeforeBuildOptions[0], expected) {
+		t.Errorf("Unexpected before build options: %+v", config.OnBeforeBuildOptions)
+	}
+	layer := config.Layers[0]
+	if len(layer.AfterOptions) != 1 || layer.AfterOptions[0].Retries != 2 || layer.Target != "app" {
+		t.Errorf("Expected AFTER with 2 retries and TARGET app, got %+v and %s", layer.AfterOptions, layer.Target)
+	}
+
+	for _, invalid := range []string{`ON_ERROR: ["make clean"] RETRIES`, `ON_ERROR: ["make clean"] RETRIES -1`, `ON_ERROR: ["make clean"] RETRIES few`} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected %q to fail", invalid)
+		}
+	}
+}
+
+func TestParseHookRunOptions(t *testing.T) {
+	content := `ON_AFTER_BUILD: ["npm ci"] WORKDIR web ENV NODE_ENV=production ENV CI=1 TIMEOUT 5m WHEN env=ci AND NOT os=windows
+LAYER ./layer AFTER ["make"] WHEN os=linux IF env=production
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	expected := util.HookOptions{
+		Workdir: "web",
+		Env:     []string{"NODE_ENV=production", "CI=1"},
+		Timeout: 5 * time.Minute,
+		When:    "env=ci AND NOT os=windows",
+	}
+	if len(config.OnAfterBuildOptions) != 1 || !reflect.DeepEqual(config.OnAfterBuildOptions[0], expected) {
+		t.Errorf("Unexpected after build options: %+v", config.OnAfterBuildOptions)
+	}
+
+	// IF after a layer's hook is the layer's condition, WHEN the hook's
+	layer := config.Layers[0]
+	if len(layer.AfterOptions) != 1 || layer.AfterOptions[0].When != "os=linux" || layer.Condition != "env=production" {
+		t.Errorf("Expected AFTER WHEN os=linux on a layer IF env=production, got %+v and %q", layer.AfterOptions, layer.Condition)
+	}
+
+	for _, invalid := range []string{
+		`ON_ERROR: ["make clean"] WORKDIR`,
+		`ON_ERROR: ["make clean"] WORKDIR ../elsewhere`,
+		`ON_ERROR: ["make clean"] WORKDIR /tmp`,
+		`ON_ERROR: ["make clean"] ENV NODE_ENV`,
+		`ON_ERROR: ["make clean"] TIMEOUT`,
+		`ON_ERROR: ["make clean"] TIMEOUT soon`,
+		`ON_ERROR: ["make clean"] TIMEOUT 0s`,
+		`ON_ERROR: ["make clean"] WHEN`,
+		`ON_ERROR: ["make clean"] WHEN ci`,
+	} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected %q to fail", invalid)
+		}
+	}
+}
+
+func TestParseHookOnce(t *testing.T) {
+	config, err := ParseOtterfileReader(strings.NewReader(`LAYER ./web AFTER ["npm install"] ONCE`), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if options := config.Layers[0].AfterOptions; len(options) != 1 || !options[0].Once {
+		t.Errorf("Expected the AFTER hook to run once, got %+v", options)
+	}
+
+	// Only layer AFTER hooks can be deferred to the end of the build
+	for _, invalid := range []string{`LAYER ./web BEFORE ["npm install"] ONCE`, `ON_AFTER_BUILD: ["npm install"] ONCE`} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected %q to fail", invalid)
+		}
+	}
+}
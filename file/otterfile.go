@@ -2,40 +2,136 @@ package file
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"path"
+	"path/filepath"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/geoffjay/otter/util"
 )
 
 // Layer represents a single layer definition from the Otterfile
 type Layer struct {
 	Repository string
+	Ref        string            // Git ref a remote layer is pinned to with @, e.g. v1.2.0; empty tracks the default branch
+	Branch     string            // Branch a remote layer follows instead of its default branch, from its BRANCH clause
 	Target     string            // Optional target directory, defaults to root
-	Condition  string            // Optional condition for applying the layer (e.g., "env=development")
+	Condition  string            // Optional condition for applying the layer (e.g., "env=development AND os=linux")
 	Template   map[string]string // Optional template variables to pass to the layer
+	Variables  map[string]string // Layer-scoped variables from WITH, used for ${...} in this LAYER only
 	Delims     [2]string         // Optional custom template delimiters [left, right], defaults to {{ and }}
 	Before     []string          // Commands to run before applying the layer
 	After      []string          // Commands to run after applying the layer
+	Type       string            // Layer type: empty for file layers, or one of the LayerType constants
+	Generate   []string          // Commands that produce the content of a generator layer
+	Allow      []string          // Normally protected files the layer may provide, e.g. .gitignore
+	Name       string            // Optional name a stacked Otterfile can use to replace the layer
+	Depends    []string          // NAMEs of layers applied before this one, wherever they are declared
+	Comment    string            // Trailing comment on the LAYER command, e.g. why the project uses the layer
+	// Frozen keeps a remote layer at the commit pinned in the lockfile, for layers intentionally
+	// diverged from upstream; builds don't update it and otter notify doesn't report it
+	Frozen bool
+	// AllowHidden are hidden files at the layer root it may provide when the hidden_files policy is
+	// allow; * allows them all
+	AllowHidden []string
+	// Only limits the files copied from the layer to these paths, relative to the layer root and
+	// optionally with wildcards, e.g. src/ and Makefile; every file is copied when empty
+	Only []string
+	// Map moves files of the layer to other paths in the target, e.g. configs/ to .config/
+	Map []util.PathMapping
+	// Strategy is what happens to files of the layer that already exist in the target, one of
+	// util.Strategies; merge when empty
+	Strategy string
+	// BeforeOptions and AfterOptions hold the options of each BEFORE and AFTER command, such as
+	// INTERACTIVE, at the index of the command; nil when no options are set
+	BeforeOptions []util.HookOptions
+	AfterOptions  []util.HookOptions
+	// DisabledWarnings are warning codes suppressed for this layer with an otter:disable comment
+	DisabledWarnings []string
 }
 
+// Layer types other than the default file layer. Package layers (devbox, nix) contribute to a
+// generated environment file, patch layers modify existing files, and generator layers copy the
+// output of a command instead of the repository.
+const (
+	LayerTypeDevbox    = "devbox"    // Merged into a generated devbox.json
+	LayerTypeNix       = "nix"       // Merged into a generated flake.nix
+	LayerTypePatch     = "patch"     // Unified diffs applied to existing project files
+	LayerTypeGenerator = "generator" // Output of GENERATE commands run in the fetched repository
+)
+
 // Condition represents a parsed condition for layer application
 type Condition struct {
 	Key   string
 	Value string
+	// Operator is = (also when empty), ~= to match a glob or regular expression, or >=, >, <=, or <
+	// to compare versions
+	Operator string
+	Negate   bool // Set for key!=value and NOT key=value
+}
+
+// ToolRequirement is a toolchain the project needs, optionally constrained to a version
+type ToolRequirement struct {
+	Name     string // Tool name, e.g. go or node
+	Operator string // Version comparison: >=, >, <=, <, or =; empty when any version is accepted
+	Version  string // Version the tool is compared against
+}
+
+func (r ToolRequirement) String() string {
+	return r.Name + r.Operator + r.Version
 }
 
 // OtterfileConfig holds the parsed configuration from Otterfile/Envfile
 type OtterfileConfig struct {
 	Variables     map[string]string // Variables defined with VAR command
 	Layers        []Layer
-	OnBeforeBuild []string // Global commands to run before build
-	OnAfterBuild  []string // Global commands to run after build
-	OnError       []string // Global commands to run on error
+	OnBeforeBuild []string          // Global commands to run before build
+	OnAfterBuild  []string          // Global commands to run after build
+	OnError       []string          // Global commands to run on error
+	Tools         []ToolRequirement // Toolchains required by the project
+	IgnorePresets []string          // Ignore presets selected with IGNORE PRESET
+	IgnoreCase    string            // Ignore case sensitivity set with IGNORE CASE; empty means case-sensitive
+	Env           []string          // Environment variables set with ENV for every hook, as KEY=VALUE; later ones win
+	MinVersion    string            // Oldest otter the Otterfile works with, from MIN_VERSION; empty when any works
+	Warnings      []util.Warning    // Problems found while parsing that don't prevent a build
+	// HookDisabledWarnings are warning codes suppressed for global hooks with an otter:disable comment
+	HookDisabledWarnings []string
+	// Options of each global hook command at the index of the command; nil when no options are set
+	OnBeforeBuildOptions []util.HookOptions
+	OnAfterBuildOptions  []util.HookOptions
+	OnErrorOptions       []util.HookOptions
+
+	variableMode variableMode // How VAR commands treat the variables the parse started from
+	prompter     Prompter     // Asks for PROMPT variables; nil when otter can't ask
+	// user detects the developer facts of the Otterfile's project for ${user.name} and the like;
+	// none when nil
+	user func() *util.UserInfo
+
+	groups []layerGroup // GROUP blocks open at the command being parsed, innermost last
+
+	// Where the command being parsed came from, the warnings disabled for it, and its trailing comment
+	source           string
+	line             int
+	disabledWarnings []string
+	comment          string
 }
 
+// Limits on Otterfile content, which can come from untrusted sources such as registries
+const (
+	MaxOtterfileSize = 1 << 20  // Largest Otterfile accepted, in bytes
+	MaxLineLength    = 64 << 10 // Longest command accepted, in bytes, including continued lines
+)
+
 // ParseOtterfile reads and parses an Otterfile or Envfile
 func ParseOtterfile(filename string) (*OtterfileConfig, error) {
 	file, err := os.Open(filename)
@@ -44,26 +140,182 @@ func ParseOtterfile(filename string) (*OtterfileConfig, error) {
 	}
 	defer file.Close()
 
+	return parseOtterfile(file, filename, nil, variablesDefined, nil, detectUser(filepath.Dir(filename)))
+}
+
+// ParseOtterfileStack parses Otterfiles that are stacked in order: variables defined in later files
+// override earlier definitions everywhere they are used, and layers are merged by NAME, with a named
+// layer in a later file replacing the earlier one in place and other layers appended.
+func ParseOtterfileStack(filenames []string) (*OtterfileConfig, error) {
+	return ParseOtterfileStackInteractive(filenames, nil)
+}
+
+// ParseOtterfileStackInteractive parses stacked Otterfiles like ParseOtterfileStack, asking prompter
+// for each PROMPT variable they don't provide; a nil prompter uses the defaults
+func ParseOtterfileStackInteractive(filenames []string, prompter Prompter) (*OtterfileConfig, error) {
+	if len(filenames) == 1 {
+		file, err := os.Open(filenames[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", filenames[0], err)
+		}
+		defer file.Close()
+		return parseOtterfile(file, filenames[0], nil, variablesDefined, prompter, detectUser(filepath.Dir(filenames[0])))
+	}
+
+	contents := make([]string, len(filenames))
+	for i, filename := range filenames {
+		content, err := os.ReadFile(filename)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", filename, err)
+		}
+		contents[i] = string(content)
+	}
+
+	// The stack describes the project of its first file
+	user := detectUser(filepath.Dir(filenames[0]))
+
+	// Resolve the final value of every variable across the stack first
+	variables := make(map[string]string)
+	for i, content := range contents {
+		config, err := parseOtterfile(strings.NewReader(content), filenames[i], variables, variablesResolving, nil, user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filenames[i], err)
+		}
+		variables = config.Variables
+	}
+
+	// Then parse each file again with those values fixed, asking for PROMPT variables none of the
+	// files provide and keeping the answers for the files after
+	var stacked *OtterfileConfig
+	for i, content := range contents {
+		config, err := parseOtterfile(strings.NewReader(content), filenames[i], variables, variablesFixed, prompter, user)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filenames[i], err)
+		}
+		variables = config.Variables
+		if stacked == nil {
+			stacked = config
+		} else {
+			stacked.stack(config)
+		}
+	}
+
+	return stacked, nil
+}
+
+// stack merges the configuration of a later Otterfile into this one
+func (config *OtterfileConfig) stack(other *OtterfileConfig) {
+	for key, value := range other.Variables {
+		config.Variables[key] = value
+	}
+
+	for _, layer := range other.Layers {
+		replaced := false
+		if layer.Name != "" {
+			for i := range config.Layers {
+				if config.Layers[i].Name == layer.Name {
+					config.Layers[i] = layer
+					replaced = true
+					break
+				}
+			}
+		}
+		if !replaced {
+			config.Layers = append(config.Layers, layer)
+		}
+	}
+
+	config.OnBeforeBuildOptions = appendHookOptions(config.OnBeforeBuildOptions, len(config.OnBeforeBuild), other.OnBeforeBuildOptions)
+	config.OnAfterBuildOptions = appendHookOptions(config.OnAfterBuildOptions, len(config.OnAfterBuild), other.OnAfterBuildOptions)
+	config.OnErrorOptions = appendHookOptions(config.OnErrorOptions, len(config.OnError), other.OnErrorOptions)
+	config.OnBeforeBuild = append(config.OnBeforeBuild, other.OnBeforeBuild...)
+	config.OnAfterBuild = append(config.OnAfterBuild, other.OnAfterBuild...)
+	config.OnError = append(config.OnError, other.OnError...)
+	config.Tools = append(config.Tools, other.Tools...)
+	config.IgnorePresets = append(config.IgnorePresets, other.IgnorePresets...)
+	config.Env = append(config.Env, other.Env...)
+	config.Warnings = append(config.Warnings, other.Warnings...)
+	config.HookDisabledWarnings = append(config.HookDisabledWarnings, other.HookDisabledWarnings...)
+	if other.IgnoreCase != "" {
+		config.IgnoreCase = other.IgnoreCase
+	}
+	if other.MinVersion != "" && (config.MinVersion == "" || util.CompareVersions(other.MinVersion, config.MinVersion) > 0) {
+		config.MinVersion = other.MinVersion
+	}
+}
+
+// ParseOtterfileReader parses Otterfile content from a reader; name is used in error messages.
+// Developer facts come from the project in the current directory.
+func ParseOtterfileReader(r io.Reader, name string) (*OtterfileConfig, error) {
+	return parseOtterfile(r, name, nil, variablesDefined, nil, detectUser("."))
+}
+
+// variableMode is how VAR commands treat variables when an Otterfile is parsed on its own or as
+// part of a stack, which is parsed twice
+type variableMode int
+
+const (
+	variablesDefined   variableMode = iota // VAR commands define variables as they are parsed
+	variablesResolving                     // Variables are being resolved across a stack, so later files may still provide them
+	variablesFixed                         // Variables were resolved across a stack and VAR can't change them
+)
+
+// parseOtterfile parses Otterfile content starting from the given variables, which VAR commands
+// treat according to mode, asking prompter for PROMPT variables when it isn't nil and taking
+// developer facts from user
+func parseOtterfile(r io.Reader, name string, variables map[string]string, mode variableMode, prompter Prompter, user func() *util.UserInfo) (*OtterfileConfig, error) {
 	config := &OtterfileConfig{
-		Variables: make(map[string]string),
-		Layers:    make([]Layer, 0),
+		Variables:    make(map[string]string),
+		Layers:       make([]Layer, 0),
+		variableMode: mode,
+		prompter:     prompter,
+		user:         user,
+		source:       name,
+	}
+	for key, value := range variables {
+		config.Variables[key] = value
+	}
+
+	content, err := io.ReadAll(io.LimitReader(r, MaxOtterfileSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %w", name, err)
+	}
+	if len(content) > MaxOtterfileSize {
+		return nil, fmt.Errorf("%s is larger than %d bytes", name, MaxOtterfileSize)
+	}
+	if err := checkMinVersion(content); err != nil {
+		return nil, err
 	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, MaxLineLength)
 	lineNumber := 0
 	startLineNumber := 0
 	var continuedLine strings.Builder
+	var disabled []string
+	var comments []string
 
 	for scanner.Scan() {
 		lineNumber++
 		rawLine := scanner.Text()
-		line := strings.TrimSpace(rawLine)
+		line, lineDisabled, err := util.ParseDisableDirective(strings.TrimSpace(rawLine))
+		if err != nil {
+			return nil, fmt.Errorf("error on line %d: %w", lineNumber, err)
+		}
+		// A directive on its own line applies to the next command
+		disabled = append(disabled, lineDisabled...)
 
 		// Skip empty lines and comments (but only if not in a continuation)
 		if continuedLine.Len() == 0 && (line == "" || strings.HasPrefix(line, "#")) {
 			continue
 		}
 
+		// A comment can follow a command, or each line of a continued command
+		line, comment := splitTrailingComment(line)
+		if comment != "" {
+			comments = append(comments, comment)
+		}
+
 		// Check for line continuation (backslash at end)
 		if strings.HasSuffix(line, "\\") {
 			// Remove the backslash and add to continued line
@@ -76,6 +328,9 @@ func ParseOtterfile(filename string) (*OtterfileConfig, error) {
 				continuedLine.WriteString(" ")
 			}
 			continuedLine.WriteString(line)
+			if continuedLine.Len() > MaxLineLength {
+				return nil, fmt.Errorf("error on line %d: command is longer than %d bytes", startLineNumber, MaxLineLength)
+			}
 			continue
 		}
 
@@ -93,23 +348,55 @@ func ParseOtterfile(filename string) (*OtterfileConfig, error) {
 			reportLineNumber = lineNumber
 		}
 
+		config.line = reportLineNumber
+		config.disabledWarnings = disabled
+		config.comment = strings.Join(comments, " ")
 		if err := parseLine(fullLine, config, reportLineNumber); err != nil {
 			return nil, fmt.Errorf("error on line %d: %w", reportLineNumber, err)
 		}
+		disabled = nil
+		comments = nil
 	}
 
 	// Check for unterminated line continuation
 	if continuedLine.Len() > 0 {
 		return nil, fmt.Errorf("error on line %d: unterminated line continuation", startLineNumber)
 	}
+	if len(config.groups) > 0 {
+		return nil, fmt.Errorf("error on line %d: GROUP is never closed with END", config.groups[len(config.groups)-1].line)
+	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading %s: %w", filename, err)
+		if errors.Is(err, bufio.ErrTooLong) {
+			return nil, fmt.Errorf("error on line %d: line is longer than %d bytes", lineNumber+1, MaxLineLength)
+		}
+		return nil, fmt.Errorf("error reading %s: %w", name, err)
 	}
 
 	return config, nil
 }
 
+// splitTrailingComment splits a comment, a # after whitespace, off the end of a line. A # inside
+// a quoted string, such as a hook command, doesn't start a comment.
+func splitTrailingComment(line string) (string, string) {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			if quoted {
+				i++ // Skip the escaped character
+			}
+		case '"':
+			quoted = !quoted
+		case '#':
+			if !quoted && i > 0 && (line[i-1] == ' ' || line[i-1] == '\t') {
+				return strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+			}
+		}
+	}
+	return line, ""
+}
+
 // parseLine parses a single line from the Otterfile
 func parseLine(line string, config *OtterfileConfig, lineNumber int) error {
 	parts := strings.Fields(line)
@@ -120,16 +407,34 @@ func parseLine(line string, config *OtterfileConfig, lineNumber int) error {
 	command := strings.ToUpper(parts[0])
 
 	switch command {
+	case "MIN_VERSION":
+		return parseMinVersionCommand(parts[1:], config)
 	case "VAR":
 		return parseVarCommand(parts[1:], config)
+	case "ENV":
+		return parseEnvCommand(parts[1:], config)
+	case "PROMPT":
+		// The question and default may be quoted, so the arguments are split from the line itself
+		return parsePromptCommand(strings.TrimSpace(strings.TrimSpace(line)[len(parts[0]):]), config)
 	case "LAYER":
 		return parseLayerCommand(parts[1:], config)
+	case "GROUP":
+		return parseGroupCommand(parts[1:], config)
+	case "END":
+		return parseEndCommand(parts[1:], config)
+	case "TOOLS":
+		return parseToolsCommand(parts[1:], config)
+	case "IGNORE":
+		return parseIgnoreCommand(parts[1:], config)
 	case "ON_BEFORE_BUILD:":
-		return parseGlobalHookCommand(parts[1:], &config.OnBeforeBuild)
+		config.HookDisabledWarnings = append(config.HookDisabledWarnings, config.disabledWarnings...)
+		return parseGlobalHookCommand(parts[1:], &config.OnBeforeBuild, &config.OnBeforeBuildOptions)
 	case "ON_AFTER_BUILD:":
-		return parseGlobalHookCommand(parts[1:], &config.OnAfterBuild)
+		config.HookDisabledWarnings = append(config.HookDisabledWarnings, config.disabledWarnings...)
+		return parseGlobalHookCommand(parts[1:], &config.OnAfterBuild, &config.OnAfterBuildOptions)
 	case "ON_ERROR:":
-		return parseGlobalHookCommand(parts[1:], &config.OnError)
+		config.HookDisabledWarnings = append(config.HookDisabledWarnings, config.disabledWarnings...)
+		return parseGlobalHookCommand(parts[1:], &config.OnError, &config.OnErrorOptions)
 	default:
 		return fmt.Errorf("unknown command: %s", command)
 	}
@@ -141,6 +446,11 @@ func parseVarCommand(args []string, config *OtterfileConfig) error {
 		return fmt.Errorf("VAR command requires a variable definition")
 	}
 
+	// KEY REQUIRED [VALUE...] declares a variable that must be provided, optionally with one of the values
+	if len(args) >= 2 && strings.ToUpper(args[1]) == "REQUIRED" && !strings.Contains(args[0], "=") {
+		return parseRequiredVar(args[0], args[2:], config)
+	}
+
 	// Join all args back into a single string in case the value contains spaces
 	varDef := strings.Join(args, " ")
 
@@ -153,35 +463,350 @@ func parseVarCommand(args []string, config *OtterfileConfig) error {
 	key := strings.TrimSpace(parts[0])
 	value := strings.TrimSpace(parts[1])
 
+	// KEY ?= VALUE only sets a default, for an earlier VAR or an OTTER_ environment variable to override
+	key, isDefault := strings.CutSuffix(key, "?")
+	key = strings.TrimSpace(key)
+
 	if key == "" {
 		return fmt.Errorf("variable name cannot be empty")
 	}
 
+	_, defined := config.Variables[key]
+	if defined && (config.variableMode == variablesFixed || isDefault) {
+		return nil
+	}
+	if isDefault {
+		if envValue := os.Getenv("OTTER_" + strings.ToUpper(key)); envValue != "" {
+			config.Variables[key] = envValue
+			return nil
+		}
+	}
+
 	// Apply variable substitution to the value using previously defined variables
-	resolvedValue := substituteVariables(value, config.Variables)
+	resolvedValue := config.substituteVariables(value, config.Variables)
+	if err := config.checkSubstituted(resolvedValue); err != nil {
+		return err
+	}
 	config.Variables[key] = resolvedValue
 	return nil
 }
 
-// parseGlobalHookCommand parses a global hook command (ON_BEFORE_BUILD, ON_AFTER_BUILD, ON_ERROR)
-func parseGlobalHookCommand(args []string, hookSlice *[]string) error {
+// parseRequiredVar checks a VAR KEY REQUIRED declaration: the variable must have a value from an
+// earlier VAR, a stacked Otterfile, or the environment, and when values are listed, one of them
+func parseRequiredVar(key string, args []string, config *OtterfileConfig) error {
+	// A later file of the stack may provide the variable, so it is checked on the next pass
+	if config.variableMode == variablesResolving {
+		return nil
+	}
+
+	value, ok := config.lookupVariable(key, config.Variables)
+	if !ok || value == "" {
+		return fmt.Errorf("variable %s is required; set it with VAR %s=value before this line, in a stacked Otterfile, or with the OTTER_%s environment variable",
+			key, key, strings.ToUpper(key))
+	}
+
+	values := allowedValues(args)
+	if len(values) > 0 && !containsValue(values, value) {
+		return fmt.Errorf("variable %s must be one of %s, got: %s", key, strings.Join(values, ", "), value)
+	}
+
+	config.Variables[key] = value
+	return nil
+}
+
+// allowedValues returns the values listed after REQUIRED, separated by spaces or commas
+func allowedValues(args []string) []string {
+	var values []string
+	for _, arg := range args {
+		values = append(values, strings.FieldsFunc(arg, func(r rune) bool { return r == ',' })...)
+	}
+	return values
+}
+
+// containsValue reports whether values contains value
+func containsValue(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+	return false
+}
+
+// envNamePattern matches the environment variable names ENV accepts, which any shell can read
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseEnvCommand parses an ENV command, which sets an environment variable for every hook command
+// of the build, e.g. ENV DATABASE_URL=postgres://localhost/${PROJECT_NAME}
+func parseEnvCommand(args []string, config *OtterfileConfig) error {
 	if len(args) == 0 {
-		return fmt.Errorf("hook command requires command array")
+		return fmt.Errorf("ENV command requires a variable definition")
+	}
+
+	// Join all args back into a single string in case the value contains spaces
+	definition := strings.Join(args, " ")
+	key, value, ok := strings.Cut(definition, "=")
+	if !ok {
+		return fmt.Errorf("ENV command must be in format 'KEY=VALUE', got: %s", definition)
+	}
+	key = strings.TrimSpace(key)
+	if !envNamePattern.MatchString(key) {
+		return fmt.Errorf("ENV name must be letters, digits, and underscores, not starting with a digit, got: %s", key)
 	}
 
-	// Join all args to handle JSON array syntax: ["cmd1", "cmd2"]
-	jsonStr := strings.Join(args, " ")
+	value = config.substituteVariables(strings.TrimSpace(value), config.Variables)
+	if err := config.checkSubstituted(value); err != nil {
+		return err
+	}
+	config.Env = append(config.Env, key+"="+value)
+	return nil
+}
 
-	// Parse as JSON array
-	var commands []string
-	if err := json.Unmarshal([]byte(jsonStr), &commands); err != nil {
-		return fmt.Errorf("failed to parse hook commands as JSON array: %w", err)
+// parseToolsCommand parses a TOOLS command, e.g. TOOLS go>=1.22 node>=20 git
+func parseToolsCommand(args []string, config *OtterfileConfig) error {
+	if len(args) == 0 {
+		return fmt.Errorf("TOOLS command requires at least one tool")
+	}
+
+	for _, arg := range args {
+		requirement, err := parseToolRequirement(config.substituteVariables(arg, config.Variables))
+		if err != nil {
+			return err
+		}
+		config.Tools = append(config.Tools, requirement)
+	}
+
+	return nil
+}
+
+// parseToolRequirement parses name[<op><version>]
+func parseToolRequirement(spec string) (ToolRequirement, error) {
+	index := strings.IndexAny(spec, "<>=")
+	if index < 0 {
+		return ToolRequirement{Name: spec}, nil
+	}
+
+	requirement := ToolRequirement{Name: spec[:index]}
+	rest := spec[index:]
+	for _, operator := range []string{">=", "<=", "==", ">", "<", "="} {
+		if strings.HasPrefix(rest, operator) {
+			requirement.Operator = operator
+			requirement.Version = rest[len(operator):]
+			break
+		}
+	}
+	if requirement.Operator == "==" {
+		requirement.Operator = "="
+	}
+
+	if requirement.Name == "" || requirement.Operator == "" || requirement.Version == "" ||
+		strings.ContainsAny(requirement.Version, "<>=") {
+		return ToolRequirement{}, fmt.Errorf("TOOLS entries must be in format 'name' or 'name>=version', got: %s", spec)
+	}
+
+	return requirement, nil
+}
+
+// parseIgnoreCommand parses an IGNORE command, e.g. IGNORE PRESET node,python or IGNORE CASE auto
+func parseIgnoreCommand(args []string, config *OtterfileConfig) error {
+	if len(args) < 2 {
+		return fmt.Errorf("IGNORE command must be in format 'IGNORE PRESET <name>[,<name>...]' or 'IGNORE CASE <mode>'")
+	}
+
+	value := config.substituteVariables(strings.Join(args[1:], " "), config.Variables)
+
+	switch strings.ToUpper(args[0]) {
+	case "PRESET":
+		names := util.SplitPresetNames(value)
+		if _, err := util.ExpandIgnorePresets(names); err != nil {
+			return err
+		}
+		config.IgnorePresets = append(config.IgnorePresets, names...)
+	case "CASE":
+		if _, err := util.ResolveIgnoreCase(value); err != nil {
+			return err
+		}
+		config.IgnoreCase = strings.ToLower(value)
+	default:
+		return fmt.Errorf("IGNORE command must be in format 'IGNORE PRESET <name>[,<name>...]' or 'IGNORE CASE <mode>'")
+	}
+
+	return nil
+}
+
+// parseGlobalHookCommand parses a global hook command (ON_BEFORE_BUILD, ON_AFTER_BUILD, ON_ERROR),
+// a JSON array of commands followed by options for them
+func parseGlobalHookCommand(args []string, hookSlice *[]string, optionsSlice *[]util.HookOptions) error {
+	if len(args) == 0 {
+		return fmt.Errorf("hook command requires command array")
+	}
+
+	commands, end, err := parseCommandArray(args, 0, "hook")
+	if err != nil {
+		return err
+	}
+	options, end, err := parseHookOptions(args, end, len(commands))
+	if err != nil {
+		return err
+	}
+	if err := rejectOnce(options); err != nil {
+		return err
+	}
+	if end+1 < len(args) {
+		return fmt.Errorf("unknown hook option: %s", args[end+1])
 	}
 
 	*hookSlice = commands
+	*optionsSlice = options
+	return nil
+}
+
+// parseHookOptions parses the options following a hook's command array, whose last argument is
+// args[end], and returns them for each of its commands along with the index of the last argument
+// consumed. The options are nil when none are given.
+func parseHookOptions(args []string, end int, commands int) ([]util.HookOptions, int, error) {
+	var hook util.HookOptions
+	found := false
+options:
+	for end+1 < len(args) {
+		switch strings.ToUpper(args[end+1]) {
+		case "INTERACTIVE":
+			hook.Interactive = true
+		case "CONTINUE_ON_ERROR":
+			hook.ContinueOnError = true
+		case "ONCE":
+			hook.Once = true
+		case "RETRIES":
+			if end+2 >= len(args) {
+				return nil, end, fmt.Errorf("RETRIES requires a number of retries")
+			}
+			retries, err := strconv.Atoi(args[end+2])
+			if err != nil || retries < 0 {
+				return nil, end, fmt.Errorf("invalid RETRIES value: %s", args[end+2])
+			}
+			hook.Retries = retries
+			end++
+		case "WORKDIR":
+			if end+2 >= len(args) {
+				return nil, end, fmt.Errorf("WORKDIR requires a directory")
+			}
+			if !filepath.IsLocal(args[end+2]) {
+				return nil, end, fmt.Errorf("WORKDIR must be a directory inside the project: %s", args[end+2])
+			}
+			hook.Workdir = args[end+2]
+			end++
+		case "ENV":
+			if end+2 >= len(args) || !strings.Contains(args[end+2], "=") {
+				return nil, end, fmt.Errorf("ENV requires a KEY=VALUE variable")
+			}
+			hook.Env = append(hook.Env, args[end+2])
+			end++
+		case "TIMEOUT":
+			if end+2 >= len(args) {
+				return nil, end, fmt.Errorf("TIMEOUT requires a duration")
+			}
+			timeout, err := time.ParseDuration(args[end+2])
+			if err != nil || timeout <= 0 {
+				return nil, end, fmt.Errorf("invalid TIMEOUT value: %s", args[end+2])
+			}
+			hook.Timeout = timeout
+			end++
+		case "WHEN":
+			if end+2 >= len(args) {
+				return nil, end, fmt.Errorf("WHEN requires a condition")
+			}
+			condition, next, err := parseConditionArgs(args, end+1)
+			if err != nil {
+				return nil, end, fmt.Errorf("invalid WHEN condition: %w", err)
+			}
+			if _, err := parseConditionExpression(condition); err != nil {
+				return nil, end, fmt.Errorf("invalid WHEN condition '%s': %w", condition, err)
+			}
+			hook.When = condition
+			end = next - 1
+		default:
+			break options
+		}
+		found = true
+		end++
+	}
+	if !found {
+		return nil, end, nil
+	}
+	return repeatHookOptions(hook, commands), end, nil
+}
+
+// rejectOnce returns an error when ONCE is used outside a layer's AFTER hook
+func rejectOnce(options []util.HookOptions) error {
+	if len(options) > 0 && options[0].Once {
+		return fmt.Errorf("ONCE is only supported on layer AFTER hooks")
+	}
 	return nil
 }
 
+// repeatHookOptions returns options for each of a hook's commands
+func repeatHookOptions(hook util.HookOptions, commands int) []util.HookOptions {
+	options := make([]util.HookOptions, commands)
+	for i := range options {
+		options[i] = hook
+	}
+	return options
+}
+
+// appendHookOptions appends the options of commands added to a hook, padding the existing
+// options so each stays at the index of its command
+func appendHookOptions(options []util.HookOptions, commands int, more []util.HookOptions) []util.HookOptions {
+	if len(more) == 0 {
+		return options
+	}
+	for len(options) < commands {
+		options = append(options, util.HookOptions{})
+	}
+	return append(options, more...)
+}
+
+// parseConditionArgs parses the condition of an IF clause at args[i], whose terms can be negated
+// with NOT and combined with AND and OR, e.g. IF env=production AND NOT os=windows. It returns
+// the condition and the index of the last argument consumed.
+func parseConditionArgs(args []string, i int) (string, int, error) {
+	if i+1 >= len(args) {
+		return "", 0, fmt.Errorf("IF requires a condition argument")
+	}
+	var terms []string
+	for {
+		if i+1 < len(args) && strings.ToUpper(args[i+1]) == "NOT" {
+			terms = append(terms, "NOT")
+			i++
+		}
+		if i+1 >= len(args) && len(terms) == 0 {
+			return "", 0, fmt.Errorf("IF requires a condition argument")
+		}
+		if i+1 >= len(args) {
+			return "", 0, fmt.Errorf("IF condition ends with %s", terms[len(terms)-1])
+		}
+		terms = append(terms, args[i+1])
+		i++ // Skip the next argument as it's the condition
+		if i+1 >= len(args) {
+			break
+		}
+		operator := strings.ToUpper(args[i+1])
+		if operator != "AND" && operator != "OR" {
+			break
+		}
+		terms = append(terms, operator)
+		i++
+	}
+	return strings.Join(terms, " "), i, nil
+}
+
+// layerKeywords are the arguments that start a clause of a LAYER command
+var layerKeywords = map[string]bool{
+	"TARGET": true, "NAME": true, "IF": true, "TEMPLATE": true, "WITH": true, "DELIMS": true,
+	"TYPE": true, "BEFORE": true, "AFTER": true, "GENERATE": true, "ALLOW": true,
+	"ALLOW_HIDDEN": true, "ONLY": true, "MAP": true, "FROZEN": true, "STRATEGY": true,
+	"DEPENDS": true, "BRANCH": true,
+}
+
 // parseLayerCommand parses a LAYER command
 func parseLayerCommand(args []string, config *OtterfileConfig) error {
 	if len(args) == 0 {
@@ -196,6 +821,7 @@ func parseLayerCommand(args []string, config *OtterfileConfig) error {
 	}
 
 	// Parse optional TARGET, IF, and TEMPLATE arguments
+	var mappings []string
 	for i := 1; i < len(args); i++ {
 		arg := strings.ToUpper(args[i])
 		switch arg {
@@ -205,12 +831,19 @@ func parseLayerCommand(args []string, config *OtterfileConfig) error {
 			}
 			layer.Target = args[i+1]
 			i++ // Skip the next argument as it's the target path
-		case "IF":
+		case "NAME":
 			if i+1 >= len(args) {
-				return fmt.Errorf("IF requires a condition argument")
+				return fmt.Errorf("NAME requires a layer name argument")
+			}
+			layer.Name = args[i+1]
+			i++ // Skip the next argument as it's the name
+		case "IF":
+			condition, next, err := parseConditionArgs(args, i)
+			if err != nil {
+				return err
 			}
-			layer.Condition = args[i+1]
-			i++ // Skip the next argument as it's the condition
+			layer.Condition = condition
+			i = next // Skip processed arguments
 		case "TEMPLATE":
 			if i+1 >= len(args) {
 				return fmt.Errorf("TEMPLATE requires template variable assignments")
@@ -231,104 +864,348 @@ func parseLayerCommand(args []string, config *OtterfileConfig) error {
 				}
 				i = j // Move the outer loop index forward
 			}
+		case "WITH":
+			// Layer-scoped variables (KEY=VALUE, possibly multiple) shadow VARs for this layer only
+			start := i
+			for i+1 < len(args) && strings.Contains(args[i+1], "=") {
+				key, value, _ := strings.Cut(args[i+1], "=")
+				if key = strings.TrimSpace(key); key == "" {
+					return fmt.Errorf("WITH variable name cannot be empty")
+				}
+				if layer.Variables == nil {
+					layer.Variables = make(map[string]string)
+				}
+				layer.Variables[key] = config.substituteVariables(strings.TrimSpace(value), config.Variables)
+				if err := config.checkSubstituted(layer.Variables[key]); err != nil {
+					return err
+				}
+				i++
+			}
+			if i == start {
+				return fmt.Errorf("WITH requires variable assignments (KEY=VALUE)")
+			}
 		case "DELIMS":
 			if i+2 >= len(args) {
 				return fmt.Errorf("DELIMS requires left and right delimiter arguments")
 			}
 			layer.Delims = [2]string{args[i+1], args[i+2]}
 			i += 2 // Skip the two delimiter arguments
-		case "BEFORE":
+		case "TYPE":
 			if i+1 >= len(args) {
-				return fmt.Errorf("BEFORE requires a command array")
+				return fmt.Errorf("TYPE requires a layer type argument")
 			}
-			// Find the JSON array for BEFORE commands
-			jsonStart := i + 1
-			if !strings.HasPrefix(args[jsonStart], "[") {
-				return fmt.Errorf("BEFORE commands must be in JSON array format")
+			switch layerType := strings.ToLower(args[i+1]); layerType {
+			case "files":
+				layer.Type = ""
+			case LayerTypeDevbox, LayerTypeNix, LayerTypePatch, LayerTypeGenerator:
+				layer.Type = layerType
+			default:
+				return fmt.Errorf("unknown layer type: %s (expected files, devbox, nix, patch, or generator)", args[i+1])
 			}
-			// Find the end of the JSON array
-			jsonEnd := jsonStart
-			for jsonEnd < len(args) && !strings.HasSuffix(args[jsonEnd], "]") {
-				jsonEnd++
+			i++ // Skip the next argument as it's the layer type
+		case "BEFORE":
+			commands, next, err := parseCommandArray(args, i+1, "BEFORE")
+			if err != nil {
+				return err
 			}
-			if jsonEnd >= len(args) {
-				return fmt.Errorf("BEFORE command array not properly closed")
+			layer.Before = commands
+			if layer.BeforeOptions, next, err = parseHookOptions(args, next, len(commands)); err != nil {
+				return err
 			}
-			// Parse the JSON array
-			jsonStr := strings.Join(args[jsonStart:jsonEnd+1], " ")
-			if err := json.Unmarshal([]byte(jsonStr), &layer.Before); err != nil {
-				return fmt.Errorf("failed to parse BEFORE commands: %w", err)
+			if err := rejectOnce(layer.BeforeOptions); err != nil {
+				return err
 			}
-			i = jsonEnd // Skip processed arguments
+			i = next // Skip processed arguments
 		case "AFTER":
+			commands, next, err := parseCommandArray(args, i+1, "AFTER")
+			if err != nil {
+				return err
+			}
+			layer.After = commands
+			if layer.AfterOptions, next, err = parseHookOptions(args, next, len(commands)); err != nil {
+				return err
+			}
+			i = next // Skip processed arguments
+		case "GENERATE":
+			commands, next, err := parseCommandArray(args, i+1, "GENERATE")
+			if err != nil {
+				return err
+			}
+			layer.Generate = commands
+			i = next // Skip processed arguments
+		case "ALLOW":
+			// Protected file names all start with a dot, which sets them apart from the next keyword
+			allowed := len(layer.Allow)
+			for i+1 < len(args) && strings.HasPrefix(args[i+1], ".") {
+				if err := util.CheckAllowProtected(args[i+1]); err != nil {
+					return err
+				}
+				layer.Allow = append(layer.Allow, args[i+1])
+				i++
+			}
+			if len(layer.Allow) == allowed {
+				return fmt.Errorf("ALLOW requires at least one protected file name")
+			}
+		case "ALLOW_HIDDEN":
+			// Without names every hidden file at the layer root is allowed
+			allowed := len(layer.AllowHidden)
+			for i+1 < len(args) && strings.HasPrefix(args[i+1], ".") {
+				layer.AllowHidden = append(layer.AllowHidden, strings.TrimSuffix(args[i+1], "/"))
+				i++
+			}
+			if len(layer.AllowHidden) == allowed {
+				layer.AllowHidden = append(layer.AllowHidden, "*")
+			}
+		case "ONLY":
+			// Paths continue up to the next LAYER keyword
+			selected := len(layer.Only)
+			for i+1 < len(args) && !layerKeywords[strings.ToUpper(args[i+1])] {
+				layer.Only = append(layer.Only, args[i+1])
+				i++
+			}
+			if len(layer.Only) == selected {
+				return fmt.Errorf("ONLY requires at least one path")
+			}
+		case "MAP":
+			// Mappings (FROM=TO, possibly multiple) are resolved once variables are substituted
+			start := i
+			for i+1 < len(args) && strings.Contains(args[i+1], "=") {
+				mappings = append(mappings, args[i+1])
+				i++
+			}
+			if i == start {
+				return fmt.Errorf("MAP requires path mappings (FROM=TO)")
+			}
+		case "FROZEN":
+			layer.Frozen = true
+		case "BRANCH":
 			if i+1 >= len(args) {
-				return fmt.Errorf("AFTER requires a command array")
+				return fmt.Errorf("BRANCH requires a branch name")
 			}
-			// Find the JSON array for AFTER commands
-			jsonStart := i + 1
-			if !strings.HasPrefix(args[jsonStart], "[") {
-				return fmt.Errorf("AFTER commands must be in JSON array format")
+			layer.Branch = args[i+1]
+			i++ // Skip the next argument as it's the branch
+		case "DEPENDS":
+			// Names continue up to the next LAYER keyword
+			depends := len(layer.Depends)
+			for i+1 < len(args) && !layerKeywords[strings.ToUpper(args[i+1])] {
+				layer.Depends = append(layer.Depends, args[i+1])
+				i++
 			}
-			// Find the end of the JSON array
-			jsonEnd := jsonStart
-			for jsonEnd < len(args) && !strings.HasSuffix(args[jsonEnd], "]") {
-				jsonEnd++
+			if len(layer.Depends) == depends {
+				return fmt.Errorf("DEPENDS requires at least one layer NAME")
 			}
-			if jsonEnd >= len(args) {
-				return fmt.Errorf("AFTER command array not properly closed")
+		case "STRATEGY":
+			if i+1 >= len(args) {
+				return fmt.Errorf("STRATEGY requires one of: %s", strings.Join(util.Strategies, ", "))
 			}
-			// Parse the JSON array
-			jsonStr := strings.Join(args[jsonStart:jsonEnd+1], " ")
-			if err := json.Unmarshal([]byte(jsonStr), &layer.After); err != nil {
-				return fmt.Errorf("failed to parse AFTER commands: %w", err)
+			layer.Strategy = strings.ToLower(args[i+1])
+			if !util.IsStrategy(layer.Strategy) {
+				return fmt.Errorf("unknown STRATEGY %s; use one of: %s", args[i+1], strings.Join(util.Strategies, ", "))
 			}
-			i = jsonEnd // Skip processed arguments
+			i++ // Skip the next argument as it's the strategy
 		default:
 			return fmt.Errorf("unknown LAYER argument: %s", args[i])
 		}
 	}
 
+	// A generator command makes this a generator layer, and a generator layer needs one
+	if len(layer.Generate) > 0 && layer.Type == "" {
+		layer.Type = LayerTypeGenerator
+	}
+	if layer.Type == LayerTypeGenerator && len(layer.Generate) == 0 {
+		return fmt.Errorf("generator layers require GENERATE commands")
+	}
+	if layer.Type != LayerTypeGenerator && len(layer.Generate) > 0 {
+		return fmt.Errorf("GENERATE can only be used with generator layers")
+	}
+	if layer.Strategy != "" && layer.Type != "" && layer.Type != LayerTypeGenerator {
+		return fmt.Errorf("STRATEGY can only be used with file and generator layers")
+	}
+
+	if layer.Name != "" {
+		for _, existing := range config.Layers {
+			if existing.Name == layer.Name {
+				return fmt.Errorf("duplicate layer NAME: %s", layer.Name)
+			}
+		}
+	}
+
 	// Apply variable substitution to repository URL and target
-	layer.Repository = substituteVariables(layer.Repository, config.Variables)
-	layer.Target = substituteVariables(layer.Target, config.Variables)
+	variables := config.LayerVariables(layer)
+	layer.Repository = config.substituteVariables(layer.Repository, variables)
+	layer.Target = config.substituteVariables(layer.Target, variables)
+	if err := config.checkSubstituted(layer.Repository); err != nil {
+		return err
+	}
+	var url string
+	if url, layer.Ref = util.SplitLayerRef(layer.Repository); url != layer.Repository && layer.Ref == "" {
+		return fmt.Errorf("LAYER %s needs a branch, tag, or commit after @", layer.Repository)
+	}
+	if err := config.checkSubstituted(layer.Target); err != nil {
+		return err
+	}
+	if err := config.checkBranch(layer); err != nil {
+		return err
+	}
+	for i, selected := range layer.Only {
+		layer.Only[i] = config.substituteVariables(selected, variables)
+		if err := config.checkSubstituted(layer.Only[i]); err != nil {
+			return err
+		}
+	}
+	for _, spec := range mappings {
+		spec = config.substituteVariables(spec, variables)
+		if err := config.checkSubstituted(spec); err != nil {
+			return err
+		}
+		mapping, err := util.ParsePathMapping(spec)
+		if err != nil {
+			return err
+		}
+		layer.Map = append(layer.Map, mapping)
+	}
 
 	// Apply variable substitution to template values
 	for key, value := range layer.Template {
-		layer.Template[key] = substituteVariables(value, config.Variables)
+		layer.Template[key] = config.substituteVariables(value, variables)
+		if err := config.checkSubstituted(layer.Template[key]); err != nil {
+			return err
+		}
+	}
+	if err := config.applyGroup(&layer); err != nil {
+		return err
 	}
+	layer.DisabledWarnings = config.disabledWarnings
+	layer.Comment = config.comment
 
 	config.Layers = append(config.Layers, layer)
 	return nil
 }
 
-// substituteVariables replaces ${VAR_NAME} placeholders with actual variable values
-func substituteVariables(text string, variables map[string]string) string {
-	// Regular expression to match ${VAR_NAME} patterns
-	re := regexp.MustCompile(`\$\{([^}]+)\}`)
+// LayerVariables returns the variables ${...} placeholders in a LAYER command resolve against:
+// the layer's WITH variables, then the global VARs
+func (config *OtterfileConfig) LayerVariables(layer Layer) map[string]string {
+	variables := make(map[string]string, len(config.Variables)+len(layer.Variables))
+	for key, value := range config.Variables {
+		variables[key] = value
+	}
+	for key, value := range layer.Variables {
+		variables[key] = value
+	}
+	return variables
+}
 
-	return re.ReplaceAllStringFunc(text, func(match string) string {
-		// Extract the variable name from ${VAR_NAME}
-		varName := match[2 : len(match)-1] // Remove ${ and }
+// parseCommandArray parses a JSON array of commands starting at args[start], which may span
+// several arguments. It returns the commands and the index of the last argument consumed.
+func parseCommandArray(args []string, start int, name string) ([]string, int, error) {
+	if start >= len(args) {
+		return nil, 0, fmt.Errorf("%s requires a command array", name)
+	}
+	if !strings.HasPrefix(args[start], "[") {
+		return nil, 0, fmt.Errorf("%s commands must be in JSON array format", name)
+	}
 
-		// First check custom variables defined in Otterfile
-		if value, exists := variables[varName]; exists {
-			return value
+	// Decode one array from the rest of the command, so a ] inside a command, as in [ -f file ],
+	// doesn't end it early, then find the argument it ends in
+	rest := strings.Join(args[start:], " ")
+	decoder := json.NewDecoder(strings.NewReader(rest))
+	var commands []string
+	if err := decoder.Decode(&commands); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, 0, fmt.Errorf("%s command array not properly closed", name)
 		}
+		return nil, 0, fmt.Errorf("failed to parse %s commands: %w", name, err)
+	}
+
+	offset := int(decoder.InputOffset())
+	end, length := start, len(args[start])
+	for length < offset {
+		end++
+		length += 1 + len(args[end])
+	}
+	if length != offset {
+		return nil, 0, fmt.Errorf("failed to parse %s commands: unexpected %q after the array", name, rest[offset:length])
+	}
+	return commands, end, nil
+}
 
-		// Then check environment variables (with OTTER_ prefix)
-		envVarName := "OTTER_" + strings.ToUpper(varName)
-		if value := os.Getenv(envVarName); value != "" {
-			return value
+// placeholderPattern matches ${VAR_NAME} placeholders
+var placeholderPattern = regexp.MustCompile(`\$\{([^}]+)\}`)
+
+// detectUser returns the developer facts of the project in dir, detected when first used, for
+// ${...} substitution while one Otterfile or stack is parsed
+func detectUser(dir string) func() *util.UserInfo {
+	return sync.OnceValue(func() *util.UserInfo {
+		return util.DetectUser(dir)
+	})
+}
+
+// substituteVariables replaces ${VAR_NAME} placeholders with actual variable values. Once the
+// text grows longer than MaxLineLength the remaining placeholders are left as they are, so values
+// that repeat other variables can't grow it without bound.
+func (config *OtterfileConfig) substituteVariables(text string, variables map[string]string) string {
+	length := len(text)
+	return placeholderPattern.ReplaceAllStringFunc(text, func(match string) string {
+		if length > MaxLineLength {
+			return match
+		}
+		// Extract the variable name from ${VAR_NAME}
+		value, found := config.lookupVariable(match[2:len(match)-1], variables) // Remove ${ and }
+		if !found {
+			// If variable is not found, return the original placeholder
+			return match
 		}
+		length += len(value) - len(match)
+		return value
+	})
+}
 
-		// Finally check direct environment variables
-		if value := os.Getenv(varName); value != "" {
-			return value
+// lookupVariable returns the value of a variable used in a ${VAR_NAME} placeholder
+func (config *OtterfileConfig) lookupVariable(varName string, variables map[string]string) (string, bool) {
+	// First check custom variables defined in Otterfile
+	if value, exists := variables[varName]; exists {
+		return value, true
+	}
+
+	// Then check environment variables (with OTTER_ prefix), which override developer facts
+	envVarName := "OTTER_" + strings.ToUpper(varName)
+	if value := os.Getenv(envVarName); value != "" {
+		return value, true
+	}
+
+	// Then developer facts such as ${user.email}
+	if config.user != nil {
+		if value, ok := config.user().Fact(varName); ok && value != "" {
+			return value, true
 		}
+	}
 
-		// If variable is not found, return the original placeholder
-		return match
-	})
+	// Finally check direct environment variables
+	if value := os.Getenv(varName); value != "" {
+		return value, true
+	}
+
+	return "", false
+}
+
+// checkSubstituted rejects text that substitution made longer than MaxLineLength and warns about
+// ${VAR_NAME} placeholders it left in text
+func (config *OtterfileConfig) checkSubstituted(text string) error {
+	if len(text) > MaxLineLength {
+		return fmt.Errorf("value is longer than %d bytes after substituting variables", MaxLineLength)
+	}
+	for _, code := range config.disabledWarnings {
+		if code == util.WarnUnknownVariable {
+			return nil
+		}
+	}
+	for _, match := range placeholderPattern.FindAllString(text, -1) {
+		config.Warnings = append(config.Warnings, util.Warning{
+			Code:    util.WarnUnknownVariable,
+			Message: fmt.Sprintf("%s line %d: unknown variable %s left unsubstituted", config.source, config.line, match),
+		})
+	}
+	return nil
 }
 
 // FindOtterfile looks for Otterfile or Envfile in the current directory
@@ -344,21 +1221,62 @@ func FindOtterfile() (string, error) {
 	return "", fmt.Errorf("no Otterfile or Envfile found in current directory")
 }
 
-// parseCondition parses a condition string (e.g., "env=development")
+// parseCondition parses a condition string (e.g., "env=development", "os!=windows", "env~=prod.*",
+// or "version>=1.21")
 func parseCondition(conditionStr string) (*Condition, error) {
 	if conditionStr == "" {
 		return nil, fmt.Errorf("condition cannot be empty")
 	}
 
-	parts := strings.SplitN(conditionStr, "=", 2)
-	if len(parts) != 2 {
-		return nil, fmt.Errorf("condition must be in format 'key=value', got: %s", conditionStr)
+	index := strings.IndexAny(conditionStr, "=<>")
+	if index < 0 {
+		return nil, fmt.Errorf("condition must be in format 'key=value', 'key!=value', 'key~=pattern', or 'key>=version', got: %s", conditionStr)
+	}
+
+	key, rest := conditionStr[:index], conditionStr[index:]
+	condition := &Condition{Operator: "="}
+	for _, operator := range []string{">=", "<=", ">", "<"} {
+		if strings.HasPrefix(rest, operator) {
+			condition.Operator = operator
+			break
+		}
+	}
+	value := strings.TrimPrefix(rest, condition.Operator)
+	if condition.Operator == "=" {
+		if trimmed, ok := strings.CutSuffix(key, "!"); ok {
+			key, condition.Negate = trimmed, true
+		} else if trimmed, ok := strings.CutSuffix(key, "~"); ok {
+			key, condition.Operator = trimmed, "~="
+		}
 	}
+	condition.Key = strings.TrimSpace(key)
+	condition.Value = strings.TrimSpace(value)
 
-	return &Condition{
-		Key:   strings.TrimSpace(parts[0]),
-		Value: strings.TrimSpace(parts[1]),
-	}, nil
+	switch condition.Operator {
+	case "~=":
+		if _, err := matchConditionPattern(condition.Value, ""); err != nil {
+			return nil, err
+		}
+	case ">=", "<=", ">", "<":
+		if !conditionVersionPattern.MatchString(condition.Value) {
+			return nil, fmt.Errorf("condition %s must compare against a version such as 1.21, got: %s", conditionStr, condition.Value)
+		}
+	}
+	return condition, nil
+}
+
+// conditionVersionPattern matches the versions conditions can compare, e.g. 1.21 or v2.0.3
+var conditionVersionPattern = regexp.MustCompile(`^v?\d+(\.\d+)*$`)
+
+// matchConditionPattern reports whether value matches pattern as a glob, e.g. prod*, or as a
+// regular expression matching the whole value, e.g. prod.*. A pattern that is neither is an error.
+func matchConditionPattern(pattern, value string) (bool, error) {
+	globMatched, globErr := path.Match(pattern, value)
+	expression, regexpErr := regexp.Compile("^(?:" + pattern + ")$")
+	if globErr != nil && regexpErr != nil {
+		return false, fmt.Errorf("invalid pattern %s: %w", pattern, regexpErr)
+	}
+	return globMatched || (regexpErr == nil && expression.MatchString(value)), nil
 }
 
 // evaluateCondition evaluates a condition against the current environment
@@ -367,11 +1285,45 @@ func evaluateCondition(condition *Condition) (bool, error) {
 		return true, nil
 	}
 
-	switch condition.Key {
+	value, err := conditionValue(condition.Key)
+	if err != nil {
+		return false, err
+	}
+
+	var matched bool
+	switch condition.Operator {
+	case "", "=":
+		matched = condition.Value == value
+	case "~=":
+		if matched, err = matchConditionPattern(condition.Value, value); err != nil {
+			return false, err
+		}
+	default:
+		// Values that aren't versions, such as unset variables, never satisfy a comparison
+		if conditionVersionPattern.MatchString(value) {
+			cmp := util.CompareVersions(value, condition.Value)
+			switch condition.Operator {
+			case ">=":
+				matched = cmp >= 0
+			case ">":
+				matched = cmp > 0
+			case "<=":
+				matched = cmp <= 0
+			case "<":
+				matched = cmp < 0
+			}
+		}
+	}
+	return matched != condition.Negate, nil
+}
+
+// conditionValue returns the current value of a condition key
+func conditionValue(key string) (string, error) {
+	switch key {
 	case "os":
-		return condition.Value == runtime.GOOS, nil
+		return runtime.GOOS, nil
 	case "arch":
-		return condition.Value == runtime.GOARCH, nil
+		return runtime.GOARCH, nil
 	case "env", "environment":
 		envValue := os.Getenv("OTTER_ENV")
 		if envValue == "" {
@@ -383,7 +1335,7 @@ func evaluateCondition(condition *Condition) (bool, error) {
 		if envValue == "" {
 			envValue = "development" // Default to development
 		}
-		return condition.Value == envValue, nil
+		return envValue, nil
 	case "editor":
 		editorValue := os.Getenv("OTTER_EDITOR")
 		if editorValue == "" {
@@ -397,30 +1349,145 @@ func evaluateCondition(condition *Condition) (bool, error) {
 				editorValue = "cursor"
 			}
 		}
-		return condition.Value == editorValue, nil
+		return editorValue, nil
 	default:
+		// Project facts, e.g. project.branch=main
+		if fact, ok := strings.CutPrefix(key, "project."); ok {
+			value, known := util.DetectProject(".").Fact(fact)
+			if !known {
+				return "", fmt.Errorf("unknown project fact: %s", key)
+			}
+			return value, nil
+		}
+
 		// Check for custom environment variables
-		envVarName := "OTTER_" + strings.ToUpper(condition.Key)
-		envValue := os.Getenv(envVarName)
-		return condition.Value == envValue, nil
+		return os.Getenv("OTTER_" + strings.ToUpper(key)), nil
 	}
 }
 
+// parseConditionExpression parses conditions, each optionally negated with NOT, combined
+// with AND and OR into the alternatives that satisfy it, each a list of conditions that must all
+// match. AND binds tighter than OR, so "a=1 OR b=2 AND c=3" holds when a=1, or when both b=2 and c=3.
+func parseConditionExpression(expression string) ([][]*Condition, error) {
+	var alternatives [][]*Condition
+	var all []*Condition
+	var term []string
+	negate := false
+	endTerm := func() error {
+		condition, err := parseCondition(strings.Join(term, " "))
+		if err != nil {
+			return err
+		}
+		condition.Negate = condition.Negate != negate
+		all = append(all, condition)
+		term = nil
+		negate = false
+		return nil
+	}
+
+	for _, field := range strings.Fields(expression) {
+		if len(term) == 0 && strings.ToUpper(field) == "NOT" {
+			negate = !negate
+			continue
+		}
+		switch strings.ToUpper(field) {
+		case "AND":
+			if err := endTerm(); err != nil {
+				return nil, err
+			}
+		case "OR":
+			if err := endTerm(); err != nil {
+				return nil, err
+			}
+			alternatives = append(alternatives, all)
+			all = nil
+		default:
+			term = append(term, field)
+		}
+	}
+	if err := endTerm(); err != nil {
+		return nil, err
+	}
+	return append(alternatives, all), nil
+}
+
 // ShouldApplyLayer determines if a layer should be applied based on its condition
 func (l *Layer) ShouldApplyLayer() (bool, error) {
 	if l.Condition == "" {
 		return true, nil // No condition means always apply
 	}
 
-	condition, err := parseCondition(l.Condition)
+	alternatives, err := parseConditionExpression(l.Condition)
 	if err != nil {
 		return false, fmt.Errorf("failed to parse condition '%s': %w", l.Condition, err)
 	}
 
-	return evaluateCondition(condition)
+	for _, all := range alternatives {
+		matched := true
+		for _, condition := range all {
+			matched, err = evaluateCondition(condition)
+			if err != nil {
+				return false, err
+			}
+			if !matched {
+				break
+			}
+		}
+		if matched {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// EvaluateCondition reports whether an IF condition expression holds, for templates that vary
+// their content the way conditional layers do
+func EvaluateCondition(expression string) (bool, error) {
+	layer := Layer{Condition: expression}
+	return layer.ShouldApplyLayer()
+}
+
+// checkBranch returns an error unless the BRANCH of a layer, if any, is one it can follow: the
+// layer is remote, isn't pinned with @, and doesn't follow another branch in an earlier LAYER
+func (config *OtterfileConfig) checkBranch(layer Layer) error {
+	for _, existing := range config.Layers {
+		if existing.Repository == layer.Repository && existing.Branch != layer.Branch {
+			return fmt.Errorf("LAYER %s follows %s, but an earlier LAYER of it follows %s", layer.Repository, branchName(layer.Branch), branchName(existing.Branch))
+		}
+	}
+	if layer.Branch == "" {
+		return nil
+	}
+	if !util.NewGitOperations("").IsRemoteLayer(layer.Repository) {
+		return fmt.Errorf("BRANCH only applies to remote layers, not %s", layer.Repository)
+	}
+	if layer.Ref != "" {
+		return fmt.Errorf("LAYER %s is pinned to %s, so it can't also follow BRANCH %s", layer.Repository, layer.Ref, layer.Branch)
+	}
+	return nil
+}
+
+// branchName describes the branch a layer follows, which is its default branch when empty
+func branchName(branch string) string {
+	if branch == "" {
+		return "its default branch"
+	}
+	return "BRANCH " + branch
+}
+
+// LayerBranches returns the branches remote layers follow from their BRANCH clauses, by repository
+func (config *OtterfileConfig) LayerBranches() map[string]string {
+	branches := make(map[string]string)
+	for _, layer := range config.Layers {
+		if layer.Branch != "" {
+			branches[layer.Repository] = layer.Branch
+		}
+	}
+	return branches
 }
 
-// FilterApplicableLayers filters layers based on their conditions
+// FilterApplicableLayers filters layers based on their conditions, in the order they are applied:
+// after the layers they DEPEND on, and otherwise in the order they are declared
 func (config *OtterfileConfig) FilterApplicableLayers() ([]Layer, error) {
 	var applicableLayers []Layer
 
@@ -435,5 +1502,5 @@ func (config *OtterfileConfig) FilterApplicableLayers() ([]Layer, error) {
 		}
 	}
 
-	return applicableLayers, nil
+	return orderLayers(applicableLayers, config.Layers)
 }
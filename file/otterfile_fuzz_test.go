@@ -0,0 +1,67 @@
+package file
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// FuzzParseOtterfileReader checks that the parser never panics and treats any input consistently.
+// Malformed inputs found along the way are kept in testdata/fuzz/FuzzParseOtterfileReader.
+func FuzzParseOtterfileReader(f *testing.F) {
+	f.Add([]byte("LAYER git@github.com:example/repo.git TARGET .config IF os=linux\n"))
+	f.Add([]byte("VAR NAME=app\nLAYER ./layer NAME base WITH PORT=8080 TEMPLATE name=${NAME} DELIMS [[ ]]\n"))
+	f.Add([]byte("ON_BEFORE_BUILD: [\"echo start\"] INTERACTIVE RETRIES 2\nON_ERROR: [\"echo failed\"]\n"))
+	f.Add([]byte("LAYER ./layer \\\n  BEFORE [\"[ -f go.mod ]\"] \\\n  AFTER [\"go mod tidy\"] ONCE\n"))
+	f.Add([]byte("LAYER ./gen TYPE generator GENERATE [\"make\"] ALLOW .gitignore ALLOW_HIDDEN\n"))
+	f.Add([]byte("TOOLS go>=1.22 node\nIGNORE PRESET node,python\nIGNORE CASE auto # otter:disable WARN001\n"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		config, err := ParseOtterfileReader(bytes.NewReader(data), "fuzz")
+		again, againErr := ParseOtterfileReader(bytes.NewReader(data), "fuzz")
+		if (err == nil) != (againErr == nil) {
+			t.Fatalf("parsing twice disagreed: %v, then %v", err, againErr)
+		}
+		if err != nil {
+			if err.Error() != againErr.Error() {
+				t.Fatalf("parsing twice returned different errors: %v, then %v", err, againErr)
+			}
+			if !strings.HasPrefix(err.Error(), "error on line ") && len(data) <= MaxOtterfileSize {
+				t.Fatalf("error doesn't name a line: %v", err)
+			}
+			return
+		}
+
+		if len(config.Layers) != len(again.Layers) {
+			t.Fatalf("parsing twice found %d, then %d layers", len(config.Layers), len(again.Layers))
+		}
+		names := make(map[string]bool)
+		for _, layer := range config.Layers {
+			if layer.Name != "" {
+				if names[layer.Name] {
+					t.Fatalf("duplicate layer name %q accepted", layer.Name)
+				}
+				names[layer.Name] = true
+			}
+			if len(layer.BeforeOptions) != 0 && len(layer.BeforeOptions) != len(layer.Before) {
+				t.Fatalf("%d BEFORE options for %d commands", len(layer.BeforeOptions), len(layer.Before))
+			}
+			if len(layer.AfterOptions) != 0 && len(layer.AfterOptions) != len(layer.After) {
+				t.Fatalf("%d AFTER options for %d commands", len(layer.AfterOptions), len(layer.After))
+			}
+			if (layer.Type == LayerTypeGenerator) != (len(layer.Generate) > 0) {
+				t.Fatalf("layer type %q with %d GENERATE commands", layer.Type, len(layer.Generate))
+			}
+			for _, value := range []string{layer.Repository, layer.Target} {
+				if len(value) > MaxLineLength {
+					t.Fatalf("value of %d bytes accepted", len(value))
+				}
+			}
+		}
+		for key, value := range config.Variables {
+			if len(value) > MaxLineLength {
+				t.Fatalf("variable %s of %d bytes accepted", key, len(value))
+			}
+		}
+	})
+}
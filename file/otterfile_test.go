@@ -1,8 +1,10 @@
 package file
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/geoffjay/otter/util"
@@ -189,6 +191,522 @@ LAYER git@github.com:example/third.git TARGET inline`,
 	}
 }
 
+func TestParseOtterfileReader(t *testing.T) {
+	content := `VAR NAME=example
+LAYER git@github.com:example/${NAME}.git TARGET config
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	if len(config.Layers) != 1 {
+		t.Fatalf("Expected 1 layer, got %d", len(config.Layers))
+	}
+	if config.Layers[0].Repository != "git@github.com:example/example.git" {
+		t.Errorf("Expected substituted repository, got %s", config.Layers[0].Repository)
+	}
+
+	_, err = ParseOtterfileReader(strings.NewReader("UNKNOWN foo"), "inline")
+	if err == nil || !contains(err.Error(), "unknown command") {
+		t.Errorf("Expected unknown command error, got %v", err)
+	}
+}
+
+func TestParseOtterfileLimits(t *testing.T) {
+	// Each variable repeats the previous one eight times
+	growth := "VAR V0=aaaaaaaaaaaaaaaa\n"
+	for i := 1; i <= 6; i++ {
+		growth += fmt.Sprintf("VAR V%d=%s\n", i, strings.Repeat(fmt.Sprintf("${V%d}", i-1), 8))
+	}
+
+	tests := []struct {
+		name          string
+		content       string
+		errorContains string
+	}{
+		{"file too large", strings.Repeat("# padding\n", MaxOtterfileSize/10+1), "larger than"},
+		{"line too long", "LAYER ./layer\nLAYER " + strings.Repeat("a", MaxLineLength+1) + "\n", "line 2: line is longer than"},
+		{"continued command too long", strings.Repeat("LAYER "+strings.Repeat("a", 1000)+" \\\n", MaxLineLength/1000+1) + "TARGET x\n", "line 1: command is longer than"},
+		{"variables growing too long", growth, "longer than 65536 bytes after substituting variables"},
+		{"trailing text after command array", `LAYER ./layer BEFORE ["a"]x ["b"]`, `unexpected "x" after the array`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseOtterfileReader(strings.NewReader(tt.content), "inline")
+			if err == nil || !contains(err.Error(), tt.errorContains) {
+				t.Errorf("Expected %q error, got %v", tt.errorContains, err)
+			}
+		})
+	}
+
+	// Many arguments ending in ] are parsed in linear time
+	content := "LAYER ./layer BEFORE [\"" + strings.Repeat("x] ", MaxLineLength/4) + "\"]"
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	if len(config.Layers[0].Before) != 1 {
+		t.Errorf("Expected 1 command, got %d", len(config.Layers[0].Before))
+	}
+}
+
+func TestParseLayerType(t *testing.T) {
+	content := `LAYER ./layers/go-tools TYPE devbox
+LAYER ./layers/nix-tools TARGET env TYPE NIX
+LAYER ./layers/config TYPE files
+LAYER ./layers/tweaks TYPE patch
+LAYER ./layers/client TARGET api GENERATE ["make generate OUT=$OTTER_OUTPUT_DIR"]
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	expected := []string{LayerTypeDevbox, LayerTypeNix, "", LayerTypePatch, LayerTypeGenerator}
+	for i, layerType := range expected {
+		if config.Layers[i].Type != layerType {
+			t.Errorf("Layer %d: expected type %q, got %q", i, layerType, config.Layers[i].Type)
+		}
+	}
+	if config.Layers[1].Target != "env" {
+		t.Errorf("Expected target env, got %s", config.Layers[1].Target)
+	}
+
+	if len(config.Layers[4].Generate) != 1 || config.Layers[4].Generate[0] != "make generate OUT=$OTTER_OUTPUT_DIR" {
+		t.Errorf("Unexpected generator commands: %v", config.Layers[4].Generate)
+	}
+
+	invalid := map[string]string{
+		"LAYER ./layer TYPE conda":                   "unknown layer type",
+		"LAYER ./layer TYPE generator":               "require GENERATE",
+		`LAYER ./layer TYPE patch GENERATE ["make"]`: "only be used with generator",
+		`LAYER ./layer GENERATE ["make generate"`:    "not properly closed",
+	}
+	for content, expectedErr := range invalid {
+		_, err = ParseOtterfileReader(strings.NewReader(content), "inline")
+		if err == nil || !contains(err.Error(), expectedErr) {
+			t.Errorf("%s: expected %q error, got %v", content, expectedErr, err)
+		}
+	}
+}
+
+func TestParseLayerRef(t *testing.T) {
+	content := `VAR VERSION=v1.2.0
+LAYER git@github.com:example/repo.git@${VERSION}
+LAYER https://github.com/example/repo.git TARGET config
+LAYER ./layers/local@2024
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	expected := []struct{ repository, ref string }{
+		{"git@github.com:example/repo.git@v1.2.0", "v1.2.0"},
+		{"https://github.com/example/repo.git", ""},
+		{"./layers/local@2024", ""},
+	}
+	for i, layer := range expected {
+		if config.Layers[i].Repository != layer.repository || config.Layers[i].Ref != layer.ref {
+			t.Errorf("Layer %d: expected %s pinned to %q, got %s pinned to %q", i, layer.repository, layer.ref, config.Layers[i].Repository, config.Layers[i].Ref)
+		}
+	}
+
+	_, err = ParseOtterfileReader(strings.NewReader("LAYER git@github.com:example/repo.git@"), "inline")
+	if err == nil || !contains(err.Error(), "needs a branch, tag, or commit after @") {
+		t.Errorf("Expected an error for an empty ref, got %v", err)
+	}
+}
+
+func TestParseLayerComment(t *testing.T) {
+	content := `LAYER builtin:makefile # shared targets for CI
+LAYER ./layers/overrides # keep local tweaks # otter:disable=WARN002
+LAYER ./layers/hooks AFTER ["echo '# not a comment'"]
+LAYER git@github.com:example/repo.git \
+  TARGET config \ # app settings
+  IF env=production # production only
+VAR COLOR=#fff
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	expected := []string{"shared targets for CI", "keep local tweaks", "", "app settings production only"}
+	for i, comment := range expected {
+		if config.Layers[i].Comment != comment {
+			t.Errorf("Layer %d: expected comment %q, got %q", i, comment, config.Layers[i].Comment)
+		}
+	}
+	if len(config.Layers[1].DisabledWarnings) != 1 {
+		t.Errorf("Expected the otter:disable directive to still apply, got %v", config.Layers[1].DisabledWarnings)
+	}
+	if hook := config.Layers[2].After[0]; hook != "echo '# not a comment'" {
+		t.Errorf("Expected # inside the hook to be kept, got %q", hook)
+	}
+	if config.Layers[3].Target != "config" || config.Layers[3].Condition != "env=production" {
+		t.Errorf("Expected the continued layer to parse around its comments, got %+v", config.Layers[3])
+	}
+	if config.Variables["COLOR"] != "#fff" {
+		t.Errorf("Expected a # not after whitespace to be kept, got %q", config.Variables["COLOR"])
+	}
+}
+
+func TestParseToolsCommand(t *testing.T) {
+	content := `VAR GO_VERSION=1.22
+TOOLS go>=${GO_VERSION} node>=20
+TOOLS git python3==3.11 \
+      terraform<2
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	expected := []ToolRequirement{
+		{Name: "go", Operator: ">=", Version: "1.22"},
+		{Name: "node", Operator: ">=", Version: "20"},
+		{Name: "git"},
+		{Name: "python3", Operator: "=", Version: "3.11"},
+		{Name: "terraform", Operator: "<", Version: "2"},
+	}
+	if len(config.Tools) != len(expected) {
+		t.Fatalf("Expected %d tools, got %d: %v", len(expected), len(config.Tools), config.Tools)
+	}
+	for i, tool := range expected {
+		if config.Tools[i] != tool {
+			t.Errorf("Tool %d: expected %+v, got %+v", i, tool, config.Tools[i])
+		}
+	}
+
+	for _, invalid := range []string{"TOOLS", "TOOLS go>=", "TOOLS >=1.0", "TOOLS go=>1"} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestParseLayerAllow(t *testing.T) {
+	config, err := ParseOtterfileReader(strings.NewReader("LAYER ./layers/git ALLOW .gitignore .otterignore TARGET app"), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	layer := config.Layers[0]
+	if strings.Join(layer.Allow, " ") != ".gitignore .otterignore" || layer.Target != "app" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+
+	for _, invalid := range []string{
+		"LAYER ./layers/git ALLOW",
+		"LAYER ./layers/git ALLOW TARGET app",
+		"LAYER ./layers/git ALLOW .git",
+		"LAYER ./layers/git ALLOW .otter/",
+		"LAYER ./layers/git ALLOW .git/hooks",
+		"LAYER ./layers/git ALLOW .env",
+	} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestParseLayerAllowHidden(t *testing.T) {
+	content := `LAYER ./layers/node ALLOW_HIDDEN .npmrc .github/ TARGET app
+LAYER ./layers/tools ALLOW_HIDDEN
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	if layer := config.Layers[0]; strings.Join(layer.AllowHidden, " ") != ".npmrc .github" || layer.Target != "app" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+	if layer := config.Layers[1]; strings.Join(layer.AllowHidden, " ") != "*" {
+		t.Errorf("Expected bare ALLOW_HIDDEN to allow every hidden file, got %v", layer.AllowHidden)
+	}
+}
+
+func TestParseLayerFrozen(t *testing.T) {
+	content := `LAYER git@github.com:example/forked.git FROZEN TARGET vendor # diverged on purpose
+LAYER git@github.com:example/tracked.git
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	if layer := config.Layers[0]; !layer.Frozen || layer.Target != "vendor" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+	if config.Layers[1].Frozen {
+		t.Error("Expected layers without FROZEN to track upstream")
+	}
+}
+
+func TestParseMinVersion(t *testing.T) {
+	defer func(version string) { OtterVersion = version }(OtterVersion)
+
+	// A newer command after MIN_VERSION asks for an upgrade instead of failing as unknown
+	content := "LAYER ./layer\nFUTURE_COMMAND x\nMIN_VERSION 0.5.0 # needs FUTURE_COMMAND\n"
+	OtterVersion = "v0.4.2-3-gabc1234"
+	_, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err == nil || !contains(err.Error(), "line 3: this Otterfile requires otter 0.5.0 or newer, but this is otter 0.4.2") {
+		t.Errorf("Expected an upgrade error, got %v", err)
+	}
+
+	OtterVersion = "dev"
+	if _, err := ParseOtterfileReader(strings.NewReader(content), "inline"); err == nil || !contains(err.Error(), "unknown command") {
+		t.Errorf("Expected development builds to skip the check, got %v", err)
+	}
+
+	OtterVersion = "v0.5.0"
+	config, err := ParseOtterfileReader(strings.NewReader("MIN_VERSION 0.5\nMIN_VERSION v0.4.9\nLAYER ./layer\n"), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	if config.MinVersion != "0.5" {
+		t.Errorf("Expected the highest minimum version, got %s", config.MinVersion)
+	}
+
+	for _, invalid := range []string{"MIN_VERSION", "MIN_VERSION latest", "MIN_VERSION 0.5.0 0.6.0"} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil || !contains(err.Error(), "MIN_VERSION requires a version") {
+			t.Errorf("Expected %q to fail, got %v", invalid, err)
+		}
+	}
+}
+
+func TestParseLayerOnly(t *testing.T) {
+	content := `VAR dir=src
+LAYER git@github.com:example/monorepo.git ONLY ${dir}/ Makefile TARGET app IF os=linux
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	layer := config.Layers[0]
+	if strings.Join(layer.Only, " ") != "src/ Makefile" || layer.Target != "app" || layer.Condition != "os=linux" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+
+	if _, err := ParseOtterfileReader(strings.NewReader("LAYER ./layer ONLY TARGET app\n"), "inline"); err == nil {
+		t.Error("Expected an error for ONLY without paths")
+	}
+}
+
+func TestParseLayerMap(t *testing.T) {
+	content := `VAR dir=.config
+LAYER git@github.com:example/dotfiles.git MAP configs/=${dir}/ README.tpl=README.md TARGET home
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	layer := config.Layers[0]
+	expected := []util.PathMapping{{From: "configs", To: ".config"}, {From: "README.tpl", To: "README.md"}}
+	if len(layer.Map) != len(expected) || layer.Map[0] != expected[0] || layer.Map[1] != expected[1] || layer.Target != "home" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+
+	for _, content := range []string{"LAYER ./layer MAP TARGET app\n", "LAYER ./layer MAP a=../b\n"} {
+		if _, err := ParseOtterfileReader(strings.NewReader(content), "inline"); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestParseLayerStrategy(t *testing.T) {
+	content := `LAYER git@github.com:example/docs.git STRATEGY skip-existing TARGET docs
+LAYER git@github.com:example/ignores.git STRATEGY Append
+LAYER git@github.com:example/base.git
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	if layer := config.Layers[0]; layer.Strategy != util.StrategySkipExisting || layer.Target != "docs" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+	if config.Layers[1].Strategy != util.StrategyAppend || config.Layers[2].Strategy != "" {
+		t.Errorf("Unexpected strategies: %s, %s", config.Layers[1].Strategy, config.Layers[2].Strategy)
+	}
+
+	for content, expected := range map[string]string{
+		"LAYER ./layer STRATEGY\n":                   "STRATEGY requires one of",
+		"LAYER ./layer STRATEGY replace\n":           "unknown STRATEGY replace",
+		"LAYER ./layer TYPE patch STRATEGY append\n": "only be used with file and generator layers",
+	} {
+		if _, err := ParseOtterfileReader(strings.NewReader(content), "inline"); err == nil || !contains(err.Error(), expected) {
+			t.Errorf("Expected %q to fail with %q, got %v", content, expected, err)
+		}
+	}
+}
+
+func TestLayerDepends(t *testing.T) {
+	content := `LAYER ./layers/api NAME api DEPENDS db cache
+LAYER ./layers/lint
+LAYER ./layers/db NAME db DEPENDS base
+LAYER ./layers/cache NAME cache
+LAYER ./layers/base NAME base
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	if depends := config.Layers[0].Depends; strings.Join(depends, " ") != "db cache" {
+		t.Errorf("Unexpected dependencies: %v", depends)
+	}
+
+	// Layers wait for what they depend on and otherwise keep their declared order
+	layers, err := config.FilterApplicableLayers()
+	if err != nil {
+		t.Fatalf("Failed to order layers: %v", err)
+	}
+	var order []string
+	for _, layer := range layers {
+		order = append(order, layer.Repository)
+	}
+	if expected := "./layers/lint ./layers/cache ./layers/base ./layers/db ./layers/api"; strings.Join(order, " ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(order, " "))
+	}
+
+	for content, expected := range map[string]string{
+		"LAYER ./a NAME a DEPENDS c\nLAYER ./b NAME b DEPENDS a\nLAYER ./c NAME c DEPENDS b\n": "cycle: a -> c -> b -> a",
+		"LAYER ./a NAME a DEPENDS a\n":                           "cycle: a -> a",
+		"LAYER ./a DEPENDS db\n":                                 "./a DEPENDS on db, which isn't the NAME of any layer",
+		"LAYER ./a DEPENDS db\nLAYER ./db NAME db IF os=plan9\n": "which doesn't apply",
+	} {
+		config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+		if err != nil {
+			t.Fatalf("Failed to parse %q: %v", content, err)
+		}
+		if _, err := config.FilterApplicableLayers(); err == nil || !contains(err.Error(), expected) {
+			t.Errorf("Expected %q to fail with %q, got %v", content, expected, err)
+		}
+	}
+
+	if _, err := ParseOtterfileReader(strings.NewReader("LAYER ./a DEPENDS TARGET app\n"), "inline"); err == nil {
+		t.Error("Expected an error for DEPENDS without names")
+	}
+}
+
+func TestParseLayerBranch(t *testing.T) {
+	content := `LAYER git@github.com:example/legacy.git BRANCH trunk TARGET legacy
+LAYER git@github.com:example/legacy.git BRANCH trunk TARGET vendor
+LAYER git@github.com:example/base.git
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+	if layer := config.Layers[0]; layer.Branch != "trunk" || layer.Target != "legacy" {
+		t.Errorf("Unexpected layer: %+v", layer)
+	}
+	branches := config.LayerBranches()
+	if len(branches) != 1 || branches["git@github.com:example/legacy.git"] != "trunk" {
+		t.Errorf("Unexpected branches: %v", branches)
+	}
+
+	for content, expected := range map[string]string{
+		"LAYER git@github.com:example/legacy.git BRANCH\n":                                                "BRANCH requires a branch name",
+		"LAYER ./layer BRANCH trunk\n":                                                                    "only applies to remote layers",
+		"LAYER git@github.com:example/legacy.git@v1.0.0 BRANCH trunk\n":                                   "pinned to v1.0.0",
+		"LAYER git@github.com:example/legacy.git BRANCH trunk\nLAYER git@github.com:example/legacy.git\n": "follows its default branch, but an earlier LAYER of it follows BRANCH trunk",
+	} {
+		if _, err := ParseOtterfileReader(strings.NewReader(content), "inline"); err == nil || !contains(err.Error(), expected) {
+			t.Errorf("Expected %q to fail with %q, got %v", content, expected, err)
+		}
+	}
+}
+
+func TestParseGroup(t *testing.T) {
+	content := `VAR region=eu
+GROUP TARGET services IF env=production OR env=staging TEMPLATE region=${region} tier=web
+  LAYER ./layers/api TARGET api TEMPLATE tier=backend
+  LAYER ./layers/web IF os=linux
+  GROUP TARGET jobs IF NOT arch=arm64
+    LAYER ./layers/worker
+  END
+END
+LAYER ./layers/base
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	tests := []struct {
+		target    string
+		condition string
+		template  map[string]string
+	}{
+		{"services/api", "env=production OR env=staging", map[string]string{"region": "eu", "tier": "backend"}},
+		{"services", "env=production AND os=linux OR env=staging AND os=linux", map[string]string{"region": "eu", "tier": "web"}},
+		{"services/jobs", "env=production AND NOT arch=arm64 OR env=staging AND NOT arch=arm64", map[string]string{"region": "eu", "tier": "web"}},
+		{".", "", map[string]string{}},
+	}
+	if len(config.Layers) != len(tests) {
+		t.Fatalf("Expected %d layers, got %d", len(tests), len(config.Layers))
+	}
+	for i, tt := range tests {
+		layer := config.Layers[i]
+		if layer.Target != tt.target || layer.Condition != tt.condition || len(layer.Template) != len(tt.template) {
+			t.Errorf("Layer %d: unexpected %+v", i, layer)
+			continue
+		}
+		for key, value := range tt.template {
+			if layer.Template[key] != value {
+				t.Errorf("Layer %d: expected template %s=%s, got %q", i, key, value, layer.Template[key])
+			}
+		}
+	}
+
+	for _, content := range []string{
+		"GROUP TARGET app\nLAYER ./layer\n",
+		"LAYER ./layer\nEND\n",
+		"GROUP NAME app\nEND\n",
+		"GROUP TARGET app\nLAYER ./layer TARGET /etc\nEND\n",
+	} {
+		if _, err := ParseOtterfileReader(strings.NewReader(content), "inline"); err == nil {
+			t.Errorf("Expected an error for %q", content)
+		}
+	}
+}
+
+func TestParseIgnoreCommand(t *testing.T) {
+	content := `IGNORE PRESET node,python
+ignore preset os rust
+IGNORE CASE Auto
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	expected := []string{"node", "python", "os", "rust"}
+	if strings.Join(config.IgnorePresets, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected presets %v, got %v", expected, config.IgnorePresets)
+	}
+	if config.IgnoreCase != "auto" {
+		t.Errorf("Expected case mode auto, got %q", config.IgnoreCase)
+	}
+
+	for _, invalid := range []string{"IGNORE", "IGNORE PRESET", "IGNORE node_modules/", "IGNORE PRESET cobol", "IGNORE CASE upper"} {
+		if _, err := ParseOtterfileReader(strings.NewReader(invalid), "inline"); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
 func contains(s, substr string) bool {
 	return len(s) >= len(substr) && (s == substr || len(s) > 0 && containsHelper(s, substr))
 }
@@ -265,3 +783,100 @@ func TestGitOperationsRepoName(t *testing.T) {
 		}
 	}
 }
+
+func TestParseOtterfileStack(t *testing.T) {
+	tempDir := t.TempDir()
+	base := filepath.Join(tempDir, "base.Otterfile")
+	team := filepath.Join(tempDir, "team.Otterfile")
+
+	baseContent := `VAR REGISTRY=registry.example.com
+VAR GO_VERSION=1.21
+LAYER ./layers/go NAME go TEMPLATE version=${GO_VERSION}
+LAYER ./layers/ci NAME ci TARGET .github
+LAYER ./layers/editor
+ON_BEFORE_BUILD: ["echo base"]
+`
+	teamContent := `VAR GO_VERSION=1.22
+VAR IMAGE=${REGISTRY}/team
+LAYER ./layers/team-ci NAME ci TARGET .github
+LAYER ./layers/docker TEMPLATE image=${IMAGE}
+ON_BEFORE_BUILD: ["echo team"]
+`
+	if err := os.WriteFile(base, []byte(baseContent), 0644); err != nil {
+		t.Fatalf("Failed to write base: %v", err)
+	}
+	if err := os.WriteFile(team, []byte(teamContent), 0644); err != nil {
+		t.Fatalf("Failed to write team: %v", err)
+	}
+
+	config, err := ParseOtterfileStack([]string{base, team})
+	if err != nil {
+		t.Fatalf("Failed to parse stack: %v", err)
+	}
+
+	// Later definitions win, including in layers of earlier files
+	if config.Variables["GO_VERSION"] != "1.22" || config.Variables["IMAGE"] != "registry.example.com/team" {
+		t.Errorf("Unexpected variables: %v", config.Variables)
+	}
+
+	repositories := make([]string, 0, len(config.Layers))
+	for _, layer := range config.Layers {
+		repositories = append(repositories, layer.Repository)
+	}
+	expected := "./layers/go ./layers/team-ci ./layers/editor ./layers/docker"
+	if strings.Join(repositories, " ") != expected {
+		t.Errorf("Expected layers %s, got %v", expected, repositories)
+	}
+	if config.Layers[0].Template["version"] != "1.22" {
+		t.Errorf("Expected base layer to use overridden variable, got %s", config.Layers[0].Template["version"])
+	}
+	if config.Layers[3].Template["image"] != "registry.example.com/team" {
+		t.Errorf("Expected stacked layer to see base variables, got %s", config.Layers[3].Template["image"])
+	}
+	if len(config.OnBeforeBuild) != 2 {
+		t.Errorf("Expected hooks from both files, got %v", config.OnBeforeBuild)
+	}
+
+	if _, err := ParseOtterfileReader(strings.NewReader("LAYER ./a NAME x\nLAYER ./b NAME x"), "inline"); err == nil {
+		t.Error("Expected error for duplicate layer NAME in one file")
+	}
+}
+
+func TestParseWarnings(t *testing.T) {
+	content := `VAR HOST=${MISSING_HOST}
+LAYER ./layers/${MISSING_LAYER} # otter:disable=WARN001
+# otter:disable=WARN002,WARN003
+LAYER ./layers/app TEMPLATE url=${MISSING_URL}
+ON_AFTER_BUILD: ["sleep 1"] # otter:disable=WARN003
+`
+
+	config, err := ParseOtterfileReader(strings.NewReader(content), "Otterfile")
+	if err != nil {
+		t.Fatalf("Failed to parse content: %v", err)
+	}
+
+	// The suppressed placeholder on line 2 isn't reported
+	if len(config.Warnings) != 2 {
+		t.Fatalf("Expected 2 warnings, got %v", config.Warnings)
+	}
+	if config.Warnings[0].Code != util.WarnUnknownVariable || !contains(config.Warnings[0].Message, "line 1: unknown variable ${MISSING_HOST}") {
+		t.Errorf("Unexpected first warning: %v", config.Warnings[0])
+	}
+	if !contains(config.Warnings[1].Message, "line 4") {
+		t.Errorf("Expected second warning from line 4, got %v", config.Warnings[1])
+	}
+
+	if got := config.Layers[1].DisabledWarnings; len(got) != 2 || got[0] != util.WarnLayerOverride {
+		t.Errorf("Expected the comment above a layer to disable its warnings, got %v", got)
+	}
+	if len(config.Layers[0].DisabledWarnings) != 1 {
+		t.Errorf("Expected a trailing comment to disable warnings for its layer, got %v", config.Layers[0].DisabledWarnings)
+	}
+	if len(config.HookDisabledWarnings) != 1 || config.HookDisabledWarnings[0] != util.WarnSlowHook {
+		t.Errorf("Expected hook warnings to be disabled, got %v", config.HookDisabledWarnings)
+	}
+
+	if _, err := ParseOtterfileReader(strings.NewReader("# otter:disable=oops\nLAYER ./x\n"), "Otterfile"); err == nil {
+		t.Errorf("Expected an invalid warning code to fail parsing")
+	}
+}
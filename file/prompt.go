@@ -0,0 +1,100 @@
+package file
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Prompter asks for the value of a PROMPT variable, offering defaultValue when hasDefault is set,
+// and returns the answer
+type Prompter func(name, question, defaultValue string, hasDefault bool) (string, error)
+
+// parsePromptCommand parses a PROMPT command, e.g. PROMPT PROJECT_NAME "Project name?" default=my-app,
+// which asks for the value of a variable no earlier VAR, stacked Otterfile, or environment variable
+// provides. Without a prompter the default is used, and a variable without one is an error.
+func parsePromptCommand(rest string, config *OtterfileConfig) error {
+	args, err := splitQuotedArgs(rest)
+	if err != nil {
+		return err
+	}
+	if len(args) < 2 {
+		return fmt.Errorf("PROMPT requires a variable name and a question, e.g. PROMPT NAME \"Project name?\"")
+	}
+	key, question := args[0], args[1]
+	if strings.Contains(key, "=") {
+		return fmt.Errorf("PROMPT variable name cannot contain '=', got: %s", key)
+	}
+
+	var defaultValue string
+	var hasDefault bool
+	for _, arg := range args[2:] {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || strings.ToLower(name) != "default" {
+			return fmt.Errorf("unknown PROMPT argument: %s (expected default=VALUE)", arg)
+		}
+		defaultValue = config.substituteVariables(value, config.Variables)
+		if err := config.checkSubstituted(defaultValue); err != nil {
+			return err
+		}
+		hasDefault = true
+	}
+
+	// A later file of the stack may provide the variable, so it is asked for on the next pass
+	if config.variableMode == variablesResolving {
+		return nil
+	}
+	if value, ok := config.lookupVariable(key, config.Variables); ok {
+		config.Variables[key] = value
+		return nil
+	}
+
+	switch {
+	case config.prompter != nil:
+		value, err := config.prompter(key, question, defaultValue, hasDefault)
+		if err != nil {
+			return err
+		}
+		config.Variables[key] = value
+	case hasDefault:
+		config.Variables[key] = defaultValue
+	default:
+		return fmt.Errorf("variable %s has no value and otter can't ask %q without a terminal; set it with VAR %s=value, in a stacked Otterfile, or with the OTTER_%s environment variable",
+			key, question, key, strings.ToUpper(key))
+	}
+	return nil
+}
+
+// splitQuotedArgs splits arguments at spaces, except within double quotes, which are removed. A
+// backslash inside quotes escapes the next character.
+func splitQuotedArgs(text string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inArg, quoted := false, false
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case quoted && c == '\\' && i+1 < len(text):
+			i++
+			current.WriteByte(text[i])
+		case c == '"':
+			quoted = !quoted
+			inArg = true
+		case !quoted && (c == ' ' || c == '\t'):
+			if inArg {
+				args = append(args, current.String())
+				current.Reset()
+				inArg = false
+			}
+		default:
+			current.WriteByte(c)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote in: %s", text)
+	}
+	if inArg {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
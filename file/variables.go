@@ -0,0 +1,129 @@
+package file
+
+import (
+	"strings"
+)
+
+// VariableDefinition is a VAR line as written in an Otterfile, before substitution
+type VariableDefinition struct {
+	Name     string
+	Value    string
+	Default  bool     // Defined with ?=, so an earlier VAR or OTTER_ environment variable overrides it
+	Required bool     // Declared with REQUIRED, so the Otterfile doesn't parse until the variable has a value
+	Allowed  []string // Values a required variable may have; any value when empty
+	Prompt   string   // Question of a PROMPT declaration, whose Value is its default
+}
+
+// ListVariables returns the VAR and PROMPT definitions of Otterfile content in the order they
+// appear. A REQUIRED or PROMPT declaration adds to an earlier definition of the same variable.
+func ListVariables(content string) []VariableDefinition {
+	var definitions []VariableDefinition
+	for _, line := range strings.Split(content, "\n") {
+		definition, ok := parseVarLine(line)
+		if !ok {
+			continue
+		}
+		if i := indexOfVariable(definitions, definition.Name); i >= 0 {
+			switch {
+			case definition.Required:
+				definitions[i].Required, definitions[i].Allowed = true, definition.Allowed
+				continue
+			case definition.Prompt != "":
+				definitions[i].Prompt = definition.Prompt
+				continue
+			}
+		}
+		definitions = append(definitions, definition)
+	}
+	return definitions
+}
+
+// SetVariables rewrites the VAR lines of Otterfile content with new values, keeping ?= on defaults and
+// leaving other lines untouched. A required or prompted variable without an earlier VAR line is
+// assigned just before its REQUIRED or PROMPT declaration, which then finds the value.
+func SetVariables(content string, values map[string]string) string {
+	var lines []string
+	assigned := make(map[string]bool)
+	for _, line := range strings.Split(content, "\n") {
+		definition, ok := parseVarLine(line)
+		if !ok {
+			lines = append(lines, line)
+			continue
+		}
+		declaration := definition.Required || definition.Prompt != ""
+		value, set := values[definition.Name]
+		if !set || (declaration && assigned[definition.Name]) {
+			lines = append(lines, line)
+			continue
+		}
+
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		operator := "="
+		if definition.Default {
+			operator = " ?= "
+		}
+		assignment := indent + "VAR " + definition.Name + operator + value
+		if declaration {
+			lines = append(lines, assignment, line)
+		} else {
+			lines = append(lines, assignment)
+		}
+		assigned[definition.Name] = true
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseVarLine parses a single-line VAR or PROMPT command into its definition with the raw value
+func parseVarLine(line string) (VariableDefinition, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || strings.HasSuffix(strings.TrimSpace(line), "\\") {
+		return VariableDefinition{}, false
+	}
+	switch strings.ToUpper(fields[0]) {
+	case "VAR":
+	case "PROMPT":
+		return parsePromptLine(strings.TrimSpace(strings.TrimSpace(line)[len(fields[0]):]))
+	default:
+		return VariableDefinition{}, false
+	}
+
+	if len(fields) >= 3 && strings.ToUpper(fields[2]) == "REQUIRED" && !strings.Contains(fields[1], "=") {
+		return VariableDefinition{Name: fields[1], Required: true, Allowed: allowedValues(fields[3:])}, true
+	}
+
+	definition := strings.TrimSpace(strings.TrimSpace(line)[len(fields[0]):])
+	name, value, ok := strings.Cut(definition, "=")
+	name, isDefault := strings.CutSuffix(strings.TrimSpace(name), "?")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" {
+		return VariableDefinition{}, false
+	}
+
+	return VariableDefinition{Name: name, Value: strings.TrimSpace(value), Default: isDefault}, true
+}
+
+// indexOfVariable returns the index of the definition of a variable, or -1 when there is none
+func indexOfVariable(definitions []VariableDefinition, name string) int {
+	for i, definition := range definitions {
+		if definition.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// parsePromptLine parses the arguments of a PROMPT command into a definition with its question and
+// raw default
+func parsePromptLine(rest string) (VariableDefinition, bool) {
+	args, err := splitQuotedArgs(rest)
+	if err != nil || len(args) < 2 {
+		return VariableDefinition{}, false
+	}
+	definition := VariableDefinition{Name: args[0], Prompt: args[1]}
+	for _, arg := range args[2:] {
+		if name, value, ok := strings.Cut(arg, "="); ok && strings.ToLower(name) == "default" {
+			definition.Value = value
+		}
+	}
+	return definition, true
+}
@@ -1,9 +1,14 @@
 package file
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
 	"testing"
+
+	"github.com/geoffjay/otter/util"
 )
 
 func TestParseVarCommand(t *testing.T) {
@@ -96,6 +101,289 @@ func TestParseVarCommand(t *testing.T) {
 	}
 }
 
+func TestParseVarCommand_Default(t *testing.T) {
+	t.Setenv("OTTER_REGION", "eu-west-1")
+
+	content := `VAR TEAM=platform
+VAR TEAM ?= frontend
+VAR REGION ?= us-east-1
+VAR IMAGE?=registry/${TEAM}
+VAR IMAGE ?= ignored
+VAR ZONE ?= ${REGION}a
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	expected := map[string]string{
+		"TEAM":   "platform",          // An earlier VAR wins over the default
+		"REGION": "eu-west-1",         // So does an OTTER_ environment variable
+		"IMAGE":  "registry/platform", // A default applies when nothing else sets the variable
+		"ZONE":   "eu-west-1a",
+	}
+	for key, value := range expected {
+		if config.Variables[key] != value {
+			t.Errorf("Expected %s=%s, got %q", key, value, config.Variables[key])
+		}
+	}
+
+	// A later VAR still replaces a default
+	config, err = ParseOtterfileReader(strings.NewReader("VAR TEAM ?= frontend\nVAR TEAM=backend\n"), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	if config.Variables["TEAM"] != "backend" {
+		t.Errorf("Expected TEAM=backend, got %q", config.Variables["TEAM"])
+	}
+}
+
+func TestParseOtterfileStack_Default(t *testing.T) {
+	tempDir := t.TempDir()
+	base := filepath.Join(tempDir, "Otterfile")
+	local := filepath.Join(tempDir, "Otterfile.local")
+	if err := os.WriteFile(base, []byte("VAR TEAM ?= frontend\nVAR REGION ?= us-east-1\nLAYER ./layers/${TEAM}/${REGION}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte("VAR TEAM=backend\nVAR REGION ?= eu-west-1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := ParseOtterfileStack([]string{base, local})
+	if err != nil {
+		t.Fatalf("Failed to parse stack: %v", err)
+	}
+	if config.Layers[0].Repository != "./layers/backend/us-east-1" {
+		t.Errorf("Expected the later VAR to replace the default and the earlier default to stay, got %s", config.Layers[0].Repository)
+	}
+}
+
+func TestParseVarCommand_Required(t *testing.T) {
+	t.Setenv("OTTER_REGION", "eu-west-1")
+
+	tests := []struct {
+		name          string
+		content       string
+		expected      map[string]string
+		errorContains string
+	}{
+		{
+			name:     "Defined by an earlier VAR",
+			content:  "VAR DATABASE=postgres\nVAR DATABASE REQUIRED\nLAYER ./layers/${DATABASE}\n",
+			expected: map[string]string{"DATABASE": "postgres"},
+		},
+		{
+			name:     "Defined by the environment",
+			content:  "VAR REGION REQUIRED us-east-1 eu-west-1\n",
+			expected: map[string]string{"REGION": "eu-west-1"},
+		},
+		{
+			name:     "Allowed values separated by commas",
+			content:  "VAR DATABASE ?= mysql\nVAR DATABASE required postgres,mysql\n",
+			expected: map[string]string{"DATABASE": "mysql"},
+		},
+		{
+			name:          "Missing",
+			content:       "VAR DATABASE REQUIRED\nLAYER ./layers/${DATABASE}\n",
+			errorContains: "error on line 1: variable DATABASE is required",
+		},
+		{
+			name:          "Empty",
+			content:       "VAR DATABASE=\nVAR DATABASE REQUIRED\n",
+			errorContains: "variable DATABASE is required",
+		},
+		{
+			name:          "Defined after the declaration",
+			content:       "VAR DATABASE REQUIRED\nVAR DATABASE=postgres\n",
+			errorContains: "variable DATABASE is required",
+		},
+		{
+			name:          "Not an allowed value",
+			content:       "VAR DATABASE=oracle\nVAR DATABASE REQUIRED postgres mysql\n",
+			errorContains: "variable DATABASE must be one of postgres, mysql, got: oracle",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := ParseOtterfileReader(strings.NewReader(tt.content), "inline")
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Fatalf("Expected error containing %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for key, value := range tt.expected {
+				if config.Variables[key] != value {
+					t.Errorf("Expected %s=%s, got %q", key, value, config.Variables[key])
+				}
+			}
+		})
+	}
+}
+
+func TestParseOtterfileStack_Required(t *testing.T) {
+	tempDir := t.TempDir()
+	base := filepath.Join(tempDir, "Otterfile")
+	local := filepath.Join(tempDir, "Otterfile.local")
+	if err := os.WriteFile(base, []byte("VAR DATABASE REQUIRED postgres mysql\nLAYER ./layers/${DATABASE}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ParseOtterfileStack([]string{base}); err == nil || !strings.Contains(err.Error(), "variable DATABASE is required") {
+		t.Errorf("Expected the requirement to fail on its own, got %v", err)
+	}
+
+	// A later file of the stack provides the variable
+	if err := os.WriteFile(local, []byte("VAR DATABASE=mysql\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseOtterfileStack([]string{base, local})
+	if err != nil {
+		t.Fatalf("Failed to parse stack: %v", err)
+	}
+	if config.Layers[0].Repository != "./layers/mysql" {
+		t.Errorf("Expected ./layers/mysql, got %s", config.Layers[0].Repository)
+	}
+
+	if err := os.WriteFile(local, []byte("VAR DATABASE=oracle\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ParseOtterfileStack([]string{base, local}); err == nil || !strings.Contains(err.Error(), "must be one of") {
+		t.Errorf("Expected a value that isn't allowed to fail, got %v", err)
+	}
+}
+
+func TestParsePromptCommand(t *testing.T) {
+	t.Setenv("OTTER_OWNER", "platform")
+
+	tests := []struct {
+		name          string
+		content       string
+		prompter      Prompter
+		expected      map[string]string
+		errorContains string
+	}{
+		{
+			name:     "Answered",
+			content:  "PROMPT PROJECT_NAME \"Project name?\" default=my-app\nLAYER ./layers/base TARGET ${PROJECT_NAME}\n",
+			prompter: func(name, question, defaultValue string, hasDefault bool) (string, error) { return "billing", nil },
+			expected: map[string]string{"PROJECT_NAME": "billing"},
+		},
+		{
+			name:     "Default without a prompter",
+			content:  "VAR APP=api\nPROMPT PROJECT_NAME \"Project name?\" default=\"my ${APP}\"\n",
+			expected: map[string]string{"PROJECT_NAME": "my api"},
+		},
+		{
+			name:    "Defined by an earlier VAR",
+			content: "VAR PROJECT_NAME=billing\nPROMPT PROJECT_NAME \"Project name?\"\n",
+			prompter: func(name, question, defaultValue string, hasDefault bool) (string, error) {
+				return "", fmt.Errorf("asked for %s", name)
+			},
+			expected: map[string]string{"PROJECT_NAME": "billing"},
+		},
+		{
+			name:     "Defined by the environment",
+			content:  "PROMPT OWNER \"Team?\" default=none\n",
+			expected: map[string]string{"OWNER": "platform"},
+		},
+		{
+			name:          "No default without a prompter",
+			content:       "PROMPT PROJECT_NAME \"Project name?\"\n",
+			errorContains: `error on line 1: variable PROJECT_NAME has no value and otter can't ask "Project name?" without a terminal`,
+		},
+		{
+			name:          "Missing question",
+			content:       "PROMPT PROJECT_NAME\n",
+			errorContains: "PROMPT requires a variable name and a question",
+		},
+		{
+			name:          "Unterminated quote",
+			content:       "PROMPT PROJECT_NAME \"Project name?\n",
+			errorContains: "unterminated quote",
+		},
+		{
+			name:          "Unknown argument",
+			content:       "PROMPT PROJECT_NAME \"Project name?\" fallback=x\n",
+			errorContains: "unknown PROMPT argument: fallback=x",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config, err := parseOtterfile(strings.NewReader(tt.content), "inline", nil, variablesDefined, tt.prompter, nil)
+			if tt.errorContains != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+					t.Fatalf("Expected error containing %q, got %v", tt.errorContains, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for key, value := range tt.expected {
+				if config.Variables[key] != value {
+					t.Errorf("Expected %s=%s, got %q", key, value, config.Variables[key])
+				}
+			}
+		})
+	}
+}
+
+func TestParseOtterfileStack_Prompt(t *testing.T) {
+	tempDir := t.TempDir()
+	base := filepath.Join(tempDir, "Otterfile")
+	local := filepath.Join(tempDir, "Otterfile.local")
+	if err := os.WriteFile(base, []byte("PROMPT PROJECT_NAME \"Project name?\" default=my-app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, []byte("LAYER ./layers/base TARGET ${PROJECT_NAME}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The question is asked once, and the answer reaches the files after it
+	var asked []string
+	prompter := func(name, question, defaultValue string, hasDefault bool) (string, error) {
+		asked = append(asked, name+"="+defaultValue)
+		return "billing", nil
+	}
+	config, err := ParseOtterfileStackInteractive([]string{base, local}, prompter)
+	if err != nil {
+		t.Fatalf("Failed to parse stack: %v", err)
+	}
+	if !reflect.DeepEqual(asked, []string{"PROJECT_NAME=my-app"}) || config.Layers[0].Target != "billing" {
+		t.Errorf("Expected one question and TARGET billing, got %v and %s", asked, config.Layers[0].Target)
+	}
+
+	// A later file of the stack answers it instead
+	if err := os.WriteFile(local, []byte("VAR PROJECT_NAME=payments\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	asked = nil
+	config, err = ParseOtterfileStackInteractive([]string{base, local}, prompter)
+	if err != nil {
+		t.Fatalf("Failed to parse stack: %v", err)
+	}
+	if len(asked) != 0 || config.Variables["PROJECT_NAME"] != "payments" {
+		t.Errorf("Expected no question and PROJECT_NAME=payments, got %v and %s", asked, config.Variables["PROJECT_NAME"])
+	}
+}
+
+func TestSplitQuotedArgs(t *testing.T) {
+	args, err := splitQuotedArgs(`NAME  "Say \"hi\"?"	default="a b" plain`)
+	if err != nil {
+		t.Fatalf("splitQuotedArgs failed: %v", err)
+	}
+	expected := []string{"NAME", `Say "hi"?`, "default=a b", "plain"}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("Expected %q, got %q", expected, args)
+	}
+}
+
 func TestSubstituteVariables(t *testing.T) {
 	variables := map[string]string{
 		"PROJECT_NAME": "my-api",
@@ -168,7 +456,7 @@ func TestSubstituteVariables(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := substituteVariables(tt.input, variables)
+			result := (&OtterfileConfig{}).substituteVariables(tt.input, variables)
 			if result != tt.expected {
 				t.Errorf("Expected %s, got %s", tt.expected, result)
 			}
@@ -218,7 +506,7 @@ func TestSubstituteVariables_EnvironmentFallback(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result := substituteVariables(tt.input, variables)
+			result := (&OtterfileConfig{}).substituteVariables(tt.input, variables)
 			if result != tt.expected {
 				t.Errorf("Expected %s, got %s", tt.expected, result)
 			}
@@ -226,6 +514,61 @@ func TestSubstituteVariables_EnvironmentFallback(t *testing.T) {
 	}
 }
 
+func TestSubstituteVariables_UserFacts(t *testing.T) {
+	config := &OtterfileConfig{user: func() *util.UserInfo {
+		return &util.UserInfo{Name: "Jane Doe", Email: "jane@example.com", Username: "jane", Hostname: "devbox"}
+	}}
+
+	variables := map[string]string{"hostname": "override"}
+
+	result := config.substituteVariables("${user.name} <${user.email}> ${user.username}@${hostname}", variables)
+	if expected := "Jane Doe <jane@example.com> jane@override"; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+
+	// OTTER_ environment variables override the detected facts
+	t.Setenv("OTTER_HOSTNAME", "ci-runner")
+	result = config.substituteVariables("${user.username}@${hostname}", map[string]string{})
+	if expected := "jane@ci-runner"; result != expected {
+		t.Errorf("Expected %s, got %s", expected, result)
+	}
+}
+
+func TestParseOtterfile_UserFactsPerProject(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("XDG_CONFIG_HOME", "")
+
+	// Each project's git config names a different developer
+	for _, name := range []string{"Jane Doe", "John Roe"} {
+		projectDir := t.TempDir()
+		gitconfig := "[core]\n\trepositoryformatversion = 0\n[user]\n\tname = " + name + "\n"
+		if err := os.MkdirAll(filepath.Join(projectDir, ".git", "objects"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Join(projectDir, ".git", "refs"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(projectDir, ".git", "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(projectDir, ".git", "config"), []byte(gitconfig), 0644); err != nil {
+			t.Fatal(err)
+		}
+		otterfile := filepath.Join(projectDir, "Otterfile")
+		if err := os.WriteFile(otterfile, []byte("VAR AUTHOR=${user.name}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		config, err := ParseOtterfile(otterfile)
+		if err != nil {
+			t.Fatalf("ParseOtterfile failed: %v", err)
+		}
+		if config.Variables["AUTHOR"] != name {
+			t.Errorf("Expected the author of %s to be %s, got %q", projectDir, name, config.Variables["AUTHOR"])
+		}
+	}
+}
+
 func TestParseLayerCommand_WithTemplate(t *testing.T) {
 	tests := []struct {
 		name             string
@@ -586,3 +929,89 @@ LAYER git@github.com:templates/config.git TARGET ${BASE_PATH}/config TEMPLATE se
 		}
 	}
 }
+
+func TestListAndSetVariables(t *testing.T) {
+	content := `# Service template
+VAR SERVICE_NAME=my-service
+  var DESCRIPTION = A new service
+VAR IMAGE=registry/${SERVICE_NAME}
+VAR REGION ?= us-east-1
+VAR REGION REQUIRED us-east-1 eu-west-1
+VAR DATABASE REQUIRED postgres,mysql
+PROMPT OWNER "Who owns the service?" default="Platform team"
+LAYER ./layers/base TEMPLATE name=${SERVICE_NAME}
+`
+
+	definitions := ListVariables(content)
+	expected := []VariableDefinition{
+		{Name: "SERVICE_NAME", Value: "my-service"},
+		{Name: "DESCRIPTION", Value: "A new service"},
+		{Name: "IMAGE", Value: "registry/${SERVICE_NAME}"},
+		{Name: "REGION", Value: "us-east-1", Default: true, Required: true, Allowed: []string{"us-east-1", "eu-west-1"}},
+		{Name: "DATABASE", Required: true, Allowed: []string{"postgres", "mysql"}},
+		{Name: "OWNER", Value: "Platform team", Prompt: "Who owns the service?"},
+	}
+	if len(definitions) != len(expected) {
+		t.Fatalf("Expected %d variables, got %d: %v", len(expected), len(definitions), definitions)
+	}
+	for i, definition := range expected {
+		if !reflect.DeepEqual(definitions[i], definition) {
+			t.Errorf("Variable %d: expected %+v, got %+v", i, definition, definitions[i])
+		}
+	}
+
+	updated := SetVariables(content, map[string]string{"SERVICE_NAME": "billing", "DESCRIPTION": "Billing API", "REGION": "eu-west-1", "DATABASE": "mysql", "OWNER": "Payments"})
+	expectedContent := `# Service template
+VAR SERVICE_NAME=billing
+  VAR DESCRIPTION=Billing API
+VAR IMAGE=registry/${SERVICE_NAME}
+VAR REGION ?= eu-west-1
+VAR REGION REQUIRED us-east-1 eu-west-1
+VAR DATABASE=mysql
+VAR DATABASE REQUIRED postgres,mysql
+VAR OWNER=Payments
+PROMPT OWNER "Who owns the service?" default="Platform team"
+LAYER ./layers/base TEMPLATE name=${SERVICE_NAME}
+`
+	if updated != expectedContent {
+		t.Errorf("Unexpected updated content:\n%s", updated)
+	}
+
+	config, err := ParseOtterfileReader(strings.NewReader(updated), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse updated content: %v", err)
+	}
+	if config.Variables["IMAGE"] != "registry/billing" {
+		t.Errorf("Expected substituted IMAGE, got %s", config.Variables["IMAGE"])
+	}
+}
+
+func TestParseLayerCommand_WithVariables(t *testing.T) {
+	content := `VAR SERVICE=billing
+VAR PORT=80
+LAYER ./layers/service NAME api WITH SERVICE=api PORT=${PORT}81 TARGET services/${SERVICE} TEMPLATE port=${PORT}
+LAYER ./layers/service TARGET services/${SERVICE} TEMPLATE port=${PORT}
+`
+	config, err := ParseOtterfileReader(strings.NewReader(content), "inline")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+
+	scoped, global := config.Layers[0], config.Layers[1]
+	if scoped.Target != "services/api" || scoped.Template["port"] != "8081" {
+		t.Errorf("Expected WITH variables to apply to their layer, got target %s and port %s", scoped.Target, scoped.Template["port"])
+	}
+	if global.Target != "services/billing" || global.Template["port"] != "80" {
+		t.Errorf("Expected WITH variables not to leak into later layers, got target %s and port %s", global.Target, global.Template["port"])
+	}
+	if config.Variables["SERVICE"] != "billing" {
+		t.Errorf("Expected global SERVICE to stay billing, got %s", config.Variables["SERVICE"])
+	}
+	if variables := config.LayerVariables(scoped); variables["SERVICE"] != "api" || variables["PORT"] != "8081" {
+		t.Errorf("Unexpected layer variables: %v", variables)
+	}
+
+	if _, err := ParseOtterfileReader(strings.NewReader("LAYER ./layer WITH TARGET x"), "inline"); err == nil {
+		t.Error("Expected WITH without assignments to fail")
+	}
+}
@@ -0,0 +1,67 @@
+package file
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/geoffjay/otter/util"
+)
+
+// OtterVersion is the version of the running otter, which MIN_VERSION is checked against. It is
+// set from the release version at startup; development builds aren't checked.
+var OtterVersion = "dev"
+
+// parseMinVersionCommand parses a MIN_VERSION command, e.g. MIN_VERSION 0.5.0, keeping the highest
+// version the Otterfile requires
+func parseMinVersionCommand(args []string, config *OtterfileConfig) error {
+	if len(args) != 1 || !conditionVersionPattern.MatchString(args[0]) {
+		return fmt.Errorf("MIN_VERSION requires a version such as 0.5.0, got: %s", strings.Join(args, " "))
+	}
+	version := strings.TrimPrefix(args[0], "v")
+	if config.MinVersion == "" || util.CompareVersions(version, config.MinVersion) > 0 {
+		config.MinVersion = version
+	}
+	return nil
+}
+
+// checkMinVersion returns an error when content has a MIN_VERSION newer than OtterVersion. It runs
+// before the Otterfile is parsed, so an older otter asks to be upgraded instead of failing on a
+// command it doesn't know yet.
+func checkMinVersion(content []byte) error {
+	current, ok := releaseVersion(OtterVersion)
+	if !ok {
+		return nil
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, MaxLineLength)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line, _ := splitTrailingComment(strings.TrimSpace(scanner.Text()))
+		fields := strings.Fields(line)
+		if len(fields) != 2 || strings.ToUpper(fields[0]) != "MIN_VERSION" || !conditionVersionPattern.MatchString(fields[1]) {
+			continue
+		}
+		required := strings.TrimPrefix(fields[1], "v")
+		if util.CompareVersions(current, required) < 0 {
+			return fmt.Errorf("error on line %d: this Otterfile requires otter %s or newer, but this is otter %s; upgrade with 'go install github.com/geoffjay/otter@latest' or download a release from https://github.com/geoffjay/otter/releases",
+				lineNumber, required, current)
+		}
+	}
+	// Lines too long to scan are reported by the parse itself
+	return nil
+}
+
+// releaseVersion returns the release a version of otter is built from, e.g. 0.5.0 for v0.5.0 or
+// v0.5.0-3-gabc1234, and false for development builds
+func releaseVersion(version string) (string, bool) {
+	version = strings.TrimPrefix(version, "v")
+	if i := strings.IndexAny(version, "-+"); i >= 0 {
+		version = version[:i]
+	}
+	if !conditionVersionPattern.MatchString(version) {
+		return "", false
+	}
+	return version, true
+}
@@ -0,0 +1,10 @@
+//go:build !(linux || darwin || freebsd || netbsd)
+
+package util
+
+import "io"
+
+// copyAttributes does nothing on systems without extended attributes and Unix ownership
+func copyAttributes(src, dst string, out io.Writer) error {
+	return nil
+}
@@ -0,0 +1,41 @@
+//go:build linux
+
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestCopyLayerPreserveAttributes(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	src := filepath.Join(layerDir, "app.conf")
+	os.WriteFile(src, []byte("setting=1"), 0644)
+	if err := unix.Setxattr(src, "user.otter.test", []byte("kept"), 0); err != nil {
+		t.Skipf("extended attributes not supported: %v", err)
+	}
+
+	fileOps := NewFileOperations()
+	if err := fileOps.CopyLayer(layerDir, filepath.Join(targetDir, "plain"), targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	if value, err := getXattr(filepath.Join(targetDir, "plain", "app.conf"), "user.otter.test"); err == nil && len(value) > 0 {
+		t.Errorf("expected attributes not to be copied by default, got %q", value)
+	}
+
+	fileOps.PreserveAttributes = true
+	if err := fileOps.CopyLayer(layerDir, filepath.Join(targetDir, "kept"), targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	value, err := getXattr(filepath.Join(targetDir, "kept", "app.conf"), "user.otter.test")
+	if err != nil {
+		t.Fatalf("expected attribute to be preserved: %v", err)
+	}
+	if string(value) != "kept" {
+		t.Errorf("attribute value = %q, want kept", value)
+	}
+}
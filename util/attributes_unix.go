@@ -0,0 +1,87 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// copyAttributes copies the extended attributes of src to dst and, when running as root, its owner
+// and group. Attributes the destination can't hold, such as security labels on another
+// filesystem, are reported and skipped like cp --preserve does.
+func copyAttributes(src, dst string, out io.Writer) error {
+	names, err := listXattrs(src)
+	if err != nil {
+		return fmt.Errorf("failed to list extended attributes of %s: %w", src, err)
+	}
+	for _, name := range names {
+		value, err := getXattr(src, name)
+		if err != nil {
+			return fmt.Errorf("failed to read extended attribute %s of %s: %w", name, src, err)
+		}
+		if err := unix.Lsetxattr(dst, name, value, 0); err != nil {
+			fmt.Fprintf(out, "    Could not preserve extended attribute %s on %s: %v\n", name, dst, err)
+		}
+	}
+
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		if err := os.Lchown(dst, int(stat.Uid), int(stat.Gid)); err != nil {
+			return fmt.Errorf("failed to set owner of %s: %w", dst, err)
+		}
+	}
+	return nil
+}
+
+// listXattrs returns the names of the extended attributes of path, or none when its filesystem
+// doesn't support them
+func listXattrs(path string) ([]string, error) {
+	size, err := unix.Llistxattr(path, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return nil, nil
+	}
+	if err != nil || size == 0 {
+		return nil, err
+	}
+
+	buf := make([]byte, size)
+	size, err = unix.Llistxattr(path, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, name := range strings.Split(string(buf[:size]), "\x00") {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// getXattr returns the value of an extended attribute of path
+func getXattr(path, name string) ([]byte, error) {
+	size, err := unix.Lgetxattr(path, name, nil)
+	if err != nil || size == 0 {
+		return nil, err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Lgetxattr(path, name, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:size], nil
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"time"
+)
+
+// AuditLogFileName is the name of the audit log inside .otter
+const AuditLogFileName = "audit.log"
+
+// AuditEntry records a single otter operation
+type AuditEntry struct {
+	Time         time.Time      `json:"time"`
+	Operation    string         `json:"operation"`
+	User         string         `json:"user"`
+	OtterVersion string         `json:"otter_version"`
+	Otterfile    string         `json:"otterfile,omitempty"`
+	Layers       []AuditLayer   `json:"layers"`
+	FilesChanged []FileChange   `json:"files_changed"`
+	FilesIgnored []IgnoredFile  `json:"files_ignored,omitempty"`
+	Hooks        []HookAttempt  `json:"hooks,omitempty"`
+	Approvals    []PlanApproval `json:"approvals,omitempty"`
+	Warnings     []Warning      `json:"warnings,omitempty"`
+	Success      bool           `json:"success"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// AuditLayer records a layer applied during an operation
+type AuditLayer struct {
+	Repository string `json:"repository"`
+	Commit     string `json:"commit,omitempty"`
+	Target     string `json:"target"`
+	Comment    string `json:"comment,omitempty"` // Trailing comment on the LAYER command, e.g. why it is used
+	// Files counts the files the layer added, modified, and skipped
+	Files *LayerSummary `json:"files,omitempty"`
+}
+
+// NewAuditEntry creates an audit entry for an operation started now
+func NewAuditEntry(operation, version string) *AuditEntry {
+	return &AuditEntry{
+		Time:         time.Now().UTC(),
+		Operation:    operation,
+		User:         CurrentUsername(),
+		OtterVersion: version,
+		Layers:       make([]AuditLayer, 0),
+		FilesChanged: make([]FileChange, 0),
+	}
+}
+
+// CurrentUsername returns the name of the user running otter
+func CurrentUsername() string {
+	if current, err := user.Current(); err == nil && current.Username != "" {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return os.Getenv("USERNAME")
+}
+
+// RecordAudit appends the entry to .otter/audit.log and forwards it to the configured sink
+func RecordAudit(projectRoot string, config AuditConfig, entry *AuditEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit entry: %w", err)
+	}
+
+	if !config.Disabled {
+		logPath := filepath.Join(projectRoot, ".otter", AuditLogFileName)
+		logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("failed to open audit log: %w", err)
+		}
+		defer logFile.Close()
+
+		if _, err := logFile.Write(append(data, '\n')); err != nil {
+			return fmt.Errorf("failed to write audit log: %w", err)
+		}
+	}
+
+	if config.Sink != "" {
+		if err := postJSON(config.Sink, data); err != nil {
+			return fmt.Errorf("failed to send audit entry to %s: %w", config.Sink, err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAudit(t *testing.T) {
+	projectRoot := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(projectRoot, ".otter"), 0755); err != nil {
+		t.Fatalf("Failed to create .otter: %v", err)
+	}
+
+	var received []byte
+	sink := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+	}))
+	defer sink.Close()
+
+	entry := NewAuditEntry("build", "v1.0.0")
+	entry.Layers = append(entry.Layers, AuditLayer{Repository: "./layer", Commit: "local-dir", Target: "."})
+	entry.FilesChanged = append(entry.FilesChanged, FileChange{Path: "README.md", Action: "create"})
+	entry.Success = true
+
+	for i := 0; i < 2; i++ {
+		if err := RecordAudit(projectRoot, AuditConfig{Sink: sink.URL}, entry); err != nil {
+			t.Fatalf("Failed to record audit entry: %v", err)
+		}
+	}
+
+	content, err := os.ReadFile(filepath.Join(projectRoot, ".otter", AuditLogFileName))
+	if err != nil {
+		t.Fatalf("Failed to read audit log: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 audit lines, got %d", len(lines))
+	}
+
+	var decoded AuditEntry
+	if err := json.Unmarshal([]byte(lines[0]), &decoded); err != nil {
+		t.Fatalf("Failed to decode audit line: %v", err)
+	}
+	if decoded.Operation != "build" || decoded.OtterVersion != "v1.0.0" || !decoded.Success {
+		t.Errorf("Unexpected audit entry: %+v", decoded)
+	}
+	if len(decoded.FilesChanged) != 1 || decoded.FilesChanged[0].Path != "README.md" {
+		t.Errorf("Expected recorded file change, got %+v", decoded.FilesChanged)
+	}
+
+	if !strings.Contains(string(received), `"operation":"build"`) {
+		t.Errorf("Expected sink to receive the entry, got %s", received)
+	}
+
+	t.Run("disabled log still forwards to sink", func(t *testing.T) {
+		disabledRoot := t.TempDir()
+		received = nil
+		if err := RecordAudit(disabledRoot, AuditConfig{Disabled: true, Sink: sink.URL}, entry); err != nil {
+			t.Fatalf("Failed to record audit entry: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(disabledRoot, ".otter", AuditLogFileName)); !os.IsNotExist(err) {
+			t.Error("Expected no audit log when disabled")
+		}
+		if len(received) == 0 {
+			t.Error("Expected sink to receive the entry")
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package util
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BuiltinLayerPrefix marks a layer embedded in the otter binary, e.g. builtin:editorconfig
+const BuiltinLayerPrefix = "builtin:"
+
+// builtinLayers holds the starter layers that ship with otter, one directory per layer
+//
+//go:embed all:builtin
+var builtinLayers embed.FS
+
+// BuiltinLayerNames returns the names of the embedded layers in sorted order
+func BuiltinLayerNames() []string {
+	entries, _ := fs.ReadDir(builtinLayers, "builtin")
+	var names []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// BuiltinLayer returns the files of an embedded layer
+func BuiltinLayer(name string) (fs.FS, error) {
+	for _, known := range BuiltinLayerNames() {
+		if known == name {
+			return fs.Sub(builtinLayers, "builtin/"+name)
+		}
+	}
+	return nil, fmt.Errorf("unknown built-in layer %q (available: %s)", name, strings.Join(BuiltinLayerNames(), ", "))
+}
+
+// isBuiltinLayer checks if the repository URL refers to an embedded layer
+func isBuiltinLayer(repoURL string) bool {
+	return strings.HasPrefix(repoURL, BuiltinLayerPrefix)
+}
+
+// handleBuiltinLayer writes an embedded layer into the cache directory so it can be applied like a local layer
+func (g *GitOperations) handleBuiltinLayer(repoURL string) (string, error) {
+	localPath, err := g.writeBuiltinLayer(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	g.printf("Using built-in layer: %s\n", strings.TrimPrefix(repoURL, BuiltinLayerPrefix))
+	return localPath, nil
+}
+
+// writeBuiltinLayer writes an embedded layer into the cache directory and returns its path there
+func (g *GitOperations) writeBuiltinLayer(repoURL string) (string, error) {
+	name := strings.TrimPrefix(repoURL, BuiltinLayerPrefix)
+	layer, err := BuiltinLayer(name)
+	if err != nil {
+		return "", err
+	}
+
+	// Start from a clean copy so files removed in a newer otter don't linger
+	localPath := filepath.Join(g.cacheDir, "builtin", name)
+	if err := os.RemoveAll(localPath); err != nil {
+		return "", fmt.Errorf("failed to clean built-in layer %s: %w", name, err)
+	}
+
+	err = fs.WalkDir(layer, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		dest := filepath.Join(localPath, filepath.FromSlash(path))
+		if d.IsDir() {
+			return os.MkdirAll(dest, 0755)
+		}
+		content, err := fs.ReadFile(layer, path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(dest, content, 0644)
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write built-in layer %s: %w", name, err)
+	}
+	return localPath, nil
+}
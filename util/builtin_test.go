@@ -0,0 +1,51 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuiltinLayers(t *testing.T) {
+	expected := []string{"editorconfig", "gitattributes", "license-mit", "makefile"}
+	if names := BuiltinLayerNames(); !reflect.DeepEqual(names, expected) {
+		t.Errorf("Expected built-in layers %v, got %v", expected, names)
+	}
+
+	if _, err := BuiltinLayer("missing"); err == nil || !strings.Contains(err.Error(), "editorconfig") {
+		t.Errorf("Expected an error listing the available layers, got %v", err)
+	}
+
+	cacheDir := t.TempDir()
+	gitOps := NewGitOperations(cacheDir)
+	layerPath, err := gitOps.CloneOrUpdateLayer("builtin:editorconfig")
+	if err != nil {
+		t.Fatalf("Failed to prepare built-in layer: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(layerPath, ".editorconfig")); err != nil {
+		t.Errorf("Expected .editorconfig in the built-in layer: %v", err)
+	}
+	if commit, err := gitOps.GetRepositoryCommit(layerPath); err != nil || commit != "local-dir" {
+		t.Errorf("Expected built-in layers to be treated as local directories, got %q (%v)", commit, err)
+	}
+
+	// Dotfiles of built-in layers aren't protected files
+	projectDir := t.TempDir()
+	fileOps := NewFileOperations()
+	for _, name := range []string{"editorconfig", "gitattributes"} {
+		layerPath, err := gitOps.CloneOrUpdateLayer(BuiltinLayerPrefix + name)
+		if err != nil {
+			t.Fatalf("Failed to prepare built-in layer %s: %v", name, err)
+		}
+		if err := fileOps.CopyLayer(layerPath, projectDir, projectDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+			t.Fatalf("Failed to copy built-in layer %s: %v", name, err)
+		}
+	}
+	for _, name := range []string{".editorconfig", ".gitattributes"} {
+		if _, err := os.Stat(filepath.Join(projectDir, name)); err != nil {
+			t.Errorf("Expected %s to be copied: %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,398 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheTokenEnv holds a bearer token sent to HTTP(S) cache backends
+const CacheTokenEnv = "OTTER_CACHE_TOKEN"
+
+// CacheConfig keeps the layers fetched into .otter/cache in a backend as well, so fresh workspaces,
+// such as CI jobs, restore them instead of cloning them again
+type CacheConfig struct {
+	// Backend is where layers are kept: dir:<path> for a shared directory, zip:<path> for a single
+	// archive, or an HTTP(S) URL that accepts GET and PUT. Relative paths are from the project.
+	Backend string `json:"backend"`
+	// ReadOnly restores layers from the backend without storing the ones builds clone or update
+	ReadOnly bool `json:"read_only"`
+}
+
+// CacheBackend keeps the git directories of cached layers by name
+type CacheBackend interface {
+	// Load restores the directory stored as name into dir, returning false when there isn't one
+	Load(name, dir string) (bool, error)
+	// Store replaces what is stored as name with the content of dir
+	Store(name, dir string) error
+	// String describes where layers are kept
+	String() string
+}
+
+// NewCacheBackend returns the backend config describes for the project at projectDir, or nil when
+// layers are only kept in .otter/cache
+func NewCacheBackend(config CacheConfig, projectDir string) (CacheBackend, error) {
+	backend, err := newCacheBackend(config.Backend, projectDir)
+	if err != nil || backend == nil {
+		return nil, err
+	}
+	if config.ReadOnly {
+		return readOnlyCacheBackend{backend}, nil
+	}
+	return backend, nil
+}
+
+func newCacheBackend(backend, projectDir string) (CacheBackend, error) {
+	resolve := func(path string) (string, error) {
+		if path == "" {
+			return "", fmt.Errorf("cache backend %s has no path", backend)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(projectDir, path)
+		}
+		return path, nil
+	}
+
+	switch {
+	case backend == "":
+		return nil, nil
+	case strings.HasPrefix(backend, "dir:"):
+		dir, err := resolve(strings.TrimPrefix(backend, "dir:"))
+		if err != nil {
+			return nil, err
+		}
+		return &dirCacheBackend{dir: dir}, nil
+	case strings.HasPrefix(backend, "zip:"):
+		path, err := resolve(strings.TrimPrefix(backend, "zip:"))
+		if err != nil {
+			return nil, err
+		}
+		return &zipCacheBackend{path: path}, nil
+	case strings.HasPrefix(backend, "http://"), strings.HasPrefix(backend, "https://"):
+		return &httpCacheBackend{url: strings.TrimSuffix(backend, "/")}, nil
+	}
+	return nil, fmt.Errorf("unsupported cache backend %q (use dir:, zip:, or http(s)://)", backend)
+}
+
+// readOnlyCacheBackend restores layers from a backend without storing any
+type readOnlyCacheBackend struct {
+	CacheBackend
+}
+
+func (b readOnlyCacheBackend) Store(name, dir string) error {
+	return nil
+}
+
+func (b readOnlyCacheBackend) String() string {
+	return b.CacheBackend.String() + " (read-only)"
+}
+
+// dirCacheBackend keeps each layer in a directory of its own, such as on a volume CI jobs share.
+// Layers are copied in and out, so concurrent builds never work in the same repository.
+type dirCacheBackend struct {
+	dir string
+}
+
+func (b *dirCacheBackend) String() string {
+	return b.dir
+}
+
+func (b *dirCacheBackend) Load(name, dir string) (bool, error) {
+	src := filepath.Join(b.dir, name)
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return false, nil
+	}
+	if err := copyTree(src, dir, func(string, os.FileInfo) bool { return false }); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (b *dirCacheBackend) Store(name, dir string) error {
+	if err := os.MkdirAll(b.dir, 0755); err != nil {
+		return err
+	}
+	// Copy next to the stored layer and swap it in, so a build restoring it never sees part of a copy
+	tmp, err := os.MkdirTemp(b.dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(dir, tmp, func(string, os.FileInfo) bool { return false }); err != nil {
+		return err
+	}
+	target := filepath.Join(b.dir, name)
+	if err := os.RemoveAll(target); err != nil {
+		return err
+	}
+	return os.Rename(tmp, target)
+}
+
+// zipCacheBackend keeps every layer in one archive, under a directory named for the layer, so the
+// cache can be kept as a single artifact
+type zipCacheBackend struct {
+	path string
+	mu   sync.Mutex // Layers fetched concurrently are stored one at a time
+}
+
+func (b *zipCacheBackend) String() string {
+	return b.path
+}
+
+func (b *zipCacheBackend) Load(name, dir string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	archive, err := zip.OpenReader(b.path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer archive.Close()
+
+	extracted, err := extractZip(archive.File, name+"/", dir)
+	return extracted > 0, err
+}
+
+func (b *zipCacheBackend) Store(name, dir string) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(b.path), 0755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(b.path), "."+filepath.Base(b.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		tmp.Close()
+		if err != nil {
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+
+	// Keep the other layers of the archive as they are and replace this one
+	w := zip.NewWriter(tmp)
+	prefix := name + "/"
+	if err := b.copyOthers(w, prefix); err != nil {
+		return err
+	}
+	if err := writeZipTree(w, dir, prefix); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), b.path)
+}
+
+// copyOthers copies the entries of the archive outside prefix to w
+func (b *zipCacheBackend) copyOthers(w *zip.Writer, prefix string) error {
+	archive, err := zip.OpenReader(b.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer archive.Close()
+
+	for _, f := range archive.File {
+		if strings.HasPrefix(f.Name, prefix) {
+			continue
+		}
+		if err := w.Copy(f); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// httpCacheBackend keeps each layer as an archive at <url>/<name>.zip, read with GET and written
+// with PUT, such as a cache server in front of a CI farm
+type httpCacheBackend struct {
+	url string
+}
+
+func (b *httpCacheBackend) String() string {
+	return b.url
+}
+
+func (b *httpCacheBackend) request(method, name string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.url+"/"+url.PathEscape(name)+".zip", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(CacheTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/zip")
+	}
+	client := &http.Client{Timeout: 5 * time.Minute}
+	return client.Do(req)
+}
+
+func (b *httpCacheBackend) Load(name, dir string) (bool, error) {
+	resp, err := b.request(http.MethodGet, name, nil)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode >= 300 {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, err
+	}
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return false, err
+	}
+	extracted, err := extractZip(archive.File, "", dir)
+	return extracted > 0, err
+}
+
+func (b *httpCacheBackend) Store(name, dir string) error {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	if err := writeZipTree(w, dir, ""); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	resp, err := b.request(http.MethodPut, name, buf.Bytes())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// writeZipTree adds the directories and files in dir to an archive, with prefix before their
+// slash-separated paths. Other kinds of files, such as symlinks, aren't added.
+func writeZipTree(w *zip.Writer, dir, prefix string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(dir, path)
+		if err != nil || relativePath == "." {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return nil
+		}
+
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = prefix + filepath.ToSlash(relativePath)
+		if info.IsDir() {
+			header.Name += "/"
+			_, err = w.CreateHeader(header)
+			return err
+		}
+		header.Method = zip.Deflate
+		entry, err := w.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		_, err = io.Copy(entry, file)
+		return err
+	})
+}
+
+// extractZip writes the entries of an archive under prefix to dir, without the prefix, and
+// returns how many there were. Entries that would land outside dir, or aren't directories or
+// regular files, are an error.
+func extractZip(files []*zip.File, prefix, dir string) (int, error) {
+	extracted := 0
+	for _, f := range files {
+		relativePath, ok := strings.CutPrefix(f.Name, prefix)
+		if !ok || relativePath == "" {
+			continue
+		}
+		target := filepath.FromSlash(strings.TrimSuffix(relativePath, "/"))
+		if !filepath.IsLocal(target) {
+			return extracted, fmt.Errorf("archive entry %s is outside the layer", f.Name)
+		}
+		target = filepath.Join(dir, target)
+		extracted++
+
+		mode := f.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, mode.Perm()|0700); err != nil {
+				return extracted, err
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			return extracted, fmt.Errorf("archive entry %s isn't a regular file", f.Name)
+		}
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return extracted, err
+		}
+		if err := extractZipFile(f, target); err != nil {
+			return extracted, err
+		}
+	}
+	return extracted, nil
+}
+
+// extractZipFile writes one archive entry to target with its permissions
+func extractZipFile(f *zip.File, target string) error {
+	src, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, f.Mode().Perm()|0200)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(target, f.Mode().Perm())
+}
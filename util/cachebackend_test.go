@@ -0,0 +1,223 @@
+package util
+
+import (
+	"archive/zip"
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// testCacheBackend stores two layers in a backend and restores them
+func testCacheBackend(t *testing.T, backend CacheBackend) {
+	t.Helper()
+
+	if found, err := backend.Load("missing", t.TempDir()); err != nil || found {
+		t.Fatalf("Expected a missing layer not to be found, got %v, %v", found, err)
+	}
+
+	layers := map[string]map[string]string{
+		"first-0a1b2c3d":  {"HEAD": "ref: refs/heads/main\n", "objects/ab/cdef": "object"},
+		"second-4e5f6a7b": {"HEAD": "ref: refs/heads/trunk\n"},
+	}
+	for name, files := range layers {
+		dir := t.TempDir()
+		for file, content := range files {
+			path := filepath.Join(dir, filepath.FromSlash(file))
+			os.MkdirAll(filepath.Dir(path), 0755)
+			if err := os.WriteFile(path, []byte(content), 0444); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := backend.Store(name, dir); err != nil {
+			t.Fatalf("Store %s failed: %v", name, err)
+		}
+	}
+	// Storing a layer again replaces it
+	replaced := t.TempDir()
+	os.WriteFile(filepath.Join(replaced, "HEAD"), []byte("ref: refs/heads/main\n"), 0644)
+	if err := backend.Store("first-0a1b2c3d", replaced); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	layers["first-0a1b2c3d"] = map[string]string{"HEAD": "ref: refs/heads/main\n"}
+
+	for name, files := range layers {
+		dir := filepath.Join(t.TempDir(), ".git")
+		found, err := backend.Load(name, dir)
+		if err != nil || !found {
+			t.Fatalf("Load %s failed: %v, %v", name, found, err)
+		}
+		var restored []string
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				relativePath, _ := filepath.Rel(dir, path)
+				restored = append(restored, filepath.ToSlash(relativePath))
+			}
+			return err
+		})
+		if len(restored) != len(files) {
+			t.Errorf("%s: expected %d files, got %v", name, len(files), restored)
+		}
+		for file, content := range files {
+			data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(file)))
+			if err != nil || string(data) != content {
+				t.Errorf("%s: expected %s to be %q, got %q, %v", name, file, content, data, err)
+			}
+		}
+	}
+}
+
+func TestDirCacheBackend(t *testing.T) {
+	projectDir := t.TempDir()
+	backend, err := NewCacheBackend(CacheConfig{Backend: "dir:shared"}, projectDir)
+	if err != nil {
+		t.Fatalf("NewCacheBackend failed: %v", err)
+	}
+	testCacheBackend(t, backend)
+	if _, err := os.Stat(filepath.Join(projectDir, "shared", "second-4e5f6a7b", "HEAD")); err != nil {
+		t.Errorf("Expected the layer in the shared directory: %v", err)
+	}
+}
+
+func TestZipCacheBackend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "artifacts", "otter-cache.zip")
+	backend, err := NewCacheBackend(CacheConfig{Backend: "zip:" + path}, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCacheBackend failed: %v", err)
+	}
+	testCacheBackend(t, backend)
+	if entries, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*")); len(entries) != 1 {
+		t.Errorf("Expected only the archive, got %v", entries)
+	}
+}
+
+func TestHTTPCacheBackend(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			stored[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := stored[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+	t.Setenv(CacheTokenEnv, "secret")
+
+	backend, err := NewCacheBackend(CacheConfig{Backend: server.URL + "/layers/"}, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewCacheBackend failed: %v", err)
+	}
+	testCacheBackend(t, backend)
+	if _, ok := stored["/layers/first-0a1b2c3d.zip"]; !ok {
+		t.Errorf("Expected an archive per layer, got %v", stored)
+	}
+
+	// A read-only backend restores layers without storing any
+	readOnly, _ := NewCacheBackend(CacheConfig{Backend: server.URL + "/layers", ReadOnly: true}, t.TempDir())
+	if err := readOnly.Store("third-8c9d0e1f", t.TempDir()); err != nil {
+		t.Fatalf("Store failed: %v", err)
+	}
+	if _, ok := stored["/layers/third-8c9d0e1f.zip"]; ok {
+		t.Error("Expected a read-only backend not to store layers")
+	}
+	if found, err := readOnly.Load("second-4e5f6a7b", t.TempDir()); err != nil || !found {
+		t.Errorf("Expected a read-only backend to restore layers, got %v, %v", found, err)
+	}
+}
+
+func TestNewCacheBackend(t *testing.T) {
+	if backend, err := NewCacheBackend(CacheConfig{}, t.TempDir()); backend != nil || err != nil {
+		t.Errorf("Expected no backend by default, got %v, %v", backend, err)
+	}
+	for _, invalid := range []string{"dir:", "zip:", "s3://bucket/cache", "/var/cache/otter"} {
+		if _, err := NewCacheBackend(CacheConfig{Backend: invalid}, t.TempDir()); err == nil {
+			t.Errorf("Expected %s to fail", invalid)
+		}
+	}
+}
+
+func TestExtractZipOutsideLayer(t *testing.T) {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	w.Create("layer/../../escaped")
+	w.Close()
+	archive, _ := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+
+	dir := t.TempDir()
+	if _, err := extractZip(archive.File, "layer/", filepath.Join(dir, "cache")); err == nil || !strings.Contains(err.Error(), "outside the layer") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "escaped")); !os.IsNotExist(err) {
+		t.Error("Expected nothing written outside the layer")
+	}
+}
+
+func TestRestoreLayerFromCache(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "VERSION", "1")
+
+	backend, _ := NewCacheBackend(CacheConfig{Backend: "zip:cache.zip"}, t.TempDir())
+	var output bytes.Buffer
+	gitOps := NewGitOperations(t.TempDir())
+	gitOps.Cache = backend
+	gitOps.Output = &output
+	if _, err := gitOps.handleRemoteRepository(upstreamDir); err != nil {
+		t.Fatalf("Failed to clone: %v", err)
+	}
+	if !strings.Contains(output.String(), "Stored in cache") {
+		t.Errorf("Expected the clone to be stored, got:\n%s", output.String())
+	}
+
+	// A fresh workspace restores the layer, with its files, instead of cloning it
+	output.Reset()
+	fresh := NewGitOperations(t.TempDir())
+	fresh.Cache = backend
+	fresh.Output = &output
+	layerPath, err := fresh.handleRemoteRepository(upstreamDir)
+	if err != nil {
+		t.Fatalf("Failed to restore: %v", err)
+	}
+	if !strings.Contains(output.String(), "Restored layer") || strings.Contains(output.String(), "Cloning") || strings.Contains(output.String(), "Stored in cache") {
+		t.Errorf("Expected the layer to be restored and left as stored, got:\n%s", output.String())
+	}
+	if data, err := os.ReadFile(filepath.Join(layerPath, "VERSION")); err != nil || string(data) != "1" {
+		t.Errorf("Expected the restored worktree, got %q, %v", data, err)
+	}
+	if commit, _ := fresh.GetRepositoryCommit(layerPath); commit != first.String() {
+		t.Errorf("Expected commit %s, got %s", first, commit)
+	}
+
+	// Updating it stores the new commit
+	commitFile(t, upstream, upstreamDir, "VERSION", "2")
+	output.Reset()
+	if _, err := fresh.handleRemoteRepository(upstreamDir); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if !strings.Contains(output.String(), "Stored in cache") {
+		t.Errorf("Expected the update to be stored, got:\n%s", output.String())
+	}
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"os"
+)
+
+// CacheKey hashes the inputs of a build into a key that only changes when one of them does
+type CacheKey struct {
+	hash hash.Hash
+}
+
+// NewCacheKey creates an empty cache key
+func NewCacheKey() *CacheKey {
+	return &CacheKey{hash: sha256.New()}
+}
+
+// Add adds a named input. Names and data are length-prefixed so inputs can't run into each other.
+func (k *CacheKey) Add(name string, data []byte) {
+	for _, part := range [][]byte{[]byte(name), data} {
+		var size [8]byte
+		binary.BigEndian.PutUint64(size[:], uint64(len(part)))
+		k.hash.Write(size[:])
+		k.hash.Write(part)
+	}
+}
+
+// AddFile adds the content of a file as a named input, recording a missing file as absent
+func (k *CacheKey) AddFile(fsys FileSystem, name, path string) error {
+	content, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		k.Add(name+" (absent)", nil)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	k.Add(name, content)
+	return nil
+}
+
+// Sum returns the key as a hex string
+func (k *CacheKey) Sum() string {
+	return hex.EncodeToString(k.hash.Sum(nil))
+}
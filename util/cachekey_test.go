@@ -0,0 +1,48 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	fsys := NewMemFileSystem()
+	if err := fsys.MkdirAll("/project", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	if err := fsys.WriteFile("/project/Otterfile", []byte("LAYER ./base\n"), 0644); err != nil {
+		t.Fatalf("Failed to write Otterfile: %v", err)
+	}
+
+	key := func(lockfile string) string {
+		k := NewCacheKey()
+		if err := k.AddFile(fsys, "Otterfile", "/project/Otterfile"); err != nil {
+			t.Fatalf("AddFile failed: %v", err)
+		}
+		if err := k.AddFile(fsys, LockfileName, lockfile); err != nil {
+			t.Fatalf("AddFile failed: %v", err)
+		}
+		return k.Sum()
+	}
+
+	first := key("/project/Otterfile.lock")
+	if second := key("/project/Otterfile.lock"); first != second {
+		t.Errorf("Expected the same inputs to give the same key, got %s and %s", first, second)
+	}
+
+	if err := fsys.WriteFile("/project/Otterfile.lock", nil, 0644); err != nil {
+		t.Fatalf("Failed to write lockfile: %v", err)
+	}
+	if empty := key("/project/Otterfile.lock"); empty == first {
+		t.Errorf("Expected an empty lockfile to give a different key than a missing one")
+	}
+
+	// Inputs are delimited, so moving bytes between them changes the key
+	a, b := NewCacheKey(), NewCacheKey()
+	a.Add("x", []byte("ab"))
+	a.Add("y", []byte("c"))
+	b.Add("x", []byte("a"))
+	b.Add("y", []byte("bc"))
+	if a.Sum() == b.Sum() {
+		t.Errorf("Expected differently split inputs to give different keys")
+	}
+}
@@ -0,0 +1,300 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Kinds of problem CheckLayer reports
+const (
+	LayerCheckMetadata  = "metadata"  // .otter-layer.json doesn't parse or has unknown fields or invalid patterns
+	LayerCheckIgnore    = "ignore"    // A line of the layer's .otterignore is invalid or never matches as intended
+	LayerCheckTemplate  = "template"  // A file the layer copies doesn't parse as a template
+	LayerCheckForbidden = "forbidden" // A file the layer copies is a leftover of git, such as a merge conflict
+	LayerCheckSecret    = "secret"    // A file of the layer looks like a credential or contains one
+	LayerCheckSafety    = "safety"    // The layer can't be copied safely, as reported by CheckLayerSafety
+)
+
+// LayerFinding is a problem CheckLayer found with a layer
+type LayerFinding struct {
+	Check   string `json:"check"`
+	Path    string `json:"path,omitempty"` // Relative to the layer root, with forward slashes
+	Line    int    `json:"line,omitempty"`
+	Message string `json:"message"`
+}
+
+func (f LayerFinding) String() string {
+	location := f.Path
+	if f.Line > 0 {
+		location += ":" + strconv.Itoa(f.Line)
+	}
+	if location == "" {
+		return fmt.Sprintf("%s [%s]", f.Message, f.Check)
+	}
+	return fmt.Sprintf("%s: %s [%s]", location, f.Message, f.Check)
+}
+
+// LayerCheckResult is the outcome of checking a layer
+type LayerCheckResult struct {
+	Layer    string         `json:"layer"`
+	Files    int            `json:"files"` // Files the layer copies
+	Findings []LayerFinding `json:"findings"`
+}
+
+// secretFileNames are files that hold credentials rather than configuration
+var secretFileNames = map[string]bool{
+	".env": true, ".netrc": true, ".pgpass": true,
+	"id_rsa": true, "id_dsa": true, "id_ecdsa": true, "id_ed25519": true,
+}
+
+// secretFileExtensions are extensions of key stores
+var secretFileExtensions = map[string]bool{".key": true, ".p12": true, ".pfx": true, ".jks": true, ".keystore": true}
+
+// envExampleSuffixes mark .env files that document variables rather than set them, e.g. .env.example
+var envExampleSuffixes = map[string]bool{"example": true, "sample": true, "template": true, "dist": true}
+
+// conflictMarkers start the lines git writes into files with merge conflicts
+var conflictMarkers = []string{"<<<<<<< ", ">>>>>>> ", "<<<<<<<\n", ">>>>>>>\n"}
+
+// CheckLayer validates a layer on its own, without an Otterfile or project: its .otter-layer.json and
+// .otterignore, the template syntax of the files it copies with the given delimiters, leftovers of
+// git among them, and credentials anywhere in the layer, since the repository publishes those whether
+// or not they are copied. The layer's own .git directory isn't checked.
+func CheckLayer(layerPath string, delims [2]string) (*LayerCheckResult, error) {
+	result := &LayerCheckResult{Layer: layerPath, Findings: make([]LayerFinding, 0)}
+	result.Findings = append(result.Findings, checkLayerMetadata(layerPath)...)
+	result.Findings = append(result.Findings, checkLayerIgnoreFile(layerPath)...)
+
+	f := NewFileOperations()
+	rules, err := f.combinedIgnoreRules(layerPath)
+	if err != nil {
+		// The ignore file findings describe the problem, so check the layer with the built-in rules
+		rules = nil
+		for _, pattern := range criticalIgnorePatterns {
+			rules = append(rules, IgnoreRule{Pattern: pattern, Source: "built-in"})
+		}
+	}
+
+	err = filepath.Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(layerPath, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if relativePath == "." {
+			return nil
+		}
+		slashPath := filepath.ToSlash(relativePath)
+		if info.Name() == ".git" {
+			if slashPath != ".git" {
+				result.Findings = append(result.Findings, LayerFinding{Check: LayerCheckForbidden, Path: slashPath, Message: "nested git repository or submodule; its files are never copied"})
+			}
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() || !info.Mode().IsRegular() {
+			return nil
+		}
+
+		copied := f.matchingIgnoreRule(relativePath, relativePath, rules) == nil
+		if copied {
+			result.Files++
+		}
+		result.Findings = append(result.Findings, checkLayerFile(srcPath, slashPath, copied, delims)...)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to check layer %s: %w", layerPath, err)
+	}
+
+	var safetyErr *LayerSafetyError
+	if err := f.CheckLayerSafety(layerPath, "", rules); errors.As(err, &safetyErr) {
+		for _, paths := range safetyErr.Collisions {
+			result.Findings = append(result.Findings, LayerFinding{Check: LayerCheckSafety, Path: paths[0], Message: strings.Join(paths, " and ") + " differ only in case"})
+		}
+		for _, problem := range safetyErr.Unsafe {
+			result.Findings = append(result.Findings, LayerFinding{Check: LayerCheckSafety, Message: problem})
+		}
+	} else if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// checkLayerMetadata validates the layer's .otter-layer.json against the fields otter knows
+func checkLayerMetadata(layerPath string) []LayerFinding {
+	data, err := os.ReadFile(filepath.Join(layerPath, LayerMetadataName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	finding := func(line int, format string, args ...interface{}) LayerFinding {
+		return LayerFinding{Check: LayerCheckMetadata, Path: LayerMetadataName, Line: line, Message: fmt.Sprintf(format, args...)}
+	}
+	if err != nil {
+		return []LayerFinding{finding(0, "failed to read: %v", err)}
+	}
+
+	var metadata LayerMetadata
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&metadata); err != nil {
+		line := 0
+		var syntaxErr *json.SyntaxError
+		var typeErr *json.UnmarshalTypeError
+		switch {
+		case errors.As(err, &syntaxErr):
+			line = lineAt(data, syntaxErr.Offset)
+		case errors.As(err, &typeErr):
+			line = lineAt(data, typeErr.Offset)
+		}
+		return []LayerFinding{finding(line, "%s", strings.TrimPrefix(err.Error(), "json: "))}
+	}
+
+	var findings []LayerFinding
+	if metadata.Deprecated != nil && metadata.Deprecated.Message == "" && metadata.Deprecated.Replacement == "" {
+		findings = append(findings, finding(0, "deprecated needs a message or a replacement"))
+	}
+	if c := metadata.Compatibility; c != nil {
+		for _, field := range []struct {
+			name     string
+			patterns []string
+		}{{"conflicts", c.Conflicts}, {"layers", c.Layers}, {"files", c.Files}} {
+			for _, pattern := range field.patterns {
+				if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+					findings = append(findings, finding(0, "compatibility.%s has an invalid pattern %q", field.name, pattern))
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// checkLayerIgnoreFile validates each line of the layer's .otterignore, including lines for other
+// operating systems, and flags patterns otter would match literally rather than as intended
+func checkLayerIgnoreFile(layerPath string) []LayerFinding {
+	const name = ".otterignore"
+	content, err := os.ReadFile(filepath.Join(layerPath, name))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return []LayerFinding{{Check: LayerCheckIgnore, Path: name, Message: fmt.Sprintf("failed to read: %v", err)}}
+	}
+
+	var findings []LayerFinding
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		finding := func(format string, args ...interface{}) {
+			findings = append(findings, LayerFinding{Check: LayerCheckIgnore, Path: name, Line: lineNumber, Message: fmt.Sprintf(format, args...)})
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line, _, err := ignoreLineCondition(line)
+		if err != nil {
+			finding("%v", err)
+			continue
+		}
+		if names, ok := strings.CutPrefix(line, IgnorePresetDirective+" "); ok {
+			if _, err := ExpandIgnorePresets(SplitPresetNames(names)); err != nil {
+				finding("%v", err)
+			}
+			continue
+		}
+
+		switch {
+		case strings.HasPrefix(line, "!"):
+			finding("pattern %s negates, which .otterignore doesn't support; it matches a file named %s", line, line)
+		case strings.Contains(line, "*") && line != "*" && (!strings.HasPrefix(line, "*.") || strings.ContainsAny(line[2:], "*/")):
+			finding("pattern %s never matches; wildcards are only supported as * and *.ext", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		findings = append(findings, LayerFinding{Check: LayerCheckIgnore, Path: name, Message: fmt.Sprintf("failed to read: %v", err)})
+	}
+	return findings
+}
+
+// checkLayerFile looks for credentials in a layer file and, when the layer copies it, for leftovers
+// of git and template syntax errors
+func checkLayerFile(srcPath, slashPath string, copied bool, delims [2]string) []LayerFinding {
+	var findings []LayerFinding
+	finding := func(check string, line int, format string, args ...interface{}) {
+		findings = append(findings, LayerFinding{Check: check, Path: slashPath, Line: line, Message: fmt.Sprintf(format, args...)})
+	}
+
+	base := path.Base(slashPath)
+	if prefix, suffix, ok := strings.Cut(base, ".env."); secretFileNames[base] || secretFileExtensions[path.Ext(base)] || (ok && prefix == "" && !envExampleSuffixes[suffix]) {
+		finding(LayerCheckSecret, 0, "file looks like it holds credentials")
+	}
+	if copied && (strings.HasSuffix(base, ".orig") || strings.HasSuffix(base, ".rej")) {
+		finding(LayerCheckForbidden, 0, "leftover of a merge or patch")
+	}
+
+	content, err := os.ReadFile(srcPath)
+	if err != nil {
+		finding(LayerCheckForbidden, 0, "failed to read: %v", err)
+		return findings
+	}
+	if bytes.IndexByte(content, 0) >= 0 {
+		return findings // Binary files are copied as they are
+	}
+
+	for _, match := range findSecrets(content) {
+		finding(LayerCheckSecret, match.Line, "line looks like it contains %s", match.Kind)
+	}
+	if !copied {
+		return findings
+	}
+
+	for i, line := range strings.SplitAfter(string(content), "\n") {
+		for _, marker := range conflictMarkers {
+			if strings.HasPrefix(line, marker) {
+				finding(LayerCheckForbidden, i+1, "merge conflict marker")
+				break
+			}
+		}
+	}
+
+	if strings.Contains(string(content), delims[0]) && strings.Contains(string(content), delims[1]) {
+		_, err := template.New(slashPath).Delims(delims[0], delims[1]).Funcs(NewFileOperations().templateFuncs()).Parse(string(content))
+		if err != nil {
+			line, message := templateErrorLine(err, slashPath)
+			finding(LayerCheckTemplate, line, "%s", message)
+		}
+	}
+	return findings
+}
+
+// templateErrorLine splits a template parse error into its line and message
+func templateErrorLine(err error, name string) (int, string) {
+	message := strings.TrimPrefix(err.Error(), "template: "+name+":")
+	lineText, rest, ok := strings.Cut(message, ":")
+	line, convErr := strconv.Atoi(lineText)
+	if !ok || convErr != nil {
+		return 0, err.Error()
+	}
+	return line, strings.TrimSpace(rest)
+}
+
+// lineAt returns the line of data that offset falls on
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
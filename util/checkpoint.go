@@ -0,0 +1,139 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// CheckpointFileName is the name of the build checkpoint inside .otter
+const CheckpointFileName = "checkpoint.json"
+
+// Build stages recorded in a checkpoint once every layer is applied, in the order they complete.
+// Layers are resolved, fetched, rendered, and applied one at a time, so until then the checkpoint
+// lists the layers finished so far.
+const (
+	StageApply = "apply" // Package manifests, pruning, and .gitignore rules written after the last layer
+	StageHooks = "hooks" // Deferred layer hooks and global after build hooks run
+)
+
+// checkpointStages are the stages in the order they complete
+var checkpointStages = []string{StageApply, StageHooks}
+
+// Checkpoint records the progress of a build, so a build that fails can continue where it stopped
+// without fetching and copying the layers it already applied
+type Checkpoint struct {
+	Version int    `json:"version"`
+	Key     string `json:"key"`             // Hash of the Otterfiles and options of the build
+	Stage   string `json:"stage,omitempty"` // Last stage completed; empty while applying layers
+	// Layers are the layers applied so far, in order
+	Layers []CheckpointLayer `json:"layers"`
+	// Gitignore and Packages hold what the applied layers contributed to files written at the end
+	Gitignore []GitignoreSection  `json:"gitignore,omitempty"`
+	Packages  []CheckpointPackage `json:"packages,omitempty"`
+}
+
+// CheckpointLayer is a layer a build finished applying, including its after hooks
+type CheckpointLayer struct {
+	Repository string         `json:"repository"`
+	Target     string         `json:"target"`
+	Commit     string         `json:"commit,omitempty"` // Commit pinned in the lockfile
+	Files      *ManifestLayer `json:"files,omitempty"`  // Files written into the project, for pruning
+}
+
+// CheckpointPackage is a package manifest merged from the layers applied so far
+type CheckpointPackage struct {
+	Path string                 `json:"path"`
+	Data map[string]interface{} `json:"data"`
+}
+
+// NewCheckpoint creates an empty checkpoint for a build identified by key
+func NewCheckpoint(key string) *Checkpoint {
+	return &Checkpoint{Version: 1, Key: key, Layers: make([]CheckpointLayer, 0)}
+}
+
+// CheckpointKey identifies a build by the content of its Otterfiles and its options; a checkpoint
+// is only resumed by a build with the same key
+func CheckpointKey(otterfiles []string, options ...string) (string, error) {
+	hash := sha256.New()
+	for _, path := range otterfiles {
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", path, len(content))
+		hash.Write(content)
+	}
+	for _, option := range options {
+		fmt.Fprintf(hash, "%s\x00", option)
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// LoadCheckpoint reads the checkpoint at path, returning nil when there isn't one
+func LoadCheckpoint(fsys FileSystem, path string) (*Checkpoint, error) {
+	data, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint %s: %w", path, err)
+	}
+
+	checkpoint := NewCheckpoint("")
+	if err := json.Unmarshal(data, checkpoint); err != nil {
+		return nil, fmt.Errorf("failed to parse checkpoint %s: %w", path, err)
+	}
+	return checkpoint, nil
+}
+
+// Save writes the checkpoint
+func (c *Checkpoint) Save(fsys FileSystem, path string) error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if err := fsys.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoint %s: %w", path, err)
+	}
+	return nil
+}
+
+// RemoveCheckpoint deletes the checkpoint at path, if there is one
+func RemoveCheckpoint(fsys FileSystem, path string) error {
+	if err := fsys.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove checkpoint %s: %w", path, err)
+	}
+	return nil
+}
+
+// Finished returns the layer the checkpoint recorded as applied at index, if any
+func (c *Checkpoint) Finished(index int) (CheckpointLayer, bool) {
+	if c == nil || index >= len(c.Layers) {
+		return CheckpointLayer{}, false
+	}
+	return c.Layers[index], true
+}
+
+// Reached reports whether the build completed the given stage
+func (c *Checkpoint) Reached(stage string) bool {
+	if c == nil || c.Stage == "" {
+		return false
+	}
+	for _, completed := range checkpointStages {
+		if completed == stage {
+			return true
+		}
+		if completed == c.Stage {
+			return false
+		}
+	}
+	return false
+}
+
+// Started reports whether the checkpoint records any progress to resume from
+func (c *Checkpoint) Started() bool {
+	return c != nil && (len(c.Layers) > 0 || c.Stage != "")
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	fsys := NewMemFileSystem()
+	if err := fsys.MkdirAll("/project/.otter", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	path := "/project/.otter/" + CheckpointFileName
+
+	if checkpoint, err := LoadCheckpoint(fsys, path); err != nil || checkpoint != nil {
+		t.Fatalf("Expected no checkpoint, got %+v (%v)", checkpoint, err)
+	}
+
+	checkpoint := NewCheckpoint("key")
+	if checkpoint.Started() || checkpoint.Reached(StageApply) {
+		t.Error("Expected a new checkpoint to record no progress")
+	}
+	checkpoint.Layers = append(checkpoint.Layers, CheckpointLayer{Repository: "base", Target: ".", Commit: "abc"})
+	checkpoint.Stage = StageApply
+	checkpoint.Gitignore = []GitignoreSection{{Path: "/project/.gitignore", Rules: []string{"node_modules"}}}
+	if err := checkpoint.Save(fsys, path); err != nil {
+		t.Fatalf("Failed to save checkpoint: %v", err)
+	}
+
+	loaded, err := LoadCheckpoint(fsys, path)
+	if err != nil {
+		t.Fatalf("Failed to load checkpoint: %v", err)
+	}
+	if !loaded.Started() || !loaded.Reached(StageApply) || loaded.Reached(StageHooks) {
+		t.Errorf("Expected the apply stage to be the last completed, got %q", loaded.Stage)
+	}
+	if layer, ok := loaded.Finished(0); !ok || layer.Commit != "abc" {
+		t.Errorf("Expected the base layer to be finished, got %+v", layer)
+	}
+	if _, ok := loaded.Finished(1); ok {
+		t.Error("Expected only one finished layer")
+	}
+
+	// The collected .gitignore rules carry over to the resumed build
+	fileOps := NewFileOperationsWithFS(fsys)
+	fileOps.RestoreGitignoreSections(loaded.Gitignore)
+	if err := fileOps.ApplyGitignoreFragments(); err != nil {
+		t.Fatalf("Failed to apply .gitignore rules: %v", err)
+	}
+	if content, _ := fsys.ReadFile("/project/.gitignore"); !strings.Contains(string(content), "\nnode_modules\n") {
+		t.Errorf("Expected restored rules in .gitignore, got %q", content)
+	}
+
+	if err := RemoveCheckpoint(fsys, path); err != nil {
+		t.Fatalf("Failed to remove checkpoint: %v", err)
+	}
+	if err := RemoveCheckpoint(fsys, path); err != nil {
+		t.Errorf("Expected removing a missing checkpoint to succeed: %v", err)
+	}
+}
+
+func TestCheckpointKey(t *testing.T) {
+	otterfile := filepath.Join(t.TempDir(), "Otterfile")
+	os.WriteFile(otterfile, []byte("LAYER ./base\n"), 0644)
+
+	key, err := CheckpointKey([]string{otterfile}, "build")
+	if err != nil {
+		t.Fatalf("CheckpointKey failed: %v", err)
+	}
+	if other, _ := CheckpointKey([]string{otterfile}, "bake"); other == key {
+		t.Error("Expected different options to change the key")
+	}
+	os.WriteFile(otterfile, []byte("LAYER ./other\n"), 0644)
+	if other, _ := CheckpointKey([]string{otterfile}, "build"); other == key {
+		t.Error("Expected a changed Otterfile to change the key")
+	}
+}
@@ -1,14 +1,105 @@
 package util
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/term"
 )
 
 // CommandExecutor handles executing shell commands for hooks
 type CommandExecutor struct {
 	WorkingDir string
+	Env        []string // Additional KEY=VALUE environment variables for commands
+	// Attempts records each run of a hook command since the last TakeAttempts
+	Attempts []HookAttempt
+	// Condition reports whether the condition of a hook command with WHEN holds
+	Condition func(expression string) (bool, error)
+}
+
+// HookOptions changes how a hook command runs
+type HookOptions struct {
+	Interactive     bool `json:"interactive,omitempty"`       // Attach stdin so the command can prompt, e.g. gh auth login; requires a terminal
+	ContinueOnError bool `json:"continue_on_error,omitempty"` // Report a failure and carry on with the build instead of aborting it
+	Retries         int  `json:"retries,omitempty"`           // Run a failing command again up to this many times, e.g. for network installs
+	// Once defers a layer's AFTER command to the end of the build, running identical commands
+	// declared by several layers a single time
+	Once    bool          `json:"once,omitempty"`
+	Workdir string        `json:"workdir,omitempty"` // Directory to run in, relative to the project
+	Env     []string      `json:"env,omitempty"`     // KEY=VALUE variables for this command only
+	Timeout time.Duration `json:"timeout,omitempty"` // Stop the command when it runs longer; each retry gets its own
+	When    string        `json:"when,omitempty"`    // Condition the command only runs under, e.g. env=ci
+}
+
+// DeferredHooks collects the hook commands marked ONCE, in the order they were first declared
+type DeferredHooks struct {
+	Commands []string
+	Options  []HookOptions
+	Layers   []string // Repositories of the layers that declared the commands
+	seen     map[string]bool
+}
+
+// Defer returns the commands of a layer's hook that run now with their options, and collects the
+// commands marked ONCE to run at the end of the build. A command already collected keeps the
+// options it was first declared with.
+func (d *DeferredHooks) Defer(layer string, commands []string, options []HookOptions) ([]string, []HookOptions) {
+	var now []string
+	var nowOptions []HookOptions
+	for i, command := range commands {
+		var opts HookOptions
+		if i < len(options) {
+			opts = options[i]
+		}
+		if !opts.Once {
+			now = append(now, command)
+			nowOptions = append(nowOptions, opts)
+			continue
+		}
+		if d.seen == nil {
+			d.seen = make(map[string]bool)
+		}
+		if !containsString(d.Layers, layer) {
+			d.Layers = append(d.Layers, layer)
+		}
+		if !d.seen[command] {
+			d.seen[command] = true
+			d.Commands = append(d.Commands, command)
+			d.Options = append(d.Options, opts)
+		}
+	}
+	return now, nowOptions
+}
+
+// HookAttempt records one run of a hook command
+type HookAttempt struct {
+	Hook     string `json:"hook"`
+	Command  string `json:"command"`
+	Attempt  int    `json:"attempt"`
+	Duration string `json:"duration"`
+	Error    string `json:"error,omitempty"`
+	// Continued is set on a final failed attempt the build ignored because of CONTINUE_ON_ERROR
+	Continued bool `json:"continued,omitempty"`
+}
+
+// hookRetryDelay is the pause before retrying a failed hook command, multiplied by the attempt
+var hookRetryDelay = 2 * time.Second
+
+// ErrNotInteractive is returned for an interactive hook command when stdin isn't a terminal
+var ErrNotInteractive = errors.New("stdin is not a terminal")
+
+// stdinIsTerminal reports whether stdin is a terminal, replaced in tests
+var stdinIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// StdinIsTerminal reports whether otter can prompt for input
+func StdinIsTerminal() bool {
+	return stdinIsTerminal()
 }
 
 // NewCommandExecutor creates a new CommandExecutor
@@ -20,16 +111,51 @@ func NewCommandExecutor(workingDir string) *CommandExecutor {
 
 // ExecuteCommands executes a list of shell commands in sequence
 func (c *CommandExecutor) ExecuteCommands(commands []string, context string) error {
+	return c.ExecuteHook(commands, nil, context)
+}
+
+// ExecuteHook executes the commands of a hook in sequence, each with the options at the same index;
+// commands without options run with the defaults. Interactive commands fail before any command
+// runs when stdin isn't a terminal, rather than waiting for input that can't arrive.
+func (c *CommandExecutor) ExecuteHook(commands []string, options []HookOptions, context string) error {
 	if len(commands) == 0 {
 		return nil
 	}
 
+	for i, command := range commands {
+		if i < len(options) && options[i].Interactive && !stdinIsTerminal() {
+			return fmt.Errorf("%s command '%s' is INTERACTIVE and needs a terminal to read input: %w", context, command, ErrNotInteractive)
+		}
+	}
+
 	fmt.Printf("  Executing %s commands:\n", context)
 
 	for i, command := range commands {
 		fmt.Printf("    [%d/%d] %s\n", i+1, len(commands), command)
 
-		if err := c.ExecuteCommand(command); err != nil {
+		var opts HookOptions
+		if i < len(options) {
+			opts = options[i]
+		}
+		if opts.When != "" {
+			if c.Condition == nil {
+				return fmt.Errorf("%s command '%s' has a WHEN condition that can't be evaluated here", context, command)
+			}
+			run, err := c.Condition(opts.When)
+			if err != nil {
+				return fmt.Errorf("error evaluating condition for %s command '%s': %w", context, command, err)
+			}
+			if !run {
+				fmt.Printf("    Skipped: condition not met (%s)\n", opts.When)
+				continue
+			}
+		}
+		if err := c.runAttempts(command, opts, context); err != nil {
+			if opts.ContinueOnError {
+				fmt.Printf("    Warning: %s command '%s' failed, continuing: %v\n", context, command, err)
+				c.Attempts[len(c.Attempts)-1].Continued = true
+				continue
+			}
 			return fmt.Errorf("failed to execute %s command '%s': %w", context, command, err)
 		}
 	}
@@ -37,28 +163,97 @@ func (c *CommandExecutor) ExecuteCommands(commands []string, context string) err
 	return nil
 }
 
+// runAttempts runs a hook command until it succeeds or its retries are used up, recording each attempt
+func (c *CommandExecutor) runAttempts(command string, options HookOptions, context string) error {
+	attempts := 1 + max(options.Retries, 0)
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if attempt > 1 {
+			fmt.Printf("    Retrying (attempt %d/%d): %v\n", attempt, attempts, err)
+			time.Sleep(time.Duration(attempt-1) * hookRetryDelay)
+		}
+
+		start := time.Now()
+		err = c.runCommand(command, options)
+		record := HookAttempt{
+			Hook:     context,
+			Command:  command,
+			Attempt:  attempt,
+			Duration: time.Since(start).Round(time.Millisecond).String(),
+		}
+		if err != nil {
+			record.Error = err.Error()
+		}
+		c.Attempts = append(c.Attempts, record)
+
+		if err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// TakeAttempts returns the hook attempts recorded since the previous call and resets the record
+func (c *CommandExecutor) TakeAttempts() []HookAttempt {
+	attempts := c.Attempts
+	c.Attempts = nil
+	return attempts
+}
+
 // ExecuteCommand executes a single shell command
 func (c *CommandExecutor) ExecuteCommand(command string) error {
+	return c.runCommand(command, HookOptions{})
+}
+
+// runCommand executes a single shell command with the given options
+func (c *CommandExecutor) runCommand(command string, options HookOptions) error {
 	if command == "" {
 		return fmt.Errorf("empty command")
 	}
 
-	// Use shell to execute the command to support shell features like redirection, pipes, etc.
-	var cmd *exec.Cmd
-
-	// Detect shell based on OS
-	if os.Getenv("SHELL") != "" {
-		cmd = exec.Command(os.Getenv("SHELL"), "-c", command)
-	} else {
-		// Default to /bin/sh on Unix-like systems, cmd.exe on Windows
-		cmd = exec.Command("/bin/sh", "-c", command)
+	ctx := context.Background()
+	if options.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, options.Timeout)
+		defer cancel()
 	}
 
+	cmd := shellCommandContext(ctx, command)
 	cmd.Dir = c.WorkingDir
+	if options.Workdir != "" {
+		cmd.Dir = filepath.Join(c.WorkingDir, options.Workdir)
+	}
+	if len(c.Env) > 0 || len(options.Env) > 0 {
+		cmd.Env = append(append(os.Environ(), c.Env...), options.Env...)
+	}
+	if options.Interactive {
+		cmd.Stdin = os.Stdin
+	}
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 
-	return cmd.Run()
+	err := cmd.Run()
+	if err != nil && ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", options.Timeout)
+	}
+	return err
+}
+
+// shellCommand builds a command that runs through the user's shell to support
+// shell features like redirection, pipes, etc.
+func shellCommand(command string) *exec.Cmd {
+	return shellCommandContext(context.Background(), command)
+}
+
+// shellCommandContext builds a shell command that is killed when ctx is done
+func shellCommandContext(ctx context.Context, command string) *exec.Cmd {
+	// Detect shell based on OS
+	if os.Getenv("SHELL") != "" {
+		return exec.CommandContext(ctx, os.Getenv("SHELL"), "-c", command)
+	}
+
+	// Default to /bin/sh on Unix-like systems
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
 }
 
 // ExecuteCommandsWithCleanup executes commands and runs cleanup on error
@@ -1,9 +1,11 @@
 package util
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 )
 
 func TestCommandExecutor(t *testing.T) {
@@ -129,3 +131,167 @@ func TestCommandExecutorWorkingDirectory(t *testing.T) {
 		t.Errorf("File was incorrectly created in wrong directory")
 	}
 }
+
+func TestCommandExecutorEnv(t *testing.T) {
+	tempDir := t.TempDir()
+	t.Setenv("OTTER_TEST_REGION", "us-east-1")
+	t.Setenv("OTTER_TEST_KEEP", "kept")
+
+	// Hooks see the parent environment with the executor's variables on top, the last one winning
+	executor := NewCommandExecutor(tempDir)
+	executor.Env = []string{"OTTER_TEST_REGION=eu-west-1", "OTTER_TEST_NAME=first", "OTTER_TEST_NAME=billing api"}
+	if err := executor.ExecuteCommand(`printf '%s|%s|%s' "$OTTER_TEST_REGION" "$OTTER_TEST_NAME" "$OTTER_TEST_KEEP" > env.txt`); err != nil {
+		t.Fatalf("Failed to execute command: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(tempDir, "env.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "eu-west-1|billing api|kept" {
+		t.Errorf("Unexpected environment: %s", content)
+	}
+}
+
+func TestExecuteHookInteractive(t *testing.T) {
+	tempDir := t.TempDir()
+	executor := NewCommandExecutor(tempDir)
+	commands := []string{"touch first.txt", "read answer || true"}
+	options := []HookOptions{{}, {Interactive: true}}
+
+	defer func(original func() bool) { stdinIsTerminal = original }(stdinIsTerminal)
+	stdinIsTerminal = func() bool { return false }
+
+	err := executor.ExecuteHook(commands, options, "before layer")
+	if !errors.Is(err, ErrNotInteractive) {
+		t.Fatalf("Expected ErrNotInteractive without a terminal, got %v", err)
+	}
+	// The hook fails before running any of its commands
+	if _, err := os.Stat(filepath.Join(tempDir, "first.txt")); err == nil {
+		t.Error("Expected no command to run when an interactive command can't read input")
+	}
+
+	// Commands without INTERACTIVE don't need a terminal
+	if err := executor.ExecuteHook(commands[:1], options[:1], "before layer"); err != nil {
+		t.Errorf("Expected a non-interactive hook to run, got %v", err)
+	}
+}
+
+func TestExecuteHookRetries(t *testing.T) {
+	tempDir := t.TempDir()
+	executor := NewCommandExecutor(tempDir)
+
+	defer func(original time.Duration) { hookRetryDelay = original }(hookRetryDelay)
+	hookRetryDelay = 0
+
+	// Fails on the first two runs, then succeeds
+	flaky := "echo x >> count; [ $(wc -l < count) -ge 3 ]"
+	if err := executor.ExecuteHook([]string{flaky}, []HookOptions{{Retries: 2}}, "after layer"); err != nil {
+		t.Fatalf("Expected the command to succeed on its last retry, got %v", err)
+	}
+	attempts := executor.TakeAttempts()
+	if len(attempts) != 3 || attempts[0].Error == "" || attempts[2].Error != "" || attempts[2].Attempt != 3 {
+		t.Errorf("Expected two failed attempts and a successful one, got %+v", attempts)
+	}
+	if executor.TakeAttempts() != nil {
+		t.Error("Expected TakeAttempts to reset the record")
+	}
+
+	// A failure with CONTINUE_ON_ERROR doesn't stop the following commands
+	commands := []string{"exit 1", "touch after.txt"}
+	options := []HookOptions{{ContinueOnError: true, Retries: 1}}
+	if err := executor.ExecuteHook(commands, options, "after layer"); err != nil {
+		t.Fatalf("Expected CONTINUE_ON_ERROR to ignore the failure, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "after.txt")); err != nil {
+		t.Errorf("Expected the next command to run: %v", err)
+	}
+	attempts = executor.TakeAttempts()
+	if len(attempts) != 3 || attempts[0].Continued || !attempts[1].Continued {
+		t.Errorf("Expected the last failed attempt to be marked continued, got %+v", attempts)
+	}
+
+	if err := executor.ExecuteHook([]string{"exit 1"}, []HookOptions{{Retries: 1}}, "after layer"); err == nil {
+		t.Error("Expected a command failing every attempt to fail the hook")
+	}
+}
+
+func TestExecuteHookRunOptions(t *testing.T) {
+	tempDir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(tempDir, "web"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	executor := NewCommandExecutor(tempDir)
+	executor.Env = []string{"OTTER_TEST_REGION=eu-west-1", "OTTER_TEST_NAME=billing"}
+	executor.Condition = func(expression string) (bool, error) { return expression == "env=ci", nil }
+
+	commands := []string{
+		`printf '%s|%s' "$OTTER_TEST_REGION" "$OTTER_TEST_NAME" > env.txt`,
+		"touch skipped.txt",
+		"touch ran.txt",
+	}
+	options := []HookOptions{
+		{Workdir: "web", Env: []string{"OTTER_TEST_NAME=web"}},
+		{When: "env=production"},
+		{When: "env=ci"},
+	}
+	if err := executor.ExecuteHook(commands, options, "after build"); err != nil {
+		t.Fatalf("ExecuteHook failed: %v", err)
+	}
+
+	// The command's own variables apply on top of the executor's, in its own directory
+	content, err := os.ReadFile(filepath.Join(tempDir, "web", "env.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "eu-west-1|web" {
+		t.Errorf("Unexpected environment: %s", content)
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "skipped.txt")); err == nil {
+		t.Error("Expected a command whose condition doesn't hold to be skipped")
+	}
+	if _, err := os.Stat(filepath.Join(tempDir, "ran.txt")); err != nil {
+		t.Errorf("Expected a command whose condition holds to run: %v", err)
+	}
+	if attempts := executor.TakeAttempts(); len(attempts) != 2 {
+		t.Errorf("Expected only the commands that ran to be recorded, got %+v", attempts)
+	}
+
+	// A command that runs too long is stopped
+	start := time.Now()
+	err = executor.ExecuteHook([]string{"sleep 5"}, []HookOptions{{Timeout: 100 * time.Millisecond}}, "after build")
+	if err == nil || time.Since(start) > 4*time.Second {
+		t.Errorf("Expected the command to time out, got %v after %s", err, time.Since(start))
+	}
+
+	// Conditions need an evaluator
+	executor.Condition = nil
+	if err := executor.ExecuteHook([]string{"true"}, []HookOptions{{When: "env=ci"}}, "after build"); err == nil {
+		t.Error("Expected a condition without an evaluator to fail")
+	}
+}
+
+func TestDeferredHooks(t *testing.T) {
+	var deferred DeferredHooks
+
+	now, options := deferred.Defer("web", []string{"npm install", "echo web"}, []HookOptions{{Once: true, Retries: 2}})
+	if len(now) != 1 || now[0] != "echo web" || len(options) != 1 {
+		t.Errorf("Expected only echo web to run now, got %v %+v", now, options)
+	}
+	now, _ = deferred.Defer("tools", []string{"go mod tidy", "npm install"}, []HookOptions{{Once: true}, {Once: true}})
+	if len(now) != 0 {
+		t.Errorf("Expected every command to be deferred, got %v", now)
+	}
+	deferred.Defer("docs", []string{"npm install"}, nil)
+
+	// Identical commands are collected once, in the order first declared, with the first options
+	if len(deferred.Commands) != 2 || deferred.Commands[0] != "npm install" || deferred.Commands[1] != "go mod tidy" {
+		t.Errorf("Unexpected deferred commands: %v", deferred.Commands)
+	}
+	if len(deferred.Layers) != 2 || deferred.Layers[1] != "tools" {
+		t.Errorf("Expected the web and tools layers to declare deferred commands, got %v", deferred.Layers)
+	}
+	if deferred.Options[0].Retries != 2 {
+		t.Errorf("Expected npm install to keep its first options, got %+v", deferred.Options[0])
+	}
+}
@@ -0,0 +1,122 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ConfigFileName is the name of the project configuration file inside .otter
+const ConfigFileName = "config.json"
+
+// Config holds project-level otter settings read from .otter/config.json
+type Config struct {
+	Audit AuditConfig `json:"audit"`
+	Lock  LockConfig  `json:"lock"`
+	Scan  ScanConfig  `json:"scan"`
+	// ApplyLimits requires confirmation before applying unusually large layers
+	ApplyLimits ApplyLimitsConfig `json:"apply_limits"`
+	Warnings    WarningsConfig    `json:"warnings"`
+	// HiddenFiles is the policy for hidden files at a layer root: copy (default) or allow, which
+	// requires an ALLOW_HIDDEN clause listing them
+	HiddenFiles string `json:"hidden_files"`
+	// TemplateEnv lists the environment variables templates can read as .Env, with * wildcards;
+	// DefaultTemplateEnv when unset
+	TemplateEnv []string `json:"template_env"`
+	// TemplateLimits bounds the output and render time of layer templates
+	TemplateLimits TemplateLimitsConfig `json:"template_limits"`
+	// SecretScan is the policy for files layers write that look like they contain credentials:
+	// off (default), warn, or fail
+	SecretScan string `json:"secret_scan"`
+	// Suggest are layers otter suggest recommends in addition to DefaultSuggestionRules, such as an
+	// organization's own layers
+	Suggest []SuggestionRule `json:"suggest"`
+	// KnownLayers are layers otter init --from-existing recognizes by their files, in addition to
+	// registries given with --registry and the built-in layers
+	KnownLayers []KnownLayer `json:"known_layers"`
+	// Targets limits where in the project layers may write
+	Targets TargetPolicy `json:"targets"`
+	// State keeps the manifest in a remote backend instead of only in .otter
+	State StateConfig `json:"state"`
+	// Notify sends layer update and build notifications to webhooks
+	Notify NotifyConfig `json:"notify"`
+	// Fetch limits how many remote layers a build fetches from each git host at once and how quickly
+	Fetch FetchConfig `json:"fetch"`
+	// Cache keeps fetched layers in a shared directory, an archive, or an HTTP cache as well
+	Cache CacheConfig `json:"cache"`
+}
+
+// WarningsConfig controls which build warnings are reported
+type WarningsConfig struct {
+	Disable []string `json:"disable"` // Warning codes never to report, e.g. WARN002
+}
+
+// AuditConfig controls where audit entries are recorded
+type AuditConfig struct {
+	Disabled bool   `json:"disabled"` // Skip writing .otter/audit.log
+	Sink     string `json:"sink"`     // Optional HTTP(S) URL that receives each entry as a JSON POST
+}
+
+// LockConfig controls lockfile signature verification
+type LockConfig struct {
+	PublicKey string `json:"public_key"` // minisign public key; when set, --locked builds require a valid signature
+	// SnapshotLocal copies local layers into the cache and pins their content hash, so --locked
+	// builds use the pinned content even after the local directory changes
+	SnapshotLocal bool `json:"snapshot_local"`
+}
+
+// Default thresholds above which applying a layer requires confirmation
+const (
+	DefaultMaxFiles     = 1000
+	DefaultMaxMegabytes = 100
+)
+
+// ApplyLimitsConfig sets the thresholds above which applying the layers of a build requires confirmation.
+// Zero uses the default; a negative value disables the check.
+type ApplyLimitsConfig struct {
+	MaxFiles     int `json:"max_files"`
+	MaxMegabytes int `json:"max_megabytes"`
+}
+
+// Exceeded reports whether stats exceed the configured limits and describes why
+func (c ApplyLimitsConfig) Exceeded(stats LayerStats) (bool, string) {
+	maxFiles := c.MaxFiles
+	if maxFiles == 0 {
+		maxFiles = DefaultMaxFiles
+	}
+	maxMegabytes := c.MaxMegabytes
+	if maxMegabytes == 0 {
+		maxMegabytes = DefaultMaxMegabytes
+	}
+
+	if maxFiles > 0 && stats.Files > maxFiles {
+		return true, fmt.Sprintf("%d files exceeds the limit of %d", stats.Files, maxFiles)
+	}
+	if maxMegabytes > 0 && stats.Bytes > int64(maxMegabytes)*1024*1024 {
+		return true, fmt.Sprintf("%.1f MB exceeds the limit of %d MB", float64(stats.Bytes)/(1024*1024), maxMegabytes)
+	}
+
+	return false, ""
+}
+
+// LoadConfig reads .otter/config.json from the project root.
+// A missing file is not an error and yields the default configuration.
+func LoadConfig(projectRoot string) (*Config, error) {
+	config := &Config{}
+
+	configPath := filepath.Join(projectRoot, ".otter", ConfigFileName)
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return config, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", configPath, err)
+	}
+
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	return config, nil
+}
@@ -0,0 +1,49 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadConfig(t *testing.T) {
+	projectRoot := t.TempDir()
+
+	t.Run("missing config yields defaults", func(t *testing.T) {
+		config, err := LoadConfig(projectRoot)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if config.Audit.Disabled || config.Audit.Sink != "" {
+			t.Errorf("Expected default audit config, got %+v", config.Audit)
+		}
+	})
+
+	t.Run("reads audit settings", func(t *testing.T) {
+		otterDir := filepath.Join(projectRoot, ".otter")
+		if err := os.MkdirAll(otterDir, 0755); err != nil {
+			t.Fatalf("Failed to create .otter: %v", err)
+		}
+		content := `{"audit": {"disabled": true, "sink": "https://audit.example.com"}}`
+		if err := os.WriteFile(filepath.Join(otterDir, ConfigFileName), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+
+		config, err := LoadConfig(projectRoot)
+		if err != nil {
+			t.Fatalf("Expected no error, got %v", err)
+		}
+		if !config.Audit.Disabled || config.Audit.Sink != "https://audit.example.com" {
+			t.Errorf("Unexpected audit config: %+v", config.Audit)
+		}
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		if err := os.WriteFile(filepath.Join(projectRoot, ".otter", ConfigFileName), []byte("{"), 0644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+		if _, err := LoadConfig(projectRoot); err == nil {
+			t.Error("Expected error for invalid config")
+		}
+	})
+}
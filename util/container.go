@@ -0,0 +1,96 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// containerName matches the names docker accepts for containers; IDs match it too
+var containerName = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// ContainerTarget is a directory inside a running container that layers are applied into
+type ContainerTarget struct {
+	Container string // Container name or ID
+	Path      string // Absolute directory inside the container
+	Runtime   string // Container CLI, defaults to docker
+}
+
+// IsContainerTarget reports whether a TARGET refers to a container
+func IsContainerTarget(target string) bool {
+	return strings.HasPrefix(target, "docker://")
+}
+
+// ParseContainerTarget parses docker://container/path or container[:path]; the path defaults to /
+func ParseContainerTarget(target string) (*ContainerTarget, error) {
+	var container, containerPath string
+	if IsContainerTarget(target) {
+		container, containerPath, _ = strings.Cut(strings.TrimPrefix(target, "docker://"), "/")
+		containerPath = "/" + containerPath
+	} else {
+		container, containerPath, _ = strings.Cut(target, ":")
+	}
+
+	if container == "" {
+		return nil, fmt.Errorf("container target %s is missing a container name", target)
+	}
+	// A name starting with - would be read by docker as an option
+	if !containerName.MatchString(container) {
+		return nil, fmt.Errorf("container target %s has an invalid container name: %s", target, container)
+	}
+	if containerPath == "" {
+		containerPath = "/"
+	}
+	if !path.IsAbs(containerPath) {
+		return nil, fmt.Errorf("container target path must be absolute, got: %s", containerPath)
+	}
+
+	return &ContainerTarget{Container: container, Path: path.Clean(containerPath), Runtime: "docker"}, nil
+}
+
+// Join returns a target for a subdirectory of this one
+func (t *ContainerTarget) Join(elem string) RemoteTarget {
+	joined := *t
+	joined.Path = path.Join(t.Path, filepath.ToSlash(elem))
+	return &joined
+}
+
+func (t *ContainerTarget) String() string {
+	return "docker://" + t.Container + t.Path
+}
+
+// Upload copies the tree under root in fsys into the container using "docker cp", which
+// extracts a tar archive read from stdin into an existing directory
+func (t *ContainerTarget) Upload(fsys FileSystem, root string) error {
+	archive, err := tarTree(fsys, root)
+	if err != nil {
+		return err
+	}
+
+	runtime := t.Runtime
+	if runtime == "" {
+		runtime = "docker"
+	}
+
+	// -- ends the options, so the container is never read as one
+	mkdir := exec.Command(runtime, "exec", "--", t.Container, "mkdir", "-p", t.Path)
+	mkdir.Stdout = os.Stdout
+	mkdir.Stderr = os.Stderr
+	if err := mkdir.Run(); err != nil {
+		return fmt.Errorf("failed to create %s: %w", t, err)
+	}
+
+	cp := exec.Command(runtime, "cp", "--", "-", t.Container+":"+t.Path)
+	cp.Stdin = archive
+	cp.Stdout = os.Stdout
+	cp.Stderr = os.Stderr
+	if err := cp.Run(); err != nil {
+		return fmt.Errorf("failed to copy into %s: %w", t, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,91 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseContainerTarget(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    ContainerTarget
+		expectError bool
+	}{
+		{"docker://devenv/workspace/app", ContainerTarget{Container: "devenv", Path: "/workspace/app", Runtime: "docker"}, false},
+		{"docker://devenv", ContainerTarget{Container: "devenv", Path: "/", Runtime: "docker"}, false},
+		{"devenv:/workspace", ContainerTarget{Container: "devenv", Path: "/workspace", Runtime: "docker"}, false},
+		{"devenv", ContainerTarget{Container: "devenv", Path: "/", Runtime: "docker"}, false},
+		{"devenv:workspace", ContainerTarget{}, true},
+		{"docker:///workspace", ContainerTarget{}, true},
+		{"docker://--privileged/workspace", ContainerTarget{}, true},
+		{"-H=tcp://attacker:2375:/workspace", ContainerTarget{}, true},
+		{"dev env:/workspace", ContainerTarget{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			target, err := ParseContainerTarget(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %s", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if *target != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, *target)
+			}
+		})
+	}
+}
+
+func TestContainerTargetUpload(t *testing.T) {
+	tempDir := t.TempDir()
+	containerRoot := filepath.Join(tempDir, "container")
+
+	// Fake docker that maps the container filesystem onto containerRoot, and fails unless -- ends its options
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+	fakeDocker := `#!/bin/sh
+root="` + containerRoot + `"
+[ "$2" = -- ] || exit 1
+case "$1" in
+exec) shift 3; [ "$1" = mkdir ] && exec mkdir -p "$root$3" ;;
+cp) exec tar -xf - -C "$root${4#*:}" ;;
+esac
+exit 1
+`
+	if err := os.WriteFile(filepath.Join(binDir, "docker"), []byte(fakeDocker), 0755); err != nil {
+		t.Fatalf("Failed to write fake docker: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	staging := NewMemFileSystem()
+	if err := staging.MkdirAll("/staging/.vscode", 0755); err != nil {
+		t.Fatalf("Failed to create staging: %v", err)
+	}
+	if err := staging.WriteFile("/staging/.vscode/settings.json", []byte("{}"), 0644); err != nil {
+		t.Fatalf("Failed to write staging file: %v", err)
+	}
+
+	target := (&ContainerTarget{Container: "devenv", Path: "/workspace"}).Join("app")
+	if target.String() != "docker://devenv/workspace/app" {
+		t.Errorf("Unexpected string form: %s", target.String())
+	}
+	if err := target.Upload(staging, "/staging"); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(containerRoot, "workspace", "app", ".vscode", "settings.json"))
+	if err != nil {
+		t.Fatalf("Expected uploaded file: %v", err)
+	}
+	if string(content) != "{}" {
+		t.Errorf("Unexpected uploaded content: %s", content)
+	}
+}
@@ -0,0 +1,120 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+	"golang.org/x/term"
+)
+
+// DefaultPager pages output that doesn't fill the screen straight through and keeps colors
+const DefaultPager = "less -FRX"
+
+// stdoutIsTerminal reports whether stdout is a terminal, replaced in tests
+var stdoutIsTerminal = func() bool {
+	return term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// StdoutIsTerminal reports whether output is shown to someone, so it may be paged and colored
+func StdoutIsTerminal() bool {
+	return stdoutIsTerminal()
+}
+
+// PagerCommand returns the pager to show long output with: $OTTER_PAGER, $PAGER, or DefaultPager.
+// A variable set to an empty value turns paging off.
+func PagerCommand() string {
+	for _, name := range []string{"OTTER_PAGER", "PAGER"} {
+		if value, ok := os.LookupEnv(name); ok {
+			return strings.TrimSpace(value)
+		}
+	}
+	return DefaultPager
+}
+
+// DiffToolCommand returns the external tool $OTTER_DIFF names for showing unified diffs, such as
+// delta, with --side-by-side added when asked for, or "" when none is set
+func DiffToolCommand(sideBySide bool) string {
+	command := strings.TrimSpace(os.Getenv("OTTER_DIFF"))
+	if command != "" && sideBySide {
+		command += " --side-by-side"
+	}
+	return command
+}
+
+// Pager shows what is written to it in the user's pager until it is closed
+type Pager struct {
+	cmd   *exec.Cmd
+	input io.WriteCloser
+}
+
+// StartPager starts command with its output on out, or returns nil when command is empty or cat
+func StartPager(command string, out io.Writer) (*Pager, error) {
+	if command == "" || command == "cat" {
+		return nil, nil
+	}
+
+	cmd := shellCommand(command)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if os.Getenv("LESS") == "" {
+		// Like git, keep colors and don't page output that fits the screen when PAGER is a bare less
+		cmd.Env = append(os.Environ(), "LESS=FRX")
+	}
+	input, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, fmt.Errorf("failed to start pager '%s': %w", command, err)
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start pager '%s': %w", command, err)
+	}
+	return &Pager{cmd: cmd, input: input}, nil
+}
+
+func (p *Pager) Write(data []byte) (int, error) {
+	return p.input.Write(data)
+}
+
+// Close ends the output and waits for the user to quit the pager
+func (p *Pager) Close() error {
+	p.input.Close()
+	return p.cmd.Wait()
+}
+
+// WriteUnifiedDiff writes patch as a unified diff like git diff, colored when color is set
+func WriteUnifiedDiff(w io.Writer, patch diff.Patch, color bool) error {
+	encoder := diff.NewUnifiedEncoder(w, diff.DefaultContextLines)
+	if color {
+		encoder.SetColor(diff.NewColorConfig())
+	}
+	return encoder.Encode(patch)
+}
+
+// RunDiffTool shows a unified diff with an external tool, such as delta, writing its output to out
+func RunDiffTool(command string, unified []byte, out io.Writer) error {
+	cmd := shellCommand(command)
+	cmd.Stdin = bytes.NewReader(unified)
+	cmd.Stdout = out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("diff tool '%s' failed: %w", command, err)
+	}
+	return nil
+}
+
+// DisplayPath returns a path to show in output. Paths in any script are shown as they are, unlike
+// git's escaped octal; only those with control characters or invalid UTF-8 are quoted, so a file
+// name can't break the line it is shown on.
+func DisplayPath(path string) string {
+	if !utf8.ValidString(path) || strings.IndexFunc(path, unicode.IsControl) >= 0 {
+		return strconv.Quote(path)
+	}
+	return path
+}
@@ -0,0 +1,87 @@
+package util
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestDisplayPath(t *testing.T) {
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"docs/guide.md", "docs/guide.md"},
+		{"docs/guía.md", "docs/guía.md"},
+		{"設定/config.yml", "設定/config.yml"},
+		{"has space.txt", "has space.txt"},
+		{"new\nline.txt", `"new\nline.txt"`},
+		{"latin1-\xe9.txt", `"latin1-\xe9.txt"`},
+	}
+	for _, tt := range tests {
+		if got := DisplayPath(tt.path); got != tt.expected {
+			t.Errorf("DisplayPath(%q): expected %s, got %s", tt.path, tt.expected, got)
+		}
+	}
+}
+
+func TestPagerCommand(t *testing.T) {
+	t.Setenv("PAGER", "more")
+	t.Setenv("OTTER_PAGER", "less -R")
+	if got := PagerCommand(); got != "less -R" {
+		t.Errorf("Expected OTTER_PAGER to win, got %q", got)
+	}
+
+	// An empty OTTER_PAGER turns paging off rather than falling back to PAGER
+	t.Setenv("OTTER_PAGER", "")
+	if got := PagerCommand(); got != "" {
+		t.Errorf("Expected no pager, got %q", got)
+	}
+}
+
+func TestDiffToolCommand(t *testing.T) {
+	t.Setenv("OTTER_DIFF", "")
+	if got := DiffToolCommand(true); got != "" {
+		t.Errorf("Expected no diff tool, got %q", got)
+	}
+
+	t.Setenv("OTTER_DIFF", "delta --dark")
+	if got := DiffToolCommand(false); got != "delta --dark" {
+		t.Errorf("Expected delta --dark, got %q", got)
+	}
+	if got := DiffToolCommand(true); got != "delta --dark --side-by-side" {
+		t.Errorf("Expected delta --dark --side-by-side, got %q", got)
+	}
+}
+
+func TestStartPager(t *testing.T) {
+	if pager, err := StartPager("cat", &bytes.Buffer{}); pager != nil || err != nil {
+		t.Errorf("Expected cat to page nothing, got %v, %v", pager, err)
+	}
+
+	var out bytes.Buffer
+	pager, err := StartPager("tr a-z A-Z", &out)
+	if err != nil {
+		t.Fatalf("StartPager failed: %v", err)
+	}
+	pager.Write([]byte("paged\n"))
+	if err := pager.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if out.String() != "PAGED\n" {
+		t.Errorf("Expected the pager's output, got %q", out.String())
+	}
+}
+
+func TestRunDiffTool(t *testing.T) {
+	var out bytes.Buffer
+	if err := RunDiffTool("sed 's/^/> /'", []byte("-a\n+b\n"), &out); err != nil {
+		t.Fatalf("RunDiffTool failed: %v", err)
+	}
+	if out.String() != "> -a\n> +b\n" {
+		t.Errorf("Expected the tool's output, got %q", out.String())
+	}
+
+	if err := RunDiffTool("exit 2", nil, &out); err == nil {
+		t.Error("Expected an error for a failing tool")
+	}
+}
@@ -0,0 +1,254 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// EditorConfig holds the sections of a project's .editorconfig
+type EditorConfig struct {
+	Root     string // Directory containing the .editorconfig
+	sections []editorConfigSection
+}
+
+// editorConfigSection is a glob and the properties it sets
+type editorConfigSection struct {
+	pattern    *regexp.Regexp
+	properties map[string]string
+}
+
+// LoadEditorConfig reads .editorconfig from the project root; it returns nil when there is none
+func LoadEditorConfig(fsys FileSystem, projectRoot string) (*EditorConfig, error) {
+	configPath := filepath.Join(projectRoot, ".editorconfig")
+	content, err := fsys.ReadFile(configPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .editorconfig: %w", err)
+	}
+
+	config := &EditorConfig{Root: projectRoot}
+	var current *editorConfigSection
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			pattern, err := editorConfigPattern(line[1 : len(line)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid .editorconfig section %s: %w", line, err)
+			}
+			config.sections = append(config.sections, editorConfigSection{pattern: pattern, properties: make(map[string]string)})
+			current = &config.sections[len(config.sections)-1]
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || current == nil {
+			// Preamble properties such as root = true don't affect files
+			continue
+		}
+		current.properties[strings.ToLower(strings.TrimSpace(key))] = strings.ToLower(strings.TrimSpace(value))
+	}
+
+	return config, nil
+}
+
+// Properties returns the properties that apply to a file, with later sections taking precedence
+func (c *EditorConfig) Properties(filePath string) map[string]string {
+	properties := make(map[string]string)
+	if c == nil {
+		return properties
+	}
+
+	relativePath, err := filepath.Rel(c.Root, filePath)
+	if err != nil || strings.HasPrefix(relativePath, "..") {
+		return properties
+	}
+	relativePath = filepath.ToSlash(relativePath)
+
+	for _, section := range c.sections {
+		if section.pattern.MatchString(relativePath) {
+			for key, value := range section.properties {
+				properties[key] = value
+			}
+		}
+	}
+
+	return properties
+}
+
+// Format rewrites text content to follow the properties for filePath: indent_style and indent_size
+// for leading indentation, trim_trailing_whitespace, insert_final_newline, end_of_line, and charset.
+// Binary content is returned unchanged.
+func (c *EditorConfig) Format(filePath string, content []byte) []byte {
+	properties := c.Properties(filePath)
+	if len(properties) == 0 || bytes.IndexByte(content, 0) >= 0 {
+		return content
+	}
+
+	text := strings.TrimPrefix(string(content), "\uFEFF")
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	lines := strings.Split(text, "\n")
+
+	indentSize, _ := strconv.Atoi(properties["indent_size"])
+	if indentSize == 0 {
+		indentSize, _ = strconv.Atoi(properties["tab_width"])
+	}
+
+	for i, line := range lines {
+		if properties["trim_trailing_whitespace"] == "true" {
+			line = strings.TrimRight(line, " \t")
+		}
+		lines[i] = reindent(line, properties["indent_style"], indentSize)
+	}
+	text = strings.Join(lines, "\n")
+
+	switch properties["insert_final_newline"] {
+	case "true":
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			text += "\n"
+		}
+	case "false":
+		text = strings.TrimRight(text, "\n")
+	}
+
+	switch properties["end_of_line"] {
+	case "crlf":
+		text = strings.ReplaceAll(text, "\n", "\r\n")
+	case "cr":
+		text = strings.ReplaceAll(text, "\n", "\r")
+	}
+
+	switch properties["charset"] {
+	case "utf-8-bom":
+		return append([]byte("\uFEFF"), text...)
+	case "latin1":
+		if latin1, ok := encodeLatin1(text); ok {
+			return latin1
+		}
+	}
+
+	return []byte(text)
+}
+
+// reindent converts the leading indentation of a line between tabs and spaces
+func reindent(line, style string, size int) string {
+	body := strings.TrimLeft(line, " \t")
+	indent := line[:len(line)-len(body)]
+	if indent == "" || body == "" {
+		return line
+	}
+
+	switch {
+	case style == "space" && strings.Contains(indent, "\t"):
+		if size == 0 {
+			return line
+		}
+		return strings.ReplaceAll(indent, "\t", strings.Repeat(" ", size)) + body
+	case style == "tab" && strings.Contains(indent, " "):
+		if size == 0 {
+			return line
+		}
+		width := 0
+		for _, c := range indent {
+			if c == '\t' {
+				width += size
+			} else {
+				width++
+			}
+		}
+		return strings.Repeat("\t", width/size) + strings.Repeat(" ", width%size) + body
+	}
+
+	return line
+}
+
+// encodeLatin1 converts UTF-8 text to ISO-8859-1, failing when a character can't be represented
+func encodeLatin1(text string) ([]byte, bool) {
+	out := make([]byte, 0, len(text))
+	for _, r := range text {
+		if r > 0xFF {
+			return nil, false
+		}
+		out = append(out, byte(r))
+	}
+	return out, true
+}
+
+// editorConfigPattern compiles an EditorConfig section glob. Globs without a slash match the file
+// name in any directory; others are matched against the path relative to the .editorconfig.
+func editorConfigPattern(glob string) (*regexp.Regexp, error) {
+	var b strings.Builder
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+		b.WriteString("^")
+	} else {
+		b.WriteString("^(?:.*/)?")
+	}
+
+	braces := 0
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				b.WriteString(".*")
+				i++
+			} else {
+				b.WriteString("[^/]*")
+			}
+		case '?':
+			b.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end
+		case '{':
+			braces++
+			b.WriteString("(?:")
+		case '}':
+			if braces == 0 {
+				b.WriteString(`\}`)
+				continue
+			}
+			braces--
+			b.WriteString(")")
+		case ',':
+			if braces > 0 {
+				b.WriteString("|")
+			} else {
+				b.WriteString(",")
+			}
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				b.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+
+	return regexp.Compile(b.String())
+}
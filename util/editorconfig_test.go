@@ -0,0 +1,134 @@
+package util
+
+import (
+	"testing"
+)
+
+const testEditorConfig = `root = true
+
+[*]
+indent_style = space
+indent_size = 2
+insert_final_newline = true
+trim_trailing_whitespace = true
+
+[*.{go,mk}]
+indent_style = tab
+indent_size = 4
+
+[Makefile]
+indent_style = tab
+
+[docs/**.md]
+trim_trailing_whitespace = false
+end_of_line = crlf
+
+[*.txt]
+charset = latin1
+`
+
+func loadTestEditorConfig(t *testing.T) *EditorConfig {
+	t.Helper()
+
+	fsys := NewMemFileSystem()
+	if err := fsys.MkdirAll("/project", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	if err := fsys.WriteFile("/project/.editorconfig", []byte(testEditorConfig), 0644); err != nil {
+		t.Fatalf("Failed to write .editorconfig: %v", err)
+	}
+
+	config, err := LoadEditorConfig(fsys, "/project")
+	if err != nil {
+		t.Fatalf("Failed to load .editorconfig: %v", err)
+	}
+	return config
+}
+
+func TestEditorConfigProperties(t *testing.T) {
+	config := loadTestEditorConfig(t)
+
+	tests := []struct {
+		path, key, expected string
+	}{
+		{"/project/app.js", "indent_style", "space"},
+		{"/project/cmd/main.go", "indent_style", "tab"},
+		{"/project/cmd/main.go", "indent_size", "4"},
+		{"/project/build/rules.mk", "indent_style", "tab"},
+		{"/project/Makefile", "indent_style", "tab"},
+		{"/project/Makefile", "indent_size", "2"},
+		{"/project/docs/guide/intro.md", "end_of_line", "crlf"},
+		{"/project/README.md", "end_of_line", ""},
+		{"/elsewhere/main.go", "indent_style", ""},
+	}
+
+	for _, tt := range tests {
+		if got := config.Properties(tt.path)[tt.key]; got != tt.expected {
+			t.Errorf("%s %s: expected %q, got %q", tt.path, tt.key, tt.expected, got)
+		}
+	}
+
+	missing, err := LoadEditorConfig(NewMemFileSystem(), "/project")
+	if err != nil || missing != nil {
+		t.Errorf("Expected no config without .editorconfig, got %v, %v", missing, err)
+	}
+}
+
+func TestEditorConfigFormat(t *testing.T) {
+	config := loadTestEditorConfig(t)
+
+	tests := []struct {
+		path, input, expected string
+	}{
+		{"/project/app.yml", "a:\n\tb: 1   \n\t\tc: 2", "a:\n  b: 1\n    c: 2\n"},
+		{"/project/main.go", "func main() {\n    fmt.Println()\n      x()\n}\n", "func main() {\n\tfmt.Println()\n\t  x()\n}\n"},
+		{"/project/docs/a.md", "line  \nnext", "line  \r\nnext\r\n"},
+		{"/project/notes.txt", "caf\u00e9", "caf\xe9\n"},
+		{"/project/app.yml", "\uFEFFkey: value\r\n", "key: value\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(config.Format(tt.path, []byte(tt.input))); got != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.path, tt.expected, got)
+		}
+	}
+
+	binary := []byte("\x00\x01\t\x02")
+	if got := config.Format("/project/image.png", binary); string(got) != string(binary) {
+		t.Error("Binary content should not be changed")
+	}
+}
+
+func TestCopyLayerAppliesEditorConfig(t *testing.T) {
+	fsys := NewMemFileSystem()
+	for _, dir := range []string{"/layer", "/project"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := fsys.WriteFile("/project/.editorconfig", []byte("[*.json]\nindent_style = space\nindent_size = 2\ninsert_final_newline = true\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .editorconfig: %v", err)
+	}
+	if err := fsys.WriteFile("/layer/settings.json", []byte("{\n\t\"name\": \"{{.name}}\"\n}"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	config, err := LoadEditorConfig(fsys, "/project")
+	if err != nil {
+		t.Fatalf("Failed to load .editorconfig: %v", err)
+	}
+	fileOps.EditorConfig = config
+
+	if err := fileOps.CopyLayer("/layer", "/project", "/project", map[string]string{"name": "api"}, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	content, err := fsys.ReadFile("/project/settings.json")
+	if err != nil {
+		t.Fatalf("Failed to read written file: %v", err)
+	}
+	if string(content) != "{\n  \"name\": \"api\"\n}\n" {
+		t.Errorf("Unexpected formatted content: %q", content)
+	}
+}
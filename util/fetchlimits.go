@@ -0,0 +1,130 @@
+package util
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultFetchMaxConcurrent is how many remote layers are fetched from one host at once by default
+const DefaultFetchMaxConcurrent = 4
+
+// FetchLimits bound fetching remote layers from one git host, so Otterfiles with dozens of layers
+// from GitHub or GitLab stay under their abuse limits. For MaxConcurrent, zero uses the default
+// and a negative value disables the limit.
+type FetchLimits struct {
+	MaxConcurrent        int `json:"max_concurrent"` // Fetches from the host at once
+	IntervalMilliseconds int `json:"interval_ms"`    // Least time between starting fetches from the host; none when zero
+}
+
+// What updating a cached layer does when its branch was force-pushed
+const (
+	ForcePushedReset = "reset" // Reset the cache to the remote branch and report it (default)
+	ForcePushedFail  = "fail"  // Fail the fetch
+)
+
+// FetchConfig sets the fetch limits of every host, and overrides them for hosts by name, e.g.
+// github.com. Zero fields of an override use the limits of every host.
+type FetchConfig struct {
+	FetchLimits
+	Hosts map[string]FetchLimits `json:"hosts"`
+	// ForcePushed is what happens when the branch of a cached layer was force-pushed, one of the
+	// ForcePushed constants; reset when empty
+	ForcePushed string `json:"force_pushed"`
+	// DefaultBranch is cloned from remotes that don't say which branch is their default, before
+	// main, master, and trunk
+	DefaultBranch string `json:"default_branch"`
+}
+
+// Validate checks the configured values
+func (c FetchConfig) Validate() error {
+	switch c.ForcePushed {
+	case "", ForcePushedReset, ForcePushedFail:
+		return nil
+	}
+	return fmt.Errorf("fetch.force_pushed must be %s or %s, got %q", ForcePushedReset, ForcePushedFail, c.ForcePushed)
+}
+
+// limits returns the limits of host: how many fetches it allows at once, 0 when unlimited, and the
+// time between starting them
+func (c FetchConfig) limits(host string) (int, time.Duration) {
+	limits := c.FetchLimits
+	if override, ok := c.Hosts[host]; ok {
+		if override.MaxConcurrent != 0 {
+			limits.MaxConcurrent = override.MaxConcurrent
+		}
+		if override.IntervalMilliseconds != 0 {
+			limits.IntervalMilliseconds = override.IntervalMilliseconds
+		}
+	}
+
+	maxConcurrent := limits.MaxConcurrent
+	switch {
+	case maxConcurrent == 0:
+		maxConcurrent = DefaultFetchMaxConcurrent
+	case maxConcurrent < 0:
+		maxConcurrent = 0
+	}
+	return maxConcurrent, time.Duration(max(limits.IntervalMilliseconds, 0)) * time.Millisecond
+}
+
+// HostLimiter holds fetches back to the limits of their host. A nil *HostLimiter never waits.
+type HostLimiter struct {
+	config FetchConfig
+	mu     sync.Mutex
+	hosts  map[string]*hostLimit
+}
+
+// hostLimit is the state of one host: a slot for each fetch allowed at once, and the earliest
+// time the next fetch may start
+type hostLimit struct {
+	slots    chan struct{}
+	interval time.Duration
+	next     time.Time
+}
+
+// NewHostLimiter creates a limiter enforcing config
+func NewHostLimiter(config FetchConfig) *HostLimiter {
+	return &HostLimiter{config: config, hosts: make(map[string]*hostLimit)}
+}
+
+// Acquire waits until a fetch from host is within its limits and returns a function that must be
+// called when the fetch is done
+func (l *HostLimiter) Acquire(host string) func() {
+	if l == nil {
+		return func() {}
+	}
+
+	l.mu.Lock()
+	limit, ok := l.hosts[host]
+	if !ok {
+		maxConcurrent, interval := l.config.limits(host)
+		limit = &hostLimit{interval: interval}
+		if maxConcurrent > 0 {
+			limit.slots = make(chan struct{}, maxConcurrent)
+		}
+		l.hosts[host] = limit
+	}
+	l.mu.Unlock()
+
+	if limit.slots != nil {
+		limit.slots <- struct{}{}
+	}
+
+	// Each fetch reserves the next start time, so waiting fetches start one interval apart
+	l.mu.Lock()
+	now := time.Now()
+	start := limit.next
+	if start.Before(now) {
+		start = now
+	}
+	limit.next = start.Add(limit.interval)
+	l.mu.Unlock()
+	time.Sleep(time.Until(start))
+
+	return func() {
+		if limit.slots != nil {
+			<-limit.slots
+		}
+	}
+}
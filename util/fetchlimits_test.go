@@ -0,0 +1,82 @@
+package util
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestFetchConfigLimits(t *testing.T) {
+	config := FetchConfig{
+		FetchLimits: FetchLimits{IntervalMilliseconds: 100},
+		Hosts: map[string]FetchLimits{
+			"github.com": {MaxConcurrent: 2},
+			"gitlab.com": {MaxConcurrent: -1, IntervalMilliseconds: 500},
+		},
+	}
+	tests := []struct {
+		host          string
+		maxConcurrent int
+		interval      time.Duration
+	}{
+		{"example.com", DefaultFetchMaxConcurrent, 100 * time.Millisecond},
+		{"github.com", 2, 100 * time.Millisecond},
+		{"gitlab.com", 0, 500 * time.Millisecond},
+	}
+	for _, test := range tests {
+		maxConcurrent, interval := config.limits(test.host)
+		if maxConcurrent != test.maxConcurrent || interval != test.interval {
+			t.Errorf("limits(%q) = %d, %v, expected %d, %v", test.host, maxConcurrent, interval, test.maxConcurrent, test.interval)
+		}
+	}
+}
+
+func TestHostLimiterConcurrency(t *testing.T) {
+	limiter := NewHostLimiter(FetchConfig{Hosts: map[string]FetchLimits{"github.com": {MaxConcurrent: 2}}})
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			release := limiter.Acquire("github.com")
+			defer release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(20 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}()
+	}
+	wg.Wait()
+
+	if peak != 2 {
+		t.Errorf("Expected at most 2 fetches at once, got %d", peak)
+	}
+
+	// Other hosts have their own limits
+	release := limiter.Acquire("gitlab.com")
+	release()
+}
+
+func TestHostLimiterInterval(t *testing.T) {
+	limiter := NewHostLimiter(FetchConfig{FetchLimits: FetchLimits{IntervalMilliseconds: 50}})
+
+	start := time.Now()
+	for i := 0; i < 3; i++ {
+		limiter.Acquire("github.com")()
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected 3 fetches to start at least 100ms apart in total, took %v", elapsed)
+	}
+
+	// A nil limiter never waits
+	var unlimited *HostLimiter
+	unlimited.Acquire("github.com")()
+}
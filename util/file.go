@@ -3,7 +3,10 @@ package util
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
+	"io"
+	"io/fs"
 	"os"
 	"path/filepath"
 	"strings"
@@ -13,6 +16,69 @@ import (
 // FileOperations handles file copying and ignore patterns
 type FileOperations struct {
 	IgnorePatterns []string
+	IgnoreSources  map[string]string // Where each project ignore pattern came from, e.g. ".otterignore line 7"
+	Changes        []FileChange      // Files written since the last call to TakeChanges
+	Ignored        []IgnoredFile     // Files skipped since the last call to TakeIgnored
+	FS             FileSystem        // Filesystem layers are read from and written to
+	EditorConfig   *EditorConfig     // Project .editorconfig applied to written files, if any
+	Project        *ProjectInfo      // Project facts available to templates as .Project, if detected
+	User           *UserInfo         // Developer facts available to templates as .User, if detected
+	Build          *BuildInfo        // Build metadata available to templates as .Build
+	Layer          *LayerInfo        // Layer being applied, available to templates as .Layer
+	Env            map[string]string // Allowlisted environment variables available to templates as .Env
+	IgnoreRoot     string            // Directory nested .otterignore scopes are resolved against; defaults to the project root
+	IgnoreCase     bool              // Match ignore patterns case-insensitively, as on default macOS and Windows filesystems
+	AllowProtected []string          // Protected files the layer being applied may provide, from its ALLOW clause
+	AllowHidden    []string          // Hidden files at the layer root the layer may provide, from its ALLOW_HIDDEN clause
+	Only           []string          // Paths the layer being applied is limited to, from its ONLY clause; every path when empty
+	Map            []PathMapping     // Where files of the layer being applied land instead, from its MAP clause
+	Strategy       string            // What happens to files of the layer being applied that already exist, one of the Strategies; merge when empty
+	// RequireAllowHidden skips hidden files at a layer root unless ALLOW_HIDDEN lists them
+	RequireAllowHidden bool
+	// PreserveAttributes copies the extended attributes of files read from disk, and their owner
+	// when running as root, for provisioning system directories from local layers
+	PreserveAttributes bool
+	// TemplateLimits bounds the output size and render time of templates, and the functions they can use
+	TemplateLimits TemplateLimitsConfig
+	// Condition evaluates the IF conditions templates test with otterIf, otterEnv, and the like;
+	// templates calling them fail when nil
+	Condition ConditionFunc
+	// SecretScan is what happens to content that looks like it contains credentials before it is
+	// written, one of the SecretScan constants; nothing when empty
+	SecretScan string
+	// Output receives the progress of copying layers; os.Stdout when nil
+	Output io.Writer
+	// LogOutput is how CopyLayers combines the output of layers copied concurrently, one of the
+	// LogOutput constants; buffered when empty
+	LogOutput string
+	// Confirm asks whether to overwrite existing files; PromptForConfirmation when nil
+	Confirm func(prompt string) bool
+
+	// Filesystem the layer being copied is read from when it isn't FS, set by CopyLayerFS
+	layerSource FileSystem
+
+	// Rules from .otterignore files below the project root, keyed by their directory relative to it
+	scopedIgnoreRules map[string][]IgnoreRule
+
+	// Template variables each rendered file refers to, keyed by its destination path
+	templateUsage map[string][]string
+
+	// Rules collected from layer .gitignore.fragment files, keyed by the .gitignore they belong to
+	gitignoreRules map[string][]string
+	gitignoreOrder []string
+}
+
+// ErrAborted is returned when the user declines to overwrite existing files
+var ErrAborted = errors.New("build aborted by user")
+
+// FileChange records a file written into the project
+type FileChange struct {
+	Path      string `json:"path"`
+	Action    string `json:"action"`              // "create", "overwrite", "merge", "append", "rename", or "upload" for remote targets
+	Protected bool   `json:"protected,omitempty"` // A normally protected file the layer was allowed to provide
+	Hidden    bool   `json:"hidden,omitempty"`    // A hidden file, or a file in a hidden directory, at the layer root
+	From      string `json:"from,omitempty"`      // Previous path of a file that a layer renamed
+	Secret    string `json:"secret,omitempty"`    // Credential the written content looks like it contains, when secret_scan is warn
 }
 
 // FileConflict tracks files that would be overwritten during a layer copy
@@ -26,28 +92,69 @@ type FileConflict struct {
 func NewFileOperations() *FileOperations {
 	return &FileOperations{
 		IgnorePatterns: make([]string, 0),
+		IgnoreSources:  make(map[string]string),
+		Changes:        make([]FileChange, 0),
+		FS:             NewOSFileSystem(),
 	}
 }
 
+// NewFileOperationsWithFS creates a FileOperations instance that works against the given filesystem
+func NewFileOperationsWithFS(fsys FileSystem) *FileOperations {
+	f := NewFileOperations()
+	f.FS = fsys
+	return f
+}
+
+// TakeChanges returns the files written since the previous call and resets the record
+func (f *FileOperations) TakeChanges() []FileChange {
+	changes := f.Changes
+	f.Changes = make([]FileChange, 0)
+	return changes
+}
+
+// TakeIgnored returns the files skipped since the previous call and resets the record
+func (f *FileOperations) TakeIgnored() []IgnoredFile {
+	ignored := f.Ignored
+	f.Ignored = make([]IgnoredFile, 0)
+	return ignored
+}
+
 // LoadIgnorePatterns loads ignore patterns from .otterignore file
 func (f *FileOperations) LoadIgnorePatterns(projectRoot string) error {
 	ignorePath := filepath.Join(projectRoot, ".otterignore")
 
-	// If .otterignore doesn't exist, that's fine
-	if _, err := os.Stat(ignorePath); os.IsNotExist(err) {
-		return nil
-	}
+	// A missing root .otterignore is fine
+	if _, err := f.FS.Stat(ignorePath); err == nil {
+		content, err := f.FS.ReadFile(ignorePath)
+		if err != nil {
+			return fmt.Errorf("failed to open .otterignore: %w", err)
+		}
 
-	file, err := os.Open(ignorePath)
-	if err != nil {
-		return fmt.Errorf("failed to open .otterignore: %w", err)
+		rules, err := parseIgnoreRules(content, "project .otterignore")
+		if err != nil {
+			return fmt.Errorf("error reading .otterignore: %w", err)
+		}
+
+		f.IgnorePatterns = make([]string, 0, len(rules))
+		f.IgnoreSources = make(map[string]string)
+		for _, rule := range rules {
+			f.addIgnoreRule(rule)
+		}
 	}
-	defer file.Close()
 
-	f.IgnorePatterns = make([]string, 0)
-	scanner := bufio.NewScanner(file)
+	// Nested .otterignore files only apply below their own directory
+	return f.loadScopedIgnorePatterns(projectRoot)
+}
+
+// parseIgnoreRules reads the patterns of an .otterignore file, expanding any preset directives.
+// Each rule's source is the given file description and the line the pattern came from.
+func parseIgnoreRules(content []byte, source string) ([]IgnoreRule, error) {
+	rules := make([]IgnoreRule, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	lineNumber := 0
 
 	for scanner.Scan() {
+		lineNumber++
 		line := strings.TrimSpace(scanner.Text())
 
 		// Skip empty lines and comments
@@ -55,10 +162,35 @@ func (f *FileOperations) LoadIgnorePatterns(projectRoot string) error {
 			continue
 		}
 
-		f.IgnorePatterns = append(f.IgnorePatterns, line)
+		// Lines prefixed with [os=...] only apply on the listed operating systems
+		line, applies, err := ignoreLineCondition(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		if !applies {
+			continue
+		}
+
+		lineSource := fmt.Sprintf("%s line %d", source, lineNumber)
+		if names, ok := strings.CutPrefix(line, IgnorePresetDirective+" "); ok {
+			presetPatterns, err := ExpandIgnorePresets(SplitPresetNames(names))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+			}
+			for _, pattern := range presetPatterns {
+				rules = append(rules, IgnoreRule{Pattern: pattern, Source: lineSource + " (" + IgnorePresetDirective + ")"})
+			}
+			continue
+		}
+
+		rules = append(rules, IgnoreRule{Pattern: line, Source: lineSource})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
 	}
 
-	return scanner.Err()
+	return rules, nil
 }
 
 // IsIgnored checks if a file path should be ignored based on ignore patterns
@@ -75,6 +207,10 @@ func (f *FileOperations) IsIgnored(relativePath string) bool {
 func (f *FileOperations) matchPattern(pattern, path string) bool {
 	// Simple pattern matching - can be enhanced with more complex glob patterns later
 
+	if f.IgnoreCase {
+		pattern, path = strings.ToLower(pattern), strings.ToLower(path)
+	}
+
 	// Exact match
 	if pattern == path {
 		return true
@@ -104,6 +240,42 @@ func (f *FileOperations) matchPattern(pattern, path string) bool {
 	return strings.HasPrefix(path, pattern)
 }
 
+// matchProtected matches one of the criticalIgnorePatterns like matchPattern, except that a
+// prefix only matches whole path components, so .git covers .git/hooks but not .github or
+// .gitattributes, and .gitignore doesn't cover .gitignore.fragment
+func (f *FileOperations) matchProtected(pattern, path string) bool {
+	if f.IgnoreCase {
+		pattern, path = strings.ToLower(pattern), strings.ToLower(path)
+	}
+	dir := strings.TrimSuffix(pattern, "/")
+	if path == dir || strings.HasPrefix(path, dir+"/") {
+		return true
+	}
+	if !strings.Contains(pattern, "/") {
+		pathParts := strings.Split(path, "/")
+		return pattern == pathParts[len(pathParts)-1]
+	}
+	return false
+}
+
+// isProtected reports whether a path matches one of the criticalIgnorePatterns
+func (f *FileOperations) isProtected(relativePath string) bool {
+	for _, pattern := range criticalIgnorePatterns {
+		if f.matchProtected(pattern, relativePath) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchRule reports whether an ignore rule matches a path; built-in rules match as protected paths
+func (f *FileOperations) matchRule(rule IgnoreRule, path string) bool {
+	if rule.Source == "built-in" {
+		return f.matchProtected(rule.Pattern, path)
+	}
+	return f.matchPattern(rule.Pattern, path)
+}
+
 // matchWildcard performs simple wildcard matching
 func (f *FileOperations) matchWildcard(pattern, path string) bool {
 	// Simple implementation for basic wildcards
@@ -123,38 +295,96 @@ func (f *FileOperations) matchWildcard(pattern, path string) bool {
 
 // loadLayerIgnorePatterns loads ignore patterns from a layer's .otterignore file
 func (f *FileOperations) loadLayerIgnorePatterns(layerPath string) ([]string, error) {
+	rules, err := f.loadLayerIgnoreRules(layerPath)
+	if err != nil {
+		return nil, err
+	}
+	return rulePatterns(rules), nil
+}
+
+// loadLayerIgnoreRules loads the rules of a layer's .otterignore file
+func (f *FileOperations) loadLayerIgnoreRules(layerPath string) ([]IgnoreRule, error) {
 	ignorePath := filepath.Join(layerPath, ".otterignore")
 
-	// If .otterignore doesn't exist in the layer, return empty patterns
-	if _, err := os.Stat(ignorePath); os.IsNotExist(err) {
-		return []string{}, nil
+	// If .otterignore doesn't exist in the layer, return empty rules
+	if _, err := f.layerFS().Stat(ignorePath); os.IsNotExist(err) {
+		return []IgnoreRule{}, nil
 	}
 
-	file, err := os.Open(ignorePath)
+	content, err := f.layerFS().ReadFile(ignorePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open layer .otterignore: %w", err)
 	}
-	defer file.Close()
 
-	var patterns []string
-	scanner := bufio.NewScanner(file)
+	rules, err := parseIgnoreRules(content, "layer .otterignore")
+	if err != nil {
+		return nil, fmt.Errorf("error reading layer .otterignore: %w", err)
+	}
+
+	return rules, nil
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+// criticalIgnorePatterns are always ignored to prevent dangerous overwrites
+var criticalIgnorePatterns = []string{
+	".git",            // Never copy .git folder from layers (would overwrite project's git repo)
+	".git/",           // Directory pattern for .git
+	".otter",          // Never copy .otter cache folder from layers
+	".otter/",         // Directory pattern for .otter
+	".otterignore",    // Never copy .otterignore files from layers
+	".gitignore",      // Never copy .gitignore files from layers (would overwrite project's git ignore rules)
+	LayerMetadataName, // Never copy layer metadata, which describes the layer rather than the project
+}
 
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+// CheckAllowProtected returns an error unless name is a protected file that a layer may be allowed to provide.
+// The .git and .otter directories and layer metadata can never be allowed.
+func CheckAllowProtected(name string) error {
+	for _, reserved := range []string{".git", ".otter", LayerMetadataName} {
+		if name == reserved || name == reserved+"/" || strings.HasPrefix(name, reserved+"/") {
+			return fmt.Errorf("ALLOW cannot include %s", name)
+		}
+	}
+	for _, pattern := range criticalIgnorePatterns {
+		if name == pattern {
+			return nil
+		}
+	}
+	return fmt.Errorf("ALLOW expects a protected file name, got: %s", name)
+}
+
+// isAllowedProtected reports whether a built-in rule is lifted by the layer's ALLOW clause
+func (f *FileOperations) isAllowedProtected(rule IgnoreRule) bool {
+	if rule.Source != "built-in" {
+		return false
+	}
+	for _, name := range f.AllowProtected {
+		if name == rule.Pattern {
+			return true
 		}
+	}
+	return false
+}
 
-		patterns = append(patterns, line)
+// combinedIgnoreRules returns the project, layer, and critical ignore rules for a layer, in matching order
+func (f *FileOperations) combinedIgnoreRules(layerPath string) ([]IgnoreRule, error) {
+	layerIgnoreRules, err := f.loadLayerIgnoreRules(layerPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load layer ignore patterns: %w", err)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading layer .otterignore: %w", err)
+	combinedRules := make([]IgnoreRule, 0, len(f.IgnorePatterns)+len(layerIgnoreRules)+len(criticalIgnorePatterns))
+	for _, pattern := range f.IgnorePatterns {
+		source := f.IgnoreSources[pattern]
+		if source == "" {
+			source = "project"
+		}
+		combinedRules = append(combinedRules, IgnoreRule{Pattern: pattern, Source: source})
+	}
+	combinedRules = append(combinedRules, layerIgnoreRules...)
+	for _, pattern := range criticalIgnorePatterns {
+		combinedRules = append(combinedRules, IgnoreRule{Pattern: pattern, Source: "built-in"})
 	}
 
-	return patterns, nil
+	return combinedRules, nil
 }
 
 // isIgnoredWithPatterns checks if a file path should be ignored based on given patterns
@@ -167,31 +397,32 @@ func (f *FileOperations) isIgnoredWithPatterns(relativePath string, patterns []s
 	return false
 }
 
+// matchingIgnoreRule returns the first rule that ignores a layer file written to destPath, or nil
+func (f *FileOperations) matchingIgnoreRule(relativePath, destPath string, rules []IgnoreRule) *IgnoreRule {
+	for i := range rules {
+		if f.matchRule(rules[i], relativePath) && !f.isAllowedProtected(rules[i]) {
+			return &rules[i]
+		}
+	}
+	if rule := f.hiddenFileRule(relativePath); rule != nil {
+		return rule
+	}
+	if rule := f.onlyRule(relativePath); rule != nil {
+		return rule
+	}
+	return f.scopedIgnoreRule(destPath)
+}
+
 // DetectConflicts scans a layer directory and returns files that would be overwritten
 func (f *FileOperations) DetectConflicts(layerPath, targetPath string) ([]FileConflict, error) {
 	var conflicts []FileConflict
 
-	// Load layer-specific ignore patterns and combine with project patterns
-	layerIgnorePatterns, err := f.loadLayerIgnorePatterns(layerPath)
+	combinedRules, err := f.combinedIgnoreRules(layerPath)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load layer ignore patterns: %w", err)
-	}
-
-	// Combine project-level and layer-level ignore patterns
-	combinedPatterns := append(f.IgnorePatterns, layerIgnorePatterns...)
-
-	// CRITICAL: Always ignore these files/directories
-	criticalIgnorePatterns := []string{
-		".git",
-		".git/",
-		".otter",
-		".otter/",
-		".otterignore",
-		".gitignore",
+		return nil, err
 	}
-	combinedPatterns = append(combinedPatterns, criticalIgnorePatterns...)
 
-	err = filepath.Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
+	err = f.layerFS().Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -207,8 +438,11 @@ func (f *FileOperations) DetectConflicts(layerPath, targetPath string) ([]FileCo
 			return nil
 		}
 
+		// Calculate destination path
+		destPath := f.destinationPath(targetPath, relativePath)
+
 		// Check if this file should be ignored
-		if f.isIgnoredWithPatterns(relativePath, combinedPatterns) {
+		if f.matchingIgnoreRule(relativePath, destPath, combinedRules) != nil {
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
@@ -220,11 +454,12 @@ func (f *FileOperations) DetectConflicts(layerPath, targetPath string) ([]FileCo
 			return nil
 		}
 
-		// Calculate destination path
-		destPath := filepath.Join(targetPath, relativePath)
-
-		// Check if destination file exists
-		if _, err := os.Stat(destPath); err == nil {
+		// Check if destination file exists; files merged, appended to, or skipped by the layer's
+		// strategy aren't overwritten
+		if _, err := f.FS.Stat(destPath); err == nil {
+			if action, _ := f.existingAction(f.mappedPath(relativePath), srcPath); action != "overwrite" {
+				return nil
+			}
 			conflicts = append(conflicts, FileConflict{
 				RelativePath: relativePath,
 				SourcePath:   srcPath,
@@ -242,6 +477,109 @@ func (f *FileOperations) DetectConflicts(layerPath, targetPath string) ([]FileCo
 	return conflicts, nil
 }
 
+// LayerStats summarizes what copying a layer would write
+type LayerStats struct {
+	Files      int   // Number of files that would be written
+	Bytes      int64 // Total size of those files in the layer
+	Overwrites int   // Number of files that already exist in the target
+}
+
+// MeasureLayer reports how many files and bytes copying a layer into targetPath would write
+func (f *FileOperations) MeasureLayer(layerPath, targetPath string) (LayerStats, error) {
+	var stats LayerStats
+
+	combinedRules, err := f.combinedIgnoreRules(layerPath)
+	if err != nil {
+		return stats, err
+	}
+
+	err = f.layerFS().Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(layerPath, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+
+		if relativePath == "." {
+			return nil
+		}
+
+		destPath := f.destinationPath(targetPath, relativePath)
+		if f.matchingIgnoreRule(relativePath, destPath, combinedRules) != nil {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		action := "create"
+		if _, err := f.FS.Stat(destPath); err == nil {
+			action, _ = f.existingAction(f.mappedPath(relativePath), srcPath)
+		}
+		if action == "skip" {
+			return nil
+		}
+		stats.Files++
+		stats.Bytes += info.Size()
+		if action == "overwrite" {
+			stats.Overwrites++
+		}
+
+		return nil
+	})
+
+	return stats, err
+}
+
+// checkLayerOverlap returns an error when the target directory is the layer directory or inside it,
+// comparing real paths so symlinks and relative paths like ./. can't hide the overlap
+func checkLayerOverlap(layerPath, targetPath string) error {
+	realLayer, err := realPath(layerPath)
+	if err != nil {
+		return err
+	}
+	realTarget, err := realPath(targetPath)
+	if err != nil {
+		return err
+	}
+
+	if realTarget == realLayer {
+		return fmt.Errorf("layer %s is the target directory itself; a layer can't be applied onto its own source", layerPath)
+	}
+	relative, err := filepath.Rel(realLayer, realTarget)
+	if err == nil && relative != ".." && !strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return fmt.Errorf("layer %s contains its target directory %s; the layer would be copied into itself", layerPath, targetPath)
+	}
+	return nil
+}
+
+// realPath returns the absolute path with symlinks resolved. Parts of the path that don't exist
+// yet, such as a target directory about to be created, are kept as given.
+func realPath(path string) (string, error) {
+	absPath, err := filepath.Abs(shortPath(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve %s: %w", path, err)
+	}
+
+	missing := ""
+	for dir := absPath; ; dir = filepath.Dir(dir) {
+		if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+			return filepath.Join(resolved, missing), nil
+		}
+		if parent := filepath.Dir(dir); parent == dir {
+			return absPath, nil
+		}
+		missing = filepath.Join(filepath.Base(dir), missing)
+	}
+}
+
 // PromptForConfirmation prompts the user for y/n confirmation and returns true if confirmed
 func PromptForConfirmation(prompt string) bool {
 	fmt.Print(prompt)
@@ -256,8 +594,26 @@ func PromptForConfirmation(prompt string) bool {
 // CopyLayer copies files from a layer directory to the target directory
 // If force is false and there are file conflicts, the user will be prompted for confirmation
 func (f *FileOperations) CopyLayer(layerPath, targetPath string, projectRoot string, templateVars map[string]string, delims [2]string, force bool) error {
+	// A layer containing its target would copy into itself while it's being walked
+	if f.layerSource == nil {
+		if err := checkLayerOverlap(layerPath, targetPath); err != nil {
+			return err
+		}
+	}
+
+	combinedRules, err := f.combinedIgnoreRules(layerPath)
+	if err != nil {
+		return err
+	}
+
+	// Refuse layers whose files would overwrite each other on case-insensitive filesystems, or that
+	// would copy something other than their own files
+	if err := f.CheckLayerSafety(layerPath, targetPath, combinedRules); err != nil {
+		return err
+	}
+
 	// Ensure target directory exists
-	if err := os.MkdirAll(targetPath, 0755); err != nil {
+	if err := f.FS.MkdirAll(targetPath, 0755); err != nil {
 		return fmt.Errorf("failed to create target directory %s: %w", targetPath, err)
 	}
 
@@ -275,34 +631,19 @@ func (f *FileOperations) CopyLayer(layerPath, targetPath string, projectRoot str
 			}
 			fmt.Println()
 
-			if !PromptForConfirmation("  Do you want to proceed? [y/N]: ") {
-				return fmt.Errorf("build aborted by user")
+			confirm := f.Confirm
+			if confirm == nil {
+				confirm = PromptForConfirmation
+			}
+			if !confirm("  Do you want to proceed? [y/N]: ") {
+				return ErrAborted
 			}
 			fmt.Println()
 		}
 	}
 
-	// Load layer-specific ignore patterns and combine with project patterns
-	layerIgnorePatterns, err := f.loadLayerIgnorePatterns(layerPath)
-	if err != nil {
-		return fmt.Errorf("failed to load layer ignore patterns: %w", err)
-	}
-
-	// Combine project-level and layer-level ignore patterns
-	combinedPatterns := append(f.IgnorePatterns, layerIgnorePatterns...)
-
-	// CRITICAL: Always ignore these files/directories to prevent dangerous overwrites
-	criticalIgnorePatterns := []string{
-		".git",         // Never copy .git folder from layers (would overwrite project's git repo)
-		".git/",        // Directory pattern for .git
-		".otter",       // Never copy .otter cache folder from layers
-		".otter/",      // Directory pattern for .otter
-		".otterignore", // Never copy .otterignore files from layers
-		".gitignore",   // Never copy .gitignore files from layers (would overwrite project's git ignore rules)
-	}
-	combinedPatterns = append(combinedPatterns, criticalIgnorePatterns...)
-
-	return filepath.Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
+	written := len(f.Changes)
+	err = f.layerFS().Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -318,70 +659,213 @@ func (f *FileOperations) CopyLayer(layerPath, targetPath string, projectRoot str
 			return nil
 		}
 
-		// Check if this file should be ignored using combined patterns
-		if f.isIgnoredWithPatterns(relativePath, combinedPatterns) {
-			fmt.Printf("  Ignoring: %s\n", relativePath)
+		// Calculate destination path; ignore rules match where the file is in the layer, and MAP
+		// moves the files they keep
+		destPath := f.destinationPath(targetPath, relativePath)
+
+		// Check if this file should be ignored using combined and nested patterns
+		if rule := f.matchingIgnoreRule(relativePath, destPath, combinedRules); rule != nil {
+			f.printf("  Ignoring: %s (%s)\n", relativePath, rule)
+			f.Ignored = append(f.Ignored, IgnoredFile{Path: destPath, Pattern: rule.Pattern, Source: rule.Source})
 			if info.IsDir() {
 				return filepath.SkipDir
 			}
 			return nil
 		}
 
-		// Calculate destination path
-		destPath := filepath.Join(targetPath, relativePath)
-
 		if info.IsDir() {
 			// Create directory
-			return os.MkdirAll(destPath, info.Mode())
-		} else {
-			// Copy file with template processing if variables are provided
-			return f.copyFile(srcPath, destPath, info.Mode(), templateVars, delims)
+			return f.FS.MkdirAll(destPath, info.Mode())
 		}
+		if info.Name() == GitignoreFragmentName {
+			// Fragments are assembled into .gitignore once all layers have been copied
+			return f.collectGitignoreFragment(srcPath, destPath)
+		}
+
+		protected := f.isProtected(relativePath)
+		if protected {
+			// Only reachable when the layer ALLOWs this protected file
+			f.printf("  Warning: copying protected file %s (allowed by layer)\n", relativePath)
+		}
+
+		// Copy file with template processing if variables are provided
+		mappedPath := f.mappedPath(relativePath)
+		copied := len(f.Changes)
+		if err := f.copyFile(srcPath, destPath, mappedPath, info.Mode(), templateVars, delims); err != nil {
+			return err
+		}
+		if len(f.Changes) == copied {
+			// Left alone by the layer's strategy
+			return nil
+		}
+		f.Changes[len(f.Changes)-1].Protected = protected
+		f.Changes[len(f.Changes)-1].Hidden = hiddenRoot(mappedPath) != ""
+		return nil
 	})
+
+	// Dotfiles are easy to miss in a project, so name the ones the layer added
+	var hidden []string
+	for _, change := range f.Changes[written:] {
+		if change.Hidden {
+			if relativePath, relErr := filepath.Rel(targetPath, change.Path); relErr == nil {
+				hidden = append(hidden, relativePath)
+			}
+		}
+	}
+	if len(hidden) > 0 {
+		f.printf("  Hidden files written: %s\n", strings.Join(hidden, ", "))
+	}
+
+	return err
 }
 
-// copyFile copies a single file from src to dst with optional template processing
-func (f *FileOperations) copyFile(src, dst string, mode os.FileMode, templateVars map[string]string, delims [2]string) error {
-	// Check if destination file exists and prompt for overwrite
-	if _, err := os.Stat(dst); err == nil {
-		fmt.Printf("  Overwriting: %s\n", dst)
+// CopyLayerFS copies a layer read from an fs.FS, such as an embedded directory or a zip archive,
+// to the target directory. Otherwise it behaves like CopyLayer.
+func (f *FileOperations) CopyLayerFS(layer fs.FS, targetPath string, projectRoot string, templateVars map[string]string, delims [2]string, force bool) error {
+	f.layerSource = NewFSFileSystem(layer)
+	defer func() { f.layerSource = nil }()
+
+	return f.CopyLayer(".", targetPath, projectRoot, templateVars, delims, force)
+}
+
+// out returns the writer progress is printed to
+func (f *FileOperations) out() io.Writer {
+	if f.Output != nil {
+		return f.Output
+	}
+	return os.Stdout
+}
+
+// printf prints progress to the output of f
+func (f *FileOperations) printf(format string, args ...interface{}) {
+	fmt.Fprintf(f.out(), format, args...)
+}
+
+// layerFS returns the filesystem layers are read from
+func (f *FileOperations) layerFS() FileSystem {
+	if f.layerSource != nil {
+		return f.layerSource
+	}
+	return f.FS
+}
+
+// copyFile copies a single file from src to dst with optional template processing.
+// When dst exists, the layer's strategy decides whether it is overwritten, merged with a merge
+// driver handling relativePath, appended to, or left alone; files left alone aren't recorded as changes.
+func (f *FileOperations) copyFile(src, dst, relativePath string, mode os.FileMode, templateVars map[string]string, delims [2]string) error {
+	action := "create"
+	var driver MergeDriver
+	if _, err := f.FS.Stat(dst); err == nil {
+		action, driver = f.existingAction(relativePath, src)
+		switch action {
+		case "skip":
+			f.printf("  Skipping existing: %s\n", dst)
+			f.Ignored = append(f.Ignored, IgnoredFile{Path: dst, Pattern: "existing file", Source: strategySource + " " + f.Strategy})
+			return nil
+		case "merge":
+			f.printf("  Merging: %s (%s)\n", dst, driver.Name())
+		case "overwrite":
+			f.printf("  Overwriting: %s\n", dst)
+		}
 	} else {
-		fmt.Printf("  Creating: %s\n", dst)
+		f.printf("  Creating: %s\n", dst)
 	}
 
 	// Ensure destination directory exists
 	dstDir := filepath.Dir(dst)
-	if err := os.MkdirAll(dstDir, 0755); err != nil {
+	if err := f.FS.MkdirAll(dstDir, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
 	// Read the source file content
-	srcContent, err := os.ReadFile(src)
+	srcContent, err := f.layerFS().ReadFile(src)
 	if err != nil {
 		return fmt.Errorf("failed to read source file: %w", err)
 	}
 
 	var finalContent []byte
 
-	// Check if we have template variables and the file contains template syntax
-	if len(templateVars) > 0 && f.containsTemplateSyntax(string(srcContent), delims) {
+	// Process templates when the layer has template variables or the file is a template referring to
+	// facts about the project, developer, build, layer, or environment, or testing conditions
+	usesFacts := len(templateVars) == 0 && f.containsTemplateSyntax(string(srcContent), delims) &&
+		f.usesTemplateFacts(string(srcContent), delims)
+	if (len(templateVars) > 0 || usesFacts) && f.containsTemplateSyntax(string(srcContent), delims) {
 		// Process the file as a template
 		processedContent, err := f.processTemplate(string(srcContent), templateVars, src, delims)
 		if err != nil {
 			return fmt.Errorf("failed to process template %s: %w", src, err)
 		}
 		finalContent = []byte(processedContent)
-		fmt.Printf("  Template processed: %s\n", dst)
+		f.printf("  Template processed: %s\n", dst)
+
+		if f.templateUsage == nil {
+			f.templateUsage = make(map[string][]string)
+		}
+		f.templateUsage[dst] = templateVariables(string(srcContent), delims)
 	} else {
 		// Copy file as-is
 		finalContent = srcContent
 	}
 
+	// Look for credentials in what the layer provides, e.g. a key passed as a template value
+	var secret string
+	if f.SecretScan == SecretScanWarn || f.SecretScan == SecretScanFail {
+		if matches := findSecrets(finalContent); len(matches) > 0 {
+			if f.SecretScan == SecretScanFail {
+				return fmt.Errorf("%w: %s has %s", ErrSecretFound, dst, matches[0])
+			}
+			secret = matches[0].String()
+		}
+	}
+
+	if driver != nil {
+		existingContent, err := f.FS.ReadFile(dst)
+		if err != nil {
+			return fmt.Errorf("failed to read %s for merging: %w", dst, err)
+		}
+		merged, conflicts, err := driver.Merge(existingContent, finalContent)
+		if err != nil {
+			return fmt.Errorf("failed to merge %s: %w", dst, err)
+		}
+		for _, conflict := range conflicts {
+			f.printf("    Merge conflict: %s\n", conflict)
+		}
+		finalContent = merged
+	}
+	if action == "append" {
+		existingContent, err := f.FS.ReadFile(dst)
+		if err != nil {
+			return fmt.Errorf("failed to read %s for appending: %w", dst, err)
+		}
+		// Appending is idempotent: content already in the file isn't added again
+		if bytes.Contains(existingContent, finalContent) {
+			f.printf("  Already appended: %s\n", dst)
+			f.Ignored = append(f.Ignored, IgnoredFile{Path: dst, Pattern: "content already present", Source: strategySource + " " + f.Strategy})
+			return nil
+		}
+		f.printf("  Appending: %s\n", dst)
+		if len(existingContent) > 0 && !bytes.HasSuffix(existingContent, []byte("\n")) {
+			existingContent = append(existingContent, '\n')
+		}
+		finalContent = append(existingContent, finalContent...)
+	}
+
+	// Match the project's formatting conventions
+	if f.EditorConfig != nil {
+		finalContent = f.EditorConfig.Format(dst, finalContent)
+	}
+
 	// Write the final content to destination
-	if err := os.WriteFile(dst, finalContent, mode); err != nil {
+	if err := f.FS.WriteFile(dst, finalContent, mode); err != nil {
 		return fmt.Errorf("failed to write destination file: %w", err)
 	}
+	if _, onDisk := f.FS.(*OSFileSystem); f.PreserveAttributes && onDisk && f.layerSource == nil {
+		if err := copyAttributes(src, dst, f.out()); err != nil {
+			return err
+		}
+	}
 
+	f.Changes = append(f.Changes, FileChange{Path: dst, Action: action, Secret: secret})
 	return nil
 }
 
@@ -393,14 +877,36 @@ func (f *FileOperations) containsTemplateSyntax(content string, delims [2]string
 // processTemplate processes a template string with the provided variables and delimiters
 func (f *FileOperations) processTemplate(content string, templateVars map[string]string, filename string, delims [2]string) (string, error) {
 	// Create a new template with custom delimiters
-	tmpl, err := template.New(filepath.Base(filename)).Delims(delims[0], delims[1]).Parse(content)
+	tmpl, err := template.New(filepath.Base(filename)).Delims(delims[0], delims[1]).Funcs(f.templateFuncs()).Parse(content)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template: %w", err)
 	}
 
+	// Template variables are available by name, and facts as .Project, .User, .Build, .Layer, and
+	// .Env unless a variable shadows them
+	data := make(map[string]interface{}, len(templateVars)+5)
+	if f.Project != nil {
+		data["Project"] = f.Project
+	}
+	if f.User != nil {
+		data["User"] = f.User
+	}
+	if f.Build != nil {
+		data["Build"] = f.Build
+	}
+	if f.Layer != nil {
+		data["Layer"] = f.Layer
+	}
+	if f.Env != nil {
+		data["Env"] = f.Env
+	}
+	for key, value := range templateVars {
+		data[key] = value
+	}
+
 	// Execute the template with the variables
 	var buf bytes.Buffer
-	if err := tmpl.Execute(&buf, templateVars); err != nil {
+	if err := f.TemplateLimits.execute(tmpl, &buf, data); err != nil {
 		return "", fmt.Errorf("failed to execute template: %w", err)
 	}
 
@@ -0,0 +1,36 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileOperationsTakeChanges(t *testing.T) {
+	tempDir := t.TempDir()
+	layerDir := filepath.Join(tempDir, "layer")
+	targetDir := filepath.Join(tempDir, "target")
+
+	if err := os.MkdirAll(layerDir, 0755); err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(layerDir, "a.txt"), []byte("a"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+
+	fileOps := NewFileOperations()
+	for _, expected := range []string{"create", "overwrite"} {
+		if err := fileOps.CopyLayer(layerDir, targetDir, tempDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+			t.Fatalf("Failed to copy layer: %v", err)
+		}
+
+		changes := fileOps.TakeChanges()
+		if len(changes) != 1 || changes[0].Action != expected {
+			t.Errorf("Expected single %s change, got %+v", expected, changes)
+		}
+	}
+
+	if len(fileOps.TakeChanges()) != 0 {
+		t.Error("Expected changes to be reset after TakeChanges")
+	}
+}
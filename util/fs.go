@@ -0,0 +1,374 @@
+package util
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileSystem abstracts the filesystem operations used when applying layers so that
+// dry runs, staging, and unit tests can run against something other than the real disk
+type FileSystem interface {
+	Stat(name string) (os.FileInfo, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm os.FileMode) error
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	Walk(root string, fn filepath.WalkFunc) error
+}
+
+// OSFileSystem is a FileSystem backed by the operating system. Paths of any length are supported,
+// including paths beyond the Windows MAX_PATH limit.
+type OSFileSystem struct{}
+
+// NewOSFileSystem creates a FileSystem backed by the real disk
+func NewOSFileSystem() *OSFileSystem {
+	return &OSFileSystem{}
+}
+
+func (OSFileSystem) Stat(name string) (os.FileInfo, error) { return os.Stat(longPath(name)) }
+func (OSFileSystem) ReadFile(name string) ([]byte, error)  { return os.ReadFile(longPath(name)) }
+func (OSFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return os.MkdirAll(longPath(path), perm)
+}
+func (OSFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return writeFileAtomic(name, data, perm)
+}
+func (OSFileSystem) Remove(name string) error { return os.Remove(longPath(name)) }
+func (OSFileSystem) Rename(oldpath, newpath string) error {
+	return os.Rename(longPath(oldpath), longPath(newpath))
+}
+
+// Walk walks root in its extended-length form on Windows, so deeply nested files can be read, but
+// passes paths to fn under root as given, so callers can compute relative paths against it
+func (OSFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	walkRoot := extendedPath(root)
+	if walkRoot == root {
+		return filepath.Walk(root, fn)
+	}
+	return filepath.Walk(walkRoot, func(path string, info os.FileInfo, err error) error {
+		return fn(root+strings.TrimPrefix(path, walkRoot), info, err)
+	})
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of name, syncs it, and renames
+// it over name, so a write interrupted part way never leaves a truncated file. Like os.WriteFile,
+// an existing file keeps its permissions and a symlink is written through to its target.
+func writeFileAtomic(name string, data []byte, perm os.FileMode) (err error) {
+	if target, err := filepath.EvalSymlinks(name); err == nil {
+		name = target
+	}
+	if info, err := os.Stat(longPath(name)); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	tmp, err := os.CreateTemp(extendedPath(filepath.Dir(name)), "."+filepath.Base(name)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	if _, err = tmp.Write(data); err != nil {
+		return err
+	}
+	if err = tmp.Sync(); err != nil {
+		return err
+	}
+	if err = tmp.Chmod(perm); err != nil {
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), longPath(name))
+}
+
+// MemFileSystem is an in-memory FileSystem for tests and dry runs
+type MemFileSystem struct {
+	mu      sync.RWMutex
+	entries map[string]*memEntry
+}
+
+// memEntry is a file or directory stored in a MemFileSystem
+type memEntry struct {
+	name    string
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (e *memEntry) Name() string       { return e.name }
+func (e *memEntry) Size() int64        { return int64(len(e.data)) }
+func (e *memEntry) Mode() os.FileMode  { return e.mode }
+func (e *memEntry) ModTime() time.Time { return e.modTime }
+func (e *memEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *memEntry) Sys() interface{}   { return nil }
+
+// NewMemFileSystem creates an empty in-memory FileSystem
+func NewMemFileSystem() *MemFileSystem {
+	return &MemFileSystem{
+		entries: make(map[string]*memEntry),
+	}
+}
+
+func (m *MemFileSystem) Stat(name string) (os.FileInfo, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if entry, ok := m.entries[filepath.Clean(name)]; ok {
+		return entry, nil
+	}
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (m *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	entry, ok := m.entries[filepath.Clean(name)]
+	if !ok {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+	}
+	return append([]byte(nil), entry.data...), nil
+}
+
+func (m *MemFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if parent, ok := m.entries[filepath.Dir(name)]; !ok || !parent.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if entry, ok := m.entries[name]; ok && entry.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+
+	m.entries[name] = &memEntry{
+		name:    filepath.Base(name),
+		data:    append([]byte(nil), data...),
+		mode:    perm.Perm(),
+		modTime: time.Now(),
+	}
+	return nil
+}
+
+func (m *MemFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if entry, ok := m.entries[dir]; ok {
+			if !entry.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+		} else {
+			m.entries[dir] = &memEntry{name: filepath.Base(dir), mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+		}
+
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+func (m *MemFileSystem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if _, ok := m.entries[name]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	prefix := name + string(filepath.Separator)
+	for path := range m.entries {
+		if strings.HasPrefix(path, prefix) {
+			return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+		}
+	}
+
+	delete(m.entries, name)
+	return nil
+}
+
+func (m *MemFileSystem) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	entry, ok := m.entries[oldpath]
+	if !ok {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+
+	prefix := oldpath + string(filepath.Separator)
+	for path, child := range m.entries {
+		if strings.HasPrefix(path, prefix) {
+			delete(m.entries, path)
+			m.entries[newpath+string(filepath.Separator)+strings.TrimPrefix(path, prefix)] = child
+		}
+	}
+
+	delete(m.entries, oldpath)
+	entry.name = filepath.Base(newpath)
+	m.entries[newpath] = entry
+	return nil
+}
+
+// Walk visits entries in lexical order like filepath.Walk
+func (m *MemFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	root = filepath.Clean(root)
+	info, err := m.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = m.walk(root, info, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (m *MemFileSystem) walk(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+
+	if err := fn(path, info, nil); err != nil {
+		return err
+	}
+
+	for _, child := range m.children(path) {
+		childPath := filepath.Join(path, child.Name())
+		if err := m.walk(childPath, child, fn); err != nil {
+			if err == filepath.SkipDir {
+				if child.IsDir() {
+					continue
+				}
+				// SkipDir from a file skips the rest of its directory
+				return nil
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// children returns the direct children of a directory sorted by name
+func (m *MemFileSystem) children(dir string) []os.FileInfo {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var children []os.FileInfo
+	for path, entry := range m.entries {
+		if path != dir && filepath.Dir(path) == dir {
+			children = append(children, entry)
+		}
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	return children
+}
+
+// fsFileSystem is a read-only FileSystem backed by an fs.FS, for reading layers that don't live on disk
+type fsFileSystem struct {
+	fsys fs.FS
+}
+
+// NewFSFileSystem creates a read-only FileSystem over an fs.FS, such as an embedded directory,
+// fstest.MapFS, or a zip archive. Paths are relative to the root of fsys; writes fail.
+func NewFSFileSystem(fsys fs.FS) FileSystem {
+	return &fsFileSystem{fsys: fsys}
+}
+
+// name converts a path to the slash-separated, unrooted form fs.FS expects
+func (m *fsFileSystem) name(path string) string {
+	name := strings.TrimPrefix(filepath.ToSlash(filepath.Clean(path)), "/")
+	if name == "" {
+		return "."
+	}
+	return name
+}
+
+func (m *fsFileSystem) Stat(name string) (os.FileInfo, error) {
+	info, err := fs.Stat(m.fsys, m.name(name))
+	if err != nil {
+		return nil, err
+	}
+	return fsFileInfo{info}, nil
+}
+
+func (m *fsFileSystem) ReadFile(name string) ([]byte, error) {
+	return fs.ReadFile(m.fsys, m.name(name))
+}
+
+func (m *fsFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	return &fs.PathError{Op: "write", Path: name, Err: fs.ErrPermission}
+}
+
+func (m *fsFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	return &fs.PathError{Op: "mkdir", Path: path, Err: fs.ErrPermission}
+}
+
+func (m *fsFileSystem) Remove(name string) error {
+	return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrPermission}
+}
+
+func (m *fsFileSystem) Rename(oldpath, newpath string) error {
+	return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrPermission}
+}
+
+// Walk visits entries in lexical order like filepath.Walk, passing paths joined to root
+func (m *fsFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	base := m.name(root)
+	return fs.WalkDir(m.fsys, base, func(name string, d fs.DirEntry, err error) error {
+		relativePath := name
+		if base != "." {
+			relativePath = strings.TrimPrefix(strings.TrimPrefix(name, base), "/")
+		}
+		path := filepath.Join(root, filepath.FromSlash(relativePath))
+		if err != nil {
+			return fn(path, nil, err)
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return fn(path, nil, err)
+		}
+		return fn(path, fsFileInfo{info}, nil)
+	})
+}
+
+// fsFileInfo gives entries without permission bits, as fstest.MapFS files often are, the usual
+// permissions so copied files remain readable
+type fsFileInfo struct {
+	fs.FileInfo
+}
+
+func (i fsFileInfo) Mode() os.FileMode {
+	mode := i.FileInfo.Mode()
+	if mode.Perm() != 0 {
+		return mode
+	}
+	if mode.IsDir() {
+		return mode | 0755
+	}
+	return mode | 0644
+}
@@ -0,0 +1,209 @@
+package util
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMemFileSystem(t *testing.T) {
+	fsys := NewMemFileSystem()
+
+	if err := fsys.WriteFile("/project/a.txt", []byte("a"), 0644); err == nil {
+		t.Error("Expected error writing into a missing directory")
+	}
+
+	if err := fsys.MkdirAll("/project/sub", 0755); err != nil {
+		t.Fatalf("Failed to create directories: %v", err)
+	}
+	if err := fsys.WriteFile("/project/sub/b.txt", []byte("b"), 0600); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+	if err := fsys.WriteFile("/project/a.txt", []byte("a"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	info, err := fsys.Stat("/project/sub/b.txt")
+	if err != nil {
+		t.Fatalf("Failed to stat file: %v", err)
+	}
+	if info.IsDir() || info.Size() != 1 || info.Mode().Perm() != 0600 {
+		t.Errorf("Unexpected file info: dir=%v size=%d mode=%v", info.IsDir(), info.Size(), info.Mode())
+	}
+
+	if _, err := fsys.Stat("/project/missing"); !os.IsNotExist(err) {
+		t.Errorf("Expected not-exist error, got %v", err)
+	}
+
+	var visited []string
+	err = fsys.Walk("/project", func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		visited = append(visited, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk failed: %v", err)
+	}
+	expected := []string{"/project", "/project/a.txt", "/project/sub", "/project/sub/b.txt"}
+	if !reflect.DeepEqual(visited, expected) {
+		t.Errorf("Expected walk order %v, got %v", expected, visited)
+	}
+
+	if err := fsys.Rename("/project/sub", "/project/moved"); err != nil {
+		t.Fatalf("Failed to rename: %v", err)
+	}
+	if content, err := fsys.ReadFile("/project/moved/b.txt"); err != nil || string(content) != "b" {
+		t.Errorf("Expected renamed file content, got %q (%v)", content, err)
+	}
+
+	if err := fsys.Remove("/project/moved"); err == nil {
+		t.Error("Expected error removing a non-empty directory")
+	}
+	if err := fsys.Remove("/project/moved/b.txt"); err != nil {
+		t.Errorf("Failed to remove file: %v", err)
+	}
+}
+
+func TestCopyLayerInMemory(t *testing.T) {
+	fsys := NewMemFileSystem()
+	layerFiles := map[string]string{
+		"/layer/README.md":      "# {{.name}}",
+		"/layer/config/app.yml": "name: app",
+		"/layer/.gitignore":     "bin/",
+		"/layer/debug.log":      "noise",
+	}
+	for path, content := range layerFiles {
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+	if err := fsys.MkdirAll("/project", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	if err := fsys.WriteFile("/project/.otterignore", []byte("*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .otterignore: %v", err)
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	if err := fileOps.LoadIgnorePatterns("/project"); err != nil {
+		t.Fatalf("Failed to load ignore patterns: %v", err)
+	}
+
+	err := fileOps.CopyLayer("/layer", "/project", "/project", map[string]string{"name": "demo"}, [2]string{"{{", "}}"}, true)
+	if err != nil {
+		t.Fatalf("Failed to copy layer: %v", err)
+	}
+
+	if content, err := fsys.ReadFile("/project/README.md"); err != nil || string(content) != "# demo" {
+		t.Errorf("Expected rendered README, got %q (%v)", content, err)
+	}
+	if _, err := fsys.Stat("/project/config/app.yml"); err != nil {
+		t.Errorf("Expected nested file to be copied: %v", err)
+	}
+	for _, ignored := range []string{"/project/.gitignore", "/project/debug.log"} {
+		if _, err := fsys.Stat(ignored); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be ignored", ignored)
+		}
+	}
+}
+
+func TestCopyLayerFS(t *testing.T) {
+	layer := fstest.MapFS{
+		"README.md":           {Data: []byte("# {{.name}}")},
+		"config/app.yml":      {Data: []byte("name: app")},
+		"scripts/run.sh":      {Data: []byte("#!/bin/sh"), Mode: 0755},
+		"notes.txt":           {Data: []byte("skip me")},
+		".otterignore":        {Data: []byte("notes.txt\n")},
+		".gitignore":          {Data: []byte("bin/")},
+		".gitignore.fragment": {Data: []byte("dist/\n")},
+	}
+
+	fsys := NewMemFileSystem()
+	fileOps := NewFileOperationsWithFS(fsys)
+	err := fileOps.CopyLayerFS(layer, "/project", "/project", map[string]string{"name": "demo"}, [2]string{"{{", "}}"}, true)
+	if err != nil {
+		t.Fatalf("Failed to copy layer: %v", err)
+	}
+
+	if content, err := fsys.ReadFile("/project/README.md"); err != nil || string(content) != "# demo" {
+		t.Errorf("Expected rendered README, got %q (%v)", content, err)
+	}
+	if info, err := fsys.Stat("/project/config/app.yml"); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("Expected nested file with default permissions, got %v (%v)", info, err)
+	}
+	if info, err := fsys.Stat("/project/scripts/run.sh"); err != nil || info.Mode().Perm() != 0755 {
+		t.Errorf("Expected executable to keep its permissions, got %v (%v)", info, err)
+	}
+	for _, ignored := range []string{"/project/notes.txt", "/project/.gitignore", "/project/.otterignore"} {
+		if _, err := fsys.Stat(ignored); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be ignored", ignored)
+		}
+	}
+	if rules := fileOps.gitignoreRules["/project/.gitignore"]; !reflect.DeepEqual(rules, []string{"dist/"}) {
+		t.Errorf("Expected fragment rules to be collected, got %v", rules)
+	}
+
+	// Layers read from an fs.FS can't be written to
+	if err := NewFSFileSystem(layer).WriteFile("README.md", nil, 0644); !errors.Is(err, fs.ErrPermission) {
+		t.Errorf("Expected writes to fail with a permission error, got %v", err)
+	}
+}
+
+func TestOSFileSystemWriteFileAtomic(t *testing.T) {
+	dir := t.TempDir()
+	fsys := NewOSFileSystem()
+	path := filepath.Join(dir, "config.yml")
+
+	if err := fsys.WriteFile(path, []byte("v1"), 0600); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+	if err := fsys.WriteFile(path, []byte("v2"), 0644); err != nil {
+		t.Fatalf("WriteFile failed: %v", err)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil || string(content) != "v2" {
+		t.Fatalf("content = %q, %v, want v2", content, err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("existing file mode = %v, want 0600 kept", info.Mode().Perm())
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected no temporary files left behind, got %d entries", len(entries))
+	}
+
+	// Writing to a symlink replaces the content of its target rather than the link
+	target := filepath.Join(dir, "target.txt")
+	link := filepath.Join(dir, "link.txt")
+	os.WriteFile(target, []byte("old"), 0644)
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+	if err := fsys.WriteFile(link, []byte("new"), 0644); err != nil {
+		t.Fatalf("WriteFile through symlink failed: %v", err)
+	}
+	if info, err := os.Lstat(link); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("expected %s to remain a symlink", link)
+	}
+	if content, _ := os.ReadFile(target); string(content) != "new" {
+		t.Errorf("target content = %q, want new", content)
+	}
+}
@@ -0,0 +1,26 @@
+package util
+
+import (
+	"fmt"
+	"os"
+)
+
+// RunGenerator runs the commands of a generator layer inside the fetched repository and returns
+// the temporary directory holding their output. The directory is available to the commands as
+// $OTTER_OUTPUT_DIR; the caller removes it once the output has been copied.
+func RunGenerator(commands []string, layerPath string) (string, error) {
+	outputDir, err := os.MkdirTemp("", "otter-generate-")
+	if err != nil {
+		return "", fmt.Errorf("failed to create generator output directory: %w", err)
+	}
+
+	executor := NewCommandExecutor(layerPath)
+	executor.Env = []string{"OTTER_OUTPUT_DIR=" + outputDir, "OTTER_LAYER_PATH=" + layerPath}
+
+	if err := executor.ExecuteCommands(commands, "generator"); err != nil {
+		os.RemoveAll(outputDir)
+		return "", err
+	}
+
+	return outputDir, nil
+}
@@ -0,0 +1,35 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRunGenerator(t *testing.T) {
+	layerPath := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerPath, "spec.txt"), []byte("users"), 0644); err != nil {
+		t.Fatalf("Failed to write spec: %v", err)
+	}
+
+	outputDir, err := RunGenerator([]string{
+		`mkdir -p "$OTTER_OUTPUT_DIR/client"`,
+		`echo "client for $(cat spec.txt)" > "$OTTER_OUTPUT_DIR/client/api.txt"`,
+	}, layerPath)
+	if err != nil {
+		t.Fatalf("RunGenerator failed: %v", err)
+	}
+	defer os.RemoveAll(outputDir)
+
+	content, err := os.ReadFile(filepath.Join(outputDir, "client", "api.txt"))
+	if err != nil {
+		t.Fatalf("Expected generated file: %v", err)
+	}
+	if string(content) != "client for users\n" {
+		t.Errorf("Unexpected generated content: %q", content)
+	}
+
+	if _, err := RunGenerator([]string{`touch "$OTTER_OUTPUT_DIR/partial"`, "exit 3"}, layerPath); err == nil {
+		t.Error("Expected error from failing generator")
+	}
+}
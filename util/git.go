@@ -2,18 +2,45 @@ package util
 
 import (
 	"crypto/sha256"
+	"errors"
 	"fmt"
+	"io"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/memory"
 )
 
 // GitOperations handles all git-related operations
 type GitOperations struct {
 	cacheDir string
+	// Metrics records the time and cache use of fetching remote layers; nil records nothing
+	Metrics *Metrics
+	// Limiter bounds how many remote layers are fetched from each host at once and how quickly;
+	// nil fetches without limits
+	Limiter *HostLimiter
+	// Output receives progress; stdout when nil
+	Output io.Writer
+	// FailOnForcePush fails updating a layer whose branch was force-pushed, instead of resetting
+	// the cache to it
+	FailOnForcePush bool
+	// Cache restores remote layers missing from the cache directory and stores the ones cloned or
+	// updated; nil keeps them only in the cache directory
+	Cache CacheBackend
+	// Branches are the branches remote layers follow instead of their default branch, by repository,
+	// from their BRANCH clauses
+	Branches map[string]string
+	// DefaultBranch is cloned from remotes that don't say which branch is their default, when they have it
+	DefaultBranch string
 }
 
 // NewGitOperations creates a new GitOperations instance
@@ -24,8 +51,12 @@ func NewGitOperations(cacheDir string) *GitOperations {
 }
 
 // CloneOrUpdateLayer clones a git repository to the cache directory, updates it if it already exists,
-// or returns the path directly for local layers
+// returns the path directly for local layers, or writes built-in layers to the cache
 func (g *GitOperations) CloneOrUpdateLayer(repoURL string) (string, error) {
+	if isBuiltinLayer(repoURL) {
+		return g.handleBuiltinLayer(repoURL)
+	}
+
 	// Check if this is a local layer
 	if g.isLocalLayer(repoURL) {
 		return g.handleLocalLayer(repoURL)
@@ -35,6 +66,35 @@ func (g *GitOperations) CloneOrUpdateLayer(repoURL string) (string, error) {
 	return g.handleRemoteRepository(repoURL)
 }
 
+// FetchResult is where FetchLayers put a layer, or why fetching it failed
+type FetchResult struct {
+	Path string
+	Err  error
+}
+
+// FetchLayers clones or updates layers concurrently, within the limits of their hosts, and returns
+// the result of each in order. Each layer prints to its own stream of a LogMultiplexer in
+// logOutput mode, so their progress doesn't interleave.
+func (g *GitOperations) FetchLayers(repositories []string, logOutput string) []FetchResult {
+	output := NewLogMultiplexer(g.out(), logOutput, repositories)
+	defer output.Flush()
+
+	results := make([]FetchResult, len(repositories))
+	var wg sync.WaitGroup
+	for i, repoURL := range repositories {
+		wg.Add(1)
+		go func(i int, repoURL string) {
+			defer wg.Done()
+			fork := *g
+			fork.Output = output.Stream(i)
+			results[i].Path, results[i].Err = fork.CloneOrUpdateLayer(repoURL)
+			output.Close(i)
+		}(i, repoURL)
+	}
+	wg.Wait()
+	return results
+}
+
 // isLocalLayer checks if the repository URL refers to a local directory
 func (g *GitOperations) isLocalLayer(repoURL string) bool {
 	// Check for relative paths
@@ -57,22 +117,44 @@ func (g *GitOperations) isLocalLayer(repoURL string) bool {
 		return true
 	}
 
+	// Check for Windows UNC paths (\\server\share)
+	if strings.HasPrefix(repoURL, `\\`) {
+		return true
+	}
+
 	return false
 }
 
+// IsRemoteLayer reports whether a layer is fetched from a git remote, and so is pinned in the lockfile
+func (g *GitOperations) IsRemoteLayer(repoURL string) bool {
+	return !isBuiltinLayer(repoURL) && !g.isLocalLayer(repoURL)
+}
+
 // handleLocalLayer processes a local directory layer
 func (g *GitOperations) handleLocalLayer(repoURL string) (string, error) {
+	localPath, err := localLayerPath(repoURL)
+	if err != nil {
+		return "", err
+	}
+
+	g.printf("Using local layer: %s\n", localPath)
+	return localPath, nil
+}
+
+// localLayerPath resolves the directory of a local layer, which must exist
+func localLayerPath(repoURL string) (string, error) {
 	var localPath string
 
 	// Handle file:// URI scheme
 	if strings.HasPrefix(repoURL, "file://") {
-		parsedURL, err := url.Parse(repoURL)
+		path, err := fileURIPath(repoURL, runtime.GOOS)
 		if err != nil {
-			return "", fmt.Errorf("failed to parse file:// URL %s: %w", repoURL, err)
+			return "", err
 		}
-		localPath = parsedURL.Path
+		localPath = path
 	} else {
-		localPath = repoURL
+		// Paths are kept in their usual form; long paths are extended where files are accessed
+		localPath = shortPath(repoURL)
 	}
 
 	// Convert to absolute path if it's relative
@@ -99,41 +181,257 @@ func (g *GitOperations) handleLocalLayer(repoURL string) (string, error) {
 		return "", fmt.Errorf("local layer path is not a directory: %s", localPath)
 	}
 
-	fmt.Printf("Using local layer: %s\n", localPath)
 	return localPath, nil
 }
 
-// handleRemoteRepository processes a remote git repository (existing logic)
-func (g *GitOperations) handleRemoteRepository(repoURL string) (string, error) {
+// CachedLayerPath returns where the files of a layer are without fetching it: the directory of a
+// local layer, or the cached copy of a built-in or remote one. It returns "" for a remote layer
+// that hasn't been fetched yet.
+func (g *GitOperations) CachedLayerPath(repoURL string) (string, error) {
+	if isBuiltinLayer(repoURL) {
+		return g.writeBuiltinLayer(repoURL)
+	}
+	if g.isLocalLayer(repoURL) {
+		return localLayerPath(repoURL)
+	}
+
+	localPath := filepath.Join(g.cacheDir, g.GetRepoDirectoryName(repoURL))
+	if _, err := os.Stat(filepath.Join(localPath, ".git")); err != nil {
+		return "", nil
+	}
+	return localPath, nil
+}
+
+// fileURIPath decodes a file:// URI into a local path for the given operating system, following
+// RFC 8089. On Windows, file:///C:/dir becomes C:\dir and file://server/share/dir (or the
+// file:////server/share/dir form) becomes the UNC path \\server\share\dir. Elsewhere only local
+// URIs, without a host or with localhost, are supported.
+func fileURIPath(uri, goos string) (string, error) {
+	parsedURL, err := url.Parse(uri)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse file:// URL %s: %w", uri, err)
+	}
+	host, path := parsedURL.Host, parsedURL.Path
+	if strings.EqualFold(host, "localhost") {
+		host = ""
+	}
+
+	if goos != "windows" {
+		if host != "" {
+			return "", fmt.Errorf("file:// URL %s refers to host %s; only local files are supported", uri, host)
+		}
+		return path, nil
+	}
+
+	switch {
+	case isDriveLetter(host):
+		// file://C:/dir, a common misspelling of file:///C:/dir
+		path = host + path
+	case host != "":
+		path = "//" + host + path
+	case len(path) >= 3 && path[0] == '/' && isDriveLetter(path[1:3]):
+		path = path[1:]
+	}
+	return strings.ReplaceAll(path, "/", `\`), nil
+}
+
+// isDriveLetter reports whether s is a Windows drive such as C:
+func isDriveLetter(s string) bool {
+	return len(s) == 2 && s[1] == ':' && ('a' <= s[0] && s[0] <= 'z' || 'A' <= s[0] && s[0] <= 'Z')
+}
+
+// SplitLayerRef splits the git ref a remote layer is pinned to off its repository, as in
+// git@github.com:org/repo.git@v1.2.0. The ref is a branch, tag, or commit SHA, and is empty when
+// the layer tracks the default branch. Local and built-in layers are never pinned.
+func SplitLayerRef(repoURL string) (string, string) {
+	if isBuiltinLayer(repoURL) || (&GitOperations{}).isLocalLayer(repoURL) {
+		return repoURL, ""
+	}
+	return splitRemoteRef(repoURL)
+}
+
+// splitRemoteRef splits a repository URL at the first @ in its path, after any user name and host
+func splitRemoteRef(repoURL string) (string, string) {
+	pathStart := 0
+	if _, rest, found := strings.Cut(repoURL, "://"); found {
+		slash := strings.Index(rest, "/")
+		if slash < 0 {
+			return repoURL, ""
+		}
+		pathStart = len(repoURL) - len(rest) + slash
+	} else if colon := strings.Index(repoURL, ":"); colon >= 0 {
+		// scp-like syntax, e.g. git@github.com:org/repo.git
+		pathStart = colon
+	}
+
+	at := strings.Index(repoURL[pathStart:], "@")
+	if at < 0 {
+		return repoURL, ""
+	}
+	return repoURL[:pathStart+at], repoURL[pathStart+at+1:]
+}
+
+// handleRemoteRepository processes a remote git repository, checking out the ref it is pinned to
+// if any. Each ref gets a clone of its own.
+func (g *GitOperations) handleRemoteRepository(repoURL string) (_ string, err error) {
 	// Create a unique directory name based on the repository URL
 	repoName := g.GetRepoDirectoryName(repoURL)
 	localPath := filepath.Join(g.cacheDir, repoName)
+	url, ref := splitRemoteRef(repoURL)
+	branch := g.Branches[repoURL]
+
+	// Waiting for the host's limits isn't counted in the fetch duration
+	release := g.Limiter.Acquire(LayerHost(repoURL))
+	defer release()
+
+	start := time.Now()
+	_, statErr := os.Stat(filepath.Join(localPath, ".git"))
+	cached := statErr == nil || g.restoreLayer(repoURL, repoName, localPath)
+	var before string
+	if cached {
+		before, _ = g.GetRepositoryCommit(localPath)
+	}
+	defer func() {
+		if err == nil {
+			g.storeLayer(repoName, localPath, before)
+		}
+	}()
+	defer func() { g.recordFetch(repoURL, cached, time.Since(start), err) }()
 
 	// Check if repository already exists
-	if _, err := os.Stat(filepath.Join(localPath, ".git")); err == nil {
+	if cached {
 		// Repository exists, try to update it
-		fmt.Printf("Updating layer: %s\n", repoURL)
-		return localPath, g.updateRepository(localPath)
+		g.printf("Updating layer: %s\n", repoURL)
+		if ref != "" {
+			return localPath, g.checkoutRef(localPath, ref, true)
+		}
+		return localPath, g.updateRepository(localPath, branch)
 	}
 
 	// Repository doesn't exist, clone it
-	fmt.Printf("Cloning layer: %s\n", repoURL)
-	return localPath, g.cloneRepository(repoURL, localPath)
+	g.printf("Cloning layer: %s\n", repoURL)
+	if err := g.cloneRepository(url, localPath, branch); err != nil {
+		return localPath, err
+	}
+	if ref != "" {
+		return localPath, g.checkoutRef(localPath, ref, false)
+	}
+	return localPath, nil
+}
+
+// restoreLayer restores a layer missing from the cache directory from g.Cache and checks out its
+// files, reporting whether it did. A layer that can't be restored is reported and cloned instead.
+func (g *GitOperations) restoreLayer(repoURL, repoName, localPath string) bool {
+	if g.Cache == nil {
+		return false
+	}
+
+	// Whatever is left of the layer without its git directory is replaced
+	os.RemoveAll(localPath)
+	found, err := g.Cache.Load(repoName, filepath.Join(localPath, ".git"))
+	if err == nil && found {
+		err = resetWorktree(localPath)
+	}
+	if err != nil {
+		g.printf("Couldn't restore layer %s from cache %s: %v\n", repoURL, g.Cache, err)
+		os.RemoveAll(localPath)
+		return false
+	}
+	if found {
+		g.printf("Restored layer %s from cache %s\n", repoURL, g.Cache)
+	}
+	return found
+}
+
+// storeLayer stores the git directory of a remote layer in g.Cache when its commit is no longer
+// before, the commit it was at before fetching. Failing to store it is reported, not an error.
+func (g *GitOperations) storeLayer(repoName, localPath, before string) {
+	if g.Cache == nil {
+		return
+	}
+	if after, err := g.GetRepositoryCommit(localPath); err != nil || after == before {
+		return
+	}
+	if err := g.Cache.Store(repoName, filepath.Join(localPath, ".git")); err != nil {
+		g.printf("  Couldn't store the layer in cache %s: %v\n", g.Cache, err)
+		return
+	}
+	g.printf("  Stored in cache %s\n", g.Cache)
+}
+
+// resetWorktree checks out the files of the commit a repository is at, such as after its git
+// directory was restored on its own
+func resetWorktree(localPath string) error {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %w", localPath, err)
+	}
+	head, err := repo.Head()
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD reference: %w", err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: head.Hash(), Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", head.Hash().String()[:7], err)
+	}
+	return nil
+}
+
+// out returns the writer progress is printed to
+func (g *GitOperations) out() io.Writer {
+	if g.Output != nil {
+		return g.Output
+	}
+	return os.Stdout
+}
+
+// printf prints progress to the output of g
+func (g *GitOperations) printf(format string, args ...interface{}) {
+	fmt.Fprintf(g.out(), format, args...)
+}
+
+// recordFetch records the duration of fetching a remote layer, whether its cache was used, and
+// whether the fetch failed, by host
+func (g *GitOperations) recordFetch(repoURL string, cached bool, duration time.Duration, err error) {
+	host := LayerHost(repoURL)
+	cache := "miss"
+	if cached {
+		cache = "hit"
+	}
+	g.Metrics.Count("otter_layer_fetches_total", "host", host, "cache", cache)
+	g.Metrics.Observe("otter_layer_fetch_duration_seconds", duration, "host", host)
+	if err != nil {
+		g.Metrics.Count("otter_layer_fetch_failures_total", "host", host)
+	}
 }
 
-// cloneRepository clones a git repository to the specified path
-func (g *GitOperations) cloneRepository(repoURL, localPath string) error {
+// cloneRepository clones a git repository to the specified path, checking out branch, or the
+// default branch of the remote when branch is empty
+func (g *GitOperations) cloneRepository(repoURL, localPath, branch string) error {
 	// Ensure the cache directory exists
 	if err := os.MkdirAll(g.cacheDir, 0755); err != nil {
 		return fmt.Errorf("failed to create cache directory: %w", err)
 	}
 
 	// Clone the repository
-	_, err := git.PlainClone(localPath, false, &git.CloneOptions{
-		URL:      repoURL,
-		Progress: os.Stdout,
-	})
+	err := g.clone(repoURL, localPath, branch)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) && branch == "" {
+		// Older servers don't advertise HEAD when it names a branch the repository doesn't have, e.g.
+		// master in a repository whose only branch is trunk, so the default branch has to be found
+		os.RemoveAll(localPath)
+		if branch, err = g.remoteDefaultBranch(repoURL); err != nil {
+			return err
+		}
+		g.printf("  Remote doesn't name its default branch; cloning %s\n", branch)
+		err = g.clone(repoURL, localPath, branch)
+	}
 
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return fmt.Errorf("failed to clone repository %s: branch %s not found", repoURL, branch)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to clone repository %s: %w", repoURL, err)
 	}
@@ -141,42 +439,183 @@ func (g *GitOperations) cloneRepository(repoURL, localPath string) error {
 	return nil
 }
 
-// updateRepository updates an existing git repository
-func (g *GitOperations) updateRepository(localPath string) error {
+// clone clones a repository checking out branch, or the branch HEAD names when it is empty
+func (g *GitOperations) clone(repoURL, localPath, branch string) error {
+	options := &git.CloneOptions{
+		URL:      repoURL,
+		Progress: g.out(),
+	}
+	if branch != "" {
+		options.ReferenceName = plumbing.NewBranchReferenceName(branch)
+	}
+	_, err := git.PlainClone(localPath, false, options)
+	return err
+}
+
+// defaultBranchNames are the usual names of a default branch, tried in order after DefaultBranch
+var defaultBranchNames = []string{"main", "master", "trunk"}
+
+// remoteDefaultBranch picks the branch to clone from a remote that doesn't say which branch is its
+// default: DefaultBranch, main, master, or trunk, the first of them the remote has, or else its only branch
+func (g *GitOperations) remoteDefaultBranch(repoURL string) (string, error) {
+	remote := git.NewRemote(memory.NewStorage(), &config.RemoteConfig{Name: "origin", URLs: []string{repoURL}})
+	refs, err := remote.List(&git.ListOptions{})
+	if err != nil {
+		return "", fmt.Errorf("failed to list branches of %s: %w", repoURL, err)
+	}
+
+	branches := make(map[string]bool)
+	var names []string
+	for _, ref := range refs {
+		if ref.Name().IsBranch() {
+			branches[ref.Name().Short()] = true
+			names = append(names, ref.Name().Short())
+		}
+	}
+	for _, name := range append([]string{g.DefaultBranch}, defaultBranchNames...) {
+		if name != "" && branches[name] {
+			return name, nil
+		}
+	}
+	switch len(names) {
+	case 0:
+		return "", fmt.Errorf("repository %s has no branches", repoURL)
+	case 1:
+		return names[0], nil
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("repository %s doesn't name its default branch and has several (%s); set BRANCH on the layer or fetch.default_branch",
+		repoURL, strings.Join(names, ", "))
+}
+
+// updateRepository updates an existing git repository to the latest commit of the branch it follows,
+// or of branch when it isn't empty, returning to the branch when a pinned commit left HEAD detached,
+// and resetting to the remote when the branch was force-pushed, unless FailOnForcePush is set
+func (g *GitOperations) updateRepository(localPath, branch string) error {
 	// Open the existing repository
 	repo, err := git.PlainOpen(localPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository at %s: %w", localPath, err)
 	}
 
-	// Get the working tree
-	worktree, err := repo.Worktree()
+	err = repo.Fetch(&git.FetchOptions{RemoteName: "origin", Force: true, Progress: g.out()})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("failed to fetch updates: %w", err)
+	}
+
+	// A layer whose BRANCH changed switches to it, whatever the cache followed before
+	tracked, head, err := trackedBranch(repo)
+	if err != nil && branch == "" {
+		return err
+	}
+	switching := branch != "" && branch != tracked
+	if branch == "" {
+		branch = tracked
+	}
+	remote, err := repo.Reference(plumbing.NewRemoteReferenceName("origin", branch), true)
 	if err != nil {
-		return fmt.Errorf("failed to get worktree: %w", err)
+		return fmt.Errorf("branch %s not found on origin: %w", branch, err)
 	}
+	latest := remote.Hash()
 
-	// Pull the latest changes
-	err = worktree.Pull(&git.PullOptions{
-		RemoteName: "origin",
-		Progress:   os.Stdout,
-	})
+	local := plumbing.ZeroHash
+	if ref, err := repo.Reference(plumbing.NewBranchReferenceName(branch), true); err == nil {
+		local = ref.Hash()
+	}
+	detached := head != nil
 
-	// If the error is "already up-to-date", that's fine
-	if err != nil && err != git.NoErrAlreadyUpToDate {
-		return fmt.Errorf("failed to pull updates: %w", err)
+	if local == latest && !detached && !switching {
+		g.printf("  Already up-to-date\n")
+		return nil
 	}
 
-	if err == git.NoErrAlreadyUpToDate {
-		fmt.Println("  Already up-to-date")
+	// A branch that no longer contains what was fetched before was force-pushed
+	if local != latest && !local.IsZero() && !isAncestor(repo, local, latest) {
+		if g.FailOnForcePush {
+			return fmt.Errorf("origin/%s was force-pushed and no longer contains %s; delete %s to fetch the layer again, or set fetch.force_pushed to reset", branch, local.String()[:7], localPath)
+		}
+		g.printf("  origin/%s was force-pushed; resetting from %s to %s\n", branch, local.String()[:7], latest.String()[:7])
+	}
+	switch {
+	case switching && tracked != "":
+		g.printf("  Switching from branch %s to %s\n", tracked, branch)
+	case switching:
+		g.printf("  Switching to branch %s\n", branch)
+	case detached:
+		g.printf("  Cache was detached at %s; returning to %s\n", head.String()[:7], branch)
 	}
 
+	// Reset the branch to the remote and check it out, discarding whatever the cache had
+	branchRef := plumbing.NewBranchReferenceName(branch)
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branchRef, latest)); err != nil {
+		return fmt.Errorf("failed to reset %s: %w", branch, err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branchRef)); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", branch, err)
+	}
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := worktree.Reset(&git.ResetOptions{Commit: latest, Mode: git.HardReset}); err != nil {
+		return fmt.Errorf("failed to reset to origin/%s: %w", branch, err)
+	}
+	if local != latest {
+		g.printf("  Updated to %s\n", latest.String()[:7])
+	}
 	return nil
 }
 
+// trackedBranch returns the branch a cached repository follows. When HEAD is detached, e.g. at a
+// commit a locked build checked out, it is the branch the clone checked out, and the detached
+// commit is returned too.
+func trackedBranch(repo *git.Repository) (string, *plumbing.Hash, error) {
+	head, err := repo.Head()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if head.Name().IsBranch() {
+		return head.Name().Short(), nil, nil
+	}
+
+	detached := head.Hash()
+	if config, err := repo.Config(); err == nil {
+		for name, branch := range config.Branches {
+			if branch.Remote == "origin" {
+				return name, &detached, nil
+			}
+		}
+	}
+	branches, err := repo.Branches()
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to list branches: %w", err)
+	}
+	defer branches.Close()
+	if ref, err := branches.Next(); err == nil {
+		return ref.Name().Short(), &detached, nil
+	}
+	return "", nil, fmt.Errorf("HEAD is detached at %s and no branch is checked out to update from", detached.String()[:7])
+}
+
+// isAncestor reports whether commit ancestor is reachable from commit descendant
+func isAncestor(repo *git.Repository, ancestor, descendant plumbing.Hash) bool {
+	a, err := repo.CommitObject(ancestor)
+	if err != nil {
+		return false
+	}
+	d, err := repo.CommitObject(descendant)
+	if err != nil {
+		return false
+	}
+	ok, err := a.IsAncestor(d)
+	return err == nil && ok
+}
+
 // getRepoDirectoryName creates a unique directory name for a repository URL
 func (g *GitOperations) GetRepoDirectoryName(repoURL string) string {
-	// Remove common prefixes and suffixes
-	name := strings.TrimSuffix(repoURL, ".git")
+	// Remove common prefixes and suffixes, and the ref a remote layer is pinned to
+	name, _ := SplitLayerRef(repoURL)
+	name = strings.TrimSuffix(name, ".git")
 
 	// Extract the repository name from different URL formats
 	if strings.Contains(name, "/") {
@@ -201,6 +640,54 @@ func (g *GitOperations) GetRepoDirectoryName(repoURL string) string {
 	return fmt.Sprintf("%s-%s", name, hashStr)
 }
 
+// checkoutRef checks out the commit a branch, tag, or commit SHA refers to in a cached layer
+// repository, leaving it on a detached HEAD. With fetch, the remote is fetched first unless the ref
+// is a commit that is already there.
+func (g *GitOperations) checkoutRef(localPath, ref string, fetch bool) error {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %w", localPath, err)
+	}
+
+	// A commit that is already there can't change, so only other refs need fetching
+	_, commitErr := repo.CommitObject(plumbing.NewHash(ref))
+	if fetch && (!plumbing.IsHash(ref) || commitErr != nil) {
+		err := repo.Fetch(&git.FetchOptions{RemoteName: "origin", Tags: git.AllTags, Force: true, Progress: g.out()})
+		if err != nil && err != git.NoErrAlreadyUpToDate {
+			return fmt.Errorf("failed to fetch updates: %w", err)
+		}
+	}
+
+	hash, err := resolveRef(repo, ref)
+	if err != nil {
+		return fmt.Errorf("ref %s not found in %s", ref, localPath)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: *hash, Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout %s: %w", ref, err)
+	}
+	g.printf("  Checked out %s (%s)\n", ref, hash.String()[:7])
+	return nil
+}
+
+// resolveRef resolves a branch, tag, or commit of a cached repository. A branch is read from the
+// remote, which is current after fetching, before tags and commits.
+func resolveRef(repo *git.Repository, ref string) (*plumbing.Hash, error) {
+	if hash, err := repo.ResolveRevision(plumbing.Revision("refs/remotes/origin/" + ref)); err == nil {
+		return hash, nil
+	}
+	return repo.ResolveRevision(plumbing.Revision(ref))
+}
+
+// ShortCommit abbreviates a commit hash for display
+func ShortCommit(commit string) string {
+	return commit[:min(8, len(commit))]
+}
+
 // GetRepositoryCommit gets the current commit hash of a repository, or returns info for local layers
 func (g *GitOperations) GetRepositoryCommit(localPath string) (string, error) {
 	// Check if the directory exists first
@@ -233,3 +720,70 @@ func (g *GitOperations) GetRepositoryCommit(localPath string) (string, error) {
 
 	return ref.Hash().String(), nil
 }
+
+// CheckoutCommit checks out a specific commit in a cached layer repository, leaving it on a detached HEAD
+func (g *GitOperations) CheckoutCommit(localPath, commit string) error {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository at %s: %w", localPath, err)
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("failed to get worktree: %w", err)
+	}
+
+	if err := worktree.Checkout(&git.CheckoutOptions{Hash: plumbing.NewHash(commit), Force: true}); err != nil {
+		return fmt.Errorf("failed to checkout commit %s: %w", commit, err)
+	}
+
+	return nil
+}
+
+// HasUpstreamCommit reports whether a commit is reachable from a remote branch or tag of a cached
+// layer repository. Update the repository first so the remote refs are current.
+func (g *GitOperations) HasUpstreamCommit(localPath, commit string) (bool, error) {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository at %s: %w", localPath, err)
+	}
+
+	target, err := repo.CommitObject(plumbing.NewHash(commit))
+	if err == plumbing.ErrObjectNotFound {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read commit %s: %w", commit, err)
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return false, fmt.Errorf("failed to list references: %w", err)
+	}
+	defer refs.Close()
+
+	found := false
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		if found || ref.Type() != plumbing.HashReference || !(ref.Name().IsRemote() || ref.Name().IsTag()) {
+			return nil
+		}
+		hash := ref.Hash()
+		if tag, err := repo.TagObject(hash); err == nil {
+			hash = tag.Target
+		}
+		head, err := repo.CommitObject(hash)
+		if err != nil {
+			return nil
+		}
+		if head.Hash == target.Hash {
+			found = true
+			return nil
+		}
+		found, err = target.IsAncestor(head)
+		return err
+	})
+	if err != nil {
+		return false, fmt.Errorf("failed to check commit %s: %w", commit, err)
+	}
+	return found, nil
+}
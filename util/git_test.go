@@ -0,0 +1,342 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// commitFile writes a file in a repository's worktree and commits it
+func commitFile(t *testing.T, repo *git.Repository, dir, name, content string) plumbing.Hash {
+	t.Helper()
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write %s: %v", name, err)
+	}
+	worktree, _ := repo.Worktree()
+	worktree.Add(name)
+	commit, err := worktree.Commit("update "+name, &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	return commit
+}
+
+func TestHasUpstreamCommit(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "README.md", "one")
+	second := commitFile(t, upstream, upstreamDir, "README.md", "two")
+
+	cloneDir := filepath.Join(t.TempDir(), "layer")
+	clone, err := git.PlainClone(cloneDir, false, &git.CloneOptions{URL: upstreamDir})
+	if err != nil {
+		t.Fatalf("Failed to clone: %v", err)
+	}
+	local := commitFile(t, clone, cloneDir, "local.txt", "not pushed")
+
+	gitOps := NewGitOperations(t.TempDir())
+	expected := map[plumbing.Hash]bool{
+		first:  true,
+		second: true,
+		local:  false,
+		plumbing.NewHash("1111111111111111111111111111111111111111"): false,
+	}
+	for commit, upstream := range expected {
+		found, err := gitOps.HasUpstreamCommit(cloneDir, commit.String())
+		if err != nil {
+			t.Fatalf("HasUpstreamCommit(%s) failed: %v", commit, err)
+		}
+		if found != upstream {
+			t.Errorf("HasUpstreamCommit(%s) = %v, expected %v", commit, found, upstream)
+		}
+	}
+}
+
+func TestIsRemoteLayer(t *testing.T) {
+	gitOps := NewGitOperations(t.TempDir())
+	for repository, remote := range map[string]bool{
+		"git@github.com:example/layer.git":  true,
+		"https://github.com/example/layer":  true,
+		"./layers/local":                    false,
+		"/opt/layers/shared":                false,
+		BuiltinLayerPrefix + "editorconfig": false,
+	} {
+		if got := gitOps.IsRemoteLayer(repository); got != remote {
+			t.Errorf("IsRemoteLayer(%s) = %v, expected %v", repository, got, remote)
+		}
+	}
+}
+
+func TestSplitLayerRef(t *testing.T) {
+	tests := []struct {
+		repository string
+		url        string
+		ref        string
+	}{
+		{"git@github.com:org/repo.git", "git@github.com:org/repo.git", ""},
+		{"git@github.com:org/repo.git@v1.2.0", "git@github.com:org/repo.git", "v1.2.0"},
+		{"https://github.com/org/repo@feature/login", "https://github.com/org/repo", "feature/login"},
+		{"https://user@example.com:8443/org/repo.git@3f2a9c1", "https://user@example.com:8443/org/repo.git", "3f2a9c1"},
+		{"ssh://git@example.com/org/repo.git", "ssh://git@example.com/org/repo.git", ""},
+		{"./layers/team@2024", "./layers/team@2024", ""},
+		{BuiltinLayerPrefix + "makefile", BuiltinLayerPrefix + "makefile", ""},
+	}
+	for _, tt := range tests {
+		url, ref := SplitLayerRef(tt.repository)
+		if url != tt.url || ref != tt.ref {
+			t.Errorf("SplitLayerRef(%s) = %q, %q; expected %q, %q", tt.repository, url, ref, tt.url, tt.ref)
+		}
+	}
+}
+
+func TestCloneOrUpdatePinnedLayer(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "VERSION", "1")
+	if _, err := upstream.CreateTag("v1.0.0", first, &git.CreateTagOptions{
+		Tagger:  &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+		Message: "v1.0.0",
+	}); err != nil {
+		t.Fatalf("Failed to tag: %v", err)
+	}
+	second := commitFile(t, upstream, upstreamDir, "VERSION", "2")
+
+	gitOps := NewGitOperations(t.TempDir())
+	for ref, expected := range map[string]plumbing.Hash{
+		"":                       second,
+		"v1.0.0":                 first,
+		first.String():           first,
+		first.String()[:7]:       first,
+		"master":                 second,
+		"refs/heads/master/typo": plumbing.ZeroHash,
+	} {
+		repository := upstreamDir
+		if ref != "" {
+			repository += "@" + ref
+		}
+		layerPath, err := gitOps.handleRemoteRepository(repository)
+		if expected.IsZero() {
+			if err == nil {
+				t.Errorf("Expected %s to fail", repository)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("Failed to fetch %s: %v", repository, err)
+		}
+		if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != expected.String() {
+			t.Errorf("%s: expected commit %s, got %s", repository, expected, commit)
+		}
+	}
+
+	// A pinned branch follows new commits when updated
+	third := commitFile(t, upstream, upstreamDir, "VERSION", "3")
+	layerPath, err := gitOps.handleRemoteRepository(upstreamDir + "@master")
+	if err != nil {
+		t.Fatalf("Failed to update pinned branch: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != third.String() {
+		t.Errorf("Expected updated branch at %s, got %s", third, commit)
+	}
+}
+
+func TestUpdateForcePushedLayer(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "VERSION", "1")
+	second := commitFile(t, upstream, upstreamDir, "VERSION", "2")
+
+	var out strings.Builder
+	gitOps := NewGitOperations(t.TempDir())
+	gitOps.Output = &out
+	layerPath, err := gitOps.handleRemoteRepository(upstreamDir)
+	if err != nil {
+		t.Fatalf("Failed to clone: %v", err)
+	}
+
+	// Rewrite the second commit, as a force-push would
+	worktree, _ := upstream.Worktree()
+	if err := worktree.Reset(&git.ResetOptions{Commit: first, Mode: git.HardReset}); err != nil {
+		t.Fatalf("Failed to reset upstream: %v", err)
+	}
+	rewritten := commitFile(t, upstream, upstreamDir, "VERSION", "2 rewritten")
+
+	gitOps.FailOnForcePush = true
+	if _, err := gitOps.handleRemoteRepository(upstreamDir); err == nil || !strings.Contains(err.Error(), "force-pushed") {
+		t.Errorf("Expected the force-push to fail the update, got %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != second.String() {
+		t.Errorf("Expected the cache to stay at %s, got %s", second, commit)
+	}
+
+	gitOps.FailOnForcePush = false
+	out.Reset()
+	if _, err := gitOps.handleRemoteRepository(upstreamDir); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != rewritten.String() {
+		t.Errorf("Expected the cache to be reset to %s, got %s", rewritten, commit)
+	}
+	if !strings.Contains(out.String(), "origin/master was force-pushed") {
+		t.Errorf("Expected the force-push to be reported, got:\n%s", out.String())
+	}
+}
+
+func TestUpdateDetachedLayer(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "VERSION", "1")
+	commitFile(t, upstream, upstreamDir, "VERSION", "2")
+
+	var out strings.Builder
+	gitOps := NewGitOperations(t.TempDir())
+	gitOps.Output = &out
+	layerPath, err := gitOps.handleRemoteRepository(upstreamDir)
+	if err != nil {
+		t.Fatalf("Failed to clone: %v", err)
+	}
+
+	// A locked build leaves the cache detached at the pinned commit
+	if err := gitOps.CheckoutCommit(layerPath, first.String()); err != nil {
+		t.Fatalf("Failed to check out %s: %v", first, err)
+	}
+	third := commitFile(t, upstream, upstreamDir, "VERSION", "3")
+
+	out.Reset()
+	if _, err := gitOps.handleRemoteRepository(upstreamDir); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != third.String() {
+		t.Errorf("Expected the cache at %s, got %s", third, commit)
+	}
+	if !strings.Contains(out.String(), "returning to master") {
+		t.Errorf("Expected the detached HEAD to be reported, got:\n%s", out.String())
+	}
+
+	repo, _ := git.PlainOpen(layerPath)
+	if head, err := repo.Head(); err != nil || head.Name() != plumbing.NewBranchReferenceName("master") {
+		t.Errorf("Expected HEAD on master, got %v (%v)", head, err)
+	}
+}
+
+// moveBranch renames the branch HEAD is on, leaving HEAD naming a branch that doesn't exist
+func moveBranch(t *testing.T, repo *git.Repository, from, to string) {
+	t.Helper()
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName(from), false)
+	if err != nil {
+		t.Fatalf("Failed to read %s: %v", from, err)
+	}
+	repo.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName(to), ref.Hash()))
+	repo.Storer.RemoveReference(ref.Name())
+}
+
+func TestCloneLayerWithoutDefaultBranch(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "VERSION", "1")
+	moveBranch(t, upstream, "master", "trunk")
+
+	var out strings.Builder
+	gitOps := NewGitOperations(t.TempDir())
+	gitOps.Output = &out
+	layerPath, err := gitOps.handleRemoteRepository(upstreamDir)
+	if err != nil {
+		t.Fatalf("Failed to clone: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != first.String() {
+		t.Errorf("Expected trunk at %s, got %s", first, commit)
+	}
+	if !strings.Contains(out.String(), "cloning trunk") {
+		t.Errorf("Expected the branch found to be reported, got:\n%s", out.String())
+	}
+
+	// Updates follow the branch found
+	second := commitFile(t, upstream, upstreamDir, "VERSION", "2")
+	moveBranch(t, upstream, "master", "trunk")
+	if _, err := gitOps.handleRemoteRepository(upstreamDir); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != second.String() {
+		t.Errorf("Expected trunk at %s, got %s", second, commit)
+	}
+
+	// With several branches, none of them a usual default, the configured one is cloned
+	upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("develop"), first))
+	moveBranch(t, upstream, "trunk", "stable")
+	fresh := NewGitOperations(t.TempDir())
+	fresh.Output = &out
+	if _, err := fresh.handleRemoteRepository(upstreamDir); err == nil || !strings.Contains(err.Error(), "has several (develop, stable)") {
+		t.Errorf("Expected an error naming the branches, got %v", err)
+	}
+	fresh.DefaultBranch = "stable"
+	if layerPath, err := fresh.handleRemoteRepository(upstreamDir); err != nil {
+		t.Errorf("Failed to clone: %v", err)
+	} else if commit, _ := fresh.GetRepositoryCommit(layerPath); commit != second.String() {
+		t.Errorf("Expected stable at %s, got %s", second, commit)
+	}
+}
+
+func TestCloneLayerBranch(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	first := commitFile(t, upstream, upstreamDir, "VERSION", "1")
+	upstream.Storer.SetReference(plumbing.NewHashReference(plumbing.NewBranchReferenceName("release"), first))
+	second := commitFile(t, upstream, upstreamDir, "VERSION", "2")
+
+	var out strings.Builder
+	gitOps := NewGitOperations(t.TempDir())
+	gitOps.Output = &out
+	gitOps.Branches = map[string]string{upstreamDir: "release"}
+	layerPath, err := gitOps.handleRemoteRepository(upstreamDir)
+	if err != nil {
+		t.Fatalf("Failed to clone: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != first.String() {
+		t.Errorf("Expected release at %s, got %s", first, commit)
+	}
+
+	// Changing the BRANCH of a cached layer switches to it
+	gitOps.Branches[upstreamDir] = "master"
+	if _, err := gitOps.handleRemoteRepository(upstreamDir); err != nil {
+		t.Fatalf("Failed to update: %v", err)
+	}
+	if commit, _ := gitOps.GetRepositoryCommit(layerPath); commit != second.String() {
+		t.Errorf("Expected master at %s, got %s", second, commit)
+	}
+	if !strings.Contains(out.String(), "Switching from branch release to master") {
+		t.Errorf("Expected the switch to be reported, got:\n%s", out.String())
+	}
+
+	fresh := NewGitOperations(t.TempDir())
+	fresh.Output = &out
+	fresh.Branches = map[string]string{upstreamDir: "missing"}
+	if _, err := fresh.handleRemoteRepository(upstreamDir); err == nil || !strings.Contains(err.Error(), "branch missing not found") {
+		t.Errorf("Expected a missing branch error, got %v", err)
+	}
+}
@@ -0,0 +1,167 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// GitignoreFragmentName is the file layers use to contribute rules to the project's .gitignore,
+// which layers can't ship directly because .gitignore is never copied
+const GitignoreFragmentName = ".gitignore.fragment"
+
+// Markers delimiting the section of a .gitignore that otter manages
+const (
+	gitignoreSectionBegin = "# BEGIN otter (generated from layer .gitignore.fragment files; do not edit)"
+	gitignoreSectionEnd   = "# END otter"
+)
+
+// GitignoreSection is the rules collected from layer fragments for one .gitignore
+type GitignoreSection struct {
+	Path  string   `json:"path"`
+	Rules []string `json:"rules"`
+}
+
+// GitignoreSections returns the rules collected so far, in the order each .gitignore was first seen
+func (f *FileOperations) GitignoreSections() []GitignoreSection {
+	sections := make([]GitignoreSection, 0, len(f.gitignoreOrder))
+	for _, gitignorePath := range f.gitignoreOrder {
+		sections = append(sections, GitignoreSection{Path: gitignorePath, Rules: f.gitignoreRules[gitignorePath]})
+	}
+	return sections
+}
+
+// RestoreGitignoreSections collects rules gathered by an earlier build, such as one being resumed
+func (f *FileOperations) RestoreGitignoreSections(sections []GitignoreSection) {
+	for _, section := range sections {
+		if f.gitignoreRules == nil {
+			f.gitignoreRules = make(map[string][]string)
+		}
+		if _, ok := f.gitignoreRules[section.Path]; !ok {
+			f.gitignoreOrder = append(f.gitignoreOrder, section.Path)
+		}
+		for _, rule := range section.Rules {
+			if !containsString(f.gitignoreRules[section.Path], rule) {
+				f.gitignoreRules[section.Path] = append(f.gitignoreRules[section.Path], rule)
+			}
+		}
+	}
+}
+
+// collectGitignoreFragment records the rules of a layer's .gitignore.fragment for the .gitignore
+// in the same directory of the target
+func (f *FileOperations) collectGitignoreFragment(src, dst string) error {
+	content, err := f.layerFS().ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", src, err)
+	}
+
+	gitignorePath := filepath.Join(filepath.Dir(dst), ".gitignore")
+	if f.gitignoreRules == nil {
+		f.gitignoreRules = make(map[string][]string)
+	}
+	if _, ok := f.gitignoreRules[gitignorePath]; !ok {
+		f.gitignoreOrder = append(f.gitignoreOrder, gitignorePath)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		rule := strings.TrimRight(scanner.Text(), " \t\r")
+		if rule == "" || containsString(f.gitignoreRules[gitignorePath], rule) {
+			continue
+		}
+		f.gitignoreRules[gitignorePath] = append(f.gitignoreRules[gitignorePath], rule)
+	}
+
+	f.printf("  Collecting .gitignore rules: %s\n", src)
+	return nil
+}
+
+// ApplyGitignoreFragments writes the rules collected from layer fragments into the otter section
+// of each .gitignore, replacing the section from the previous build and leaving other lines intact
+func (f *FileOperations) ApplyGitignoreFragments() error {
+	for _, gitignorePath := range f.gitignoreOrder {
+		action := "merge"
+		existing, err := f.FS.ReadFile(gitignorePath)
+		if os.IsNotExist(err) {
+			action = "create"
+		} else if err != nil {
+			return fmt.Errorf("failed to read %s: %w", gitignorePath, err)
+		}
+
+		updated := updateGitignoreSection(existing, f.gitignoreRules[gitignorePath])
+		if bytes.Equal(existing, updated) {
+			continue
+		}
+
+		if err := f.FS.MkdirAll(filepath.Dir(gitignorePath), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", gitignorePath, err)
+		}
+		if err := f.FS.WriteFile(gitignorePath, updated, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", gitignorePath, err)
+		}
+
+		fmt.Printf("  Updated .gitignore rules: %s\n", gitignorePath)
+		f.Changes = append(f.Changes, FileChange{Path: gitignorePath, Action: action})
+	}
+
+	f.gitignoreRules = nil
+	f.gitignoreOrder = nil
+	return nil
+}
+
+// updateGitignoreSection replaces the otter section of a .gitignore with rules, appending the
+// section when the file doesn't have one yet
+func updateGitignoreSection(existing []byte, rules []string) []byte {
+	var before, after []string
+	inSection, found := false, false
+
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case !found && line == gitignoreSectionBegin:
+			inSection, found = true, true
+		case inSection && line == gitignoreSectionEnd:
+			inSection = false
+		case inSection:
+		case found:
+			after = append(after, line)
+		default:
+			before = append(before, line)
+		}
+	}
+
+	var out strings.Builder
+	for _, line := range before {
+		out.WriteString(line + "\n")
+	}
+	if !found && len(before) > 0 && strings.TrimSpace(before[len(before)-1]) != "" {
+		out.WriteString("\n")
+	}
+
+	out.WriteString(gitignoreSectionBegin + "\n")
+	for _, rule := range rules {
+		out.WriteString(rule + "\n")
+	}
+	out.WriteString(gitignoreSectionEnd + "\n")
+
+	for _, line := range after {
+		out.WriteString(line + "\n")
+	}
+
+	return []byte(out.String())
+}
+
+// containsString reports whether values contains s
+func containsString(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,86 @@
+package util
+
+import (
+	"testing"
+)
+
+func TestUpdateGitignoreSection(t *testing.T) {
+	rules := []string{"# Go", "/bin/", "*.test"}
+
+	appended := updateGitignoreSection([]byte("node_modules/\n.env\n"), rules)
+	expected := "node_modules/\n.env\n\n" + gitignoreSectionBegin + "\n# Go\n/bin/\n*.test\n" + gitignoreSectionEnd + "\n"
+	if string(appended) != expected {
+		t.Errorf("Unexpected appended section:\n%s", appended)
+	}
+
+	// The section is replaced in place, keeping the lines around it
+	existing := "node_modules/\n" + gitignoreSectionBegin + "\nold-rule\n" + gitignoreSectionEnd + "\n.env\n"
+	replaced := updateGitignoreSection([]byte(existing), []string{"/dist/"})
+	expected = "node_modules/\n" + gitignoreSectionBegin + "\n/dist/\n" + gitignoreSectionEnd + "\n.env\n"
+	if string(replaced) != expected {
+		t.Errorf("Unexpected replaced section:\n%s", replaced)
+	}
+}
+
+func TestCopyLayerCollectsGitignoreFragments(t *testing.T) {
+	fsys := NewMemFileSystem()
+	for _, dir := range []string{"/layers/go", "/layers/node/web", "/project"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	files := map[string]string{
+		"/layers/go/" + GitignoreFragmentName:       "/bin/\n*.test\n",
+		"/layers/node/" + GitignoreFragmentName:     "*.test\nnode_modules/\n",
+		"/layers/node/web/" + GitignoreFragmentName: "/dist/\n",
+		"/layers/node/.gitignore":                   "should never be copied\n",
+		"/project/.gitignore":                       ".env\n",
+	}
+	for name, content := range files {
+		if err := fsys.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	delims := [2]string{"{{", "}}"}
+	for _, layer := range []string{"/layers/go", "/layers/node"} {
+		if err := fileOps.CopyLayer(layer, "/project", "/project", nil, delims, true); err != nil {
+			t.Fatalf("Failed to copy %s: %v", layer, err)
+		}
+	}
+	if err := fileOps.ApplyGitignoreFragments(); err != nil {
+		t.Fatalf("Failed to apply fragments: %v", err)
+	}
+
+	content, err := fsys.ReadFile("/project/.gitignore")
+	if err != nil {
+		t.Fatalf("Failed to read .gitignore: %v", err)
+	}
+	expected := ".env\n\n" + gitignoreSectionBegin + "\n/bin/\n*.test\nnode_modules/\n" + gitignoreSectionEnd + "\n"
+	if string(content) != expected {
+		t.Errorf("Unexpected .gitignore:\n%s", content)
+	}
+
+	if _, err := fsys.ReadFile("/project/web/.gitignore"); err != nil {
+		t.Errorf("Expected nested .gitignore to be created: %v", err)
+	}
+	if _, err := fsys.Stat("/project/" + GitignoreFragmentName); err == nil {
+		t.Error("Fragment files should not be copied into the project")
+	}
+
+	// Applying the same rules again leaves the file untouched
+	fileOps.TakeChanges()
+	if err := fileOps.CopyLayer("/layers/go", "/project", "/project", nil, delims, true); err != nil {
+		t.Fatalf("Failed to copy layer: %v", err)
+	}
+	fileOps.TakeChanges()
+	if err := fileOps.ApplyGitignoreFragments(); err != nil {
+		t.Fatalf("Failed to apply fragments: %v", err)
+	}
+	content, _ = fsys.ReadFile("/project/.gitignore")
+	expected = ".env\n\n" + gitignoreSectionBegin + "\n/bin/\n*.test\n" + gitignoreSectionEnd + "\n"
+	if string(content) != expected {
+		t.Errorf("Expected section to reflect only the applied layers:\n%s", content)
+	}
+}
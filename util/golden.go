@@ -0,0 +1,257 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Parts of a golden test case directory besides its Otterfile and local layers
+const (
+	GoldenExpectedDir = "expected"    // Files the build is expected to write, as a tree
+	GoldenInputDir    = "input"       // Files the output starts with, e.g. for merges and patches
+	GoldenReportName  = "report.json" // Expected report of the build
+)
+
+// GoldenReport is the outcome of a golden test build: the audit entry without what varies
+// between runs, such as times, users, and commits
+type GoldenReport struct {
+	Success      bool          `json:"success"`
+	Error        string        `json:"error,omitempty"`
+	Layers       []AuditLayer  `json:"layers"`
+	FilesChanged []FileChange  `json:"files_changed"`
+	FilesIgnored []IgnoredFile `json:"files_ignored,omitempty"`
+	Warnings     []Warning     `json:"warnings,omitempty"`
+}
+
+// PrepareGoldenCase copies a golden test case into a project directory to build it in, with
+// everything but its expected results, and its input files into the output directory
+func PrepareGoldenCase(caseDir, projectDir, outputDir string) error {
+	if _, err := os.Stat(filepath.Join(caseDir, "Otterfile")); err != nil {
+		return fmt.Errorf("golden test case %s has no Otterfile", caseDir)
+	}
+	err := copyTree(caseDir, projectDir, func(relativePath string, info os.FileInfo) bool {
+		switch relativePath {
+		case GoldenExpectedDir, GoldenInputDir, GoldenReportName:
+			return true
+		}
+		return info.IsDir() && info.Name() == ".git"
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(projectDir, ".otter"), 0755); err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return err
+	}
+	inputDir := filepath.Join(caseDir, GoldenInputDir)
+	if _, err := os.Stat(inputDir); os.IsNotExist(err) {
+		return nil
+	}
+	return copyTree(inputDir, outputDir, func(string, os.FileInfo) bool { return false })
+}
+
+// LastAuditEntry returns the latest entry of the audit log of a project
+func LastAuditEntry(projectRoot string) (*AuditEntry, error) {
+	logPath := filepath.Join(projectRoot, ".otter", AuditLogFileName)
+	logFile, err := os.Open(logPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	defer logFile.Close()
+
+	var last []byte
+	scanner := bufio.NewScanner(logFile)
+	scanner.Buffer(nil, 64*1024*1024)
+	for scanner.Scan() {
+		if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+			last = append(last[:0], line...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read audit log: %w", err)
+	}
+	if last == nil {
+		return nil, fmt.Errorf("audit log %s is empty", logPath)
+	}
+
+	var entry AuditEntry
+	if err := json.Unmarshal(last, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse audit log: %w", err)
+	}
+	return &entry, nil
+}
+
+// NewGoldenReport reduces an audit entry to a GoldenReport. Paths use forward slashes, and each
+// directory in placeholders is replaced with its placeholder in the error, e.g. the temporary
+// directory the case was built in.
+func NewGoldenReport(entry *AuditEntry, placeholders map[string]string) GoldenReport {
+	report := GoldenReport{
+		Success:      entry.Success,
+		Error:        entry.Error,
+		Layers:       make([]AuditLayer, 0, len(entry.Layers)),
+		FilesChanged: make([]FileChange, 0, len(entry.FilesChanged)),
+		Warnings:     entry.Warnings,
+	}
+
+	// Replace longer directories first, so one inside another keeps its own placeholder
+	dirs := make([]string, 0, len(placeholders))
+	for dir := range placeholders {
+		dirs = append(dirs, dir)
+	}
+	sort.Slice(dirs, func(i, j int) bool { return len(dirs[i]) > len(dirs[j]) })
+	for _, dir := range dirs {
+		report.Error = strings.ReplaceAll(report.Error, dir, placeholders[dir])
+	}
+
+	for _, layer := range entry.Layers {
+		layer.Commit = ""
+		report.Layers = append(report.Layers, layer)
+	}
+	for _, change := range entry.FilesChanged {
+		change.Path = filepath.ToSlash(change.Path)
+		change.From = filepath.ToSlash(change.From)
+		report.FilesChanged = append(report.FilesChanged, change)
+	}
+	for _, ignored := range entry.FilesIgnored {
+		ignored.Path = filepath.ToSlash(ignored.Path)
+		report.FilesIgnored = append(report.FilesIgnored, ignored)
+	}
+	return report
+}
+
+// Marshal encodes the report as it is stored in report.json
+func (r GoldenReport) Marshal() []byte {
+	data, _ := json.MarshalIndent(r, "", "  ")
+	return append(data, '\n')
+}
+
+// CompareGolden compares the result of building a golden test case with its expected output
+// tree and report, and returns the differences
+func CompareGolden(caseDir, outputDir string, report GoldenReport) ([]string, error) {
+	expectedDir := filepath.Join(caseDir, GoldenExpectedDir)
+	expected, err := treeFiles(expectedDir)
+	if err != nil {
+		return nil, err
+	}
+	actual, err := treeFiles(outputDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var differences []string
+	for _, path := range sortedKeys(expected, actual) {
+		_, inExpected := expected[path]
+		_, inActual := actual[path]
+		switch {
+		case !inActual:
+			differences = append(differences, "missing "+path)
+		case !inExpected:
+			differences = append(differences, "unexpected "+path)
+		default:
+			expectedContent, err := os.ReadFile(filepath.Join(expectedDir, filepath.FromSlash(path)))
+			if err != nil {
+				return nil, err
+			}
+			actualContent, err := os.ReadFile(filepath.Join(outputDir, filepath.FromSlash(path)))
+			if err != nil {
+				return nil, err
+			}
+			if difference := firstDifference(expectedContent, actualContent); difference != "" {
+				differences = append(differences, path+" differs at "+difference)
+			}
+		}
+	}
+
+	expectedReport, err := os.ReadFile(filepath.Join(caseDir, GoldenReportName))
+	switch {
+	case os.IsNotExist(err):
+		differences = append(differences, "missing "+GoldenReportName)
+	case err != nil:
+		return nil, err
+	default:
+		if difference := firstDifference(expectedReport, report.Marshal()); difference != "" {
+			differences = append(differences, GoldenReportName+" differs at "+difference)
+		}
+	}
+	return differences, nil
+}
+
+// UpdateGolden replaces the expected output tree and report of a golden test case with the
+// result of building it
+func UpdateGolden(caseDir, outputDir string, report GoldenReport) error {
+	expectedDir := filepath.Join(caseDir, GoldenExpectedDir)
+	if err := os.RemoveAll(expectedDir); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", expectedDir, err)
+	}
+	if err := os.MkdirAll(expectedDir, 0755); err != nil {
+		return err
+	}
+	if err := copyTree(outputDir, expectedDir, func(string, os.FileInfo) bool { return false }); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(caseDir, GoldenReportName), report.Marshal(), 0644)
+}
+
+// treeFiles returns the files under root by path with forward slashes; none when root doesn't exist
+func treeFiles(root string) (map[string]bool, error) {
+	files := make(map[string]bool)
+	if _, err := os.Stat(root); os.IsNotExist(err) {
+		return files, nil
+	}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relativePath, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		files[filepath.ToSlash(relativePath)] = true
+		return nil
+	})
+	return files, err
+}
+
+// sortedKeys returns the keys of both maps, sorted
+func sortedKeys(a, b map[string]bool) []string {
+	var keys []string
+	for key := range a {
+		keys = append(keys, key)
+	}
+	for key := range b {
+		if !a[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// firstDifference describes the first line that differs between expected and actual content,
+// or returns "" when they are the same
+func firstDifference(expected, actual []byte) string {
+	if bytes.Equal(expected, actual) {
+		return ""
+	}
+	expectedLines := strings.Split(string(expected), "\n")
+	actualLines := strings.Split(string(actual), "\n")
+	for i := 0; ; i++ {
+		switch {
+		case i >= len(expectedLines):
+			return fmt.Sprintf("line %d: expected end of file, got %q", i+1, actualLines[i])
+		case i >= len(actualLines):
+			return fmt.Sprintf("line %d: expected %q, got end of file", i+1, expectedLines[i])
+		case expectedLines[i] != actualLines[i]:
+			return fmt.Sprintf("line %d: expected %q, got %q", i+1, expectedLines[i], actualLines[i])
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoldenCase(t *testing.T) {
+	caseDir := t.TempDir()
+	os.MkdirAll(filepath.Join(caseDir, "layers", "base"), 0755)
+	os.MkdirAll(filepath.Join(caseDir, GoldenInputDir), 0755)
+	os.WriteFile(filepath.Join(caseDir, "Otterfile"), []byte("LAYER ./layers/base\n"), 0644)
+	os.WriteFile(filepath.Join(caseDir, "layers", "base", "app.yml"), []byte("a: 1\n"), 0644)
+	os.WriteFile(filepath.Join(caseDir, GoldenInputDir, "existing.txt"), []byte("kept\n"), 0644)
+
+	buildDir := t.TempDir()
+	projectDir := filepath.Join(buildDir, "project")
+	outputDir := filepath.Join(buildDir, "output")
+	if err := PrepareGoldenCase(caseDir, projectDir, outputDir); err != nil {
+		t.Fatalf("PrepareGoldenCase failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, "layers", "base", "app.yml")); err != nil {
+		t.Errorf("Expected the layers to be copied into the project: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(projectDir, GoldenInputDir)); !os.IsNotExist(err) {
+		t.Error("Expected the input to be left out of the project")
+	}
+	if content, _ := os.ReadFile(filepath.Join(outputDir, "existing.txt")); string(content) != "kept\n" {
+		t.Errorf("Expected the input to seed the output, got %q", content)
+	}
+
+	// Stand in for a build
+	os.WriteFile(filepath.Join(outputDir, "app.yml"), []byte("a: 1\n"), 0644)
+	entry := NewAuditEntry("test", "dev")
+	entry.Success = false
+	entry.Error = "failed to read " + filepath.Join(projectDir, "Otterfile")
+	entry.Layers = append(entry.Layers, AuditLayer{Repository: "./layers/base", Commit: "local-dir", Target: "."})
+	entry.FilesChanged = append(entry.FilesChanged, FileChange{Path: "app.yml", Action: "create"})
+	if err := RecordAudit(projectDir, AuditConfig{}, entry); err != nil {
+		t.Fatalf("RecordAudit failed: %v", err)
+	}
+	recorded, err := LastAuditEntry(projectDir)
+	if err != nil {
+		t.Fatalf("LastAuditEntry failed: %v", err)
+	}
+	report := NewGoldenReport(recorded, map[string]string{buildDir: "$BUILD", projectDir: "$PROJECT"})
+	if report.Error != "failed to read "+filepath.Join("$PROJECT", "Otterfile") {
+		t.Errorf("Expected the project directory to be replaced, got %q", report.Error)
+	}
+	if report.Layers[0].Commit != "" {
+		t.Errorf("Expected commits to be left out, got %+v", report.Layers[0])
+	}
+
+	if err := UpdateGolden(caseDir, outputDir, report); err != nil {
+		t.Fatalf("UpdateGolden failed: %v", err)
+	}
+	differences, err := CompareGolden(caseDir, outputDir, report)
+	if err != nil {
+		t.Fatalf("CompareGolden failed: %v", err)
+	}
+	if len(differences) != 0 {
+		t.Errorf("Expected no differences after updating, got %v", differences)
+	}
+
+	os.WriteFile(filepath.Join(outputDir, "app.yml"), []byte("a: 2\n"), 0644)
+	os.Remove(filepath.Join(outputDir, "existing.txt"))
+	os.WriteFile(filepath.Join(outputDir, "extra.txt"), nil, 0644)
+	report.Success = true
+	differences, err = CompareGolden(caseDir, outputDir, report)
+	if err != nil {
+		t.Fatalf("CompareGolden failed: %v", err)
+	}
+	expected := []string{
+		`app.yml differs at line 1: expected "a: 1", got "a: 2"`,
+		"missing existing.txt",
+		"unexpected extra.txt",
+		`report.json differs at line 2: expected "  \"success\": false,", got "  \"success\": true,"`,
+	}
+	if strings.Join(differences, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected differences:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(differences, "\n"))
+	}
+}
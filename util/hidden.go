@@ -0,0 +1,53 @@
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// Policies for hidden files at the root of a layer, set with hidden_files in .otter/config.json
+const (
+	HiddenFilesCopy  = "copy"  // Copy hidden files like any other file (default)
+	HiddenFilesAllow = "allow" // Copy hidden files only when the layer's ALLOW_HIDDEN clause lists them
+)
+
+// hiddenFilesSource names the hidden file policy as the source of files it skips
+const hiddenFilesSource = "hidden file policy"
+
+// ResolveHiddenFiles reports whether hidden files at a layer root require ALLOW_HIDDEN under a policy
+func ResolveHiddenFiles(policy string) (bool, error) {
+	switch strings.ToLower(policy) {
+	case "", HiddenFilesCopy:
+		return false, nil
+	case HiddenFilesAllow:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown hidden_files policy %q (expected copy or allow)", policy)
+	}
+}
+
+// hiddenRoot returns the hidden file or directory at the layer root that relativePath is or is
+// inside of, or "" when it isn't hidden
+func hiddenRoot(relativePath string) string {
+	root, _, _ := strings.Cut(filepath.ToSlash(relativePath), "/")
+	if strings.HasPrefix(root, ".") && root != "." && root != ".." {
+		return root
+	}
+	return ""
+}
+
+// hiddenFileRule returns a rule skipping relativePath when it is hidden at the layer root, hidden
+// files require ALLOW_HIDDEN, and the layer doesn't list it
+func (f *FileOperations) hiddenFileRule(relativePath string) *IgnoreRule {
+	root := hiddenRoot(relativePath)
+	if !f.RequireAllowHidden || root == "" {
+		return nil
+	}
+	for _, pattern := range f.AllowHidden {
+		if f.matchPattern(pattern, root) {
+			return nil
+		}
+	}
+	return &IgnoreRule{Pattern: root, Source: hiddenFilesSource}
+}
@@ -0,0 +1,64 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveHiddenFiles(t *testing.T) {
+	for policy, want := range map[string]bool{"": false, "copy": false, "Allow": true} {
+		got, err := ResolveHiddenFiles(policy)
+		if err != nil || got != want {
+			t.Errorf("ResolveHiddenFiles(%q) = %v, %v, want %v", policy, got, err, want)
+		}
+	}
+	if _, err := ResolveHiddenFiles("never"); err == nil {
+		t.Error("Expected an unknown policy to fail")
+	}
+}
+
+func TestCopyLayerHiddenFiles(t *testing.T) {
+	layerDir := t.TempDir()
+	os.MkdirAll(filepath.Join(layerDir, ".github", "workflows"), 0755)
+	os.MkdirAll(filepath.Join(layerDir, "src"), 0755)
+	os.WriteFile(filepath.Join(layerDir, ".npmrc"), []byte("registry"), 0644)
+	os.WriteFile(filepath.Join(layerDir, ".tool-versions"), []byte("node 20"), 0644)
+	os.WriteFile(filepath.Join(layerDir, ".github", "workflows", "ci.yml"), []byte("on: push"), 0644)
+	os.WriteFile(filepath.Join(layerDir, "src", ".keep"), []byte(""), 0644)
+
+	// Hidden files are copied by default and marked in the recorded changes
+	targetDir := t.TempDir()
+	fileOps := NewFileOperations()
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	hidden := make(map[string]bool)
+	for _, change := range fileOps.TakeChanges() {
+		relativePath, _ := filepath.Rel(targetDir, change.Path)
+		hidden[filepath.ToSlash(relativePath)] = change.Hidden
+	}
+	for path, want := range map[string]bool{".npmrc": true, ".tool-versions": true, ".github/workflows/ci.yml": true, "src/.keep": false} {
+		if got, ok := hidden[path]; !ok || got != want {
+			t.Errorf("change for %s: written %v, hidden %v, want hidden %v", path, ok, got, want)
+		}
+	}
+
+	// The allow policy only copies hidden files the layer lists
+	targetDir = t.TempDir()
+	fileOps = NewFileOperations()
+	fileOps.RequireAllowHidden = true
+	fileOps.AllowHidden = []string{".github"}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	for path, want := range map[string]bool{".npmrc": false, ".tool-versions": false, ".github/workflows/ci.yml": true, "src/.keep": true} {
+		if _, err := os.Stat(filepath.Join(targetDir, path)); (err == nil) != want {
+			t.Errorf("%s copied = %v, want %v", path, err == nil, want)
+		}
+	}
+	ignored := fileOps.TakeIgnored()
+	if len(ignored) != 2 || ignored[0].Source != hiddenFilesSource {
+		t.Errorf("Expected the unlisted hidden files to be recorded as ignored, got %+v", ignored)
+	}
+}
@@ -0,0 +1,110 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// IgnorePresetDirective selects presets from an .otterignore file, e.g. "@preset node,python"
+const IgnorePresetDirective = "@preset"
+
+// ignorePresets are maintained pattern lists for common toolchains, selected by name
+var ignorePresets = map[string][]string{
+	"node": {
+		"node_modules/",
+		".npm/",
+		".pnpm-store/",
+		".yarn/cache/",
+		".next/",
+		".nuxt/",
+		".turbo/",
+		"npm-debug.log",
+		"yarn-error.log",
+		"pnpm-debug.log",
+	},
+	"python": {
+		"__pycache__/",
+		".venv/",
+		"venv/",
+		".tox/",
+		".nox/",
+		".pytest_cache/",
+		".mypy_cache/",
+		".ruff_cache/",
+		"*.pyc",
+		"*.pyo",
+	},
+	"rust": {
+		"target/",
+		"*.rs.bk",
+	},
+	"go": {
+		"vendor/",
+		"*.test",
+		"*.out",
+	},
+	"java": {
+		"target/",
+		"build/",
+		".gradle/",
+		"*.class",
+	},
+	"os": {
+		".DS_Store",
+		"Thumbs.db",
+		"desktop.ini",
+	},
+}
+
+// IgnorePresetNames returns the names of the available ignore presets
+func IgnorePresetNames() []string {
+	names := make([]string, 0, len(ignorePresets))
+	for name := range ignorePresets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ExpandIgnorePresets returns the patterns of the named presets, in order and without duplicates
+func ExpandIgnorePresets(names []string) ([]string, error) {
+	var patterns []string
+	seen := make(map[string]bool)
+
+	for _, name := range names {
+		preset, ok := ignorePresets[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unknown ignore preset %q (available: %s)", name, strings.Join(IgnorePresetNames(), ", "))
+		}
+		for _, pattern := range preset {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+
+	return patterns, nil
+}
+
+// SplitPresetNames splits a preset list separated by commas and/or whitespace
+func SplitPresetNames(list string) []string {
+	return strings.FieldsFunc(list, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// AddIgnorePresets appends the patterns of the named presets to the project ignore patterns
+func (f *FileOperations) AddIgnorePresets(names []string) error {
+	for _, name := range names {
+		patterns, err := ExpandIgnorePresets([]string{name})
+		if err != nil {
+			return err
+		}
+		for _, pattern := range patterns {
+			f.addIgnoreRule(IgnoreRule{Pattern: pattern, Source: "Otterfile IGNORE PRESET " + name})
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExpandIgnorePresets(t *testing.T) {
+	patterns, err := ExpandIgnorePresets([]string{"rust", "Java"})
+	if err != nil {
+		t.Fatalf("Failed to expand presets: %v", err)
+	}
+
+	// target/ is shared by both presets and only listed once
+	count := 0
+	for _, pattern := range patterns {
+		if pattern == "target/" {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("Expected target/ once, got %d times in %v", count, patterns)
+	}
+
+	if _, err := ExpandIgnorePresets([]string{"node", "cobol"}); err == nil {
+		t.Error("Expected error for unknown preset")
+	}
+}
+
+func TestIgnorePresetDirective(t *testing.T) {
+	fsys := NewMemFileSystem()
+	files := map[string]string{
+		"/layer/package.json":             "{}",
+		"/layer/node_modules/left-pad.js": "module.exports = 1",
+		"/layer/app/__pycache__/main.pyc": "",
+		"/layer/app/main.py":              "print('hi')",
+		"/layer/tmp/cache.bin":            "",
+		"/project/.otterignore":           "@preset node, python\ntmp/\n",
+		"/layer/.DS_Store":                "",
+	}
+	for path, content := range files {
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	if err := fileOps.LoadIgnorePatterns("/project"); err != nil {
+		t.Fatalf("Failed to load ignore patterns: %v", err)
+	}
+	if err := fileOps.AddIgnorePresets([]string{"os"}); err != nil {
+		t.Fatalf("Failed to add presets: %v", err)
+	}
+
+	if err := fileOps.CopyLayer("/layer", "/project", "/project", nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("Failed to copy layer: %v", err)
+	}
+
+	for _, copied := range []string{"/project/package.json", "/project/app/main.py"} {
+		if _, err := fsys.Stat(copied); err != nil {
+			t.Errorf("Expected %s to be copied: %v", copied, err)
+		}
+	}
+	for _, ignored := range []string{"/project/node_modules", "/project/app/__pycache__/main.pyc", "/project/tmp", "/project/.DS_Store"} {
+		if _, err := fsys.Stat(ignored); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be ignored", ignored)
+		}
+	}
+
+	if err := fsys.WriteFile("/project/.otterignore", []byte("@preset haskell\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .otterignore: %v", err)
+	}
+	if err := fileOps.LoadIgnorePatterns("/project"); err == nil {
+		t.Error("Expected error for unknown preset in .otterignore")
+	}
+}
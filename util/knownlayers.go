@@ -0,0 +1,178 @@
+package util
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// KnownLayer identifies a layer by the files it writes, so otter init --from-existing can recognize
+// it in a project that was set up without otter
+type KnownLayer struct {
+	Layer   string `json:"layer"`             // Repository to add as a LAYER
+	Options string `json:"options,omitempty"` // Clauses written after the repository, e.g. TARGET .github
+	// Files are the SHA-256 hashes of the layer's canonical files, by where the project receives them,
+	// relative to the project with forward slashes; see CanonicalHash
+	Files map[string]string `json:"files"`
+}
+
+// KnownLayerRegistry is a file listing known layers, such as the layers an organization publishes
+type KnownLayerRegistry struct {
+	Layers []KnownLayer `json:"layers"`
+}
+
+// LayerMatch is how much of a known layer a project has
+type LayerMatch struct {
+	KnownLayer
+	Matched  []string // Canonical files the project has with the same content, sorted
+	Modified []string // Canonical files the project has with other content, sorted
+	Missing  []string // Canonical files the project doesn't have, sorted
+}
+
+// Complete reports whether the project has every canonical file of the layer unchanged
+func (m LayerMatch) Complete() bool {
+	return len(m.Modified) == 0 && len(m.Missing) == 0
+}
+
+// Line returns the Otterfile command that adds the matched layer
+func (m LayerMatch) Line() string {
+	return LayerSuggestion{Layer: m.Layer, Options: m.Options}.Line()
+}
+
+// CanonicalHash returns the hex SHA-256 of file content with CRLF line endings converted to LF, so a
+// checkout on Windows matches the hash of the layer's file, e.g. as printed by sha256sum
+func CanonicalHash(content []byte) string {
+	sum := sha256.Sum256(bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n")))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseKnownLayerRegistry parses a registry of known layers; source is used in error messages
+func ParseKnownLayerRegistry(data []byte, source string) ([]KnownLayer, error) {
+	var registry KnownLayerRegistry
+	if err := json.Unmarshal(data, &registry); err != nil {
+		return nil, fmt.Errorf("failed to parse known layers from %s: %w", source, err)
+	}
+	if err := ValidateKnownLayers(registry.Layers); err != nil {
+		return nil, fmt.Errorf("invalid known layers in %s: %w", source, err)
+	}
+	return registry.Layers, nil
+}
+
+// ValidateKnownLayers checks that each known layer names a repository and identifies it by files
+// inside the project with SHA-256 hashes
+func ValidateKnownLayers(layers []KnownLayer) error {
+	for _, layer := range layers {
+		if layer.Layer == "" {
+			return fmt.Errorf("known layer without a layer repository")
+		}
+		if len(layer.Files) == 0 {
+			return fmt.Errorf("known layer %s has no files to recognize it by", layer.Layer)
+		}
+		for name, hash := range layer.Files {
+			if cleanMapPath(name) != name {
+				return fmt.Errorf("known layer %s: file %q must be a clean path inside the project", layer.Layer, name)
+			}
+			if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != sha256.Size {
+				return fmt.Errorf("known layer %s: hash of %s must be a hex SHA-256, got: %s", layer.Layer, name, hash)
+			}
+		}
+	}
+	return nil
+}
+
+// BuiltinKnownLayers returns the built-in layers that copy their files as they are, identified by
+// those files. Layers with templates are left out, since their output varies by project.
+func BuiltinKnownLayers() []KnownLayer {
+	var layers []KnownLayer
+	for _, name := range BuiltinLayerNames() {
+		layer, err := BuiltinLayer(name)
+		if err != nil {
+			continue
+		}
+		known := KnownLayer{Layer: BuiltinLayerPrefix + name, Files: make(map[string]string)}
+		templated := false
+		fs.WalkDir(layer, ".", func(filePath string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || filePath == LayerMetadataName {
+				return err
+			}
+			content, err := fs.ReadFile(layer, filePath)
+			if err != nil {
+				return err
+			}
+			templated = templated || bytes.Contains(content, []byte("{{"))
+			known.Files[filePath] = CanonicalHash(content)
+			return nil
+		})
+		if !templated && len(known.Files) > 0 {
+			layers = append(layers, known)
+		}
+	}
+	return layers
+}
+
+// MatchKnownLayers returns the known layers the project at projectDir has at least one canonical
+// file of, unchanged, in the order given. A layer listed more than once is matched by its first entry.
+func MatchKnownLayers(projectDir string, layers []KnownLayer) ([]LayerMatch, error) {
+	var matches []LayerMatch
+	seen := make(map[string]bool)
+	for _, layer := range layers {
+		if seen[layer.Layer] {
+			continue
+		}
+		seen[layer.Layer] = true
+
+		match := LayerMatch{KnownLayer: layer}
+		for name, hash := range layer.Files {
+			content, err := os.ReadFile(filepath.Join(projectDir, filepath.FromSlash(name)))
+			switch {
+			case os.IsNotExist(err):
+				match.Missing = append(match.Missing, name)
+			case err != nil:
+				return nil, fmt.Errorf("failed to read %s: %w", name, err)
+			case strings.EqualFold(CanonicalHash(content), hash):
+				match.Matched = append(match.Matched, name)
+			default:
+				match.Modified = append(match.Modified, name)
+			}
+		}
+		if len(match.Matched) == 0 {
+			continue
+		}
+		sort.Strings(match.Matched)
+		sort.Strings(match.Modified)
+		sort.Strings(match.Missing)
+		matches = append(matches, match)
+	}
+	return matches, nil
+}
+
+// ProposeOtterfile returns an Otterfile that applies the layers the project fully matches. Partial
+// matches are listed commented out, since building them would overwrite the files that differ.
+func ProposeOtterfile(matches []LayerMatch) string {
+	var builder strings.Builder
+	builder.WriteString("# Otterfile proposed by otter init --from-existing from the files already in the project.\n")
+	builder.WriteString("# Review it, then run 'otter build' to apply the layers.\n")
+	for _, match := range matches {
+		builder.WriteString("\n")
+		if match.Complete() {
+			fmt.Fprintf(&builder, "# All %d file(s) match\n%s\n", len(match.Files), match.Line())
+			continue
+		}
+		fmt.Fprintf(&builder, "# %d of %d file(s) match", len(match.Matched), len(match.Files))
+		if len(match.Modified) > 0 {
+			fmt.Fprintf(&builder, "; modified: %s", strings.Join(match.Modified, ", "))
+		}
+		if len(match.Missing) > 0 {
+			fmt.Fprintf(&builder, "; missing: %s", strings.Join(match.Missing, ", "))
+		}
+		fmt.Fprintf(&builder, "\n# %s\n", match.Line())
+	}
+	return builder.String()
+}
@@ -0,0 +1,112 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalHash(t *testing.T) {
+	// sha256sum of "hello\n"
+	expected := "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"
+	if got := CanonicalHash([]byte("hello\n")); got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+	if got := CanonicalHash([]byte("hello\r\n")); got != expected {
+		t.Errorf("Expected CRLF to hash like LF, got %s", got)
+	}
+}
+
+func TestMatchKnownLayers(t *testing.T) {
+	projectDir := t.TempDir()
+	files := map[string]string{
+		".github/workflows/ci.yml":      "on: push\r\n",
+		".github/workflows/release.yml": "on: tag (edited)\n",
+		"Dockerfile":                    "FROM alpine\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(projectDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	known := []KnownLayer{
+		{Layer: "git@github.com:org/ci.git", Options: "TARGET .github", Files: map[string]string{
+			".github/workflows/ci.yml":      CanonicalHash([]byte("on: push\n")),
+			".github/workflows/release.yml": CanonicalHash([]byte("on: tag\n")),
+			".github/dependabot.yml":        CanonicalHash([]byte("version: 2\n")),
+		}},
+		{Layer: "git@github.com:org/docker.git", Files: map[string]string{"Dockerfile": CanonicalHash([]byte("FROM alpine\n"))}},
+		{Layer: "git@github.com:org/docker.git", Files: map[string]string{"Dockerfile": CanonicalHash([]byte("FROM debian\n"))}},
+		{Layer: "git@github.com:org/node.git", Files: map[string]string{"package.json": CanonicalHash([]byte("{}\n"))}},
+	}
+	matches, err := MatchKnownLayers(projectDir, known)
+	if err != nil {
+		t.Fatalf("MatchKnownLayers failed: %v", err)
+	}
+	if len(matches) != 2 {
+		t.Fatalf("Expected 2 matches, got %+v", matches)
+	}
+
+	ci := matches[0]
+	if ci.Complete() || !reflect.DeepEqual(ci.Matched, []string{".github/workflows/ci.yml"}) ||
+		!reflect.DeepEqual(ci.Modified, []string{".github/workflows/release.yml"}) ||
+		!reflect.DeepEqual(ci.Missing, []string{".github/dependabot.yml"}) {
+		t.Errorf("Unexpected partial match: %+v", ci)
+	}
+	if !matches[1].Complete() || matches[1].Layer != "git@github.com:org/docker.git" {
+		t.Errorf("Expected the first docker entry to match completely, got %+v", matches[1])
+	}
+
+	proposal := ProposeOtterfile(matches)
+	for _, expected := range []string{
+		"# 1 of 3 file(s) match; modified: .github/workflows/release.yml; missing: .github/dependabot.yml\n# LAYER git@github.com:org/ci.git TARGET .github\n",
+		"# All 1 file(s) match\nLAYER git@github.com:org/docker.git\n",
+	} {
+		if !strings.Contains(proposal, expected) {
+			t.Errorf("Expected the proposal to contain %q, got:\n%s", expected, proposal)
+		}
+	}
+}
+
+func TestParseKnownLayerRegistry(t *testing.T) {
+	hash := CanonicalHash([]byte("x"))
+	layers, err := ParseKnownLayerRegistry([]byte(`{"layers": [{"layer": "git@github.com:org/ci.git", "files": {"Makefile": "`+hash+`"}}]}`), "registry.json")
+	if err != nil || len(layers) != 1 || layers[0].Files["Makefile"] != hash {
+		t.Fatalf("Unexpected result: %+v, %v", layers, err)
+	}
+
+	invalid := []string{
+		`{"layers": [`,
+		`{"layers": [{"files": {"Makefile": "` + hash + `"}}]}`,
+		`{"layers": [{"layer": "git@github.com:org/ci.git"}]}`,
+		`{"layers": [{"layer": "git@github.com:org/ci.git", "files": {"../Makefile": "` + hash + `"}}]}`,
+		`{"layers": [{"layer": "git@github.com:org/ci.git", "files": {"Makefile": "abc"}}]}`,
+	}
+	for _, content := range invalid {
+		if _, err := ParseKnownLayerRegistry([]byte(content), "registry.json"); err == nil {
+			t.Errorf("Expected %s to fail", content)
+		}
+	}
+}
+
+func TestBuiltinKnownLayers(t *testing.T) {
+	names := make(map[string]bool)
+	for _, layer := range BuiltinKnownLayers() {
+		names[layer.Layer] = true
+		if _, ok := layer.Files[LayerMetadataName]; ok {
+			t.Errorf("Expected %s to leave out its metadata", layer.Layer)
+		}
+	}
+
+	// license-mit renders the year and author, so its output can't be recognized by hash
+	if !names["builtin:editorconfig"] || names["builtin:license-mit"] {
+		t.Errorf("Unexpected built-in known layers: %v", names)
+	}
+}
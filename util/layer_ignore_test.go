@@ -498,3 +498,89 @@ func TestIsIgnoredWithPatterns(t *testing.T) {
 		})
 	}
 }
+
+func TestIgnorePatternsMatchPrefixes(t *testing.T) {
+	fileOps := NewFileOperations()
+
+	// Project and layer patterns match any path they are a prefix of
+	patterns := []string{".env", "secret"}
+	for path, expected := range map[string]bool{
+		".env":            true,
+		".env.local":      true,
+		"secrets.yml":     true,
+		"secret/key.pem":  true,
+		"config/.env":     true,
+		"config/settings": false,
+	} {
+		if result := fileOps.isIgnoredWithPatterns(path, patterns); result != expected {
+			t.Errorf("isIgnoredWithPatterns(%s) = %v, expected %v", path, result, expected)
+		}
+	}
+
+	// Built-in patterns only match whole path components
+	for path, expected := range map[string]bool{
+		".git":                     true,
+		".git/hooks/pre-commit":    true,
+		"sub/.git":                 true,
+		".gitignore":               true,
+		".otter/cache":             true,
+		".github/workflows/ci.yml": false,
+		".gitattributes":           false,
+		".gitignore.fragment":      false,
+		".otterrc":                 false,
+	} {
+		if result := fileOps.isProtected(path); result != expected {
+			t.Errorf("isProtected(%s) = %v, expected %v", path, result, expected)
+		}
+	}
+}
+
+func TestAllowProtectedFiles(t *testing.T) {
+	fsys := NewMemFileSystem()
+	files := map[string]string{
+		"/layer/.gitignore":     "bin/\n",
+		"/layer/.git/config":    "[core]",
+		"/layer/.otterignore":   "",
+		"/layer/sub/.gitignore": "*.tmp\n",
+		"/project/.gitignore":   "node_modules/\n",
+	}
+	for path, content := range files {
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	fileOps.AllowProtected = []string{".gitignore"}
+	if err := fileOps.CopyLayer("/layer", "/project", "/project", nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("Failed to copy layer: %v", err)
+	}
+
+	if content, _ := fsys.ReadFile("/project/.gitignore"); string(content) != "bin/\n" {
+		t.Errorf("Expected allowed .gitignore to be copied, got %q", content)
+	}
+	for _, ignored := range []string{"/project/.git", "/project/.otterignore"} {
+		if _, err := fsys.Stat(ignored); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to stay protected", ignored)
+		}
+	}
+
+	protected := 0
+	for _, change := range fileOps.TakeChanges() {
+		if change.Protected {
+			protected++
+		}
+	}
+	if protected != 2 {
+		t.Errorf("Expected 2 protected changes, got %d", protected)
+	}
+
+	for name, valid := range map[string]bool{".gitignore": true, ".otterignore": true, ".git": false, ".otter/": false, ".env": false} {
+		if err := CheckAllowProtected(name); (err == nil) != valid {
+			t.Errorf("CheckAllowProtected(%s): expected valid=%v, got %v", name, valid, err)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// LayerMetadataName is the file a layer can provide at its root to describe itself. It is never
+// copied into the project.
+const LayerMetadataName = ".otter-layer.json"
+
+// LayerMetadata describes a layer to the projects that apply it
+type LayerMetadata struct {
+	// Description says what the layer provides in a sentence, shown by otter list and otter info
+	Description   string         `json:"description,omitempty"`
+	Deprecated    *Deprecation   `json:"deprecated,omitempty"`
+	Compatibility *Compatibility `json:"compatibility,omitempty"`
+}
+
+// Compatibility declares the other layers and the projects a layer works with. Layers are matched
+// by NAME or repository, and patterns may use * and ? like file globs.
+type Compatibility struct {
+	Conflicts []string `json:"conflicts,omitempty"` // Layers it can't be applied together with
+	Layers    []string `json:"layers,omitempty"`    // Layers that must be applied along with it
+	Files     []string `json:"files,omitempty"`     // Files the project must have, e.g. go.mod; globs are allowed
+	Warn      bool     `json:"warn,omitempty"`      // Report problems as warnings instead of failing the build
+}
+
+// LayerRef identifies a layer an Otterfile applies
+type LayerRef struct {
+	Name       string
+	Repository string
+}
+
+// Matches reports whether a compatibility pattern matches the layer's name or repository, with or
+// without a trailing .git
+func (r LayerRef) Matches(pattern string) bool {
+	for _, candidate := range []string{r.Name, r.Repository, strings.TrimSuffix(r.Repository, ".git")} {
+		if candidate == "" {
+			continue
+		}
+		if matched, err := path.Match(pattern, candidate); candidate == pattern || (err == nil && matched) {
+			return true
+		}
+	}
+	return false
+}
+
+// Deprecation marks a layer that is going away, with the layer to use instead
+type Deprecation struct {
+	Message     string `json:"message,omitempty"`
+	Replacement string `json:"replacement,omitempty"` // Repository of the layer that replaces this one
+}
+
+// String describes the deprecation, including the replacement when there is one
+func (d *Deprecation) String() string {
+	text := "deprecated"
+	if d.Message != "" {
+		text += ": " + d.Message
+	}
+	if d.Replacement != "" {
+		text += fmt.Sprintf(" (replace it with %s)", d.Replacement)
+	}
+	return text
+}
+
+// Check returns the problems with applying layer alongside the other applicable layers to the
+// project at projectDir
+func (c *Compatibility) Check(layer LayerRef, layers []LayerRef, projectDir string) []string {
+	var problems []string
+	for _, pattern := range c.Conflicts {
+		for _, other := range layers {
+			if other != layer && other.Matches(pattern) {
+				problems = append(problems, fmt.Sprintf("conflicts with layer %s", other.Repository))
+			}
+		}
+	}
+
+	for _, pattern := range c.Layers {
+		found := false
+		for _, other := range layers {
+			found = found || (other != layer && other.Matches(pattern))
+		}
+		if !found {
+			problems = append(problems, fmt.Sprintf("requires a layer matching %s", pattern))
+		}
+	}
+
+	for _, pattern := range c.Files {
+		if matches, err := filepath.Glob(filepath.Join(projectDir, filepath.FromSlash(pattern))); err != nil || len(matches) == 0 {
+			problems = append(problems, fmt.Sprintf("requires %s in the project", pattern))
+		}
+	}
+	return problems
+}
+
+// LoadLayerMetadata reads the metadata at the root of a layer. Layers without a metadata file
+// have empty metadata.
+func LoadLayerMetadata(layerPath string) (*LayerMetadata, error) {
+	metadata := &LayerMetadata{}
+
+	metadataPath := filepath.Join(layerPath, LayerMetadataName)
+	data, err := os.ReadFile(metadataPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return metadata, nil
+		}
+		return nil, fmt.Errorf("failed to read %s: %w", metadataPath, err)
+	}
+
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", metadataPath, err)
+	}
+	return metadata, nil
+}
+
+// LayerDescription returns a short description of the layer at layerPath: the description in its
+// metadata, or else the first heading of its README, whether or not the README is copied
+func LayerDescription(layerPath string) string {
+	if metadata, err := LoadLayerMetadata(layerPath); err == nil && metadata.Description != "" {
+		return firstLine(metadata.Description)
+	}
+
+	entries, err := os.ReadDir(layerPath)
+	if err != nil {
+		return ""
+	}
+	for _, entry := range entries {
+		name := strings.ToLower(entry.Name())
+		if entry.IsDir() || (name != "readme" && strings.TrimSuffix(name, path.Ext(name)) != "readme") {
+			continue
+		}
+		if content, err := os.ReadFile(filepath.Join(layerPath, entry.Name())); err == nil {
+			return readmeHeading(string(content))
+		}
+	}
+	return ""
+}
+
+// readmeHeading returns the first heading of a README: a line starting with #, or a line
+// underlined with = or -. Fenced code blocks are skipped.
+func readmeHeading(content string) string {
+	lines := strings.Split(strings.ReplaceAll(content, "\r\n", "\n"), "\n")
+	fenced := false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "```") || strings.HasPrefix(line, "~~~") {
+			fenced = !fenced
+			continue
+		}
+		if fenced || line == "" {
+			continue
+		}
+		if heading := strings.TrimLeft(line, "#"); heading != line && (heading == "" || heading[0] == ' ') {
+			return strings.Trim(heading, "# ")
+		}
+		if i+1 < len(lines) {
+			underline := strings.TrimSpace(lines[i+1])
+			if underline != "" && (strings.Trim(underline, "=") == "" || strings.Trim(underline, "-") == "") {
+				return line
+			}
+		}
+	}
+	return ""
+}
+
+// firstLine returns the first line of text, without surrounding space
+func firstLine(text string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(text), "\n")
+	return strings.TrimSpace(line)
+}
@@ -0,0 +1,149 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadLayerMetadata(t *testing.T) {
+	layerDir := t.TempDir()
+
+	metadata, err := LoadLayerMetadata(layerDir)
+	if err != nil {
+		t.Fatalf("Expected missing metadata to be empty: %v", err)
+	}
+	if metadata.Deprecated != nil {
+		t.Errorf("Expected layer without metadata not to be deprecated")
+	}
+
+	content := `{"deprecated": {"message": "use the service layer", "replacement": "git@github.com:example/service.git"}}`
+	if err := os.WriteFile(filepath.Join(layerDir, LayerMetadataName), []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	metadata, err = LoadLayerMetadata(layerDir)
+	if err != nil {
+		t.Fatalf("Failed to load metadata: %v", err)
+	}
+	expected := "deprecated: use the service layer (replace it with git@github.com:example/service.git)"
+	if metadata.Deprecated == nil || metadata.Deprecated.String() != expected {
+		t.Errorf("Expected %q, got %+v", expected, metadata.Deprecated)
+	}
+
+	if err := os.WriteFile(filepath.Join(layerDir, LayerMetadataName), []byte("{"), 0644); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if _, err := LoadLayerMetadata(layerDir); err == nil {
+		t.Error("Expected invalid metadata to fail")
+	}
+}
+
+func TestLayerMetadataNotCopied(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	os.WriteFile(filepath.Join(layerDir, LayerMetadataName), []byte(`{"deprecated": {}}`), 0644)
+	os.WriteFile(filepath.Join(layerDir, "README.md"), []byte("readme"), 0644)
+
+	fileOps := NewFileOperations()
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, LayerMetadataName)); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be copied", LayerMetadataName)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "README.md")); err != nil {
+		t.Errorf("Expected README.md to be copied: %v", err)
+	}
+	if err := CheckAllowProtected(LayerMetadataName); err == nil {
+		t.Errorf("Expected ALLOW %s to be rejected", LayerMetadataName)
+	}
+}
+
+func TestCompatibilityCheck(t *testing.T) {
+	projectDir := t.TempDir()
+	os.WriteFile(filepath.Join(projectDir, "go.mod"), []byte("module example\n"), 0644)
+
+	self := LayerRef{Name: "lint", Repository: "git@github.com:example/golangci.git"}
+	layers := []LayerRef{
+		self,
+		{Repository: "git@github.com:example/eslint.git"},
+		{Name: "ci", Repository: "https://github.com/example/ci"},
+	}
+
+	compatibility := &Compatibility{
+		Conflicts: []string{"git@github.com:example/eslint", "*golangci*"},
+		Layers:    []string{"ci", "docs"},
+		Files:     []string{"go.mod", "*.go"},
+	}
+	problems := compatibility.Check(self, layers, projectDir)
+	expected := []string{
+		"conflicts with layer git@github.com:example/eslint.git",
+		"requires a layer matching docs",
+		"requires *.go in the project",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, problems)
+	}
+	for i := range expected {
+		if problems[i] != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], problems[i])
+		}
+	}
+
+	compatible := &Compatibility{Conflicts: []string{"python"}, Layers: []string{"https://github.com/example/*"}, Files: []string{"go.mod"}}
+	if problems := compatible.Check(self, layers, projectDir); len(problems) != 0 {
+		t.Errorf("Expected no problems, got %v", problems)
+	}
+}
+
+func TestLayerDescription(t *testing.T) {
+	tests := []struct {
+		name     string
+		files    map[string]string
+		expected string
+	}{
+		{"no description", map[string]string{"Makefile": "all:\n"}, ""},
+		{"metadata", map[string]string{
+			LayerMetadataName: `{"description": "Go service defaults\nwith more detail"}`,
+			"README.md":       "# Ignored\n",
+		}, "Go service defaults"},
+		{"README heading", map[string]string{"README.md": "<!-- badge -->\n\n## Go service layer ##\n\n# Later\n"}, "Go service layer"},
+		{"underlined heading", map[string]string{"readme.rst": "Python tooling\n==============\n"}, "Python tooling"},
+		{"code block skipped", map[string]string{"README": "```sh\n# otter build\n```\n# Real heading\n"}, "Real heading"},
+		{"hashtag isn't a heading", map[string]string{"README.md": "#notaheading\n"}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layerDir := t.TempDir()
+			for name, content := range tt.files {
+				os.WriteFile(filepath.Join(layerDir, name), []byte(content), 0644)
+			}
+			if description := LayerDescription(layerDir); description != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, description)
+			}
+		})
+	}
+}
+
+func TestCachedLayerPath(t *testing.T) {
+	cacheDir := t.TempDir()
+	gitOps := NewGitOperations(cacheDir)
+
+	layerPath, err := gitOps.CachedLayerPath("builtin:makefile")
+	if err != nil {
+		t.Fatalf("Failed to prepare built-in layer: %v", err)
+	}
+	if description := LayerDescription(layerPath); description == "" {
+		t.Errorf("Expected built-in layers to have a description")
+	}
+
+	localDir := t.TempDir()
+	if layerPath, err := gitOps.CachedLayerPath(localDir); err != nil || layerPath != localDir {
+		t.Errorf("Expected local layer at %s, got %q (%v)", localDir, layerPath, err)
+	}
+
+	if layerPath, err := gitOps.CachedLayerPath("git@github.com:example/unfetched.git"); err != nil || layerPath != "" {
+		t.Errorf("Expected no path for an unfetched layer, got %q (%v)", layerPath, err)
+	}
+}
@@ -0,0 +1,131 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrUnsafeLayer is returned when a layer has entries that can't be copied safely
+var ErrUnsafeLayer = errors.New("layer can't be copied safely")
+
+// specialFileModes are entries that aren't regular files, directories, or symlinks. On Windows this
+// includes junctions and other reparse points.
+const specialFileModes = os.ModeIrregular | os.ModeDevice | os.ModeCharDevice | os.ModeNamedPipe | os.ModeSocket
+
+// LayerSafetyError lists the entries of a layer that make copying it unsafe
+type LayerSafetyError struct {
+	// Collisions are paths that are copied to destinations differing only in case, which overwrite
+	// each other on macOS and Windows. A path MAP moves is listed with its destination.
+	Collisions [][]string
+	Unsafe     []string // Links out of the layer, junctions, and other special files, with the reason
+}
+
+func (e *LayerSafetyError) Error() string {
+	var problems []string
+	for _, paths := range e.Collisions {
+		problems = append(problems, strings.Join(paths, " and ")+" differ only in case")
+	}
+	problems = append(problems, e.Unsafe...)
+	return fmt.Sprintf("%s: %s", ErrUnsafeLayer, strings.Join(problems, "; "))
+}
+
+func (e *LayerSafetyError) Is(target error) bool {
+	return target == ErrUnsafeLayer
+}
+
+// CheckLayerSafety looks for entries of a layer that can't be copied safely: paths whose destinations,
+// once mapped with MAP, differ only in case, symlinks that lead outside the layer or to directories, and special files such as
+// junctions, devices, and pipes. Entries the rules ignore are never copied, so they aren't checked.
+func (f *FileOperations) CheckLayerSafety(layerPath, targetPath string, rules []IgnoreRule) error {
+	// Only a layer on disk has links to resolve
+	realLayer := ""
+	if f.layerSource == nil {
+		var err error
+		if realLayer, err = realPath(layerPath); err != nil {
+			return err
+		}
+	}
+
+	// Entries by their lowercased destination, and the spellings of that destination
+	byName := make(map[string][]string)
+	spellings := make(map[string]map[string]bool)
+	var unsafe []string
+	err := f.layerFS().Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(layerPath, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if relativePath == "." {
+			return nil
+		}
+		if f.matchingIgnoreRule(relativePath, f.destinationPath(targetPath, relativePath), rules) != nil {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		destination := filepath.ToSlash(f.mappedPath(relativePath))
+		relativePath = filepath.ToSlash(relativePath)
+		name := relativePath
+		if destination != relativePath {
+			name += " (mapped to " + destination + ")"
+		}
+		lower := strings.ToLower(destination)
+		byName[lower] = append(byName[lower], name)
+		if spellings[lower] == nil {
+			spellings[lower] = make(map[string]bool)
+		}
+		spellings[lower][destination] = true
+
+		switch {
+		case info.Mode()&specialFileModes != 0:
+			unsafe = append(unsafe, relativePath+" is a junction, device, pipe, or other special file")
+		case info.Mode()&os.ModeSymlink != 0 && realLayer != "":
+			if reason := linkProblem(srcPath, realLayer); reason != "" {
+				unsafe = append(unsafe, relativePath+" "+reason)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	var collisions [][]string
+	for lower, paths := range byName {
+		// Entries mapped to the very same path merge rather than collide
+		if len(spellings[lower]) > 1 {
+			collisions = append(collisions, paths)
+		}
+	}
+	if len(collisions) == 0 && len(unsafe) == 0 {
+		return nil
+	}
+	sort.Slice(collisions, func(i, j int) bool { return collisions[i][0] < collisions[j][0] })
+	return &LayerSafetyError{Collisions: collisions, Unsafe: unsafe}
+}
+
+// linkProblem describes why a symlink in the layer at realLayer can't be copied, or returns "" when
+// it points to a file inside the layer, whose content is copied
+func linkProblem(linkPath, realLayer string) string {
+	target, err := filepath.EvalSymlinks(linkPath)
+	if err != nil {
+		return "is a broken link"
+	}
+	relative, err := filepath.Rel(realLayer, target)
+	if err != nil || relative == ".." || strings.HasPrefix(relative, ".."+string(filepath.Separator)) {
+		return "links outside the layer"
+	}
+	if info, err := os.Stat(target); err == nil && info.IsDir() {
+		return "links to a directory"
+	}
+	return ""
+}
@@ -0,0 +1,61 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckLayerSafetyCaseCollisions(t *testing.T) {
+	layerDir := t.TempDir()
+	os.WriteFile(filepath.Join(layerDir, "README.md"), []byte("one"), 0644)
+	if err := os.WriteFile(filepath.Join(layerDir, "Readme.md"), []byte("two"), 0644); err != nil {
+		t.Fatalf("Failed to write Readme.md: %v", err)
+	}
+	if entries, _ := os.ReadDir(layerDir); len(entries) < 2 {
+		t.Skip("filesystem is case-insensitive")
+	}
+	os.WriteFile(filepath.Join(layerDir, "notes.txt"), []byte("notes"), 0644)
+
+	targetDir := t.TempDir()
+	fileOps := NewFileOperations()
+	err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{}, true)
+	if !errors.Is(err, ErrUnsafeLayer) || !strings.Contains(err.Error(), "README.md and Readme.md differ only in case") {
+		t.Fatalf("Expected a case collision, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "notes.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be copied from an unsafe layer")
+	}
+
+	// Ignoring one of the files resolves the collision
+	os.WriteFile(filepath.Join(layerDir, ".otterignore"), []byte("Readme.md\n"), 0644)
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{}, true); err != nil {
+		t.Errorf("Expected ignored files not to collide: %v", err)
+	}
+}
+
+func TestCheckLayerSafetyMappedCollisions(t *testing.T) {
+	layerDir := t.TempDir()
+	for _, name := range []string{"readme.md", "docs/README.md", "app/config.yml", "web/Config.yml", "web/nginx.conf"} {
+		path := filepath.Join(layerDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+
+	fileOps := NewFileOperations()
+	fileOps.Map = []PathMapping{{From: "docs/README.md", To: "README.md"}}
+	err := fileOps.CheckLayerSafety(layerDir, t.TempDir(), nil)
+	if !errors.Is(err, ErrUnsafeLayer) || !strings.Contains(err.Error(), "docs/README.md (mapped to README.md) and readme.md differ only in case") {
+		t.Fatalf("Expected destinations differing in case to collide, got %v", err)
+	}
+
+	// Directories mapped to the same path merge, but their files still collide by case
+	fileOps.Map = []PathMapping{{From: "app", To: "conf"}, {From: "web", To: "conf"}}
+	err = fileOps.CheckLayerSafety(layerDir, t.TempDir(), nil)
+	var safetyErr *LayerSafetyError
+	if !errors.As(err, &safetyErr) || len(safetyErr.Collisions) != 1 || !strings.Contains(err.Error(), "app/config.yml (mapped to conf/config.yml) and web/Config.yml (mapped to conf/Config.yml)") {
+		t.Fatalf("Expected only the mapped files to collide, got %v", err)
+	}
+}
@@ -0,0 +1,43 @@
+//go:build linux || darwin || freebsd || netbsd
+
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestCheckLayerSafetyLinks(t *testing.T) {
+	layerDir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret")
+	os.WriteFile(outside, []byte("secret"), 0600)
+	os.WriteFile(filepath.Join(layerDir, "config.yml"), []byte("config"), 0644)
+	os.Mkdir(filepath.Join(layerDir, "docs"), 0755)
+	os.Symlink("config.yml", filepath.Join(layerDir, "config-link.yml"))
+	os.Symlink(outside, filepath.Join(layerDir, "escape"))
+	os.Symlink("docs", filepath.Join(layerDir, "docs-link"))
+	os.Symlink("missing", filepath.Join(layerDir, "broken"))
+	if err := syscall.Mkfifo(filepath.Join(layerDir, "pipe"), 0644); err != nil {
+		t.Fatalf("Failed to create pipe: %v", err)
+	}
+
+	fileOps := NewFileOperations()
+	err := fileOps.CheckLayerSafety(layerDir, t.TempDir(), nil)
+	var safetyErr *LayerSafetyError
+	if !errors.As(err, &safetyErr) {
+		t.Fatalf("Expected a LayerSafetyError, got %v", err)
+	}
+	expected := []string{
+		"broken is a broken link",
+		"docs-link links to a directory",
+		"escape links outside the layer",
+		"pipe is a junction, device, pipe, or other special file",
+	}
+	if strings.Join(safetyErr.Unsafe, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected %v, got %v", expected, safetyErr.Unsafe)
+	}
+}
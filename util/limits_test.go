@@ -0,0 +1,81 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMeasureLayer(t *testing.T) {
+	tempDir := t.TempDir()
+	layerDir := filepath.Join(tempDir, "layer")
+	targetDir := filepath.Join(tempDir, "target")
+
+	files := map[string]string{
+		"a.txt":         "12345",
+		"dir/b.txt":     "123",
+		"ignored.log":   "1234567890",
+		".git/config":   "[core]",
+		".otterignore":  "*.log\n",
+		"existing.conf": "1",
+	}
+	for name, content := range files {
+		path := filepath.Join(layerDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	if err := os.MkdirAll(targetDir, 0755); err != nil {
+		t.Fatalf("Failed to create target: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(targetDir, "existing.conf"), []byte("0"), 0644); err != nil {
+		t.Fatalf("Failed to write existing file: %v", err)
+	}
+
+	stats, err := NewFileOperations().MeasureLayer(layerDir, targetDir)
+	if err != nil {
+		t.Fatalf("Failed to measure layer: %v", err)
+	}
+
+	if stats.Files != 3 {
+		t.Errorf("Expected 3 files, got %d", stats.Files)
+	}
+	if stats.Bytes != 9 {
+		t.Errorf("Expected 9 bytes, got %d", stats.Bytes)
+	}
+	if stats.Overwrites != 1 {
+		t.Errorf("Expected 1 overwrite, got %d", stats.Overwrites)
+	}
+}
+
+func TestApplyLimitsExceeded(t *testing.T) {
+	tests := []struct {
+		name     string
+		limits   ApplyLimitsConfig
+		stats    LayerStats
+		exceeded bool
+	}{
+		{"defaults under limit", ApplyLimitsConfig{}, LayerStats{Files: 10, Bytes: 1024}, false},
+		{"defaults over file limit", ApplyLimitsConfig{}, LayerStats{Files: DefaultMaxFiles + 1}, true},
+		{"defaults over size limit", ApplyLimitsConfig{}, LayerStats{Files: 1, Bytes: (DefaultMaxMegabytes + 1) * 1024 * 1024}, true},
+		{"custom file limit", ApplyLimitsConfig{MaxFiles: 5}, LayerStats{Files: 6}, true},
+		{"custom size limit", ApplyLimitsConfig{MaxMegabytes: 1}, LayerStats{Files: 1, Bytes: 2 * 1024 * 1024}, true},
+		{"disabled limits", ApplyLimitsConfig{MaxFiles: -1, MaxMegabytes: -1}, LayerStats{Files: 1 << 20, Bytes: 1 << 40}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exceeded, reason := tt.limits.Exceeded(tt.stats)
+			if exceeded != tt.exceeded {
+				t.Errorf("Expected exceeded=%v, got %v (%s)", tt.exceeded, exceeded, reason)
+			}
+			if exceeded && reason == "" {
+				t.Error("Expected a reason when limits are exceeded")
+			}
+		})
+	}
+}
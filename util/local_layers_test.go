@@ -45,6 +45,11 @@ func TestIsLocalLayer(t *testing.T) {
 			repoURL:  "C:/path/to/layer",
 			expected: true,
 		},
+		{
+			name:     "Windows UNC path",
+			repoURL:  "\\\\server\\share\\layer",
+			expected: true,
+		},
 		{
 			name:     "Git SSH URL",
 			repoURL:  "git@github.com:user/repo.git",
@@ -390,3 +395,79 @@ func TestLocalLayerIntegration(t *testing.T) {
 		})
 	}
 }
+
+func TestCopyLayerOverlap(t *testing.T) {
+	projectDir := t.TempDir()
+	layerDir := filepath.Join(projectDir, "layers", "basics")
+	if err := os.MkdirAll(layerDir, 0755); err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	os.WriteFile(filepath.Join(layerDir, "README.md"), []byte("readme"), 0644)
+	linkDir := filepath.Join(t.TempDir(), "link")
+	if err := os.Symlink(projectDir, linkDir); err != nil {
+		t.Fatalf("Failed to create symlink: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		layer   string
+		target  string
+		overlap bool
+	}{
+		{"layer inside the project", layerDir, projectDir, false},
+		{"project as its own layer", filepath.Join(projectDir, "."), projectDir, true},
+		{"project through a symlink", linkDir, projectDir, true},
+		{"target inside the layer", projectDir, filepath.Join(projectDir, "layers", "basics", "out"), true},
+		{"sibling with a similar name", layerDir, layerDir + "-copy", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewFileOperations().CopyLayer(tt.layer, tt.target, projectDir, nil, [2]string{"{{", "}}"}, true)
+			if tt.overlap && err == nil {
+				t.Errorf("Expected copying %s to %s to fail", tt.layer, tt.target)
+			}
+			if !tt.overlap && err != nil {
+				t.Errorf("Expected copying %s to %s to succeed: %v", tt.layer, tt.target, err)
+			}
+		})
+	}
+}
+
+func TestFileURIPath(t *testing.T) {
+	tests := []struct {
+		uri       string
+		goos      string
+		expected  string
+		expectErr bool
+	}{
+		{uri: "file:///home/dev/layer", goos: "linux", expected: "/home/dev/layer"},
+		{uri: "file://localhost/home/dev/layer", goos: "darwin", expected: "/home/dev/layer"},
+		{uri: "file:///home/dev/my%20layer", goos: "linux", expected: "/home/dev/my layer"},
+		{uri: "file://server/share/layer", goos: "linux", expectErr: true},
+		{uri: "file:///C:/Users/dev/layer", goos: "windows", expected: `C:\Users\dev\layer`},
+		{uri: "file://localhost/c:/layer", goos: "windows", expected: `c:\layer`},
+		{uri: "file://C:/Users/dev/layer", goos: "windows", expected: `C:\Users\dev\layer`},
+		{uri: "file:///C:/Program%20Files/layer", goos: "windows", expected: `C:\Program Files\layer`},
+		{uri: "file://server/share/layer", goos: "windows", expected: `\\server\share\layer`},
+		{uri: "file:////server/share/layer", goos: "windows", expected: `\\server\share\layer`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.goos+" "+tt.uri, func(t *testing.T) {
+			path, err := fileURIPath(tt.uri, tt.goos)
+			if tt.expectErr {
+				if err == nil {
+					t.Errorf("Expected an error, got %s", path)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fileURIPath failed: %v", err)
+			}
+			if path != tt.expected {
+				t.Errorf("Expected %s, got %s", tt.expected, path)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// LockfileName is the name of the lockfile written next to the Otterfile
+const LockfileName = "Otterfile.lock"
+
+// LockfileVersion is the current lockfile format version
+const LockfileVersion = 1
+
+// Lockfile pins each remote layer to the commit that was last applied
+type Lockfile struct {
+	Version int           `json:"version"`
+	Layers  []LockedLayer `json:"layers"`
+}
+
+// LockedLayer records the commit a layer repository was applied at, or for a snapshotted local
+// layer, the content hash of its snapshot
+type LockedLayer struct {
+	Repository string `json:"repository"`
+	Commit     string `json:"commit,omitempty"`
+	SHA256     string `json:"sha256,omitempty"`
+}
+
+// NewLockfile creates an empty lockfile
+func NewLockfile() *Lockfile {
+	return &Lockfile{
+		Version: LockfileVersion,
+		Layers:  make([]LockedLayer, 0),
+	}
+}
+
+// LoadLockfile reads a lockfile from disk
+func LoadLockfile(path string) (*Lockfile, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read lockfile %s: %w", path, err)
+	}
+
+	lock := NewLockfile()
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("failed to parse lockfile %s: %w", path, err)
+	}
+
+	if lock.Version > LockfileVersion {
+		return nil, fmt.Errorf("lockfile %s has version %d, this otter supports up to %d", path, lock.Version, LockfileVersion)
+	}
+
+	return lock, nil
+}
+
+// Save writes the lockfile to disk with layers sorted by repository for stable diffs
+func (l *Lockfile) Save(path string) error {
+	return l.SaveFS(NewOSFileSystem(), path)
+}
+
+// SaveFS writes the lockfile to fsys with layers sorted by repository for stable diffs
+func (l *Lockfile) SaveFS(fsys FileSystem, path string) error {
+	sort.Slice(l.Layers, func(i, j int) bool {
+		return l.Layers[i].Repository < l.Layers[j].Repository
+	})
+
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode lockfile: %w", err)
+	}
+
+	if err := fsys.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write lockfile %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// Find returns the locked entry for a repository
+func (l *Lockfile) Find(repository string) (LockedLayer, bool) {
+	for _, layer := range l.Layers {
+		if layer.Repository == repository {
+			return layer, true
+		}
+	}
+	return LockedLayer{}, false
+}
+
+// Set records the commit for a repository, replacing any existing entry
+func (l *Lockfile) Set(repository, commit string) {
+	for i, layer := range l.Layers {
+		if layer.Repository == repository {
+			l.Layers[i].Commit = commit
+			return
+		}
+	}
+	l.Layers = append(l.Layers, LockedLayer{Repository: repository, Commit: commit})
+}
+
+// SetSnapshot records the snapshot content hash for a local layer, replacing any existing entry
+func (l *Lockfile) SetSnapshot(repository, hash string) {
+	for i, layer := range l.Layers {
+		if layer.Repository == repository {
+			l.Layers[i] = LockedLayer{Repository: repository, SHA256: hash}
+			return
+		}
+	}
+	l.Layers = append(l.Layers, LockedLayer{Repository: repository, SHA256: hash})
+}
+
+// Tidy removes entries for repositories that aren't referenced and duplicate entries, keeping the
+// first, and returns the removed entries. Save sorts the remaining entries.
+func (l *Lockfile) Tidy(referenced []string) []LockedLayer {
+	keep := make(map[string]bool, len(referenced))
+	for _, repository := range referenced {
+		keep[repository] = true
+	}
+
+	var removed []LockedLayer
+	layers := make([]LockedLayer, 0, len(l.Layers))
+	seen := make(map[string]bool, len(l.Layers))
+	for _, layer := range l.Layers {
+		if !keep[layer.Repository] || seen[layer.Repository] {
+			removed = append(removed, layer)
+			continue
+		}
+		seen[layer.Repository] = true
+		layers = append(layers, layer)
+	}
+	l.Layers = layers
+	return removed
+}
@@ -0,0 +1,80 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLockfileSaveAndLoad(t *testing.T) {
+	lockPath := filepath.Join(t.TempDir(), LockfileName)
+
+	lock := NewLockfile()
+	lock.Set("git@github.com:example/zeta.git", "1111111111111111111111111111111111111111")
+	lock.Set("git@github.com:example/alpha.git", "2222222222222222222222222222222222222222")
+	lock.Set("git@github.com:example/zeta.git", "3333333333333333333333333333333333333333")
+
+	if err := lock.Save(lockPath); err != nil {
+		t.Fatalf("Failed to save lockfile: %v", err)
+	}
+
+	loaded, err := LoadLockfile(lockPath)
+	if err != nil {
+		t.Fatalf("Failed to load lockfile: %v", err)
+	}
+
+	if len(loaded.Layers) != 2 {
+		t.Fatalf("Expected 2 locked layers, got %d", len(loaded.Layers))
+	}
+	if loaded.Layers[0].Repository != "git@github.com:example/alpha.git" {
+		t.Errorf("Expected layers sorted by repository, got %v", loaded.Layers)
+	}
+
+	zeta, ok := loaded.Find("git@github.com:example/zeta.git")
+	if !ok || zeta.Commit != "3333333333333333333333333333333333333333" {
+		t.Errorf("Expected updated commit for zeta, got %+v", zeta)
+	}
+
+	if _, ok := loaded.Find("git@github.com:example/missing.git"); ok {
+		t.Error("Expected missing repository not to be found")
+	}
+}
+
+func TestLoadLockfileErrors(t *testing.T) {
+	tempDir := t.TempDir()
+
+	if _, err := LoadLockfile(filepath.Join(tempDir, "missing.lock")); err == nil {
+		t.Error("Expected error for missing lockfile")
+	}
+
+	futurePath := filepath.Join(tempDir, "future.lock")
+	if err := os.WriteFile(futurePath, []byte(`{"version": 99, "layers": []}`), 0644); err != nil {
+		t.Fatalf("Failed to write lockfile: %v", err)
+	}
+	_, err := LoadLockfile(futurePath)
+	if err == nil || !strings.Contains(err.Error(), "version 99") {
+		t.Errorf("Expected unsupported version error, got %v", err)
+	}
+}
+
+func TestLockfileTidy(t *testing.T) {
+	lock := NewLockfile()
+	lock.Layers = []LockedLayer{
+		{Repository: "git@github.com:example/zeta.git", Commit: "1111111111111111111111111111111111111111"},
+		{Repository: "git@github.com:example/removed.git", Commit: "2222222222222222222222222222222222222222"},
+		{Repository: "git@github.com:example/alpha.git", Commit: "3333333333333333333333333333333333333333"},
+		{Repository: "git@github.com:example/zeta.git", Commit: "4444444444444444444444444444444444444444"},
+	}
+
+	removed := lock.Tidy([]string{"git@github.com:example/alpha.git", "git@github.com:example/zeta.git"})
+	if len(removed) != 2 || removed[0].Repository != "git@github.com:example/removed.git" || removed[1].Commit != "4444444444444444444444444444444444444444" {
+		t.Errorf("Expected the unreferenced and duplicate entries to be removed, got %+v", removed)
+	}
+	if len(lock.Layers) != 2 {
+		t.Fatalf("Expected 2 remaining entries, got %+v", lock.Layers)
+	}
+	if zeta, _ := lock.Find("git@github.com:example/zeta.git"); zeta.Commit != "1111111111111111111111111111111111111111" {
+		t.Errorf("Expected the first zeta entry to be kept, got %+v", zeta)
+	}
+}
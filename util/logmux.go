@@ -0,0 +1,137 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Ways to print the output of layers copied concurrently, set with otter build --log-output
+const (
+	LogOutputBuffered = "buffered" // Each layer's output is printed whole, in declared order (default)
+	LogOutputPrefixed = "prefixed" // Lines are printed as they come, prefixed with their layer
+)
+
+// ResolveLogOutput checks a log output mode, returning the default for an empty one
+func ResolveLogOutput(mode string) (string, error) {
+	switch strings.ToLower(mode) {
+	case "", LogOutputBuffered:
+		return LogOutputBuffered, nil
+	case LogOutputPrefixed:
+		return LogOutputPrefixed, nil
+	default:
+		return "", fmt.Errorf("unknown log output %q (expected buffered or prefixed)", mode)
+	}
+}
+
+// LogMultiplexer gives each of several concurrent writers a stream of its own and combines them
+// into one readable output: either whole streams in order, or prefixed lines
+type LogMultiplexer struct {
+	out  io.Writer
+	mode string
+
+	mu      sync.Mutex
+	streams []*logStream
+	next    int // Buffered mode: the stream printed directly; later streams wait
+}
+
+// logStream is the output of one writer
+type logStream struct {
+	mux     *LogMultiplexer
+	index   int
+	name    string
+	pending bytes.Buffer // Buffered output, or the unfinished line in prefixed mode
+	started bool         // Buffered mode: the heading was written
+	closed  bool
+}
+
+// NewLogMultiplexer creates a multiplexer writing to out with a stream for each name
+func NewLogMultiplexer(out io.Writer, mode string, names []string) *LogMultiplexer {
+	m := &LogMultiplexer{out: out, mode: mode}
+	for i, name := range names {
+		m.streams = append(m.streams, &logStream{mux: m, index: i, name: name})
+	}
+	return m
+}
+
+// Stream returns the writer of stream i
+func (m *LogMultiplexer) Stream(i int) io.Writer {
+	return m.streams[i]
+}
+
+// Close marks stream i finished. In buffered mode, the output of the streams after it that finished
+// already is printed.
+func (m *LogMultiplexer) Close(i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stream := m.streams[i]
+	stream.closed = true
+	if m.mode == LogOutputPrefixed {
+		if stream.pending.Len() > 0 {
+			stream.pending.WriteByte('\n')
+			m.writeLines(stream)
+		}
+		return
+	}
+	for m.next < len(m.streams) && m.streams[m.next].closed {
+		m.next++
+		if m.next < len(m.streams) {
+			next := m.streams[m.next]
+			m.out.Write(next.pending.Bytes())
+			next.pending.Reset()
+		}
+	}
+}
+
+// Flush prints whatever the streams haven't yet, in order, such as the output of streams that
+// were never closed because an earlier layer failed
+func (m *LogMultiplexer) Flush() {
+	for i := range m.streams {
+		m.Close(i)
+	}
+}
+
+func (s *logStream) Write(p []byte) (int, error) {
+	m := s.mux
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.mode == LogOutputPrefixed {
+		s.pending.Write(p)
+		m.writeLines(s)
+		return len(p), nil
+	}
+
+	var data []byte
+	if !s.started {
+		s.started = true
+		data = []byte(fmt.Sprintf("  [%s]\n", s.name))
+	}
+	data = append(data, p...)
+	if s.index == m.next {
+		if _, err := m.out.Write(data); err != nil {
+			return 0, err
+		}
+	} else {
+		s.pending.Write(data)
+	}
+	return len(p), nil
+}
+
+// writeLines prints the complete lines of a stream with its name in front
+func (m *LogMultiplexer) writeLines(s *logStream) {
+	for {
+		line, err := s.pending.ReadBytes('\n')
+		if err != nil {
+			// Keep the unfinished line for the next write
+			rest := append([]byte(nil), line...)
+			s.pending.Reset()
+			s.pending.Write(rest)
+			return
+		}
+		fmt.Fprintf(m.out, "[%s] %s", s.name, line)
+	}
+}
@@ -0,0 +1,65 @@
+package util
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLogMultiplexerBuffered(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewLogMultiplexer(&out, LogOutputBuffered, []string{"a", "b", "c"})
+
+	// Later streams wait for the ones before them, whatever order they finish in
+	fmt.Fprintf(mux.Stream(2), "c1\n")
+	fmt.Fprintf(mux.Stream(1), "b1\n")
+	fmt.Fprintf(mux.Stream(0), "a1\n")
+	if out.String() != "  [a]\na1\n" {
+		t.Errorf("Expected only the first stream to be printed, got %q", out.String())
+	}
+	mux.Close(2)
+	fmt.Fprintf(mux.Stream(1), "b2\n")
+	fmt.Fprintf(mux.Stream(0), "a2\n")
+	mux.Close(0)
+	fmt.Fprintf(mux.Stream(1), "b3\n")
+	mux.Close(1)
+
+	expected := "  [a]\na1\na2\n  [b]\nb1\nb2\nb3\n  [c]\nc1\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestLogMultiplexerPrefixed(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewLogMultiplexer(&out, LogOutputPrefixed, []string{"a", "b"})
+
+	fmt.Fprintf(mux.Stream(1), "b1\nb")
+	fmt.Fprintf(mux.Stream(0), "a1\n")
+	fmt.Fprintf(mux.Stream(1), "2\n")
+	fmt.Fprintf(mux.Stream(0), "unfinished")
+	mux.Flush()
+
+	expected := "[b] b1\n[a] a1\n[b] b2\n[a] unfinished\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestLogMultiplexerFlush(t *testing.T) {
+	var out bytes.Buffer
+	mux := NewLogMultiplexer(&out, LogOutputBuffered, []string{"a", "b"})
+	fmt.Fprintf(mux.Stream(1), "b1\n")
+	mux.Flush()
+	if !strings.Contains(out.String(), "b1") {
+		t.Errorf("Expected Flush to print streams that were never closed, got %q", out.String())
+	}
+
+	if _, err := ResolveLogOutput("json"); err == nil {
+		t.Error("Expected an unknown log output to be rejected")
+	}
+	if mode, err := ResolveLogOutput(""); err != nil || mode != LogOutputBuffered {
+		t.Errorf("Expected buffered by default, got %q (%v)", mode, err)
+	}
+}
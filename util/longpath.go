@@ -0,0 +1,66 @@
+package util
+
+import (
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Windows paths longer than this need the \\?\ prefix. Directories are limited to 12 characters
+// less, leaving room for an 8.3 file name inside them.
+const windowsMaxPath = 260 - 12
+
+// longPathPrefix marks a Windows extended-length path
+const longPathPrefix = `\\?\`
+
+// longPath returns a path the operating system can open regardless of its length. On Windows,
+// paths beyond MAX_PATH are made absolute and clean, since the \\?\ prefix turns off that
+// processing, and prefixed; UNC paths use the \\?\UNC\ form. Other paths are returned unchanged.
+func longPath(name string) string {
+	return longPathFor(name, runtime.GOOS)
+}
+
+// longPathFor is longPath for the given operating system
+func longPathFor(name, goos string) string {
+	if len(name) < windowsMaxPath {
+		return name
+	}
+	return extendedPathFor(name, goos)
+}
+
+// extendedPath returns the \\?\ form of a path on Windows whatever its length, for directories
+// whose contents may exceed MAX_PATH, and the path unchanged elsewhere
+func extendedPath(name string) string {
+	return extendedPathFor(name, runtime.GOOS)
+}
+
+// extendedPathFor is extendedPath for the given operating system
+func extendedPathFor(name, goos string) string {
+	if goos != "windows" || strings.HasPrefix(name, longPathPrefix) {
+		return name
+	}
+
+	if goos == runtime.GOOS && !filepath.IsAbs(name) {
+		if absName, err := filepath.Abs(name); err == nil {
+			name = absName
+		}
+	}
+
+	slashed := strings.ReplaceAll(name, `\`, "/")
+	if strings.HasPrefix(slashed, "//") {
+		// \\server\share\dir becomes \\?\UNC\server\share\dir
+		cleaned := path.Clean(slashed[1:])
+		return longPathPrefix + `UNC` + strings.ReplaceAll(cleaned, "/", `\`)
+	}
+	return longPathPrefix + strings.ReplaceAll(path.Clean(slashed), "/", `\`)
+}
+
+// shortPath removes the \\?\ prefix longPath adds, for paths shown to users or compared with
+// paths given without it
+func shortPath(name string) string {
+	if rest, ok := strings.CutPrefix(name, longPathPrefix+`UNC\`); ok {
+		return `\\` + rest
+	}
+	return strings.TrimPrefix(name, longPathPrefix)
+}
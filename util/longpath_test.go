@@ -0,0 +1,59 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLongPathFor(t *testing.T) {
+	deep := strings.Repeat(`dir\`, 70) + "file.txt"
+
+	tests := []struct {
+		name string
+		path string
+		goos string
+		want string
+	}{
+		{"short path unchanged", `C:\work\file.txt`, "windows", `C:\work\file.txt`},
+		{"long drive path", `C:\` + deep, "windows", `\\?\C:\` + deep},
+		{"long UNC path", `\\server\share\` + deep, "windows", `\\?\UNC\server\share\` + deep},
+		{"dot segments cleaned", `C:\work\..\` + deep, "windows", `\\?\C:\` + deep},
+		{"already prefixed", `\\?\C:\` + deep, "windows", `\\?\C:\` + deep},
+		{"other systems unchanged", "/" + strings.ReplaceAll(deep, `\`, "/"), "linux", "/" + strings.ReplaceAll(deep, `\`, "/")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := longPathFor(tt.path, tt.goos); got != tt.want {
+				t.Errorf("longPathFor(%q) = %q, want %q", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExtendedPathFor(t *testing.T) {
+	if got := extendedPathFor(`C:\work\.\layer`, "windows"); got != `\\?\C:\work\layer` {
+		t.Errorf("extendedPathFor drive path = %q", got)
+	}
+	if got := extendedPathFor(`\\server\share\layer`, "windows"); got != `\\?\UNC\server\share\layer` {
+		t.Errorf("extendedPathFor UNC path = %q", got)
+	}
+	if got := extendedPathFor("/work/layer", "linux"); got != "/work/layer" {
+		t.Errorf("extendedPathFor on linux = %q", got)
+	}
+}
+
+func TestShortPath(t *testing.T) {
+	tests := map[string]string{
+		`\\?\C:\work\layer`:          `C:\work\layer`,
+		`\\?\UNC\server\share\layer`: `\\server\share\layer`,
+		`C:\work\layer`:              `C:\work\layer`,
+		`\\server\share\layer`:       `\\server\share\layer`,
+		"/work/layer":                "/work/layer",
+	}
+	for path, want := range tests {
+		if got := shortPath(path); got != want {
+			t.Errorf("shortPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
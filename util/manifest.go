@@ -0,0 +1,404 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileManifestName is the name of the record of layer-provided files inside .otter
+const FileManifestName = "manifest.json"
+
+// FileManifest records the files each layer wrote into the project, so files a layer stops
+// providing can be found on the next build
+type FileManifest struct {
+	Version int             `json:"version"`
+	Layers  []ManifestLayer `json:"layers"`
+}
+
+// ManifestLayer lists the files a layer applied to a target wrote
+type ManifestLayer struct {
+	Repository string            `json:"repository"`
+	Target     string            `json:"target"`
+	Commit     string            `json:"commit,omitempty"`   // Layer commit the files were written from
+	Template   map[string]string `json:"template,omitempty"` // TEMPLATE values the files were rendered with
+	Delims     []string          `json:"delims,omitempty"`   // Template delimiters, recorded with the values
+	Files      []ManifestFile    `json:"files"`
+}
+
+// ManifestFile is a file written by a layer and the hash of the content written
+type ManifestFile struct {
+	Path      string   `json:"path"` // Relative to the project root
+	SHA256    string   `json:"sha256"`
+	Variables []string `json:"variables,omitempty"` // Template variables a rendered file refers to
+}
+
+// LoadFileManifest reads the manifest, returning an empty one when it doesn't exist yet
+func LoadFileManifest(fsys FileSystem, path string) (*FileManifest, error) {
+	manifest := &FileManifest{Version: 1, Layers: make([]ManifestLayer, 0)}
+
+	data, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest %s: %w", path, err)
+	}
+	return manifest, nil
+}
+
+// Save writes the manifest with layers and files sorted for stable output
+func (m *FileManifest) Save(fsys FileSystem, path string) error {
+	sort.Slice(m.Layers, func(i, j int) bool {
+		if m.Layers[i].Repository != m.Layers[j].Repository {
+			return m.Layers[i].Repository < m.Layers[j].Repository
+		}
+		return m.Layers[i].Target < m.Layers[j].Target
+	})
+	for _, layer := range m.Layers {
+		sort.Slice(layer.Files, func(i, j int) bool { return layer.Files[i].Path < layer.Files[j].Path })
+	}
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %w", err)
+	}
+	if err := fsys.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", path, err)
+	}
+	return nil
+}
+
+// Find returns the recorded files of a layer applied to a target
+func (m *FileManifest) Find(repository, target string) (ManifestLayer, bool) {
+	for _, layer := range m.Layers {
+		if layer.Repository == repository && layer.Target == target {
+			return layer, true
+		}
+	}
+	return ManifestLayer{}, false
+}
+
+// Set replaces the recorded files of a layer
+func (m *FileManifest) Set(layer ManifestLayer) {
+	for i := range m.Layers {
+		if m.Layers[i].Repository == layer.Repository && m.Layers[i].Target == layer.Target {
+			m.Layers[i] = layer
+			return
+		}
+	}
+	m.Layers = append(m.Layers, layer)
+}
+
+// ManifestCheck is the result of comparing the project against the manifest
+type ManifestCheck struct {
+	Checked  int      // Number of recorded files compared
+	Modified []string // Recorded files whose content changed since otter wrote them
+	Missing  []string // Recorded files that no longer exist
+	Extra    []string // Files in a layer's own target directory that no layer wrote
+}
+
+// Drift returns the recorded files that were modified or removed since otter wrote them, relative to root
+func (m *FileManifest) Drift(fsys FileSystem, root string) ([]string, error) {
+	check, err := m.Check(fsys, root)
+	if err != nil {
+		return nil, err
+	}
+	drifted := append(check.Modified, check.Missing...)
+	sort.Strings(drifted)
+	return drifted, nil
+}
+
+// Check recomputes the hash of every recorded file under root and compares it with the manifest.
+// Files in the target directory of a layer that isn't applied to the project root are expected to
+// come from layers, so any other file found there is extra; generated .gitignore files and
+// .otter-new review copies are not counted.
+func (m *FileManifest) Check(fsys FileSystem, root string) (*ManifestCheck, error) {
+	check := &ManifestCheck{}
+	recorded := make(map[string]bool)
+	for _, layer := range m.Layers {
+		for _, file := range layer.Files {
+			recorded[file.Path] = true
+			check.Checked++
+
+			content, err := fsys.ReadFile(filepath.Join(root, filepath.FromSlash(file.Path)))
+			if os.IsNotExist(err) {
+				check.Missing = append(check.Missing, file.Path)
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to read %s: %w", file.Path, err)
+			}
+			if hashContent(content) != file.SHA256 {
+				check.Modified = append(check.Modified, file.Path)
+			}
+		}
+	}
+
+	extra := make(map[string]bool)
+	for _, layer := range m.Layers {
+		if layer.Target == "" || layer.Target == "." {
+			continue
+		}
+		targetPath := filepath.Join(root, filepath.FromSlash(layer.Target))
+		err := fsys.Walk(targetPath, func(path string, info os.FileInfo, err error) error {
+			if os.IsNotExist(err) && path == targetPath {
+				return filepath.SkipDir
+			}
+			if err != nil {
+				return err
+			}
+			if info.IsDir() {
+				if info.Name() == ".git" || info.Name() == ".otter" {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.Name() == ".gitignore" || strings.HasSuffix(info.Name(), ".otter-new") {
+				return nil
+			}
+
+			relativePath, err := filepath.Rel(root, path)
+			if err != nil {
+				return err
+			}
+			if relativePath = filepath.ToSlash(relativePath); !recorded[relativePath] {
+				extra[relativePath] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan %s: %w", layer.Target, err)
+		}
+	}
+	for path := range extra {
+		check.Extra = append(check.Extra, path)
+	}
+
+	sort.Strings(check.Modified)
+	sort.Strings(check.Missing)
+	sort.Strings(check.Extra)
+	return check, nil
+}
+
+// ManifestFiles hashes the written files, given relative to root, for recording in the manifest
+func (f *FileOperations) ManifestFiles(root string, relativePaths []string) ([]ManifestFile, error) {
+	files := make([]ManifestFile, 0, len(relativePaths))
+	for _, relativePath := range relativePaths {
+		content, err := f.FS.ReadFile(filepath.Join(root, relativePath))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", relativePath, err)
+		}
+		files = append(files, ManifestFile{
+			Path:      filepath.ToSlash(relativePath),
+			SHA256:    hashContent(content),
+			Variables: f.templateUsage[filepath.Join(root, relativePath)],
+		})
+	}
+	return files, nil
+}
+
+// RenameSimilarity is the share of lines a locally modified file must have in common with a file
+// newly provided by the same layer for the two to be treated as a rename
+const RenameSimilarity = 0.5
+
+// PruneLayers compares the files each applied layer wrote with what the manifest recorded for it
+// and removes files the layer no longer provides, along with the files of recorded layers that
+// weren't applied, e.g. because they were removed from the Otterfile. Files another applied layer
+// still provides are kept. A file that was modified since otter wrote it is moved to the new path
+// when the layer renamed it, and kept otherwise. With prune unset, orphaned files are only reported
+// and stay in the manifest. The manifest is updated with the applied layers.
+func (f *FileOperations) PruneLayers(root string, manifest *FileManifest, applied []ManifestLayer, prune bool) error {
+	provided := make(map[string]bool)
+	for _, layer := range applied {
+		for _, file := range layer.Files {
+			provided[file.Path] = true
+		}
+	}
+
+	isApplied := make(map[[2]string]bool)
+	for _, layer := range applied {
+		isApplied[[2]string{layer.Repository, layer.Target}] = true
+		if previous, ok := manifest.Find(layer.Repository, layer.Target); ok {
+			orphaned, err := f.pruneFiles(root, layer.Repository, previous.Files, provided, addedFiles(previous.Files, layer.Files), prune)
+			if err != nil {
+				return err
+			}
+			layer.Files = append(layer.Files, orphaned...)
+		}
+		manifest.Set(layer)
+	}
+
+	remaining := make([]ManifestLayer, 0, len(manifest.Layers))
+	for _, layer := range manifest.Layers {
+		if isApplied[[2]string{layer.Repository, layer.Target}] {
+			remaining = append(remaining, layer)
+			continue
+		}
+		fmt.Printf("  Layer no longer applied: %s\n", layer.Repository)
+		orphaned, err := f.pruneFiles(root, layer.Repository, layer.Files, provided, nil, prune)
+		if err != nil {
+			return err
+		}
+		if len(orphaned) > 0 {
+			layer.Files = orphaned
+			remaining = append(remaining, layer)
+		}
+	}
+	manifest.Layers = remaining
+
+	return nil
+}
+
+// pruneFiles removes the recorded files of a layer that no applied layer provides, or moves them
+// to one of the added files the layer renamed them to. It returns the orphaned files left in
+// place because prune is unset, which stay in the manifest.
+func (f *FileOperations) pruneFiles(root, repository string, files []ManifestFile, provided map[string]bool, added map[string]ManifestFile, prune bool) ([]ManifestFile, error) {
+	var orphaned []ManifestFile
+	for _, file := range files {
+		if provided[file.Path] {
+			continue
+		}
+
+		path := filepath.Join(root, filepath.FromSlash(file.Path))
+		content, err := f.FS.ReadFile(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		modified := hashContent(content) != file.SHA256
+
+		switch {
+		case modified && prune:
+			renamed, err := f.moveRenamedFile(root, file, content, added)
+			if err != nil {
+				return nil, err
+			}
+			if !renamed {
+				fmt.Printf("  Keeping modified file no longer provided by %s: %s\n", repository, file.Path)
+			}
+		case modified:
+			fmt.Printf("  Keeping modified file no longer provided by %s: %s\n", repository, file.Path)
+		case !prune:
+			fmt.Printf("  Orphaned (not pruned): %s\n", file.Path)
+			orphaned = append(orphaned, file)
+		default:
+			if err := f.FS.Remove(path); err != nil {
+				return nil, fmt.Errorf("failed to prune %s: %w", path, err)
+			}
+			fmt.Printf("  Pruning: %s\n", path)
+			f.Changes = append(f.Changes, FileChange{Path: path, Action: "delete"})
+		}
+	}
+	return orphaned, nil
+}
+
+// addedFiles returns the files in current that are not in previous
+func addedFiles(previous, current []ManifestFile) map[string]ManifestFile {
+	known := make(map[string]bool, len(previous))
+	for _, file := range previous {
+		known[file.Path] = true
+	}
+	added := make(map[string]ManifestFile)
+	for _, file := range current {
+		if !known[file.Path] {
+			added[file.Path] = file
+		}
+	}
+	return added
+}
+
+// moveRenamedFile moves a locally modified file to the path the layer renamed it to, if one of
+// the added files has the content the old file was written with or is similar to its current
+// content. The layer's fresh copy is replaced by the modified file; when the layer also changed
+// the content, its version is kept next to it with an .otter-new suffix for review.
+func (f *FileOperations) moveRenamedFile(root string, old ManifestFile, content []byte, added map[string]ManifestFile) (bool, error) {
+	var target string
+	var targetContent []byte
+	exact := false
+	bestSimilarity := RenameSimilarity
+
+	for _, candidate := range sortedManifestFiles(added) {
+		candidateContent, err := f.FS.ReadFile(filepath.Join(root, filepath.FromSlash(candidate.Path)))
+		if err != nil {
+			continue
+		}
+		if candidate.SHA256 == old.SHA256 {
+			target, targetContent, exact = candidate.Path, candidateContent, true
+			break
+		}
+		if similarity := lineSimilarity(content, candidateContent); similarity >= bestSimilarity {
+			target, targetContent, bestSimilarity = candidate.Path, candidateContent, similarity
+		}
+	}
+	if target == "" {
+		return false, nil
+	}
+	delete(added, target)
+
+	oldPath := filepath.Join(root, filepath.FromSlash(old.Path))
+	newPath := filepath.Join(root, filepath.FromSlash(target))
+	if !exact {
+		if err := f.FS.WriteFile(newPath+".otter-new", targetContent, 0644); err != nil {
+			return false, fmt.Errorf("failed to keep layer version of %s: %w", newPath, err)
+		}
+		fmt.Printf("  Layer changes to %s were not applied; see %s.otter-new\n", target, target)
+	}
+	if err := f.FS.WriteFile(newPath, content, 0644); err != nil {
+		return false, fmt.Errorf("failed to move %s to %s: %w", oldPath, newPath, err)
+	}
+	if err := f.FS.Remove(oldPath); err != nil {
+		return false, fmt.Errorf("failed to remove %s: %w", oldPath, err)
+	}
+
+	fmt.Printf("  Renaming: %s -> %s (keeping local changes)\n", old.Path, target)
+	f.Changes = append(f.Changes, FileChange{Path: newPath, Action: "rename", From: oldPath})
+	return true, nil
+}
+
+// sortedManifestFiles returns the files of a set ordered by path
+func sortedManifestFiles(files map[string]ManifestFile) []ManifestFile {
+	sorted := make([]ManifestFile, 0, len(files))
+	for _, file := range files {
+		sorted = append(sorted, file)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Path < sorted[j].Path })
+	return sorted
+}
+
+// lineSimilarity returns the share of lines two files have in common, from 0 to 1
+func lineSimilarity(a, b []byte) float64 {
+	linesA := strings.Split(string(a), "\n")
+	linesB := strings.Split(string(b), "\n")
+
+	counts := make(map[string]int, len(linesA))
+	for _, line := range linesA {
+		counts[line]++
+	}
+	common := 0
+	for _, line := range linesB {
+		if counts[line] > 0 {
+			counts[line]--
+			common++
+		}
+	}
+
+	return 2 * float64(common) / float64(len(linesA)+len(linesB))
+}
+
+// hashContent returns the hex SHA-256 of content
+func hashContent(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
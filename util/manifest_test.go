@@ -0,0 +1,279 @@
+package util
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestPruneLayers(t *testing.T) {
+	fsys := NewMemFileSystem()
+	files := map[string]string{
+		"/project/kept.txt":     "kept",
+		"/project/removed.txt":  "removed",
+		"/project/edited.txt":   "edited by hand",
+		"/project/moved.txt":    "moved",
+		"/project/orphaned.txt": "orphaned",
+	}
+	if err := fsys.MkdirAll("/project/.otter", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	for path, content := range files {
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	manifest := &FileManifest{Version: 1}
+	manifest.Set(ManifestLayer{Repository: "base", Target: ".", Files: []ManifestFile{
+		{Path: "kept.txt", SHA256: hashContent([]byte("kept"))},
+		{Path: "removed.txt", SHA256: hashContent([]byte("removed"))},
+		{Path: "edited.txt", SHA256: hashContent([]byte("edited"))},
+		{Path: "moved.txt", SHA256: hashContent([]byte("moved"))},
+		{Path: "gone.txt", SHA256: hashContent([]byte("gone"))},
+	}})
+	manifest.Set(ManifestLayer{Repository: "tools", Target: ".", Files: []ManifestFile{
+		{Path: "orphaned.txt", SHA256: hashContent([]byte("orphaned"))},
+	}})
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	kept, _ := fileOps.ManifestFiles("/project", []string{"kept.txt"})
+	moved, _ := fileOps.ManifestFiles("/project", []string{"moved.txt"})
+	orphaned, _ := fileOps.ManifestFiles("/project", []string{"orphaned.txt"})
+	applied := []ManifestLayer{
+		{Repository: "base", Target: ".", Files: kept},
+		{Repository: "extra", Target: ".", Files: moved},
+		{Repository: "tools", Target: ".", Files: orphaned},
+	}
+	if err := fileOps.PruneLayers("/project", manifest, applied, true); err != nil {
+		t.Fatalf("PruneLayers failed: %v", err)
+	}
+
+	// Only the unmodified file no applied layer provides anymore is removed
+	expected := map[string]bool{"kept.txt": true, "removed.txt": false, "edited.txt": true, "moved.txt": true}
+	for name, exists := range expected {
+		_, err := fsys.Stat("/project/" + name)
+		if exists && err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+		if !exists && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be pruned", name)
+		}
+	}
+	changes := fileOps.TakeChanges()
+	if len(changes) != 1 || changes[0].Action != "delete" {
+		t.Errorf("Expected a single delete change, got %+v", changes)
+	}
+
+	if layer, _ := manifest.Find("base", "."); len(layer.Files) != 1 || layer.Files[0].Path != "kept.txt" {
+		t.Errorf("Expected base layer to record only kept.txt, got %+v", layer.Files)
+	}
+
+	// With pruning disabled orphans are reported and remembered for a later build
+	applied = []ManifestLayer{{Repository: "tools", Target: "."}}
+	if err := fileOps.PruneLayers("/project", manifest, applied, false); err != nil {
+		t.Fatalf("PruneLayers failed: %v", err)
+	}
+	if _, err := fsys.Stat("/project/orphaned.txt"); err != nil {
+		t.Errorf("Expected orphaned.txt to be kept with pruning disabled: %v", err)
+	}
+	if layer, _ := manifest.Find("tools", "."); len(layer.Files) != 1 {
+		t.Errorf("Expected orphaned.txt to stay in the manifest, got %+v", layer.Files)
+	}
+
+	if err := manifest.Save(fsys, "/project/.otter/manifest.json"); err != nil {
+		t.Fatalf("Failed to save manifest: %v", err)
+	}
+	loaded, err := LoadFileManifest(fsys, "/project/.otter/manifest.json")
+	if err != nil {
+		t.Fatalf("Failed to load manifest: %v", err)
+	}
+	if len(loaded.Layers) != 3 || loaded.Layers[0].Repository != "base" {
+		t.Errorf("Unexpected loaded manifest: %+v", loaded.Layers)
+	}
+}
+
+func TestPruneLayersRemoved(t *testing.T) {
+	fsys := NewMemFileSystem()
+	files := map[string]string{
+		"/project/shared.txt":  "shared",
+		"/project/removed.txt": "removed",
+		"/project/edited.txt":  "edited by hand",
+	}
+	if err := fsys.MkdirAll("/project", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	for path, content := range files {
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	newManifest := func() *FileManifest {
+		manifest := &FileManifest{Version: 1}
+		manifest.Set(ManifestLayer{Repository: "tools", Target: ".", Files: []ManifestFile{
+			{Path: "shared.txt", SHA256: hashContent([]byte("shared"))},
+			{Path: "removed.txt", SHA256: hashContent([]byte("removed"))},
+			{Path: "edited.txt", SHA256: hashContent([]byte("edited"))},
+		}})
+		return manifest
+	}
+	fileOps := NewFileOperationsWithFS(fsys)
+	shared, _ := fileOps.ManifestFiles("/project", []string{"shared.txt"})
+	applied := []ManifestLayer{{Repository: "base", Target: ".", Files: shared}}
+
+	// With pruning disabled the files of a layer that is no longer applied are only reported
+	manifest := newManifest()
+	if err := fileOps.PruneLayers("/project", manifest, applied, false); err != nil {
+		t.Fatalf("PruneLayers failed: %v", err)
+	}
+	if layer, ok := manifest.Find("tools", "."); !ok || len(layer.Files) != 1 || layer.Files[0].Path != "removed.txt" {
+		t.Errorf("Expected removed.txt to stay in the manifest, got %+v", layer.Files)
+	}
+	if _, err := fsys.Stat("/project/removed.txt"); err != nil {
+		t.Errorf("Expected removed.txt to be kept with pruning disabled: %v", err)
+	}
+
+	manifest = newManifest()
+	if err := fileOps.PruneLayers("/project", manifest, applied, true); err != nil {
+		t.Fatalf("PruneLayers failed: %v", err)
+	}
+	expected := map[string]bool{"shared.txt": true, "removed.txt": false, "edited.txt": true}
+	for name, exists := range expected {
+		_, err := fsys.Stat("/project/" + name)
+		if exists && err != nil {
+			t.Errorf("Expected %s to be kept: %v", name, err)
+		}
+		if !exists && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be pruned", name)
+		}
+	}
+	if _, ok := manifest.Find("tools", "."); ok || len(manifest.Layers) != 1 {
+		t.Errorf("Expected the removed layer to leave the manifest, got %+v", manifest.Layers)
+	}
+}
+
+func TestPruneLayersRenames(t *testing.T) {
+	fsys := NewMemFileSystem()
+	original := "line one\nline two\nline three\nline four\n"
+	edited := "line one\nline two changed\nline three\nline four\n"
+	updated := "line one\nline two\nline three\nline four\nline five\n"
+	files := map[string]string{
+		"/project/old.txt":       edited,
+		"/project/new.txt":       original,
+		"/project/before.txt":    edited,
+		"/project/after.txt":     updated,
+		"/project/unrelated.txt": "something else entirely",
+	}
+	if err := fsys.MkdirAll("/project", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	for path, content := range files {
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	manifest := &FileManifest{Version: 1}
+	manifest.Set(ManifestLayer{Repository: "base", Target: ".", Files: []ManifestFile{
+		{Path: "old.txt", SHA256: hashContent([]byte(original))},
+		{Path: "before.txt", SHA256: hashContent([]byte(original))},
+	}})
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	provided, _ := fileOps.ManifestFiles("/project", []string{"after.txt", "new.txt", "unrelated.txt"})
+	applied := []ManifestLayer{{Repository: "base", Target: ".", Files: provided}}
+	if err := fileOps.PruneLayers("/project", manifest, applied, true); err != nil {
+		t.Fatalf("PruneLayers failed: %v", err)
+	}
+
+	// The edits move to the renamed paths and the old paths are removed
+	for _, name := range []string{"old.txt", "before.txt"} {
+		if _, err := fsys.Stat("/project/" + name); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be moved", name)
+		}
+	}
+	for _, name := range []string{"new.txt", "after.txt"} {
+		content, err := fsys.ReadFile("/project/" + name)
+		if err != nil || string(content) != edited {
+			t.Errorf("Expected %s to keep local edits, got %q (%v)", name, content, err)
+		}
+	}
+
+	// A rename the layer also changed keeps the layer's version for review
+	if content, err := fsys.ReadFile("/project/after.txt.otter-new"); err != nil || string(content) != updated {
+		t.Errorf("Expected layer version in after.txt.otter-new, got %q (%v)", content, err)
+	}
+	if _, err := fsys.Stat("/project/new.txt.otter-new"); !os.IsNotExist(err) {
+		t.Errorf("Expected no .otter-new file for an unchanged rename")
+	}
+
+	changes := fileOps.TakeChanges()
+	if len(changes) != 2 || changes[0].Action != "rename" || changes[0].From == "" {
+		t.Errorf("Expected two rename changes, got %+v", changes)
+	}
+}
+
+func TestFileManifestDrift(t *testing.T) {
+	fsys := NewMemFileSystem()
+	if err := fsys.MkdirAll("/project", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	fsys.WriteFile("/project/same.txt", []byte("same"), 0644)
+	fsys.WriteFile("/project/edited.txt", []byte("edited by hand"), 0644)
+
+	manifest := &FileManifest{Version: 1}
+	manifest.Set(ManifestLayer{Repository: "base", Target: ".", Files: []ManifestFile{
+		{Path: "same.txt", SHA256: hashContent([]byte("same"))},
+		{Path: "edited.txt", SHA256: hashContent([]byte("edited"))},
+		{Path: "removed.txt", SHA256: hashContent([]byte("removed"))},
+	}})
+
+	drifted, err := manifest.Drift(fsys, "/project")
+	if err != nil {
+		t.Fatalf("Drift failed: %v", err)
+	}
+	if len(drifted) != 2 || drifted[0] != "edited.txt" || drifted[1] != "removed.txt" {
+		t.Errorf("Expected edited.txt and removed.txt to drift, got %v", drifted)
+	}
+}
+
+func TestFileManifestCheck(t *testing.T) {
+	fsys := NewMemFileSystem()
+	if err := fsys.MkdirAll("/project/config/.git", 0755); err != nil {
+		t.Fatalf("Failed to create project: %v", err)
+	}
+	fsys.WriteFile("/project/README.md", []byte("readme"), 0644)
+	fsys.WriteFile("/project/main.go", []byte("package main"), 0644)
+	fsys.WriteFile("/project/config/app.yml", []byte("changed"), 0644)
+	fsys.WriteFile("/project/config/local.yml", []byte("added by hand"), 0644)
+	fsys.WriteFile("/project/config/.gitignore", []byte("*.log"), 0644)
+	fsys.WriteFile("/project/config/.git/HEAD", []byte("ref"), 0644)
+
+	manifest := &FileManifest{Version: 1}
+	manifest.Set(ManifestLayer{Repository: "base", Target: ".", Files: []ManifestFile{
+		{Path: "README.md", SHA256: hashContent([]byte("readme"))},
+	}})
+	manifest.Set(ManifestLayer{Repository: "config", Target: "config", Files: []ManifestFile{
+		{Path: "config/app.yml", SHA256: hashContent([]byte("app"))},
+		{Path: "config/db.yml", SHA256: hashContent([]byte("db"))},
+	}})
+
+	check, err := manifest.Check(fsys, "/project")
+	if err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if check.Checked != 3 {
+		t.Errorf("Expected 3 files checked, got %d", check.Checked)
+	}
+	if !reflect.DeepEqual(check.Modified, []string{"config/app.yml"}) {
+		t.Errorf("Unexpected modified files: %v", check.Modified)
+	}
+	if !reflect.DeepEqual(check.Missing, []string{"config/db.yml"}) {
+		t.Errorf("Unexpected missing files: %v", check.Missing)
+	}
+	// Files outside layer target directories, like main.go, aren't extra
+	if !reflect.DeepEqual(check.Extra, []string{"config/local.yml"}) {
+		t.Errorf("Unexpected extra files: %v", check.Extra)
+	}
+}
@@ -0,0 +1,66 @@
+package util
+
+import (
+	"path"
+	"path/filepath"
+)
+
+// MergeDriver combines a file contributed by a layer with the copy already in the target,
+// for files where several layers are expected to contribute entries
+type MergeDriver interface {
+	// Name identifies the driver in build output
+	Name() string
+	// Match reports whether the driver handles a path relative to the layer target, using forward slashes
+	Match(relativePath string) bool
+	// Merge returns the combined content and a description of each conflict it had to resolve
+	Merge(existing, incoming []byte) (merged []byte, conflicts []string, err error)
+}
+
+// mergeDrivers are consulted in order when a layer file already exists in the target
+var mergeDrivers = []MergeDriver{
+	toolVersionsDriver{},
+	miseDriver{},
+	vscodeExtensionsDriver{},
+	workflowDriver{},
+}
+
+// contentMatcher is implemented by drivers that only merge some of the files matching their path
+type contentMatcher interface {
+	// MatchContent reports whether the layer's version of the file should be merged
+	MatchContent(incoming []byte) bool
+}
+
+// FindMergeDriver returns the driver for a path relative to the layer target, or nil when the
+// file should be overwritten
+func FindMergeDriver(relativePath string) MergeDriver {
+	relativePath = filepath.ToSlash(relativePath)
+	for _, driver := range mergeDrivers {
+		if driver.Match(relativePath) {
+			return driver
+		}
+	}
+	return nil
+}
+
+// mergeDriverFor returns the driver that merges the layer file at srcPath into the target, or nil
+func (f *FileOperations) mergeDriverFor(relativePath, srcPath string) MergeDriver {
+	driver := FindMergeDriver(relativePath)
+	if matcher, ok := driver.(contentMatcher); ok {
+		content, err := f.layerFS().ReadFile(srcPath)
+		if err != nil || !matcher.MatchContent(content) {
+			return nil
+		}
+	}
+	return driver
+}
+
+// matchBase reports whether the final element of a slash-separated path matches any of the patterns
+func matchBase(relativePath string, patterns ...string) bool {
+	base := path.Base(relativePath)
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, base); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+package util
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFindMergeDriver(t *testing.T) {
+	tests := map[string]string{
+		".tool-versions":              "tool-versions",
+		"backend/.tool-versions":      "tool-versions",
+		"mise.toml":                   "mise",
+		".mise.toml":                  "mise",
+		".vscode/extensions.json":     "vscode-extensions",
+		"web/.vscode/extensions.json": "vscode-extensions",
+		"extensions.json":             "",
+		".github/workflows/ci.yml":    "github-workflow",
+		".github/workflows/ci.yaml":   "github-workflow",
+		".github/ci.yml":              "",
+		"README.md":                   "",
+		"tool-versions/README.md":     "",
+	}
+
+	for relativePath, expected := range tests {
+		driver := FindMergeDriver(relativePath)
+		name := ""
+		if driver != nil {
+			name = driver.Name()
+		}
+		if name != expected {
+			t.Errorf("%s: expected driver %q, got %q", relativePath, expected, name)
+		}
+	}
+}
+
+func TestToolVersionsMerge(t *testing.T) {
+	existing := "# project tools\ngolang 1.21.5\nnodejs 18.19.0\npython 3.11.7\n"
+	incoming := "golang 1.22.1\nnodejs 20.11.0\nterraform 1.7.0\npython 3.11.7\n"
+
+	merged, conflicts, err := toolVersionsDriver{}.Merge([]byte(existing), []byte(incoming))
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	expected := "# project tools\ngolang 1.22.1\nnodejs 18.19.0\npython 3.11.7\nterraform 1.7.0\n"
+	if string(merged) != expected {
+		t.Errorf("Unexpected merge result:\n%s", merged)
+	}
+	if len(conflicts) != 1 || !strings.Contains(conflicts[0], "nodejs") {
+		t.Errorf("Expected a nodejs conflict, got %v", conflicts)
+	}
+}
+
+func TestMiseMerge(t *testing.T) {
+	existing := `[env]
+NODE_ENV = "development"
+
+[tools]
+go = "1.21"
+node = "20.1"
+
+[tasks.test]
+run = "go test ./..."
+`
+	incoming := `[tools]
+go = "1.22"
+"npm:prettier" = "3.2"
+
+[env]
+CI = "true"
+
+[settings]
+experimental = true
+`
+
+	merged, conflicts, err := miseDriver{}.Merge([]byte(existing), []byte(incoming))
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	expected := `[env]
+NODE_ENV = "development"
+
+[tools]
+go = "1.22"
+node = "20.1"
+"npm:prettier" = "3.2"
+
+[tasks.test]
+run = "go test ./..."
+
+[settings]
+experimental = true
+`
+	if string(merged) != expected {
+		t.Errorf("Unexpected merge result:\n%s", merged)
+	}
+	if len(conflicts) != 1 || !strings.Contains(conflicts[0], "[env]") {
+		t.Errorf("Expected an [env] conflict, got %v", conflicts)
+	}
+}
+
+func TestVSCodeExtensionsMerge(t *testing.T) {
+	existing := `{
+  // Project recommendations
+  "recommendations": ["golang.go", "eamodio.gitlens",],
+  "unwantedRecommendations": ["ms-vscode.cpptools"]
+}`
+	incoming := `{
+  /* Shared editor layer */
+  "recommendations": ["esbenp.prettier-vscode", "golang.go", "ms-vscode.cpptools"]
+}`
+
+	merged, conflicts, err := vscodeExtensionsDriver{}.Merge([]byte(existing), []byte(incoming))
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	expected := `{
+  "recommendations": [
+    "eamodio.gitlens",
+    "esbenp.prettier-vscode",
+    "golang.go",
+    "ms-vscode.cpptools"
+  ],
+  "unwantedRecommendations": [
+    "ms-vscode.cpptools"
+  ]
+}
+`
+	if string(merged) != expected {
+		t.Errorf("Unexpected merge result:\n%s", merged)
+	}
+	if len(conflicts) != 1 || !strings.Contains(conflicts[0], "ms-vscode.cpptools") {
+		t.Errorf("Expected a cpptools conflict, got %v", conflicts)
+	}
+
+	if _, _, err := (vscodeExtensionsDriver{}).Merge([]byte("{"), []byte("{}")); err == nil {
+		t.Error("Expected error for invalid JSON")
+	}
+}
+
+func TestStripJSONComments(t *testing.T) {
+	input := `{"url": "https://example.com/*x*/", // comment
+  "list": [1, 2, ], /* block */ "n": 1,
+}`
+	expected := `{"url": "https://example.com/*x*/", 
+  "list": [1, 2 ],  "n": 1
+}`
+	if got := string(stripJSONComments([]byte(input))); got != expected {
+		t.Errorf("Unexpected output:\n%s", got)
+	}
+}
+
+func TestWorkflowMerge(t *testing.T) {
+	existing := `name: CI
+on: [push]
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      # otter:anchor test-steps
+      - run: go test ./...
+  # otter:anchor jobs
+`
+	incoming := `# Contributed by the lint layer
+# otter:insert test-steps
+- name: Lint
+  run: golangci-lint run
+
+# otter:insert jobs
+lint:
+  runs-on: ubuntu-latest
+  steps:
+    - uses: actions/checkout@v4
+# otter:insert deploy
+release:
+  runs-on: ubuntu-latest
+`
+
+	driver := workflowDriver{}
+	if !driver.MatchContent([]byte(incoming)) {
+		t.Fatal("Expected insert blocks to be detected")
+	}
+	if driver.MatchContent([]byte(existing)) {
+		t.Error("Expected a complete workflow not to be merged")
+	}
+
+	merged, conflicts, err := driver.Merge([]byte(existing), []byte(incoming))
+	if err != nil {
+		t.Fatalf("Merge failed: %v", err)
+	}
+
+	expected := `name: CI
+on: [push]
+jobs:
+  test:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+      # otter:begin test-steps
+      - name: Lint
+        run: golangci-lint run
+      # otter:end test-steps
+      # otter:anchor test-steps
+      - run: go test ./...
+  # otter:begin jobs
+  lint:
+    runs-on: ubuntu-latest
+    steps:
+      - uses: actions/checkout@v4
+  # otter:end jobs
+  # otter:anchor jobs
+`
+	if string(merged) != expected {
+		t.Errorf("Unexpected merge result:\n%s", merged)
+	}
+	if len(conflicts) != 1 || !strings.Contains(conflicts[0], "deploy") {
+		t.Errorf("Expected a missing anchor conflict, got %v", conflicts)
+	}
+
+	// Merging the same blocks again leaves the workflow unchanged
+	again, _, err := driver.Merge(merged, []byte(incoming))
+	if err != nil {
+		t.Fatalf("Second merge failed: %v", err)
+	}
+	if string(again) != expected {
+		t.Errorf("Expected repeated merge to be idempotent:\n%s", again)
+	}
+}
+
+func TestCopyLayerReplacesCompleteWorkflow(t *testing.T) {
+	fsys := NewMemFileSystem()
+	for _, dir := range []string{"/layer/.github/workflows", "/project/.github/workflows"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := fsys.WriteFile("/layer/.github/workflows/ci.yml", []byte("name: Layer CI\n"), 0644); err != nil {
+		t.Fatalf("Failed to write layer workflow: %v", err)
+	}
+	if err := fsys.WriteFile("/project/.github/workflows/ci.yml", []byte("name: CI\n"), 0644); err != nil {
+		t.Fatalf("Failed to write project workflow: %v", err)
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	conflicts, err := fileOps.DetectConflicts("/layer", "/project")
+	if err != nil {
+		t.Fatalf("DetectConflicts failed: %v", err)
+	}
+	if len(conflicts) != 1 {
+		t.Errorf("Expected a workflow without insert blocks to be reported as an overwrite, got %v", conflicts)
+	}
+}
+
+func TestPickToolVersion(t *testing.T) {
+	tests := []struct {
+		existing, incoming, expected string
+		conflict                     bool
+	}{
+		{"1.21", "1.22", "1.22", false},
+		{"1.22.3", "1.22", "1.22.3", false},
+		{"18.19.0", "20.11.0", "18.19.0", true},
+		{"latest", "1.22", "latest", true},
+		{"system", "system", "system", false},
+	}
+
+	for _, tt := range tests {
+		version, conflict := pickToolVersion("tool", tt.existing, tt.incoming)
+		if version != tt.expected || (conflict != "") != tt.conflict {
+			t.Errorf("pickToolVersion(%s, %s) = %s, %q", tt.existing, tt.incoming, version, conflict)
+		}
+	}
+}
+
+func TestCopyLayerMergesFiles(t *testing.T) {
+	fsys := NewMemFileSystem()
+	for _, dir := range []string{"/layers/go", "/layers/node", "/project"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := fsys.WriteFile("/layers/go/.tool-versions", []byte("golang 1.22.1\n"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+	if err := fsys.WriteFile("/layers/node/.tool-versions", []byte("nodejs 20.11.0\n"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	delims := [2]string{"{{", "}}"}
+	if err := fileOps.CopyLayer("/layers/go", "/project", "/project", nil, delims, false); err != nil {
+		t.Fatalf("Failed to copy go layer: %v", err)
+	}
+
+	// The second layer merges without prompting because the file has a merge driver
+	if err := fileOps.CopyLayer("/layers/node", "/project", "/project", nil, delims, false); err != nil {
+		t.Fatalf("Failed to copy node layer: %v", err)
+	}
+
+	content, err := fsys.ReadFile("/project/.tool-versions")
+	if err != nil {
+		t.Fatalf("Failed to read merged file: %v", err)
+	}
+	if string(content) != "golang 1.22.1\nnodejs 20.11.0\n" {
+		t.Errorf("Unexpected merged content:\n%s", content)
+	}
+
+	changes := fileOps.TakeChanges()
+	if len(changes) != 2 || changes[1].Action != "merge" {
+		t.Errorf("Expected create then merge, got %+v", changes)
+	}
+}
@@ -0,0 +1,244 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// toolVersionsDriver merges asdf/mise .tool-versions files
+type toolVersionsDriver struct{}
+
+func (toolVersionsDriver) Name() string { return "tool-versions" }
+
+func (toolVersionsDriver) Match(relativePath string) bool {
+	return matchBase(relativePath, ".tool-versions")
+}
+
+// Merge keeps the project's lines and order, updating tools both files list and appending new ones
+func (toolVersionsDriver) Merge(existing, incoming []byte) ([]byte, []string, error) {
+	type entry struct {
+		tool     string
+		versions []string
+	}
+
+	parse := func(content []byte) []entry {
+		var entries []entry
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for scanner.Scan() {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			fields := strings.Fields(strings.SplitN(line, "#", 2)[0])
+			entries = append(entries, entry{tool: fields[0], versions: fields[1:]})
+		}
+		return entries
+	}
+
+	incomingEntries := parse(incoming)
+	incomingVersions := make(map[string][]string)
+	for _, e := range incomingEntries {
+		incomingVersions[e.tool] = e.versions
+	}
+
+	var conflicts []string
+	var out strings.Builder
+	seen := make(map[string]bool)
+
+	scanner := bufio.NewScanner(bytes.NewReader(existing))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		fields := strings.Fields(strings.SplitN(trimmed, "#", 2)[0])
+		tool := fields[0]
+		seen[tool] = true
+
+		versions, ok := incomingVersions[tool]
+		if !ok || len(versions) == 0 || len(fields) < 2 {
+			out.WriteString(line + "\n")
+			continue
+		}
+
+		version, conflict := pickToolVersion(tool, fields[1], versions[0])
+		if conflict != "" {
+			conflicts = append(conflicts, conflict)
+		}
+		if version == fields[1] {
+			out.WriteString(line + "\n")
+		} else {
+			out.WriteString(strings.Join(append([]string{tool}, versions...), " ") + "\n")
+		}
+	}
+
+	for _, e := range incomingEntries {
+		if !seen[e.tool] {
+			seen[e.tool] = true
+			out.WriteString(strings.Join(append([]string{e.tool}, e.versions...), " ") + "\n")
+		}
+	}
+
+	return []byte(out.String()), conflicts, nil
+}
+
+// miseDriver merges the [tools] table of mise.toml files
+type miseDriver struct{}
+
+func (miseDriver) Name() string { return "mise" }
+
+func (miseDriver) Match(relativePath string) bool {
+	return matchBase(relativePath, "mise.toml", ".mise.toml")
+}
+
+var (
+	tomlTablePattern = regexp.MustCompile(`^\s*\[([^\[\]]+)\]\s*$`)
+	tomlToolPattern  = regexp.MustCompile(`^\s*("?[\w@:/.-]+"?)\s*=\s*"([^"]*)"`)
+)
+
+// Merge updates and extends the project's [tools] table with the layer's tools. Other tables from
+// the layer are appended when the project doesn't define them, and reported as conflicts when it does.
+func (miseDriver) Merge(existing, incoming []byte) ([]byte, []string, error) {
+	incomingTables, order := splitTOMLTables(incoming)
+	existingTables, existingOrder := splitTOMLTables(existing)
+
+	incomingTools := make(map[string]string)
+	incomingKeys := make(map[string]string) // Keys as written, which may be quoted
+	var incomingToolOrder []string
+	for _, line := range incomingTables["tools"] {
+		if match := tomlToolPattern.FindStringSubmatch(line); match != nil {
+			tool := strings.Trim(match[1], `"`)
+			incomingTools[tool] = match[2]
+			incomingKeys[tool] = match[1]
+			incomingToolOrder = append(incomingToolOrder, tool)
+		}
+	}
+
+	var conflicts []string
+	seen := make(map[string]bool)
+	var tools []string
+	for _, line := range existingTables["tools"] {
+		match := tomlToolPattern.FindStringSubmatch(line)
+		if match == nil {
+			tools = append(tools, line)
+			continue
+		}
+
+		tool := strings.Trim(match[1], `"`)
+		seen[tool] = true
+		incomingVersion, ok := incomingTools[tool]
+		if !ok {
+			tools = append(tools, line)
+			continue
+		}
+
+		version, conflict := pickToolVersion(tool, match[2], incomingVersion)
+		if conflict != "" {
+			conflicts = append(conflicts, conflict)
+		}
+		tools = append(tools, strings.Replace(line, `"`+match[2]+`"`, `"`+version+`"`, 1))
+	}
+
+	// Keep new tools ahead of any blank lines that separate the table from the next one
+	trailing := 0
+	for trailing < len(tools) && strings.TrimSpace(tools[len(tools)-1-trailing]) == "" {
+		trailing++
+	}
+	blanks := append([]string(nil), tools[len(tools)-trailing:]...)
+	tools = tools[:len(tools)-trailing]
+	for _, tool := range incomingToolOrder {
+		if !seen[tool] {
+			seen[tool] = true
+			tools = append(tools, fmt.Sprintf("%s = %q", incomingKeys[tool], incomingTools[tool]))
+		}
+	}
+	tools = append(tools, blanks...)
+
+	if _, ok := existingTables["tools"]; !ok && len(incomingToolOrder) > 0 {
+		existingOrder = append(existingOrder, "tools")
+	}
+	existingTables["tools"] = tools
+
+	for _, table := range order {
+		if table == "" || table == "tools" {
+			continue
+		}
+		if _, ok := existingTables[table]; ok {
+			conflicts = append(conflicts, fmt.Sprintf("[%s] is defined by both the project and the layer; keeping the project's", table))
+			continue
+		}
+		existingTables[table] = incomingTables[table]
+		existingOrder = append(existingOrder, table)
+	}
+
+	var out strings.Builder
+	for _, table := range existingOrder {
+		lines := existingTables[table]
+		if table != "" {
+			if out.Len() > 0 && !strings.HasSuffix(out.String(), "\n\n") {
+				out.WriteString("\n")
+			}
+			out.WriteString("[" + table + "]\n")
+		}
+		for _, line := range lines {
+			out.WriteString(line + "\n")
+		}
+	}
+
+	return []byte(out.String()), conflicts, nil
+}
+
+// splitTOMLTables groups the lines of a TOML document by table, using "" for lines before the
+// first table header. It returns the tables and their order of appearance.
+func splitTOMLTables(content []byte) (map[string][]string, []string) {
+	tables := map[string][]string{"": nil}
+	order := []string{""}
+	current := ""
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := tomlTablePattern.FindStringSubmatch(line); match != nil {
+			current = strings.TrimSpace(match[1])
+			if _, ok := tables[current]; !ok {
+				order = append(order, current)
+			}
+			continue
+		}
+		tables[current] = append(tables[current], line)
+	}
+
+	return tables, order
+}
+
+var numericVersionPattern = regexp.MustCompile(`^v?\d+(\.\d+)*$`)
+
+// pickToolVersion chooses between the project's version of a tool and one contributed by a layer.
+// The highest version wins when both share a major version; otherwise the project's version is kept
+// and the conflict is described.
+func pickToolVersion(tool, existing, incoming string) (string, string) {
+	if existing == incoming {
+		return existing, ""
+	}
+
+	if !numericVersionPattern.MatchString(existing) || !numericVersionPattern.MatchString(incoming) {
+		return existing, fmt.Sprintf("%s: keeping %s, layer requested %s", tool, existing, incoming)
+	}
+
+	existingMajor := strings.SplitN(strings.TrimPrefix(existing, "v"), ".", 2)[0]
+	incomingMajor := strings.SplitN(strings.TrimPrefix(incoming, "v"), ".", 2)[0]
+	if existingMajor != incomingMajor {
+		return existing, fmt.Sprintf("%s: keeping %s, layer requested incompatible %s", tool, existing, incoming)
+	}
+
+	if CompareVersions(incoming, existing) > 0 {
+		return incoming, ""
+	}
+	return existing, ""
+}
@@ -0,0 +1,127 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// vscodeExtensionsDriver merges the extension recommendations of .vscode/extensions.json files
+type vscodeExtensionsDriver struct{}
+
+func (vscodeExtensionsDriver) Name() string { return "vscode-extensions" }
+
+func (vscodeExtensionsDriver) Match(relativePath string) bool {
+	return path.Base(relativePath) == "extensions.json" && path.Base(path.Dir(relativePath)) == ".vscode"
+}
+
+// Merge combines the recommendations and unwantedRecommendations arrays, deduplicated and sorted.
+// Other keys keep the project's value.
+func (vscodeExtensionsDriver) Merge(existing, incoming []byte) ([]byte, []string, error) {
+	var existingDoc, incomingDoc map[string]interface{}
+	if err := json.Unmarshal(stripJSONComments(existing), &existingDoc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse existing extensions.json: %w", err)
+	}
+	if err := json.Unmarshal(stripJSONComments(incoming), &incomingDoc); err != nil {
+		return nil, nil, fmt.Errorf("failed to parse layer extensions.json: %w", err)
+	}
+	if existingDoc == nil {
+		existingDoc = make(map[string]interface{})
+	}
+
+	for _, key := range []string{"recommendations", "unwantedRecommendations"} {
+		ids := make(map[string]bool)
+		for _, doc := range []map[string]interface{}{existingDoc, incomingDoc} {
+			values, _ := doc[key].([]interface{})
+			for _, value := range values {
+				if id, ok := value.(string); ok {
+					ids[id] = true
+				}
+			}
+		}
+		if len(ids) == 0 {
+			continue
+		}
+
+		merged := make([]string, 0, len(ids))
+		for id := range ids {
+			merged = append(merged, id)
+		}
+		sort.Strings(merged)
+		existingDoc[key] = merged
+	}
+
+	var conflicts []string
+	if recommended, ok := existingDoc["recommendations"].([]string); ok {
+		unwanted, _ := existingDoc["unwantedRecommendations"].([]string)
+		for _, id := range unwanted {
+			if i := sort.SearchStrings(recommended, id); i < len(recommended) && recommended[i] == id {
+				conflicts = append(conflicts, fmt.Sprintf("%s is both recommended and unwanted", id))
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(existingDoc); err != nil {
+		return nil, nil, fmt.Errorf("failed to encode extensions.json: %w", err)
+	}
+
+	return buf.Bytes(), conflicts, nil
+}
+
+// stripJSONComments removes // and /* */ comments and trailing commas from JSON with comments,
+// the format VS Code uses for its settings files
+func stripJSONComments(content []byte) []byte {
+	var out []byte
+	inString := false
+
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+
+		if inString {
+			out = append(out, c)
+			if c == '\\' && i+1 < len(content) {
+				i++
+				out = append(out, content[i])
+			} else if c == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case c == '"':
+			inString = true
+			out = append(out, c)
+		case c == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			if i < len(content) {
+				out = append(out, '\n')
+			}
+		case c == '/' && i+1 < len(content) && content[i+1] == '*':
+			i += 2
+			for i+1 < len(content) && !(content[i] == '*' && content[i+1] == '/') {
+				i++
+			}
+			i++
+		case c == ']' || c == '}':
+			// Drop a trailing comma before the closing bracket
+			trimmed := bytes.TrimRight(out, " \t\r\n")
+			if len(trimmed) > 0 && trimmed[len(trimmed)-1] == ',' {
+				out = append(trimmed[:len(trimmed)-1], out[len(trimmed):]...)
+			}
+			out = append(out, c)
+		default:
+			out = append(out, c)
+		}
+	}
+
+	return out
+}
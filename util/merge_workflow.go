@@ -0,0 +1,183 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// workflowDriver merges GitHub Actions workflows. Layers contribute blocks marked with
+// "# otter:insert <anchor>", which are inserted above the matching "# otter:anchor <anchor>"
+// comment in the project's workflow, so CI layers can add jobs or steps without replacing it.
+type workflowDriver struct{}
+
+var (
+	workflowInsertPattern = regexp.MustCompile(`^\s*# otter:insert\s+(\S+)\s*$`)
+	workflowAnchorPattern = regexp.MustCompile(`^(\s*)# otter:anchor\s+(\S+)\s*$`)
+)
+
+func (workflowDriver) Name() string { return "github-workflow" }
+
+func (workflowDriver) Match(relativePath string) bool {
+	return path.Dir(relativePath) == ".github/workflows" && matchBase(relativePath, "*.yml", "*.yaml")
+}
+
+// MatchContent only merges layer workflows that contribute insert blocks; complete workflows replace the file
+func (workflowDriver) MatchContent(incoming []byte) bool {
+	for _, line := range strings.Split(string(incoming), "\n") {
+		if workflowInsertPattern.MatchString(line) {
+			return true
+		}
+	}
+	return false
+}
+
+// Merge inserts each block into a managed region between "# otter:begin" and "# otter:end"
+// comments above its anchor. Blocks already present in the region are skipped, so repeated
+// builds don't duplicate them.
+func (workflowDriver) Merge(existing, incoming []byte) ([]byte, []string, error) {
+	blocks, order := splitWorkflowBlocks(incoming)
+	lines := strings.Split(strings.TrimSuffix(string(existing), "\n"), "\n")
+
+	var conflicts []string
+	for _, anchor := range order {
+		anchorIndex, indent := -1, ""
+		for i, line := range lines {
+			if match := workflowAnchorPattern.FindStringSubmatch(line); match != nil && match[2] == anchor {
+				anchorIndex, indent = i, match[1]
+				break
+			}
+		}
+		if anchorIndex < 0 {
+			conflicts = append(conflicts, fmt.Sprintf("anchor %s not found in workflow; its blocks were skipped", anchor))
+			continue
+		}
+
+		beginMarker := indent + "# otter:begin " + anchor
+		endMarker := indent + "# otter:end " + anchor
+
+		// Find the managed region directly above the anchor, if there is one
+		regionStart, regionEnd := anchorIndex, anchorIndex
+		if anchorIndex > 0 && lines[anchorIndex-1] == endMarker {
+			for i := anchorIndex - 2; i >= 0; i-- {
+				if lines[i] == beginMarker {
+					regionStart, regionEnd = i, anchorIndex-1
+					break
+				}
+			}
+		}
+
+		var region []string
+		if regionStart < regionEnd {
+			region = append(region, lines[regionStart+1:regionEnd]...)
+		}
+
+		for _, block := range blocks[anchor] {
+			indented := make([]string, len(block))
+			for i, line := range block {
+				if strings.TrimSpace(line) != "" {
+					indented[i] = indent + line
+				}
+			}
+			if containsLines(region, indented) {
+				continue
+			}
+			region = append(region, indented...)
+		}
+
+		replacement := append([]string{beginMarker}, region...)
+		replacement = append(replacement, endMarker)
+
+		updated := append([]string{}, lines[:regionStart]...)
+		updated = append(updated, replacement...)
+		lines = append(updated, lines[anchorIndex:]...)
+	}
+
+	return []byte(strings.Join(lines, "\n") + "\n"), conflicts, nil
+}
+
+// splitWorkflowBlocks returns the dedented blocks following each insert marker, grouped by anchor,
+// and the anchors in order of first appearance. Lines before the first marker are ignored.
+func splitWorkflowBlocks(content []byte) (map[string][][]string, []string) {
+	blocks := make(map[string][][]string)
+	var order []string
+
+	var anchor string
+	var current []string
+	flush := func() {
+		if anchor == "" {
+			return
+		}
+		// Trim blank lines around the block
+		for len(current) > 0 && strings.TrimSpace(current[len(current)-1]) == "" {
+			current = current[:len(current)-1]
+		}
+		for len(current) > 0 && strings.TrimSpace(current[0]) == "" {
+			current = current[1:]
+		}
+		if len(current) == 0 {
+			return
+		}
+		if _, ok := blocks[anchor]; !ok {
+			order = append(order, anchor)
+		}
+		blocks[anchor] = append(blocks[anchor], dedentLines(current))
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := workflowInsertPattern.FindStringSubmatch(line); match != nil {
+			flush()
+			anchor, current = match[1], nil
+			continue
+		}
+		current = append(current, line)
+	}
+	flush()
+
+	return blocks, order
+}
+
+// dedentLines removes the indentation common to all non-blank lines
+func dedentLines(lines []string) []string {
+	minIndent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		if minIndent < 0 || indent < minIndent {
+			minIndent = indent
+		}
+	}
+
+	dedented := make([]string, len(lines))
+	for i, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		dedented[i] = line[minIndent:]
+	}
+	return dedented
+}
+
+// containsLines reports whether block appears as a contiguous run of lines in region
+func containsLines(region, block []string) bool {
+	for start := 0; start+len(block) <= len(region); start++ {
+		match := true
+		for i := range block {
+			if region[start+i] != block[i] {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,194 @@
+package util
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Metrics collects counters and durations of otter operations, such as builds and layer fetches,
+// for fleet-wide observability. They are exposed in the Prometheus text format, and sent to statsd
+// as they happen when a statsd address is set. A nil *Metrics discards everything.
+type Metrics struct {
+	mu        sync.Mutex
+	counters  map[metricKey]float64
+	durations map[metricKey]*durationStat
+	statsd    net.Conn
+	prefix    string
+}
+
+// metricKey identifies a series: a metric name and its labels rendered as k="v",...
+type metricKey struct {
+	name   string
+	labels string
+}
+
+// durationStat sums the observations of a duration series
+type durationStat struct {
+	seconds float64
+	count   int
+}
+
+// NewMetrics creates an empty metrics collector
+func NewMetrics() *Metrics {
+	return &Metrics{
+		counters:  make(map[metricKey]float64),
+		durations: make(map[metricKey]*durationStat),
+	}
+}
+
+// EnableStatsd also sends every observation to the statsd server at addr (host:port) over UDP,
+// with metric names starting with prefix
+func (m *Metrics) EnableStatsd(addr, prefix string) error {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to connect to statsd at %s: %w", addr, err)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.statsd = conn
+	m.prefix = prefix
+	return nil
+}
+
+// Close stops sending to statsd
+func (m *Metrics) Close() error {
+	if m == nil || m.statsd == nil {
+		return nil
+	}
+	return m.statsd.Close()
+}
+
+// Count adds one to a counter. labels are name, value pairs, e.g. "result", "success".
+func (m *Metrics) Count(name string, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counters[metricKey{name, renderLabels(labels)}]++
+	m.sendStatsd(name, labels, "1|c")
+}
+
+// Observe records a duration. labels are name, value pairs, e.g. "host", "github.com".
+func (m *Metrics) Observe(name string, d time.Duration, labels ...string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := metricKey{name, renderLabels(labels)}
+	stat, ok := m.durations[key]
+	if !ok {
+		stat = &durationStat{}
+		m.durations[key] = stat
+	}
+	stat.seconds += d.Seconds()
+	stat.count++
+	m.sendStatsd(name, labels, fmt.Sprintf("%d|ms", d.Milliseconds()))
+}
+
+// sendStatsd sends an observation, naming it prefix.name.value... since statsd has no labels, e.g.
+// otter.layer_fetches_total.github_com.hit.
+// Callers must hold m.mu.
+func (m *Metrics) sendStatsd(name string, labels []string, value string) {
+	if m.statsd == nil {
+		return
+	}
+	// The prefix replaces the otter_ namespace of the Prometheus names
+	parts := []string{strings.TrimPrefix(name, "otter_")}
+	if m.prefix != "" {
+		parts = append([]string{m.prefix}, parts...)
+	}
+	for i := 1; i < len(labels); i += 2 {
+		parts = append(parts, strings.NewReplacer(".", "_", ":", "_", "|", "_", "@", "_").Replace(labels[i]))
+	}
+	// Delivery isn't guaranteed over UDP anyway, so errors are ignored
+	fmt.Fprintf(m.statsd, "%s:%s", strings.Join(parts, "."), value)
+}
+
+// WritePrometheus writes every series in the Prometheus text exposition format. Durations are
+// summaries with a _sum and _count in seconds.
+func (m *Metrics) WritePrometheus(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var b strings.Builder
+	counters := make([]metricKey, 0, len(m.counters))
+	for key := range m.counters {
+		counters = append(counters, key)
+	}
+	for i, key := range sortMetricKeys(counters) {
+		if i == 0 || counters[i-1].name != key.name {
+			fmt.Fprintf(&b, "# TYPE %s counter\n", key.name)
+		}
+		fmt.Fprintf(&b, "%s%s %g\n", key.name, wrapLabels(key.labels), m.counters[key])
+	}
+
+	durations := make([]metricKey, 0, len(m.durations))
+	for key := range m.durations {
+		durations = append(durations, key)
+	}
+	for i, key := range sortMetricKeys(durations) {
+		if i == 0 || durations[i-1].name != key.name {
+			fmt.Fprintf(&b, "# TYPE %s summary\n", key.name)
+		}
+		stat := m.durations[key]
+		fmt.Fprintf(&b, "%s_sum%s %g\n", key.name, wrapLabels(key.labels), stat.seconds)
+		fmt.Fprintf(&b, "%s_count%s %d\n", key.name, wrapLabels(key.labels), stat.count)
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sortMetricKeys sorts series by name and then labels, so each metric's series are together
+func sortMetricKeys(keys []metricKey) []metricKey {
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].name != keys[j].name {
+			return keys[i].name < keys[j].name
+		}
+		return keys[i].labels < keys[j].labels
+	})
+	return keys
+}
+
+// renderLabels renders name, value pairs as k="v",...
+func renderLabels(labels []string) string {
+	var rendered []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		value := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1])
+		rendered = append(rendered, fmt.Sprintf(`%s="%s"`, labels[i], value))
+	}
+	return strings.Join(rendered, ",")
+}
+
+// wrapLabels puts rendered labels in braces, or returns nothing when there are none
+func wrapLabels(labels string) string {
+	if labels == "" {
+		return ""
+	}
+	return "{" + labels + "}"
+}
+
+// LayerHost returns the host a remote layer repository is fetched from, e.g. github.com for both
+// git@github.com:org/repo.git and https://github.com/org/repo.git
+func LayerHost(repoURL string) string {
+	repoURL, _ = SplitLayerRef(repoURL)
+	if parsed, err := url.Parse(repoURL); err == nil && parsed.Host != "" {
+		return parsed.Hostname()
+	}
+	// scp-like syntax: [user@]host:path
+	if before, _, ok := strings.Cut(repoURL, ":"); ok && !strings.Contains(before, "/") {
+		if _, host, ok := strings.Cut(before, "@"); ok {
+			return host
+		}
+		return before
+	}
+	return "unknown"
+}
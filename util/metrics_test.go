@@ -0,0 +1,122 @@
+package util
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+)
+
+func TestMetricsPrometheus(t *testing.T) {
+	metrics := NewMetrics()
+	metrics.Count("otter_builds_total", "operation", "build", "result", "success")
+	metrics.Count("otter_builds_total", "operation", "build", "result", "success")
+	metrics.Count("otter_builds_total", "operation", "build", "result", "failure")
+	metrics.Count("otter_build_failures_total", "class", `say "fetch"`)
+	metrics.Observe("otter_build_duration_seconds", 1500*time.Millisecond, "operation", "build")
+	metrics.Observe("otter_build_duration_seconds", 500*time.Millisecond, "operation", "build")
+
+	var out strings.Builder
+	if err := metrics.WritePrometheus(&out); err != nil {
+		t.Fatalf("WritePrometheus failed: %v", err)
+	}
+	expected := `# TYPE otter_build_failures_total counter
+otter_build_failures_total{class="say \"fetch\""} 1
+# TYPE otter_builds_total counter
+otter_builds_total{operation="build",result="failure"} 1
+otter_builds_total{operation="build",result="success"} 2
+# TYPE otter_build_duration_seconds summary
+otter_build_duration_seconds_sum{operation="build"} 2
+otter_build_duration_seconds_count{operation="build"} 2
+`
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+
+	// A nil collector discards everything
+	var disabled *Metrics
+	disabled.Count("otter_builds_total")
+	disabled.Observe("otter_build_duration_seconds", time.Second)
+	if err := disabled.Close(); err != nil {
+		t.Errorf("Close of nil metrics failed: %v", err)
+	}
+}
+
+func TestMetricsStatsd(t *testing.T) {
+	listener, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	metrics := NewMetrics()
+	if err := metrics.EnableStatsd(listener.LocalAddr().String(), "otter"); err != nil {
+		t.Fatalf("EnableStatsd failed: %v", err)
+	}
+	defer metrics.Close()
+
+	metrics.Count("otter_layer_fetches_total", "host", "github.com", "cache", "hit")
+	metrics.Observe("otter_layer_fetch_duration_seconds", 250*time.Millisecond, "host", "github.com")
+
+	listener.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for _, expected := range []string{
+		"otter.layer_fetches_total.github_com.hit:1|c",
+		"otter.layer_fetch_duration_seconds.github_com:250|ms",
+	} {
+		buf := make([]byte, 512)
+		n, _, err := listener.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("Failed to read statsd packet: %v", err)
+		}
+		if string(buf[:n]) != expected {
+			t.Errorf("Expected packet %q, got %q", expected, buf[:n])
+		}
+	}
+}
+
+func TestLayerHost(t *testing.T) {
+	tests := map[string]string{
+		"git@github.com:example/repo.git":              "github.com",
+		"git@github.com:example/repo.git@v1.0.0":       "github.com",
+		"https://gitlab.example.com/group/repo.git":    "gitlab.example.com",
+		"ssh://git@git.example.com:2222/repo.git@main": "git.example.com",
+		"bitbucket.org:example/repo.git":               "bitbucket.org",
+		"/srv/layers/base":                             "unknown",
+	}
+	for repository, expected := range tests {
+		if host := LayerHost(repository); host != expected {
+			t.Errorf("LayerHost(%q) = %q, expected %q", repository, host, expected)
+		}
+	}
+}
+
+func TestFetchMetrics(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	commitFile(t, upstream, upstreamDir, "README.md", "layer")
+
+	gitOps := NewGitOperations(t.TempDir())
+	gitOps.Metrics = NewMetrics()
+	for i := 0; i < 2; i++ {
+		if _, err := gitOps.handleRemoteRepository(upstreamDir); err != nil {
+			t.Fatalf("Failed to fetch: %v", err)
+		}
+	}
+
+	var out strings.Builder
+	gitOps.Metrics.WritePrometheus(&out)
+	for _, expected := range []string{
+		`otter_layer_fetches_total{host="unknown",cache="hit"} 1`,
+		`otter_layer_fetches_total{host="unknown",cache="miss"} 1`,
+		`otter_layer_fetch_duration_seconds_count{host="unknown"} 2`,
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %s in:\n%s", expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Webhook payload formats
+const (
+	WebhookFormatJSON  = "json"  // The notification itself as JSON
+	WebhookFormatSlack = "slack" // A Slack-compatible {"text": ...} message, also accepted by Mattermost and others
+)
+
+// Build events a webhook can subscribe to
+const (
+	BuildSucceeded = "success"
+	BuildFailed    = "failure"
+)
+
+// NotifyConfig controls where notifications are sent
+type NotifyConfig struct {
+	Webhooks []WebhookConfig `json:"webhooks"` // Receive otter notify's layer updates
+	// Build webhooks receive the audit entry of each build and apply when it finishes
+	Build []BuildWebhookConfig `json:"build"`
+}
+
+// BuildWebhookConfig is a webhook notified when builds finish
+type BuildWebhookConfig struct {
+	WebhookConfig
+	On []string `json:"on"` // Events to send, success and/or failure; both when empty
+}
+
+// Validate checks every configured webhook
+func (c NotifyConfig) Validate() error {
+	for _, webhook := range c.Webhooks {
+		if err := webhook.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, webhook := range c.Build {
+		if err := webhook.Validate(); err != nil {
+			return err
+		}
+		for _, event := range webhook.On {
+			if event != BuildSucceeded && event != BuildFailed {
+				return fmt.Errorf("webhook %s has unknown event %q; use %s or %s", webhook.URL, event, BuildSucceeded, BuildFailed)
+			}
+		}
+	}
+	return nil
+}
+
+// subscribed reports whether the webhook wants the event
+func (w BuildWebhookConfig) subscribed(event string) bool {
+	if len(w.On) == 0 {
+		return true
+	}
+	for _, on := range w.On {
+		if on == event {
+			return true
+		}
+	}
+	return false
+}
+
+// BuildNotification is the payload of a build webhook: the build's audit entry with the project
+// and event
+type BuildNotification struct {
+	Project string `json:"project"`
+	Event   string `json:"event"`
+	*AuditEntry
+}
+
+// Summary describes the outcome of the build in one line
+func (n *BuildNotification) Summary() string {
+	if n.Event == BuildFailed {
+		return fmt.Sprintf("otter %s failed in %s: %s", n.Operation, n.Project, n.Error)
+	}
+	return fmt.Sprintf("otter %s succeeded in %s: %d layer(s) applied, %d file(s) changed", n.Operation, n.Project, len(n.Layers), len(n.FilesChanged))
+}
+
+// SendBuild posts the finished build's audit entry to each build webhook subscribed to its
+// outcome, and returns the errors of those that failed
+func (c NotifyConfig) SendBuild(project string, entry *AuditEntry) error {
+	event := BuildSucceeded
+	if !entry.Success {
+		event = BuildFailed
+	}
+	notification := &BuildNotification{Project: project, Event: event, AuditEntry: entry}
+
+	var errs []error
+	for _, webhook := range c.Build {
+		if webhook.subscribed(event) {
+			if err := webhook.Send(notification, notification.Summary()); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// WebhookConfig is an HTTP(S) endpoint that receives notifications as a JSON POST
+type WebhookConfig struct {
+	URL    string `json:"url"`
+	Format string `json:"format"` // json (default) or slack
+}
+
+// Validate checks that the webhook has a URL and a known format
+func (w WebhookConfig) Validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("webhook has no url")
+	}
+	switch w.Format {
+	case "", WebhookFormatJSON, WebhookFormatSlack:
+		return nil
+	}
+	return fmt.Errorf("webhook %s has unknown format %q; use %s or %s", w.URL, w.Format, WebhookFormatJSON, WebhookFormatSlack)
+}
+
+// Send posts payload to the webhook, or for the slack format, text as a message
+func (w WebhookConfig) Send(payload interface{}, text string) error {
+	if w.Format == WebhookFormatSlack {
+		payload = map[string]string{"text": text}
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+	if err := postJSON(w.URL, data); err != nil {
+		return fmt.Errorf("failed to send notification to %s: %w", w.URL, err)
+	}
+	return nil
+}
+
+// LayerUpdate is a remote layer whose upstream has a different commit than the lockfile pins
+type LayerUpdate struct {
+	Repository string `json:"repository"`
+	Locked     string `json:"locked,omitempty"` // Empty when the layer isn't pinned yet
+	Latest     string `json:"latest"`
+}
+
+// UpdateNotification lists the layer updates available to a project
+type UpdateNotification struct {
+	Project string        `json:"project"`
+	Time    time.Time     `json:"time"`
+	Updates []LayerUpdate `json:"updates"`
+}
+
+// NewUpdateNotification creates an empty notification for the project
+func NewUpdateNotification(project string) *UpdateNotification {
+	return &UpdateNotification{
+		Project: project,
+		Time:    time.Now().UTC(),
+		Updates: make([]LayerUpdate, 0),
+	}
+}
+
+// Summary describes the updates as plain text, one layer per line
+func (n *UpdateNotification) Summary() string {
+	if len(n.Updates) == 0 {
+		return fmt.Sprintf("%s: all layers are up to date", n.Project)
+	}
+
+	var summary strings.Builder
+	fmt.Fprintf(&summary, "%s: %d layer update(s) available", n.Project, len(n.Updates))
+	for _, update := range n.Updates {
+		if update.Locked == "" {
+			fmt.Fprintf(&summary, "\n  %s: not pinned, latest %s", update.Repository, ShortCommit(update.Latest))
+		} else {
+			fmt.Fprintf(&summary, "\n  %s: %s -> %s", update.Repository, ShortCommit(update.Locked), ShortCommit(update.Latest))
+		}
+	}
+	return summary.String()
+}
+
+// postJSON posts encoded JSON to url
+func postJSON(url string, data []byte) error {
+	client := &http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Post(url, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package util
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestUpdateNotificationSummary(t *testing.T) {
+	notification := NewUpdateNotification("app")
+	if summary := notification.Summary(); summary != "app: all layers are up to date" {
+		t.Errorf("Unexpected summary without updates: %q", summary)
+	}
+
+	notification.Updates = append(notification.Updates,
+		LayerUpdate{Repository: "git@github.com:example/base.git", Locked: "1111111111", Latest: "2222222222"},
+		LayerUpdate{Repository: "git@github.com:example/ci.git", Latest: "3333333333"},
+	)
+	expected := `app: 2 layer update(s) available
+  git@github.com:example/base.git: 11111111 -> 22222222
+  git@github.com:example/ci.git: not pinned, latest 33333333`
+	if summary := notification.Summary(); summary != expected {
+		t.Errorf("Expected summary:\n%s\ngot:\n%s", expected, summary)
+	}
+}
+
+func TestWebhookSend(t *testing.T) {
+	var received []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	notification := NewUpdateNotification("app")
+	notification.Updates = append(notification.Updates, LayerUpdate{Repository: "git@github.com:example/base.git", Locked: "aaa", Latest: "bbb"})
+
+	t.Run("json", func(t *testing.T) {
+		if err := (WebhookConfig{URL: server.URL}).Send(notification, "summary"); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		var decoded UpdateNotification
+		if err := json.Unmarshal(received, &decoded); err != nil {
+			t.Fatalf("Failed to decode payload %s: %v", received, err)
+		}
+		if decoded.Project != "app" || len(decoded.Updates) != 1 || decoded.Updates[0].Latest != "bbb" {
+			t.Errorf("Unexpected payload: %s", received)
+		}
+	})
+
+	t.Run("slack", func(t *testing.T) {
+		if err := (WebhookConfig{URL: server.URL, Format: WebhookFormatSlack}).Send(notification, "summary"); err != nil {
+			t.Fatalf("Send failed: %v", err)
+		}
+		if string(received) != `{"text":"summary"}` {
+			t.Errorf("Unexpected payload: %s", received)
+		}
+	})
+
+	t.Run("error status", func(t *testing.T) {
+		status = http.StatusInternalServerError
+		err := (WebhookConfig{URL: server.URL}).Send(notification, "summary")
+		if err == nil || !strings.Contains(err.Error(), "500") {
+			t.Errorf("Expected an error for status 500, got %v", err)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if err := (WebhookConfig{URL: server.URL, Format: "xml"}).Validate(); err == nil {
+			t.Error("Expected an error for an unknown format")
+		}
+	})
+}
+
+func TestSendBuild(t *testing.T) {
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, r.URL.Path+" "+string(body))
+	}))
+	defer server.Close()
+
+	config := NotifyConfig{Build: []BuildWebhookConfig{
+		{WebhookConfig: WebhookConfig{URL: server.URL + "/all"}},
+		{WebhookConfig: WebhookConfig{URL: server.URL + "/failures", Format: WebhookFormatSlack}, On: []string{BuildFailed}},
+	}}
+	if err := config.Validate(); err != nil {
+		t.Fatalf("Expected a valid config, got %v", err)
+	}
+
+	entry := NewAuditEntry("build", "v1.0.0")
+	entry.Layers = append(entry.Layers, AuditLayer{Repository: "./layer", Target: "."})
+	entry.Success = true
+	if err := config.SendBuild("app", entry); err != nil {
+		t.Fatalf("SendBuild failed: %v", err)
+	}
+	if len(received) != 1 || !strings.HasPrefix(received[0], "/all ") {
+		t.Fatalf("Expected only the /all webhook to be notified of a success, got %v", received)
+	}
+	var decoded BuildNotification
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(received[0], "/all ")), &decoded); err != nil {
+		t.Fatalf("Failed to decode payload: %v", err)
+	}
+	if decoded.Project != "app" || decoded.Event != BuildSucceeded || decoded.AuditEntry == nil || len(decoded.Layers) != 1 {
+		t.Errorf("Unexpected payload: %s", received[0])
+	}
+
+	received = nil
+	entry.Success = false
+	entry.Error = "hook failed"
+	if err := config.SendBuild("app", entry); err != nil {
+		t.Fatalf("SendBuild failed: %v", err)
+	}
+	if len(received) != 2 || received[1] != `/failures {"text":"otter build failed in app: hook failed"}` {
+		t.Errorf("Expected both webhooks to be notified of a failure, got %v", received)
+	}
+
+	config.Build[0].On = []string{"done"}
+	if err := config.Validate(); err == nil {
+		t.Error("Expected an error for an unknown event")
+	}
+}
@@ -0,0 +1,43 @@
+package util
+
+import (
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// onlySource names a layer's ONLY clause as the source of files it skips
+const onlySource = "layer ONLY"
+
+// onlyRule returns a rule skipping relativePath when the layer's ONLY clause doesn't select it, or
+// nil when the layer has no ONLY clause
+func (f *FileOperations) onlyRule(relativePath string) *IgnoreRule {
+	if len(f.Only) == 0 {
+		return nil
+	}
+	relativePath = filepath.ToSlash(relativePath)
+	for _, pattern := range f.Only {
+		if f.onlySelects(pattern, relativePath) {
+			return nil
+		}
+	}
+	return &IgnoreRule{Pattern: strings.Join(f.Only, " "), Source: onlySource}
+}
+
+// onlySelects reports whether an ONLY path selects relativePath: the path matches it or is inside a
+// directory it matches, or is a directory above what it matches, which must be walked to reach it.
+// Paths are relative to the layer root, and each component may use * and ? wildcards.
+func (f *FileOperations) onlySelects(pattern, relativePath string) bool {
+	pattern = strings.Trim(path.Clean(filepath.ToSlash(pattern)), "/")
+	if f.IgnoreCase {
+		pattern, relativePath = strings.ToLower(pattern), strings.ToLower(relativePath)
+	}
+	patternParts := strings.Split(pattern, "/")
+	pathParts := strings.Split(relativePath, "/")
+	for i := 0; i < len(patternParts) && i < len(pathParts); i++ {
+		if matched, err := path.Match(patternParts[i], pathParts[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
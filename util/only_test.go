@@ -0,0 +1,50 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestCopyLayerOnly(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	for _, name := range []string{"Makefile", "README.md", "src/main.go", "src/lib/util.go", "docs/guide.md", "cmd/api/main.go", "cmd/api/notes.txt", "cmd/cli/main.go"} {
+		path := filepath.Join(layerDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte(name), 0644)
+	}
+
+	fileOps := NewFileOperations()
+	fileOps.Only = []string{"src/", "Makefile", "cmd/*/main.go"}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	var copied []string
+	for _, change := range fileOps.TakeChanges() {
+		relativePath, _ := filepath.Rel(targetDir, change.Path)
+		copied = append(copied, filepath.ToSlash(relativePath))
+	}
+	sort.Strings(copied)
+	if expected := "Makefile cmd/api/main.go cmd/cli/main.go src/lib/util.go src/main.go"; strings.Join(copied, " ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(copied, " "))
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "docs")); !os.IsNotExist(err) {
+		t.Error("Expected docs to be skipped")
+	}
+
+	var skipped []string
+	for _, ignored := range fileOps.TakeIgnored() {
+		if ignored.Source == onlySource {
+			relativePath, _ := filepath.Rel(targetDir, ignored.Path)
+			skipped = append(skipped, filepath.ToSlash(relativePath))
+		}
+	}
+	sort.Strings(skipped)
+	if expected := "README.md cmd/api/notes.txt docs"; strings.Join(skipped, " ") != expected {
+		t.Errorf("Expected ONLY to skip %s, got %s", expected, strings.Join(skipped, " "))
+	}
+}
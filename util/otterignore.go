@@ -0,0 +1,173 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// IgnoreRule is an ignore pattern and where it was defined
+type IgnoreRule struct {
+	Pattern string
+	Source  string // e.g. "project .otterignore line 7", "layer .otterignore line 2", or "built-in"
+}
+
+func (r IgnoreRule) String() string {
+	return r.Pattern + " from " + r.Source
+}
+
+// IgnoredFile records a layer file that was skipped and the rule that caused it
+type IgnoredFile struct {
+	Path    string `json:"path"`
+	Pattern string `json:"pattern"`
+	Source  string `json:"source"`
+}
+
+// addIgnoreRule appends a project ignore pattern, remembering where it was first defined
+func (f *FileOperations) addIgnoreRule(rule IgnoreRule) {
+	f.IgnorePatterns = append(f.IgnorePatterns, rule.Pattern)
+	if f.IgnoreSources == nil {
+		f.IgnoreSources = make(map[string]string)
+	}
+	if _, ok := f.IgnoreSources[rule.Pattern]; !ok {
+		f.IgnoreSources[rule.Pattern] = rule.Source
+	}
+}
+
+// rulePatterns returns the patterns of a list of rules
+func rulePatterns(rules []IgnoreRule) []string {
+	patterns := make([]string, 0, len(rules))
+	for _, rule := range rules {
+		patterns = append(patterns, rule.Pattern)
+	}
+	return patterns
+}
+
+// loadScopedIgnorePatterns collects the .otterignore files in subdirectories of the project.
+// Like nested .gitignore files, their patterns are matched against paths relative to their own
+// directory and only apply to files written below it.
+func (f *FileOperations) loadScopedIgnorePatterns(projectRoot string) error {
+	if f.IgnoreRoot == "" {
+		f.IgnoreRoot = projectRoot
+	}
+	f.scopedIgnoreRules = make(map[string][]IgnoreRule)
+
+	if _, err := f.FS.Stat(projectRoot); os.IsNotExist(err) {
+		return nil
+	}
+
+	return f.FS.Walk(projectRoot, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(projectRoot, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		relativePath = filepath.ToSlash(relativePath)
+
+		if info.IsDir() {
+			// Never descend into version control, otter's own cache, or directories the project ignores
+			if relativePath != "." && (f.isProtected(relativePath) ||
+				f.isIgnoredWithPatterns(relativePath, f.IgnorePatterns)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		dir := filepath.ToSlash(filepath.Dir(relativePath))
+		if info.Name() != ".otterignore" || dir == "." {
+			return nil
+		}
+
+		content, err := f.FS.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to open %s: %w", relativePath, err)
+		}
+		rules, err := parseIgnoreRules(content, relativePath)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", relativePath, err)
+		}
+		if len(rules) > 0 {
+			f.scopedIgnoreRules[dir] = rules
+		}
+
+		return nil
+	})
+}
+
+// scopedIgnoreRule returns the rule of a nested .otterignore above destPath that ignores it, or nil
+func (f *FileOperations) scopedIgnoreRule(destPath string) *IgnoreRule {
+	if len(f.scopedIgnoreRules) == 0 {
+		return nil
+	}
+
+	relativePath, err := filepath.Rel(f.IgnoreRoot, destPath)
+	if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+		return nil
+	}
+	relativePath = filepath.ToSlash(relativePath)
+
+	for dir, rules := range f.scopedIgnoreRules {
+		if f.IgnoreCase && strings.HasPrefix(strings.ToLower(relativePath), strings.ToLower(dir)+"/") {
+			dir = relativePath[:len(dir)]
+		}
+		scopedPath, ok := strings.CutPrefix(relativePath, dir+"/")
+		if !ok {
+			continue
+		}
+		for i := range rules {
+			if f.matchPattern(rules[i].Pattern, scopedPath) {
+				return &rules[i]
+			}
+		}
+	}
+
+	return nil
+}
+
+// Case sensitivity modes for ignore matching
+const (
+	IgnoreCaseSensitive   = "sensitive"
+	IgnoreCaseInsensitive = "insensitive"
+	IgnoreCaseAuto        = "auto" // Insensitive on macOS and Windows, whose default filesystems ignore case
+)
+
+// ResolveIgnoreCase reports whether ignore matching should be case-insensitive for a mode
+func ResolveIgnoreCase(mode string) (bool, error) {
+	switch strings.ToLower(mode) {
+	case "", IgnoreCaseSensitive:
+		return false, nil
+	case IgnoreCaseInsensitive:
+		return true, nil
+	case IgnoreCaseAuto:
+		return runtime.GOOS == "darwin" || runtime.GOOS == "windows", nil
+	default:
+		return false, fmt.Errorf("unknown ignore case mode %q (expected sensitive, insensitive, or auto)", mode)
+	}
+}
+
+// ignoreLineCondition strips an [os=name,...] prefix from an ignore line and reports whether the
+// line applies on the current operating system
+func ignoreLineCondition(line string) (string, bool, error) {
+	systems, ok := strings.CutPrefix(line, "[os=")
+	if !ok {
+		return line, true, nil
+	}
+
+	systems, pattern, ok := strings.Cut(systems, "]")
+	pattern = strings.TrimSpace(pattern)
+	if !ok || systems == "" || pattern == "" {
+		return "", false, fmt.Errorf("ignore condition must be in format '[os=name] pattern', got: %s", line)
+	}
+
+	for _, system := range strings.Split(systems, ",") {
+		if strings.TrimSpace(system) == runtime.GOOS {
+			return pattern, true, nil
+		}
+	}
+	return pattern, false, nil
+}
@@ -0,0 +1,187 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestScopedOtterignore(t *testing.T) {
+	fsys := NewMemFileSystem()
+	files := map[string]string{
+		"/layer/README.md":                            "# service",
+		"/layer/config.env":                           "SECRET=1",
+		"/layer/local/settings.json":                  "{}",
+		"/project/.otterignore":                       "build/\n",
+		"/project/services/api/.otterignore":          "*.env\nlocal/\n",
+		"/project/build/.otterignore":                 "README.md\n",
+		"/monorepo/layer/services/api/config.env":     "SECRET=1",
+		"/monorepo/layer/services/web/config.env":     "SECRET=1",
+		"/monorepo/layer/services/api/README.md":      "# api",
+		"/monorepo/project/services/api/.otterignore": "*.env\n",
+	}
+	for path, content := range files {
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	if err := fileOps.LoadIgnorePatterns("/project"); err != nil {
+		t.Fatalf("Failed to load ignore patterns: %v", err)
+	}
+
+	// Directories ignored by the root .otterignore are not searched for nested files
+	if _, ok := fileOps.scopedIgnoreRules["build"]; ok {
+		t.Error("Expected build/.otterignore to be skipped")
+	}
+
+	for _, target := range []string{"/project/services/api", "/project/services/web"} {
+		if err := fileOps.CopyLayer("/layer", target, "/project", nil, [2]string{"{{", "}}"}, true); err != nil {
+			t.Fatalf("Failed to copy layer to %s: %v", target, err)
+		}
+	}
+
+	expected := map[string]bool{
+		"/project/services/api/README.md":           true,
+		"/project/services/api/config.env":          false,
+		"/project/services/api/local/settings.json": false,
+		"/project/services/web/README.md":           true,
+		"/project/services/web/config.env":          true,
+		"/project/services/web/local/settings.json": true,
+	}
+	for path, copied := range expected {
+		_, err := fsys.Stat(path)
+		if copied && err != nil {
+			t.Errorf("Expected %s to be copied: %v", path, err)
+		}
+		if !copied && !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be ignored", path)
+		}
+	}
+
+	// Scopes apply to the destination, so a layer targeting the project root is filtered too
+	fileOps = NewFileOperationsWithFS(fsys)
+	if err := fileOps.LoadIgnorePatterns("/monorepo/project"); err != nil {
+		t.Fatalf("Failed to load ignore patterns: %v", err)
+	}
+	stats, err := fileOps.MeasureLayer("/monorepo/layer", "/monorepo/project")
+	if err != nil {
+		t.Fatalf("Failed to measure layer: %v", err)
+	}
+	if stats.Files != 2 {
+		t.Errorf("Expected 2 files to be written, got %d", stats.Files)
+	}
+}
+
+func TestIgnoreLineCondition(t *testing.T) {
+	other := "windows"
+	if runtime.GOOS == "windows" {
+		other = "linux"
+	}
+
+	content := "[os=" + runtime.GOOS + ",plan9] Thumbs.db\n[os=" + other + "] desktop.ini\n[Bb]in/\n"
+	rules, err := parseIgnoreRules([]byte(content), ".otterignore")
+	if err != nil {
+		t.Fatalf("Failed to parse patterns: %v", err)
+	}
+	if patterns := rulePatterns(rules); strings.Join(patterns, " ") != "Thumbs.db [Bb]in/" {
+		t.Errorf("Unexpected patterns: %v", patterns)
+	}
+
+	for _, invalid := range []string{"[os=] Thumbs.db", "[os=windows Thumbs.db", "[os=windows]"} {
+		if _, err := parseIgnoreRules([]byte(invalid), ".otterignore"); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestIgnoreCaseInsensitive(t *testing.T) {
+	fileOps := NewFileOperations()
+	fileOps.IgnorePatterns = []string{"node_modules/", "*.log", "Thumbs.db"}
+
+	paths := []string{"Node_Modules/pkg/index.js", "logs/DEBUG.LOG", "assets/thumbs.db"}
+	for _, path := range paths {
+		if fileOps.IsIgnored(path) {
+			t.Errorf("Expected %s not to be ignored with case-sensitive matching", path)
+		}
+	}
+
+	fileOps.IgnoreCase = true
+	for _, path := range paths {
+		if !fileOps.IsIgnored(path) {
+			t.Errorf("Expected %s to be ignored with case-insensitive matching", path)
+		}
+	}
+	if !fileOps.isIgnoredWithPatterns(".GIT/config", criticalIgnorePatterns) {
+		t.Error("Expected critical patterns to be matched case-insensitively")
+	}
+
+	if _, err := ResolveIgnoreCase("sometimes"); err == nil {
+		t.Error("Expected error for unknown case mode")
+	}
+}
+
+func TestIgnoredFileSources(t *testing.T) {
+	fsys := NewMemFileSystem()
+	files := map[string]string{
+		"/layer/.otterignore":       "# layer rules\nnotes.txt\n",
+		"/layer/notes.txt":          "",
+		"/layer/debug.log":          "",
+		"/layer/.gitignore":         "",
+		"/layer/node_modules/x.js":  "",
+		"/layer/api/secret.env":     "",
+		"/project/.otterignore":     "# project rules\n\n*.log\n",
+		"/project/api/.otterignore": "*.env\n",
+	}
+	for path, content := range files {
+		if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Failed to create directory: %v", err)
+		}
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	if err := fileOps.LoadIgnorePatterns("/project"); err != nil {
+		t.Fatalf("Failed to load ignore patterns: %v", err)
+	}
+	if err := fileOps.AddIgnorePresets([]string{"node"}); err != nil {
+		t.Fatalf("Failed to add presets: %v", err)
+	}
+	if err := fileOps.CopyLayer("/layer", "/project", "/project", nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("Failed to copy layer: %v", err)
+	}
+
+	expected := map[string]IgnoredFile{
+		"/project/debug.log":      {Pattern: "*.log", Source: "project .otterignore line 3"},
+		"/project/notes.txt":      {Pattern: "notes.txt", Source: "layer .otterignore line 2"},
+		"/project/.gitignore":     {Pattern: ".gitignore", Source: "built-in"},
+		"/project/.otterignore":   {Pattern: ".otterignore", Source: "built-in"},
+		"/project/node_modules":   {Pattern: "node_modules/", Source: "Otterfile IGNORE PRESET node"},
+		"/project/api/secret.env": {Pattern: "*.env", Source: "api/.otterignore line 1"},
+	}
+	ignored := fileOps.TakeIgnored()
+	if len(ignored) != len(expected) {
+		t.Errorf("Expected %d ignored files, got %d: %+v", len(expected), len(ignored), ignored)
+	}
+	for _, file := range ignored {
+		want, ok := expected[file.Path]
+		if !ok {
+			t.Errorf("Unexpected ignored file %+v", file)
+			continue
+		}
+		if file.Pattern != want.Pattern || file.Source != want.Source {
+			t.Errorf("%s: expected %s from %s, got %s from %s", file.Path, want.Pattern, want.Source, file.Pattern, file.Source)
+		}
+	}
+	if len(fileOps.TakeIgnored()) != 0 {
+		t.Error("Expected TakeIgnored to reset the record")
+	}
+}
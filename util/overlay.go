@@ -0,0 +1,258 @@
+package util
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OverlayFileSystem reads through to a base FileSystem and keeps every change in memory, so a build
+// can run against a project without modifying it
+type OverlayFileSystem struct {
+	base FileSystem
+
+	mu      sync.Mutex
+	entries map[string]*memEntry // Files written and directories created
+	removed map[string]bool      // Base entries removed
+	pending []OverlayChange      // Changes since the last TakeChanges
+}
+
+// OverlayChange is a file written or removed through an OverlayFileSystem
+type OverlayChange struct {
+	Path    string
+	Data    []byte
+	Mode    os.FileMode
+	Removed bool
+}
+
+// NewOverlayFileSystem creates an OverlayFileSystem over base
+func NewOverlayFileSystem(base FileSystem) *OverlayFileSystem {
+	return &OverlayFileSystem{
+		base:    base,
+		entries: make(map[string]*memEntry),
+		removed: make(map[string]bool),
+	}
+}
+
+// TakeChanges returns the files written and removed since the previous call, in the order of their
+// last change, and resets the record
+func (o *OverlayFileSystem) TakeChanges() []OverlayChange {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	changes := o.pending
+	o.pending = nil
+	return changes
+}
+
+// record notes a change, replacing an earlier change to the same path
+func (o *OverlayFileSystem) record(change OverlayChange) {
+	for i, previous := range o.pending {
+		if previous.Path == change.Path {
+			o.pending = append(o.pending[:i], o.pending[i+1:]...)
+			break
+		}
+	}
+	o.pending = append(o.pending, change)
+}
+
+func (o *OverlayFileSystem) Stat(name string) (os.FileInfo, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.stat(filepath.Clean(name))
+}
+
+func (o *OverlayFileSystem) stat(name string) (os.FileInfo, error) {
+	if entry, ok := o.entries[name]; ok {
+		return entry, nil
+	}
+	if o.removed[name] {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.base.Stat(name)
+}
+
+func (o *OverlayFileSystem) ReadFile(name string) ([]byte, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	name = filepath.Clean(name)
+	if entry, ok := o.entries[name]; ok {
+		if entry.IsDir() {
+			return nil, &fs.PathError{Op: "read", Path: name, Err: fs.ErrInvalid}
+		}
+		return append([]byte(nil), entry.data...), nil
+	}
+	if o.removed[name] {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	return o.base.ReadFile(name)
+}
+
+func (o *OverlayFileSystem) WriteFile(name string, data []byte, perm os.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.writeFile(filepath.Clean(name), data, perm)
+}
+
+func (o *OverlayFileSystem) writeFile(name string, data []byte, perm os.FileMode) error {
+	if parent, err := o.stat(filepath.Dir(name)); err != nil || !parent.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	if info, err := o.stat(name); err == nil && info.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: fs.ErrExist}
+	}
+
+	delete(o.removed, name)
+	o.entries[name] = &memEntry{
+		name:    filepath.Base(name),
+		data:    append([]byte(nil), data...),
+		mode:    perm.Perm(),
+		modTime: time.Now(),
+	}
+	o.record(OverlayChange{Path: name, Data: append([]byte(nil), data...), Mode: perm.Perm()})
+	return nil
+}
+
+func (o *OverlayFileSystem) MkdirAll(path string, perm os.FileMode) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for dir := filepath.Clean(path); ; dir = filepath.Dir(dir) {
+		if info, err := o.stat(dir); err == nil {
+			if !info.IsDir() {
+				return &fs.PathError{Op: "mkdir", Path: dir, Err: fs.ErrExist}
+			}
+			return nil
+		}
+		delete(o.removed, dir)
+		o.entries[dir] = &memEntry{name: filepath.Base(dir), mode: os.ModeDir | perm.Perm(), modTime: time.Now()}
+
+		if dir == filepath.Dir(dir) {
+			return nil
+		}
+	}
+}
+
+func (o *OverlayFileSystem) Remove(name string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return o.remove(filepath.Clean(name))
+}
+
+func (o *OverlayFileSystem) remove(name string) error {
+	info, err := o.stat(name)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() && len(o.children(name)) > 0 {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrExist}
+	}
+
+	delete(o.entries, name)
+	if _, err := o.base.Stat(name); err == nil {
+		o.removed[name] = true
+	}
+	o.record(OverlayChange{Path: name, Removed: true})
+	return nil
+}
+
+// Rename moves a file; directories can't be renamed
+func (o *OverlayFileSystem) Rename(oldpath, newpath string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	oldpath, newpath = filepath.Clean(oldpath), filepath.Clean(newpath)
+	info, err := o.stat(oldpath)
+	if err != nil {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrNotExist}
+	}
+	if info.IsDir() {
+		return &fs.PathError{Op: "rename", Path: oldpath, Err: fs.ErrInvalid}
+	}
+
+	var data []byte
+	if entry, ok := o.entries[oldpath]; ok {
+		data = entry.data
+	} else if data, err = o.base.ReadFile(oldpath); err != nil {
+		return err
+	}
+	if err := o.writeFile(newpath, data, info.Mode()); err != nil {
+		return err
+	}
+	return o.remove(oldpath)
+}
+
+// Walk visits entries in lexical order like filepath.Walk
+func (o *OverlayFileSystem) Walk(root string, fn filepath.WalkFunc) error {
+	root = filepath.Clean(root)
+	info, err := o.Stat(root)
+	if err != nil {
+		return fn(root, nil, err)
+	}
+
+	err = o.walk(root, info, fn)
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func (o *OverlayFileSystem) walk(path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if err := fn(path, info, nil); err != nil || !info.IsDir() {
+		return err
+	}
+
+	o.mu.Lock()
+	children := o.children(path)
+	o.mu.Unlock()
+
+	for _, child := range children {
+		if err := o.walk(filepath.Join(path, child.Name()), child, fn); err != nil {
+			if err == filepath.SkipDir {
+				if child.IsDir() {
+					continue
+				}
+				// SkipDir from a file skips the rest of its directory
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// children returns the direct children of a directory sorted by name, with the overlay's changes
+func (o *OverlayFileSystem) children(dir string) []os.FileInfo {
+	byName := make(map[string]os.FileInfo)
+	o.base.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == dir {
+			return nil
+		}
+		if !o.removed[path] {
+			byName[info.Name()] = info
+		}
+		if info.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	for path, entry := range o.entries {
+		if path != dir && filepath.Dir(path) == dir {
+			byName[entry.name] = entry
+		}
+	}
+
+	children := make([]os.FileInfo, 0, len(byName))
+	for _, info := range byName {
+		children = append(children, info)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		return children[i].Name() < children[j].Name()
+	})
+	return children
+}
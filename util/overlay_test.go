@@ -0,0 +1,71 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOverlayFileSystem(t *testing.T) {
+	base := NewMemFileSystem()
+	base.MkdirAll("/project/docs", 0755)
+	base.WriteFile("/project/README.md", []byte("readme"), 0644)
+	base.WriteFile("/project/docs/old.md", []byte("old"), 0644)
+
+	overlay := NewOverlayFileSystem(base)
+	if err := overlay.MkdirAll("/project/src", 0755); err != nil {
+		t.Fatalf("MkdirAll failed: %v", err)
+	}
+	overlay.WriteFile("/project/src/main.go", []byte("package main"), 0644)
+	overlay.WriteFile("/project/README.md", []byte("draft"), 0644)
+	overlay.WriteFile("/project/README.md", []byte("updated"), 0644)
+	if err := overlay.Rename("/project/docs/old.md", "/project/docs/new.md"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+
+	// Reads see the changes while the base is untouched
+	if content, _ := overlay.ReadFile("/project/README.md"); string(content) != "updated" {
+		t.Errorf("Expected the overlay's README.md, got %q", content)
+	}
+	if content, _ := base.ReadFile("/project/README.md"); string(content) != "readme" {
+		t.Errorf("Expected the base README.md to be untouched, got %q", content)
+	}
+	if _, err := overlay.Stat("/project/docs/old.md"); !os.IsNotExist(err) {
+		t.Errorf("Expected old.md to be removed in the overlay, got %v", err)
+	}
+	if _, err := base.Stat("/project/src"); !os.IsNotExist(err) {
+		t.Errorf("Expected src not to be created in the base")
+	}
+
+	var walked []string
+	overlay.Walk("/project", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			rel, _ := filepath.Rel("/project", path)
+			walked = append(walked, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if expected := []string{"README.md", "docs/new.md", "src/main.go"}; !reflect.DeepEqual(walked, expected) {
+		t.Errorf("Expected walk to visit %v, got %v", expected, walked)
+	}
+
+	// Each path is reported once, at its last change
+	var changed []string
+	for _, change := range overlay.TakeChanges() {
+		changed = append(changed, change.Path)
+		if change.Path == "/project/docs/old.md" && !change.Removed {
+			t.Errorf("Expected old.md to be reported as removed")
+		}
+	}
+	if expected := []string{"/project/src/main.go", "/project/README.md", "/project/docs/new.md", "/project/docs/old.md"}; !reflect.DeepEqual(changed, expected) {
+		t.Errorf("Expected changes %v, got %v", expected, changed)
+	}
+	if len(overlay.TakeChanges()) != 0 {
+		t.Error("Expected TakeChanges to reset the record")
+	}
+
+	if err := overlay.Remove("/project/docs"); err == nil {
+		t.Error("Expected removing a non-empty directory to fail")
+	}
+}
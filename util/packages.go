@@ -0,0 +1,183 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PackageFragmentName is the file a package layer provides at its root, in devbox.json format
+const PackageFragmentName = "devbox.json"
+
+// PackageManifest accumulates the devbox.json fragments of package layers.
+// Fragments are merged structurally: objects are merged key by key, arrays are
+// combined without duplicates, and later scalar values replace earlier ones.
+type PackageManifest struct {
+	Data map[string]interface{}
+}
+
+// NewPackageManifest creates an empty package manifest
+func NewPackageManifest() *PackageManifest {
+	return &PackageManifest{Data: make(map[string]interface{})}
+}
+
+// MergeLayer merges the fragment at the root of a package layer
+func (m *PackageManifest) MergeLayer(layerPath string) error {
+	fragmentPath := filepath.Join(layerPath, PackageFragmentName)
+	data, err := os.ReadFile(fragmentPath)
+	if err != nil {
+		return fmt.Errorf("package layer must provide %s: %w", PackageFragmentName, err)
+	}
+
+	var fragment map[string]interface{}
+	if err := json.Unmarshal(data, &fragment); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", fragmentPath, err)
+	}
+
+	m.Merge(fragment)
+	return nil
+}
+
+// Merge merges a fragment into the manifest
+func (m *PackageManifest) Merge(fragment map[string]interface{}) {
+	mergeObjects(m.Data, fragment)
+}
+
+// DevboxJSON renders the manifest as a devbox.json document
+func (m *PackageManifest) DevboxJSON() ([]byte, error) {
+	data, err := json.MarshalIndent(m.Data, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode devbox.json: %w", err)
+	}
+	return append(data, '\n'), nil
+}
+
+// FlakeNix renders the manifest as a flake.nix with a default development shell.
+// Packages map to nixpkgs attributes (devbox version suffixes are dropped), env
+// entries become shell variables, and shell.init_hook becomes the shellHook.
+func (m *PackageManifest) FlakeNix() []byte {
+	var packages []string
+	for _, pkg := range m.packages() {
+		name, _, _ := strings.Cut(pkg, "@")
+		packages = append(packages, name)
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by otter from package layers; changes will be overwritten\n")
+	b.WriteString("{\n")
+	b.WriteString("  inputs.nixpkgs.url = \"github:NixOS/nixpkgs/nixpkgs-unstable\";\n")
+	b.WriteString("  inputs.flake-utils.url = \"github:numtide/flake-utils\";\n\n")
+	b.WriteString("  outputs = { self, nixpkgs, flake-utils }:\n")
+	b.WriteString("    flake-utils.lib.eachDefaultSystem (system:\n")
+	b.WriteString("      let pkgs = nixpkgs.legacyPackages.${system}; in {\n")
+	b.WriteString("        devShells.default = pkgs.mkShell {\n")
+	fmt.Fprintf(&b, "          packages = with pkgs; [ %s ];\n", strings.Join(packages, " "))
+
+	if env, ok := m.Data["env"].(map[string]interface{}); ok {
+		keys := make([]string, 0, len(env))
+		for key := range env {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fmt.Fprintf(&b, "          %s = %s;\n", nixString(key), nixString(fmt.Sprint(env[key])))
+		}
+	}
+
+	if hook := m.initHook(); len(hook) > 0 {
+		fmt.Fprintf(&b, "          shellHook = %s;\n", nixString(strings.Join(hook, "\n")))
+	}
+
+	b.WriteString("        };\n")
+	b.WriteString("      });\n")
+	b.WriteString("}\n")
+
+	return []byte(b.String())
+}
+
+// packages returns the package names, accepting both the list and map forms used by devbox
+func (m *PackageManifest) packages() []string {
+	switch packages := m.Data["packages"].(type) {
+	case []interface{}:
+		var names []string
+		for _, pkg := range packages {
+			names = append(names, fmt.Sprint(pkg))
+		}
+		return names
+	case map[string]interface{}:
+		names := make([]string, 0, len(packages))
+		for name := range packages {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	return nil
+}
+
+// initHook returns shell.init_hook as a list of lines
+func (m *PackageManifest) initHook() []string {
+	shell, ok := m.Data["shell"].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+
+	switch hook := shell["init_hook"].(type) {
+	case string:
+		return []string{hook}
+	case []interface{}:
+		var lines []string
+		for _, line := range hook {
+			lines = append(lines, fmt.Sprint(line))
+		}
+		return lines
+	}
+	return nil
+}
+
+// mergeObjects merges src into dst recursively
+func mergeObjects(dst, src map[string]interface{}) {
+	for key, value := range src {
+		switch srcValue := value.(type) {
+		case map[string]interface{}:
+			if dstValue, ok := dst[key].(map[string]interface{}); ok {
+				mergeObjects(dstValue, srcValue)
+				continue
+			}
+		case []interface{}:
+			if dstValue, ok := dst[key].([]interface{}); ok {
+				dst[key] = mergeArrays(dstValue, srcValue)
+				continue
+			}
+		}
+		dst[key] = value
+	}
+}
+
+// mergeArrays appends the elements of src that are not already in dst
+func mergeArrays(dst, src []interface{}) []interface{} {
+	seen := make(map[string]bool)
+	for _, value := range dst {
+		encoded, _ := json.Marshal(value)
+		seen[string(encoded)] = true
+	}
+
+	for _, value := range src {
+		encoded, _ := json.Marshal(value)
+		if !seen[string(encoded)] {
+			seen[string(encoded)] = true
+			dst = append(dst, value)
+		}
+	}
+
+	return dst
+}
+
+// nixString quotes a string as a Nix string literal
+func nixString(s string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "${", `\${`, "\n", `\n`, "\t", `\t`)
+	return `"` + replacer.Replace(s) + `"`
+}
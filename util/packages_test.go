@@ -0,0 +1,75 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPackageManifestMerge(t *testing.T) {
+	tempDir := t.TempDir()
+
+	goLayer := filepath.Join(tempDir, "go")
+	nodeLayer := filepath.Join(tempDir, "node")
+	for dir, content := range map[string]string{
+		goLayer:   `{"packages": ["go@1.22", "git"], "env": {"GOFLAGS": "-mod=mod"}, "shell": {"init_hook": ["go version"]}}`,
+		nodeLayer: `{"packages": ["nodejs@20", "git"], "env": {"NODE_ENV": "development"}, "shell": {"init_hook": "node --version"}}`,
+	} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create layer: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, PackageFragmentName), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write fragment: %v", err)
+		}
+	}
+
+	manifest := NewPackageManifest()
+	if err := manifest.MergeLayer(goLayer); err != nil {
+		t.Fatalf("Failed to merge go layer: %v", err)
+	}
+	if err := manifest.MergeLayer(nodeLayer); err != nil {
+		t.Fatalf("Failed to merge node layer: %v", err)
+	}
+
+	packages := manifest.packages()
+	if strings.Join(packages, ",") != "go@1.22,git,nodejs@20" {
+		t.Errorf("Unexpected merged packages: %v", packages)
+	}
+
+	env := manifest.Data["env"].(map[string]interface{})
+	if env["GOFLAGS"] != "-mod=mod" || env["NODE_ENV"] != "development" {
+		t.Errorf("Expected env from both layers, got %v", env)
+	}
+
+	// A scalar init_hook from a later layer replaces the earlier list
+	if hook := manifest.initHook(); len(hook) != 1 || hook[0] != "node --version" {
+		t.Errorf("Unexpected init hook: %v", hook)
+	}
+
+	devbox, err := manifest.DevboxJSON()
+	if err != nil {
+		t.Fatalf("Failed to render devbox.json: %v", err)
+	}
+	if !strings.Contains(string(devbox), `"nodejs@20"`) {
+		t.Errorf("Expected packages in devbox.json:\n%s", devbox)
+	}
+
+	flake := string(manifest.FlakeNix())
+	if !strings.Contains(flake, "packages = with pkgs; [ go git nodejs ];") {
+		t.Errorf("Expected packages without versions in flake.nix:\n%s", flake)
+	}
+	if !strings.Contains(flake, `"GOFLAGS" = "-mod=mod";`) {
+		t.Errorf("Expected env in flake.nix:\n%s", flake)
+	}
+
+	if err := NewPackageManifest().MergeLayer(tempDir); err == nil {
+		t.Error("Expected error for layer without a fragment")
+	}
+}
+
+func TestNixString(t *testing.T) {
+	if got := nixString(`echo "${HOME}"`); got != `"echo \"\${HOME}\""` {
+		t.Errorf("Unexpected quoting: %s", got)
+	}
+}
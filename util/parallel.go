@@ -0,0 +1,245 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CopyJob is a layer to copy with CopyLayers
+type CopyJob struct {
+	Name     string            // Layer the output of the job is shown under
+	Source   string            // Directory the layer's files are read from
+	Target   string            // Directory the files are copied to
+	Template map[string]string // Template variables of the layer
+	Delims   [2]string         // Template delimiters of the layer
+	Allow    []string          // Protected files the layer may provide
+	Hidden   []string          // Hidden files at the layer root the layer may provide
+	Only     []string          // Paths the layer is limited to; every path when empty
+	Map      []PathMapping     // Where files of the layer land instead of their path in it
+	Strategy string            // What happens to files of the layer that already exist
+	Layer    *LayerInfo        // Layer available to templates as .Layer
+	// PreserveAttributes copies extended attributes and ownership of the layer's files
+	PreserveAttributes bool
+}
+
+// CopyResult holds the files a CopyJob wrote and ignored
+type CopyResult struct {
+	Changes []FileChange
+	Ignored []IgnoredFile
+}
+
+// PlanCopyGroups groups jobs so that jobs writing to any of the same files share a group. Groups
+// and the jobs within them are in declared order; separate groups can be copied concurrently.
+func (f *FileOperations) PlanCopyGroups(jobs []CopyJob) ([][]int, error) {
+	// Union jobs that share a destination file, keeping the first declared job as the root
+	parent := make([]int, len(jobs))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	owner := make(map[string]int)
+	for i, job := range jobs {
+		files, err := f.plannedFiles(job)
+		if err != nil {
+			return nil, err
+		}
+		for _, destPath := range files {
+			key := destPath
+			if f.IgnoreCase {
+				key = strings.ToLower(key)
+			}
+			if j, ok := owner[key]; ok {
+				a, b := find(i), find(j)
+				if a > b {
+					a, b = b, a
+				}
+				parent[b] = a
+				continue
+			}
+			owner[key] = i
+		}
+	}
+
+	var groups [][]int
+	index := make(map[int]int)
+	for i := range jobs {
+		root := find(i)
+		g, ok := index[root]
+		if !ok {
+			g = len(groups)
+			index[root] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups, nil
+}
+
+// plannedFiles returns the destination paths a job would write
+func (f *FileOperations) plannedFiles(job CopyJob) ([]string, error) {
+	ops := f.fork()
+	ops.AllowProtected = job.Allow
+	ops.AllowHidden = job.Hidden
+	ops.Only = job.Only
+	ops.Map = job.Map
+
+	combinedRules, err := ops.combinedIgnoreRules(job.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = f.layerFS().Walk(job.Source, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(job.Source, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if relativePath == "." {
+			return nil
+		}
+
+		destPath := ops.destinationPath(job.Target, relativePath)
+		if ops.matchingIgnoreRule(relativePath, destPath, combinedRules) != nil {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.IsDir() && info.Name() != GitignoreFragmentName {
+			files = append(files, destPath)
+		}
+		return nil
+	})
+
+	return files, err
+}
+
+// CopyLayers copies the layers of jobs without prompting, running jobs that write to separate
+// files concurrently and jobs that overlap one after another in declared order. The result of
+// each job is returned in declared order, and .gitignore fragments are collected as if the jobs
+// had been copied in sequence. The first error in declared order is returned.
+func (f *FileOperations) CopyLayers(jobs []CopyJob, projectRoot string) ([]CopyResult, error) {
+	groups, err := f.PlanCopyGroups(jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each job prints to its own stream, so concurrent layers don't interleave their output
+	names := make([]string, len(jobs))
+	for i, job := range jobs {
+		names[i] = job.Name
+	}
+	output := NewLogMultiplexer(f.out(), f.LogOutput, names)
+	defer output.Flush()
+
+	forks := make([]*FileOperations, len(jobs))
+	errs := make([]error, len(jobs))
+	var wg sync.WaitGroup
+	for _, group := range groups {
+		wg.Add(1)
+		go func(group []int) {
+			defer wg.Done()
+			for _, i := range group {
+				forks[i] = f.fork()
+				forks[i].AllowProtected = jobs[i].Allow
+				forks[i].AllowHidden = jobs[i].Hidden
+				forks[i].Only = jobs[i].Only
+				forks[i].Map = jobs[i].Map
+				forks[i].Strategy = jobs[i].Strategy
+				forks[i].Layer = jobs[i].Layer
+				forks[i].PreserveAttributes = jobs[i].PreserveAttributes
+				forks[i].Output = output.Stream(i)
+				errs[i] = forks[i].CopyLayer(jobs[i].Source, jobs[i].Target, projectRoot, jobs[i].Template, jobs[i].Delims, true)
+				output.Close(i)
+				if errs[i] != nil {
+					return
+				}
+			}
+		}(group)
+	}
+	wg.Wait()
+	output.Flush()
+
+	results := make([]CopyResult, len(jobs))
+	for i, ops := range forks {
+		if ops == nil {
+			continue
+		}
+		results[i] = CopyResult{Changes: ops.TakeChanges(), Ignored: ops.TakeIgnored()}
+		f.join(ops)
+	}
+	for i, err := range errs {
+		if err != nil {
+			return results, fmt.Errorf("failed to copy %s: %w", jobs[i].Source, err)
+		}
+	}
+	return results, nil
+}
+
+// fork returns a copy of the settings of f with its own record of changes, for copying a layer concurrently
+func (f *FileOperations) fork() *FileOperations {
+	return &FileOperations{
+		IgnorePatterns:     f.IgnorePatterns,
+		IgnoreSources:      f.IgnoreSources,
+		FS:                 f.FS,
+		EditorConfig:       f.EditorConfig,
+		Project:            f.Project,
+		User:               f.User,
+		Build:              f.Build,
+		Layer:              f.Layer,
+		Env:                f.Env,
+		TemplateLimits:     f.TemplateLimits,
+		Condition:          f.Condition,
+		SecretScan:         f.SecretScan,
+		IgnoreRoot:         f.IgnoreRoot,
+		IgnoreCase:         f.IgnoreCase,
+		AllowProtected:     f.AllowProtected,
+		AllowHidden:        f.AllowHidden,
+		Only:               f.Only,
+		Map:                f.Map,
+		Strategy:           f.Strategy,
+		RequireAllowHidden: f.RequireAllowHidden,
+		PreserveAttributes: f.PreserveAttributes,
+		Output:             f.Output,
+		LogOutput:          f.LogOutput,
+		scopedIgnoreRules:  f.scopedIgnoreRules,
+	}
+}
+
+// join records the template usage and .gitignore rules a fork collected
+func (f *FileOperations) join(ops *FileOperations) {
+	for dst, variables := range ops.templateUsage {
+		if f.templateUsage == nil {
+			f.templateUsage = make(map[string][]string)
+		}
+		f.templateUsage[dst] = variables
+	}
+
+	for _, gitignorePath := range ops.gitignoreOrder {
+		if f.gitignoreRules == nil {
+			f.gitignoreRules = make(map[string][]string)
+		}
+		if _, ok := f.gitignoreRules[gitignorePath]; !ok {
+			f.gitignoreOrder = append(f.gitignoreOrder, gitignorePath)
+		}
+		for _, rule := range ops.gitignoreRules[gitignorePath] {
+			if !containsString(f.gitignoreRules[gitignorePath], rule) {
+				f.gitignoreRules[gitignorePath] = append(f.gitignoreRules[gitignorePath], rule)
+			}
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package util
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCopyLayers(t *testing.T) {
+	fsys := NewMemFileSystem()
+	layerFiles := map[string]string{
+		"/layers/a/shared.txt":           "from a",
+		"/layers/a/.gitignore.fragment":  "dist/\n",
+		"/layers/b/b.txt":                "from b",
+		"/layers/c/shared.txt":           "from c",
+		"/layers/c/.gitignore.fragment":  "build/\ndist/\n",
+		"/layers/d/nested/{{.Name}}.txt": "name: {{.Name}}",
+	}
+	for _, dir := range []string{"/layers/a", "/layers/b", "/layers/c", "/layers/d/nested", "/project"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	for path, content := range layerFiles {
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	delims := [2]string{"{{", "}}"}
+	jobs := []CopyJob{
+		{Name: "a", Source: "/layers/a", Target: "/project", Delims: delims},
+		{Name: "b", Source: "/layers/b", Target: "/project", Delims: delims},
+		{Name: "c", Source: "/layers/c", Target: "/project", Delims: delims},
+		{Name: "d", Source: "/layers/d", Target: "/project/sub", Template: map[string]string{"Name": "otter"}, Delims: delims},
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	groups, err := fileOps.PlanCopyGroups(jobs)
+	if err != nil {
+		t.Fatalf("PlanCopyGroups failed: %v", err)
+	}
+	if expected := [][]int{{0, 2}, {1}, {3}}; !reflect.DeepEqual(groups, expected) {
+		t.Errorf("Expected groups %v, got %v", expected, groups)
+	}
+
+	var output bytes.Buffer
+	fileOps.Output = &output
+	results, err := fileOps.CopyLayers(jobs, "/project")
+	if err != nil {
+		t.Fatalf("CopyLayers failed: %v", err)
+	}
+
+	// The output of each layer is printed whole, in declared order
+	expectedOutput := strings.Join([]string{
+		"  [a]",
+		"  Collecting .gitignore rules: /layers/a/.gitignore.fragment",
+		"  Creating: /project/shared.txt",
+		"  [b]",
+		"  Creating: /project/b.txt",
+		"  [c]",
+		"  Collecting .gitignore rules: /layers/c/.gitignore.fragment",
+		"  Overwriting: /project/shared.txt",
+		"  [d]",
+		"  Creating: /project/sub/nested/{{.Name}}.txt",
+		"  Template processed: /project/sub/nested/{{.Name}}.txt",
+	}, "\n") + "\n"
+	if output.String() != expectedOutput {
+		t.Errorf("Expected the output of each layer in order, got:\n%s", output.String())
+	}
+
+	// Overlapping layers are copied in declared order, so the later one wins
+	if content, _ := fsys.ReadFile("/project/shared.txt"); string(content) != "from c" {
+		t.Errorf("Expected shared.txt from the later layer, got %q", content)
+	}
+	if len(results) != 4 || len(results[1].Changes) != 1 || results[1].Changes[0].Path != "/project/b.txt" {
+		t.Errorf("Expected results per job, got %+v", results)
+	}
+
+	// Fragment rules are collected as if the layers had been copied in sequence
+	if rules := fileOps.gitignoreRules["/project/.gitignore"]; !reflect.DeepEqual(rules, []string{"dist/", "build/"}) {
+		t.Errorf("Expected .gitignore rules of all layers in order, got %v", rules)
+	}
+	if _, err := fsys.Stat("/project/sub/nested/{{.Name}}.txt"); err != nil {
+		t.Errorf("Expected the templated layer to be copied: %v", err)
+	}
+}
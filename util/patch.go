@@ -0,0 +1,453 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DefaultPatchFuzz is the number of context lines that may be ignored at each end of a hunk
+// when it doesn't apply cleanly, matching the default of patch(1)
+const DefaultPatchFuzz = 2
+
+// FilePatch is the set of hunks a unified diff applies to one file
+type FilePatch struct {
+	OldPath string // Empty when the patch creates the file
+	NewPath string // Empty when the patch deletes the file
+	Hunks   []Hunk
+}
+
+// Hunk is one @@ section of a unified diff
+type Hunk struct {
+	OldStart int
+	Header   string   // The @@ line
+	Lines    []string // Diff lines including their ' ', '-', or '+' prefix
+	NoEOL    bool     // The new version of the file has no trailing newline
+}
+
+// PatchResult reports how the hunks of a patch applied to one file
+type PatchResult struct {
+	Path       string
+	Applied    int    // Hunks applied, including those needing an offset or fuzz
+	Fuzzed     int    // Hunks that only applied after ignoring context lines
+	Skipped    int    // Hunks that were already applied
+	Rejected   int    // Hunks that could not be applied
+	RejectPath string // .rej file holding rejected hunks
+}
+
+var hunkHeaderPattern = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// ParsePatch parses a unified diff, as produced by diff -u or git diff, into per-file patches
+func ParsePatch(content []byte) ([]FilePatch, error) {
+	var patches []FilePatch
+	var current *FilePatch
+	var hunk *Hunk
+	lastPrefix := byte(0)
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(make([]byte, 64*1024), 10*1024*1024)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "--- ") && (hunk == nil || hunkComplete(hunk)):
+			patches = append(patches, FilePatch{OldPath: patchPath(line[4:])})
+			current = &patches[len(patches)-1]
+			hunk = nil
+		case strings.HasPrefix(line, "+++ ") && current != nil && len(current.Hunks) == 0 && hunk == nil:
+			current.NewPath = patchPath(line[4:])
+		case strings.HasPrefix(line, "@@ "):
+			if current == nil {
+				return nil, fmt.Errorf("line %d: hunk without a file header", lineNumber)
+			}
+			match := hunkHeaderPattern.FindStringSubmatch(line)
+			if match == nil {
+				return nil, fmt.Errorf("line %d: invalid hunk header: %s", lineNumber, line)
+			}
+			oldStart, _ := strconv.Atoi(match[1])
+			current.Hunks = append(current.Hunks, Hunk{OldStart: oldStart, Header: line})
+			hunk = &current.Hunks[len(current.Hunks)-1]
+		case hunk != nil && strings.HasPrefix(line, `\`):
+			if lastPrefix == '+' || lastPrefix == ' ' {
+				hunk.NoEOL = true
+			}
+		case hunk != nil && line == "":
+			// Some tools strip the space from empty context lines
+			hunk.Lines = append(hunk.Lines, " ")
+			lastPrefix = ' '
+		case hunk != nil && (line[0] == ' ' || line[0] == '-' || line[0] == '+'):
+			hunk.Lines = append(hunk.Lines, line)
+			lastPrefix = line[0]
+		default:
+			// Anything else (diff --git, index lines, commentary) ends the current hunk
+			hunk = nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read patch: %w", err)
+	}
+
+	for _, patch := range patches {
+		if patch.OldPath == "" && patch.NewPath == "" {
+			return nil, fmt.Errorf("patch has no file paths")
+		}
+	}
+
+	return patches, nil
+}
+
+// hunkComplete reports whether a hunk has all the lines its header announces, so a following
+// "--- " line starts a new file rather than removing a line beginning with "-- "
+func hunkComplete(hunk *Hunk) bool {
+	match := hunkHeaderPattern.FindStringSubmatch(hunk.Header)
+	oldCount, newCount := 1, 1
+	if match[2] != "" {
+		oldCount, _ = strconv.Atoi(match[2])
+	}
+	if match[4] != "" {
+		newCount, _ = strconv.Atoi(match[4])
+	}
+
+	for _, line := range hunk.Lines {
+		switch line[0] {
+		case ' ':
+			oldCount--
+			newCount--
+		case '-':
+			oldCount--
+		case '+':
+			newCount--
+		}
+	}
+	return oldCount <= 0 && newCount <= 0
+}
+
+// patchPath strips the timestamp and a/ or b/ prefix from a diff header path
+func patchPath(header string) string {
+	name, _, _ := strings.Cut(header, "\t")
+	name = strings.TrimSpace(name)
+	if name == "/dev/null" {
+		return ""
+	}
+	if strings.HasPrefix(name, "a/") || strings.HasPrefix(name, "b/") {
+		name = name[2:]
+	}
+	return name
+}
+
+// ApplyPatchLayer applies every .patch and .diff file in a layer, in lexical order, to the files
+// under targetPath. Rejected hunks are written next to their file with a .rej suffix and reported
+// in the returned error once all patches have been attempted.
+func (f *FileOperations) ApplyPatchLayer(layerPath, targetPath string, fuzz int) ([]PatchResult, error) {
+	var patchFiles []string
+	err := f.FS.Walk(layerPath, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if !info.IsDir() && (strings.HasSuffix(srcPath, ".patch") || strings.HasSuffix(srcPath, ".diff")) {
+			patchFiles = append(patchFiles, srcPath)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(patchFiles)
+
+	if len(patchFiles) == 0 {
+		return nil, fmt.Errorf("patch layer contains no .patch or .diff files")
+	}
+
+	combinedRules, err := f.combinedIgnoreRules(layerPath)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []PatchResult
+	rejected := 0
+	for _, patchFile := range patchFiles {
+		content, err := f.FS.ReadFile(patchFile)
+		if err != nil {
+			return results, fmt.Errorf("failed to read %s: %w", patchFile, err)
+		}
+		patches, err := ParsePatch(content)
+		if err != nil {
+			return results, fmt.Errorf("failed to parse %s: %w", patchFile, err)
+		}
+
+		fmt.Printf("  Applying patch: %s\n", filepath.Base(patchFile))
+		for _, patch := range patches {
+			// Patched files are held to the ignore rules copied files are
+			rule, err := f.patchIgnoreRule(patch, targetPath, combinedRules)
+			if err != nil {
+				return results, fmt.Errorf("%s: %w", filepath.Base(patchFile), err)
+			}
+			if rule != nil {
+				relativePath := filepath.FromSlash(patchTarget(patch))
+				f.printf("  Ignoring: %s (%s)\n", relativePath, rule)
+				f.Ignored = append(f.Ignored, IgnoredFile{Path: filepath.Join(targetPath, relativePath), Pattern: rule.Pattern, Source: rule.Source})
+				continue
+			}
+			result, err := f.applyFilePatch(patch, targetPath, fuzz)
+			if err != nil {
+				return results, err
+			}
+			results = append(results, result)
+			rejected += result.Rejected
+		}
+	}
+
+	if rejected > 0 {
+		return results, fmt.Errorf("%d hunk(s) could not be applied; see the .rej files", rejected)
+	}
+	return results, nil
+}
+
+// patchTarget returns the path relative to the target of the file a patch changes
+func patchTarget(patch FilePatch) string {
+	if patch.NewPath != "" {
+		return patch.NewPath
+	}
+	return patch.OldPath
+}
+
+// patchIgnoreRule returns an error when a patch names a file outside the target, such as
+// ../escaped.txt, and otherwise the ignore rule that keeps the layer from changing the file, if any
+func (f *FileOperations) patchIgnoreRule(patch FilePatch, targetPath string, rules []IgnoreRule) (*IgnoreRule, error) {
+	for _, name := range []string{patch.OldPath, patch.NewPath} {
+		if name != "" && !filepath.IsLocal(filepath.FromSlash(name)) {
+			return nil, fmt.Errorf("patch for %s names a file outside the target", name)
+		}
+	}
+	for _, name := range []string{patch.OldPath, patch.NewPath} {
+		if name == "" {
+			continue
+		}
+		relativePath := filepath.FromSlash(name)
+		if rule := f.matchingIgnoreRule(relativePath, filepath.Join(targetPath, relativePath), rules); rule != nil {
+			return rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// applyFilePatch applies the hunks of one file patch
+func (f *FileOperations) applyFilePatch(patch FilePatch, targetPath string, fuzz int) (PatchResult, error) {
+	relativePath := patchTarget(patch)
+	destPath := filepath.Join(targetPath, filepath.FromSlash(relativePath))
+	result := PatchResult{Path: destPath}
+
+	var lines []string
+	finalNewline := true
+	mode := os.FileMode(0644)
+	exists := false
+	if info, err := f.FS.Stat(destPath); err == nil {
+		content, err := f.FS.ReadFile(destPath)
+		if err != nil {
+			return result, fmt.Errorf("failed to read %s: %w", destPath, err)
+		}
+		exists, mode = true, info.Mode()
+		text := string(content)
+		finalNewline = text == "" || strings.HasSuffix(text, "\n")
+		if text != "" {
+			lines = strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+		}
+	}
+
+	var rejects []Hunk
+	offset := 0
+	for _, hunk := range patch.Hunks {
+		_, newLines := hunkSides(hunk)
+
+		if patch.OldPath == "" && exists && len(lines) > 0 {
+			if equalLines(lines, newLines) {
+				result.Skipped++
+			} else {
+				rejects = append(rejects, hunk)
+			}
+			continue
+		}
+
+		position, fuzzUsed, ok := locateHunk(lines, hunk, hunk.OldStart-1+offset, 0)
+		if !ok {
+			// A hunk whose result is already present was applied by an earlier build
+			if _, _, applied := locateLines(lines, newLines, hunk.OldStart-1+offset); applied {
+				result.Skipped++
+				continue
+			}
+			if position, fuzzUsed, ok = locateHunk(lines, hunk, hunk.OldStart-1+offset, fuzz); !ok {
+				rejects = append(rejects, hunk)
+				continue
+			}
+		}
+
+		trimmedOld, trimmedNew := trimHunkContext(hunk, fuzzUsed)
+		updated := append([]string{}, lines[:position]...)
+		updated = append(updated, trimmedNew...)
+		updated = append(updated, lines[position+len(trimmedOld):]...)
+		lines = updated
+
+		// Later hunks are numbered against the original file, so track how far this one moved
+		offset = position - leadingContext(hunk, fuzzUsed) - (hunk.OldStart - 1) + len(trimmedNew) - len(trimmedOld)
+
+		result.Applied++
+		if fuzzUsed > 0 {
+			result.Fuzzed++
+		}
+		if hunk.NoEOL {
+			finalNewline = false
+		}
+	}
+
+	if result.Applied > 0 {
+		if patch.NewPath == "" {
+			if err := f.FS.Remove(destPath); err != nil {
+				return result, fmt.Errorf("failed to delete %s: %w", destPath, err)
+			}
+			fmt.Printf("  Deleting: %s\n", destPath)
+			f.Changes = append(f.Changes, FileChange{Path: destPath, Action: "delete"})
+		} else {
+			content := strings.Join(lines, "\n")
+			if finalNewline && len(lines) > 0 {
+				content += "\n"
+			}
+			if err := f.FS.MkdirAll(filepath.Dir(destPath), 0755); err != nil {
+				return result, fmt.Errorf("failed to create directory for %s: %w", destPath, err)
+			}
+			if err := f.FS.WriteFile(destPath, []byte(content), mode); err != nil {
+				return result, fmt.Errorf("failed to write %s: %w", destPath, err)
+			}
+			fmt.Printf("  Patched: %s\n", destPath)
+			f.Changes = append(f.Changes, FileChange{Path: destPath, Action: "patch"})
+		}
+	}
+
+	if result.Fuzzed > 0 {
+		fmt.Printf("    %d hunk(s) applied with fuzz\n", result.Fuzzed)
+	}
+	if result.Skipped > 0 {
+		fmt.Printf("    %d hunk(s) already applied to %s\n", result.Skipped, destPath)
+	}
+
+	if len(rejects) > 0 {
+		result.Rejected = len(rejects)
+		result.RejectPath = destPath + ".rej"
+
+		var rej strings.Builder
+		fmt.Fprintf(&rej, "--- %s\n+++ %s\n", relativePath, relativePath)
+		for _, hunk := range rejects {
+			rej.WriteString(hunk.Header + "\n")
+			for _, line := range hunk.Lines {
+				rej.WriteString(line + "\n")
+			}
+		}
+		if err := f.FS.MkdirAll(filepath.Dir(result.RejectPath), 0755); err != nil {
+			return result, fmt.Errorf("failed to create directory for %s: %w", result.RejectPath, err)
+		}
+		if err := f.FS.WriteFile(result.RejectPath, []byte(rej.String()), 0644); err != nil {
+			return result, fmt.Errorf("failed to write %s: %w", result.RejectPath, err)
+		}
+		fmt.Printf("    %d hunk(s) rejected, saved to %s\n", result.Rejected, result.RejectPath)
+	}
+
+	return result, nil
+}
+
+// hunkSides returns the lines a hunk expects to find and the lines it leaves behind
+func hunkSides(hunk Hunk) (oldLines, newLines []string) {
+	for _, line := range hunk.Lines {
+		switch line[0] {
+		case ' ':
+			oldLines = append(oldLines, line[1:])
+			newLines = append(newLines, line[1:])
+		case '-':
+			oldLines = append(oldLines, line[1:])
+		case '+':
+			newLines = append(newLines, line[1:])
+		}
+	}
+	return oldLines, newLines
+}
+
+// leadingContext returns how many context lines fuzz drops from the start of a hunk
+func leadingContext(hunk Hunk, fuzz int) int {
+	leading := 0
+	for leading < fuzz && leading < len(hunk.Lines) && hunk.Lines[leading][0] == ' ' {
+		leading++
+	}
+	return leading
+}
+
+// trimHunkContext returns the sides of a hunk with up to fuzz context lines dropped from each end
+func trimHunkContext(hunk Hunk, fuzz int) (oldLines, newLines []string) {
+	lines := hunk.Lines
+	for i := 0; i < fuzz && len(lines) > 0 && lines[0][0] == ' '; i++ {
+		lines = lines[1:]
+	}
+	for i := 0; i < fuzz && len(lines) > 0 && lines[len(lines)-1][0] == ' '; i++ {
+		lines = lines[:len(lines)-1]
+	}
+	return hunkSides(Hunk{Lines: lines})
+}
+
+// locateHunk finds where a hunk applies, trying increasing amounts of fuzz
+func locateHunk(lines []string, hunk Hunk, expected, maxFuzz int) (position, fuzz int, ok bool) {
+	for fuzz = 0; fuzz <= maxFuzz; fuzz++ {
+		oldLines, _ := trimHunkContext(hunk, fuzz)
+		if len(oldLines) == 0 && fuzz > 0 {
+			// Without any context left the hunk would match anywhere
+			break
+		}
+		if position, _, ok = locateLines(lines, oldLines, expected+leadingContext(hunk, fuzz)); ok {
+			return position, fuzz, true
+		}
+	}
+	return 0, 0, false
+}
+
+// locateLines finds needle in lines, searching outwards from the expected position
+func locateLines(lines, needle []string, expected int) (position, distance int, ok bool) {
+	maxStart := len(lines) - len(needle)
+	if maxStart < 0 {
+		return 0, 0, false
+	}
+	if expected < 0 {
+		expected = 0
+	}
+	if expected > maxStart {
+		expected = maxStart
+	}
+
+	for distance = 0; distance <= len(lines); distance++ {
+		for _, start := range []int{expected - distance, expected + distance} {
+			if start >= 0 && start <= maxStart && equalLines(lines[start:start+len(needle)], needle) {
+				return start, distance, true
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// equalLines reports whether two line slices are identical
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
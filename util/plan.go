@@ -0,0 +1,344 @@
+package util
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// DefaultPlanFile is where otter plan writes the plan unless told otherwise
+const DefaultPlanFile = "otter.plan.json"
+
+// HookRecordFileName is the name of the record of hook commands inside .otter
+const HookRecordFileName = "hooks.json"
+
+// Plan is a build worked out in advance: the layers and commits it applies and the exact file
+// changes and hook commands, in the order otter apply carries them out
+type Plan struct {
+	Version      int          `json:"version"`
+	Created      time.Time    `json:"created"`
+	OtterVersion string       `json:"otter_version"`
+	Otterfile    string       `json:"otterfile"`
+	Layers       []AuditLayer `json:"layers"`
+	Steps        []PlanStep   `json:"steps"`
+	// Env are the environment variables the Otterfile sets with ENV for hook commands, as KEY=VALUE
+	Env []string `json:"env,omitempty"`
+	// OnError are the ON_ERROR commands otter apply runs when a step fails
+	OnError        []string      `json:"on_error,omitempty"`
+	OnErrorOptions []HookOptions `json:"on_error_options,omitempty"`
+}
+
+// PlanStep is either files to change or hook commands to run
+type PlanStep struct {
+	Files []PlanFile `json:"files,omitempty"`
+
+	Hook     string        `json:"hook,omitempty"`   // Kind of hook, e.g. "after layer"
+	Layers   []string      `json:"layers,omitempty"` // Repositories of the layers declaring layer hooks
+	Commands []string      `json:"commands,omitempty"`
+	Options  []HookOptions `json:"options,omitempty"`
+}
+
+// PlanFile is a file a plan writes or deletes
+type PlanFile struct {
+	Path   string      `json:"path"`   // Relative to the project, with forward slashes
+	Action string      `json:"action"` // "create", "overwrite", or "delete"
+	Mode   os.FileMode `json:"mode,omitempty"`
+	SHA256 string      `json:"sha256,omitempty"` // Hash of the content written
+	// Previous is the hash of the file when the plan was made, empty when it didn't exist; otter
+	// apply refuses to run when the project no longer matches
+	Previous string `json:"previous,omitempty"`
+	Content  []byte `json:"content,omitempty"`
+}
+
+// PlanApproval is a change in a plan that otter apply only makes once it is approved
+type PlanApproval struct {
+	Kind string `json:"kind"` // "delete", "overwrite", or "hook"
+	// Target is the path of the file, the repository of a new layer declaring the hook, or the
+	// hook command
+	Target     string `json:"target"`
+	Reason     string `json:"reason"`
+	ApprovedBy string `json:"approved_by,omitempty"` // "flag" for --approve, or "prompt"
+}
+
+// String returns the approval in the form --approve takes, e.g. delete:docs/old.md
+func (a PlanApproval) String() string {
+	return a.Kind + ":" + a.Target
+}
+
+// Matches reports whether an --approve value covers the approval: "all", a kind such as "delete",
+// or a kind and a target, where the target may be a glob such as overwrite:config/*
+func (a PlanApproval) Matches(approve string) bool {
+	kind, target, found := strings.Cut(approve, ":")
+	if !found {
+		return approve == "all" || approve == a.Kind
+	}
+	if kind != a.Kind {
+		return false
+	}
+	matched, err := path.Match(target, a.Target)
+	return target == a.Target || (err == nil && matched)
+}
+
+// NewPlan creates an empty plan
+func NewPlan(version string) *Plan {
+	return &Plan{
+		Version:      1,
+		Created:      time.Now().UTC(),
+		OtterVersion: version,
+		Layers:       make([]AuditLayer, 0),
+		Steps:        make([]PlanStep, 0),
+	}
+}
+
+// AddFiles adds the changes made through overlay since the previous call as a step, relative to root
+func (p *Plan) AddFiles(overlay *OverlayFileSystem, root string) error {
+	var files []PlanFile
+	for _, change := range overlay.TakeChanges() {
+		relativePath, err := filepath.Rel(root, change.Path)
+		if err != nil || relativePath == ".." || strings.HasPrefix(relativePath, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("cannot plan changes outside the project: %s", change.Path)
+		}
+
+		file := PlanFile{Path: filepath.ToSlash(relativePath), Action: "delete"}
+		if previous, err := overlay.base.ReadFile(change.Path); err == nil {
+			file.Previous = hashContent(previous)
+		} else if info, statErr := overlay.base.Stat(change.Path); statErr == nil && info.IsDir() {
+			file.Previous = "directory"
+		}
+		if !change.Removed {
+			file.Action = "create"
+			if file.Previous != "" {
+				file.Action = "overwrite"
+			}
+			file.Mode = change.Mode
+			file.SHA256 = hashContent(change.Data)
+			file.Content = change.Data
+			if file.SHA256 == file.Previous {
+				continue // Rewritten with the same content
+			}
+		}
+		files = append(files, file)
+	}
+
+	if len(files) > 0 {
+		p.Steps = append(p.Steps, PlanStep{Files: files})
+	}
+	return nil
+}
+
+// AddHook adds hook commands as a step
+func (p *Plan) AddHook(hook string, layers, commands []string, options []HookOptions) {
+	p.Steps = append(p.Steps, PlanStep{Hook: hook, Layers: layers, Commands: commands, Options: options})
+}
+
+// Approvals returns the changes in the plan that need approval: files it deletes, files it
+// overwrites that were edited since a layer wrote them according to manifest, hooks declared by
+// layers that neither manifest nor lock know, and any other hook or ON_ERROR command the last build
+// or apply didn't run according to ran. The plan's own account of where a hook comes from isn't
+// trusted, so a command is only run without approval when it ran before.
+func (p *Plan) Approvals(manifest *FileManifest, lock *Lockfile, ran *HookRecord) []PlanApproval {
+	written := make(map[string]string)
+	known := make(map[string]bool)
+	for _, layer := range manifest.Layers {
+		known[layer.Repository] = true
+		for _, file := range layer.Files {
+			written[filepath.ToSlash(file.Path)] = file.SHA256
+		}
+	}
+	if lock != nil {
+		for _, layer := range lock.Layers {
+			known[layer.Repository] = true
+		}
+	}
+
+	var approvals []PlanApproval
+	seen := make(map[string]bool)
+	add := func(approval PlanApproval) {
+		if !seen[approval.String()] {
+			seen[approval.String()] = true
+			approvals = append(approvals, approval)
+		}
+	}
+
+	previous := make(map[string]bool)
+	if ran != nil {
+		for _, command := range ran.Commands {
+			previous[command] = true
+		}
+	}
+	addCommands := func(hook string, commands []string) {
+		for _, command := range commands {
+			if !previous[command] {
+				add(PlanApproval{Kind: "hook", Target: command, Reason: "runs a " + hook + " command the last build didn't run"})
+			}
+		}
+	}
+
+	planned := make(map[string]bool)
+	for _, step := range p.Steps {
+		for _, file := range step.Files {
+			// Later changes to a file replace content the plan itself wrote
+			first := !planned[file.Path]
+			planned[file.Path] = true
+			switch {
+			case !first || file.Previous == "directory":
+			case file.Action == "delete":
+				add(PlanApproval{Kind: "delete", Target: file.Path, Reason: "file is deleted"})
+			case file.Action == "overwrite" && written[file.Path] != "" && written[file.Path] != file.Previous:
+				add(PlanApproval{Kind: "overwrite", Target: file.Path, Reason: "file was edited since a layer wrote it"})
+			}
+		}
+		newLayer := false
+		for _, layer := range step.Layers {
+			if !known[layer] {
+				newLayer = true
+				add(PlanApproval{Kind: "hook", Target: layer, Reason: "runs " + step.Hook + " commands from a layer not applied before"})
+			}
+		}
+		if !newLayer {
+			addCommands(step.Hook, step.Commands)
+		}
+	}
+	addCommands("error cleanup", p.OnError)
+	return approvals
+}
+
+// HookRecord lists the hook and ON_ERROR commands of the last build or apply of a project, which
+// otter apply runs without asking for approval
+type HookRecord struct {
+	Version  int      `json:"version"`
+	Commands []string `json:"commands"`
+}
+
+// NewHookRecord creates a record of commands, each listed once
+func NewHookRecord(commands []string) *HookRecord {
+	record := &HookRecord{Version: 1, Commands: make([]string, 0, len(commands))}
+	seen := make(map[string]bool)
+	for _, command := range commands {
+		if !seen[command] {
+			seen[command] = true
+			record.Commands = append(record.Commands, command)
+		}
+	}
+	return record
+}
+
+// LoadHookRecord reads the record of hook commands, returning an empty one when it doesn't exist yet
+func LoadHookRecord(fsys FileSystem, path string) (*HookRecord, error) {
+	data, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		return NewHookRecord(nil), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read hook record %s: %w", path, err)
+	}
+
+	record := &HookRecord{}
+	if err := json.Unmarshal(data, record); err != nil {
+		return nil, fmt.Errorf("failed to parse hook record %s: %w", path, err)
+	}
+	return record, nil
+}
+
+// Save writes the record of hook commands
+func (r *HookRecord) Save(fsys FileSystem, path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode hook record: %w", err)
+	}
+	if err := fsys.WriteFile(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write hook record %s: %w", path, err)
+	}
+	return nil
+}
+
+// LoadPlan reads a plan written by otter plan
+func LoadPlan(path string) (*Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read plan %s: %w", path, err)
+	}
+
+	plan := &Plan{}
+	if err := json.Unmarshal(data, plan); err != nil {
+		return nil, fmt.Errorf("failed to parse plan %s: %w", path, err)
+	}
+	if plan.Version != 1 {
+		return nil, fmt.Errorf("unsupported plan version %d in %s", plan.Version, path)
+	}
+	for _, step := range plan.Steps {
+		for _, file := range step.Files {
+			if !filepath.IsLocal(filepath.FromSlash(file.Path)) {
+				return nil, fmt.Errorf("plan %s changes a file outside the project: %s", path, file.Path)
+			}
+			if file.Action != "delete" && hashContent(file.Content) != file.SHA256 {
+				return nil, fmt.Errorf("plan %s is corrupt: content of %s doesn't match its hash", path, file.Path)
+			}
+		}
+	}
+	return plan, nil
+}
+
+// Save writes the plan
+func (p *Plan) Save(path string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode plan: %w", err)
+	}
+	if err := writeFileAtomic(path, append(data, '\n'), 0644); err != nil {
+		return fmt.Errorf("failed to write plan %s: %w", path, err)
+	}
+	return nil
+}
+
+// Stale returns the files the plan changes that no longer match the project the plan was made for
+func (p *Plan) Stale(fsys FileSystem, root string) []string {
+	var stale []string
+	checked := make(map[string]bool)
+	for _, step := range p.Steps {
+		for _, file := range step.Files {
+			if checked[file.Path] {
+				continue
+			}
+			checked[file.Path] = true
+
+			current := ""
+			path := filepath.Join(root, filepath.FromSlash(file.Path))
+			if content, err := fsys.ReadFile(path); err == nil {
+				current = hashContent(content)
+			} else if info, err := fsys.Stat(path); err == nil && info.IsDir() {
+				current = "directory"
+			}
+			if current != file.Previous {
+				stale = append(stale, file.Path)
+			}
+		}
+	}
+	return stale
+}
+
+// ApplyFiles makes the file changes of a step in the project at root
+func (s PlanStep) ApplyFiles(fsys FileSystem, root string) ([]FileChange, error) {
+	changes := make([]FileChange, 0, len(s.Files))
+	for _, file := range s.Files {
+		path := filepath.Join(root, filepath.FromSlash(file.Path))
+		if file.Action == "delete" {
+			if err := fsys.Remove(path); err != nil && !os.IsNotExist(err) {
+				return changes, fmt.Errorf("failed to delete %s: %w", file.Path, err)
+			}
+		} else {
+			if err := fsys.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return changes, fmt.Errorf("failed to create directory for %s: %w", file.Path, err)
+			}
+			if err := fsys.WriteFile(path, file.Content, file.Mode); err != nil {
+				return changes, fmt.Errorf("failed to write %s: %w", file.Path, err)
+			}
+		}
+		changes = append(changes, FileChange{Path: file.Path, Action: file.Action})
+	}
+	return changes, nil
+}
@@ -0,0 +1,156 @@
+package util
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPlan(t *testing.T) {
+	fsys := NewMemFileSystem()
+	fsys.MkdirAll("/project", 0755)
+	fsys.WriteFile("/project/README.md", []byte("readme"), 0644)
+	fsys.WriteFile("/project/same.txt", []byte("same"), 0644)
+	fsys.WriteFile("/project/stale.txt", []byte("stale"), 0644)
+
+	overlay := NewOverlayFileSystem(fsys)
+	plan := NewPlan("test")
+	overlay.WriteFile("/project/README.md", []byte("updated"), 0644)
+	overlay.WriteFile("/project/same.txt", []byte("same"), 0644)
+	if err := plan.AddFiles(overlay, "/project"); err != nil {
+		t.Fatalf("AddFiles failed: %v", err)
+	}
+	plan.AddHook("after layer", []string{"base"}, []string{"make setup"}, nil)
+	overlay.MkdirAll("/project/src", 0755)
+	overlay.WriteFile("/project/src/main.go", []byte("package main"), 0644)
+	overlay.Remove("/project/stale.txt")
+	if err := plan.AddFiles(overlay, "/project"); err != nil {
+		t.Fatalf("AddFiles failed: %v", err)
+	}
+
+	if len(plan.Steps) != 3 || plan.Steps[1].Commands[0] != "make setup" {
+		t.Fatalf("Expected files, hook, and files steps, got %+v", plan.Steps)
+	}
+	// Files rewritten with the same content aren't part of the plan
+	var actions []string
+	for _, step := range plan.Steps {
+		for _, file := range step.Files {
+			actions = append(actions, file.Action+" "+file.Path)
+		}
+	}
+	if expected := []string{"overwrite README.md", "create src/main.go", "delete stale.txt"}; !reflect.DeepEqual(actions, expected) {
+		t.Errorf("Expected %v, got %v", expected, actions)
+	}
+
+	path := filepath.Join(t.TempDir(), DefaultPlanFile)
+	if err := plan.Save(path); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadPlan(path)
+	if err != nil {
+		t.Fatalf("LoadPlan failed: %v", err)
+	}
+
+	if stale := loaded.Stale(fsys, "/project"); len(stale) != 0 {
+		t.Errorf("Expected an up to date plan, got stale files %v", stale)
+	}
+	for _, step := range loaded.Steps {
+		if _, err := step.ApplyFiles(fsys, "/project"); err != nil {
+			t.Fatalf("ApplyFiles failed: %v", err)
+		}
+	}
+	if content, _ := fsys.ReadFile("/project/src/main.go"); string(content) != "package main" {
+		t.Errorf("Expected src/main.go to be written, got %q", content)
+	}
+	if _, err := fsys.Stat("/project/stale.txt"); err == nil {
+		t.Error("Expected stale.txt to be deleted")
+	}
+
+	// Once applied, the project no longer matches what the plan was made against
+	if stale := loaded.Stale(fsys, "/project"); !reflect.DeepEqual(stale, []string{"README.md", "src/main.go", "stale.txt"}) {
+		t.Errorf("Expected every planned file to be stale, got %v", stale)
+	}
+}
+
+func TestPlanApprovals(t *testing.T) {
+	plan := NewPlan("test")
+	plan.Steps = []PlanStep{
+		{Files: []PlanFile{
+			{Path: "kept.txt", Action: "overwrite", Previous: hashContent([]byte("kept"))},
+			{Path: "edited.txt", Action: "overwrite", Previous: hashContent([]byte("edited by hand"))},
+			{Path: "mine.txt", Action: "overwrite", Previous: hashContent([]byte("not from a layer"))},
+			{Path: "docs/old.md", Action: "delete", Previous: hashContent([]byte("old"))},
+			{Path: "docs", Action: "delete", Previous: "directory"},
+		}},
+		{Hook: "after layer", Layers: []string{"base"}, Commands: []string{"make"}},
+		{Hook: "deferred after layer", Layers: []string{"base", "extra"}, Commands: []string{"npm install"}},
+		{Hook: "after build", Commands: []string{"go generate ./..."}},
+		{Files: []PlanFile{{Path: "edited.txt", Action: "overwrite", Previous: hashContent([]byte("edited by hand"))}}},
+	}
+
+	manifest := &FileManifest{Version: 1}
+	manifest.Set(ManifestLayer{Repository: "base", Target: ".", Files: []ManifestFile{
+		{Path: "kept.txt", SHA256: hashContent([]byte("kept"))},
+		{Path: "edited.txt", SHA256: hashContent([]byte("edited"))},
+	}})
+
+	plan.OnError = []string{"make clean"}
+	ran := NewHookRecord([]string{"make", "go generate ./...", "make clean", "make"})
+
+	var approvals []string
+	for _, approval := range plan.Approvals(manifest, nil, ran) {
+		approvals = append(approvals, approval.String())
+	}
+	// Only deleted files, edited layer files, and hooks from layers not applied before need approval
+	if expected := []string{"overwrite:edited.txt", "delete:docs/old.md", "hook:extra"}; !reflect.DeepEqual(approvals, expected) {
+		t.Errorf("Expected approvals %v, got %v", expected, approvals)
+	}
+
+	lock := &Lockfile{Layers: []LockedLayer{{Repository: "extra"}}}
+	approvals = nil
+	for _, approval := range plan.Approvals(manifest, lock, ran) {
+		approvals = append(approvals, approval.String())
+	}
+	// A pinned layer's hooks still need approval for commands the last build didn't run
+	if expected := []string{"overwrite:edited.txt", "delete:docs/old.md", "hook:npm install"}; !reflect.DeepEqual(approvals, expected) {
+		t.Errorf("Expected approvals %v, got %v", expected, approvals)
+	}
+
+	// Commands the plan doesn't attribute to a layer need approval unless they ran before
+	approvals = nil
+	for _, approval := range plan.Approvals(manifest, lock, NewHookRecord([]string{"make", "npm install"})) {
+		approvals = append(approvals, approval.String())
+	}
+	if expected := []string{"overwrite:edited.txt", "delete:docs/old.md", "hook:go generate ./...", "hook:make clean"}; !reflect.DeepEqual(approvals, expected) {
+		t.Errorf("Expected approvals %v, got %v", expected, approvals)
+	}
+
+	approval := PlanApproval{Kind: "delete", Target: "docs/old.md"}
+	for approve, expected := range map[string]bool{
+		"all": true, "delete": true, "delete:docs/old.md": true, "delete:docs/*": true,
+		"overwrite": false, "delete:old.md": false, "overwrite:docs/old.md": false,
+	} {
+		if approval.Matches(approve) != expected {
+			t.Errorf("Expected --approve %s to match: %v", approve, expected)
+		}
+	}
+}
+
+func TestHookRecord(t *testing.T) {
+	fsys := NewMemFileSystem()
+	fsys.MkdirAll("/project/.otter", 0755)
+	if record, err := LoadHookRecord(fsys, "/project/.otter/hooks.json"); err != nil || len(record.Commands) != 0 {
+		t.Fatalf("Expected an empty record before the first build, got %v, %v", record, err)
+	}
+
+	if err := NewHookRecord([]string{"make", "npm install", "make"}).Save(fsys, "/project/.otter/hooks.json"); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	record, err := LoadHookRecord(fsys, "/project/.otter/hooks.json")
+	if err != nil {
+		t.Fatalf("LoadHookRecord failed: %v", err)
+	}
+	if expected := []string{"make", "npm install"}; !reflect.DeepEqual(record.Commands, expected) {
+		t.Errorf("Expected commands %v, got %v", expected, record.Commands)
+	}
+}
@@ -0,0 +1,107 @@
+package util
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// ProjectInfo holds read-only facts about the project that templates and conditions can use.
+// Facts that can't be detected are empty.
+type ProjectInfo struct {
+	Module        string // Go module path from go.mod
+	Package       string // Package name from package.json
+	Remote        string // URL of the origin remote
+	Branch        string // Currently checked out branch
+	DefaultBranch string // Default branch of the origin remote, or main/master when unknown
+}
+
+// DetectProject inspects the project root for module files and git metadata
+func DetectProject(projectRoot string) *ProjectInfo {
+	info := &ProjectInfo{
+		Module:  goModulePath(filepath.Join(projectRoot, "go.mod")),
+		Package: packageJSONName(filepath.Join(projectRoot, "package.json")),
+	}
+
+	repo, err := git.PlainOpenWithOptions(projectRoot, &git.PlainOpenOptions{DetectDotGit: true})
+	if err != nil {
+		return info
+	}
+
+	if remote, err := repo.Remote("origin"); err == nil && len(remote.Config().URLs) > 0 {
+		info.Remote = remote.Config().URLs[0]
+	}
+
+	if head, err := repo.Head(); err == nil && head.Name().IsBranch() {
+		info.Branch = head.Name().Short()
+	}
+
+	if ref, err := repo.Reference(plumbing.NewRemoteHEADReferenceName("origin"), false); err == nil && ref.Type() == plumbing.SymbolicReference {
+		info.DefaultBranch = strings.TrimPrefix(ref.Target().Short(), "origin/")
+	} else {
+		for _, candidate := range []string{"main", "master"} {
+			if _, err := repo.Reference(plumbing.NewBranchReferenceName(candidate), false); err == nil {
+				info.DefaultBranch = candidate
+				break
+			}
+		}
+	}
+
+	return info
+}
+
+// Fact returns a fact by its condition key, e.g. "module" or "default_branch"
+func (p *ProjectInfo) Fact(key string) (string, bool) {
+	switch strings.ToLower(key) {
+	case "module":
+		return p.Module, true
+	case "package":
+		return p.Package, true
+	case "remote":
+		return p.Remote, true
+	case "branch":
+		return p.Branch, true
+	case "default_branch":
+		return p.DefaultBranch, true
+	}
+	return "", false
+}
+
+// goModulePath reads the module path from a go.mod file
+func goModulePath(goModPath string) string {
+	content, err := os.ReadFile(goModPath)
+	if err != nil {
+		return ""
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
+
+// packageJSONName reads the name field from a package.json file
+func packageJSONName(packageJSONPath string) string {
+	content, err := os.ReadFile(packageJSONPath)
+	if err != nil {
+		return ""
+	}
+
+	var pkg struct {
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(content, &pkg); err != nil {
+		return ""
+	}
+	return pkg.Name
+}
@@ -0,0 +1,104 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+func TestDetectProject(t *testing.T) {
+	projectDir := t.TempDir()
+	files := map[string]string{
+		"go.mod":       "module github.com/example/api\n\ngo 1.22\n",
+		"package.json": `{"name": "@example/web", "version": "1.0.0"}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(projectDir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	// Without git metadata only the module files are detected
+	info := DetectProject(projectDir)
+	if info.Module != "github.com/example/api" || info.Package != "@example/web" || info.Remote != "" {
+		t.Errorf("Unexpected project info: %+v", info)
+	}
+
+	repo, err := git.PlainInit(projectDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	worktree, _ := repo.Worktree()
+	worktree.Add("go.mod")
+	commit, err := worktree.Commit("initial", &git.CommitOptions{
+		Author: &object.Signature{Name: "Test", Email: "test@example.com", When: time.Now()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to commit: %v", err)
+	}
+	if err := worktree.Checkout(&git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName("feature/x"), Create: true, Hash: commit}); err != nil {
+		t.Fatalf("Failed to create branch: %v", err)
+	}
+	if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"git@github.com:example/api.git"}}); err != nil {
+		t.Fatalf("Failed to add remote: %v", err)
+	}
+
+	info = DetectProject(projectDir)
+	if info.Remote != "git@github.com:example/api.git" || info.Branch != "feature/x" || info.DefaultBranch != "master" {
+		t.Errorf("Unexpected git facts: %+v", info)
+	}
+
+	// The remote's HEAD takes precedence over local branch names
+	originHead := plumbing.NewSymbolicReference(plumbing.NewRemoteHEADReferenceName("origin"), plumbing.NewRemoteReferenceName("origin", "trunk"))
+	if err := repo.Storer.SetReference(originHead); err != nil {
+		t.Fatalf("Failed to set origin HEAD: %v", err)
+	}
+	if info = DetectProject(projectDir); info.DefaultBranch != "trunk" {
+		t.Errorf("Expected default branch from origin HEAD, got %s", info.DefaultBranch)
+	}
+
+	if value, ok := info.Fact("default_branch"); !ok || value != "trunk" {
+		t.Errorf("Unexpected default_branch fact: %s, %v", value, ok)
+	}
+	if _, ok := info.Fact("unknown"); ok {
+		t.Error("Expected unknown fact to be rejected")
+	}
+}
+
+func TestTemplateProjectFacts(t *testing.T) {
+	fsys := NewMemFileSystem()
+	for _, dir := range []string{"/layer", "/project"} {
+		if err := fsys.MkdirAll(dir, 0755); err != nil {
+			t.Fatalf("Failed to create %s: %v", dir, err)
+		}
+	}
+	if err := fsys.WriteFile("/layer/README.md", []byte("# {{ .Project.Module }} ({{ .Project.Branch }})\n"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+	if err := fsys.WriteFile("/layer/chart.yaml", []byte("name: {{ .Values.name }}\n"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+
+	fileOps := NewFileOperationsWithFS(fsys)
+	fileOps.Project = &ProjectInfo{Module: "github.com/example/api", Branch: "main"}
+
+	// Project facts are rendered without TEMPLATE variables; other template syntax is left alone
+	if err := fileOps.CopyLayer("/layer", "/project", "/project", nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	readme, _ := fsys.ReadFile("/project/README.md")
+	if string(readme) != "# github.com/example/api (main)\n" {
+		t.Errorf("Unexpected rendered README: %q", readme)
+	}
+	chart, _ := fsys.ReadFile("/project/chart.yaml")
+	if string(chart) != "name: {{ .Values.name }}\n" {
+		t.Errorf("Expected chart to be copied as is, got %q", chart)
+	}
+}
@@ -0,0 +1,21 @@
+package util
+
+import "time"
+
+// BuildInfo describes the build rendering templates, available to them as .Build
+type BuildInfo struct {
+	Date         time.Time // When the build started, in UTC
+	OtterVersion string
+}
+
+// NewBuildInfo describes a build starting now
+func NewBuildInfo(version string) *BuildInfo {
+	return &BuildInfo{Date: time.Now().UTC(), OtterVersion: version}
+}
+
+// LayerInfo describes the layer being applied, available to templates as .Layer
+type LayerInfo struct {
+	Repository string
+	Commit     string // Commit the files come from, or "local-dir" for local and built-in layers
+	Target     string
+}
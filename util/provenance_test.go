@@ -0,0 +1,36 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTemplateProvenance(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	content := "# {{ .Layer.Repository }}@{{ .Layer.Commit }} by otter {{ .Build.OtterVersion }} on {{ .Build.Date.Format \"2006-01-02\" }}\n"
+	os.WriteFile(filepath.Join(layerDir, "PROVENANCE"), []byte(content), 0644)
+
+	fileOps := NewFileOperations()
+	fileOps.Build = &BuildInfo{Date: time.Date(2026, 3, 14, 9, 0, 0, 0, time.UTC), OtterVersion: "v1.2.3"}
+	jobs := []CopyJob{{
+		Source: layerDir,
+		Target: targetDir,
+		Delims: [2]string{"{{", "}}"},
+		Layer:  &LayerInfo{Repository: "git@github.com:example/layer.git", Commit: "abc123", Target: "."},
+	}}
+	if _, err := fileOps.CopyLayers(jobs, targetDir); err != nil {
+		t.Fatalf("CopyLayers failed: %v", err)
+	}
+
+	written, err := os.ReadFile(filepath.Join(targetDir, "PROVENANCE"))
+	if err != nil {
+		t.Fatalf("Failed to read PROVENANCE: %v", err)
+	}
+	expected := "# git@github.com:example/layer.git@abc123 by otter v1.2.3 on 2026-03-14\n"
+	if string(written) != expected {
+		t.Errorf("Expected %q, got %q", expected, written)
+	}
+}
@@ -0,0 +1,111 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxReportFiles is how many files of each kind a layer report lists per directory before
+// summarizing the rest, so large layers stay reviewable
+const maxReportFiles = 10
+
+// LayerSummary counts the files a layer added, modified, and skipped
+type LayerSummary struct {
+	Added    int `json:"added"`
+	Modified int `json:"modified"`
+	Skipped  int `json:"skipped"`
+}
+
+// LayerReport groups the files a layer added, modified, and skipped by directory
+type LayerReport struct {
+	Summary LayerSummary
+	dirs    map[string][]reportEntry
+}
+
+// reportEntry is a file in a LayerReport, marked + when added, ~ when modified, or - when skipped
+type reportEntry struct {
+	mark   string
+	name   string
+	reason string
+}
+
+// NewLayerReport builds the report of a layer from the files it wrote and ignored. Paths are
+// shown as given, so they should be relative to the project.
+func NewLayerReport(changes []FileChange, ignored []IgnoredFile) *LayerReport {
+	r := &LayerReport{dirs: make(map[string][]reportEntry)}
+	for _, change := range changes {
+		switch change.Action {
+		case "create", "generate", "upload":
+			r.Summary.Added++
+			r.add(change.Path, reportEntry{mark: "+"})
+		default:
+			r.Summary.Modified++
+			r.add(change.Path, reportEntry{mark: "~", reason: change.Action})
+		}
+	}
+	for _, file := range ignored {
+		r.Summary.Skipped++
+		r.add(file.Path, reportEntry{mark: "-", reason: file.Pattern + " from " + file.Source})
+	}
+	return r
+}
+
+func (r *LayerReport) add(filePath string, entry reportEntry) {
+	filePath = filepath.ToSlash(filePath)
+	dir := path.Dir(filePath)
+	entry.name = path.Base(filePath)
+	r.dirs[dir] = append(r.dirs[dir], entry)
+}
+
+// String renders the summary line followed by a tree of directories and their files. Within a
+// directory, added files are listed first, then modified and skipped ones.
+func (r *LayerReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d added, %d modified, %d skipped\n", r.Summary.Added, r.Summary.Modified, r.Summary.Skipped)
+
+	dirs := make([]string, 0, len(r.dirs))
+	for dir := range r.dirs {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		fmt.Fprintf(&b, "%s/\n", dir)
+		entries := r.dirs[dir]
+		sort.SliceStable(entries, func(i, j int) bool {
+			if entries[i].mark != entries[j].mark {
+				return markOrder(entries[i].mark) < markOrder(entries[j].mark)
+			}
+			return entries[i].name < entries[j].name
+		})
+
+		shown := make(map[string]int)
+		hidden := make(map[string]int)
+		for _, entry := range entries {
+			if shown[entry.mark] == maxReportFiles {
+				hidden[entry.mark]++
+				continue
+			}
+			shown[entry.mark]++
+			if entry.reason != "" {
+				fmt.Fprintf(&b, "  %s %s (%s)\n", entry.mark, entry.name, entry.reason)
+			} else {
+				fmt.Fprintf(&b, "  %s %s\n", entry.mark, entry.name)
+			}
+		}
+		for _, mark := range []string{"+", "~", "-"} {
+			if hidden[mark] > 0 {
+				fmt.Fprintf(&b, "  %s ... %d more\n", mark, hidden[mark])
+			}
+		}
+	}
+	return b.String()
+}
+
+// markOrder orders report entries as added, modified, then skipped
+func markOrder(mark string) int {
+	return strings.Index("+~-", mark)
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestLayerReport(t *testing.T) {
+	changes := []FileChange{
+		{Path: "src/main.go", Action: "create"},
+		{Path: "README.md", Action: "overwrite"},
+		{Path: "Makefile", Action: "create"},
+		{Path: ".vscode/settings.json", Action: "merge"},
+	}
+	ignored := []IgnoredFile{{Path: "secret.env", Pattern: "*.env", Source: "project"}}
+
+	report := NewLayerReport(changes, ignored)
+	if report.Summary != (LayerSummary{Added: 2, Modified: 2, Skipped: 1}) {
+		t.Errorf("Unexpected summary: %+v", report.Summary)
+	}
+
+	want := `2 added, 2 modified, 1 skipped
+./
+  + Makefile
+  ~ README.md (overwrite)
+  - secret.env (*.env from project)
+.vscode/
+  ~ settings.json (merge)
+src/
+  + main.go
+`
+	if got := report.String(); got != want {
+		t.Errorf("Unexpected report:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestLayerReportSummarizesLargeDirectories(t *testing.T) {
+	var changes []FileChange
+	for i := 0; i < maxReportFiles+5; i++ {
+		changes = append(changes, FileChange{Path: fmt.Sprintf("assets/icon%02d.png", i), Action: "create"})
+	}
+
+	got := NewLayerReport(changes, nil).String()
+	if strings.Count(got, "  + icon") != maxReportFiles {
+		t.Errorf("Expected %d files listed, got:\n%s", maxReportFiles, got)
+	}
+	if !strings.Contains(got, "  + ... 5 more\n") {
+		t.Errorf("Expected the remaining files to be summarized, got:\n%s", got)
+	}
+}
@@ -0,0 +1,203 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/template"
+	"text/template/parse"
+)
+
+// allTemplateVariables marks a file whose template passes its whole data along, so any change affects it
+const allTemplateVariables = "*"
+
+// templateVariables returns the names of the template variables content refers to, or
+// allTemplateVariables when the template uses its data as a whole
+func templateVariables(content string, delims [2]string) []string {
+	tmpl, err := template.New("").Delims(delims[0], delims[1]).Funcs(conditionFuncs(nil)).Parse(content)
+	if err != nil {
+		return []string{allTemplateVariables}
+	}
+
+	used := make(map[string]bool)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			collectTemplateFields(t.Tree.Root, used)
+		}
+	}
+	if used[allTemplateVariables] {
+		return []string{allTemplateVariables}
+	}
+
+	names := make([]string, 0, len(used))
+	for name := range used {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// usesTemplateFacts reports whether content is a template referring to the facts f provides, such
+// as .Project or .Env, or calling condition functions. Content that doesn't parse as a template with
+// the functions layers have, such as a GitHub Actions workflow using ${{ vars.NAME }} or a Helm
+// chart using include, isn't a layer template, and mentioning a fact in its text doesn't make it one.
+func (f *FileOperations) usesTemplateFacts(content string, delims [2]string) bool {
+	tmpl, err := template.New("").Delims(delims[0], delims[1]).Funcs(f.templateFuncs()).Parse(content)
+	if err != nil {
+		return false
+	}
+
+	used := make(map[string]bool)
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			collectTemplateFields(t.Tree.Root, used)
+		}
+	}
+	return (f.Project != nil && used["Project"]) ||
+		(f.User != nil && used["User"]) ||
+		(f.Build != nil && used["Build"]) ||
+		(f.Layer != nil && used["Layer"]) ||
+		(f.Env != nil && used["Env"]) ||
+		(f.Condition != nil && usesConditions(content))
+}
+
+// collectTemplateFields records the top-level fields a template node refers to. Fields inside
+// range and with blocks are recorded too, which may include names that aren't variables.
+func collectTemplateFields(node parse.Node, used map[string]bool) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			collectTemplateFields(child, used)
+		}
+	case *parse.ActionNode:
+		collectTemplateFields(n.Pipe, used)
+	case *parse.PipeNode:
+		if n == nil {
+			return
+		}
+		for _, cmd := range n.Cmds {
+			collectTemplateFields(cmd, used)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			collectTemplateFields(arg, used)
+		}
+	case *parse.ChainNode:
+		collectTemplateFields(n.Node, used)
+	case *parse.FieldNode:
+		used[n.Ident[0]] = true
+	case *parse.VariableNode:
+		if n.Ident[0] == "$" {
+			if len(n.Ident) > 1 {
+				used[n.Ident[1]] = true
+			} else {
+				used[allTemplateVariables] = true
+			}
+		}
+	case *parse.DotNode:
+		used[allTemplateVariables] = true
+	case *parse.IfNode:
+		collectTemplateBranch(&n.BranchNode, used)
+	case *parse.RangeNode:
+		collectTemplateBranch(&n.BranchNode, used)
+	case *parse.WithNode:
+		collectTemplateBranch(&n.BranchNode, used)
+	case *parse.TemplateNode:
+		collectTemplateFields(n.Pipe, used)
+	}
+}
+
+// collectTemplateBranch records the fields of an if, range, or with block
+func collectTemplateBranch(n *parse.BranchNode, used map[string]bool) {
+	collectTemplateFields(n.Pipe, used)
+	collectTemplateFields(n.List, used)
+	collectTemplateFields(n.ElseList, used)
+}
+
+// changedTemplateValues returns the names of the values that differ between two TEMPLATE clauses
+func changedTemplateValues(previous, current map[string]string) map[string]bool {
+	changed := make(map[string]bool)
+	for key, value := range current {
+		if old, ok := previous[key]; !ok || old != value {
+			changed[key] = true
+		}
+	}
+	for key := range previous {
+		if _, ok := current[key]; !ok {
+			changed[key] = true
+		}
+	}
+	return changed
+}
+
+// RenderTemplateChanges re-renders only the files of a layer that refer to TEMPLATE values that
+// changed since the files were recorded, for a layer whose commit is unchanged. Files referring to
+// project, developer, or build facts are always re-rendered. It returns the updated manifest files, or false when the
+// layer has to be copied in full because files were modified or removed, or the previous build
+// didn't render templates.
+func (f *FileOperations) RenderTemplateChanges(layerPath, root string, previous ManifestLayer, templateVars map[string]string, delims [2]string) ([]ManifestFile, bool, error) {
+	if len(previous.Template) == 0 || len(templateVars) == 0 {
+		return nil, false, nil
+	}
+	if len(previous.Delims) != 2 || previous.Delims[0] != delims[0] || previous.Delims[1] != delims[1] {
+		return nil, false, nil
+	}
+
+	// Every recorded file must still be as otter wrote it
+	for _, file := range previous.Files {
+		content, err := f.FS.ReadFile(filepath.Join(root, filepath.FromSlash(file.Path)))
+		if err != nil || hashContent(content) != file.SHA256 {
+			return nil, false, nil
+		}
+	}
+
+	changed := changedTemplateValues(previous.Template, templateVars)
+	changed["Project"] = true
+	changed["User"] = true
+	changed["Build"] = true
+	changed["Env"] = true
+	changed[allTemplateVariables] = true
+
+	files := make([]ManifestFile, 0, len(previous.Files))
+	for _, file := range previous.Files {
+		affected := false
+		for _, name := range file.Variables {
+			if changed[name] {
+				affected = true
+				break
+			}
+		}
+		if !affected {
+			files = append(files, file)
+			continue
+		}
+
+		relativePath, err := filepath.Rel(filepath.FromSlash(previous.Target), filepath.FromSlash(file.Path))
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to get relative path: %w", err)
+		}
+		srcPath := filepath.Join(layerPath, relativePath)
+		info, err := f.layerFS().Stat(srcPath)
+		if os.IsNotExist(err) {
+			return nil, false, nil
+		}
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to stat %s: %w", srcPath, err)
+		}
+
+		if err := f.copyFile(srcPath, filepath.Join(root, filepath.FromSlash(file.Path)), relativePath, info.Mode(), templateVars, delims); err != nil {
+			return nil, false, err
+		}
+		rendered, err := f.ManifestFiles(root, []string{filepath.FromSlash(file.Path)})
+		if err != nil {
+			return nil, false, err
+		}
+		files = append(files, rendered...)
+	}
+
+	return files, true, nil
+}
@@ -0,0 +1,84 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTemplateVariables(t *testing.T) {
+	delims := [2]string{"{{", "}}"}
+	tests := []struct {
+		content  string
+		expected []string
+	}{
+		{"name: {{.Name}}", []string{"Name"}},
+		{"{{if .Debug}}{{.Level | printf \"%s\"}}{{end}}", []string{"Debug", "Level"}},
+		{"{{range .Items}}{{$.Owner}}{{end}}", []string{"Items", "Owner"}},
+		{"{{.Project.Language}}", []string{"Project"}},
+		{"{{template \"x\" .}}", []string{allTemplateVariables}},
+		{"plain text", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := templateVariables(tt.content, delims); !reflect.DeepEqual(got, tt.expected) {
+			t.Errorf("templateVariables(%q) = %v, expected %v", tt.content, got, tt.expected)
+		}
+	}
+}
+
+func TestRenderTemplateChanges(t *testing.T) {
+	fsys := NewMemFileSystem()
+	delims := [2]string{"{{", "}}"}
+	layerFiles := map[string]string{
+		"/layer/name.txt":    "name: {{.Name}}",
+		"/layer/version.txt": "version: {{.Version}}",
+		"/layer/plain.txt":   "plain",
+	}
+	if err := fsys.MkdirAll("/layer", 0755); err != nil {
+		t.Fatalf("Failed to create layer: %v", err)
+	}
+	for path, content := range layerFiles {
+		if err := fsys.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", path, err)
+		}
+	}
+
+	values := map[string]string{"Name": "otter", "Version": "1"}
+	fileOps := NewFileOperationsWithFS(fsys)
+	if err := fileOps.CopyLayer("/layer", "/project", "/project", values, delims, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	fileOps.TakeChanges()
+	files, err := fileOps.ManifestFiles("/project", []string{"name.txt", "plain.txt", "version.txt"})
+	if err != nil {
+		t.Fatalf("ManifestFiles failed: %v", err)
+	}
+	previous := ManifestLayer{Repository: "layer", Target: ".", Template: values, Delims: delims[:], Files: files}
+
+	// Only the file using the changed value is rendered again
+	updated := map[string]string{"Name": "otter", "Version": "2"}
+	rendered, ok, err := fileOps.RenderTemplateChanges("/layer", "/project", previous, updated, delims)
+	if err != nil || !ok {
+		t.Fatalf("RenderTemplateChanges failed: %v (ok=%v)", err, ok)
+	}
+	changes := fileOps.TakeChanges()
+	if len(changes) != 1 || changes[0].Path != "/project/version.txt" {
+		t.Errorf("Expected only version.txt to be written, got %+v", changes)
+	}
+	if content, _ := fsys.ReadFile("/project/version.txt"); string(content) != "version: 2" {
+		t.Errorf("Expected version.txt to be re-rendered, got %q", content)
+	}
+	if len(rendered) != 3 || rendered[2].SHA256 == files[2].SHA256 {
+		t.Errorf("Expected the manifest to record the new hash of version.txt, got %+v", rendered)
+	}
+
+	// A locally modified file means the layer is copied in full
+	if err := fsys.WriteFile("/project/plain.txt", []byte("edited"), 0644); err != nil {
+		t.Fatalf("Failed to edit plain.txt: %v", err)
+	}
+	previous.Files = rendered
+	previous.Template = updated
+	if _, ok, _ := fileOps.RenderTemplateChanges("/layer", "/project", previous, values, delims); ok {
+		t.Errorf("Expected a modified file to require a full copy")
+	}
+}
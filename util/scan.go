@@ -0,0 +1,77 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ScanConfig configures the scanner run against fetched layer content before it is copied
+type ScanConfig struct {
+	// Command is run through the shell from inside the layer directory; the layer path is
+	// also available as $OTTER_LAYER_PATH. A non-zero exit quarantines the layer.
+	Command string `json:"command"`
+}
+
+// ScanError reports a layer rejected by the scanner
+type ScanError struct {
+	Repository string
+	Output     string
+	Err        error
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("layer %s failed scan (%v):\n%s", e.Repository, e.Err, strings.TrimRight(e.Output, "\n"))
+}
+
+func (e *ScanError) Unwrap() error {
+	return e.Err
+}
+
+// ScanLayer runs the configured scan command against a layer directory.
+// It returns a *ScanError carrying the scanner output when the command fails.
+func ScanLayer(config ScanConfig, repository, layerPath string) error {
+	if config.Command == "" {
+		return nil
+	}
+
+	cmd := shellCommand(config.Command)
+	cmd.Dir = layerPath
+	cmd.Env = append(os.Environ(), "OTTER_LAYER_PATH="+layerPath, "OTTER_LAYER_REPOSITORY="+repository)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return &ScanError{Repository: repository, Output: string(output), Err: err}
+	}
+
+	return nil
+}
+
+// QuarantineLayer records a failed scan under .otter/quarantine and, for layers fetched into the
+// cache, moves the cached copy there so it is fetched afresh rather than reused.
+// It returns the path of the quarantine record.
+func QuarantineLayer(otterDir, layerPath string, scanErr *ScanError) (string, error) {
+	quarantineDir := filepath.Join(otterDir, "quarantine")
+	if err := os.MkdirAll(quarantineDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create quarantine directory: %w", err)
+	}
+
+	name := fmt.Sprintf("%s-%s", filepath.Base(layerPath), time.Now().UTC().Format("20060102T150405Z"))
+
+	cacheDir := filepath.Join(otterDir, "cache")
+	if rel, err := filepath.Rel(cacheDir, layerPath); err == nil && !strings.HasPrefix(rel, "..") {
+		if err := os.Rename(layerPath, filepath.Join(quarantineDir, name)); err != nil {
+			return "", fmt.Errorf("failed to quarantine layer: %w", err)
+		}
+	}
+
+	report := fmt.Sprintf("repository: %s\npath: %s\nerror: %v\n\n%s", scanErr.Repository, layerPath, scanErr.Err, scanErr.Output)
+	reportPath := filepath.Join(quarantineDir, name+".log")
+	if err := os.WriteFile(reportPath, []byte(report), 0644); err != nil {
+		return "", fmt.Errorf("failed to write quarantine report: %w", err)
+	}
+
+	return reportPath, nil
+}
@@ -0,0 +1,83 @@
+package util
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScanLayer(t *testing.T) {
+	layerDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(layerDir, "secret.txt"), []byte("AKIAEXAMPLE"), 0644); err != nil {
+		t.Fatalf("Failed to write layer file: %v", err)
+	}
+
+	t.Run("no command configured", func(t *testing.T) {
+		if err := ScanLayer(ScanConfig{}, "./layer", layerDir); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+
+	t.Run("passing scan", func(t *testing.T) {
+		config := ScanConfig{Command: `test -d "$OTTER_LAYER_PATH"`}
+		if err := ScanLayer(config, "./layer", layerDir); err != nil {
+			t.Errorf("Expected scan to pass, got %v", err)
+		}
+	})
+
+	t.Run("failing scan carries output", func(t *testing.T) {
+		config := ScanConfig{Command: "grep -l AKIA *.txt && exit 1"}
+		err := ScanLayer(config, "./layer", layerDir)
+
+		var scanErr *ScanError
+		if !errors.As(err, &scanErr) {
+			t.Fatalf("Expected ScanError, got %v", err)
+		}
+		if !strings.Contains(scanErr.Output, "secret.txt") {
+			t.Errorf("Expected scanner output in error, got %q", scanErr.Output)
+		}
+	})
+}
+
+func TestQuarantineLayer(t *testing.T) {
+	otterDir := filepath.Join(t.TempDir(), ".otter")
+	scanErr := &ScanError{Repository: "git@github.com:example/layer.git", Output: "found secret", Err: errors.New("exit status 1")}
+
+	t.Run("cached layer is moved", func(t *testing.T) {
+		cachedLayer := filepath.Join(otterDir, "cache", "layer-abcd1234")
+		if err := os.MkdirAll(cachedLayer, 0755); err != nil {
+			t.Fatalf("Failed to create cached layer: %v", err)
+		}
+
+		reportPath, err := QuarantineLayer(otterDir, cachedLayer, scanErr)
+		if err != nil {
+			t.Fatalf("Failed to quarantine layer: %v", err)
+		}
+
+		if _, err := os.Stat(cachedLayer); !os.IsNotExist(err) {
+			t.Error("Expected cached layer to be moved out of the cache")
+		}
+
+		report, err := os.ReadFile(reportPath)
+		if err != nil {
+			t.Fatalf("Failed to read quarantine report: %v", err)
+		}
+		if !strings.Contains(string(report), "found secret") {
+			t.Errorf("Expected scanner output in report, got %s", report)
+		}
+	})
+
+	t.Run("local layer is left in place", func(t *testing.T) {
+		localLayer := t.TempDir()
+
+		if _, err := QuarantineLayer(otterDir, localLayer, scanErr); err != nil {
+			t.Fatalf("Failed to quarantine layer: %v", err)
+		}
+
+		if _, err := os.Stat(localLayer); err != nil {
+			t.Error("Expected local layer directory to remain")
+		}
+	})
+}
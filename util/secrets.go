@@ -0,0 +1,71 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Policies for credentials in the content layers write, set with secret_scan in .otter/config.json
+const (
+	SecretScanOff  = "off"  // Don't look for credentials (default)
+	SecretScanWarn = "warn" // Report files that look like they contain credentials as warnings
+	SecretScanFail = "fail" // Stop the build before writing a file that looks like it contains credentials
+)
+
+// ErrSecretFound is returned when content a layer would write looks like it contains a credential
+var ErrSecretFound = errors.New("layer output looks like it contains a credential")
+
+// secretPatterns match credentials in file content
+var secretPatterns = []struct {
+	name    string
+	pattern *regexp.Regexp
+}{
+	{"a private key", regexp.MustCompile(`-----BEGIN ([A-Z0-9]+ )*PRIVATE KEY-----`)},
+	{"an AWS access key", regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"a GitHub token", regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"a GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}`)},
+	{"a Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{"a Stripe key", regexp.MustCompile(`\b[rs]k_live_[A-Za-z0-9]{20,}`)},
+}
+
+// SecretMatch is a line of content that looks like it contains a credential
+type SecretMatch struct {
+	Line int
+	Kind string // What the credential looks like, e.g. "a private key"
+}
+
+func (m SecretMatch) String() string {
+	return fmt.Sprintf("%s on line %d", m.Kind, m.Line)
+}
+
+// ResolveSecretScan validates a secret_scan policy, returning it in lower case
+func ResolveSecretScan(policy string) (string, error) {
+	switch policy = strings.ToLower(policy); policy {
+	case "":
+		return SecretScanOff, nil
+	case SecretScanOff, SecretScanWarn, SecretScanFail:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("unknown secret_scan policy %q (expected off, warn, or fail)", policy)
+	}
+}
+
+// findSecrets returns the lines of content that look like they contain credentials. Binary
+// content is skipped.
+func findSecrets(content []byte) []SecretMatch {
+	if bytes.IndexByte(content, 0) >= 0 {
+		return nil
+	}
+	var matches []SecretMatch
+	for i, line := range strings.SplitAfter(string(content), "\n") {
+		for _, secret := range secretPatterns {
+			if secret.pattern.MatchString(line) {
+				matches = append(matches, SecretMatch{Line: i + 1, Kind: secret.name})
+			}
+		}
+	}
+	return matches
+}
@@ -0,0 +1,118 @@
+package util
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// SignatureSuffix is appended to a file name to locate its minisign signature
+const SignatureSuffix = ".minisig"
+
+// minisign algorithm identifiers
+const (
+	minisignAlgLegacy    = "Ed" // Signature over the raw message
+	minisignAlgPrehashed = "ED" // Signature over the BLAKE2b-512 hash of the message
+)
+
+// VerifyFileSignature verifies path against path+".minisig" using a minisign public key.
+// The key may be the contents of a minisign .pub file or just its base64 key line.
+func VerifyFileSignature(path, publicKey string) error {
+	message, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	signature, err := os.ReadFile(path + SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("failed to read signature for %s: %w", path, err)
+	}
+
+	if err := VerifyMinisign(publicKey, message, signature); err != nil {
+		return fmt.Errorf("signature verification failed for %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// VerifyMinisign verifies a minisign signature (legacy or prehashed) of message
+func VerifyMinisign(publicKey string, message, signature []byte) error {
+	keyID, key, err := parseMinisignPublicKey(publicKey)
+	if err != nil {
+		return err
+	}
+
+	lines := nonCommentLines(string(signature), "untrusted comment:")
+	if len(lines) != 3 || !strings.HasPrefix(lines[1], "trusted comment: ") {
+		return fmt.Errorf("malformed signature file")
+	}
+
+	sigBlob, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(sigBlob) != 2+8+ed25519.SignatureSize {
+		return fmt.Errorf("malformed signature")
+	}
+
+	algorithm := string(sigBlob[:2])
+	if !bytes.Equal(sigBlob[2:10], keyID) {
+		return fmt.Errorf("signature was made with a different key")
+	}
+	sig := sigBlob[10:]
+
+	switch algorithm {
+	case minisignAlgLegacy:
+	case minisignAlgPrehashed:
+		digest := blake2b.Sum512(message)
+		message = digest[:]
+	default:
+		return fmt.Errorf("unsupported signature algorithm %q", algorithm)
+	}
+
+	if !ed25519.Verify(key, message, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+
+	// The global signature covers the signature and the trusted comment
+	trustedComment := strings.TrimPrefix(lines[1], "trusted comment: ")
+	globalSig, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(globalSig) != ed25519.SignatureSize {
+		return fmt.Errorf("malformed global signature")
+	}
+	if !ed25519.Verify(key, append(append([]byte{}, sig...), trustedComment...), globalSig) {
+		return fmt.Errorf("invalid trusted comment signature")
+	}
+
+	return nil
+}
+
+// parseMinisignPublicKey decodes a minisign public key into its key ID and Ed25519 key
+func parseMinisignPublicKey(publicKey string) ([]byte, ed25519.PublicKey, error) {
+	lines := nonCommentLines(publicKey, "untrusted comment:")
+	if len(lines) != 1 {
+		return nil, nil, fmt.Errorf("malformed public key")
+	}
+
+	blob, err := base64.StdEncoding.DecodeString(lines[0])
+	if err != nil || len(blob) != 2+8+ed25519.PublicKeySize || string(blob[:2]) != minisignAlgLegacy {
+		return nil, nil, fmt.Errorf("malformed public key")
+	}
+
+	return blob[2:10], ed25519.PublicKey(blob[10:]), nil
+}
+
+// nonCommentLines returns the trimmed, non-empty lines that don't start with commentPrefix
+func nonCommentLines(content, commentPrefix string) []string {
+	var lines []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, commentPrefix) {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,101 @@
+package util
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// minisignFixture builds a minisign public key and signature for message
+func minisignFixture(t *testing.T, algorithm string, message []byte) (string, string) {
+	t.Helper()
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	keyID := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	signed := message
+	if algorithm == minisignAlgPrehashed {
+		digest := blake2b.Sum512(message)
+		signed = digest[:]
+	}
+	sig := ed25519.Sign(privateKey, signed)
+
+	trustedComment := "timestamp:1700000000\tfile:Otterfile.lock"
+	globalSig := ed25519.Sign(privateKey, append(append([]byte{}, sig...), trustedComment...))
+
+	keyBlob := append(append([]byte(minisignAlgLegacy), keyID...), publicKey...)
+	sigBlob := append(append([]byte(algorithm), keyID...), sig...)
+
+	pub := "untrusted comment: minisign public key\n" + base64.StdEncoding.EncodeToString(keyBlob) + "\n"
+	signature := "untrusted comment: signature from minisign secret key\n" +
+		base64.StdEncoding.EncodeToString(sigBlob) + "\n" +
+		"trusted comment: " + trustedComment + "\n" +
+		base64.StdEncoding.EncodeToString(globalSig) + "\n"
+
+	return pub, signature
+}
+
+func TestVerifyMinisign(t *testing.T) {
+	message := []byte(`{"version": 1, "layers": []}`)
+
+	for _, algorithm := range []string{minisignAlgLegacy, minisignAlgPrehashed} {
+		t.Run(algorithm, func(t *testing.T) {
+			pub, signature := minisignFixture(t, algorithm, message)
+
+			if err := VerifyMinisign(pub, message, []byte(signature)); err != nil {
+				t.Errorf("Expected valid signature, got %v", err)
+			}
+
+			if err := VerifyMinisign(pub, []byte("tampered"), []byte(signature)); err == nil {
+				t.Error("Expected tampered message to fail verification")
+			}
+
+			otherPub, _ := minisignFixture(t, algorithm, message)
+			if err := VerifyMinisign(otherPub, message, []byte(signature)); err == nil {
+				t.Error("Expected verification with a different key to fail")
+			}
+		})
+	}
+
+	t.Run("malformed inputs", func(t *testing.T) {
+		pub, signature := minisignFixture(t, minisignAlgLegacy, message)
+		if err := VerifyMinisign("not a key", message, []byte(signature)); err == nil {
+			t.Error("Expected malformed key error")
+		}
+		if err := VerifyMinisign(pub, message, []byte("garbage")); err == nil {
+			t.Error("Expected malformed signature error")
+		}
+	})
+}
+
+func TestVerifyFileSignature(t *testing.T) {
+	tempDir := t.TempDir()
+	lockPath := filepath.Join(tempDir, LockfileName)
+	message := []byte(`{"version": 1, "layers": []}`)
+
+	if err := os.WriteFile(lockPath, message, 0644); err != nil {
+		t.Fatalf("Failed to write lockfile: %v", err)
+	}
+
+	pub, signature := minisignFixture(t, minisignAlgPrehashed, message)
+
+	if err := VerifyFileSignature(lockPath, pub); err == nil {
+		t.Error("Expected error when signature file is missing")
+	}
+
+	if err := os.WriteFile(lockPath+SignatureSuffix, []byte(signature), 0644); err != nil {
+		t.Fatalf("Failed to write signature: %v", err)
+	}
+
+	if err := VerifyFileSignature(lockPath, pub); err != nil {
+		t.Errorf("Expected valid signature, got %v", err)
+	}
+}
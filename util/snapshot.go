@@ -0,0 +1,129 @@
+package util
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SnapshotDirName is the cache directory that holds snapshots of local layers, named by content hash
+const SnapshotDirName = "snapshots"
+
+// HashLayerDirectory hashes the paths, permissions, and content of the files in a layer directory.
+// The .git directory is skipped, so the hash only depends on the layer's files.
+func HashLayerDirectory(layerPath string) (string, error) {
+	fsys := NewOSFileSystem()
+	key := NewCacheKey()
+	err := fsys.Walk(layerPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(layerPath, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if info.IsDir() {
+			if info.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		content, err := fsys.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		key.Add(fmt.Sprintf("%s %o", filepath.ToSlash(relativePath), info.Mode().Perm()), content)
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to hash layer %s: %w", layerPath, err)
+	}
+	return key.Sum(), nil
+}
+
+// SnapshotLayer copies a local layer into the cache and returns the snapshot's path and content
+// hash. The copy is hashed rather than the directory, so changes made while copying can't make
+// the snapshot differ from its hash. Existing snapshots with the same hash are reused.
+func (g *GitOperations) SnapshotLayer(layerPath string) (string, string, error) {
+	snapshotsDir := filepath.Join(g.cacheDir, SnapshotDirName)
+	if err := os.MkdirAll(snapshotsDir, 0755); err != nil {
+		return "", "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+
+	staging, err := os.MkdirTemp(snapshotsDir, ".staging-")
+	if err != nil {
+		return "", "", fmt.Errorf("failed to create snapshot directory: %w", err)
+	}
+	defer os.RemoveAll(extendedPath(staging))
+
+	if err := copyLayerTree(layerPath, staging); err != nil {
+		return "", "", err
+	}
+	hash, err := HashLayerDirectory(staging)
+	if err != nil {
+		return "", "", err
+	}
+
+	snapshotPath, ok := g.LayerSnapshot(hash)
+	if ok {
+		return snapshotPath, hash, nil
+	}
+	if err := os.Rename(staging, snapshotPath); err != nil {
+		return "", "", fmt.Errorf("failed to store snapshot of %s: %w", layerPath, err)
+	}
+	return snapshotPath, hash, nil
+}
+
+// LayerSnapshot returns the path of the snapshot with a content hash, and whether it is cached
+func (g *GitOperations) LayerSnapshot(hash string) (string, bool) {
+	snapshotPath := filepath.Join(g.cacheDir, SnapshotDirName, hash)
+	if strings.ContainsAny(hash, `/\.`) {
+		return snapshotPath, false
+	}
+	info, err := os.Stat(snapshotPath)
+	return snapshotPath, err == nil && info.IsDir()
+}
+
+// copyLayerTree copies the files of a layer directory, without its .git directory, preserving permissions
+func copyLayerTree(src, dst string) error {
+	return copyTree(src, dst, func(relativePath string, info os.FileInfo) bool {
+		return info.IsDir() && info.Name() == ".git"
+	})
+}
+
+// copyTree copies the files of a directory, preserving permissions, except the files and
+// directories skip reports
+func copyTree(src, dst string, skip func(relativePath string, info os.FileInfo) bool) error {
+	fsys := NewOSFileSystem()
+	return fsys.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relativePath, err := filepath.Rel(src, path)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		target := filepath.Join(dst, relativePath)
+
+		if relativePath != "." && skip(relativePath, info) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return fsys.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+
+		content, err := fsys.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if err := fsys.WriteFile(target, content, info.Mode().Perm()); err != nil {
+			return fmt.Errorf("failed to write %s: %w", target, err)
+		}
+		return nil
+	})
+}
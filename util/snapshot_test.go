@@ -0,0 +1,52 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotLayer(t *testing.T) {
+	layerDir := t.TempDir()
+	os.MkdirAll(filepath.Join(layerDir, "docs"), 0755)
+	os.MkdirAll(filepath.Join(layerDir, ".git"), 0755)
+	os.WriteFile(filepath.Join(layerDir, "README.md"), []byte("v1"), 0644)
+	os.WriteFile(filepath.Join(layerDir, "docs", "guide.md"), []byte("guide"), 0644)
+	os.WriteFile(filepath.Join(layerDir, ".git", "HEAD"), []byte("ref: refs/heads/main"), 0644)
+
+	gitOps := NewGitOperations(t.TempDir())
+	snapshotPath, hash, err := gitOps.SnapshotLayer(layerDir)
+	if err != nil {
+		t.Fatalf("SnapshotLayer failed: %v", err)
+	}
+	if content, err := os.ReadFile(filepath.Join(snapshotPath, "docs", "guide.md")); err != nil || string(content) != "guide" {
+		t.Errorf("Expected nested files in the snapshot, got %q (%v)", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(snapshotPath, ".git")); !os.IsNotExist(err) {
+		t.Error("Expected .git not to be snapshotted")
+	}
+	if directHash, _ := HashLayerDirectory(layerDir); directHash != hash {
+		t.Errorf("Expected the snapshot hash %s to match the directory hash %s", hash, directHash)
+	}
+
+	// Git metadata doesn't affect the hash, and an unchanged layer reuses the snapshot
+	os.WriteFile(filepath.Join(layerDir, ".git", "HEAD"), []byte("ref: refs/heads/other"), 0644)
+	if path, again, err := gitOps.SnapshotLayer(layerDir); err != nil || again != hash || path != snapshotPath {
+		t.Errorf("Expected the snapshot to be reused, got %s %s (%v)", path, again, err)
+	}
+
+	// Changed content gets a new snapshot, and the old one stays available
+	os.WriteFile(filepath.Join(layerDir, "README.md"), []byte("v2"), 0644)
+	_, changed, err := gitOps.SnapshotLayer(layerDir)
+	if err != nil || changed == hash {
+		t.Errorf("Expected changed content to get a new hash, got %s (%v)", changed, err)
+	}
+	if path, ok := gitOps.LayerSnapshot(hash); !ok {
+		t.Errorf("Expected the first snapshot to stay cached at %s", path)
+	} else if content, _ := os.ReadFile(filepath.Join(path, "README.md")); string(content) != "v1" {
+		t.Errorf("Expected the first snapshot to keep its content, got %q", content)
+	}
+	if _, ok := gitOps.LayerSnapshot("../escape"); ok {
+		t.Error("Expected hashes with path separators to be rejected")
+	}
+}
@@ -0,0 +1,191 @@
+package util
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// RemoteTarget is a destination outside the local project that staged layer files are uploaded to
+type RemoteTarget interface {
+	// Upload copies the tree under root in fsys to the target
+	Upload(fsys FileSystem, root string) error
+	// Join returns a target for a subdirectory of this one
+	Join(elem string) RemoteTarget
+	String() string
+}
+
+// SSHTarget is a directory on a remote machine that layers are applied into
+type SSHTarget struct {
+	Host string // [user@]host
+	Port string // Optional port
+	Path string // Directory on the remote machine
+}
+
+// IsSSHTarget reports whether a TARGET refers to a remote machine
+func IsSSHTarget(target string) bool {
+	return strings.HasPrefix(target, "ssh://")
+}
+
+// ParseSSHTarget parses ssh://[user@]host[:port]/path or the scp-like [user@]host:path form
+func ParseSSHTarget(target string) (*SSHTarget, error) {
+	if IsSSHTarget(target) {
+		parsed, err := url.Parse(target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse SSH target %s: %w", target, err)
+		}
+		if parsed.Hostname() == "" {
+			return nil, fmt.Errorf("SSH target %s is missing a host", target)
+		}
+
+		host := parsed.Hostname()
+		if parsed.User != nil {
+			host = parsed.User.Username() + "@" + host
+		}
+
+		remotePath := parsed.Path
+		if remotePath == "" {
+			remotePath = "."
+		}
+
+		if err := checkSSHHost(host, target); err != nil {
+			return nil, err
+		}
+		return &SSHTarget{Host: host, Port: parsed.Port(), Path: remotePath}, nil
+	}
+
+	host, remotePath, ok := strings.Cut(target, ":")
+	if !ok || host == "" {
+		return nil, fmt.Errorf("SSH target must be ssh://[user@]host[:port]/path or [user@]host:path, got: %s", target)
+	}
+	if remotePath == "" {
+		remotePath = "."
+	}
+	if err := checkSSHHost(host, target); err != nil {
+		return nil, err
+	}
+
+	return &SSHTarget{Host: host, Path: remotePath}, nil
+}
+
+// checkSSHHost returns an error for a [user@]host that ssh would read as an option, e.g.
+// -oProxyCommand=..., which would run a command on this machine
+func checkSSHHost(host, target string) error {
+	if strings.HasPrefix(host, "-") {
+		return fmt.Errorf("SSH target %s has a host starting with -", target)
+	}
+	return nil
+}
+
+// Join returns a target for a subdirectory of this one
+func (t *SSHTarget) Join(elem string) RemoteTarget {
+	joined := *t
+	joined.Path = path.Join(t.Path, filepath.ToSlash(elem))
+	return &joined
+}
+
+func (t *SSHTarget) String() string {
+	host := t.Host
+	if t.Port != "" {
+		host += ":" + t.Port
+	}
+	if strings.HasPrefix(t.Path, "/") {
+		return "ssh://" + host + t.Path
+	}
+	return "ssh://" + host + "/" + t.Path
+}
+
+// Upload copies the tree under root in fsys to the remote directory.
+// The files are streamed as a tar archive through a single ssh session, so the
+// remote machine only needs a POSIX shell and tar; authentication and host key
+// checking follow the user's ssh configuration.
+func (t *SSHTarget) Upload(fsys FileSystem, root string) error {
+	archive, err := tarTree(fsys, root)
+	if err != nil {
+		return err
+	}
+
+	remoteCommand := fmt.Sprintf("mkdir -p %s && tar -xf - -C %s", shellQuote(t.Path), shellQuote(t.Path))
+
+	args := []string{}
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	// -- ends the options, so the host is never read as one
+	args = append(args, "--", t.Host, remoteCommand)
+
+	cmd := exec.Command("ssh", args...)
+	cmd.Stdin = archive
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to upload to %s: %w", t, err)
+	}
+
+	return nil
+}
+
+// tarTree archives the files and directories under root
+func tarTree(fsys FileSystem, root string) (*bytes.Buffer, error) {
+	var buf bytes.Buffer
+	writer := tar.NewWriter(&buf)
+
+	err := fsys.Walk(root, func(srcPath string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		relativePath, err := filepath.Rel(root, srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to get relative path: %w", err)
+		}
+		if relativePath == "." {
+			return nil
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return fmt.Errorf("failed to create archive header for %s: %w", relativePath, err)
+		}
+		header.Name = filepath.ToSlash(relativePath)
+		if info.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := writer.WriteHeader(header); err != nil {
+			return fmt.Errorf("failed to write archive header for %s: %w", relativePath, err)
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		content, err := fsys.ReadFile(srcPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", srcPath, err)
+		}
+		_, err = writer.Write(content)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writer.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	return &buf, nil
+}
+
+// shellQuote quotes a string for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
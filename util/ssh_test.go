@@ -0,0 +1,95 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseSSHTarget(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    SSHTarget
+		expectError bool
+	}{
+		{"ssh://devbox/srv/app", SSHTarget{Host: "devbox", Path: "/srv/app"}, false},
+		{"ssh://deploy@devbox:2222/srv/app", SSHTarget{Host: "deploy@devbox", Port: "2222", Path: "/srv/app"}, false},
+		{"ssh://devbox", SSHTarget{Host: "devbox", Path: "."}, false},
+		{"deploy@jump:/etc/app", SSHTarget{Host: "deploy@jump", Path: "/etc/app"}, false},
+		{"jump:config", SSHTarget{Host: "jump", Path: "config"}, false},
+		{"ssh:///srv/app", SSHTarget{}, true},
+		{"no-colon", SSHTarget{}, true},
+		{"-oProxyCommand=touch /tmp/pwned:/srv/app", SSHTarget{}, true},
+		{"ssh://-oProxyCommand=sh@devbox/srv/app", SSHTarget{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			target, err := ParseSSHTarget(tt.input)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error for %s", tt.input)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if *target != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, *target)
+			}
+		})
+	}
+}
+
+func TestSSHTargetJoinAndString(t *testing.T) {
+	target := &SSHTarget{Host: "deploy@devbox", Port: "2222", Path: "/srv/app"}
+
+	joined := target.Join(".config/tool").(*SSHTarget)
+	if joined.Path != "/srv/app/.config/tool" {
+		t.Errorf("Expected joined path, got %s", joined.Path)
+	}
+	if target.Path != "/srv/app" {
+		t.Error("Join should not modify the original target")
+	}
+	if joined.String() != "ssh://deploy@devbox:2222/srv/app/.config/tool" {
+		t.Errorf("Unexpected string form: %s", joined.String())
+	}
+}
+
+func TestSSHTargetUpload(t *testing.T) {
+	tempDir := t.TempDir()
+
+	// Fake ssh that runs the remote command locally
+	binDir := filepath.Join(tempDir, "bin")
+	if err := os.MkdirAll(binDir, 0755); err != nil {
+		t.Fatalf("Failed to create bin dir: %v", err)
+	}
+	fakeSSH := "#!/bin/sh\nfor arg; do last=$arg; done\nexec /bin/sh -c \"$last\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "ssh"), []byte(fakeSSH), 0755); err != nil {
+		t.Fatalf("Failed to write fake ssh: %v", err)
+	}
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	staging := NewMemFileSystem()
+	if err := staging.MkdirAll("/staging/conf", 0755); err != nil {
+		t.Fatalf("Failed to create staging: %v", err)
+	}
+	if err := staging.WriteFile("/staging/conf/app.ini", []byte("[app]"), 0640); err != nil {
+		t.Fatalf("Failed to write staging file: %v", err)
+	}
+
+	remoteDir := filepath.Join(tempDir, "remote dir's path")
+	target := &SSHTarget{Host: "devbox", Port: "22", Path: remoteDir}
+	if err := target.Upload(staging, "/staging"); err != nil {
+		t.Fatalf("Upload failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(remoteDir, "conf", "app.ini"))
+	if err != nil {
+		t.Fatalf("Expected uploaded file: %v", err)
+	}
+	if string(content) != "[app]" {
+		t.Errorf("Unexpected uploaded content: %s", content)
+	}
+}
@@ -0,0 +1,346 @@
+package util
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// StateTokenEnv holds a bearer token sent to HTTP(S) state backends
+const StateTokenEnv = "OTTER_STATE_TOKEN"
+
+// StateConfig keeps the manifest of layer-provided files in a remote backend, so builds in fresh
+// workspaces, such as CI, can find drift and files layers stop providing
+type StateConfig struct {
+	// Backend is where state is kept: s3://bucket/prefix, gs://bucket/prefix, an HTTP(S) URL that
+	// accepts GET and PUT, or git+<repository>#<branch>
+	Backend string `json:"backend"`
+	// Key identifies the project in the backend; defaults to its origin remote, or the directory name
+	Key string `json:"key"`
+}
+
+// StateBackend reads and writes files of a project's state in a remote backend
+type StateBackend interface {
+	// Pull returns the content of a state file, or an error satisfying os.IsNotExist when there isn't one
+	Pull(name string) ([]byte, error)
+	// Push replaces a state file
+	Push(name string, data []byte) error
+	// String describes where the project's state is kept
+	String() string
+}
+
+// NewStateBackend returns the backend config describes for the project at projectDir, or nil when
+// no backend is configured
+func NewStateBackend(config StateConfig, projectDir string) (StateBackend, error) {
+	if config.Backend == "" {
+		return nil, nil
+	}
+
+	key := config.Key
+	if key == "" {
+		key = defaultStateKey(projectDir)
+	}
+	if key == "" || !filepath.IsLocal(filepath.FromSlash(key)) {
+		return nil, fmt.Errorf("invalid state key %q", key)
+	}
+
+	backend := config.Backend
+	switch {
+	case strings.HasPrefix(backend, "s3://"):
+		return &commandStateBackend{url: strings.TrimSuffix(backend, "/") + "/" + key, tool: "aws", copy: []string{"s3", "cp"}}, nil
+	case strings.HasPrefix(backend, "gs://"):
+		return &commandStateBackend{url: strings.TrimSuffix(backend, "/") + "/" + key, tool: "gcloud", copy: []string{"storage", "cp"}}, nil
+	case strings.HasPrefix(backend, "http://"), strings.HasPrefix(backend, "https://"):
+		return &httpStateBackend{url: strings.TrimSuffix(backend, "/") + "/" + key}, nil
+	case strings.HasPrefix(backend, "git+"):
+		repository, branch, _ := strings.Cut(strings.TrimPrefix(backend, "git+"), "#")
+		if repository == "" {
+			return nil, fmt.Errorf("state backend %s has no repository", backend)
+		}
+		if branch == "" {
+			branch = "otter-state"
+		}
+		return &gitStateBackend{repository: repository, branch: branch, key: key}, nil
+	}
+	return nil, fmt.Errorf("unsupported state backend %q (use s3://, gs://, http(s)://, or git+)", backend)
+}
+
+// defaultStateKey derives a key from the project's origin remote, e.g. github.com/org/service, or
+// from its directory name when it has no remote
+func defaultStateKey(projectDir string) string {
+	remote := DetectProject(projectDir).Remote
+	if remote == "" {
+		return filepath.Base(projectDir)
+	}
+
+	if _, rest, found := strings.Cut(remote, "://"); found {
+		remote = rest
+	} else {
+		// scp-like syntax, e.g. git@github.com:org/service.git
+		remote = strings.Replace(remote, ":", "/", 1)
+	}
+	if _, rest, found := strings.Cut(remote, "@"); found {
+		remote = rest
+	}
+	return strings.Trim(strings.TrimSuffix(remote, ".git"), "/")
+}
+
+// PullState replaces the local copy of a state file with the one in the backend, keeping the local
+// copy when the backend doesn't have one yet
+func PullState(backend StateBackend, fsys FileSystem, localPath string) error {
+	data, err := backend.Pull(filepath.Base(localPath))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s from %s: %w", filepath.Base(localPath), backend, err)
+	}
+	if err := fsys.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(localPath), err)
+	}
+	if err := fsys.WriteFile(localPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", localPath, err)
+	}
+	return nil
+}
+
+// PushState stores the local copy of a state file in the backend
+func PushState(backend StateBackend, fsys FileSystem, localPath string) error {
+	data, err := fsys.ReadFile(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", localPath, err)
+	}
+	if err := backend.Push(filepath.Base(localPath), data); err != nil {
+		return fmt.Errorf("failed to store %s in %s: %w", filepath.Base(localPath), backend, err)
+	}
+	return nil
+}
+
+// httpStateBackend keeps state at <url>/<key>/<name>, read with GET and written with PUT
+type httpStateBackend struct {
+	url string
+}
+
+func (b *httpStateBackend) String() string {
+	return b.url
+}
+
+func (b *httpStateBackend) request(method, name string, body []byte) (*http.Response, error) {
+	req, err := http.NewRequest(method, b.url+"/"+name, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if token := os.Getenv(StateTokenEnv); token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	client := &http.Client{Timeout: 30 * time.Second}
+	return client.Do(req)
+}
+
+func (b *httpStateBackend) Pull(name string) ([]byte, error) {
+	resp, err := b.request(http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, &fs.PathError{Op: "get", Path: b.url + "/" + name, Err: fs.ErrNotExist}
+	}
+	if resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (b *httpStateBackend) Push(name string, data []byte) error {
+	resp, err := b.request(http.MethodPut, name, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// commandStateBackend keeps state in a cloud bucket through the provider's CLI, which brings its
+// own credentials
+type commandStateBackend struct {
+	url  string
+	tool string   // aws or gcloud
+	copy []string // Subcommand that copies between a local file, or - for stdin and stdout, and the bucket
+}
+
+func (b *commandStateBackend) String() string {
+	return b.url
+}
+
+func (b *commandStateBackend) run(stdin []byte, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(b.tool, append(append([]string(nil), b.copy...), args...)...)
+	cmd.Stdin = bytes.NewReader(stdin)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		message := strings.TrimSpace(stderr.String())
+		if message == "" {
+			return nil, fmt.Errorf("%s failed: %w", b.tool, err)
+		}
+		return nil, fmt.Errorf("%s failed: %s", b.tool, message)
+	}
+	return stdout.Bytes(), nil
+}
+
+func (b *commandStateBackend) Pull(name string) ([]byte, error) {
+	data, err := b.run(nil, b.url+"/"+name, "-")
+	if err != nil && isMissingObject(err.Error()) {
+		return nil, &fs.PathError{Op: "get", Path: b.url + "/" + name, Err: fs.ErrNotExist}
+	}
+	return data, err
+}
+
+func (b *commandStateBackend) Push(name string, data []byte) error {
+	_, err := b.run(data, "-", b.url+"/"+name)
+	return err
+}
+
+// isMissingObject reports whether a cloud CLI error means the object doesn't exist
+func isMissingObject(message string) bool {
+	for _, marker := range []string{"(404)", "NoSuchKey", "Not Found", "No URLs matched", "does not exist"} {
+		if strings.Contains(message, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// gitStateBackend keeps state as <key>/<name> on a branch of a git repository, one commit per push
+type gitStateBackend struct {
+	repository string
+	branch     string
+	key        string
+}
+
+func (b *gitStateBackend) String() string {
+	return b.repository + "#" + b.branch + ":" + b.key
+}
+
+// checkout clones the state branch into a temporary directory; the repository is nil when the
+// branch doesn't exist yet
+func (b *gitStateBackend) checkout(dir string) (*git.Repository, error) {
+	repo, err := git.PlainClone(dir, false, &git.CloneOptions{
+		URL:           b.repository,
+		ReferenceName: plumbing.NewBranchReferenceName(b.branch),
+		SingleBranch:  true,
+		Depth:         1,
+	})
+	var noMatch git.NoMatchingRefSpecError
+	if errors.Is(err, transport.ErrEmptyRemoteRepository) || errors.Is(err, plumbing.ErrReferenceNotFound) || errors.As(err, &noMatch) {
+		os.RemoveAll(dir)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to clone %s: %w", b.repository, err)
+	}
+	return repo, nil
+}
+
+func (b *gitStateBackend) Pull(name string) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "otter-state-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	statePath := filepath.Join(dir, filepath.FromSlash(path.Join(b.key, name)))
+	repo, err := b.checkout(dir)
+	if err != nil {
+		return nil, err
+	}
+	if repo == nil {
+		return nil, &fs.PathError{Op: "get", Path: b.String() + "/" + name, Err: fs.ErrNotExist}
+	}
+	return os.ReadFile(statePath)
+}
+
+func (b *gitStateBackend) Push(name string, data []byte) error {
+	dir, err := os.MkdirTemp("", "otter-state-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	branch := plumbing.NewBranchReferenceName(b.branch)
+	repo, err := b.checkout(dir)
+	if err != nil {
+		return err
+	}
+	if repo == nil {
+		// Start the branch with this first commit
+		if repo, err = git.PlainInit(dir, false); err != nil {
+			return err
+		}
+		if _, err := repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{b.repository}}); err != nil {
+			return err
+		}
+		if err := repo.Storer.SetReference(plumbing.NewSymbolicReference(plumbing.HEAD, branch)); err != nil {
+			return err
+		}
+	}
+
+	relativePath := path.Join(b.key, name)
+	statePath := filepath.Join(dir, filepath.FromSlash(relativePath))
+	if err := os.MkdirAll(filepath.Dir(statePath), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(statePath, data, 0644); err != nil {
+		return err
+	}
+
+	worktree, err := repo.Worktree()
+	if err != nil {
+		return err
+	}
+	if _, err := worktree.Add(relativePath); err != nil {
+		return err
+	}
+	if status, err := worktree.Status(); err == nil && status.IsClean() {
+		return nil
+	}
+	_, err = worktree.Commit("Update otter state for "+b.key, &git.CommitOptions{
+		Author: &object.Signature{Name: "otter", Email: "otter@localhost", When: time.Now()},
+	})
+	if err != nil {
+		return err
+	}
+
+	err = repo.Push(&git.PushOptions{
+		RemoteName: "origin",
+		RefSpecs:   []config.RefSpec{config.RefSpec(branch + ":" + branch)},
+	})
+	if err != nil && err != git.NoErrAlreadyUpToDate {
+		return fmt.Errorf("failed to push to %s: %w", b.repository, err)
+	}
+	return nil
+}
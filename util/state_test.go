@@ -0,0 +1,116 @@
+package util
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// testStateBackend pushes a manifest to a backend and pulls it into a fresh project
+func testStateBackend(t *testing.T, backend StateBackend) {
+	t.Helper()
+	fsys := NewOSFileSystem()
+
+	freshPath := filepath.Join(t.TempDir(), ".otter", FileManifestName)
+	if err := PullState(backend, fsys, freshPath); err != nil {
+		t.Fatalf("Expected pulling missing state to succeed: %v", err)
+	}
+	if _, err := os.Stat(freshPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected no manifest before state is pushed")
+	}
+
+	for _, content := range []string{`{"version": 1, "layers": []}`, `{"version": 1, "layers": [{"repository": "a"}]}`} {
+		localPath := filepath.Join(t.TempDir(), FileManifestName)
+		os.WriteFile(localPath, []byte(content), 0644)
+		if err := PushState(backend, fsys, localPath); err != nil {
+			t.Fatalf("PushState failed: %v", err)
+		}
+
+		if err := PullState(backend, fsys, freshPath); err != nil {
+			t.Fatalf("PullState failed: %v", err)
+		}
+		if data, _ := os.ReadFile(freshPath); string(data) != content {
+			t.Errorf("Expected %q, got %q", content, data)
+		}
+	}
+}
+
+func TestHTTPStateBackend(t *testing.T) {
+	var mu sync.Mutex
+	stored := make(map[string][]byte)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.Method {
+		case http.MethodPut:
+			stored[r.URL.Path], _ = io.ReadAll(r.Body)
+		case http.MethodGet:
+			data, ok := stored[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(data)
+		}
+	}))
+	defer server.Close()
+	t.Setenv(StateTokenEnv, "secret")
+
+	backend, err := NewStateBackend(StateConfig{Backend: server.URL + "/state/", Key: "org/service"}, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStateBackend failed: %v", err)
+	}
+	testStateBackend(t, backend)
+	if _, ok := stored["/state/org/service/"+FileManifestName]; !ok {
+		t.Errorf("Expected state at the project key, got %v", stored)
+	}
+}
+
+func TestGitStateBackend(t *testing.T) {
+	remoteDir := t.TempDir()
+	if _, err := git.PlainInit(remoteDir, true); err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+
+	backend, err := NewStateBackend(StateConfig{Backend: "git+" + remoteDir + "#state", Key: "service"}, t.TempDir())
+	if err != nil {
+		t.Fatalf("NewStateBackend failed: %v", err)
+	}
+	testStateBackend(t, backend)
+}
+
+func TestNewStateBackend(t *testing.T) {
+	projectDir := filepath.Join(t.TempDir(), "service")
+	os.Mkdir(projectDir, 0755)
+
+	backend, err := NewStateBackend(StateConfig{Backend: "s3://bucket/otter"}, projectDir)
+	if err != nil || backend.String() != "s3://bucket/otter/service" {
+		t.Errorf("Expected the directory name as key, got %v (%v)", backend, err)
+	}
+
+	repo, _ := git.PlainInit(projectDir, false)
+	repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"git@github.com:org/service.git"}})
+	if backend, _ := NewStateBackend(StateConfig{Backend: "gs://bucket"}, projectDir); backend.String() != "gs://bucket/github.com/org/service" {
+		t.Errorf("Expected the origin remote as key, got %v", backend)
+	}
+
+	if backend, err := NewStateBackend(StateConfig{}, projectDir); backend != nil || err != nil {
+		t.Errorf("Expected no backend when none is configured")
+	}
+	for _, stateConfig := range []StateConfig{{Backend: "ftp://host"}, {Backend: "s3://bucket", Key: "../other"}, {Backend: "git+#main"}} {
+		if _, err := NewStateBackend(stateConfig, projectDir); err == nil {
+			t.Errorf("Expected %+v to be rejected", stateConfig)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package util
+
+import "strings"
+
+// Strategies for a layer file that already exists in the target, from the layer's STRATEGY clause
+const (
+	// StrategyMerge combines files that have a merge driver with the existing copy and overwrites
+	// the rest; layers without a STRATEGY clause use it
+	StrategyMerge = "merge"
+	// StrategyOverwrite replaces the existing copy, even of files that have a merge driver
+	StrategyOverwrite = "overwrite"
+	// StrategySkipExisting leaves the existing copy as it is
+	StrategySkipExisting = "skip-existing"
+	// StrategyAppend adds the layer's content to the end of the existing copy, unless it is already there
+	StrategyAppend = "append"
+)
+
+// Strategies are the values a STRATEGY clause accepts
+var Strategies = []string{StrategyMerge, StrategyOverwrite, StrategySkipExisting, StrategyAppend}
+
+// strategySource names a layer's STRATEGY clause as the source of existing files it leaves alone
+const strategySource = "layer STRATEGY"
+
+// IsStrategy reports whether name is one of the Strategies
+func IsStrategy(name string) bool {
+	for _, strategy := range Strategies {
+		if name == strategy {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStrategySkip reports whether an ignored file was left alone because of a STRATEGY clause
+func IsStrategySkip(file IgnoredFile) bool {
+	return strings.HasPrefix(file.Source, strategySource+" ")
+}
+
+// existingAction returns what copying the layer file at srcPath does to the copy already in the
+// target under the layer's strategy: "overwrite", "merge" with the driver combining the two,
+// "append", or "skip"
+func (f *FileOperations) existingAction(relativePath, srcPath string) (string, MergeDriver) {
+	switch f.Strategy {
+	case StrategySkipExisting:
+		return "skip", nil
+	case StrategyAppend:
+		return "append", nil
+	case StrategyOverwrite:
+		return "overwrite", nil
+	}
+	if driver := f.mergeDriverFor(relativePath, srcPath); driver != nil {
+		return "merge", driver
+	}
+	return "overwrite", nil
+}
@@ -0,0 +1,130 @@
+package util
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// strategyLayer writes a layer and a target that already has some of its files
+func strategyLayer(t *testing.T) (string, string) {
+	t.Helper()
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	for name, content := range map[string]string{"README.md": "# Layer\n", "LICENSE": "MIT\n", ".tool-versions": "golang 1.22.0\n"} {
+		os.WriteFile(filepath.Join(layerDir, name), []byte(content), 0644)
+	}
+	for name, content := range map[string]string{"README.md": "# Project", ".tool-versions": "nodejs 20.0.0\n"} {
+		os.WriteFile(filepath.Join(targetDir, name), []byte(content), 0644)
+	}
+	return layerDir, targetDir
+}
+
+func readTarget(t *testing.T, targetDir, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(targetDir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestCopyLayerStrategySkipExisting(t *testing.T) {
+	layerDir, targetDir := strategyLayer(t)
+	fileOps := NewFileOperations()
+	fileOps.Strategy = StrategySkipExisting
+
+	if conflicts, err := fileOps.DetectConflicts(layerDir, targetDir); err != nil || len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v, %v", conflicts, err)
+	}
+	if stats, err := fileOps.MeasureLayer(layerDir, targetDir); err != nil || stats.Files != 1 || stats.Overwrites != 0 {
+		t.Errorf("Expected only LICENSE to be written, got %+v, %v", stats, err)
+	}
+
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, false); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	if content := readTarget(t, targetDir, "README.md"); content != "# Project" {
+		t.Errorf("Expected the existing README.md to be kept, got %q", content)
+	}
+	if content := readTarget(t, targetDir, ".tool-versions"); content != "nodejs 20.0.0\n" {
+		t.Errorf("Expected the existing .tool-versions to be kept, got %q", content)
+	}
+	if changes := fileOps.TakeChanges(); len(changes) != 1 || filepath.Base(changes[0].Path) != "LICENSE" || changes[0].Action != "create" {
+		t.Errorf("Expected only LICENSE to be created, got %+v", changes)
+	}
+	ignored := fileOps.TakeIgnored()
+	if len(ignored) != 2 || !IsStrategySkip(ignored[0]) || ignored[0].Source != "layer STRATEGY skip-existing" {
+		t.Errorf("Expected the existing files to be skipped by the strategy, got %+v", ignored)
+	}
+}
+
+func TestCopyLayerStrategyAppend(t *testing.T) {
+	layerDir, targetDir := strategyLayer(t)
+	fileOps := NewFileOperations()
+	fileOps.Strategy = StrategyAppend
+
+	if conflicts, err := fileOps.DetectConflicts(layerDir, targetDir); err != nil || len(conflicts) != 0 {
+		t.Errorf("Expected no conflicts, got %v, %v", conflicts, err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+			t.Fatalf("CopyLayer failed: %v", err)
+		}
+	}
+	if content := readTarget(t, targetDir, "README.md"); content != "# Project\n# Layer\n" {
+		t.Errorf("Expected the layer's content appended once, got %q", content)
+	}
+	if content := readTarget(t, targetDir, ".tool-versions"); content != "nodejs 20.0.0\ngolang 1.22.0\n" {
+		t.Errorf("Expected the layer's content appended once, got %q", content)
+	}
+
+	// The second copy finds the layer's content already in every file, including the one it created
+	actions := make(map[string]int)
+	for _, change := range fileOps.TakeChanges() {
+		actions[change.Action]++
+	}
+	if actions["append"] != 2 || actions["create"] != 1 || len(actions) != 2 {
+		t.Errorf("Unexpected changes: %v", actions)
+	}
+	if ignored := fileOps.TakeIgnored(); len(ignored) != 3 || !IsStrategySkip(ignored[0]) {
+		t.Errorf("Expected the second copy to leave the files alone, got %+v", ignored)
+	}
+}
+
+func TestCopyLayerStrategyOverwrite(t *testing.T) {
+	layerDir, targetDir := strategyLayer(t)
+	fileOps := NewFileOperations()
+
+	// By default files with a merge driver are merged rather than overwritten
+	conflicts, err := fileOps.DetectConflicts(layerDir, targetDir)
+	if err != nil || len(conflicts) != 1 || conflicts[0].RelativePath != "README.md" {
+		t.Errorf("Expected README.md to conflict, got %v, %v", conflicts, err)
+	}
+
+	fileOps.Strategy = StrategyOverwrite
+	if conflicts, err := fileOps.DetectConflicts(layerDir, targetDir); err != nil || len(conflicts) != 2 {
+		t.Errorf("Expected both existing files to conflict, got %v, %v", conflicts, err)
+	}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	if content := readTarget(t, targetDir, ".tool-versions"); content != "golang 1.22.0\n" {
+		t.Errorf("Expected .tool-versions to be overwritten, got %q", content)
+	}
+}
+
+func TestCopyLayersStrategy(t *testing.T) {
+	layerDir, targetDir := strategyLayer(t)
+	fileOps := NewFileOperations()
+	fileOps.Output = io.Discard
+
+	jobs := []CopyJob{{Name: "docs", Source: layerDir, Target: targetDir, Delims: [2]string{"{{", "}}"}, Strategy: StrategySkipExisting}}
+	if _, err := fileOps.CopyLayers(jobs, targetDir); err != nil {
+		t.Fatalf("CopyLayers failed: %v", err)
+	}
+	if content := readTarget(t, targetDir, "README.md"); content != "# Project" {
+		t.Errorf("Expected the existing README.md to be kept, got %q", content)
+	}
+}
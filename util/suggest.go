@@ -0,0 +1,131 @@
+package util
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SuggestionRule recommends a layer for projects that have every file in Present, at least one file
+// in Any, and none of the files in Absent. Files are relative to the project and may be globs.
+type SuggestionRule struct {
+	Layer   string   `json:"layer"`             // Repository to add as a LAYER
+	Options string   `json:"options,omitempty"` // Clauses written after the repository, e.g. TARGET .github
+	Reason  string   `json:"reason"`
+	Present []string `json:"present,omitempty"`
+	Any     []string `json:"any,omitempty"`
+	Absent  []string `json:"absent,omitempty"`
+}
+
+// projectSources are files that mark a project otter can suggest language layers for
+var projectSources = []string{"go.mod", "package.json", "pyproject.toml", "requirements.txt", "Cargo.toml"}
+
+// ciConfigs are files that show a project already has CI
+var ciConfigs = []string{".github/workflows/*", ".gitlab-ci.yml", ".circleci/config.yml", "Jenkinsfile", "azure-pipelines.yml"}
+
+// DefaultSuggestionRules are the layers otter suggest knows about without any configuration
+var DefaultSuggestionRules = []SuggestionRule{
+	{Layer: "builtin:editorconfig", Reason: "no .editorconfig", Absent: []string{".editorconfig"}},
+	{Layer: "builtin:gitattributes", Reason: "no .gitattributes", Absent: []string{".gitattributes"}},
+	{Layer: "builtin:makefile", Reason: "Go module without a Makefile", Present: []string{"go.mod"}, Absent: []string{"Makefile"}},
+	{Layer: "git@github.com:otter-layers/go-gitignore.git", Reason: "Go module without a .gitignore", Present: []string{"go.mod"}, Absent: []string{".gitignore"}},
+	{Layer: "git@github.com:otter-layers/npm-setup.git", Reason: "package.json without a .npmrc", Present: []string{"package.json"}, Absent: []string{".npmrc"}},
+	{Layer: "git@github.com:otter-layers/dockerfile.git", Reason: "no Dockerfile", Any: projectSources, Absent: []string{"Dockerfile", "Containerfile"}},
+	{Layer: "git@github.com:otter-layers/github-actions.git", Reason: "no CI configuration", Any: projectSources, Absent: ciConfigs},
+}
+
+// projectTraits are the kinds of project otter suggest reports, by the file that shows them
+var projectTraits = []struct {
+	Name string
+	File string
+}{
+	{"Go", "go.mod"},
+	{"Node.js", "package.json"},
+	{"Python", "pyproject.toml"},
+	{"Python", "requirements.txt"},
+	{"Rust", "Cargo.toml"},
+	{"Docker", "Dockerfile"},
+	{"GitHub Actions", ".github/workflows/*"},
+	{"GitLab CI", ".gitlab-ci.yml"},
+}
+
+// LayerSuggestion is a layer suggested for a project
+type LayerSuggestion struct {
+	Layer   string
+	Options string
+	Reason  string
+}
+
+// Line returns the Otterfile command that adds the suggested layer
+func (s LayerSuggestion) Line() string {
+	if s.Options == "" {
+		return "LAYER " + s.Layer
+	}
+	return "LAYER " + s.Layer + " " + s.Options
+}
+
+// ProjectTraits describes what the project at projectDir is, e.g. "Go (go.mod)"
+func ProjectTraits(projectDir string) []string {
+	var traits []string
+	for _, trait := range projectTraits {
+		if matches := projectFiles(projectDir, trait.File); len(matches) > 0 {
+			traits = append(traits, trait.Name+" ("+matches[0]+")")
+		}
+	}
+	return traits
+}
+
+// SuggestLayers returns the layers rules suggest for the project at projectDir, leaving out the
+// layers it already applies. A layer suggested by several rules is suggested once, for the first.
+func SuggestLayers(projectDir string, rules []SuggestionRule, applied []string) []LayerSuggestion {
+	skip := make(map[string]bool)
+	for _, repository := range applied {
+		skip[strings.TrimSuffix(repository, ".git")] = true
+	}
+
+	var suggestions []LayerSuggestion
+	for _, rule := range rules {
+		if skip[strings.TrimSuffix(rule.Layer, ".git")] || !rule.matches(projectDir) {
+			continue
+		}
+		skip[strings.TrimSuffix(rule.Layer, ".git")] = true
+		suggestions = append(suggestions, LayerSuggestion{Layer: rule.Layer, Options: rule.Options, Reason: rule.Reason})
+	}
+	return suggestions
+}
+
+// matches reports whether the project at projectDir satisfies the rule
+func (r SuggestionRule) matches(projectDir string) bool {
+	for _, pattern := range r.Present {
+		if len(projectFiles(projectDir, pattern)) == 0 {
+			return false
+		}
+	}
+	for _, pattern := range r.Absent {
+		if len(projectFiles(projectDir, pattern)) > 0 {
+			return false
+		}
+	}
+	if len(r.Any) == 0 {
+		return true
+	}
+	for _, pattern := range r.Any {
+		if len(projectFiles(projectDir, pattern)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// projectFiles returns the files in the project matching a glob, relative to the project
+func projectFiles(projectDir, pattern string) []string {
+	matches, err := filepath.Glob(filepath.Join(projectDir, filepath.FromSlash(pattern)))
+	if err != nil {
+		return nil
+	}
+	for i, match := range matches {
+		if relative, err := filepath.Rel(projectDir, match); err == nil {
+			matches[i] = filepath.ToSlash(relative)
+		}
+	}
+	return matches
+}
@@ -0,0 +1,40 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSuggestLayers(t *testing.T) {
+	projectDir := t.TempDir()
+	os.WriteFile(filepath.Join(projectDir, "go.mod"), []byte("module example\n"), 0644)
+	os.WriteFile(filepath.Join(projectDir, ".editorconfig"), []byte("root = true\n"), 0644)
+	os.MkdirAll(filepath.Join(projectDir, ".github", "workflows"), 0755)
+	os.WriteFile(filepath.Join(projectDir, ".github", "workflows", "ci.yml"), []byte("on: push\n"), 0644)
+
+	traits := ProjectTraits(projectDir)
+	if len(traits) != 2 || traits[0] != "Go (go.mod)" || traits[1] != "GitHub Actions (.github/workflows/ci.yml)" {
+		t.Errorf("Unexpected traits: %v", traits)
+	}
+
+	rules := append([]SuggestionRule{
+		{Layer: "git@github.com:example/go-lint.git", Options: "TARGET .", Reason: "Go module", Present: []string{"go.mod"}},
+		{Layer: "git@github.com:example/python.git", Reason: "Python project", Any: []string{"pyproject.toml", "requirements.txt"}},
+	}, DefaultSuggestionRules...)
+	suggestions := SuggestLayers(projectDir, rules, []string{"builtin:gitattributes", "git@github.com:otter-layers/go-gitignore"})
+
+	expected := []string{
+		"LAYER git@github.com:example/go-lint.git TARGET .",
+		"LAYER builtin:makefile",
+		"LAYER git@github.com:otter-layers/dockerfile.git",
+	}
+	if len(suggestions) != len(expected) {
+		t.Fatalf("Expected %v, got %+v", expected, suggestions)
+	}
+	for i := range expected {
+		if suggestions[i].Line() != expected[i] {
+			t.Errorf("Expected %q, got %q", expected[i], suggestions[i].Line())
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// TargetPolicy limits the directories of the project layers may write to, set with targets in
+// .otter/config.json
+type TargetPolicy struct {
+	// Allow lists the TARGET roots layers may use, e.g. configs or .github, where . is the project
+	// root and patterns may use * and ?. Any TARGET is allowed when it is empty.
+	Allow []string `json:"allow"`
+	// Exempt lists the layers, by NAME or repository pattern, that may use any TARGET
+	Exempt []string `json:"exempt"`
+}
+
+// Check returns an error when the policy doesn't allow layer to write to target
+func (p TargetPolicy) Check(layer LayerRef, target string) error {
+	if len(p.Allow) == 0 {
+		return nil
+	}
+	for _, pattern := range p.Exempt {
+		if layer.Matches(pattern) {
+			return nil
+		}
+	}
+
+	target = path.Clean(filepath.ToSlash(target))
+	for _, root := range p.Allow {
+		if targetUnder(target, path.Clean(filepath.ToSlash(root))) {
+			return nil
+		}
+	}
+	return fmt.Errorf("layer %s may not write to TARGET %s; allowed targets are %s (exempt the layer in targets.exempt to allow it)", layer.Repository, target, strings.Join(p.Allow, ", "))
+}
+
+// targetUnder reports whether target is root, or inside it, with root matched as a pattern
+// against the leading directories of target
+func targetUnder(target, root string) bool {
+	if root == "." || target == "." {
+		return root == target
+	}
+
+	rootParts := strings.Split(root, "/")
+	targetParts := strings.Split(target, "/")
+	if len(targetParts) < len(rootParts) {
+		return false
+	}
+	matched, err := path.Match(root, strings.Join(targetParts[:len(rootParts)], "/"))
+	return err == nil && matched
+}
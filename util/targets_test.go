@@ -0,0 +1,38 @@
+package util
+
+import "testing"
+
+func TestTargetPolicy(t *testing.T) {
+	policy := TargetPolicy{
+		Allow:  []string{"configs", ".github/", "tools/*/config"},
+		Exempt: []string{"bootstrap", "git@github.com:org/trusted-*"},
+	}
+	layer := LayerRef{Repository: "git@github.com:org/shared.git"}
+
+	allowed := []string{"configs", "configs/lint", ".github", "./.github/workflows", "tools/lint/config", "tools/lint/config/extra"}
+	for _, target := range allowed {
+		if err := policy.Check(layer, target); err != nil {
+			t.Errorf("Expected TARGET %s to be allowed: %v", target, err)
+		}
+	}
+
+	denied := []string{".", "src", "configs-old", "configs/../src", "tools/lint", "../configs"}
+	for _, target := range denied {
+		if err := policy.Check(layer, target); err == nil {
+			t.Errorf("Expected TARGET %s to be denied", target)
+		}
+	}
+
+	for _, exempt := range []LayerRef{{Name: "bootstrap", Repository: "./layers/bootstrap"}, {Repository: "git@github.com:org/trusted-base.git"}} {
+		if err := policy.Check(exempt, "src"); err != nil {
+			t.Errorf("Expected exempt layer %s to write anywhere: %v", exempt.Repository, err)
+		}
+	}
+
+	if err := (TargetPolicy{}).Check(layer, "src"); err != nil {
+		t.Errorf("Expected an empty policy to allow any TARGET: %v", err)
+	}
+	if err := (TargetPolicy{Allow: []string{"."}}).Check(layer, "."); err != nil {
+		t.Errorf("Expected . to allow the project root: %v", err)
+	}
+}
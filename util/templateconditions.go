@@ -0,0 +1,51 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"text/template"
+)
+
+// ConditionFunc reports whether an IF condition expression holds, e.g. "env=production AND os=linux"
+type ConditionFunc func(expression string) (bool, error)
+
+// conditionFuncPattern finds calls of the condition template functions
+var conditionFuncPattern = regexp.MustCompile(`\botter(If|Env|OS|Arch|Editor)\b`)
+
+// conditionFuncs returns the template functions that evaluate IF conditions, so a file can vary by
+// environment the way layers do: otterIf takes a whole expression, and otterEnv, otterOS,
+// otterArch, and otterEditor compare one built-in variable with a value
+func conditionFuncs(evaluate ConditionFunc) template.FuncMap {
+	check := func(expression string) (bool, error) {
+		if evaluate == nil {
+			return false, fmt.Errorf("conditions are not available to this template")
+		}
+		return evaluate(expression)
+	}
+	equals := func(key string) func(string) (bool, error) {
+		return func(value string) (bool, error) {
+			return check(key + "=" + value)
+		}
+	}
+	return template.FuncMap{
+		"otterIf":     check,
+		"otterEnv":    equals("env"),
+		"otterOS":     equals("os"),
+		"otterArch":   equals("arch"),
+		"otterEditor": equals("editor"),
+	}
+}
+
+// usesConditions reports whether content calls a condition template function
+func usesConditions(content string) bool {
+	return conditionFuncPattern.MatchString(content)
+}
+
+// templateFuncs returns the functions available to layer templates
+func (f *FileOperations) templateFuncs() template.FuncMap {
+	funcs := conditionFuncs(f.Condition)
+	for name, fn := range f.TemplateLimits.funcs() {
+		funcs[name] = fn
+	}
+	return funcs
+}
@@ -0,0 +1,54 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestTemplateConditions(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	content := `{{ if otterEnv "production" }}replicas: 3{{ else }}replicas: 1{{ end }}
+{{ if otterIf "os=linux AND arch=arm64" }}image: app-arm64{{ end }}
+{{ if not (otterEditor "vscode") }}editor: other{{ end }}
+`
+	os.WriteFile(filepath.Join(layerDir, "deploy.yml"), []byte(content), 0644)
+
+	var evaluated []string
+	fileOps := NewFileOperations()
+	fileOps.Condition = func(expression string) (bool, error) {
+		evaluated = append(evaluated, expression)
+		return expression == "env=production" || expression == "os=linux AND arch=arm64", nil
+	}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	written, err := os.ReadFile(filepath.Join(targetDir, "deploy.yml"))
+	if err != nil {
+		t.Fatalf("Failed to read deploy.yml: %v", err)
+	}
+	if expected := "replicas: 3\nimage: app-arm64\neditor: other\n"; string(written) != expected {
+		t.Errorf("Expected %q, got %q", expected, written)
+	}
+	if expected := "env=production|os=linux AND arch=arm64|editor=vscode"; strings.Join(evaluated, "|") != expected {
+		t.Errorf("Expected conditions %q, got %q", expected, strings.Join(evaluated, "|"))
+	}
+
+	// Without an evaluator the file is copied as-is
+	fileOps = NewFileOperations()
+	os.Remove(filepath.Join(targetDir, "deploy.yml"))
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+	if written, _ := os.ReadFile(filepath.Join(targetDir, "deploy.yml")); string(written) != content {
+		t.Errorf("Expected the file to be copied as-is, got %q", written)
+	}
+
+	// Conditions don't hide the variables a template uses
+	if variables := templateVariables(`{{ if otterEnv "production" }}{{ .replicas }}{{ end }}`, [2]string{"{{", "}}"}); len(variables) != 1 || variables[0] != "replicas" {
+		t.Errorf("Expected [replicas], got %v", variables)
+	}
+}
@@ -0,0 +1,34 @@
+package util
+
+import (
+	"os"
+	"path"
+	"strings"
+)
+
+// DefaultTemplateEnv are the environment variables available to templates as .Env unless
+// template_env in .otter/config.json lists others
+var DefaultTemplateEnv = []string{"OTTER_*", "CI", "USER"}
+
+// TemplateEnv returns the environment variables whose names match the allowlist, which may use *
+// wildcards. A nil allowlist uses DefaultTemplateEnv; an empty one exposes nothing.
+func TemplateEnv(allow []string) map[string]string {
+	if allow == nil {
+		allow = DefaultTemplateEnv
+	}
+
+	env := make(map[string]string)
+	for _, entry := range os.Environ() {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || name == "" {
+			continue
+		}
+		for _, pattern := range allow {
+			if matched, _ := path.Match(pattern, name); matched {
+				env[name] = value
+				break
+			}
+		}
+	}
+	return env
+}
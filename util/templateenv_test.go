@@ -0,0 +1,81 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTemplateEnv(t *testing.T) {
+	t.Setenv("OTTER_REGION", "eu")
+	t.Setenv("CI", "true")
+	t.Setenv("SECRET_TOKEN", "hunter2")
+
+	env := TemplateEnv(nil)
+	if env["OTTER_REGION"] != "eu" || env["CI"] != "true" {
+		t.Errorf("Expected default allowlist to expose OTTER_* and CI, got %v", env)
+	}
+	if _, ok := env["SECRET_TOKEN"]; ok {
+		t.Error("Expected variables outside the allowlist to be hidden")
+	}
+
+	env = TemplateEnv([]string{"SECRET_*"})
+	if env["SECRET_TOKEN"] != "hunter2" || len(env) != 1 {
+		t.Errorf("Expected only SECRET_TOKEN, got %v", env)
+	}
+	if env := TemplateEnv([]string{}); len(env) != 0 {
+		t.Errorf("Expected an empty allowlist to expose nothing, got %v", env)
+	}
+}
+
+func TestTemplateEnvRendering(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	content := "region: {{ .Env.OTTER_REGION }}\nci: {{ if .Env.CI }}yes{{ else }}no{{ end }}\n"
+	os.WriteFile(filepath.Join(layerDir, "deploy.yml"), []byte(content), 0644)
+
+	fileOps := NewFileOperations()
+	fileOps.Env = map[string]string{"OTTER_REGION": "eu"}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	written, err := os.ReadFile(filepath.Join(targetDir, "deploy.yml"))
+	if err != nil {
+		t.Fatalf("Failed to read deploy.yml: %v", err)
+	}
+	if expected := "region: eu\nci: no\n"; string(written) != expected {
+		t.Errorf("Expected %q, got %q", expected, written)
+	}
+}
+
+func TestTemplateFactsVerbatimFiles(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	files := map[string]string{
+		// GitHub Actions expressions mention .Env and .Project without being layer templates
+		"ci.yml": "env:\n  TARGET: ${{ vars.Environment }}\n  NAME: ${{ github.event.repository.name }}\n",
+		// Helm templates use functions layers don't have, and facts only in their own fields
+		"deployment.yaml": "name: {{ include \"app.fullname\" . }}\nenv: {{ .Values.Environment | quote }}\n",
+		"values.yaml":     "release: {{ .Release.Name }}\nproject: {{ .Chart.Project.name }}\n",
+	}
+	for name, content := range files {
+		os.WriteFile(filepath.Join(layerDir, name), []byte(content), 0644)
+	}
+
+	fileOps := NewFileOperations()
+	fileOps.Env = map[string]string{"OTTER_REGION": "eu"}
+	fileOps.Project = &ProjectInfo{Module: "github.com/example/api"}
+	fileOps.Build = &BuildInfo{}
+	fileOps.Layer = &LayerInfo{Repository: "./layer"}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	for name, content := range files {
+		written, err := os.ReadFile(filepath.Join(targetDir, name))
+		if err != nil || string(written) != content {
+			t.Errorf("Expected %s to be copied as is, got %q, %v", name, written, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"text/template"
+	"time"
+)
+
+// Default limits on rendering a layer template
+const (
+	DefaultTemplateMaxMegabytes = 10
+	DefaultTemplateTimeout      = 10 * time.Second
+)
+
+// ErrTemplateLimit is returned when rendering a layer template exceeds a limit
+var ErrTemplateLimit = errors.New("template exceeded a limit")
+
+// TemplateLimitsConfig guards rendering layer templates, so a malicious or broken template can't
+// produce huge files or hang a build. Zero uses the default; a negative value disables the check.
+type TemplateLimitsConfig struct {
+	MaxMegabytes   int `json:"max_megabytes"`   // Largest output of one template
+	TimeoutSeconds int `json:"timeout_seconds"` // Longest time one template may take to render
+	// AllowCall enables the call function, which runs any function value reachable from the
+	// template's data
+	AllowCall bool `json:"allow_call"`
+}
+
+// maxBytes returns the output limit in bytes, or 0 when there is none
+func (c TemplateLimitsConfig) maxBytes() int64 {
+	switch {
+	case c.MaxMegabytes == 0:
+		return DefaultTemplateMaxMegabytes * 1024 * 1024
+	case c.MaxMegabytes < 0:
+		return 0
+	}
+	return int64(c.MaxMegabytes) * 1024 * 1024
+}
+
+// timeout returns the render time limit, or 0 when there is none
+func (c TemplateLimitsConfig) timeout() time.Duration {
+	switch {
+	case c.TimeoutSeconds == 0:
+		return DefaultTemplateTimeout
+	case c.TimeoutSeconds < 0:
+		return 0
+	}
+	return time.Duration(c.TimeoutSeconds) * time.Second
+}
+
+// funcs returns the functions that replace built-in template functions the configuration disables
+func (c TemplateLimitsConfig) funcs() template.FuncMap {
+	if c.AllowCall {
+		return nil
+	}
+	return template.FuncMap{
+		"call": func(...interface{}) (interface{}, error) {
+			return nil, fmt.Errorf("call is disabled; set template_limits.allow_call in .otter/config.json to enable it")
+		},
+	}
+}
+
+// execute renders tmpl into out within the configured limits
+func (c TemplateLimitsConfig) execute(tmpl *template.Template, out io.Writer, data interface{}) error {
+	if max := c.maxBytes(); max > 0 {
+		out = &limitedWriter{w: out, remaining: max, max: max}
+	}
+
+	timeout := c.timeout()
+	if timeout == 0 {
+		return tmpl.Execute(out, data)
+	}
+
+	// A template can't be interrupted, so one that runs too long is abandoned; it stops once it
+	// writes after the deadline, or with the process
+	timeoutErr := fmt.Errorf("%w: rendering took longer than %s", ErrTemplateLimit, timeout)
+	done := make(chan error, 1)
+	writer := &deadlineWriter{w: out, deadline: time.Now().Add(timeout), err: timeoutErr}
+	go func() {
+		done <- tmpl.Execute(writer, data)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return timeoutErr
+	}
+}
+
+// limitedWriter fails writes once more than max bytes have been written
+type limitedWriter struct {
+	w         io.Writer
+	remaining int64
+	max       int64
+}
+
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.remaining {
+		return 0, fmt.Errorf("%w: output is larger than %d MB", ErrTemplateLimit, l.max/(1024*1024))
+	}
+	l.remaining -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// deadlineWriter fails writes after a deadline, stopping a template that was abandoned
+type deadlineWriter struct {
+	w        io.Writer
+	deadline time.Time
+	err      error
+}
+
+func (d *deadlineWriter) Write(p []byte) (int, error) {
+	if time.Now().After(d.deadline) {
+		return 0, d.err
+	}
+	return d.w.Write(p)
+}
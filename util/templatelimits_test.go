@@ -0,0 +1,67 @@
+package util
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTemplateLimits(t *testing.T) {
+	// A kilobyte written over a thousand times
+	large := `{{define "kb"}}` + strings.Repeat("x", 1024) + `{{end}}` + strings.Repeat(`{{template "kb"}}`, 1100)
+
+	// Each level renders the one below four times, far more than can finish within the timeout
+	slow := `{{define "l0"}}x{{end}}`
+	for i := 1; i <= 15; i++ {
+		call := fmt.Sprintf(`{{template "l%d"}}`, i-1)
+		slow += fmt.Sprintf(`{{define "l%d"}}%s{{end}}`, i, strings.Repeat(call, 4))
+	}
+	slow += `{{template "l15"}}`
+
+	tests := []struct {
+		name          string
+		content       string
+		limits        TemplateLimitsConfig
+		limit         bool
+		errorContains string
+	}{
+		{"output too large", large, TemplateLimitsConfig{MaxMegabytes: 1}, true, "larger than 1 MB"},
+		{"output limit disabled", large, TemplateLimitsConfig{MaxMegabytes: -1}, false, ""},
+		{"too slow", slow, TemplateLimitsConfig{MaxMegabytes: -1, TimeoutSeconds: 1}, true, "longer than 1s"},
+		{"call disabled", `{{ call "rm" }}`, TemplateLimitsConfig{}, false, "call is disabled"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			layerDir := t.TempDir()
+			targetDir := t.TempDir()
+			os.WriteFile(filepath.Join(layerDir, "out.txt"), []byte(tt.content), 0644)
+
+			fileOps := NewFileOperations()
+			fileOps.Output = &strings.Builder{}
+			fileOps.TemplateLimits = tt.limits
+			start := time.Now()
+			err := fileOps.CopyLayer(layerDir, targetDir, targetDir, map[string]string{"name": "app"}, [2]string{"{{", "}}"}, true)
+			if elapsed := time.Since(start); elapsed > 5*time.Second {
+				t.Errorf("Rendering took %s", elapsed)
+			}
+
+			if tt.errorContains == "" {
+				if err != nil {
+					t.Fatalf("CopyLayer failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errorContains) {
+				t.Fatalf("Expected %q error, got %v", tt.errorContains, err)
+			}
+			if errors.Is(err, ErrTemplateLimit) != tt.limit {
+				t.Errorf("Expected errors.Is(err, ErrTemplateLimit) to be %v for %v", tt.limit, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,153 @@
+package util
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ToolCheck is the result of checking a required tool against the installed one
+type ToolCheck struct {
+	Name      string
+	Operator  string // Version comparison, empty when any version is accepted
+	Required  string // Required version
+	Found     bool   // Whether the tool is on PATH
+	Version   string // Installed version, empty when it could not be determined
+	Satisfied bool
+}
+
+// toolVersionArgs lists the arguments that print the version of tools that don't support --version
+var toolVersionArgs = map[string][]string{
+	"go":   {"version"},
+	"java": {"-version"},
+}
+
+// asdfPlugins and miseTools map command names to version manager names where they differ
+var (
+	asdfPlugins = map[string]string{"go": "golang", "node": "nodejs", "python3": "python"}
+	miseTools   = map[string]string{"python3": "python"}
+)
+
+var versionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// CheckTool looks up a tool on PATH and compares its version with the requirement
+func CheckTool(name, operator, required string) ToolCheck {
+	check := ToolCheck{Name: name, Operator: operator, Required: required}
+
+	toolPath, err := exec.LookPath(name)
+	if err != nil {
+		return check
+	}
+	check.Found = true
+
+	if operator == "" {
+		check.Satisfied = true
+		return check
+	}
+
+	args, ok := toolVersionArgs[name]
+	if !ok {
+		args = []string{"--version"}
+	}
+	output, _ := exec.Command(toolPath, args...).CombinedOutput()
+	check.Version = versionPattern.FindString(string(output))
+	if check.Version == "" {
+		return check
+	}
+
+	cmp := CompareVersions(check.Version, required)
+	switch operator {
+	case ">=":
+		check.Satisfied = cmp >= 0
+	case ">":
+		check.Satisfied = cmp > 0
+	case "<=":
+		check.Satisfied = cmp <= 0
+	case "<":
+		check.Satisfied = cmp < 0
+	case "=":
+		// Only the components given in the requirement are compared, so go=1.22 accepts 1.22.3
+		check.Satisfied = CompareVersions(truncateVersion(check.Version, required), required) == 0
+	}
+
+	return check
+}
+
+// Problem describes why the check failed, or returns an empty string when it passed
+func (c ToolCheck) Problem() string {
+	switch {
+	case c.Satisfied:
+		return ""
+	case !c.Found:
+		return fmt.Sprintf("%s is not installed (requires %s%s%s)", c.Name, c.Name, c.Operator, c.Required)
+	case c.Version == "":
+		return fmt.Sprintf("could not determine the version of %s (requires %s%s%s)", c.Name, c.Name, c.Operator, c.Required)
+	default:
+		return fmt.Sprintf("%s %s is installed but %s%s%s is required", c.Name, c.Version, c.Name, c.Operator, c.Required)
+	}
+}
+
+// InstallHints returns the asdf and mise commands that would install a tool satisfying the check,
+// for whichever of the two version managers is installed
+func (c ToolCheck) InstallHints() []string {
+	version := "latest"
+	if c.Operator == ">=" || c.Operator == "=" {
+		version = c.Required
+	}
+
+	var hints []string
+	if _, err := exec.LookPath("mise"); err == nil {
+		hints = append(hints, fmt.Sprintf("mise use %s@%s", managerName(miseTools, c.Name), version))
+	}
+	if _, err := exec.LookPath("asdf"); err == nil {
+		plugin := managerName(asdfPlugins, c.Name)
+		hints = append(hints, fmt.Sprintf("asdf plugin add %s && asdf install %s %s", plugin, plugin, version))
+	}
+	return hints
+}
+
+// managerName returns the version manager's name for a tool
+func managerName(names map[string]string, tool string) string {
+	if name, ok := names[tool]; ok {
+		return name
+	}
+	return tool
+}
+
+// CompareVersions compares dotted numeric versions, returning -1, 0, or 1.
+// Missing components count as zero, so 1.22 equals 1.22.0.
+func CompareVersions(a, b string) int {
+	aParts := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bParts := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var aNum, bNum int
+		if i < len(aParts) {
+			aNum, _ = strconv.Atoi(aParts[i])
+		}
+		if i < len(bParts) {
+			bNum, _ = strconv.Atoi(bParts[i])
+		}
+
+		if aNum != bNum {
+			if aNum < bNum {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return 0
+}
+
+// truncateVersion shortens version to the number of components in like
+func truncateVersion(version, like string) string {
+	parts := strings.Split(version, ".")
+	n := len(strings.Split(like, "."))
+	if len(parts) > n {
+		parts = parts[:n]
+	}
+	return strings.Join(parts, ".")
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.22", "1.22.0", 0},
+		{"1.22.1", "1.22", 1},
+		{"1.9", "1.22", -1},
+		{"v20.11.0", "20", 1},
+		{"3", "3.0.1", -1},
+	}
+
+	for _, tt := range tests {
+		if got := CompareVersions(tt.a, tt.b); got != tt.expected {
+			t.Errorf("CompareVersions(%s, %s) = %d, expected %d", tt.a, tt.b, got, tt.expected)
+		}
+	}
+}
+
+func TestCheckTool(t *testing.T) {
+	binDir := t.TempDir()
+	fakeTool := "#!/bin/sh\necho \"faketool version v1.22.3 (linux/amd64)\"\n"
+	if err := os.WriteFile(filepath.Join(binDir, "faketool"), []byte(fakeTool), 0755); err != nil {
+		t.Fatalf("Failed to write fake tool: %v", err)
+	}
+	t.Setenv("PATH", binDir)
+
+	tests := []struct {
+		operator, required string
+		satisfied          bool
+	}{
+		{"", "", true},
+		{">=", "1.22", true},
+		{">=", "1.23", false},
+		{"<", "2", true},
+		{"=", "1.22", true},
+		{"=", "1.21", false},
+		{">", "1.22.3", false},
+	}
+
+	for _, tt := range tests {
+		check := CheckTool("faketool", tt.operator, tt.required)
+		if !check.Found {
+			t.Fatal("Expected fake tool to be found")
+		}
+		if check.Satisfied != tt.satisfied {
+			t.Errorf("faketool%s%s: expected satisfied=%v (version %q)", tt.operator, tt.required, tt.satisfied, check.Version)
+		}
+		if tt.satisfied && check.Problem() != "" {
+			t.Errorf("Expected no problem, got %s", check.Problem())
+		}
+	}
+
+	missing := CheckTool("missingtool", ">=", "1.0")
+	if missing.Found || missing.Satisfied || missing.Problem() == "" {
+		t.Errorf("Expected missing tool to fail, got %+v", missing)
+	}
+}
+
+func TestToolInstallHints(t *testing.T) {
+	binDir := t.TempDir()
+	for _, manager := range []string{"mise", "asdf"} {
+		if err := os.WriteFile(filepath.Join(binDir, manager), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatalf("Failed to write fake %s: %v", manager, err)
+		}
+	}
+	t.Setenv("PATH", binDir)
+
+	hints := ToolCheck{Name: "go", Operator: ">=", Required: "1.22"}.InstallHints()
+	if len(hints) != 2 || hints[0] != "mise use go@1.22" || hints[1] != "asdf plugin add golang && asdf install golang 1.22" {
+		t.Errorf("Unexpected install hints: %v", hints)
+	}
+}
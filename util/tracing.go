@@ -0,0 +1,224 @@
+package util
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracerConfig is where a Tracer exports spans and how it identifies otter
+type TracerConfig struct {
+	Endpoint       string            // OTLP/HTTP traces URL, e.g. http://localhost:4318/v1/traces
+	Headers        map[string]string // Sent with every export, e.g. for authentication
+	ServiceName    string
+	ServiceVersion string
+}
+
+// Tracer records spans around the phases of otter operations and exports them to an
+// OpenTelemetry collector with OTLP over HTTP, encoded as JSON. Spans are buffered until Flush.
+// A nil *Tracer records nothing.
+type Tracer struct {
+	config TracerConfig
+	client *http.Client
+	mu     sync.Mutex
+	spans  []otlpSpan
+}
+
+// Span is an operation being traced. A nil *Span records nothing.
+type Span struct {
+	tracer     *Tracer
+	traceID    string
+	spanID     string
+	parentID   string
+	name       string
+	start      time.Time
+	attributes []otlpAttribute
+}
+
+// NewTracer creates a tracer that exports to config.Endpoint
+func NewTracer(config TracerConfig) *Tracer {
+	return &Tracer{config: config, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// Start begins a span. When traceparent is a W3C trace context, e.g. from the TRACEPARENT
+// environment variable of a pipeline, the span joins that trace; otherwise it starts a new one.
+// attributes are key, value pairs.
+func (t *Tracer) Start(name, traceparent string, attributes ...string) *Span {
+	if t == nil {
+		return nil
+	}
+	traceID, parentID, ok := parseTraceparent(traceparent)
+	if !ok {
+		traceID = randomID(16)
+	}
+	return t.newSpan(name, traceID, parentID, attributes)
+}
+
+// Start begins a child span
+func (s *Span) Start(name string, attributes ...string) *Span {
+	if s == nil {
+		return nil
+	}
+	return s.tracer.newSpan(name, s.traceID, s.spanID, attributes)
+}
+
+func (t *Tracer) newSpan(name, traceID, parentID string, attributes []string) *Span {
+	span := &Span{
+		tracer:   t,
+		traceID:  traceID,
+		spanID:   randomID(8),
+		parentID: parentID,
+		name:     name,
+		start:    time.Now(),
+	}
+	for i := 0; i+1 < len(attributes); i += 2 {
+		span.attributes = append(span.attributes, otlpAttribute{Key: attributes[i], Value: otlpValue{StringValue: attributes[i+1]}})
+	}
+	return span
+}
+
+// End finishes the span, marking it failed when err is set
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	span := otlpSpan{
+		TraceID:      s.traceID,
+		SpanID:       s.spanID,
+		ParentSpanID: s.parentID,
+		Name:         s.name,
+		Kind:         1, // SPAN_KIND_INTERNAL
+		Start:        strconv.FormatInt(s.start.UnixNano(), 10),
+		End:          strconv.FormatInt(time.Now().UnixNano(), 10),
+		Attributes:   s.attributes,
+	}
+	if err != nil {
+		span.Status = &otlpStatus{Code: 2, Message: err.Error()} // STATUS_CODE_ERROR
+	}
+
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, span)
+}
+
+// Flush exports the spans that ended since the last flush
+func (t *Tracer) Flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	spans := t.spans
+	t.spans = nil
+	t.mu.Unlock()
+	if len(spans) == 0 {
+		return nil
+	}
+
+	resource := []otlpAttribute{{Key: "service.name", Value: otlpValue{StringValue: t.config.ServiceName}}}
+	if t.config.ServiceVersion != "" {
+		resource = append(resource, otlpAttribute{Key: "service.version", Value: otlpValue{StringValue: t.config.ServiceVersion}})
+	}
+	data, err := json.Marshal(otlpTraces{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: resource},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "otter"}, Spans: spans}},
+	}}})
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, t.config.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to export spans to %s: %w", t.config.Endpoint, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.config.Headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export spans to %s: %w", t.config.Endpoint, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("failed to export spans to %s: unexpected status %s", t.config.Endpoint, resp.Status)
+	}
+	return nil
+}
+
+// parseTraceparent returns the trace and parent span IDs of a W3C traceparent, e.g.
+// 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+func parseTraceparent(traceparent string) (string, string, bool) {
+	parts := strings.Split(strings.TrimSpace(traceparent), "-")
+	if len(parts) < 4 || len(parts[1]) != 32 || len(parts[2]) != 16 {
+		return "", "", false
+	}
+	for _, id := range parts[1:3] {
+		if _, err := hex.DecodeString(id); err != nil || strings.Trim(id, "0") == "" {
+			return "", "", false
+		}
+	}
+	return strings.ToLower(parts[1]), strings.ToLower(parts[2]), true
+}
+
+// randomID returns n random bytes in hex, for trace and span IDs
+func randomID(n int) string {
+	id := make([]byte, n)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// OTLP/JSON trace export request, see the opentelemetry-proto trace service
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID      string          `json:"traceId"`
+	SpanID       string          `json:"spanId"`
+	ParentSpanID string          `json:"parentSpanId,omitempty"`
+	Name         string          `json:"name"`
+	Kind         int             `json:"kind"`
+	Start        string          `json:"startTimeUnixNano"`
+	End          string          `json:"endTimeUnixNano"`
+	Attributes   []otlpAttribute `json:"attributes,omitempty"`
+	Status       *otlpStatus     `json:"status,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
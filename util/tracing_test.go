@@ -0,0 +1,88 @@
+package util
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracerExport(t *testing.T) {
+	var received []byte
+	var authorization string
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		authorization = r.Header.Get("Authorization")
+	}))
+	defer collector.Close()
+
+	tracer := NewTracer(TracerConfig{
+		Endpoint:       collector.URL + "/v1/traces",
+		Headers:        map[string]string{"Authorization": "Bearer secret"},
+		ServiceName:    "otter",
+		ServiceVersion: "v1.0.0",
+	})
+	root := tracer.Start("otter.build", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "otter.project", "/srv/app")
+	fetch := root.Start("otter.fetch", "otter.layer", "git@github.com:example/layer.git")
+	fetch.End(errors.New("connection refused"))
+	root.End(nil)
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if authorization != "Bearer secret" {
+		t.Errorf("Expected the configured headers to be sent, got %q", authorization)
+	}
+
+	var export otlpTraces
+	if err := json.Unmarshal(received, &export); err != nil {
+		t.Fatalf("Failed to decode export %s: %v", received, err)
+	}
+	if len(export.ResourceSpans) != 1 || len(export.ResourceSpans[0].ScopeSpans) != 1 {
+		t.Fatalf("Unexpected export: %s", received)
+	}
+	spans := export.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected 2 spans, got %d", len(spans))
+	}
+	fetchSpan, rootSpan := spans[0], spans[1]
+	if rootSpan.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || rootSpan.ParentSpanID != "00f067aa0ba902b7" {
+		t.Errorf("Expected the root span to join the trace of the traceparent, got %+v", rootSpan)
+	}
+	if fetchSpan.TraceID != rootSpan.TraceID || fetchSpan.ParentSpanID != rootSpan.SpanID {
+		t.Errorf("Expected the fetch span to be a child of the root span, got %+v", fetchSpan)
+	}
+	if fetchSpan.Status == nil || fetchSpan.Status.Code != 2 || fetchSpan.Status.Message != "connection refused" {
+		t.Errorf("Expected the fetch span to be failed, got %+v", fetchSpan.Status)
+	}
+	if rootSpan.Status != nil {
+		t.Errorf("Expected the root span to have no status, got %+v", rootSpan.Status)
+	}
+
+	// Spans are exported once
+	received = nil
+	if err := tracer.Flush(); err != nil || received != nil {
+		t.Errorf("Expected nothing to export, got %s (%v)", received, err)
+	}
+}
+
+func TestTracerNewTrace(t *testing.T) {
+	tracer := NewTracer(TracerConfig{ServiceName: "otter"})
+	for _, traceparent := range []string{"", "garbage", "00-00000000000000000000000000000000-00f067aa0ba902b7-01"} {
+		span := tracer.Start("otter.build", traceparent)
+		if len(span.traceID) != 32 || span.parentID != "" {
+			t.Errorf("Expected a new trace for traceparent %q, got trace %q with parent %q", traceparent, span.traceID, span.parentID)
+		}
+	}
+
+	// A nil tracer records nothing
+	var disabled *Tracer
+	span := disabled.Start("otter.build", "")
+	span.Start("otter.parse").End(nil)
+	span.End(nil)
+	if err := disabled.Flush(); err != nil {
+		t.Errorf("Flush of nil tracer failed: %v", err)
+	}
+}
@@ -0,0 +1,211 @@
+package util
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/format/diff"
+)
+
+// Kinds of UpstreamChange
+const (
+	UpstreamAdded    = "added"
+	UpstreamModified = "modified"
+	UpstreamDeleted  = "deleted"
+)
+
+// UpstreamChange is a file of a layer that differs between two of its commits, such as the commit
+// pinned in the lockfile and the latest one
+type UpstreamChange struct {
+	Action    string // One of the Upstream constants
+	Path      string // Path of the file in the layer, with forward slashes
+	Additions int    // Lines added; zero for binary files
+	Deletions int    // Lines deleted; zero for binary files
+	// Destination is where the project receives the file, and Ignored why it doesn't, e.g. the
+	// ignore rule that matches it; both are set by MapUpstreamChanges
+	Destination string
+	Ignored     string
+}
+
+// ResolveCommit returns the commit a branch, tag, or commit of a cached layer repository is at
+func (g *GitOperations) ResolveCommit(localPath, ref string) (string, error) {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open repository at %s: %w", localPath, err)
+	}
+	hash, err := resolveRef(repo, ref)
+	if err != nil {
+		return "", fmt.Errorf("ref %s not found in %s", ref, localPath)
+	}
+	return hash.String(), nil
+}
+
+// UpstreamChanges returns the files that differ between commits from and to of a cached layer
+// repository, sorted by path
+func (g *GitOperations) UpstreamChanges(localPath, from, to string) ([]UpstreamChange, error) {
+	patch, err := commitPatch(localPath, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []UpstreamChange
+	for _, filePatch := range patch.FilePatches() {
+		change := UpstreamChange{Action: UpstreamModified}
+		fromFile, toFile := filePatch.Files()
+		switch {
+		case fromFile == nil:
+			change.Action, change.Path = UpstreamAdded, toFile.Path()
+		case toFile == nil:
+			change.Action, change.Path = UpstreamDeleted, fromFile.Path()
+		default:
+			change.Path = toFile.Path()
+		}
+		for _, chunk := range filePatch.Chunks() {
+			lines := strings.Count(chunk.Content(), "\n")
+			if content := chunk.Content(); content != "" && !strings.HasSuffix(content, "\n") {
+				lines++
+			}
+			switch chunk.Type() {
+			case diff.Add:
+				change.Additions += lines
+			case diff.Delete:
+				change.Deletions += lines
+			}
+		}
+		changes = append(changes, change)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	return changes, nil
+}
+
+// UpstreamPatch returns the content of the changes between commits from and to of a cached layer
+// repository, for the files the project receives. Each file is named by its destination relative
+// to root, or by its path in the layer when it has none, e.g. for remote targets.
+func (g *GitOperations) UpstreamPatch(localPath, from, to string, changes []UpstreamChange, root string) (diff.Patch, error) {
+	patch, err := commitPatch(localPath, from, to)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	for _, change := range changes {
+		if change.Ignored != "" {
+			continue
+		}
+		names[change.Path] = change.Path
+		if change.Destination != "" {
+			if relativePath, err := filepath.Rel(root, change.Destination); err == nil {
+				names[change.Path] = filepath.ToSlash(relativePath)
+			}
+		}
+	}
+
+	var filePatches []diff.FilePatch
+	for _, filePatch := range patch.FilePatches() {
+		fromFile, toFile := filePatch.Files()
+		layerPath := ""
+		if toFile != nil {
+			layerPath = toFile.Path()
+		} else if fromFile != nil {
+			layerPath = fromFile.Path()
+		}
+		name, ok := names[layerPath]
+		if !ok {
+			continue
+		}
+		displayed := displayedFilePatch{FilePatch: filePatch}
+		if fromFile != nil {
+			displayed.from = displayedFile{File: fromFile, path: DisplayPath(name)}
+		}
+		if toFile != nil {
+			displayed.to = displayedFile{File: toFile, path: DisplayPath(name)}
+		}
+		filePatches = append(filePatches, displayed)
+	}
+	sort.Slice(filePatches, func(i, j int) bool { return displayedPath(filePatches[i]) < displayedPath(filePatches[j]) })
+	return displayedPatch(filePatches), nil
+}
+
+// commitPatch returns the patch between commits from and to of a cached layer repository
+func commitPatch(localPath, from, to string) (diff.Patch, error) {
+	repo, err := git.PlainOpen(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository at %s: %w", localPath, err)
+	}
+	fromCommit, err := repo.CommitObject(plumbing.NewHash(from))
+	if err != nil {
+		return nil, fmt.Errorf("commit %s not found in %s: %w", from, localPath, err)
+	}
+	toCommit, err := repo.CommitObject(plumbing.NewHash(to))
+	if err != nil {
+		return nil, fmt.Errorf("commit %s not found in %s: %w", to, localPath, err)
+	}
+	patch, err := fromCommit.Patch(toCommit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare %s with %s: %w", from, to, err)
+	}
+	return patch, nil
+}
+
+// displayedPatch is a patch of the files a project receives, without a commit message
+type displayedPatch []diff.FilePatch
+
+func (p displayedPatch) FilePatches() []diff.FilePatch { return p }
+func (p displayedPatch) Message() string               { return "" }
+
+// displayedFilePatch is the patch of a layer file, named where the project receives it
+type displayedFilePatch struct {
+	diff.FilePatch
+	from, to diff.File
+}
+
+func (p displayedFilePatch) Files() (diff.File, diff.File) { return p.from, p.to }
+
+// displayedFile is a file of a patch shown under another path
+type displayedFile struct {
+	diff.File
+	path string
+}
+
+func (f displayedFile) Path() string { return f.path }
+
+// displayedPath returns the path a file patch is shown under
+func displayedPath(filePatch diff.FilePatch) string {
+	from, to := filePatch.Files()
+	if to != nil {
+		return to.Path()
+	}
+	return from.Path()
+}
+
+// MapUpstreamChanges sets where the project receives each changed file of a layer copied from
+// layerPath to targetPath, or why the ignore rules keep it out of the project
+func (f *FileOperations) MapUpstreamChanges(layerPath, targetPath string, changes []UpstreamChange) error {
+	rules, err := f.combinedIgnoreRules(layerPath)
+	if err != nil {
+		return err
+	}
+
+	for i := range changes {
+		relativePath := filepath.FromSlash(changes[i].Path)
+		changes[i].Destination = f.destinationPath(targetPath, relativePath)
+		if filepath.Base(relativePath) == GitignoreFragmentName {
+			// Fragments are assembled into the .gitignore beside them
+			changes[i].Destination = filepath.Join(filepath.Dir(changes[i].Destination), ".gitignore")
+		}
+
+		// A rule matching the file or a directory above it keeps it out of the project
+		for path := relativePath; path != "." && path != string(filepath.Separator); path = filepath.Dir(path) {
+			if rule := f.matchingIgnoreRule(path, f.destinationPath(targetPath, path), rules); rule != nil {
+				changes[i].Ignored = "ignored by " + rule.String()
+				break
+			}
+		}
+	}
+	return nil
+}
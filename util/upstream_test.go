@@ -0,0 +1,108 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+)
+
+func TestUpstreamChanges(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	commitFile(t, upstream, upstreamDir, "config.yml", "a: 1\nb: 2\n")
+	pinned := commitFile(t, upstream, upstreamDir, "old.txt", "old\n")
+
+	os.MkdirAll(filepath.Join(upstreamDir, "docs"), 0755)
+	commitFile(t, upstream, upstreamDir, "config.yml", "a: 1\nb: 3\nc: 4\n")
+	commitFile(t, upstream, upstreamDir, filepath.Join("docs", "guide.md"), "guide\n")
+	worktree, _ := upstream.Worktree()
+	worktree.Remove("old.txt")
+	latest := commitFile(t, upstream, upstreamDir, GitignoreFragmentName, "*.log\n")
+
+	gitOps := NewGitOperations(t.TempDir())
+	changes, err := gitOps.UpstreamChanges(upstreamDir, pinned.String(), latest.String())
+	if err != nil {
+		t.Fatalf("UpstreamChanges failed: %v", err)
+	}
+
+	fileOps := NewFileOperations()
+	fileOps.IgnorePatterns = []string{"docs/"}
+	projectRoot := t.TempDir()
+	target := filepath.Join(projectRoot, "app")
+	if err := fileOps.MapUpstreamChanges(upstreamDir, target, changes); err != nil {
+		t.Fatalf("MapUpstreamChanges failed: %v", err)
+	}
+
+	expected := []UpstreamChange{
+		{Action: UpstreamAdded, Path: GitignoreFragmentName, Additions: 1, Destination: filepath.Join(target, ".gitignore")},
+		{Action: UpstreamModified, Path: "config.yml", Additions: 2, Deletions: 1, Destination: filepath.Join(target, "config.yml")},
+		{Action: UpstreamAdded, Path: "docs/guide.md", Additions: 1, Destination: filepath.Join(target, "docs", "guide.md"), Ignored: "ignored by docs/ from project"},
+		{Action: UpstreamDeleted, Path: "old.txt", Deletions: 1, Destination: filepath.Join(target, "old.txt")},
+	}
+	if len(changes) != len(expected) {
+		t.Fatalf("Expected %d changes, got %+v", len(expected), changes)
+	}
+	for i, change := range changes {
+		if change != expected[i] {
+			t.Errorf("Change %d: expected %+v, got %+v", i, expected[i], change)
+		}
+	}
+
+	if _, err := gitOps.ResolveCommit(upstreamDir, "HEAD~1"); err != nil {
+		t.Errorf("ResolveCommit failed: %v", err)
+	}
+	if _, err := gitOps.ResolveCommit(upstreamDir, "v9.9.9"); err == nil {
+		t.Error("Expected an error for a missing tag")
+	}
+}
+
+func TestUpstreamPatch(t *testing.T) {
+	upstreamDir := t.TempDir()
+	upstream, err := git.PlainInit(upstreamDir, false)
+	if err != nil {
+		t.Fatalf("Failed to init repository: %v", err)
+	}
+	commitFile(t, upstream, upstreamDir, "config.yml", "a: 1\n")
+	pinned := commitFile(t, upstream, upstreamDir, "notes.txt", "old\n")
+	commitFile(t, upstream, upstreamDir, "config.yml", "a: 2\n")
+	latest := commitFile(t, upstream, upstreamDir, "notes.txt", "new\n")
+
+	gitOps := NewGitOperations(t.TempDir())
+	changes, err := gitOps.UpstreamChanges(upstreamDir, pinned.String(), latest.String())
+	if err != nil {
+		t.Fatalf("UpstreamChanges failed: %v", err)
+	}
+
+	fileOps := NewFileOperations()
+	fileOps.IgnorePatterns = []string{"notes.txt"}
+	fileOps.Map = []PathMapping{{From: "config.yml", To: "設定/config.yml"}}
+	projectRoot := t.TempDir()
+	if err := fileOps.MapUpstreamChanges(upstreamDir, filepath.Join(projectRoot, "app"), changes); err != nil {
+		t.Fatalf("MapUpstreamChanges failed: %v", err)
+	}
+
+	patch, err := gitOps.UpstreamPatch(upstreamDir, pinned.String(), latest.String(), changes, projectRoot)
+	if err != nil {
+		t.Fatalf("UpstreamPatch failed: %v", err)
+	}
+	var unified strings.Builder
+	if err := WriteUnifiedDiff(&unified, patch, false); err != nil {
+		t.Fatalf("WriteUnifiedDiff failed: %v", err)
+	}
+
+	// The ignored file is left out and the other is named where the project receives it
+	for _, expected := range []string{"diff --git a/app/設定/config.yml b/app/設定/config.yml\n", "-a: 1\n+a: 2\n"} {
+		if !strings.Contains(unified.String(), expected) {
+			t.Errorf("Expected the diff to contain %q, got:\n%s", expected, unified.String())
+		}
+	}
+	if strings.Contains(unified.String(), "notes.txt") {
+		t.Errorf("Expected the ignored file to be left out, got:\n%s", unified.String())
+	}
+}
@@ -0,0 +1,257 @@
+package util
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// usageOperations are the operations whose audit entries say which layers a project uses
+var usageOperations = map[string]bool{"build": true, "apply": true, "bake": true, "new": true}
+
+// usageVersionPattern matches refs that are versions, e.g. v1.2.0, which can be ordered
+var usageVersionPattern = regexp.MustCompile(`^v?\d+(\.\d+)*$`)
+
+// UsageReport is which layers, at which refs, projects applied in their latest successful builds,
+// read from audit entries collected from many projects
+type UsageReport struct {
+	Projects int          `json:"projects"`
+	Reports  int          `json:"reports"` // Audit entries read
+	Layers   []LayerUsage `json:"layers"`
+	// Skipped are files found that aren't audit logs or build notifications
+	Skipped []string `json:"skipped,omitempty"`
+}
+
+// LayerUsage is where a layer repository is used
+type LayerUsage struct {
+	Repository string `json:"repository"` // Without the ref it is pinned to
+	// Latest is the newest version any project pins the layer to; empty when none pins a version
+	Latest   string         `json:"latest,omitempty"`
+	Versions []VersionUsage `json:"versions"`
+}
+
+// VersionUsage is the projects using a layer at one ref
+type VersionUsage struct {
+	// Ref is what the layer is pinned to, e.g. v1.2.0; empty for layers that aren't pinned, such as
+	// ones tracking the default branch
+	Ref      string         `json:"ref,omitempty"`
+	Outdated bool           `json:"outdated,omitempty"` // Pinned to an older version than Latest
+	Projects []ProjectUsage `json:"projects"`
+}
+
+// ProjectUsage is a project using a layer, with the commit of its latest build
+type ProjectUsage struct {
+	Project string    `json:"project"`
+	Commit  string    `json:"commit,omitempty"`
+	Built   time.Time `json:"built"`
+}
+
+// Outdated counts the projects pinned to an older version of a layer than another project uses
+func (r *UsageReport) Outdated() int {
+	outdated := 0
+	for _, layer := range r.Layers {
+		for _, version := range layer.Versions {
+			if version.Outdated {
+				outdated += len(version.Projects)
+			}
+		}
+	}
+	return outdated
+}
+
+// projectEntry is an audit entry and the project it came from
+type projectEntry struct {
+	project string
+	entry   AuditEntry
+}
+
+// AggregateUsage reads the audit logs and build notifications that sources name, each a
+// directory, a file, or a glob, and reports the layers each project used in its latest
+// successful build. Directories are searched for audit.log, *.json, and *.jsonl files.
+func AggregateUsage(sources []string) (*UsageReport, error) {
+	report := &UsageReport{Layers: make([]LayerUsage, 0)}
+	latest := make(map[string]projectEntry)
+	for _, source := range sources {
+		files, err := usageFiles(source)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range files {
+			entries, err := readUsageEntries(name)
+			if err != nil {
+				report.Skipped = append(report.Skipped, name)
+				continue
+			}
+			report.Reports += len(entries)
+			for _, entry := range entries {
+				if !entry.entry.Success || !usageOperations[entry.entry.Operation] {
+					continue
+				}
+				if current, ok := latest[entry.project]; !ok || entry.entry.Time.After(current.entry.Time) {
+					latest[entry.project] = entry
+				}
+			}
+		}
+	}
+	report.Projects = len(latest)
+
+	usages := make(map[string]map[string]*VersionUsage)
+	for project, entry := range latest {
+		seen := make(map[string]bool)
+		for _, layer := range entry.entry.Layers {
+			if seen[layer.Repository] {
+				continue
+			}
+			seen[layer.Repository] = true
+
+			repository, ref := SplitLayerRef(layer.Repository)
+			if usages[repository] == nil {
+				usages[repository] = make(map[string]*VersionUsage)
+			}
+			version := usages[repository][ref]
+			if version == nil {
+				version = &VersionUsage{Ref: ref}
+				usages[repository][ref] = version
+			}
+			version.Projects = append(version.Projects, ProjectUsage{Project: project, Commit: layer.Commit, Built: entry.entry.Time})
+		}
+	}
+
+	for repository, versions := range usages {
+		layer := LayerUsage{Repository: repository}
+		for _, version := range versions {
+			if usageVersionPattern.MatchString(version.Ref) && (layer.Latest == "" || CompareVersions(version.Ref, layer.Latest) > 0) {
+				layer.Latest = version.Ref
+			}
+			sort.Slice(version.Projects, func(i, j int) bool { return version.Projects[i].Project < version.Projects[j].Project })
+			layer.Versions = append(layer.Versions, *version)
+		}
+		for i, version := range layer.Versions {
+			layer.Versions[i].Outdated = usageVersionPattern.MatchString(version.Ref) && CompareVersions(version.Ref, layer.Latest) < 0
+		}
+		sort.Slice(layer.Versions, func(i, j int) bool { return refBefore(layer.Versions[i].Ref, layer.Versions[j].Ref) })
+		report.Layers = append(report.Layers, layer)
+	}
+	sort.Slice(report.Layers, func(i, j int) bool { return report.Layers[i].Repository < report.Layers[j].Repository })
+	return report, nil
+}
+
+// refBefore orders refs with versions first, newest first, then other refs by name, then layers
+// that aren't pinned
+func refBefore(a, b string) bool {
+	aVersion, bVersion := usageVersionPattern.MatchString(a), usageVersionPattern.MatchString(b)
+	switch {
+	case aVersion && bVersion:
+		if cmp := CompareVersions(a, b); cmp != 0 {
+			return cmp > 0
+		}
+		return a < b
+	case aVersion != bVersion:
+		return aVersion
+	case (a == "") != (b == ""):
+		return b == ""
+	}
+	return a < b
+}
+
+// usageFiles returns the report files source names: the files of a directory that can hold audit
+// entries, a file, or the files a glob matches
+func usageFiles(source string) ([]string, error) {
+	info, err := os.Stat(source)
+	if err == nil && info.IsDir() {
+		var files []string
+		err := filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() {
+				if path != source && (d.Name() == ".git" || d.Name() == "node_modules") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if ext := filepath.Ext(path); d.Name() == AuditLogFileName || ext == ".json" || ext == ".jsonl" {
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read reports in %s: %w", source, err)
+		}
+		return files, nil
+	}
+	if err == nil {
+		return []string{source}, nil
+	}
+
+	files, globErr := filepath.Glob(source)
+	if globErr != nil {
+		return nil, fmt.Errorf("invalid pattern %s: %w", source, globErr)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no reports found at %s", source)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// readUsageEntries reads the audit entries of a file: an audit log with one entry per line, or
+// build notifications, which carry the project they came from. Other entries are attributed to
+// the project the file is named for. Files without any audit entry are an error.
+func readUsageEntries(name string) ([]projectEntry, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []projectEntry
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	for {
+		var notification struct {
+			Project string `json:"project"`
+			AuditEntry
+		}
+		if err := decoder.Decode(&notification); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", name, err)
+		}
+		if notification.Operation == "" {
+			return nil, fmt.Errorf("%s isn't an audit log or build notification", name)
+		}
+		project := notification.Project
+		if project == "" {
+			project = usageProject(name)
+		}
+		entries = append(entries, projectEntry{project: project, entry: notification.AuditEntry})
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s has no audit entries", name)
+	}
+	return entries, nil
+}
+
+// usageProject names the project a report file came from: the directory of a project's
+// .otter/audit.log, or the file name without its extension, e.g. billing for billing.jsonl
+func usageProject(name string) string {
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		abs = name
+	}
+	if filepath.Base(abs) == AuditLogFileName {
+		dir := filepath.Dir(abs)
+		if filepath.Base(dir) == ".otter" {
+			dir = filepath.Dir(dir)
+		}
+		return filepath.Base(dir)
+	}
+	return strings.TrimSuffix(filepath.Base(abs), filepath.Ext(abs))
+}
@@ -0,0 +1,78 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAggregateUsage(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		// An audit log is attributed to its project, and only its latest successful build counts
+		"billing/.otter/audit.log": `{"time":"2026-01-01T00:00:00Z","operation":"build","layers":[{"repository":"git@github.com:org/ci.git@v1.2.0","target":"."}],"success":true}
+{"time":"2026-02-01T00:00:00Z","operation":"build","layers":[{"repository":"git@github.com:org/ci.git@v1.3.0","target":"."},{"repository":"git@github.com:org/ci.git@v1.3.0","target":"docs"}],"success":true}
+{"time":"2026-03-01T00:00:00Z","operation":"build","layers":[],"success":false,"error":"failed"}
+{"time":"2026-03-02T00:00:00Z","operation":"plan","layers":[{"repository":"git@github.com:org/ci.git@v2.0.0","target":"."}],"success":true}
+`,
+		// A build notification names its project
+		"notifications/1.json": `{"project":"payments","event":"success","time":"2026-02-10T00:00:00Z","operation":"apply","layers":[{"repository":"git@github.com:org/ci.git@v1.10.0","target":"."},{"repository":"git@github.com:org/docker.git","commit":"cccccccccccc","target":"."}],"success":true}`,
+		"search.jsonl":         `{"time":"2026-02-11T00:00:00Z","operation":"build","layers":[{"repository":"git@github.com:org/ci.git@main","target":"."},{"repository":"git@github.com:org/docker.git","commit":"dddddddddddd","target":"."}],"success":true}`,
+		"web/package.json":     `{"name":"web"}`,
+		"web/.git/audit.log":   `not json`,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := AggregateUsage([]string{dir})
+	if err != nil {
+		t.Fatalf("AggregateUsage failed: %v", err)
+	}
+	if report.Projects != 3 || report.Reports != 6 {
+		t.Errorf("Expected 3 projects from 6 entries, got %d from %d", report.Projects, report.Reports)
+	}
+	if !reflect.DeepEqual(report.Skipped, []string{filepath.Join(dir, "web", "package.json")}) {
+		t.Errorf("Unexpected skipped files: %v", report.Skipped)
+	}
+	if len(report.Layers) != 2 {
+		t.Fatalf("Expected 2 layers, got %+v", report.Layers)
+	}
+
+	ci := report.Layers[0]
+	if ci.Repository != "git@github.com:org/ci.git" || ci.Latest != "v1.10.0" {
+		t.Errorf("Unexpected layer: %+v", ci)
+	}
+	var versions []string
+	for _, version := range ci.Versions {
+		versions = append(versions, version.Ref+"="+version.Projects[0].Project)
+		if expected := version.Ref == "v1.3.0"; version.Outdated != expected {
+			t.Errorf("Expected %s outdated to be %v", version.Ref, expected)
+		}
+	}
+	if expected := []string{"v1.10.0=payments", "v1.3.0=billing", "main=search"}; !reflect.DeepEqual(versions, expected) {
+		t.Errorf("Expected versions %v, got %v", expected, versions)
+	}
+	if report.Outdated() != 1 {
+		t.Errorf("Expected 1 outdated project, got %d", report.Outdated())
+	}
+
+	docker := report.Layers[1]
+	if len(docker.Versions) != 1 || docker.Versions[0].Ref != "" || len(docker.Versions[0].Projects) != 2 || docker.Versions[0].Projects[1].Commit != "dddddddddddd" {
+		t.Errorf("Unexpected layer: %+v", docker)
+	}
+
+	// Globs read the files they match
+	report, err = AggregateUsage([]string{filepath.Join(dir, "notifications", "*.json")})
+	if err != nil || report.Projects != 1 {
+		t.Errorf("Expected 1 project, got %+v, %v", report, err)
+	}
+	if _, err := AggregateUsage([]string{filepath.Join(dir, "missing", "*.json")}); err == nil {
+		t.Error("Expected a pattern matching nothing to fail")
+	}
+}
@@ -0,0 +1,62 @@
+package util
+
+import (
+	"os"
+	"os/user"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+)
+
+// UserInfo holds facts about the developer running otter, for templates to fill in author fields
+// and license headers. Facts that can't be detected are empty.
+type UserInfo struct {
+	Name     string // user.name from git config
+	Email    string // user.email from git config
+	Username string // Operating system login name
+	Hostname string // Name of the machine
+}
+
+// DetectUser reads the git identity, preferring the project repository's config over the global
+// one, and the operating system user and hostname
+func DetectUser(projectRoot string) *UserInfo {
+	info := &UserInfo{}
+
+	var gitConfig *config.Config
+	if repo, err := git.PlainOpenWithOptions(projectRoot, &git.PlainOpenOptions{DetectDotGit: true}); err == nil {
+		gitConfig, _ = repo.ConfigScoped(config.GlobalScope)
+	}
+	if gitConfig == nil {
+		gitConfig, _ = config.LoadConfig(config.GlobalScope)
+	}
+	if gitConfig != nil {
+		info.Name = gitConfig.User.Name
+		info.Email = gitConfig.User.Email
+	}
+
+	if current, err := user.Current(); err == nil {
+		info.Username = current.Username
+	} else if name := os.Getenv("USER"); name != "" {
+		info.Username = name
+	} else {
+		info.Username = os.Getenv("USERNAME")
+	}
+	info.Hostname, _ = os.Hostname()
+
+	return info
+}
+
+// Fact returns a fact by its ${...} placeholder name, e.g. "user.name" or "hostname"
+func (u *UserInfo) Fact(key string) (string, bool) {
+	switch key {
+	case "user.name":
+		return u.Name, true
+	case "user.email":
+		return u.Email, true
+	case "user.username":
+		return u.Username, true
+	case "hostname":
+		return u.Hostname, true
+	}
+	return "", false
+}
@@ -0,0 +1,52 @@
+package util
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetectUser(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	gitconfig := "[user]\n\tname = Jane Doe\n\temail = jane@example.com\n"
+	if err := os.WriteFile(filepath.Join(home, ".gitconfig"), []byte(gitconfig), 0644); err != nil {
+		t.Fatalf("Failed to write .gitconfig: %v", err)
+	}
+
+	info := DetectUser(t.TempDir())
+	if info.Name != "Jane Doe" || info.Email != "jane@example.com" {
+		t.Errorf("Expected identity from the global git config, got %+v", info)
+	}
+	if hostname, _ := os.Hostname(); info.Hostname != hostname {
+		t.Errorf("Expected hostname %s, got %s", hostname, info.Hostname)
+	}
+
+	if value, ok := info.Fact("user.email"); !ok || value != "jane@example.com" {
+		t.Errorf("Expected user.email fact, got %q", value)
+	}
+	if _, ok := info.Fact("user.unknown"); ok {
+		t.Error("Expected unknown fact not to be found")
+	}
+}
+
+func TestTemplateUserFacts(t *testing.T) {
+	layerDir := t.TempDir()
+	targetDir := t.TempDir()
+	os.WriteFile(filepath.Join(layerDir, "LICENSE"), []byte("Copyright {{.User.Name}} <{{.User.Email}}>\n"), 0644)
+
+	fileOps := NewFileOperations()
+	fileOps.User = &UserInfo{Name: "Jane Doe", Email: "jane@example.com"}
+	if err := fileOps.CopyLayer(layerDir, targetDir, targetDir, nil, [2]string{"{{", "}}"}, true); err != nil {
+		t.Fatalf("CopyLayer failed: %v", err)
+	}
+
+	content, err := os.ReadFile(filepath.Join(targetDir, "LICENSE"))
+	if err != nil {
+		t.Fatalf("Failed to read LICENSE: %v", err)
+	}
+	if string(content) != "Copyright Jane Doe <jane@example.com>\n" {
+		t.Errorf("Expected developer facts to be rendered, got %q", content)
+	}
+}
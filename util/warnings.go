@@ -0,0 +1,98 @@
+package util
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Warning codes. Each can be suppressed in .otter/config.json (warnings.disable) or with an
+// "# otter:disable=CODE" comment on the Otterfile command it concerns.
+const (
+	WarnUnknownVariable = "WARN001" // A ${...} placeholder didn't match a variable and was left as is
+	WarnLayerOverride   = "WARN002" // A layer overwrote a file another layer wrote in the same build
+	WarnSlowHook        = "WARN003" // A hook took longer than SlowHookThreshold
+	WarnDeprecatedLayer = "WARN004" // An applied layer is deprecated
+	WarnIncompatible    = "WARN005" // An applied layer declares it doesn't work with the other layers or the project
+	WarnSecret          = "WARN006" // A layer wrote content that looks like it contains a credential
+)
+
+// SlowHookThreshold is how long a hook may run before WarnSlowHook is reported
+const SlowHookThreshold = 30 * time.Second
+
+// DisableDirective starts an Otterfile comment that suppresses warnings, e.g. "# otter:disable=WARN001,WARN002"
+const DisableDirective = "otter:disable="
+
+var warningCodePattern = regexp.MustCompile(`^WARN\d{3}$`)
+
+// Warning is a problem that doesn't stop a build but likely needs attention
+type Warning struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (w Warning) String() string {
+	return w.Code + ": " + w.Message
+}
+
+// Warnings reports warnings that aren't suppressed and keeps them for the audit log
+type Warnings struct {
+	Reported []Warning // Warnings reported so far
+
+	disabled map[string]bool
+}
+
+// NewWarnings creates a reporter that never reports the disabled codes
+func NewWarnings(disabled []string) *Warnings {
+	w := &Warnings{disabled: make(map[string]bool)}
+	for _, code := range disabled {
+		w.disabled[code] = true
+	}
+	return w
+}
+
+// Warn prints and records a warning unless its code is disabled globally or in suppressed, and
+// reports whether it did
+func (w *Warnings) Warn(code string, suppressed []string, format string, args ...interface{}) bool {
+	if w.disabled[code] || containsString(suppressed, code) {
+		return false
+	}
+	warning := Warning{Code: code, Message: fmt.Sprintf(format, args...)}
+	fmt.Printf("  Warning [%s]: %s\n", warning.Code, warning.Message)
+	w.Reported = append(w.Reported, warning)
+	return true
+}
+
+// Add reports warnings collected elsewhere, such as while parsing the Otterfile
+func (w *Warnings) Add(warnings ...Warning) {
+	for _, warning := range warnings {
+		w.Warn(warning.Code, nil, "%s", warning.Message)
+	}
+}
+
+// ParseDisableDirective returns the warning codes of an "otter:disable=" directive in a comment,
+// and the line with the comment removed. Lines without a directive are returned unchanged.
+func ParseDisableDirective(line string) (string, []string, error) {
+	index := strings.Index(line, "#")
+	for index >= 0 {
+		comment := strings.TrimSpace(line[index+1:])
+		if codes, ok := strings.CutPrefix(comment, DisableDirective); ok {
+			var disabled []string
+			for _, code := range strings.Split(codes, ",") {
+				code = strings.TrimSpace(code)
+				if !warningCodePattern.MatchString(code) {
+					return "", nil, fmt.Errorf("invalid warning code in %s: %q", DisableDirective, code)
+				}
+				disabled = append(disabled, code)
+			}
+			return strings.TrimSpace(line[:index]), disabled, nil
+		}
+		next := strings.Index(line[index+1:], "#")
+		if next < 0 {
+			break
+		}
+		index += next + 1
+	}
+	return line, nil, nil
+}
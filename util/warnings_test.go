@@ -0,0 +1,51 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseDisableDirective(t *testing.T) {
+	tests := []struct {
+		line     string
+		expected string
+		codes    []string
+		wantErr  bool
+	}{
+		{"LAYER ./x", "LAYER ./x", nil, false},
+		{"LAYER ./x # otter:disable=WARN002", "LAYER ./x", []string{"WARN002"}, false},
+		{"# otter:disable=WARN001, WARN003", "", []string{"WARN001", "WARN003"}, false},
+		{"# just a comment", "# just a comment", nil, false},
+		{"LAYER ./x # otter:disable=WARN1", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		line, codes, err := ParseDisableDirective(tt.line)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseDisableDirective(%q) error = %v, wantErr %v", tt.line, err, tt.wantErr)
+			continue
+		}
+		if tt.wantErr {
+			continue
+		}
+		if line != tt.expected || !reflect.DeepEqual(codes, tt.codes) {
+			t.Errorf("ParseDisableDirective(%q) = %q, %v; expected %q, %v", tt.line, line, codes, tt.expected, tt.codes)
+		}
+	}
+}
+
+func TestWarningsSuppression(t *testing.T) {
+	warnings := NewWarnings([]string{WarnSlowHook})
+	warnings.Warn(WarnSlowHook, nil, "disabled in config")
+	warnings.Warn(WarnLayerOverride, []string{WarnLayerOverride}, "disabled inline")
+	warnings.Warn(WarnLayerOverride, nil, "layer %s overwrote a file", "b")
+	warnings.Add(Warning{Code: WarnUnknownVariable, Message: "from parsing"})
+
+	expected := []Warning{
+		{Code: WarnLayerOverride, Message: "layer b overwrote a file"},
+		{Code: WarnUnknownVariable, Message: "from parsing"},
+	}
+	if !reflect.DeepEqual(warnings.Reported, expected) {
+		t.Errorf("Expected %v, got %v", expected, warnings.Reported)
+	}
+}